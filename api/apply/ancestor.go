@@ -0,0 +1,63 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+ancestor.go implements the reconstruction of the tree that patches were
+made against, from the oids of the blobs that their index lines record,
+which three-way merges of patches that don't apply are based on.
+*/
+package apply
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// ================================================================= //
+// FAKE ANCESTORS
+// ================================================================= //
+
+// FakeAncestor returns an index of the files that patches change, as
+// they were before the patches, with their blobs found by the oids
+// that the patches record. The files that the patches create are left
+// out, and the ones whose modes only change are taken from the given
+// index.
+func FakeAncestor(repo *api.DiskRepository, patches []*Patch, current *api.Index) (*api.Index, error) {
+	idx := new(api.Index)
+	for _, p := range patches {
+		if p.IsNew() {
+			continue
+		}
+		name := p.OldName
+		if name == "" {
+			name = p.NewName
+		}
+		var oid *objects.ObjectId
+		if p.Added == 0 && p.Deleted == 0 && !p.IsBinary {
+			// a change of mode keeps the current blob
+			entry := current.Entry(name, 0)
+			if entry == nil {
+				return nil, fmt.Errorf("mode change for %s, which is not in current HEAD", name)
+			}
+			oid = entry.ObjectId()
+		} else {
+			o, err := repo.ObjectFromShortOid(p.OldOid)
+			if _, ok := o.(*objects.Blob); err != nil || !ok {
+				return nil, fmt.Errorf("sha1 information is lacking or useless (%s).", name)
+			}
+			oid = o.ObjectId()
+		}
+		mode := p.OldMode
+		if mode != objects.ModeBlobExec && mode != objects.ModeLink {
+			mode = objects.ModeBlob
+		}
+		idx.Add(api.NewIndexEntry(name, 0, oid, mode, nil))
+	}
+	return idx, nil
+}
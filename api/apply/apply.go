@@ -0,0 +1,725 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+apply.go implements the application of patches, as git-apply does. All the
+patches of an input are checked against their target first, which computes
+the new contents of their files, and the files are only written if every
+patch applies.
+*/
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ================================================================= //
+// OPTIONS
+// ================================================================= //
+
+// AllContext is the Context of an application that never reduces
+// the context of fragments.
+const AllContext = -1
+
+// WhitespaceAction is what happens to the whitespace errors of the
+// lines that patches add.
+type WhitespaceAction int
+
+const (
+	WhitespaceWarn     WhitespaceAction = iota // the first few errors are shown
+	WhitespaceNoWarn                           // errors are not looked for
+	WhitespaceError                            // patches with errors are not applied
+	WhitespaceErrorAll                         // and all errors are shown
+	WhitespaceFix                              // errors are fixed
+)
+
+// ParseWhitespaceAction parses the value of --whitespace or of
+// apply.whitespace.
+func ParseWhitespaceAction(s string) (WhitespaceAction, error) {
+	switch s {
+	case "warn":
+		return WhitespaceWarn, nil
+	case "nowarn":
+		return WhitespaceNoWarn, nil
+	case "error":
+		return WhitespaceError, nil
+	case "error-all":
+		return WhitespaceErrorAll, nil
+	case "fix", "strip":
+		return WhitespaceFix, nil
+	}
+	return WhitespaceWarn, fmt.Errorf("unrecognized whitespace option '%s'", s)
+}
+
+// dies returns true if whitespace errors stop patches from being
+// applied.
+func (action WhitespaceAction) dies() bool {
+	return action == WhitespaceError || action == WhitespaceErrorAll
+}
+
+// Options are the options of the application of patches.
+type Options struct {
+	// Strip is the number of leading directories that are
+	// removed from the names of patches; if it is negative, it
+	// is guessed for traditional patches, as Parse does.
+	Strip int
+
+	// Context is the least number of context lines that a
+	// fragment is reduced to when it doesn't apply, or
+	// AllContext.
+	Context int
+
+	Reverse          bool // the patches are applied in reverse
+	UnidiffZero      bool // fragments without context may apply anywhere
+	IgnoreWhitespace bool // lines that differ in whitespace match
+	Whitespace       WhitespaceAction
+	Rule             diff.WhitespaceRule
+
+	// ThreeWay merges a patch into its file when it doesn't
+	// apply, using the blob that it names as the base.
+	ThreeWay bool
+
+	// Prefix is the directory that the patches are applied in,
+	// with a trailing slash. The names of traditional patches are
+	// relative to it, and patches of files outside of it are
+	// skipped.
+	Prefix string
+
+	Check      bool // the patches are checked, but not applied
+	Verbose    bool // the progress is reported
+	AllowEmpty bool // inputs without patches are not an error
+}
+
+// ================================================================= //
+// ERRORS
+// ================================================================= //
+
+var (
+	// ErrFailed is returned when patches don't apply. The reasons
+	// were written to the log.
+	ErrFailed = errors.New("patches do not apply")
+
+	// ErrConflicts is returned when patches were merged with
+	// conflicts, which are staged in the index.
+	ErrConflicts = errors.New("patches applied with conflicts")
+
+	// errReported is returned by the steps of the application whose
+	// errors were written to the log.
+	errReported = errors.New("error was reported")
+
+	// errNoThreeway is returned when a patch can't be merged.
+	errNoThreeway = errors.New("no three-way merge")
+)
+
+// modeType masks the bits of modes that tell the type of files.
+const modeType = 0170000
+
+// the states of the names of the table of patched files, apart
+// from the patches that produced their contents
+var (
+	toBeDeleted = new(Patch)
+	wasDeleted  = new(Patch)
+)
+
+// ================================================================= //
+// APPLYING
+// ================================================================= //
+
+// Applier applies patches to a target.
+type Applier struct {
+	repo   *api.DiskRepository
+	target Target
+	opts   Options
+	log    io.Writer
+
+	input    string // the name of the input, for messages
+	linenr   int    // the number of the next line of the inputs
+	wsErrors int    // the whitespace errors of all the inputs
+	fixed    int    // the lines whose whitespace was fixed
+	apply    bool   // the patches are written, not only checked
+
+	// the patches that produced the files, by name
+	table map[string]*Patch
+}
+
+// NewApplier returns an applier of patches. Messages, warnings and
+// the reasons why patches don't apply are written to the log.
+func NewApplier(repo *api.DiskRepository, target Target, opts Options, log io.Writer) *Applier {
+	return &Applier{
+		repo:   repo,
+		target: target,
+		opts:   opts,
+		log:    log,
+		linenr: 1,
+	}
+}
+
+// Apply applies the patches of an input, which is named in the
+// messages about whitespace. It returns ErrFailed if they don't
+// apply, and ErrConflicts if a three-way merge left conflicts.
+func (a *Applier) Apply(input string, data []byte) error {
+	a.input = input
+	patches, linenr, err := parse(data, a.opts.Strip, a.linenr)
+	a.linenr = linenr
+	if err != nil {
+		return err
+	}
+	var list []*Patch
+	skipped := 0
+	for _, p := range patches {
+		if a.opts.Prefix != "" && !p.toplevel {
+			if p.OldName != "" {
+				p.OldName = a.opts.Prefix + p.OldName
+			}
+			if p.NewName != "" {
+				p.NewName = a.opts.Prefix + p.NewName
+			}
+		}
+		if !a.uses(p) {
+			if a.opts.Verbose {
+				fmt.Fprintf(a.log, "Skipped patch '%s'.\n", p.Name())
+			}
+			skipped++
+			continue
+		}
+		if a.opts.Reverse {
+			p.reverse()
+			list = append([]*Patch{p}, list...)
+		} else {
+			list = append(list, p)
+		}
+		a.checkWhitespace(p)
+	}
+	if len(list) == 0 && skipped == 0 && !a.opts.AllowEmpty {
+		return errors.New(`No valid patches in input (allow with "--allow-empty")`)
+	}
+
+	a.apply = !a.opts.Check
+	if a.wsErrors > 0 && a.opts.Whitespace.dies() {
+		a.apply = false
+	}
+	if !a.opts.Check && !a.apply {
+		return nil
+	}
+	if a.checkPatches(list) {
+		return ErrFailed
+	}
+	if !a.apply {
+		return nil
+	}
+	return a.write(list)
+}
+
+// Finish reports the whitespace errors of all the inputs, and
+// returns an error if they stopped the patches from being applied.
+func (a *Applier) Finish() error {
+	if a.wsErrors == 0 {
+		return nil
+	}
+	if squelch := a.squelch(); squelch > 0 && a.wsErrors > squelch {
+		n := a.wsErrors - squelch
+		fmt.Fprintf(a.log, "warning: squelched %d whitespace %s\n", n, plural(n, "error", "errors"))
+	}
+	lines := fmt.Sprintf("%d %s", a.wsErrors, plural(a.wsErrors, "line adds", "lines add"))
+	switch {
+	case a.opts.Whitespace.dies():
+		return fmt.Errorf("%s whitespace errors.", lines)
+	case a.fixed > 0 && a.apply:
+		fmt.Fprintf(a.log, "warning: %d %s applied after fixing whitespace errors.\n", a.fixed, plural(a.fixed, "line", "lines"))
+	default:
+		fmt.Fprintf(a.log, "warning: %s whitespace errors.\n", lines)
+	}
+	return nil
+}
+
+// uses returns true if a patch is applied, which it is unless its
+// file is outside of the directory of the prefix.
+func (a *Applier) uses(p *Patch) bool {
+	name := p.NewName
+	if name == "" {
+		name = p.OldName
+	}
+	prefix := a.opts.Prefix
+	return prefix == "" || (strings.HasPrefix(name, prefix) && len(name) > len(prefix))
+}
+
+// ================================================================= //
+// WHITESPACE
+// ================================================================= //
+
+// checkWhitespace looks for whitespace errors in the lines that a
+// patch adds, and in its context lines when they are fixed.
+func (a *Applier) checkWhitespace(p *Patch) {
+	for _, frag := range p.Fragments {
+		for i, line := range frag.Lines {
+			switch {
+			case line.Op == '+' && a.opts.Whitespace != WhitespaceNoWarn:
+			case line.Op == ' ' && a.opts.Whitespace == WhitespaceFix && !a.opts.Reverse:
+			default:
+				continue
+			}
+			errs := diff.CheckWhitespace(line.Text, a.opts.Rule)
+			a.recordWhitespace(errs, strings.TrimSuffix(line.Text, "\n"), frag.linenrs[i])
+		}
+	}
+}
+
+// recordWhitespace counts the whitespace errors of a line of the
+// input, and shows the first few of them.
+func (a *Applier) recordWhitespace(errs diff.WhitespaceRule, line string, linenr int) {
+	if errs == 0 {
+		return
+	}
+	a.wsErrors++
+	if squelch := a.squelch(); squelch > 0 && a.wsErrors > squelch {
+		return
+	}
+	fmt.Fprintf(a.log, "%s:%d: %s.\n%s\n", a.input, linenr, errs.Describe(), line)
+}
+
+// squelch returns the number of whitespace errors that are shown,
+// or 0 if all of them are.
+func (a *Applier) squelch() int {
+	if a.opts.Whitespace == WhitespaceErrorAll {
+		return 0
+	}
+	return 5
+}
+
+// ================================================================= //
+// CHECKING
+// ================================================================= //
+
+// checkPatches checks that the patches apply, one after the other,
+// and computes the new contents of their files. It returns true if
+// any of them doesn't apply.
+func (a *Applier) checkPatches(list []*Patch) bool {
+	a.table = make(map[string]*Patch)
+	for _, p := range list {
+		if p.NewName == "" || p.IsRename {
+			a.table[p.OldName] = toBeDeleted
+		}
+	}
+	failed := false
+	for _, p := range list {
+		if a.opts.Verbose {
+			fmt.Fprintf(a.log, "Checking patch %s...\n", p.Name())
+		}
+		if err := a.checkPatch(p); err != nil {
+			a.report(err)
+			failed = true
+		}
+	}
+	return failed
+}
+
+// checkPatch checks that a patch applies, as git's check_patch
+// does.
+func (a *Applier) checkPatch(p *Patch) error {
+	p.rejected = true
+	data, err := a.checkPreimage(p)
+	if err != nil {
+		return err
+	}
+
+	okIfExists := false
+	if prev := a.table[p.NewName]; p.NewName != "" && (prev == toBeDeleted || prev == wasDeleted) {
+		okIfExists = true
+	}
+	if p.NewName != "" && (p.isNew > 0 || p.IsRename || p.IsCopy) {
+		if err := a.target.CheckNew(p.NewName, okIfExists); err != nil {
+			if !a.opts.ThreeWay {
+				return err
+			}
+			p.threeway = true
+		}
+		if p.NewMode == 0 {
+			if p.isNew > 0 {
+				p.NewMode = objects.ModeBlob
+			} else {
+				p.NewMode = p.OldMode
+			}
+		}
+	}
+	if p.NewName != "" && p.OldName != "" {
+		if p.NewMode == 0 {
+			p.NewMode = p.OldMode
+		}
+		if (p.OldMode^p.NewMode)&modeType != 0 {
+			if p.NewName == p.OldName {
+				return fmt.Errorf("new mode (%o) of %s does not match old mode (%o)", p.NewMode, p.NewName, p.OldMode)
+			}
+			return fmt.Errorf("new mode (%o) of %s does not match old mode (%o) of %s", p.NewMode, p.NewName, p.OldMode, p.OldName)
+		}
+	}
+
+	if err := a.applyData(p, data); err != nil {
+		a.report(err)
+		name := p.OldName
+		if name == "" {
+			name = p.NewName
+		}
+		return fmt.Errorf("%s: patch does not apply", name)
+	}
+	p.rejected = false
+	return nil
+}
+
+// checkPreimage reads the file that a patch changes, either from
+// the target or from the result of an earlier patch, and checks
+// its mode.
+func (a *Applier) checkPreimage(p *Patch) ([]byte, error) {
+	if p.OldName == "" {
+		return nil, nil
+	}
+	prev, gone := a.previous(p)
+	if gone {
+		return nil, fmt.Errorf("path %s has been renamed/deleted", p.OldName)
+	}
+	var data []byte
+	var mode objects.FileMode
+	if prev != nil {
+		data, mode = prev.result, prev.NewMode
+	} else {
+		var err error
+		if data, mode, err = a.target.Read(p.OldName); err != nil {
+			if _, ok := err.(*MissingError); ok && p.isNew < 0 {
+				// a traditional patch that creates its file
+				p.isNew, p.isDelete = 1, 0
+				p.OldName = ""
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+	if p.isNew < 0 {
+		p.isNew = 0
+	}
+	if p.OldMode == 0 {
+		p.OldMode = mode
+	}
+	if (mode^p.OldMode)&modeType != 0 {
+		return nil, errors.New("wrong type")
+	}
+	if mode != p.OldMode {
+		fmt.Fprintf(a.log, "warning: %s has type %o, expected %o\n", p.OldName, mode, p.OldMode)
+	}
+	if p.NewMode == 0 && p.isDelete == 0 {
+		p.NewMode = mode
+	}
+	return data, nil
+}
+
+// previous returns the earlier patch that produced the file that a
+// patch changes, if there is one, and true if an earlier patch
+// deleted or renamed the file.
+func (a *Applier) previous(p *Patch) (*Patch, bool) {
+	if p.IsCopy || p.IsRename {
+		return nil, false
+	}
+	switch prev := a.table[p.OldName]; prev {
+	case nil, toBeDeleted:
+		return nil, false
+	case wasDeleted:
+		return nil, true
+	default:
+		return prev, false
+	}
+}
+
+// addToTable records the result of a patch, for the patches that
+// change its file later.
+func (a *Applier) addToTable(p *Patch) {
+	if p.NewName != "" {
+		a.table[p.NewName] = p
+	}
+	if p.NewName == "" || p.IsRename {
+		a.table[p.OldName] = wasDeleted
+	}
+}
+
+// applyData computes the new contents of the file of a patch.
+func (a *Applier) applyData(p *Patch, data []byte) error {
+	img := newImage(data)
+	merged := false
+	if a.opts.ThreeWay {
+		switch err := a.tryThreeway(img, p, data); err {
+		case nil:
+			merged = true
+		case errNoThreeway:
+		default:
+			a.report(err)
+		}
+	}
+	if !merged {
+		if a.opts.ThreeWay && !p.threeway {
+			fmt.Fprintln(a.log, "Falling back to direct application...")
+		}
+		if p.threeway {
+			return errReported
+		}
+		if err := a.applyFragments(img, p); err != nil {
+			return err
+		}
+	}
+	p.result, p.resultKnown = img.bytes(), true
+	a.addToTable(p)
+	if p.isDelete > 0 && len(p.result) > 0 {
+		return errors.New("removal patch leaves file contents")
+	}
+	return nil
+}
+
+// applyFragments applies the fragments of a patch to an image.
+func (a *Applier) applyFragments(img *image, p *Patch) error {
+	name := p.OldName
+	if name == "" {
+		name = p.NewName
+	}
+	if p.IsBinary {
+		return a.applyBinary(img, p, name)
+	}
+	for i, frag := range p.Fragments {
+		if !a.applyFragment(img, frag, a.opts.Rule, i+1) {
+			return fmt.Errorf("patch failed: %s:%d", name, frag.OldPos)
+		}
+	}
+	return nil
+}
+
+// ================================================================= //
+// BINARY PATCHES
+// ================================================================= //
+
+// applyBinary applies a binary patch, whose index line must have
+// full oids: the preimage must be the old blob, and the result
+// must be the new one.
+func (a *Applier) applyBinary(img *image, p *Patch, name string) error {
+	if len(p.OldOid) != objects.OidHexSize || len(p.NewOid) != objects.OidHexSize {
+		return fmt.Errorf("cannot apply binary patch to '%s' without full index line", name)
+	}
+	data := img.bytes()
+	if p.OldName != "" {
+		if oid := api.BlobOid(data).String(); oid != p.OldOid {
+			return fmt.Errorf("the patch applies to '%s' (%s), which does not match the current contents.", name, oid)
+		}
+	} else if len(data) > 0 {
+		return fmt.Errorf("the patch applies to an empty '%s' but it is not empty", name)
+	}
+	if p.NewOid == strings.Repeat("0", objects.OidHexSize) {
+		img.set(nil)
+		return nil
+	}
+
+	// the new blob may be there already
+	if oid, err := objects.OidFromString(p.NewOid); err == nil && a.repo.HasObject(oid) {
+		blob, err := api.BlobFromOid(a.repo, oid)
+		if err != nil {
+			return fmt.Errorf("the necessary postimage %s for '%s' cannot be read", p.NewOid, name)
+		}
+		img.set(blob.Data())
+		return nil
+	}
+	result, err := applyBinaryHunk(data, p, name)
+	if err != nil {
+		if err != errReported {
+			a.report(err)
+		}
+		return fmt.Errorf("binary patch does not apply to '%s'", name)
+	}
+	if oid := api.BlobOid(result).String(); oid != p.NewOid {
+		return fmt.Errorf("binary patch to '%s' creates incorrect result (expecting %s, got %s)", name, p.NewOid, oid)
+	}
+	img.set(result)
+	return nil
+}
+
+// applyBinaryHunk applies the hunk of a binary patch that goes the
+// right way.
+func applyBinaryHunk(data []byte, p *Patch, name string) ([]byte, error) {
+	if len(p.Binary) == 0 {
+		return nil, fmt.Errorf("missing binary patch data for '%s'", name)
+	}
+	hunk := p.Binary[0]
+	if p.reversed {
+		if len(p.Binary) < 2 {
+			return nil, fmt.Errorf("cannot reverse-apply a binary patch without the reverse hunk to '%s'", name)
+		}
+		hunk = p.Binary[1]
+	}
+	if !hunk.Delta {
+		return hunk.Data, nil
+	}
+	result, err := patchDelta(data, hunk.Data)
+	if err != nil {
+		return nil, errReported
+	}
+	return result, nil
+}
+
+// ================================================================= //
+// THREE-WAY MERGES
+// ================================================================= //
+
+// tryThreeway applies a patch to the blob that it names, and merges
+// the result into the current contents of its file. It returns
+// errNoThreeway if the patch can't be merged.
+func (a *Applier) tryThreeway(img *image, p *Patch, ours []byte) error {
+	if p.isDelete != 0 || p.OldMode == objects.ModeCommit || p.NewMode == objects.ModeCommit ||
+		(p.isNew != 0 && !p.threeway) || (p.IsRename && p.Added == 0 && p.Deleted == 0) {
+		return errNoThreeway
+	}
+
+	var base []byte
+	if p.isNew == 0 {
+		o, err := a.repo.ObjectFromShortOid(p.OldOid)
+		blob, ok := o.(*objects.Blob)
+		if err != nil || !ok {
+			return errors.New("repository lacks the necessary blob to perform 3-way merge.")
+		}
+		base = blob.Data()
+	}
+	if p.threeway {
+		fmt.Fprintln(a.log, "Performing three-way merge...")
+	}
+	theirsImg := newImage(base)
+	if err := a.applyFragments(theirsImg, p); err != nil {
+		a.report(err)
+		return errReported
+	}
+	theirs := theirsImg.bytes()
+
+	if p.isNew != 0 {
+		var err error
+		if ours, _, err = a.target.Read(p.NewName); err != nil {
+			a.report(err)
+			return fmt.Errorf("cannot read the current contents of '%s'", p.NewName)
+		}
+	}
+	var oids [3]*objects.ObjectId
+	for i, data := range [][]byte{base, ours, theirs} {
+		oid, err := a.repo.WriteBlob(data)
+		if err != nil {
+			return err
+		}
+		oids[i] = oid
+	}
+	baseOid, oursOid, theirsOid := oids[0].String(), oids[1].String(), oids[2].String()
+
+	var merged []byte
+	conflicts := 0
+	switch {
+	case baseOid == oursOid:
+		merged = theirs
+	case baseOid == theirsOid, oursOid == theirsOid:
+		merged = ours
+	case diff.IsBinary(base) || diff.IsBinary(ours) || diff.IsBinary(theirs):
+		fmt.Fprintf(a.log, "warning: Cannot merge binary files: %s (ours vs. theirs)\n", p.NewName)
+		merged, conflicts = ours, 1
+	default:
+		merged, conflicts = diff.Merge(base, ours, theirs, diff.MergeOptions{
+			Ours:   "ours",
+			Theirs: "theirs",
+			Base:   "base",
+		})
+	}
+	img.set(merged)
+	if conflicts > 0 {
+		p.conflicted = true
+		p.stages = oids
+		if p.isNew != 0 {
+			p.stages[0] = nil
+		}
+		fmt.Fprintf(a.log, "Applied patch to '%s' with conflicts.\n", p.NewName)
+	} else {
+		fmt.Fprintf(a.log, "Applied patch to '%s' cleanly.\n", p.NewName)
+	}
+	return nil
+}
+
+// ================================================================= //
+// WRITING
+// ================================================================= //
+
+// write writes the results of the patches to the target: deleted
+// and changed files are removed first, and then the new contents
+// are written. The names of files with conflicts are listed.
+func (a *Applier) write(list []*Patch) error {
+	var conflicted []string
+	for phase := 0; phase < 2; phase++ {
+		for _, p := range list {
+			if p.rejected {
+				continue
+			}
+			if err := a.writePatch(p, phase); err != nil {
+				return err
+			}
+			if phase == 1 && p.conflicted {
+				conflicted = append(conflicted, p.NewName)
+			}
+			if phase == 1 && a.opts.Verbose {
+				fmt.Fprintf(a.log, "Applied patch %s cleanly.\n", p.Name())
+			}
+		}
+	}
+	if len(conflicted) == 0 {
+		return nil
+	}
+	sort.Strings(conflicted)
+	for _, name := range conflicted {
+		fmt.Fprintf(a.log, "U %s\n", name)
+	}
+	return ErrConflicts
+}
+
+// writePatch writes what a patch does in one of the phases.
+func (a *Applier) writePatch(p *Patch, phase int) error {
+	switch {
+	case p.isDelete > 0:
+		if phase == 0 {
+			return a.target.Remove(p.OldName)
+		}
+	case p.isNew > 0 || p.IsCopy:
+		if phase == 1 {
+			return a.create(p)
+		}
+	case phase == 0:
+		return a.target.Remove(p.OldName)
+	default:
+		return a.create(p)
+	}
+	return nil
+}
+
+// create writes the new contents of the file of a patch.
+func (a *Applier) create(p *Patch) error {
+	mode := p.NewMode
+	if mode == 0 {
+		mode = objects.ModeBlob
+	}
+	var stages []*objects.ObjectId
+	if p.conflicted {
+		stages = p.stages[:]
+	}
+	return a.target.Write(p.NewName, p.result, mode, stages)
+}
+
+// report writes an error to the log, unless it was written there
+// already.
+func (a *Applier) report(err error) {
+	if err != errReported {
+		fmt.Fprintf(a.log, "error: %s\n", err)
+	}
+}
@@ -0,0 +1,134 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+apply_git_test.go implements git-comparison tests for the application of
+patches to the working tree, to the index and to trees.
+*/
+package apply
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test_applyWorkTree applies a patch of git-diff to the working tree,
+// and checks that git finds the files of the second commit there.
+func Test_applyWorkTree(t *testing.T) {
+	testCase := test.Apply
+	dir, err := testCase.Clone("__apply_work_tree")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	patch := testCase.Info().(*test.InfoApply).Patch
+
+	log := new(bytes.Buffer)
+	a := NewApplier(repo, NewWorkTreeTarget(api.NewWorkTree(repo)), Options{Strip: -1, Context: AllContext}, log)
+	util.AssertNoErrOrDie(t, a.Apply("patch", []byte(patch)))
+	util.AssertNoErr(t, a.Finish())
+	util.AssertEqualString(t, "", log.String())
+	util.AssertNoErr(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"diff", "--cached", "--quiet", "second"},
+	))
+
+	// and back again
+	a = NewApplier(repo, NewWorkTreeTarget(api.NewWorkTree(repo)), Options{Strip: -1, Context: AllContext, Reverse: true}, log)
+	util.AssertNoErrOrDie(t, a.Apply("patch", []byte(patch)))
+	util.AssertNoErr(t, a.Finish())
+	util.AssertNoErr(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"diff", "--cached", "--quiet", "first"},
+	))
+}
+
+// Test_applyIndex applies a patch to the index and the working tree,
+// and to a tree that is held in memory.
+func Test_applyIndex(t *testing.T) {
+	testCase := test.Apply
+	dir, err := testCase.Clone("__apply_index")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	patch := testCase.Info().(*test.InfoApply).Patch
+
+	idx, err := repo.Index()
+	util.AssertNoErrOrDie(t, err)
+	a := NewApplier(repo, NewIndexTarget(repo, idx, api.NewWorkTree(repo)), Options{Strip: -1, Context: AllContext}, new(bytes.Buffer))
+	util.AssertNoErrOrDie(t, a.Apply("patch", []byte(patch)))
+	util.AssertNoErr(t, a.Finish())
+	lock, err := repo.LockIndex()
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, repo.WriteIndex(lock, idx))
+	util.AssertNoErr(t, util.GitExecMany(dir,
+		[]string{"diff", "--cached", "--quiet", "second"},
+		[]string{"diff", "--quiet"},
+	))
+
+	// the tree of the first commit
+	_, err = util.GitExec(dir, "reset", "--hard", "first")
+	util.AssertNoErrOrDie(t, err)
+	tree, err := api.TreeFromRevision(repo, "first")
+	util.AssertNoErrOrDie(t, err)
+	idx, err = api.IndexFromTree(repo, tree)
+	util.AssertNoErrOrDie(t, err)
+	a = NewApplier(repo, NewIndexTarget(repo, idx, nil), Options{Strip: -1, Context: AllContext}, new(bytes.Buffer))
+	util.AssertNoErrOrDie(t, a.Apply("patch", []byte(patch)))
+	util.AssertNoErr(t, a.Finish())
+	lock, err = repo.LockIndex()
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, repo.WriteIndex(lock, idx))
+	_, err = util.GitExec(dir, "diff", "--cached", "--quiet", "second")
+	util.AssertNoErr(t, err)
+
+	// while the working tree is left alone
+	_, err = os.Stat(dir + "/added.txt")
+	util.Assert(t, os.IsNotExist(err), "expected no working tree file")
+}
+
+// Test_applyThreeWay checks the stages of a conflicting three-way
+// application against git's.
+func Test_applyThreeWay(t *testing.T) {
+	testCase := test.Apply
+	dir, err := testCase.Clone("__apply_three_way")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	source := testCase.Info().(*test.InfoApply).Source
+
+	patch, err := util.GitExec(dir, "diff", "first", "second", "--", "main.txt")
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErr(t, util.TestFile(dir, "main.txt", strings.Replace(source, "two", "2", 1)))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"commit", "-a", "-m", "ours"},
+	))
+
+	idx, err := repo.Index()
+	util.AssertNoErrOrDie(t, err)
+	log := new(bytes.Buffer)
+	opts := Options{Strip: -1, Context: AllContext, ThreeWay: true}
+	a := NewApplier(repo, NewIndexTarget(repo, idx, nil), opts, log)
+	util.AssertEqualString(t, ErrConflicts.Error(), a.Apply("patch", []byte(patch)).Error())
+	util.Assert(t, strings.Contains(log.String(), "Applied patch to 'main.txt' with conflicts."))
+	lock, err := repo.LockIndex()
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, repo.WriteIndex(lock, idx))
+	ours := util.GitNow(dir, "ls-files", "-s")
+
+	_, err = util.GitExec(dir, "reset", "--hard")
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, util.TestFile(dir, ".git/three.diff", patch))
+	_, err = util.GitExec(dir, "apply", "--cached", "-3", dir+"/.git/three.diff")
+	util.Assert(t, err != nil, "expected git to find conflicts")
+	util.AssertEqualString(t, util.GitNow(dir, "ls-files", "-s"), ours)
+}
@@ -0,0 +1,249 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+binary.go implements the parsing and application of binary patches, whose
+hunks hold either the new contents of a file or a delta against the old
+ones, deflated and encoded in base85.
+*/
+package apply
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// PARSING
+// ================================================================= //
+
+// the values of the digits of git's base85, plus one
+var de85 [256]byte
+
+func init() {
+	const en85 = "0123456789" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"abcdefghijklmnopqrstuvwxyz" +
+		"!#$%&()*+-;<=>?@^_`{|}~"
+	for i := 0; i < len(en85); i++ {
+		de85[en85[i]] = byte(i + 1)
+	}
+}
+
+// parseBinary parses the hunks of a binary patch, which follow
+// the "GIT binary patch" line, and returns their length. The first
+// hunk turns the old contents into the new ones, and the optional
+// second one does the opposite.
+func (p *parser) parseBinary(off int, patch *Patch) (int, error) {
+	forward, used, err := p.parseBinaryHunk(off)
+	if err != nil {
+		return 0, err
+	}
+	if forward == nil {
+		return 0, fmt.Errorf("unrecognized binary patch at line %d", p.linenr-1)
+	}
+	patch.Binary = []*BinaryHunk{forward}
+	reverse, n, err := p.parseBinaryHunk(off + used)
+	if err != nil {
+		return 0, err
+	}
+	if reverse != nil {
+		patch.Binary = append(patch.Binary, reverse)
+		used += n
+	}
+	patch.IsBinary = true
+	return used, nil
+}
+
+// parseBinaryHunk parses a hunk of a binary patch: a line that
+// names its method, "literal" or "delta", and the size of its data,
+// which is followed by lines of its deflated data and by an empty
+// line. Each line of data starts with the number of bytes that it
+// encodes, 'A' to 'Z' for 1 to 26 and 'a' to 'z' for 27 to 52. It
+// returns nil if there is no hunk.
+func (p *parser) parseBinaryHunk(off int) (*BinaryHunk, int, error) {
+	line := p.line(off)
+	hunk := new(BinaryHunk)
+	var size string
+	switch {
+	case strings.HasPrefix(line, "delta "):
+		hunk.Delta, size = true, line[len("delta "):]
+	case strings.HasPrefix(line, "literal "):
+		size = line[len("literal "):]
+	default:
+		return nil, 0, nil
+	}
+	origLen, _ := strconv.Atoi(strings.TrimSpace(size))
+	used := len(line)
+	p.linenr++
+
+	var data []byte
+	corrupt := func(line string) error {
+		return fmt.Errorf("corrupt binary patch at line %d: %s", p.linenr-1, strings.TrimSuffix(line, "\n"))
+	}
+	for {
+		line = p.line(off + used)
+		used += len(line)
+		p.linenr++
+		if line == "\n" {
+			break
+		}
+		// the shortest line is "A00000\n", and the digits come in
+		// groups of five
+		if len(line) < 7 || (len(line)-2)%5 != 0 {
+			return nil, 0, corrupt(line)
+		}
+		maxLen := (len(line) - 2) / 5 * 4
+		n := 0
+		switch c := line[0]; {
+		case 'A' <= c && c <= 'Z':
+			n = int(c-'A') + 1
+		case 'a' <= c && c <= 'z':
+			n = int(c-'a') + 27
+		default:
+			return nil, 0, corrupt(line)
+		}
+		// the last group is padded by at most three bytes
+		if maxLen < n || n <= maxLen-4 {
+			return nil, 0, corrupt(line)
+		}
+		decoded, err := decode85(line[1:len(line)-1], n)
+		if err != nil {
+			return nil, 0, corrupt(line)
+		}
+		data = append(data, decoded...)
+	}
+	var err error
+	if hunk.Data, err = inflate(data, origLen); err != nil {
+		return nil, 0, corrupt(line)
+	}
+	return hunk, used, nil
+}
+
+// decode85 decodes n bytes of base85 digits.
+func decode85(digits string, n int) ([]byte, error) {
+	out := make([]byte, 0, n+3)
+	for len(out) < n {
+		if len(digits) < 5 {
+			return nil, errors.New("short base85 data")
+		}
+		var acc uint64
+		for i := 0; i < 5; i++ {
+			d := de85[digits[i]]
+			if d == 0 {
+				return nil, fmt.Errorf("invalid base85 digit: %c", digits[i])
+			}
+			acc = acc*85 + uint64(d-1)
+		}
+		if acc > 0xffffffff {
+			return nil, errors.New("invalid base85 sequence")
+		}
+		out = append(out, byte(acc>>24), byte(acc>>16), byte(acc>>8), byte(acc))
+		digits = digits[5:]
+	}
+	return out[:n], nil
+}
+
+// inflate decompresses the data of a hunk, which must have the
+// expected size.
+func inflate(data []byte, size int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != size {
+		return nil, errors.New("inflated size mismatch")
+	}
+	return out, nil
+}
+
+// ================================================================= //
+// DELTAS
+// ================================================================= //
+
+// patchDelta applies a delta of git's packs to the source.
+func patchDelta(src, delta []byte) ([]byte, error) {
+	errCorrupt := errors.New("corrupt delta")
+	readSize := func() (int, bool) {
+		size, shift := 0, uint(0)
+		for len(delta) > 0 {
+			c := delta[0]
+			delta = delta[1:]
+			size |= int(c&0x7f) << shift
+			shift += 7
+			if c&0x80 == 0 {
+				return size, true
+			}
+		}
+		return 0, false
+	}
+	srcSize, ok := readSize()
+	if !ok || srcSize != len(src) {
+		return nil, errCorrupt
+	}
+	dstSize, ok := readSize()
+	if !ok {
+		return nil, errCorrupt
+	}
+	dst := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// copy from the source; the bits of op tell which bytes
+			// of the offset and the size follow
+			off, n := 0, 0
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errCorrupt
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					n |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > len(src) || len(dst)+n > dstSize {
+				return nil, errCorrupt
+			}
+			dst = append(dst, src[off:off+n]...)
+		case op != 0:
+			// insert the bytes that follow
+			n := int(op)
+			if n > len(delta) || len(dst)+n > dstSize {
+				return nil, errCorrupt
+			}
+			dst = append(dst, delta[:n]...)
+			delta = delta[n:]
+		default:
+			return nil, errCorrupt
+		}
+	}
+	if len(dst) != dstSize {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,454 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+image.go implements the application of the fragments of text patches to
+the lines of a file, as git's apply_one_fragment does. A fragment is looked
+for near the place that it names first and then further and further away,
+and if it can't be found, its context is reduced.
+*/
+package apply
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api/diff"
+	"math"
+	"strings"
+)
+
+// ================================================================= //
+// IMAGES
+// ================================================================= //
+
+// the flags of the lines of images
+const (
+	lineCommon  = 1 << iota // a context line of a fragment
+	linePatched             // a line that a fragment put in place
+)
+
+// imageLine is a line of an image, with the hash of its
+// non-whitespace characters.
+type imageLine struct {
+	text string
+	hash uint32
+	flag int
+}
+
+// image is a file that is being patched, or the lines that a
+// fragment expects or leaves in place.
+type image struct {
+	lines []imageLine
+}
+
+// newImage returns the image of the contents of a file.
+func newImage(data []byte) *image {
+	img := new(image)
+	img.set(data)
+	return img
+}
+
+// set replaces the contents of the image.
+func (img *image) set(data []byte) {
+	img.lines = nil
+	for _, line := range diff.SplitLines(data) {
+		img.add(line, 0)
+	}
+}
+
+func (img *image) add(text string, flag int) {
+	img.lines = append(img.lines, imageLine{text, hashLine(text), flag})
+}
+
+// bytes returns the contents of the image.
+func (img *image) bytes() []byte {
+	buf := new(bytes.Buffer)
+	for _, line := range img.lines {
+		buf.WriteString(line.text)
+	}
+	return buf.Bytes()
+}
+
+func (img *image) removeFirst() {
+	img.lines = img.lines[1:]
+}
+
+func (img *image) removeLast() {
+	img.lines = img.lines[:len(img.lines)-1]
+}
+
+// update replaces the lines of the preimage, which start at the
+// given line of the image, with the lines of the postimage. The
+// preimage may reach past the end of the image.
+func (img *image) update(lno int, pre, post *image) {
+	n := len(pre.lines)
+	if rest := len(img.lines) - lno; n > rest {
+		n = rest
+	}
+	lines := make([]imageLine, 0, len(img.lines)-n+len(post.lines))
+	lines = append(lines, img.lines[:lno]...)
+	for _, line := range post.lines {
+		line.flag |= linePatched
+		lines = append(lines, line)
+	}
+	img.lines = append(lines, img.lines[lno+n:]...)
+}
+
+// ================================================================= //
+// APPLYING FRAGMENTS
+// ================================================================= //
+
+// applyFragment applies the nth fragment of a patch to an image,
+// and returns false if it can't be found.
+func (a *Applier) applyFragment(img *image, frag *Fragment, rule diff.WhitespaceRule, nth int) bool {
+	pre, post := new(image), new(image)
+	newBlanks, foundBlanks := 0, 0
+	for i, line := range frag.Lines {
+		addedBlank, blankContext := false, false
+		switch line.Op {
+		case ' ':
+			blankContext = line.Text != "" && rule&diff.WSBlankAtEOF != 0 && isBlank(line.Text)
+			pre.add(line.Text, lineCommon)
+			post.add(line.Text, lineCommon)
+		case '-':
+			pre.add(line.Text, 0)
+		case '+':
+			text := line.Text
+			if a.wsErrors > 0 && a.opts.Whitespace == WhitespaceFix {
+				var fixed bool
+				if text, fixed = diff.FixWhitespace(text, rule); fixed {
+					a.fixed++
+				}
+			}
+			post.add(text, 0)
+			addedBlank = rule&diff.WSBlankAtEOF != 0 && isBlank(line.Text)
+		}
+		// count the blank lines that are added at the end
+		switch {
+		case addedBlank:
+			if newBlanks == 0 {
+				foundBlanks = frag.linenrs[i]
+			}
+			newBlanks++
+		case !blankContext:
+			newBlanks = 0
+		}
+	}
+	searched := string(pre.bytes())
+
+	leading, trailing := frag.Leading, frag.Trailing
+	// a fragment at the start or the end of the file must match
+	// there, unless it has no context to tell
+	matchBeginning := frag.OldPos == 0 || (frag.OldPos == 1 && !a.opts.UnidiffZero)
+	matchEnd := !a.opts.UnidiffZero && trailing == 0
+	context := a.opts.Context
+	if context < 0 {
+		context = math.MaxInt32
+	}
+	pos := 0
+	if frag.NewPos > 0 {
+		pos = frag.NewPos - 1
+	}
+
+	applied := -1
+	for {
+		if applied = a.findPos(img, pre, post, pos, rule, matchBeginning, matchEnd); applied >= 0 {
+			break
+		}
+		if leading <= context && trailing <= context {
+			break
+		}
+		if matchBeginning || matchEnd {
+			matchBeginning, matchEnd = false, false
+			continue
+		}
+		// reduce the context, from the side that has more of it
+		if leading >= trailing {
+			pre.removeFirst()
+			post.removeFirst()
+			pos--
+			leading--
+		}
+		if trailing > leading {
+			pre.removeLast()
+			post.removeLast()
+			trailing--
+		}
+	}
+
+	if applied < 0 {
+		if a.opts.Verbose {
+			fmt.Fprintf(a.log, "error: while searching for:\n%s\n", searched)
+		}
+		return false
+	}
+	if newBlanks > 0 && applied+len(pre.lines) >= len(img.lines) &&
+		rule&diff.WSBlankAtEOF != 0 && a.opts.Whitespace != WhitespaceNoWarn {
+		a.recordWhitespace(diff.WSBlankAtEOF, "+", foundBlanks)
+		if a.opts.Whitespace == WhitespaceFix {
+			for ; newBlanks > 0; newBlanks-- {
+				post.removeLast()
+			}
+		}
+		if a.opts.Whitespace.dies() {
+			a.apply = false
+		}
+	}
+	if a.opts.Verbose && applied != pos {
+		offset := applied - pos
+		if a.opts.Reverse {
+			offset = -offset
+		}
+		fmt.Fprintf(a.log, "Hunk #%d succeeded at %d (offset %d %s).\n", nth, applied+1, offset, plural(offset, "line", "lines"))
+	}
+	if leading != frag.Leading || trailing != frag.Trailing {
+		fmt.Fprintf(a.log, "Context reduced to (%d/%d) to apply fragment at %d\n", leading, trailing, applied+1)
+	}
+	img.update(applied, pre, post)
+	return true
+}
+
+// findPos looks for the preimage in the image, starting at a line
+// and then trying the lines after and before it in turn. It
+// returns the line where it was found, or -1.
+func (a *Applier) findPos(img, pre, post *image, lno int, rule diff.WhitespaceRule, matchBeginning, matchEnd bool) int {
+	n := len(img.lines)
+	switch {
+	case matchBeginning:
+		lno = 0
+	case matchEnd:
+		lno = n - len(pre.lines)
+	}
+	if lno < 0 || lno > n {
+		lno = n
+	}
+	backwards, forwards, current := lno, lno, lno
+	for i := 0; ; i++ {
+		if a.matchFragment(img, pre, post, current, rule, matchBeginning, matchEnd) {
+			return current
+		}
+		for {
+			if backwards == 0 && forwards == n {
+				return -1
+			}
+			if i&1 != 0 {
+				if backwards == 0 {
+					i++
+					continue
+				}
+				backwards--
+				current = backwards
+			} else {
+				if forwards == n {
+					i++
+					continue
+				}
+				forwards++
+				current = forwards
+			}
+			break
+		}
+	}
+}
+
+// matchFragment returns true if the preimage matches the image at
+// a line. When whitespace is ignored or fixed, lines that only
+// differ in whitespace match too, and the preimage and the
+// postimage are changed to take the whitespace of the image, or
+// its fixed whitespace.
+func (a *Applier) matchFragment(img, pre, post *image, lno int, rule diff.WhitespaceRule, matchBeginning, matchEnd bool) bool {
+	limit := len(pre.lines)
+	fix := a.opts.Whitespace == WhitespaceFix
+	switch {
+	case lno+len(pre.lines) <= len(img.lines):
+		if matchEnd && lno+len(pre.lines) != len(img.lines) {
+			return false
+		}
+	case fix && rule&diff.WSBlankAtEOF != 0:
+		// blank lines at the end of the preimage may have been
+		// fixed away from the image
+		limit = len(img.lines) - lno
+	default:
+		return false
+	}
+	if matchBeginning && lno != 0 {
+		return false
+	}
+	for i := 0; i < limit; i++ {
+		line := img.lines[lno+i]
+		if line.flag&linePatched != 0 || line.hash != pre.lines[i].hash {
+			return false
+		}
+	}
+
+	if limit == len(pre.lines) {
+		if a.matchExactly(img, pre, lno, matchEnd) {
+			return true
+		}
+	} else {
+		// the lines that match must not all be blank
+		blank := true
+		for i := 0; i < limit && blank; i++ {
+			blank = isBlank(pre.lines[i].text)
+		}
+		if blank {
+			return false
+		}
+	}
+
+	var fixed []string
+	switch {
+	case a.opts.IgnoreWhitespace:
+		for i := 0; i < limit; i++ {
+			text := img.lines[lno+i].text
+			if !fuzzyMatch(text, pre.lines[i].text) {
+				return false
+			}
+			fixed = append(fixed, text)
+		}
+	case fix:
+		for i := 0; i < limit; i++ {
+			want, _ := diff.FixWhitespace(pre.lines[i].text, rule)
+			have, _ := diff.FixWhitespace(img.lines[lno+i].text, rule)
+			if want != have {
+				return false
+			}
+			fixed = append(fixed, want)
+		}
+	default:
+		return false
+	}
+	// the rest of the preimage, past the end of the image, must
+	// be blank
+	for i := limit; i < len(pre.lines); i++ {
+		text := pre.lines[i].text
+		if !a.opts.IgnoreWhitespace {
+			text, _ = diff.FixWhitespace(text, rule)
+		}
+		if !isBlank(text) {
+			return false
+		}
+		fixed = append(fixed, text)
+	}
+	updatePrePostImages(pre, post, fixed)
+	return true
+}
+
+// matchExactly returns true if the lines of the preimage are the
+// lines of the image. As in git, the last line only has to start
+// the line of the image, unless it has to match at the end.
+func (a *Applier) matchExactly(img, pre *image, lno int, matchEnd bool) bool {
+	last := len(pre.lines) - 1
+	for i, line := range pre.lines {
+		text := img.lines[lno+i].text
+		switch {
+		case i < last || matchEnd:
+			if text != line.text {
+				return false
+			}
+		case !strings.HasPrefix(text, line.text):
+			return false
+		}
+	}
+	return true
+}
+
+// updatePrePostImages gives the lines of the preimage their fixed
+// text, and the context lines of the postimage the text of the
+// context lines of the preimage.
+func updatePrePostImages(pre, post *image, fixed []string) {
+	for i, text := range fixed {
+		pre.lines[i].text, pre.lines[i].hash = text, hashLine(text)
+	}
+	var lines []imageLine
+	ctx := 0
+	for _, line := range post.lines {
+		if line.flag&lineCommon != 0 {
+			for ctx < len(pre.lines) && pre.lines[ctx].flag&lineCommon == 0 {
+				ctx++
+			}
+			if ctx >= len(pre.lines) {
+				// the context was reduced
+				continue
+			}
+			line.text, line.hash = pre.lines[ctx].text, pre.lines[ctx].hash
+			ctx++
+		}
+		lines = append(lines, line)
+	}
+	post.lines = lines
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+// hashLine hashes the characters of a line that are not
+// whitespace, as git's hash_line does.
+func hashLine(s string) uint32 {
+	var h uint32
+	for i := 0; i < len(s); i++ {
+		if !isSpace(s[i]) {
+			h = h*3 + uint32(s[i])
+		}
+	}
+	return h & 0xffffff
+}
+
+// isBlank returns true if a line has nothing but whitespace.
+func isBlank(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isSpace(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// fuzzyMatch returns true if two lines only differ in the amount
+// of whitespace where both have some, and in whitespace at their
+// ends, as git's fuzzy_matchlines does.
+func fuzzyMatch(a, b string) bool {
+	trim := func(s string) string {
+		n := len(s)
+		for n > 0 && isSpace(s[n-1]) {
+			n--
+		}
+		return s[:n]
+	}
+	skip := func(s string) string {
+		for len(s) > 0 && isSpace(s[0]) {
+			s = s[1:]
+		}
+		return s
+	}
+	a, b = trim(a), trim(b)
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case isSpace(a[0]):
+			if !isSpace(b[0]) {
+				return false
+			}
+			a, b = skip(a), skip(b)
+		case a[0] != b[0]:
+			return false
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return len(a) == 0 && len(b) == 0
+}
+
+// plural returns the singular word for 1, and the plural one for
+// other numbers.
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
@@ -0,0 +1,916 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+parse.go implements the parsing of patches, as git-apply does: the patches
+of git diffs, whose headers tell about renames, copies, modes and created
+and deleted files, traditional unified diffs, and binary patches.
+*/
+package apply
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/util"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// PATCHES
+// ================================================================= //
+
+// Patch is the patch of a single file.
+type Patch struct {
+	// the names of the file before and after, the old name being
+	// empty for a created file and the new name for a deleted one
+	OldName, NewName string
+
+	// the modes of the file, which are 0 if the patch doesn't say
+	OldMode, NewMode objects.FileMode
+
+	IsRename, IsCopy bool
+	Score            int // the similarity of a rename or copy
+
+	// the oids of the index line, which may be abbreviated
+	OldOid, NewOid string
+
+	// the changes of a text patch, or the hunks of a binary one:
+	// the forward hunk, and the optional reverse hunk
+	IsBinary  bool
+	Fragments []*Fragment
+	Binary    []*BinaryHunk
+
+	// the number of added and deleted lines
+	Added, Deleted int
+
+	// whether the file is created or deleted: 1 if it is, 0 if
+	// not, and -1 if a traditional patch can't tell
+	isNew, isDelete int
+
+	defName  string // the name in the "diff --git" line
+	toplevel bool   // the names are relative to the top, as in git diffs
+	extLine  int    // the line of the first header that names the kind of patch
+	reversed bool   // the reverse hunk of a binary patch applies
+
+	// what applying the patch made of it
+	result      []byte
+	resultKnown bool
+	rejected    bool
+	threeway    bool // go straight to the three-way merge
+	conflicted  bool
+	stages      [3]*objects.ObjectId
+}
+
+// IsNew returns true if the patch creates its file.
+func (p *Patch) IsNew() bool {
+	return p.isNew > 0
+}
+
+// IsDelete returns true if the patch deletes its file.
+func (p *Patch) IsDelete() bool {
+	return p.isDelete > 0
+}
+
+// Name returns the name that a patch is shown under, which is
+// both names for a rename or a copy.
+func (p *Patch) Name() string {
+	if p.OldName != "" && p.NewName != "" && p.OldName != p.NewName {
+		return util.QuotePath(p.OldName) + " => " + util.QuotePath(p.NewName)
+	}
+	if p.NewName != "" {
+		return util.QuotePath(p.NewName)
+	}
+	return util.QuotePath(p.OldName)
+}
+
+// Fragment is a hunk of a text patch.
+type Fragment struct {
+	OldPos, OldLines int
+	NewPos, NewLines int
+
+	// the number of context lines before the first change and
+	// after the last one
+	Leading, Trailing int
+
+	// the lines, whose text lacks the line feed if the line
+	// is followed by the marker of a missing line feed
+	Lines []diff.Line
+
+	line    int   // the line of the header in the input
+	linenrs []int // the lines of the lines in the input
+}
+
+// BinaryHunk is a hunk of a binary patch, which holds either the
+// new contents or a delta against the old ones.
+type BinaryHunk struct {
+	Delta bool
+	Data  []byte
+}
+
+// the ends of names in headers
+const (
+	termSpace = 1 << iota
+	termTab
+)
+
+// ================================================================= //
+// PARSING
+// ================================================================= //
+
+// parser holds the input of patches, and the number of the line
+// that is being parsed, which it counts the way git does.
+type parser struct {
+	data       string
+	linenr     int
+	strip      int
+	stripKnown bool
+}
+
+// Parse parses the patches of a diff. The given number of leading
+// directories is stripped from the names of the files. If it is
+// negative, it is 1, or 0 if the names of a traditional patch have
+// no directories to strip.
+func Parse(data []byte, strip int) ([]*Patch, error) {
+	patches, _, err := parse(data, strip, 1)
+	return patches, err
+}
+
+// parse parses the patches of a diff whose first line has the
+// given number, and returns the number of the line after it. As
+// in git, lines are numbered across all the inputs.
+func parse(data []byte, strip, linenr int) ([]*Patch, int, error) {
+	p := &parser{data: string(data), linenr: linenr, strip: strip, stripKnown: strip >= 0}
+	if strip < 0 {
+		p.strip = 1
+	}
+	var patches []*Patch
+	for off := 0; off < len(p.data); {
+		patch := new(Patch)
+		n, err := p.parseChunk(off, patch)
+		if err != nil {
+			return nil, p.linenr, err
+		}
+		if n < 0 {
+			break
+		}
+		patches = append(patches, patch)
+		off += n
+	}
+	return patches, p.linenr, nil
+}
+
+// line returns the line at an offset, with its line feed.
+func (p *parser) line(off int) string {
+	if i := strings.IndexByte(p.data[off:], '\n'); i >= 0 {
+		return p.data[off : off+i+1]
+	}
+	return p.data[off:]
+}
+
+// parseChunk parses the patch that follows an offset, as git's
+// parse_chunk does. It returns the length of the patch, along
+// with the garbage before it, or -1 if there are no more.
+func (p *parser) parseChunk(off int, patch *Patch) (int, error) {
+	start, hdrsize, err := p.findHeader(off, patch)
+	if err != nil || start < 0 {
+		return -1, err
+	}
+	hd := start + hdrsize
+	size, err := p.parseSinglePatch(hd, patch)
+	if err != nil {
+		return -1, err
+	}
+	if size == 0 {
+		line := p.line(hd)
+		switch {
+		case line == "GIT binary patch\n":
+			p.linenr++
+			used, err := p.parseBinary(hd+len(line), patch)
+			if err != nil {
+				return -1, err
+			}
+			if used > 0 {
+				size = used + len(line)
+			}
+		case strings.HasSuffix(line, " differ\n"):
+			if strings.HasPrefix(line, "Binary files ") || strings.HasPrefix(line, "Files ") {
+				p.linenr++
+				patch.IsBinary = true
+				size = len(line)
+			}
+		}
+		if !patch.IsBinary && !patch.changesMetadata() {
+			return -1, fmt.Errorf("patch with only garbage at line %d", p.linenr)
+		}
+	}
+	return hd + size - off, nil
+}
+
+// changesMetadata returns true if the patch changes more than
+// the contents of its file.
+func (p *Patch) changesMetadata() bool {
+	return p.IsRename || p.IsCopy || p.isNew > 0 || p.isDelete > 0 ||
+		(p.OldMode != 0 && p.NewMode != 0 && p.OldMode != p.NewMode)
+}
+
+// findHeader looks for the header of the next patch, as git's
+// find_header does, and returns its offset and length, or -1 if
+// there is none. Fragments without headers are errors.
+func (p *parser) findHeader(off int, patch *Patch) (int, int, error) {
+	patch.isNew, patch.isDelete = -1, -1
+	for ; off < len(p.data); p.linenr++ {
+		line := p.line(off)
+		size := len(p.data) - off
+		if len(line) < 6 {
+			off += len(line)
+			continue
+		}
+		if strings.HasPrefix(line, "@@ -") {
+			if _, ok := parseFragmentHeader(line, new(Fragment)); ok {
+				return -1, 0, fmt.Errorf("patch fragment without header at line %d: %s", p.linenr, line[:len(line)-1])
+			}
+			off += len(line)
+			continue
+		}
+		if size < len(line)+6 {
+			break
+		}
+		if strings.HasPrefix(line, "diff --git ") {
+			n, err := p.parseGitHeader(off, patch)
+			if err != nil {
+				return -1, 0, err
+			}
+			if n <= len(line) {
+				off += len(line)
+				continue
+			}
+			return off, n, nil
+		}
+		next := p.line(off + len(line))
+		if !strings.HasPrefix(line, "--- ") || !strings.HasPrefix(next, "+++ ") {
+			off += len(line)
+			continue
+		}
+		// the shortest hunk header is "@@ -0,0 +1 @@\n"
+		if size < len(next)+14 || !strings.HasPrefix(p.data[off+len(line)+len(next):], "@@ -") {
+			off += len(line)
+			continue
+		}
+		if err := p.parseTraditional(line[4:], next[4:], patch); err != nil {
+			return -1, 0, err
+		}
+		p.linenr += 2
+		return off, len(line) + len(next), nil
+	}
+	return -1, 0, nil
+}
+
+// parseTraditional takes the names of a patch from the "---" and
+// "+++" lines of a unified diff. A file is created or deleted if
+// one of them is /dev/null.
+func (p *parser) parseTraditional(first, second string, patch *Patch) error {
+	if !p.stripKnown {
+		a, b := guessStrip(first), guessStrip(second)
+		if a < 0 {
+			a = b
+		}
+		if a >= 0 && a == b {
+			p.strip, p.stripKnown = a, true
+		}
+	}
+	var name string
+	switch {
+	case isDevNull(first):
+		patch.isNew, patch.isDelete = 1, 0
+		name, _ = findName(second, "", p.strip, termTab)
+		patch.NewName = name
+	case isDevNull(second):
+		patch.isNew, patch.isDelete = 0, 1
+		name, _ = findName(first, "", p.strip, termTab)
+		patch.OldName = name
+	default:
+		firstName, _ := findName(first, "", p.strip, termTab)
+		name, _ = findName(second, firstName, p.strip, termTab)
+		patch.OldName, patch.NewName = name, name
+	}
+	if name == "" {
+		return fmt.Errorf("unable to find filename in patch at line %d", p.linenr)
+	}
+	return nil
+}
+
+// guessStrip guesses how many directories to strip from a name of
+// a traditional patch: none if it has no directories, and -1 if it
+// can't tell.
+func guessStrip(line string) int {
+	if isDevNull(line) {
+		return -1
+	}
+	name, ok := findName(line, "", 0, termTab)
+	if !ok {
+		return -1
+	}
+	if !strings.Contains(name, "/") {
+		return 0
+	}
+	return -1
+}
+
+// ================================================================= //
+// GIT HEADERS
+// ================================================================= //
+
+// the header lines of git diffs, and what to make of them
+var gitHeaders = []struct {
+	prefix string
+	parse  func(p *parser, line string, patch *Patch) (bool, error)
+}{
+	{"@@ -", (*parser).headerEnd},
+	{"--- ", (*parser).oldName},
+	{"+++ ", (*parser).newName},
+	{"old mode ", (*parser).oldMode},
+	{"new mode ", (*parser).newMode},
+	{"deleted file mode ", (*parser).deletedFile},
+	{"new file mode ", (*parser).newFile},
+	{"copy from ", (*parser).copySource},
+	{"copy to ", (*parser).copyDest},
+	{"rename old ", (*parser).renameSource},
+	{"rename new ", (*parser).renameDest},
+	{"rename from ", (*parser).renameSource},
+	{"rename to ", (*parser).renameDest},
+	{"similarity index ", (*parser).similarity},
+	{"dissimilarity index ", (*parser).similarity},
+	{"index ", (*parser).index},
+	{"", (*parser).headerEnd},
+}
+
+// parseGitHeader parses the header of a git diff, which starts
+// with the "diff --git" line at an offset, and returns its length.
+// Git diffs tell whether files are created or deleted.
+func (p *parser) parseGitHeader(off int, patch *Patch) (int, error) {
+	patch.isNew, patch.isDelete = 0, 0
+	patch.toplevel = true
+	line := p.line(off)
+	patch.defName = gitHeaderName(p.strip, line)
+	n := len(line)
+	p.linenr++
+	for ; off+n < len(p.data); p.linenr++ {
+		line = p.line(off + n)
+		if !strings.HasSuffix(line, "\n") {
+			break
+		}
+		done := false
+		for _, h := range gitHeaders {
+			if !strings.HasPrefix(line, h.prefix) {
+				continue
+			}
+			end, err := h.parse(p, line[len(h.prefix):], patch)
+			if err != nil {
+				return -1, err
+			}
+			if err = p.checkHeaderLine(patch); err != nil {
+				return -1, err
+			}
+			done = end
+			break
+		}
+		if done {
+			break
+		}
+		n += len(line)
+	}
+	if patch.OldName == "" && patch.NewName == "" {
+		if patch.defName == "" {
+			components := "components"
+			if p.strip == 1 {
+				components = "component"
+			}
+			return -1, fmt.Errorf("git diff header lacks filename information when removing %d leading pathname %s (line %d)", p.strip, components, p.linenr)
+		}
+		patch.OldName, patch.NewName = patch.defName, patch.defName
+	}
+	if (patch.NewName == "" && patch.isDelete == 0) || (patch.OldName == "" && patch.isNew == 0) {
+		return -1, fmt.Errorf("git diff header lacks filename information (line %d)", p.linenr)
+	}
+	return n, nil
+}
+
+// checkHeaderLine makes sure that headers name one kind of patch.
+func (p *parser) checkHeaderLine(patch *Patch) error {
+	kinds := 0
+	for _, b := range []bool{patch.isDelete == 1, patch.isNew == 1, patch.IsRename, patch.IsCopy} {
+		if b {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return fmt.Errorf("inconsistent header lines %d and %d", patch.extLine, p.linenr)
+	}
+	if kinds > 0 && patch.extLine == 0 {
+		patch.extLine = p.linenr
+	}
+	return nil
+}
+
+func (p *parser) headerEnd(line string, patch *Patch) (bool, error) {
+	return true, nil
+}
+
+func (p *parser) oldName(line string, patch *Patch) (bool, error) {
+	return false, p.verifyName(line, patch.isNew > 0, &patch.OldName, "old")
+}
+
+func (p *parser) newName(line string, patch *Patch) (bool, error) {
+	return false, p.verifyName(line, patch.isDelete > 0, &patch.NewName, "new")
+}
+
+// verifyName checks that the name of a "---" or "+++" line agrees
+// with what the rest of the header says.
+func (p *parser) verifyName(line string, isNull bool, name *string, side string) error {
+	switch {
+	case *name == "" && !isNull:
+		*name, _ = findName(line, "", p.strip, termTab)
+	case *name != "":
+		if isNull {
+			return fmt.Errorf("git apply: bad git-diff - expected /dev/null, got %s on line %d", *name, p.linenr)
+		}
+		if another, _ := findName(line, "", p.strip, termTab); another != *name {
+			return fmt.Errorf("git apply: bad git-diff - inconsistent %s filename on line %d", side, p.linenr)
+		}
+	case !isDevNull(line):
+		return fmt.Errorf("git apply: bad git-diff - expected /dev/null on line %d", p.linenr)
+	}
+	return nil
+}
+
+func (p *parser) oldMode(line string, patch *Patch) (bool, error) {
+	return false, p.parseMode(line, &patch.OldMode)
+}
+
+func (p *parser) newMode(line string, patch *Patch) (bool, error) {
+	return false, p.parseMode(line, &patch.NewMode)
+}
+
+func (p *parser) deletedFile(line string, patch *Patch) (bool, error) {
+	patch.isDelete = 1
+	patch.OldName = patch.defName
+	return p.oldMode(line, patch)
+}
+
+func (p *parser) newFile(line string, patch *Patch) (bool, error) {
+	patch.isNew = 1
+	patch.NewName = patch.defName
+	return p.newMode(line, patch)
+}
+
+func (p *parser) copySource(line string, patch *Patch) (bool, error) {
+	patch.IsCopy = true
+	patch.OldName, _ = findName(line, "", max(p.strip-1, 0), 0)
+	return false, nil
+}
+
+func (p *parser) copyDest(line string, patch *Patch) (bool, error) {
+	patch.IsCopy = true
+	patch.NewName, _ = findName(line, "", max(p.strip-1, 0), 0)
+	return false, nil
+}
+
+func (p *parser) renameSource(line string, patch *Patch) (bool, error) {
+	patch.IsRename = true
+	patch.OldName, _ = findName(line, "", max(p.strip-1, 0), 0)
+	return false, nil
+}
+
+func (p *parser) renameDest(line string, patch *Patch) (bool, error) {
+	patch.IsRename = true
+	patch.NewName, _ = findName(line, "", max(p.strip-1, 0), 0)
+	return false, nil
+}
+
+func (p *parser) similarity(line string, patch *Patch) (bool, error) {
+	if n, _ := parseNum(line); n <= 100 {
+		patch.Score = n
+	}
+	return false, nil
+}
+
+// index parses the oids of an index line, and the mode of the
+// file that follows them if its mode didn't change.
+func (p *parser) index(line string, patch *Patch) (bool, error) {
+	dots := strings.IndexByte(line, '.')
+	if dots < 0 || !strings.HasPrefix(line[dots:], "..") || dots > objects.OidHexSize {
+		return false, nil
+	}
+	old := line[:dots]
+	line = line[dots+2:]
+	end := strings.IndexAny(line, " \n")
+	if end < 0 {
+		end = len(line)
+	}
+	if end > objects.OidHexSize {
+		return false, nil
+	}
+	patch.OldOid, patch.NewOid = old, line[:end]
+	if end < len(line) && line[end] == ' ' {
+		return p.oldMode(line[end+1:], patch)
+	}
+	return false, nil
+}
+
+// parseMode parses the octal mode that starts a line.
+func (p *parser) parseMode(line string, mode *objects.FileMode) error {
+	i := 0
+	for i < len(line) && '0' <= line[i] && line[i] <= '7' {
+		i++
+	}
+	n, err := strconv.ParseUint(line[:i], 8, 32)
+	if err != nil || i == len(line) || !isSpace(line[i]) {
+		return fmt.Errorf("invalid mode on line %d: %s", p.linenr, line)
+	}
+	*mode = canonMode(uint32(n))
+	return nil
+}
+
+// canonMode returns the mode that git keeps for a file mode.
+func canonMode(mode uint32) objects.FileMode {
+	switch mode & 0170000 {
+	case 0100000:
+		if mode&0100 != 0 {
+			return objects.ModeBlobExec
+		}
+		return objects.ModeBlob
+	case 0120000:
+		return objects.ModeLink
+	case 0040000:
+		return objects.ModeTree
+	}
+	return objects.ModeCommit
+}
+
+// ================================================================= //
+// NAMES
+// ================================================================= //
+
+// gitHeaderName returns the name in a "diff --git" line, as git's
+// git_header_name does. The line names a file twice, and we only
+// take the name if both agree, which they do unless the file is
+// renamed or copied. Those patches name files elsewhere anyway.
+func gitHeaderName(strip int, line string) string {
+	line = strings.TrimSuffix(line[len("diff --git "):], "\n")
+	if strings.HasPrefix(line, `"`) {
+		first, rest, err := util.UnquotePath(line)
+		if err != nil {
+			return ""
+		}
+		if first, err = skipTreePrefix(strip, first); err != nil {
+			return ""
+		}
+		rest = strings.TrimLeft(rest, " \t\n\r")
+		if rest == "" {
+			return ""
+		}
+		if strings.HasPrefix(rest, `"`) {
+			if rest, _, err = util.UnquotePath(rest); err != nil {
+				return ""
+			}
+		}
+		if second, err := skipTreePrefix(strip, rest); err != nil || second != first {
+			return ""
+		}
+		return first
+	}
+
+	name, err := skipTreePrefix(strip, line)
+	if err != nil {
+		return ""
+	}
+	// with the first name unquoted, a quote starts the second one
+	if i := strings.IndexByte(name, '"'); i >= 0 {
+		second, _, err := util.UnquotePath(name[i:])
+		if err != nil {
+			return ""
+		}
+		if second, err = skipTreePrefix(strip, second); err != nil {
+			return ""
+		}
+		if len(second) < i && strings.HasPrefix(name, second) && isSpace(name[len(second)]) {
+			return second
+		}
+		return ""
+	}
+
+	// take the name only if it shows up twice, in the same form
+	for i := 0; i < len(name); i++ {
+		if name[i] != ' ' && name[i] != '\t' {
+			continue
+		}
+		if i+1 == len(name) {
+			return ""
+		}
+		second, err := skipTreePrefix(strip, name[i+1:])
+		if err != nil {
+			return ""
+		}
+		if second == name[:i] {
+			return second
+		}
+	}
+	return ""
+}
+
+// skipTreePrefix strips leading directories from a name, failing
+// if it has too few of them. A name with none to strip may not be
+// absolute.
+func skipTreePrefix(strip int, name string) (string, error) {
+	if strip == 0 {
+		if strings.HasPrefix(name, "/") {
+			return "", fmt.Errorf("absolute name: %s", name)
+		}
+		return name, nil
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' {
+			if strip--; strip <= 0 {
+				if i == 0 {
+					break
+				}
+				return name[i+1:], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("too few directories: %s", name)
+}
+
+// findName returns the name that starts a header line, with the
+// given number of leading directories stripped, as git's find_name
+// does. The name ends at the end of the line or, depending on
+// terminate, at a space or a tab. If it can't find a name, it
+// returns def. A name that only adds to def, as "file.orig" does
+// to "file", gives def as well.
+func findName(line, def string, strip, terminate int) (string, bool) {
+	if strings.HasPrefix(line, `"`) {
+		if name, _, err := util.UnquotePath(line); err == nil {
+			for ; strip > 0; strip-- {
+				i := strings.IndexByte(name, '/')
+				if i < 0 {
+					break
+				}
+				name = name[i+1:]
+			}
+			if strip == 0 {
+				return squashSlashes(name), true
+			}
+		}
+	}
+
+	start := -1
+	if strip == 0 {
+		start = 0
+	}
+	i := 0
+	for ; i < len(line); i++ {
+		c := line[i]
+		if c == '\n' || (c == ' ' && terminate&termSpace != 0) ||
+			(c == '\t' && terminate&termTab != 0) ||
+			(isSpace(c) && c != ' ' && c != '\t') {
+			break
+		}
+		if c == '/' {
+			if strip--; strip == 0 {
+				start = i + 1
+			}
+		}
+	}
+	if start < 0 || i == start {
+		return squashSlashes(def), def != ""
+	}
+	name := line[start:i]
+	if def != "" && len(def) < len(name) && strings.HasPrefix(name, def) {
+		return squashSlashes(def), true
+	}
+	return squashSlashes(name), true
+}
+
+// squashSlashes turns runs of slashes into single ones.
+func squashSlashes(name string) string {
+	for strings.Contains(name, "//") {
+		name = strings.Replace(name, "//", "/", -1)
+	}
+	return name
+}
+
+// isDevNull returns true if a line names /dev/null.
+func isDevNull(line string) bool {
+	return strings.HasPrefix(line, "/dev/null") && len(line) > 9 && isSpace(line[9])
+}
+
+// ================================================================= //
+// FRAGMENTS
+// ================================================================= //
+
+// parseSinglePatch parses the fragments of a text patch, which
+// start at an offset, and returns their length, which is 0 if
+// there are none.
+func (p *parser) parseSinglePatch(off int, patch *Patch) (int, error) {
+	start := off
+	oldLines, newLines, context := 0, 0, 0
+	for len(p.data)-off > 4 && strings.HasPrefix(p.data[off:], "@@ -") {
+		frag := &Fragment{line: p.linenr}
+		n, ok := p.parseFragment(off, patch, frag)
+		if !ok {
+			return 0, fmt.Errorf("corrupt patch at line %d", p.linenr)
+		}
+		oldLines += frag.OldLines
+		newLines += frag.NewLines
+		context += frag.Leading + frag.Trailing
+		patch.Fragments = append(patch.Fragments, frag)
+		off += n
+	}
+
+	// A patch that removes lines creates no file, and one that adds
+	// them deletes none. Patches with several fragments do neither.
+	// Past that, patches without context can't tell.
+	if patch.isNew < 0 && (oldLines > 0 || len(patch.Fragments) > 1) {
+		patch.isNew = 0
+	}
+	if patch.isDelete < 0 && (newLines > 0 || len(patch.Fragments) > 1) {
+		patch.isDelete = 0
+	}
+	if patch.isNew > 0 && oldLines > 0 {
+		return 0, fmt.Errorf("new file %s depends on old contents", patch.NewName)
+	}
+	if patch.isDelete > 0 && newLines > 0 {
+		return 0, fmt.Errorf("deleted file %s still has contents", patch.OldName)
+	}
+	return off - start, nil
+}
+
+// parseFragmentHeader parses the ranges of a hunk header.
+func parseFragmentHeader(line string, frag *Fragment) (int, bool) {
+	if !strings.HasSuffix(line, "\n") {
+		return 0, false
+	}
+	off, ok := parseRange(line, 4, " +", &frag.OldPos, &frag.OldLines)
+	if !ok {
+		return 0, false
+	}
+	return parseRange(line, off, " @@", &frag.NewPos, &frag.NewLines)
+}
+
+// parseRange parses a range of a hunk header, which starts at an
+// offset and is followed by the expected text.
+func parseRange(line string, off int, expect string, pos, lines *int) (int, bool) {
+	n, digits := parseNum(line[off:])
+	if digits == 0 {
+		return 0, false
+	}
+	*pos, *lines = n, 1
+	off += digits
+	if strings.HasPrefix(line[off:], ",") {
+		if *lines, digits = parseNum(line[off+1:]); digits == 0 {
+			return 0, false
+		}
+		off += digits + 1
+	}
+	if !strings.HasPrefix(line[off:], expect) {
+		return 0, false
+	}
+	return off + len(expect), true
+}
+
+// parseNum parses the decimal number that starts a string, and
+// returns it along with the number of its digits.
+func parseNum(s string) (int, int) {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.Atoi(s[:i])
+	return n, i
+}
+
+// parseFragment parses the hunk that starts at an offset, as git's
+// parse_fragment does, and returns its length.
+func (p *parser) parseFragment(off int, patch *Patch, frag *Fragment) (int, bool) {
+	header := p.line(off)
+	if _, ok := parseFragmentHeader(header, frag); !ok {
+		return 0, false
+	}
+	oldLines, newLines := frag.OldLines, frag.NewLines
+	added, deleted := 0, 0
+	n := len(header)
+	p.linenr++
+	for ; off+n < len(p.data); p.linenr++ {
+		if oldLines == 0 && newLines == 0 {
+			break
+		}
+		line := p.line(off + n)
+		if !strings.HasSuffix(line, "\n") {
+			return 0, false
+		}
+		switch line[0] {
+		case '\n', ' ':
+			// newer GNU diffs leave out the space of empty context lines
+			oldLines--
+			newLines--
+			if deleted == 0 && added == 0 {
+				frag.Leading++
+			}
+			frag.Trailing++
+			frag.addLine(' ', strings.TrimPrefix(line, " "), p.linenr)
+		case '-':
+			deleted++
+			oldLines--
+			frag.Trailing = 0
+			frag.addLine('-', line[1:], p.linenr)
+		case '+':
+			added++
+			newLines--
+			frag.Trailing = 0
+			frag.addLine('+', line[1:], p.linenr)
+		case '\\':
+			// whatever the language of the marker, it is at least this long
+			if len(line) < 12 || !strings.HasPrefix(line, "\\ ") {
+				return 0, false
+			}
+			frag.dropNewline()
+		default:
+			return 0, false
+		}
+		n += len(line)
+	}
+	if oldLines != 0 || newLines != 0 || (deleted == 0 && added == 0) {
+		return 0, false
+	}
+
+	// the marker after the last line was not reached above
+	if line := p.line(off + n); len(p.data)-off-n > 12 && strings.HasPrefix(line, "\\ ") {
+		frag.dropNewline()
+		n += len(line)
+	}
+	patch.Added += added
+	patch.Deleted += deleted
+	return n, true
+}
+
+// addLine adds a line to a fragment.
+func (frag *Fragment) addLine(op byte, text string, linenr int) {
+	frag.Lines = append(frag.Lines, diff.Line{Op: op, Text: text})
+	frag.linenrs = append(frag.linenrs, linenr)
+}
+
+// dropNewline removes the line feed of the last line of a
+// fragment, which is followed by the marker of a missing one.
+func (frag *Fragment) dropNewline() {
+	if n := len(frag.Lines); n > 0 {
+		frag.Lines[n-1].Text = strings.TrimSuffix(frag.Lines[n-1].Text, "\n")
+	}
+}
+
+// ================================================================= //
+// REVERSING
+// ================================================================= //
+
+// reverse turns a patch around, so that it undoes what it did.
+func (p *Patch) reverse() {
+	p.OldName, p.NewName = p.NewName, p.OldName
+	p.OldMode, p.NewMode = p.NewMode, p.OldMode
+	p.isNew, p.isDelete = p.isDelete, p.isNew
+	p.Added, p.Deleted = p.Deleted, p.Added
+	p.OldOid, p.NewOid = p.NewOid, p.OldOid
+	for _, frag := range p.Fragments {
+		frag.OldPos, frag.NewPos = frag.NewPos, frag.OldPos
+		frag.OldLines, frag.NewLines = frag.NewLines, frag.OldLines
+		for i := range frag.Lines {
+			switch frag.Lines[i].Op {
+			case '-':
+				frag.Lines[i].Op = '+'
+			case '+':
+				frag.Lines[i].Op = '-'
+			}
+		}
+	}
+	p.reversed = !p.reversed
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,134 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+parse_test.go implements tests for the parsing of patches and for the
+application of their fragments.
+*/
+package apply
+
+import (
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"testing"
+)
+
+const testPatch = `a leading line of garbage
+diff --git a/old.txt "b/new\tname.txt"
+similarity index 80%
+rename from old.txt
+rename to "new\tname.txt"
+index 0123456..89abcde 100644
+--- a/old.txt
++++ "b/new\tname.txt"
+@@ -1,3 +1,3 @@ func
+ one
+-two
++TWO
+ three
+diff --git a/run.sh b/run.sh
+old mode 100644
+new mode 100755
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index 1234567..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+\ No newline at end of file
+--- a/dir/plain.txt	2012-01-01 00:00:00
++++ b/dir/plain.txt	2012-01-02 00:00:00
+@@ -0,0 +1 @@
++new
+`
+
+func Test_Parse(t *testing.T) {
+	patches, err := Parse([]byte(testPatch), -1)
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualInt(t, 4, len(patches))
+
+	p := patches[0]
+	util.AssertEqualString(t, "old.txt", p.OldName)
+	util.AssertEqualString(t, "new\tname.txt", p.NewName)
+	util.Assert(t, p.IsRename && !p.IsNew() && !p.IsDelete())
+	util.AssertEqualInt(t, 80, p.Score)
+	util.AssertEqualString(t, "0123456", p.OldOid)
+	util.Assert(t, p.OldMode == objects.ModeBlob && p.NewMode == 0)
+	util.AssertEqualString(t, `old.txt => "new\tname.txt"`, p.Name())
+	util.AssertEqualInt(t, 1, len(p.Fragments))
+	frag := p.Fragments[0]
+	util.AssertEqualInt(t, 4, len(frag.Lines))
+	util.AssertEqualInt(t, 1, frag.Leading)
+	util.AssertEqualInt(t, 1, frag.Trailing)
+	util.AssertEqualInt(t, 1, p.Added)
+	util.AssertEqualInt(t, 1, p.Deleted)
+
+	p = patches[1]
+	util.Assert(t, p.OldMode == objects.ModeBlob && p.NewMode == objects.ModeBlobExec)
+	util.AssertEqualInt(t, 0, len(p.Fragments))
+
+	p = patches[2]
+	util.Assert(t, p.IsDelete())
+	util.AssertEqualString(t, "", p.NewName)
+	util.AssertEqualString(t, "bye", p.Fragments[0].Lines[0].Text)
+
+	// traditional patches can't tell if they create their files
+	p = patches[3]
+	util.AssertEqualString(t, "dir/plain.txt", p.OldName)
+	util.AssertEqualInt(t, -1, p.isNew)
+
+	_, err = Parse([]byte("@@ -1 +1 @@\n-a\n+b\n"), -1)
+	util.AssertEqualString(t, "patch fragment without header at line 1: @@ -1 +1 @@", err.Error())
+	_, err = Parse([]byte("--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n 1\n-2\n"), -1)
+	util.AssertEqualString(t, "corrupt patch at line 6", err.Error())
+}
+
+func Test_applyFragment(t *testing.T) {
+	const patch = `--- a/f
++++ b/f
+@@ -3,3 +3,3 @@
+ 3
+-4
++four
+ 5
+`
+	file := "1\n2\n3\n4\n5\n6\n"
+	apply := func(opts Options, data string) (string, bool) {
+		patches, err := Parse([]byte(patch), -1)
+		util.AssertNoErrOrDie(t, err)
+		a := NewApplier(nil, nil, opts, ioutil.Discard)
+		img := newImage([]byte(data))
+		ok := a.applyFragment(img, patches[0].Fragments[0], 0, 1)
+		return string(img.bytes()), ok
+	}
+
+	out, ok := apply(Options{Context: AllContext}, file)
+	util.Assert(t, ok)
+	util.AssertEqualString(t, "1\n2\n3\nfour\n5\n6\n", out)
+
+	// the fragment is found away from where it says
+	out, ok = apply(Options{Context: AllContext}, "0\n0\n"+file)
+	util.Assert(t, ok)
+	util.AssertEqualString(t, "0\n0\n1\n2\n3\nfour\n5\n6\n", out)
+
+	// changed context only matches when it is reduced
+	_, ok = apply(Options{Context: AllContext}, "1\n2\nthree\n4\n5\n6\n")
+	util.Assert(t, !ok, "expected the fragment not to apply")
+	out, ok = apply(Options{Context: 0}, "1\n2\nthree\n4\n5\n6\n")
+	util.Assert(t, ok)
+	util.AssertEqualString(t, "1\n2\nthree\nfour\n5\n6\n", out)
+
+	// whitespace differences are ignored only when asked
+	_, ok = apply(Options{Context: AllContext}, "1\n2\n3 \n4\n5\n6\n")
+	util.Assert(t, !ok, "expected the fragment not to apply")
+	out, ok = apply(Options{Context: AllContext, IgnoreWhitespace: true}, "1\n2\n3 \n4\n5\n6\n")
+	util.Assert(t, ok)
+	util.AssertEqualString(t, "1\n2\n3 \nfour\n5\n6\n", out)
+}
@@ -0,0 +1,222 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+target.go implements the places that patches are applied to: the working
+tree, the index, with or without the working tree, and trees, which are
+read into an index that is held in memory.
+*/
+package apply
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"os"
+)
+
+// ================================================================= //
+// TARGETS
+// ================================================================= //
+
+// Target is where patches are applied to.
+type Target interface {
+	// Read returns the contents and the mode of a file, or a
+	// *MissingError if there is no such file.
+	Read(name string) ([]byte, objects.FileMode, error)
+
+	// CheckNew returns an error if a file cannot be created,
+	// which is usually because it exists, unless okIfExists is
+	// true because an earlier patch deletes it.
+	CheckNew(name string, okIfExists bool) error
+
+	// Remove removes a file.
+	Remove(name string) error
+
+	// Write creates or replaces a file. If stages is not nil, the
+	// file has conflicts, and stages holds the oids of its base,
+	// of our side and of their side, any of which may be nil.
+	Write(name string, data []byte, mode objects.FileMode, stages []*objects.ObjectId) error
+}
+
+// MissingError is the error of a file that a patch needs but
+// which does not exist.
+type MissingError struct {
+	Name  string
+	Where string // "index", or empty for the working tree
+}
+
+func (e *MissingError) Error() string {
+	if e.Where == "" {
+		return fmt.Sprintf("%s: No such file or directory", e.Name)
+	}
+	return fmt.Sprintf("%s: does not exist in %s", e.Name, e.Where)
+}
+
+// ExistsError is the error of a file that a patch creates but
+// which already exists.
+type ExistsError struct {
+	Name  string
+	Where string // "index" or "working directory"
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("%s: already exists in %s", e.Name, e.Where)
+}
+
+// ================================================================= //
+// WORKING TREE
+// ================================================================= //
+
+type workTreeTarget struct {
+	wt *api.WorkTree
+}
+
+// NewWorkTreeTarget returns a target that applies patches to the
+// files of a working tree, leaving the index alone.
+func NewWorkTreeTarget(wt *api.WorkTree) Target {
+	return &workTreeTarget{wt}
+}
+
+func (t *workTreeTarget) Read(name string) ([]byte, objects.FileMode, error) {
+	data, mode, err := t.wt.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, objects.ModeNew, &MissingError{Name: name}
+	}
+	return data, mode, err
+}
+
+func (t *workTreeTarget) CheckNew(name string, okIfExists bool) error {
+	return checkWorkTree(t.wt, name, okIfExists)
+}
+
+func (t *workTreeTarget) Remove(name string) error {
+	return t.wt.RemoveFile(name)
+}
+
+func (t *workTreeTarget) Write(name string, data []byte, mode objects.FileMode, stages []*objects.ObjectId) error {
+	return t.wt.WriteFile(name, data, mode)
+}
+
+// checkWorkTree returns an error if a file exists in the working
+// tree. Directories are not in the way, as they are not in git's.
+func checkWorkTree(wt *api.WorkTree, name string, okIfExists bool) error {
+	info, err := wt.Lstat(name)
+	if err != nil || okIfExists || info.IsDir() {
+		return nil
+	}
+	return &ExistsError{Name: name, Where: "working directory"}
+}
+
+// ================================================================= //
+// INDEX
+// ================================================================= //
+
+type indexTarget struct {
+	repo *api.DiskRepository
+	idx  *api.Index
+	wt   *api.WorkTree
+}
+
+// NewIndexTarget returns a target that applies patches to the
+// entries of an index. If wt is not nil, the files of the working
+// tree are updated as well, and they must match the index. The
+// index is only changed in memory; writing it is up to the caller.
+// Patches are applied to a tree by reading it into an index with
+// api.IndexFromTree.
+func NewIndexTarget(repo *api.DiskRepository, idx *api.Index, wt *api.WorkTree) Target {
+	return &indexTarget{repo, idx, wt}
+}
+
+func (t *indexTarget) Read(name string) ([]byte, objects.FileMode, error) {
+	entry := t.idx.Entry(name, 0)
+	if entry == nil {
+		return nil, objects.ModeNew, &MissingError{Name: name, Where: "index"}
+	}
+	if t.wt != nil {
+		status, err := t.wt.Status(entry)
+		if err != nil {
+			return nil, objects.ModeNew, err
+		}
+		switch status {
+		case api.WorkDeleted:
+			// the file is checked out again, as git does
+			if err = t.checkout(entry); err != nil {
+				return nil, objects.ModeNew, err
+			}
+		case api.WorkModified:
+			return nil, objects.ModeNew, fmt.Errorf("%s: does not match index", name)
+		}
+	}
+	if entry.Mode() == objects.ModeCommit {
+		return []byte(fmt.Sprintf("Subproject commit %s\n", entry.ObjectId())), entry.Mode(), nil
+	}
+	blob, err := api.BlobFromOid(t.repo, entry.ObjectId())
+	if err != nil {
+		return nil, objects.ModeNew, err
+	}
+	return blob.Data(), entry.Mode(), nil
+}
+
+// checkout writes the file of an index entry to the working tree.
+func (t *indexTarget) checkout(entry *api.IndexEntry) error {
+	blob, err := api.BlobFromOid(t.repo, entry.ObjectId())
+	if err != nil {
+		return err
+	}
+	return t.wt.WriteFile(entry.Name(), blob.Data(), entry.Mode())
+}
+
+func (t *indexTarget) CheckNew(name string, okIfExists bool) error {
+	if t.idx.Entry(name, 0) != nil && !okIfExists {
+		return &ExistsError{Name: name, Where: "index"}
+	}
+	if t.wt == nil {
+		return nil
+	}
+	return checkWorkTree(t.wt, name, okIfExists)
+}
+
+func (t *indexTarget) Remove(name string) error {
+	t.idx.Remove(name)
+	if t.wt == nil {
+		return nil
+	}
+	return t.wt.RemoveFile(name)
+}
+
+func (t *indexTarget) Write(name string, data []byte, mode objects.FileMode, stages []*objects.ObjectId) error {
+	if t.wt != nil {
+		if err := t.wt.WriteFile(name, data, mode); err != nil {
+			return err
+		}
+	}
+	if stages != nil {
+		t.idx.Remove(name)
+		for i, oid := range stages {
+			if oid != nil {
+				t.idx.Add(api.NewIndexEntry(name, i+1, oid, mode, nil))
+			}
+		}
+		return nil
+	}
+	oid, err := t.repo.WriteBlob(data)
+	if err != nil {
+		return err
+	}
+	var stat *api.StatInfo
+	if t.wt != nil {
+		info, err := t.wt.Lstat(name)
+		if err != nil {
+			return err
+		}
+		stat = api.NewStatInfo(info)
+	}
+	t.idx.Add(api.NewIndexEntry(name, 0, oid, mode, stat))
+	return nil
+}
@@ -0,0 +1,286 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+attributes.go implements gitattributes, which assign attributes such as
+"diff" or "text" to paths in the working tree.
+*/
+package api
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+const (
+	// AttributesFile is the name of the per-directory
+	// attributes file.
+	AttributesFile = ".gitattributes"
+
+	// InfoAttributesFile is the repository-wide attributes
+	// file, relative to the git directory.
+	InfoAttributesFile = "info/attributes"
+)
+
+// the macros that git defines for us
+var builtinMacros = map[string][]string{
+	"binary": []string{"-diff", "-merge", "-text"},
+}
+
+// ================================================================= //
+// ATTRIBUTE VALUES
+// ================================================================= //
+
+type attrState int
+
+const (
+	attrUnspecified attrState = iota
+	attrSet
+	attrUnset
+	attrValue
+)
+
+// AttrValue is the value of an attribute for some path, which
+// may be set, unset, unspecified or set to a string value.
+type AttrValue struct {
+	state attrState
+	value string
+}
+
+// IsSet returns true if the attribute is set, either by
+// itself or to some value.
+func (v AttrValue) IsSet() bool {
+	return v.state == attrSet || v.state == attrValue
+}
+
+// IsUnset returns true if the attribute is explicitly unset,
+// as in "-diff".
+func (v AttrValue) IsUnset() bool {
+	return v.state == attrUnset
+}
+
+// IsUnspecified returns true if no pattern says anything
+// about the attribute.
+func (v AttrValue) IsUnspecified() bool {
+	return v.state == attrUnspecified
+}
+
+// Value returns the string value of the attribute, which is
+// empty unless the attribute was set to a value.
+func (v AttrValue) Value() string {
+	return v.value
+}
+
+// String returns the value as git-check-attr shows it.
+func (v AttrValue) String() string {
+	switch v.state {
+	case attrSet:
+		return "set"
+	case attrUnset:
+		return "unset"
+	case attrValue:
+		return v.value
+	}
+	return "unspecified"
+}
+
+// ParseAttrValue parses an attribute assignment such as
+// "text", "-text", "!text" or "eol=lf" into a name and
+// a value.
+func ParseAttrValue(s string) (name string, v AttrValue) {
+	switch {
+	case strings.HasPrefix(s, "-"):
+		return s[1:], AttrValue{attrUnset, ""}
+	case strings.HasPrefix(s, "!"):
+		return s[1:], AttrValue{attrUnspecified, ""}
+	}
+	if i := strings.Index(s, "="); i >= 0 {
+		return s[:i], AttrValue{attrValue, s[i+1:]}
+	}
+	return s, AttrValue{attrSet, ""}
+}
+
+// ================================================================= //
+// ATTRIBUTE RULES
+// ================================================================= //
+
+type attrAssignment struct {
+	name  string
+	value AttrValue
+}
+
+// attrRule is a single line of an attributes file.
+type attrRule struct {
+	pattern *ignorePattern
+	assigns []attrAssignment
+}
+
+// attrList is an ordered list of rules, in which the
+// last matching rule that mentions an attribute wins.
+type attrList []*attrRule
+
+func (l attrList) lookup(pth, name string) (AttrValue, bool) {
+	for i := len(l) - 1; i >= 0; i-- {
+		r := l[i]
+		if !r.pattern.match(pth, false) {
+			continue
+		}
+		for j := len(r.assigns) - 1; j >= 0; j-- {
+			if r.assigns[j].name == name {
+				return r.assigns[j].value, true
+			}
+		}
+	}
+	return AttrValue{}, false
+}
+
+// ================================================================= //
+// ATTRIBUTES
+// ================================================================= //
+
+// Attributes looks up the attributes of paths in the working
+// tree. As with ignore rules, the repository's info/attributes
+// file has the highest precedence, followed by the per-directory
+// files from the deepest directory up, and the user's global
+// attributes file.
+type Attributes struct {
+	workDir string
+	macros  map[string][]string
+	info    attrList
+	dirs    map[string]attrList
+	global  attrList
+}
+
+// NewAttributes returns the attributes of the working tree
+// of the given repository.
+func NewAttributes(repo *DiskRepository) (*Attributes, error) {
+	a := &Attributes{
+		workDir: repo.WorkDir(),
+		macros:  make(map[string][]string),
+		dirs:    make(map[string]attrList),
+	}
+	for name, expansion := range builtinMacros {
+		a.macros[name] = expansion
+	}
+	var err error
+	if file := globalAttributesFile(); file != "" {
+		if a.global, err = a.readFile(file, "", true); err != nil {
+			return nil, err
+		}
+	}
+	if a.info, err = a.readFile(path.Join(repo.path, InfoAttributesFile), "", true); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Get returns the value of the named attribute for the path,
+// which is relative to the top of the working tree.
+func (a *Attributes) Get(pth, name string) AttrValue {
+	if v, ok := a.info.lookup(pth, name); ok {
+		return v
+	}
+	dir := path.Dir(pth)
+	for {
+		if dir == "." {
+			dir = ""
+		}
+		if v, ok := a.dirList(dir).lookup(pth, name); ok {
+			return v
+		}
+		if dir == "" {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	v, _ := a.global.lookup(pth, name)
+	return v
+}
+
+// dirList returns the rules defined in the given
+// directory, reading them the first time around.
+func (a *Attributes) dirList(dir string) attrList {
+	list, ok := a.dirs[dir]
+	if !ok {
+		base := dir
+		if base != "" {
+			base += "/"
+		}
+		file := filepath.Join(a.workDir, filepath.FromSlash(dir), AttributesFile)
+		list, _ = a.readFile(file, base, dir == "") // unreadable files are skipped
+		a.dirs[dir] = list
+	}
+	return list
+}
+
+// readFile reads the rules of an attributes file. Macros may
+// only be defined by top-level files.
+func (a *Attributes) readFile(file, base string, macros bool) (attrList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var list attrList
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "[attr]") {
+			if macros {
+				a.macros[fields[0][len("[attr]"):]] = fields[1:]
+			}
+			continue
+		}
+		p := parseIgnorePattern(fields[0], base)
+		if p == nil || p.negate {
+			continue // negative patterns are forbidden
+		}
+		list = append(list, &attrRule{p, a.expand(fields[1:], 0)})
+	}
+	return list, scanner.Err()
+}
+
+// expand turns a list of assignments into attrAssignments,
+// inlining the definitions of macros that are set.
+func (a *Attributes) expand(fields []string, depth int) []attrAssignment {
+	var result []attrAssignment
+	for _, field := range fields {
+		name, v := ParseAttrValue(field)
+		result = append(result, attrAssignment{name, v})
+		if expansion, ok := a.macros[name]; ok && v.IsSet() && depth < 8 {
+			result = append(result, a.expand(expansion, depth+1)...)
+		}
+	}
+	return result
+}
+
+// globalAttributesFile returns the location of the user's
+// global attributes file, or the empty string if the user
+// doesn't have one.
+func globalAttributesFile() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "attributes")
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "git", "attributes")
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+attributes_git_test.go implements git-comparison tests for gitattributes.
+*/
+package api
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"strings"
+	"testing"
+)
+
+// Test_attributes compares the attributes that we find for a
+// few paths with the output of git-check-attr.
+func Test_attributes(t *testing.T) {
+	dir := test.Attributes.Repo()
+	repo := Open(dir)
+	attrs, err := NewAttributes(repo)
+	util.AssertNoErrOrDie(t, err)
+
+	paths := []string{"a.txt", "sub/a.txt", "sub/x.txt", "img.png", "sub/img.png", "a.c", "top.c", "sub/top.c", "important.txt", "none"}
+	names := []string{"text", "diff", "merge", "eol", "foo", "mine", "binary"}
+	for _, pth := range paths {
+		for _, name := range names {
+			expected := util.GitNow(dir, "check-attr", name, "--", pth)
+			actual := fmt.Sprintf("%s: %s: %s", pth, name, attrs.Get(pth, name))
+			util.AssertEqualString(t, strings.TrimSpace(expected), actual)
+		}
+	}
+}
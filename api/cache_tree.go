@@ -0,0 +1,225 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+cache_tree.go implements the writing of the index as trees, as
+git-write-tree does. The trees that the directories of the index were
+written as are cached in the index, and stay valid until an entry inside
+of them changes, so that only the directories that changed since need to
+be written again.
+*/
+package api
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"strings"
+)
+
+// ================================================================= //
+// CACHED TREES
+// ================================================================= //
+
+// CachedTree returns the cached trees of the index, or nil if the
+// index has none.
+func (inx *Index) CachedTree() *CachedTreeIndexExtention {
+	for _, ext := range inx.extentions {
+		if tree, ok := (*ext).(*CachedTreeIndexExtention); ok {
+			return tree
+		}
+	}
+	return nil
+}
+
+// Entries returns the cached trees of the directories of the index,
+// each directory before the ones inside of it.
+func (ext *CachedTreeIndexExtention) Entries() []*CachedTreeEntry {
+	return ext.entries
+}
+
+// Tree returns the cached tree of a directory, whose path may have
+// a trailing slash, or nil if the directory has no cached tree. The
+// tree may be one that was written without the entries that are
+// intended to be added, which is invalid but still has an oid.
+func (ext *CachedTreeIndexExtention) Tree(pth string) *CachedTreeEntry {
+	var names []string
+	for _, name := range strings.Split(pth, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	pth = strings.Join(names, "/")
+	for _, e := range ext.entries {
+		if e.Path == pth && e.Oid != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// invalidate invalidates the cached trees of the directories that
+// contain the entry with the given name, which changed.
+func (inx *Index) invalidate(name string) {
+	tree := inx.CachedTree()
+	if tree == nil {
+		return
+	}
+	for _, e := range tree.entries {
+		if e.Path == "" || strings.HasPrefix(name, e.Path+"/") {
+			e.Count, e.Oid = -1, nil
+		}
+	}
+}
+
+// ================================================================= //
+// WRITING TREES
+// ================================================================= //
+
+// IndexTreeError is the error of writing an index whose entries
+// can't be written as trees, because they are unmerged or their
+// objects are missing.
+type IndexTreeError struct {
+	Unmerged []*IndexEntry
+	Missing  []*IndexEntry
+}
+
+func (e *IndexTreeError) Error() string {
+	if len(e.Unmerged) > 0 {
+		return fmt.Sprintf("%s: unmerged", e.Unmerged[0].Name())
+	}
+	return fmt.Sprintf("invalid object %s for '%s'", e.Missing[0].ObjectId(), e.Missing[0].Name())
+}
+
+// WriteTree stores the trees of the index and returns the oid of
+// the tree of the top of the working tree. Directories whose cached
+// trees are valid are not written again, and the cached trees of
+// the others are updated, so the index should be written afterwards
+// to keep them. The objects of the entries must exist, unless
+// missingOk is true. Entries that are intended to be added are left
+// out, and leave the trees that contain them invalid.
+func (inx *Index) WriteTree(repo *DiskRepository, missingOk bool) (*objects.ObjectId, error) {
+	var unmerged []*IndexEntry
+	for _, e := range inx.entries {
+		if e.Stage() != 0 {
+			unmerged = append(unmerged, e)
+		}
+	}
+	if len(unmerged) > 0 {
+		return nil, &IndexTreeError{Unmerged: unmerged}
+	}
+
+	old := inx.CachedTree()
+	if old == nil {
+		old = new(CachedTreeIndexExtention)
+		var ext IndexExtention = old
+		inx.extentions = append(inx.extentions, &ext)
+	}
+	w := &treeWriter{repo, old.entries, nil, missingOk}
+	oid, _, err := w.write("", inx.entries)
+	if err != nil {
+		return nil, err
+	}
+	old.entries = w.cached
+	return oid, nil
+}
+
+// treeWriter writes the trees of the directories of an index.
+type treeWriter struct {
+	repo      *DiskRepository
+	old       []*CachedTreeEntry // the cached trees before writing
+	cached    []*CachedTreeEntry // the cached trees after writing
+	missingOk bool
+}
+
+// write writes the tree of a directory, given the index entries
+// inside of it, and caches it. It returns the oid of the tree, and
+// whether its cached tree is valid, which it isn't if the directory
+// has entries that are intended to be added.
+func (w *treeWriter) write(dir string, entries []*IndexEntry) (*objects.ObjectId, bool, error) {
+	cached := &CachedTreeEntry{Path: dir, Count: len(entries)}
+	w.cached = append(w.cached, cached)
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	var own []*objects.TreeEntry
+	for i := 0; i < len(entries); {
+		e := entries[i]
+		name := e.name[len(prefix):]
+		slash := strings.IndexByte(name, '/')
+		if slash < 0 {
+			i++
+			switch {
+			case e.ExtendedFlags().IntentToAdd():
+				cached.Count = -1
+				continue
+			case !w.missingOk && e.Mode() != objects.ModeCommit && !w.repo.HasObject(e.eid):
+				return nil, false, &IndexTreeError{Missing: []*IndexEntry{e}}
+			}
+			otype := objects.ObjectBlob
+			if e.Mode() == objects.ModeCommit {
+				otype = objects.ObjectCommit
+			}
+			own = append(own, objects.NewTreeEntry(e.Mode(), otype, name, e.eid))
+			continue
+		}
+
+		// the entries of a subdirectory are next to each other
+		sub := prefix + name[:slash]
+		j := i + 1
+		for j < len(entries) && strings.HasPrefix(entries[j].name, sub+"/") {
+			j++
+		}
+		oid, valid := w.reuse(sub, j-i)
+		if oid == nil {
+			var err error
+			if oid, valid, err = w.write(sub, entries[i:j]); err != nil {
+				return nil, false, err
+			}
+		}
+		if !valid {
+			cached.Count = -1
+		}
+		cached.SubtreeCount++
+		own = append(own, objects.NewTreeEntry(objects.ModeTree, objects.ObjectTree, name[:slash], oid))
+		i = j
+	}
+
+	t, err := WriteTree(w.repo, own)
+	if err != nil {
+		return nil, false, err
+	}
+	cached.Oid = t.ObjectId()
+	return cached.Oid, cached.Count >= 0, nil
+}
+
+// reuse caches the cached tree of a directory again, along with the
+// ones inside of it, if it is still valid, and returns its oid. It
+// returns nil if it isn't valid.
+func (w *treeWriter) reuse(dir string, count int) (*objects.ObjectId, bool) {
+	for i, e := range w.old {
+		if e.Path != dir {
+			continue
+		}
+		if e.Count != count || !w.repo.HasObject(e.Oid) {
+			return nil, false
+		}
+		// the trees inside of a valid tree are valid as well, and
+		// follow it
+		w.cached = append(w.cached, e)
+		for _, sub := range w.old[i+1:] {
+			if !strings.HasPrefix(sub.Path, dir+"/") {
+				break
+			}
+			w.cached = append(w.cached, sub)
+		}
+		return e.Oid, true
+	}
+	return nil, false
+}
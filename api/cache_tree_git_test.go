@@ -0,0 +1,130 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+cache_tree_git_test.go implements git-comparison tests for the writing of
+the index as trees, and for the cached trees that git-write-tree leaves in
+the index.
+*/
+package api
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test_readCachedTree checks that the cached trees of the index
+// are those of git-write-tree, and that the index is written back
+// as git wrote it.
+func Test_readCachedTree(t *testing.T) {
+	dir := test.CacheTree.Repo()
+	repo := Open(dir)
+
+	idx, err := repo.Index()
+	util.AssertNoErrOrDie(t, err)
+	tree := idx.CachedTree()
+	util.Assert(t, tree != nil, "expected cached trees")
+	var paths []string
+	for _, e := range tree.Entries() {
+		paths = append(paths, e.Path)
+		expected := util.GitNow(dir, "write-tree", "--prefix="+e.Path)
+		util.AssertEqualString(t, strings.TrimSpace(expected), e.Oid.String())
+	}
+	util.AssertEqualString(t, " a a/b c", strings.Join(paths, " "))
+	util.AssertEqualInt(t, 2, tree.Tree("a/").Count)
+	util.Assert(t, tree.Tree("nope") == nil)
+
+	data, err := ioutil.ReadFile(path.Join(dir, ".git", IndexFile))
+	util.AssertNoErrOrDie(t, err)
+	buf := new(bytes.Buffer)
+	_, err = idx.WriteTo(buf)
+	util.AssertNoErr(t, err)
+	util.Assert(t, bytes.Equal(data, buf.Bytes()), "expected the index to be written as git wrote it")
+}
+
+// Test_writeTree compares the trees that the index is written as,
+// as it changes, with the ones of git-write-tree.
+func Test_writeTree(t *testing.T) {
+	dir, err := test.CacheTree.Clone("__cache_tree_write")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+
+	idx, err := repo.Index()
+	util.AssertNoErrOrDie(t, err)
+	oid, err := idx.WriteTree(repo, false)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, strings.TrimSpace(util.GitNow(dir, "write-tree")), oid.String())
+
+	// adding a file invalidates the trees that contain it
+	blob, err := repo.WriteBlob([]byte("new\n"))
+	util.AssertNoErrOrDie(t, err)
+	idx.Add(NewIndexEntry("a/b/new", 0, blob, objects.ModeBlob, nil))
+	for _, e := range idx.CachedTree().Entries() {
+		util.Assertf(t, (e.Count < 0) == (e.Path != "c"), "%s: unexpected count %d", e.Path, e.Count)
+	}
+	_, err = util.GitExec(dir, "update-index", "--add", "--cacheinfo", "100644,"+blob.String()+",a/b/new")
+	util.AssertNoErrOrDie(t, err)
+	oid, err = idx.WriteTree(repo, false)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, strings.TrimSpace(util.GitNow(dir, "write-tree")), oid.String())
+	util.AssertEqualString(t, strings.TrimSpace(util.GitNow(dir, "write-tree", "--prefix=a/b")), idx.CachedTree().Tree("a/b").Oid.String())
+
+	// missing objects are only written with missingOk
+	missing := objects.OidNow("1111111111111111111111111111111111111111")
+	idx.Add(NewIndexEntry("c/missing", 0, missing, objects.ModeBlob, nil))
+	_, err = idx.WriteTree(repo, false)
+	te, ok := err.(*IndexTreeError)
+	util.Assert(t, ok && len(te.Missing) == 1, "expected a missing object")
+	_, err = idx.WriteTree(repo, true)
+	util.AssertNoErr(t, err)
+	idx.Remove("c/missing")
+
+	// unmerged entries can't be written
+	idx.Add(NewIndexEntry("top", 2, blob, objects.ModeBlob, nil))
+	idx.Add(NewIndexEntry("top", 3, blob, objects.ModeBlob, nil))
+	_, err = idx.WriteTree(repo, false)
+	te, ok = err.(*IndexTreeError)
+	util.Assert(t, ok && len(te.Unmerged) == 2, "expected unmerged entries")
+}
+
+// Test_writeCommit compares the commits that we write with the ones
+// of git-commit-tree, with the identities of the environment.
+func Test_writeCommit(t *testing.T) {
+	dir := test.CacheTree.Repo()
+	repo := Open(dir)
+
+	for _, v := range []string{"GIT_AUTHOR_DATE", "GIT_COMMITTER_DATE"} {
+		defer os.Setenv(v, os.Getenv(v))
+	}
+	os.Setenv("GIT_AUTHOR_DATE", "1300000000 +0130")
+	os.Setenv("GIT_COMMITTER_DATE", "2011-03-13 07:06:40 -0500")
+
+	tree := strings.TrimSpace(util.GitNow(dir, "write-tree"))
+	expected := strings.TrimSpace(util.GitNow(dir, "commit-tree", tree, "-m", "message"))
+
+	config, err := ReadConfig(repo)
+	util.AssertNoErrOrDie(t, err)
+	author, err := Ident(config, RoleAuthor)
+	util.AssertNoErrOrDie(t, err)
+	committer, err := Ident(config, RoleCommitter)
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualInt(t, 90, author.Offset())
+	util.AssertEqualInt(t, -300, committer.Offset())
+
+	c, err := WriteCommit(repo, objects.OidNow(tree), nil, author, committer, "message\n")
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, expected, c.ObjectId().String())
+}
@@ -0,0 +1,625 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+checkout.go implements the checkout of trees into the index and the working
+tree, in the way of the two-way merge of git's unpack-trees.c. Every path of
+the tree that is checked out, of the tree that is left and of the index is
+decided first, and the files are only touched if no local changes would be
+lost. The paths of trees and of the index can also be restored on their own.
+*/
+package checkout
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ================================================================= //
+// OPTIONS
+// ================================================================= //
+
+// Options are the options of a checkout of a tree.
+type Options struct {
+	// Force throws away the local changes to the index and the
+	// working tree, and overwrites untracked files.
+	Force bool
+
+	// Ignorer decides which untracked files are expendable, as
+	// ignored files are. If it is nil, no file is.
+	Ignorer *api.Ignorer
+
+	// Action names the command that checks out the tree in the
+	// explanations of errors, such as "checkout" or "merge".
+	Action string
+}
+
+// ================================================================= //
+// ERRORS
+// ================================================================= //
+
+// Error is returned when a checkout would lose local changes or
+// untracked files, in which case nothing is touched. The lists of
+// paths are sorted.
+type Error struct {
+	Action string
+
+	// Overwritten are the files whose staged changes would be
+	// overwritten, and Modified are the ones whose changes in
+	// the working tree would be.
+	Overwritten []string
+	Modified    []string
+
+	// Dirs are the directories that would be replaced by files,
+	// but which have untracked files in them, and Removed and
+	// Untracked are the untracked files that would be removed
+	// and overwritten.
+	Dirs      []string
+	Removed   []string
+	Untracked []string
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.Messages(), "\n")
+}
+
+// Messages returns the explanations of the error, as git gives
+// them, one for each of its kinds.
+func (e *Error) Messages() []string {
+	var msgs []string
+	add := func(header string, paths []string, advice string) {
+		if len(paths) == 0 {
+			return
+		}
+		msg := header + ":\n"
+		for _, pth := range paths {
+			msg += "\t" + pth + "\n"
+		}
+		msgs = append(msgs, msg+advice)
+	}
+	local, untracked := e.advice()
+	add("Your local changes to the following files would be overwritten by "+e.Action, e.Overwritten, local)
+	add("Your local changes to the following files would be overwritten by "+e.Action, e.Modified, local)
+	add("Updating the following directories would lose untracked files in them", e.Dirs, "")
+	add("The following untracked working tree files would be removed by "+e.Action, e.Removed, untracked)
+	add("The following untracked working tree files would be overwritten by "+e.Action, e.Untracked, untracked)
+	return msgs
+}
+
+// First returns the explanation of the first path of the error,
+// alone, as git gives it when it stops at the first problem, as
+// git-reset does.
+func (e *Error) First() string {
+	var first, msg string
+	add := func(paths []string, format string) {
+		if len(paths) > 0 && (first == "" || paths[0] < first) {
+			first, msg = paths[0], fmt.Sprintf(format, paths[0])
+		}
+	}
+	add(e.Overwritten, "Entry '%s' would be overwritten by merge. Cannot merge.")
+	add(e.Modified, "Entry '%s' not uptodate. Cannot merge.")
+	add(e.Dirs, "Updating '%s' would lose untracked files in it")
+	add(e.Removed, "Untracked working tree file '%s' would be removed by merge.")
+	add(e.Untracked, "Untracked working tree file '%s' would be overwritten by merge.")
+	return msg
+}
+
+// advice returns what to do about local changes and untracked
+// files before the action.
+func (e *Error) advice() (local, untracked string) {
+	before := "you " + e.Action
+	if e.Action == "checkout" {
+		before = "you switch branches"
+	}
+	return "Please commit your changes or stash them before " + before + ".",
+		"Please move or remove them before " + before + "."
+}
+
+func (e *Error) empty() bool {
+	return len(e.Overwritten)+len(e.Modified)+len(e.Dirs)+len(e.Removed)+len(e.Untracked) == 0
+}
+
+// UnmergedError is returned when paths to be checked out have
+// merge conflicts.
+type UnmergedError struct {
+	Paths []string
+}
+
+func (e *UnmergedError) Error() string {
+	return "you need to resolve your current index first"
+}
+
+// ================================================================= //
+// SWITCHING TREES
+// ================================================================= //
+
+// what is done to a path
+type action int
+
+const (
+	keep action = iota
+	write
+	remove
+)
+
+// pathState is what is known about a path: its entries in the
+// tree that is left and in the tree that is checked out, and its
+// entry in the index.
+type pathState struct {
+	from, to *objects.TreeEntry
+	entry    *api.IndexEntry
+	unmerged bool
+}
+
+// SwitchTrees checks out a tree into the index and the working
+// tree, which are supposed to have checked out another tree,
+// from, before; either tree may be nil. Paths that are the same
+// in both trees keep their local changes, and the other paths are
+// only updated if they have none, unless the checkout is forced.
+// If the checkout would lose anything, an *Error is returned, and
+// nothing is touched. The index is updated in memory, so it must
+// be written afterwards, and forgets the conflicts that it had
+// resolved.
+func SwitchTrees(repo *api.DiskRepository, idx *api.Index, from, to *objects.Tree, opts *Options) error {
+	s := newSwitcher(repo, idx, opts)
+	if err := s.collect(repo, from, to); err != nil {
+		return err
+	}
+	var unmerged []string
+	for _, name := range s.names {
+		if s.paths[name].unmerged {
+			unmerged = append(unmerged, name)
+		}
+	}
+	if len(unmerged) > 0 && !opts.Force {
+		return &UnmergedError{unmerged}
+	}
+	return s.switchPaths()
+}
+
+// MergeTree checks out a tree into the index and the working tree,
+// in the way of git-reset --merge. The paths that the index stages
+// as the tree does keep their local changes, and the other paths
+// are only updated if their files have no changes, while the paths
+// with merge conflicts are overwritten. If the checkout would lose
+// anything, an *Error is returned, and nothing is touched. The index
+// is updated in memory, so it must be written afterwards.
+func MergeTree(repo *api.DiskRepository, idx *api.Index, to *objects.Tree, opts *Options) error {
+	s := newSwitcher(repo, idx, opts)
+	if err := s.collect(repo, nil, to); err != nil {
+		return err
+	}
+
+	// the index takes the place of the tree that is left
+	for _, st := range s.paths {
+		if st.entry != nil {
+			st.from = objects.NewTreeEntry(st.entry.Mode(), objects.ObjectBlob, st.entry.Name(), st.entry.ObjectId())
+		}
+	}
+	s.overwriteUnmerged = true
+	return s.switchPaths()
+}
+
+func newSwitcher(repo *api.DiskRepository, idx *api.Index, opts *Options) *switcher {
+	return &switcher{
+		wt:      api.NewWorkTree(repo),
+		idx:     idx,
+		opts:    opts,
+		paths:   make(map[string]*pathState),
+		tracked: make(map[string]bool),
+	}
+}
+
+// switchPaths decides what to do with every path, and does it if
+// nothing would be lost.
+func (s *switcher) switchPaths() error {
+	e := &Error{Action: s.opts.Action}
+	if e.Action == "" {
+		e.Action = "checkout"
+	}
+	actions := make(map[string]action)
+	for _, name := range s.names {
+		a, err := s.decide(name, e)
+		if err != nil {
+			return err
+		}
+		actions[name] = a
+	}
+	if !e.empty() {
+		return e
+	}
+
+	// files are removed first, to make way for the new ones
+	for _, name := range s.names {
+		if actions[name] != remove {
+			continue
+		}
+		s.idx.Remove(name)
+		if err := s.wt.RemoveFile(name); err != nil {
+			return err
+		}
+	}
+	for _, name := range s.names {
+		if actions[name] != write {
+			continue
+		}
+		to := s.paths[name].to
+		entry, err := s.wt.CheckoutEntry(api.NewIndexEntry(name, 0, to.ObjectId(), to.Mode(), nil))
+		if err != nil {
+			return err
+		}
+		s.idx.Add(entry)
+	}
+
+	// the conflicts that were resolved can't be undone anymore
+	s.idx.ClearResolveUndo()
+	return nil
+}
+
+type switcher struct {
+	wt   *api.WorkTree
+	idx  *api.Index
+	opts *Options
+
+	// the paths of the trees and the index, in order, and the
+	// paths that the index has entries for
+	names   []string
+	paths   map[string]*pathState
+	tracked map[string]bool
+
+	// overwriteUnmerged overwrites the paths that have merge
+	// conflicts, as if the checkout were forced
+	overwriteUnmerged bool
+}
+
+// collect gathers the paths of both trees and of the index.
+func (s *switcher) collect(repo *api.DiskRepository, from, to *objects.Tree) error {
+	state := func(name string) *pathState {
+		st, ok := s.paths[name]
+		if !ok {
+			st = new(pathState)
+			s.paths[name] = st
+			s.names = append(s.names, name)
+		}
+		return st
+	}
+	for i, t := range []*objects.Tree{from, to} {
+		if t == nil {
+			continue
+		}
+		err := api.WalkTree(repo, t, "", func(pth string, e *objects.TreeEntry) error {
+			if e.ObjectType() == objects.ObjectTree {
+				return nil
+			}
+			e = objects.NewTreeEntry(e.Mode(), e.ObjectType(), pth, e.ObjectId())
+			if i == 0 {
+				state(pth).from = e
+			} else {
+				state(pth).to = e
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range s.idx.Entries() {
+		st := state(e.Name())
+		s.tracked[e.Name()] = true
+		if e.Stage() == 0 {
+			st.entry = e
+		} else {
+			st.unmerged = true
+		}
+	}
+	sort.Strings(s.names)
+	return nil
+}
+
+// decide decides what to do with a path, and adds it to the
+// error if that would lose changes.
+func (s *switcher) decide(name string, e *Error) (action, error) {
+	st := s.paths[name]
+	if s.opts.Force || (st.unmerged && s.overwriteUnmerged) {
+		switch {
+		case st.to == nil:
+			if st.entry != nil || st.unmerged {
+				return remove, nil
+			}
+			return keep, nil
+		case st.unmerged || !sameEntry(st.to, st.entry):
+			return write, nil
+		}
+		clean, err := s.upToDate(st.entry)
+		if err != nil || clean {
+			return keep, err
+		}
+		return write, nil
+	}
+
+	switch {
+	case sameTreeEntry(st.from, st.to):
+		// the local changes are carried over, whatever they are
+		return keep, nil
+	case st.entry == nil:
+		if st.to == nil {
+			// the file was deleted in the index, and must not be
+			// left untracked in the working tree
+			s.verifyGone(name, e)
+			return keep, nil
+		}
+		if st.from != nil {
+			// the file was deleted in the index
+			e.Overwritten = append(e.Overwritten, name)
+			return keep, nil
+		}
+		if err := s.verifyAbsent(name, e); err != nil {
+			return keep, err
+		}
+		return write, nil
+	case sameEntry(st.to, st.entry):
+		return keep, nil
+	case !sameEntry(st.from, st.entry):
+		e.Overwritten = append(e.Overwritten, name)
+		return keep, nil
+	}
+	clean, err := s.upToDate(st.entry)
+	if err != nil {
+		return keep, err
+	}
+	if !clean {
+		e.Modified = append(e.Modified, name)
+		return keep, nil
+	}
+	if st.to == nil {
+		return remove, nil
+	}
+	return write, nil
+}
+
+// upToDate returns true if the file of an index entry has no
+// changes in the working tree, or is gone.
+func (s *switcher) upToDate(entry *api.IndexEntry) (bool, error) {
+	status, err := s.wt.Status(entry)
+	if err != nil {
+		return false, err
+	}
+	return status != api.WorkModified, nil
+}
+
+// verifyAbsent makes sure that a new file doesn't overwrite an
+// untracked file, or a directory with untracked files in it,
+// unless they are expendable.
+func (s *switcher) verifyAbsent(name string, e *Error) error {
+	// a leading directory may be an untracked file
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		info, err := s.wt.Lstat(dir)
+		if err == nil && !info.IsDir() {
+			if !s.tracked[dir] && !s.expendable(dir, false) {
+				e.Untracked = append(e.Untracked, dir)
+			}
+			return nil
+		}
+	}
+	info, err := s.wt.Lstat(name)
+	switch {
+	case err != nil:
+		return nil
+	case !info.IsDir():
+		if !s.expendable(name, false) {
+			e.Untracked = append(e.Untracked, name)
+		}
+		return nil
+	}
+	lost := false
+	root := s.wt.Path(name)
+	err = filepath.Walk(root, func(pth string, info os.FileInfo, err error) error {
+		if err != nil || lost {
+			return err
+		}
+		rel, err := filepath.Rel(s.wt.Dir(), pth)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if s.expendable(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		lost = !s.tracked[rel] && !s.expendable(rel, false)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if lost {
+		e.Dirs = append(e.Dirs, name)
+	}
+	return nil
+}
+
+// verifyGone makes sure that a file that the checkout removes from
+// the tree isn't left behind in the working tree as an untracked
+// file, unless it is expendable.
+func (s *switcher) verifyGone(name string, e *Error) {
+	info, err := s.wt.Lstat(name)
+	if err == nil && !info.IsDir() && !s.expendable(name, false) {
+		e.Removed = append(e.Removed, name)
+	}
+}
+
+// expendable returns true if an untracked file may be thrown away
+// by the checkout.
+func (s *switcher) expendable(name string, isDir bool) bool {
+	return s.opts.Ignorer != nil && s.opts.Ignorer.Ignored(name, isDir)
+}
+
+// ================================================================= //
+// RESTORING PATHS
+// ================================================================= //
+
+// RestoreOptions are the options of the restoring of paths.
+type RestoreOptions struct {
+	// Source is the tree that the paths are restored from, or
+	// nil to restore them from the index.
+	Source *objects.Tree
+
+	// Staged and WorkTree choose what is restored: the entries
+	// of the index, the files of the working tree, or both.
+	Staged   bool
+	WorkTree bool
+
+	// Overlay keeps the paths that the source doesn't have,
+	// instead of removing them.
+	Overlay bool
+}
+
+// PathError is returned when a path that is to be restored from
+// the index has merge conflicts.
+type PathError struct {
+	Name string
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("path '%s' is unmerged", e.Name)
+}
+
+// RestorePaths restores the paths that match a pathspec to their
+// versions in a tree, or in the index. It returns the number of
+// files of the working tree that were written or removed. The
+// index is updated in memory, so it must be written afterwards.
+func RestorePaths(repo *api.DiskRepository, idx *api.Index, ps *pathspec.Pathspec, opts *RestoreOptions) (int, error) {
+	wt := api.NewWorkTree(repo)
+
+	// the versions in the source, and the paths that the index has
+	// but the source doesn't
+	var sources []*api.IndexEntry
+	inSource := make(map[string]bool)
+	if opts.Source == nil {
+		for _, e := range idx.Entries() {
+			if !ps.MatchIndexEntry(e) {
+				continue
+			}
+			if e.Stage() != 0 {
+				return 0, &PathError{e.Name()}
+			}
+			if !e.ExtendedFlags().IntentToAdd() {
+				sources = append(sources, e)
+				inSource[e.Name()] = true
+			}
+		}
+	} else {
+		err := api.WalkTree(repo, opts.Source, "", func(pth string, e *objects.TreeEntry) error {
+			if e.ObjectType() != objects.ObjectTree && ps.Match(pth, false) {
+				sources = append(sources, api.NewIndexEntry(pth, 0, e.ObjectId(), e.Mode(), nil))
+				inSource[pth] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	var missing []string
+	if !opts.Overlay {
+		for _, e := range idx.Entries() {
+			if ps.MatchIndexEntry(e) && !inSource[e.Name()] && (len(missing) == 0 || missing[len(missing)-1] != e.Name()) {
+				missing = append(missing, e.Name())
+			}
+		}
+	}
+
+	count := 0
+	for _, name := range missing {
+		if opts.Staged {
+			idx.Remove(name)
+		}
+		if opts.WorkTree {
+			if _, err := wt.Lstat(name); err == nil {
+				if err = wt.RemoveFile(name); err != nil {
+					return count, err
+				}
+				count++
+			}
+		}
+	}
+	for _, source := range sources {
+		current := idx.Entry(source.Name(), 0)
+		entry := source
+		if current != nil && sameIndexEntry(current, source) {
+			entry = current
+		}
+		if opts.WorkTree {
+			fresh, err := hasFile(wt, entry)
+			if err != nil {
+				return count, err
+			}
+			if !fresh {
+				if entry, err = wt.CheckoutEntry(source); err != nil {
+					return count, err
+				}
+				count++
+			}
+			if !opts.Staged && (current == nil || !sameIndexEntry(current, source)) {
+				// the index keeps its own version
+				continue
+			}
+		}
+		if opts.Staged || current != entry {
+			idx.Add(entry)
+		}
+	}
+	return count, nil
+}
+
+// hasFile returns true if the working tree has the file of an
+// entry.
+func hasFile(wt *api.WorkTree, entry *api.IndexEntry) (bool, error) {
+	if _, err := wt.Lstat(entry.Name()); err != nil {
+		return false, nil
+	}
+	status, err := wt.Status(entry)
+	return status == api.WorkUnmodified, err
+}
+
+// ================================================================= //
+// UTILITY FUNCTIONS
+// ================================================================= //
+
+// sameTreeEntry returns true if two tree entries, which may be nil,
+// have the same mode and contents.
+func sameTreeEntry(a, b *objects.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode() == b.Mode() && a.ObjectId().String() == b.ObjectId().String()
+}
+
+// sameEntry returns true if a tree entry and an index entry, which
+// may be nil, have the same mode and contents.
+func sameEntry(a *objects.TreeEntry, b *api.IndexEntry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Mode() == b.Mode() && a.ObjectId().String() == b.ObjectId().String()
+}
+
+// sameIndexEntry returns true if two index entries have the same
+// mode and contents.
+func sameIndexEntry(a, b *api.IndexEntry) bool {
+	return a.Mode() == b.Mode() && a.ObjectId().String() == b.ObjectId().String()
+}
@@ -0,0 +1,217 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+checkout_git_test.go implements git-comparison tests for the switching of
+trees and the restoring of paths, which are checked against the index,
+the working tree and the errors of git-checkout, git-reset and git-restore.
+*/
+package checkout
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// changeRepo clones the checkout test case to a repository of the
+// given name, and then changes the working tree and the index with
+// the given git commands, where {"write", name, contents} writes a
+// file instead.
+func changeRepo(t *testing.T, name string, changes [][]string) (string, *api.DiskRepository) {
+	dir, err := test.Checkout.Clone(name)
+	util.AssertNoErrOrDie(t, err)
+	for _, change := range changes {
+		if change[0] == "write" {
+			os.RemoveAll(path.Join(dir, change[1]))
+			util.AssertNoErrOrDie(t, util.TestFile(dir, change[1], change[2]))
+			continue
+		}
+		util.AssertNoErrOrDie(t, util.GitExecMany(dir, change))
+	}
+	return dir, api.Open(dir)
+}
+
+// gitErrors runs a git command, and returns its errors as they are
+// reported by the Error of this package, or the empty string if
+// it succeeds.
+func gitErrors(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stderr = &out
+	if cmd.Run() == nil {
+		return ""
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if line != "Aborting" {
+			lines = append(lines, strings.TrimPrefix(line, "error: "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// state returns the staged files and the status of a repository.
+func state(dir string) string {
+	return util.GitNow(dir, "ls-files", "-s") + util.GitNow(dir, "status", "--porcelain", "-uall")
+}
+
+// tree returns the tree of a revision.
+func tree(t *testing.T, repo *api.DiskRepository, rev string) *objects.Tree {
+	tree, err := api.TreeFromRevision(repo, rev)
+	util.AssertNoErrOrDie(t, err)
+	return tree
+}
+
+var switchCases = []struct {
+	name    string
+	changes [][]string
+}{
+	{"clean", nil},
+	{"carried", [][]string{{"write", "a", "x\n"}, {"write", "n", "n\n"}, {"add", "n"}}},
+	{"modified", [][]string{{"write", "b", "x\n"}}},
+	{"staged", [][]string{{"write", "b", "x\n"}, {"add", "b"}, {"write", "b", "b2\n"}}},
+	{"same", [][]string{{"write", "z", "z\n"}, {"add", "z"}}},
+	{"untracked", [][]string{{"write", "z", "x\n"}}},
+	{"ignored", [][]string{{"write", "z", "x\n"}, {"write", ".git/info/exclude", "z\n"}}},
+	{"directory", [][]string{{"write", "z/y", "y\n"}}},
+	{"leading", [][]string{{"write", "e", "e\n"}}},
+	{"all", [][]string{{"write", "b", "x\n"}, {"write", "z", "x\n"}, {"write", "e", "e\n"}}},
+	{"untracked-only", [][]string{{"rm", "-q", "--cached", "b"}}},
+}
+
+// Test_switchTrees compares the switching of trees with the one of
+// git-checkout.
+func Test_switchTrees(t *testing.T) {
+	gitName, name := "__switch_git", "__switch"
+	defer os.RemoveAll(util.TempRepo(gitName))
+	defer os.RemoveAll(util.TempRepo(name))
+	for _, c := range switchCases {
+		gitDir, _ := changeRepo(t, gitName, c.changes)
+		expected := gitErrors(gitDir, "checkout", "-q", "other")
+
+		dir, repo := changeRepo(t, name, c.changes)
+		idx, err := repo.Index()
+		util.AssertNoErrOrDie(t, err)
+		ig, err := api.NewStandardIgnorer(repo)
+		util.AssertNoErrOrDie(t, err)
+		err = SwitchTrees(repo, idx, tree(t, repo, "master"), tree(t, repo, "other"), &Options{Ignorer: ig})
+		if expected != "" {
+			util.Assertf(t, err != nil, "%s: expected an error", c.name)
+			if err != nil {
+				util.AssertEqualString(t, expected, err.Error())
+			}
+			continue
+		}
+		util.AssertNoErrOrDie(t, err)
+		lock, err := repo.LockIndex()
+		util.AssertNoErrOrDie(t, err)
+		util.AssertNoErrOrDie(t, repo.WriteIndex(lock, idx))
+		_, err = util.GitExec(dir, "symbolic-ref", "HEAD", "refs/heads/other")
+		util.AssertNoErrOrDie(t, err)
+		util.AssertEqualString(t, state(gitDir), state(dir))
+	}
+}
+
+// Test_restorePaths compares the restoring of paths with the one of
+// git-restore.
+func Test_restorePaths(t *testing.T) {
+	gitName, name := "__restore_git", "__restore"
+	defer os.RemoveAll(util.TempRepo(gitName))
+	defer os.RemoveAll(util.TempRepo(name))
+	changes := [][]string{{"write", "a", "x\n"}, {"write", "d/c", "y\n"}, {"rm", "-q", "b"}, {"write", "n", "n\n"}, {"add", "n"}}
+	for _, args := range [][]string{
+		{"."},
+		{"--staged", "."},
+		{"--staged", "--worktree", "."},
+		{"--source", "other", "."},
+		{"--source", "other", "--staged", "--worktree", "--overlay", "."},
+		{"--source", "HEAD~", "--staged", "d", "b"},
+	} {
+		gitDir, _ := changeRepo(t, gitName, changes)
+		_, err := util.GitExec(gitDir, append([]string{"restore"}, args...)...)
+		util.AssertNoErrOrDie(t, err)
+
+		dir, repo := changeRepo(t, name, changes)
+		idx, err := repo.Index()
+		util.AssertNoErrOrDie(t, err)
+		opts := new(RestoreOptions)
+		var paths []string
+		for i := 0; i < len(args); i++ {
+			switch args[i] {
+			case "--source":
+				opts.Source = tree(t, repo, args[i+1])
+				i++
+			case "--staged":
+				opts.Staged = true
+			case "--worktree":
+				opts.WorkTree = true
+			case "--overlay":
+				opts.Overlay = true
+			default:
+				paths = append(paths, args[i])
+			}
+		}
+		if !opts.Staged {
+			opts.WorkTree = true
+		} else if opts.Source == nil {
+			opts.Source = tree(t, repo, "HEAD")
+		}
+		ps, err := pathspec.Parse("", paths, 0)
+		util.AssertNoErrOrDie(t, err)
+		_, err = RestorePaths(repo, idx, ps, opts)
+		util.AssertNoErrOrDie(t, err)
+		lock, err := repo.LockIndex()
+		util.AssertNoErrOrDie(t, err)
+		util.AssertNoErrOrDie(t, repo.WriteIndex(lock, idx))
+		util.AssertEqualString(t, state(gitDir), state(dir))
+	}
+}
+
+// Test_mergeTree compares the checkout of trees over the index with
+// the one of git-reset --merge, which stops at the first problem.
+func Test_mergeTree(t *testing.T) {
+	gitName, name := "__merge_tree_git", "__merge_tree"
+	defer os.RemoveAll(util.TempRepo(gitName))
+	defer os.RemoveAll(util.TempRepo(name))
+	for _, c := range switchCases {
+		gitDir, _ := changeRepo(t, gitName, c.changes)
+		expected := strings.Split(gitErrors(gitDir, "reset", "-q", "--merge", "other"), "\n")[0]
+
+		dir, repo := changeRepo(t, name, c.changes)
+		idx, err := repo.Index()
+		util.AssertNoErrOrDie(t, err)
+		ig, err := api.NewStandardIgnorer(repo)
+		util.AssertNoErrOrDie(t, err)
+		err = MergeTree(repo, idx, tree(t, repo, "other"), &Options{Ignorer: ig})
+		if expected != "" {
+			e, ok := err.(*Error)
+			util.Assertf(t, ok, "%s: expected an error", c.name)
+			if ok {
+				util.AssertEqualString(t, expected, e.First())
+			}
+			continue
+		}
+		util.AssertNoErrOrDie(t, err)
+		lock, err := repo.LockIndex()
+		util.AssertNoErrOrDie(t, err)
+		util.AssertNoErrOrDie(t, repo.WriteIndex(lock, idx))
+		_, err = util.GitExec(dir, "reset", "-q", "--soft", "other")
+		util.AssertNoErrOrDie(t, err)
+		util.AssertEqualString(t, state(gitDir), state(dir))
+	}
+}
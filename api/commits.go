@@ -13,8 +13,10 @@ and useful operations that allow users to resolve and navigate commits.
 package api
 
 import (
+	"container/heap"
 	"errors"
 	"fmt"
+	"github.com/jbrukh/ggit/api/format"
 	"github.com/jbrukh/ggit/api/objects"
 )
 
@@ -55,18 +57,16 @@ func CommitNthAncestor(repo Repository, c *objects.Commit, n int) (rc *objects.C
 
 // CommitFromObject returns the commit being referred to; that is, if
 // the object is a commit object, it is converted and returned. If the
-// object is a tag, then the target of the tag is returned. Other object
+// object is a tag, then it is peeled, and the commit that the tag (or
+// the tag that it tags, and so on) points to is returned. Other object
 // types cause an error to be returned.
 func CommitFromObject(repo Repository, o objects.Object) (*objects.Commit, error) {
-	switch t := o.(type) {
-	case *objects.Commit:
-		return t, nil
-	case *objects.Tag:
-		obj, err := repo.ObjectFromOid(t.Object())
-		if err != nil {
-			return nil, err
-		}
-		return obj.(*objects.Commit), nil
+	peeled, err := PeelObject(repo, o)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := peeled.(*objects.Commit); ok {
+		return c, nil
 	}
 	return nil, errors.New("not a commit or tag")
 }
@@ -94,3 +94,109 @@ func CommitFromRef(repo Repository, spec string) (*objects.Commit, error) {
 	}
 	return CommitFromObject(repo, o)
 }
+
+// ================================================================= //
+// COMMIT WRITING
+// ================================================================= //
+
+// WriteCommit stores a commit of a tree, with the given parents,
+// author, committer and message, and returns it.
+func WriteCommit(repo *DiskRepository, tree *objects.ObjectId, parents []*objects.ObjectId, author, committer *objects.WhoWhen, msg string) (*objects.Commit, error) {
+	f := format.NewStrFormat()
+	if _, err := f.Commit(objects.NewCommit(nil, tree, 0, parents, author, committer, msg)); err != nil {
+		return nil, err
+	}
+	size := int64(len(f.String()))
+	oid, err := repo.WriteObject(objects.NewCommit(nil, tree, size, parents, author, committer, msg))
+	if err != nil {
+		return nil, err
+	}
+	return objects.NewCommit(oid, tree, size, parents, author, committer, msg), nil
+}
+
+// ================================================================= //
+// COMMIT WALKING
+// ================================================================= //
+
+// StopWalk is used as a return value from CommitVisitor to
+// end a walk early. It is not returned as an error by any
+// function.
+var StopWalk = errors.New("stop walking")
+
+// CommitVisitor is the type of the function called for each
+// commit visited by WalkCommits.
+type CommitVisitor func(c *objects.Commit) error
+
+// WalkCommits visits each commit that is reachable from the given
+// commits exactly once, in order of decreasing commit date, as
+// git-rev-list does by default. Commits with the same date are
+// visited in the order in which they were found.
+func WalkCommits(repo Repository, starts []*objects.Commit, f CommitVisitor) error {
+	q := new(commitQueue)
+	seen := make(map[string]bool)
+	push := func(c *objects.Commit) {
+		if id := c.ObjectId().String(); !seen[id] {
+			seen[id] = true
+			heap.Push(q, c)
+		}
+	}
+	for _, c := range starts {
+		push(c)
+	}
+	for q.Len() > 0 {
+		c := heap.Pop(q).(*objects.Commit)
+		if err := f(c); err != nil {
+			if err == StopWalk {
+				return nil
+			}
+			return err
+		}
+		for _, oid := range c.Parents() {
+			if seen[oid.String()] {
+				continue
+			}
+			parent, err := CommitFromOid(repo, oid)
+			if err != nil {
+				return err
+			}
+			push(parent)
+		}
+	}
+	return nil
+}
+
+// commitQueue is a priority queue of commits, which yields
+// the youngest commit first.
+type commitQueue struct {
+	commits []*objects.Commit
+	order   []int
+	n       int
+}
+
+func (q *commitQueue) Len() int { return len(q.commits) }
+
+func (q *commitQueue) Less(i, j int) bool {
+	ti, tj := q.commits[i].Committer().Seconds(), q.commits[j].Committer().Seconds()
+	if ti != tj {
+		return ti > tj
+	}
+	return q.order[i] < q.order[j]
+}
+
+func (q *commitQueue) Swap(i, j int) {
+	q.commits[i], q.commits[j] = q.commits[j], q.commits[i]
+	q.order[i], q.order[j] = q.order[j], q.order[i]
+}
+
+func (q *commitQueue) Push(x interface{}) {
+	q.commits = append(q.commits, x.(*objects.Commit))
+	q.order = append(q.order, q.n)
+	q.n++
+}
+
+func (q *commitQueue) Pop() interface{} {
+	last := len(q.commits) - 1
+	c := q.commits[last]
+	q.commits, q.order = q.commits[:last], q.order[:last]
+	return c
+}
@@ -0,0 +1,610 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+config.go implements the reading and the editing of git's configuration
+files.
+*/
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+const (
+	// ConfigFile is the configuration file of a repository,
+	// relative to the git directory.
+	ConfigFile = "config"
+
+	// SystemConfigFile is the configuration file that applies
+	// to all users.
+	SystemConfigFile = "/etc/gitconfig"
+)
+
+// ================================================================= //
+// CONFIG
+// ================================================================= //
+
+type configEntry struct {
+	key   string
+	value string
+	bare  bool // a key without "=", which is true as a boolean
+}
+
+// Config holds the variables of one or more configuration files.
+// Keys are of the form section.name or section.subsection.name;
+// section and variable names are case-insensitive, and subsection
+// names are not. When a variable is set more than once, the
+// last value wins.
+type Config struct {
+	entries []configEntry
+}
+
+// ReadConfig reads the configuration of a repository: the system
+// file, the user's global files and the file of the repository,
+// in increasing order of precedence. Files that don't exist are
+// skipped.
+func ReadConfig(repo *DiskRepository) (*Config, error) {
+	c := new(Config)
+	var files []string
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		files = append(files, SystemConfigFile)
+	}
+	files = append(files, globalConfigFiles()...)
+	files = append(files, path.Join(repo.path, ConfigFile))
+	for _, file := range files {
+		if err := c.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ReadFile adds the variables of a configuration file, which
+// take precedence over the ones read so far. A file that doesn't
+// exist is not an error.
+func (c *Config) ReadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if err = c.Read(f); err != nil {
+		return fmt.Errorf("bad config file %s: %s", file, err)
+	}
+	return nil
+}
+
+// Read adds the variables read from r, which take precedence
+// over the ones read so far.
+func (c *Config) Read(r io.Reader) error {
+	p := &configParser{r: bufio.NewReader(r), line: 1}
+	entries, err := p.parse()
+	if err != nil {
+		return err
+	}
+	c.entries = append(c.entries, entries...)
+	return nil
+}
+
+// Get returns the last value of a variable, and whether the
+// variable is set at all.
+func (c *Config) Get(key string) (string, bool) {
+	key = canonicalKey(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].key == key {
+			return c.entries[i].value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns all values of a multi-valued variable, in
+// order.
+func (c *Config) GetAll(key string) []string {
+	key = canonicalKey(key)
+	var values []string
+	for _, e := range c.entries {
+		if e.key == key {
+			values = append(values, e.value)
+		}
+	}
+	return values
+}
+
+// String returns the value of a variable, or def if it is
+// not set.
+func (c *Config) String(key, def string) string {
+	if v, ok := c.Get(key); ok {
+		return v
+	}
+	return def
+}
+
+// Bool returns the value of a boolean variable, or def if it
+// is not set.
+func (c *Config) Bool(key string, def bool) (bool, error) {
+	key = canonicalKey(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		e := c.entries[i]
+		if e.key != key {
+			continue
+		}
+		if e.bare {
+			return true, nil
+		}
+		switch strings.ToLower(e.value) {
+		case "true", "yes", "on":
+			return true, nil
+		case "false", "no", "off", "":
+			return false, nil
+		}
+		n, err := parseConfigInt(e.value)
+		if err != nil {
+			return false, fmt.Errorf("bad boolean config value '%s' for '%s'", e.value, key)
+		}
+		return n != 0, nil
+	}
+	return def, nil
+}
+
+// Int returns the value of an integer variable, which may have a
+// suffix of k, m or g, or def if it is not set.
+func (c *Config) Int(key string, def int) (int, error) {
+	v, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	n, err := parseConfigInt(v)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s'", v, canonicalKey(key))
+	}
+	return n, nil
+}
+
+// ================================================================= //
+// PARSING
+// ================================================================= //
+
+// configParser reads a configuration file, in the syntax that is
+// described in git-config(1).
+type configParser struct {
+	r       *bufio.Reader
+	line    int
+	eof     bool
+	section string // the canonical section and subsection, with a trailing dot
+	spans   []configSpan
+}
+
+// configSpan records the lines of a file that a section header or
+// a variable takes up, from first up to but not including end. The
+// key of a header is its section, with a trailing dot.
+type configSpan struct {
+	key        string
+	first, end int
+}
+
+func (p *configParser) errorf(format string, items ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, items...))
+}
+
+func (p *configParser) next() (byte, bool) {
+	c, err := p.r.ReadByte()
+	if err != nil {
+		p.eof = true
+		return 0, false
+	}
+	if c == '\n' {
+		p.line++
+	}
+	return c, true
+}
+
+func (p *configParser) unread(c byte) {
+	p.r.UnreadByte()
+	if c == '\n' {
+		p.line--
+	}
+}
+
+// skipLine skips the rest of a comment line.
+func (p *configParser) skipLine() {
+	for {
+		if c, ok := p.next(); !ok || c == '\n' {
+			return
+		}
+	}
+}
+
+func (p *configParser) parse() (entries []configEntry, err error) {
+	// skip a byte order mark
+	if bom, _ := p.r.Peek(3); string(bom) == "\xef\xbb\xbf" {
+		p.r.Discard(3)
+	}
+	for {
+		c, ok := p.next()
+		switch {
+		case !ok:
+			return entries, nil
+		case c == '\n' || isConfigSpace(c):
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			first := p.line
+			if err = p.parseSection(); err != nil {
+				return nil, err
+			}
+			p.spans = append(p.spans, configSpan{p.section, first, first + 1})
+		case isConfigAlpha(c):
+			if p.section == "" {
+				return nil, p.errorf("variable outside of a section")
+			}
+			p.unread(c)
+			first := p.line
+			entry, err := p.parseVariable()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+			end := p.line
+			if p.eof {
+				end++
+			}
+			p.spans = append(p.spans, configSpan{entry.key, first, end})
+		default:
+			return nil, p.errorf("unexpected character '%c'", c)
+		}
+	}
+}
+
+// parseSection parses a section header, after its "[".
+func (p *configParser) parseSection() error {
+	var name bytes.Buffer
+	for {
+		c, ok := p.next()
+		switch {
+		case !ok || c == '\n':
+			return p.errorf("unterminated section header")
+		case c == ']':
+			if name.Len() == 0 {
+				return p.errorf("empty section name")
+			}
+			// the deprecated [section.subsection] syntax
+			// has a case-insensitive subsection
+			p.section = strings.ToLower(name.String()) + "."
+			return nil
+		case isConfigSpace(c):
+			return p.parseSubsection(strings.ToLower(name.String()))
+		case isConfigAlpha(c) || ('0' <= c && c <= '9') || c == '-' || c == '.':
+			name.WriteByte(c)
+		default:
+			return p.errorf("bad section name")
+		}
+	}
+}
+
+// parseSubsection parses the quoted subsection of a section
+// header, as in [section "subsection"].
+func (p *configParser) parseSubsection(section string) error {
+	c, ok := p.next()
+	for ok && isConfigSpace(c) {
+		c, ok = p.next()
+	}
+	if c != '"' {
+		return p.errorf("bad section header")
+	}
+	var sub bytes.Buffer
+	for {
+		c, ok = p.next()
+		switch {
+		case !ok || c == '\n':
+			return p.errorf("unterminated subsection name")
+		case c == '"':
+			if c, ok = p.next(); c != ']' {
+				return p.errorf("bad section header")
+			}
+			p.section = section + "." + sub.String() + "."
+			return nil
+		case c == '\\':
+			if c, ok = p.next(); !ok || c == '\n' {
+				return p.errorf("unterminated subsection name")
+			}
+		}
+		sub.WriteByte(c)
+	}
+}
+
+// parseVariable parses a "name = value" line, or a line that
+// only has a name.
+func (p *configParser) parseVariable() (configEntry, error) {
+	var name bytes.Buffer
+	c, ok := p.next()
+	for ok && (isConfigAlpha(c) || ('0' <= c && c <= '9') || c == '-') {
+		name.WriteByte(c)
+		c, ok = p.next()
+	}
+	for ok && isConfigSpace(c) {
+		c, ok = p.next()
+	}
+	entry := configEntry{key: p.section + strings.ToLower(name.String())}
+	switch {
+	case !ok || c == '\n':
+		entry.bare = true
+		return entry, nil
+	case c == '#' || c == ';':
+		p.skipLine()
+		entry.bare = true
+		return entry, nil
+	case c != '=':
+		return entry, p.errorf("bad variable name")
+	}
+	value, err := p.parseValue()
+	entry.value = value
+	return entry, err
+}
+
+// parseValue parses a value, up to the end of its line. Spaces
+// outside of quotes are kept between words, but not at the ends,
+// and comments end the value.
+func (p *configParser) parseValue() (string, error) {
+	var value bytes.Buffer
+	quoted, spaces := false, 0
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			if quoted {
+				return "", p.errorf("unterminated quote")
+			}
+			return value.String(), nil
+		}
+		if !quoted && (c == '#' || c == ';') {
+			p.skipLine()
+			return value.String(), nil
+		}
+		if !quoted && isConfigSpace(c) {
+			if value.Len() > 0 {
+				spaces++
+			}
+			continue
+		}
+		for ; spaces > 0; spaces-- {
+			value.WriteByte(' ')
+		}
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			c, ok = p.next()
+			switch {
+			case !ok:
+				return "", p.errorf("bad escape")
+			case c == '\n':
+				// the value continues on the next line
+			case c == 'n':
+				value.WriteByte('\n')
+			case c == 't':
+				value.WriteByte('\t')
+			case c == 'b':
+				value.WriteByte('\b')
+			case c == '\\' || c == '"':
+				value.WriteByte(c)
+			default:
+				return "", p.errorf("bad escape")
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+}
+
+// ================================================================= //
+// EDITING
+// ================================================================= //
+
+// SetConfig sets a variable in the configuration file of a
+// repository. The last value of the variable is replaced, or else
+// the variable is added to the last of its sections, or to a new
+// section at the end of the file.
+func SetConfig(repo *DiskRepository, key, value string) error {
+	last := strings.LastIndex(key, ".")
+	if last <= 0 || last == len(key)-1 {
+		return fmt.Errorf("key does not contain a section: %s", key)
+	}
+	section, name := canonicalKey(key[:last+1]), key[last+1:]
+	line := fmt.Sprintf("\t%s = %s", name, quoteConfigValue(value))
+	return editConfig(repo, func(lines []string, spans []configSpan) []string {
+		for i := len(spans) - 1; i >= 0; i-- {
+			if span := spans[i]; span.key == canonicalKey(key) {
+				return splice(lines, span.first-1, span.end-1, line)
+			}
+		}
+		for i := len(spans) - 1; i >= 0; i-- {
+			if span := spans[i]; isConfigVariable(span.key, section) {
+				return splice(lines, span.end-1, span.end-1, line)
+			}
+		}
+		return append(lines, configHeader(key[:last]), line)
+	})
+}
+
+// RemoveConfigSection removes every occurrence of a section, as in
+// "branch.topic", from the configuration file of a repository,
+// along with its variables.
+func RemoveConfigSection(repo *DiskRepository, section string) error {
+	section = canonicalKey(section + ".")
+	return editConfig(repo, func(lines []string, spans []configSpan) []string {
+		for i := len(spans) - 1; i >= 0; i-- {
+			if span := spans[i]; isConfigVariable(span.key, section) {
+				lines = splice(lines, span.first-1, span.end-1)
+			}
+		}
+		return lines
+	})
+}
+
+// RenameConfigSection renames every occurrence of a section, as in
+// "branch.topic", in the configuration file of a repository.
+func RenameConfigSection(repo *DiskRepository, old, new string) error {
+	old = canonicalKey(old + ".")
+	return editConfig(repo, func(lines []string, spans []configSpan) []string {
+		for _, span := range spans {
+			if span.key == old {
+				lines[span.first-1] = configHeader(new)
+			}
+		}
+		return lines
+	})
+}
+
+// editConfig rewrites the configuration file of a repository with
+// the lines that an edit returns, given the lines of the file and
+// where its sections and variables are. A file that doesn't exist
+// has no lines.
+func editConfig(repo *DiskRepository, edit func(lines []string, spans []configSpan) []string) error {
+	file := path.Join(repo.path, ConfigFile)
+	lock, err := Lock(file)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		lock.Rollback()
+		return err
+	}
+	p := &configParser{r: bufio.NewReader(bytes.NewReader(data)), line: 1}
+	if _, err = p.parse(); err != nil {
+		lock.Rollback()
+		return fmt.Errorf("bad config file %s: %s", file, err)
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	lines = edit(lines, p.spans)
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+	if _, err = lock.Write([]byte(content)); err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// splice replaces the lines from i up to j with others.
+func splice(lines []string, i, j int, others ...string) []string {
+	result := append([]string{}, lines[:i]...)
+	result = append(result, others...)
+	return append(result, lines[j:]...)
+}
+
+// isConfigVariable returns true if a key names a variable of a
+// section, rather than of one of its subsections, or is the key
+// of the header of the section itself.
+func isConfigVariable(key, section string) bool {
+	return strings.HasPrefix(key, section) && !strings.Contains(key[len(section):], ".")
+}
+
+// configHeader returns the header of a section, as in "branch.topic",
+// whose subsection is quoted.
+func configHeader(section string) string {
+	i := strings.Index(section, ".")
+	if i < 0 {
+		return "[" + section + "]"
+	}
+	sub := strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(section[i+1:])
+	return fmt.Sprintf("[%s \"%s\"]", section[:i], sub)
+}
+
+// quoteConfigValue returns a value as it is written in a file, in
+// quotes if it has spaces at its ends or comment characters.
+func quoteConfigValue(value string) string {
+	value = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "\b", "\\b").Replace(value)
+	if strings.TrimSpace(value) != value || strings.ContainsAny(value, "#;") {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+// canonicalKey lowercases the section and variable names of a
+// key, but not its subsection.
+func canonicalKey(key string) string {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+func parseConfigInt(s string) (int, error) {
+	factor := 1
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			factor = 1 << 10
+		case 'm', 'M':
+			factor = 1 << 20
+		case 'g', 'G':
+			factor = 1 << 30
+		}
+		if factor != 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.Atoi(s)
+	return n * factor, err
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isConfigAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// globalConfigFiles returns the locations of the user's
+// global configuration files, in increasing order of
+// precedence.
+func globalConfigFiles() []string {
+	if file := os.Getenv("GIT_CONFIG_GLOBAL"); file != "" {
+		return []string{file}
+	}
+	var files []string
+	home := os.Getenv("HOME")
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		files = append(files, filepath.Join(xdg, "git", "config"))
+	} else if home != "" {
+		files = append(files, filepath.Join(home, ".config", "git", "config"))
+	}
+	if home != "" {
+		files = append(files, filepath.Join(home, ".gitconfig"))
+	}
+	return files
+}
@@ -0,0 +1,120 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+config_git_test.go implements git-comparison tests for the reading and the
+editing of configuration files.
+*/
+package api
+
+import (
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test_config compares the values that we read from a config
+// file with the output of git-config.
+func Test_config(t *testing.T) {
+	dir := test.Config.Repo()
+	file := path.Join(dir, ".git", "config")
+
+	c := new(Config)
+	util.AssertNoErrOrDie(t, c.ReadFile(file))
+
+	keys := []string{
+		"core.bare", "core.editor", "CORE.EDITOR", "diff.algorithm", "diff.renamelimit",
+		"remote.Origin.url", "remote.Origin.fetch", "branch.master.remote",
+		"weird.spaces", "weird.quoted", "weird.escapes", "weird.continued",
+	}
+	for _, key := range keys {
+		expected := util.GitNow(dir, "config", "--file", file, "--get", key)
+		actual, ok := c.Get(key)
+		util.Assertf(t, ok, "expected %s to be set", key)
+		util.AssertEqualString(t, strings.TrimSuffix(expected, "\n"), actual)
+	}
+
+	all := c.GetAll("remote.Origin.fetch")
+	util.AssertEqualInt(t, 2, len(all))
+	_, ok := c.Get("remote.origin.url")
+	util.Assert(t, !ok, "subsections are case-sensitive")
+
+	b, err := c.Bool("weird.flag", false)
+	util.AssertNoErr(t, err)
+	util.Assert(t, b, "a bare variable is true")
+	b, err = c.Bool("core.bare", true)
+	util.AssertNoErr(t, err)
+	util.Assert(t, !b)
+	n, err := c.Int("diff.renameLimit", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, 2048, n)
+	n, err = c.Int("diff.missing", 7)
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, 7, n)
+}
+
+func Test_configErrors(t *testing.T) {
+	bad := []string{
+		"name = outside\n",
+		"[unterminated\n",
+		"[sec]\nvalue = \"open\n",
+		"[sec]\nvalue = bad \\q escape\n",
+		"[sec \"sub]\n",
+	}
+	for _, s := range bad {
+		err := new(Config).Read(strings.NewReader(s))
+		util.Assertf(t, err != nil, "expected an error for %q", s)
+	}
+}
+
+// Test_editConfig compares the configuration files that we edit with
+// the ones that git-config edits.
+func Test_editConfig(t *testing.T) {
+	gitDir, err := test.Config.Clone("__edit_config_git")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(gitDir)
+	dir, err := test.Config.Clone("__edit_config")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+
+	sets := [][]string{
+		{"core.bare", "true"},
+		{"core.editor", "emacs"},
+		{"diff.context", "5"},
+		{"remote.Origin.url", "http://example.com/b.git"},
+		{"branch.topic.remote", "."},
+		{"branch.topic.merge", "refs/heads/master"},
+		{"branch.a\"b.merge", "refs/heads/x"},
+		{"weird.quoted", " padded # with \"quotes\"\tand\\ tabs"},
+	}
+	for _, set := range sets {
+		_, err := util.GitExec(gitDir, "config", set[0], set[1])
+		util.AssertNoErrOrDie(t, err)
+		util.AssertNoErrOrDie(t, SetConfig(repo, set[0], set[1]))
+	}
+	_, err = util.GitExec(gitDir, "config", "--rename-section", "branch.topic", "branch.renamed")
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, RenameConfigSection(repo, "branch.topic", "branch.renamed"))
+	_, err = util.GitExec(gitDir, "config", "--remove-section", "Diff")
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, RemoveConfigSection(repo, "Diff"))
+
+	expected, err := ioutil.ReadFile(path.Join(gitDir, ".git", "config"))
+	util.AssertNoErrOrDie(t, err)
+	actual, err := ioutil.ReadFile(path.Join(dir, ".git", "config"))
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualString(t, string(expected), string(actual))
+
+	// removing a section that doesn't exist does nothing
+	util.AssertNoErr(t, RemoveConfigSection(repo, "missing"))
+}
@@ -0,0 +1,424 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+algorithms.go implements the patience and histogram line diffs, which
+anchor the diff on lines that are rare in both files and tend to give
+better hunks for code that was moved around. As with Myers, they follow
+git's xdiff, so that they produce the same hunks that git does.
+*/
+package diff
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// ================================================================= //
+// ALGORITHMS
+// ================================================================= //
+
+// Algorithm is a line diff algorithm.
+type Algorithm int
+
+const (
+	Myers     Algorithm = iota // Myers' algorithm with xdiff's heuristics
+	Minimal                    // Myers' algorithm, spending extra time to find the smallest diff
+	Patience                   // the patience diff algorithm
+	Histogram                  // the histogram diff algorithm, which extends patience
+)
+
+var algorithmNames = map[Algorithm]string{
+	Myers:     "myers",
+	Minimal:   "minimal",
+	Patience:  "patience",
+	Histogram: "histogram",
+}
+
+// ParseAlgorithm returns the algorithm of the given name, as
+// accepted by --diff-algorithm and diff.algorithm. The name
+// "default" is a synonym of "myers".
+func ParseAlgorithm(name string) (Algorithm, error) {
+	if name == "default" {
+		return Myers, nil
+	}
+	for alg, algName := range algorithmNames {
+		if name == algName {
+			return alg, nil
+		}
+	}
+	return Myers, fmt.Errorf("unknown diff algorithm: %s", name)
+}
+
+func (alg Algorithm) String() string {
+	return algorithmNames[alg]
+}
+
+// Diff compares two files line by line using the algorithm.
+func (alg Algorithm) Diff(a, b []byte) *LineDiff {
+	env := newDiffEnv(SplitLines(a), SplitLines(b))
+	switch alg {
+	case Patience:
+		env.patience(1, env.f1.nrec(), 1, env.f2.nrec())
+	case Histogram:
+		env.histogram(1, env.f1.nrec(), 1, env.f2.nrec())
+	default:
+		env.trimEnds()
+		env.cleanupRecords()
+		env.myers(alg == Minimal)
+	}
+	return env.result()
+}
+
+// BlobDiffer compares blobs using the algorithm.
+func (alg Algorithm) BlobDiffer() BlobDiffer {
+	return algorithmDiffer(alg)
+}
+
+type algorithmDiffer Algorithm
+
+func (d algorithmDiffer) Diff(a, b *objects.Blob) *LineDiff {
+	return Algorithm(d).Diff(a.Data(), b.Data())
+}
+
+// ================================================================= //
+// HELPERS
+// ================================================================= //
+
+// Both algorithms work on ranges of lines that are numbered from 1,
+// as in xdiff. Ranges are given by their first line and length.
+
+// changeAll marks all lines of both ranges as changed.
+func (env *diffEnv) changeAll(line1, count1, line2, count2 int) {
+	for i := 0; i < count1; i++ {
+		env.f1.setChanged(line1-1+i, true)
+	}
+	for i := 0; i < count2; i++ {
+		env.f2.setChanged(line2-1+i, true)
+	}
+}
+
+// match returns true if line1 of the first file is the same
+// as line2 of the second file.
+func (env *diffEnv) match(line1, line2 int) bool {
+	return env.f1.ha[line1-1] == env.f2.ha[line2-1]
+}
+
+// fallBack diffs the ranges with Myers' algorithm, as though
+// they were files of their own.
+func (env *diffEnv) fallBack(line1, count1, line2, count2 int) {
+	sub := newDiffEnv(env.f1.recs[line1-1:line1-1+count1], env.f2.recs[line2-1:line2-1+count2])
+	sub.trimEnds()
+	sub.cleanupRecords()
+	sub.myers(false)
+	for i := 0; i < count1; i++ {
+		env.f1.setChanged(line1-1+i, sub.f1.changed(i))
+	}
+	for i := 0; i < count2; i++ {
+		env.f2.setChanged(line2-1+i, sub.f2.changed(i))
+	}
+}
+
+// ================================================================= //
+// PATIENCE
+// ================================================================= //
+
+// patienceEntry is a line that occurs in the first range. Line2
+// is its line in the second range if it is unique to both, or
+// zero or nonUnique otherwise.
+type patienceEntry struct {
+	line1, line2   int
+	next, previous *patienceEntry
+}
+
+const nonUnique = -1
+
+// patience finds the longest common sequence of the lines that
+// are unique to both ranges, and recursively diffs the gaps
+// between them.
+func (env *diffEnv) patience(line1, count1, line2, count2 int) {
+	if count1 == 0 || count2 == 0 {
+		env.changeAll(line1, count1, line2, count2)
+		return
+	}
+
+	// index the lines in the order of their first occurrence
+	var entries []*patienceEntry
+	byClass := make(map[int]*patienceEntry)
+	hasMatches := false
+	for i := line1; i < line1+count1; i++ {
+		c := env.f1.ha[i-1]
+		if e, ok := byClass[c]; ok {
+			e.line2 = nonUnique
+			continue
+		}
+		e := &patienceEntry{line1: i}
+		byClass[c] = e
+		entries = append(entries, e)
+	}
+	for i := line2; i < line2+count2; i++ {
+		e, ok := byClass[env.f2.ha[i-1]]
+		if !ok {
+			continue
+		}
+		hasMatches = true
+		if e.line2 != 0 {
+			e.line2 = nonUnique
+		} else {
+			e.line2 = i
+		}
+	}
+	if !hasMatches {
+		env.changeAll(line1, count1, line2, count2)
+		return
+	}
+
+	if first := longestCommonSequence(entries); first != nil {
+		env.walkCommonSequence(first, line1, count1, line2, count2)
+	} else {
+		env.fallBack(line1, count1, line2, count2)
+	}
+}
+
+// longestCommonSequence finds the longest sequence of unique lines
+// that appear in the same order in both ranges, by patience sorting
+// on their lines in the second range. It returns the first entry of
+// the sequence, which is linked through next.
+func longestCommonSequence(entries []*patienceEntry) *patienceEntry {
+	sequence := make([]*patienceEntry, len(entries))
+	longest := 0
+	for _, e := range entries {
+		if e.line2 == 0 || e.line2 == nonUnique {
+			continue
+		}
+		// find the last element of the sequence that is less than e
+		left, right := -1, longest
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > e.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		e.previous = nil
+		if left >= 0 {
+			e.previous = sequence[left]
+		}
+		sequence[left+1] = e
+		if left+1 == longest {
+			longest++
+		}
+	}
+	if longest == 0 {
+		return nil
+	}
+	e := sequence[longest-1]
+	e.next = nil
+	for e.previous != nil {
+		e.previous.next = e
+		e = e.previous
+	}
+	return e
+}
+
+// walkCommonSequence diffs the gaps around the lines of the common
+// sequence, after growing the sequence by the lines that match on
+// either side of it.
+func (env *diffEnv) walkCommonSequence(first *patienceEntry, line1, count1, line2, count2 int) {
+	end1, end2 := line1+count1, line2+count2
+	for {
+		var next1, next2 int
+		if first != nil {
+			next1, next2 = first.line1, first.line2
+			for next1 > line1 && next2 > line2 && env.match(next1-1, next2-1) {
+				next1--
+				next2--
+			}
+		} else {
+			next1, next2 = end1, end2
+		}
+		for line1 < next1 && line2 < next2 && env.match(line1, line2) {
+			line1++
+			line2++
+		}
+
+		if next1 > line1 || next2 > line2 {
+			env.patience(line1, next1-line1, line2, next2-line2)
+		}
+		if first == nil {
+			return
+		}
+
+		for first.next != nil && first.next.line1 == first.line1+1 && first.next.line2 == first.line2+1 {
+			first = first.next
+		}
+		line1, line2 = first.line1+1, first.line2+1
+		first = first.next
+	}
+}
+
+// ================================================================= //
+// HISTOGRAM
+// ================================================================= //
+
+// the most occurrences that a line may have in the first range
+// to be used as the anchor of a common region
+const maxChainLength = 64
+
+// histRecord counts the occurrences of a line in the first range,
+// and points at its first occurrence.
+type histRecord struct {
+	ptr, cnt int
+}
+
+// region is a common region of both ranges, given by its
+// first and last lines.
+type region struct {
+	begin1, end1 int
+	begin2, end2 int
+}
+
+// histIndex is the histogram of the first range.
+type histIndex struct {
+	env       *diffEnv
+	records   map[int]*histRecord // by the class of the line
+	lineMap   []*histRecord       // by line
+	nextPtrs  []int               // the next occurrence of each line
+	ptrShift  int
+	cnt       int
+	hasCommon bool
+}
+
+func (idx *histIndex) recordOf(line int) *histRecord {
+	return idx.lineMap[line-idx.ptrShift]
+}
+
+func (idx *histIndex) nextPtr(line int) int {
+	return idx.nextPtrs[line-idx.ptrShift]
+}
+
+// histogram splits the ranges around the longest common region
+// whose lines occur the fewest times in the first range, and
+// recursively diffs the parts before and after it.
+func (env *diffEnv) histogram(line1, count1, line2, count2 int) {
+	for {
+		if count1 <= 0 && count2 <= 0 {
+			return
+		}
+		if count1 == 0 || count2 == 0 {
+			env.changeAll(line1, count1, line2, count2)
+			return
+		}
+		var lcs region
+		if env.findLcs(&lcs, line1, count1, line2, count2) {
+			env.fallBack(line1, count1, line2, count2)
+			return
+		}
+		if lcs.begin1 == 0 && lcs.begin2 == 0 {
+			env.changeAll(line1, count1, line2, count2)
+			return
+		}
+		env.histogram(line1, lcs.begin1-line1, line2, lcs.begin2-line2)
+		end1, end2 := line1+count1-1, line2+count2-1
+		count1, line1 = end1-lcs.end1, lcs.end1+1
+		count2, line2 = end2-lcs.end2, lcs.end2+1
+	}
+}
+
+// findLcs finds the common region to split on. It returns true
+// if the ranges have lines in common, but all of them occur too
+// often to be used, in which case we fall back to Myers.
+func (env *diffEnv) findLcs(lcs *region, line1, count1, line2, count2 int) bool {
+	idx := &histIndex{
+		env:      env,
+		records:  make(map[int]*histRecord),
+		lineMap:  make([]*histRecord, count1),
+		nextPtrs: make([]int, count1),
+		ptrShift: line1,
+	}
+	// scan backwards, so that each record ends up pointing at
+	// the first occurrence of its line
+	for ptr := line1 + count1 - 1; ptr >= line1; ptr-- {
+		c := env.f1.ha[ptr-1]
+		rec, ok := idx.records[c]
+		if ok {
+			idx.nextPtrs[ptr-line1] = rec.ptr
+			rec.ptr = ptr
+			rec.cnt++
+		} else {
+			rec = &histRecord{ptr: ptr, cnt: 1}
+			idx.records[c] = rec
+		}
+		idx.lineMap[ptr-line1] = rec
+	}
+
+	idx.cnt = maxChainLength + 1
+	for bPtr := line2; bPtr <= line2+count2-1; {
+		bPtr = idx.tryLcs(lcs, bPtr, line1, count1, line2, count2)
+	}
+	return idx.hasCommon && maxChainLength < idx.cnt
+}
+
+// tryLcs looks for common regions that contain line bPtr of the
+// second range, and returns the next line to try.
+func (idx *histIndex) tryLcs(lcs *region, bPtr, line1, count1, line2, count2 int) int {
+	env := idx.env
+	bNext := bPtr + 1
+	rec, ok := idx.records[env.f2.ha[bPtr-1]]
+	if !ok {
+		return bNext
+	}
+	if rec.cnt > idx.cnt {
+		if !idx.hasCommon {
+			idx.hasCommon = env.match(rec.ptr, bPtr)
+		}
+		return bNext
+	}
+	idx.hasCommon = true
+	end1, end2 := line1+count1-1, line2+count2-1
+	as := rec.ptr
+	for {
+		np := idx.nextPtr(as)
+		bs, ae, be := bPtr, as, bPtr
+		rc := rec.cnt
+		for line1 < as && line2 < bs && env.match(as-1, bs-1) {
+			as--
+			bs--
+			if 1 < rc {
+				rc = min(rc, idx.recordOf(as).cnt)
+			}
+		}
+		for ae < end1 && be < end2 && env.match(ae+1, be+1) {
+			ae++
+			be++
+			if 1 < rc {
+				rc = min(rc, idx.recordOf(ae).cnt)
+			}
+		}
+
+		if bNext <= be {
+			bNext = be + 1
+		}
+		if lcs.end1-lcs.begin1 < ae-as || rc < idx.cnt {
+			*lcs = region{as, ae, bs, be}
+			idx.cnt = rc
+		}
+
+		if np == 0 {
+			break
+		}
+		for np <= ae {
+			if np = idx.nextPtr(np); np == 0 {
+				return bNext
+			}
+		}
+		as = np
+	}
+	return bNext
+}
@@ -0,0 +1,72 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+algorithms_git_test.go implements git-comparison tests for the line diff
+algorithms.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"testing"
+)
+
+func Test_ParseAlgorithm(t *testing.T) {
+	for _, name := range []string{"myers", "minimal", "patience", "histogram"} {
+		alg, err := ParseAlgorithm(name)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, name, alg.String())
+	}
+	alg, err := ParseAlgorithm("default")
+	util.AssertNoErr(t, err)
+	util.Assert(t, alg == Myers)
+	_, err = ParseAlgorithm("bogus")
+	util.Assert(t, err != nil)
+}
+
+// Test_algorithms compares the hunks of each algorithm with the
+// output of git-diff.
+func Test_algorithms(t *testing.T) {
+	dir := test.Algorithms.Repo()
+	repo := api.Open(dir)
+	ta, err := api.TreeFromRevision(repo, "HEAD~")
+	util.AssertNoErrOrDie(t, err)
+	tb, err := api.TreeFromRevision(repo, "HEAD")
+	util.AssertNoErrOrDie(t, err)
+	td, err := DiffTrees(repo, ta, tb, &Options{})
+	util.AssertNoErrOrDie(t, err)
+
+	for _, alg := range []Algorithm{Myers, Minimal, Patience, Histogram} {
+		for _, context := range []int{0, 3} {
+			expected := util.GitNow(dir, "diff", "--diff-algorithm="+alg.String(), fmt.Sprintf("-U%d", context), "HEAD~", "HEAD")
+			buf := new(bytes.Buffer)
+			opts := PatchOptions{Context: context, Algorithm: alg}
+			err = NewPatchWriter(buf, RepoSource(repo), RepoSource(repo), opts).WriteDiff(td)
+			util.AssertNoErr(t, err)
+			util.AssertEqualString(t, expected, buf.String())
+		}
+	}
+}
+
+func Test_BlobDiffer(t *testing.T) {
+	info := test.Algorithms.Info().(*test.InfoAlgorithms)
+	a := objects.NewBlob(nil, nil, []byte(info.Before))
+	b := objects.NewBlob(nil, nil, []byte(info.After))
+	for _, alg := range []Algorithm{Myers, Minimal, Patience, Histogram} {
+		d := alg.BlobDiffer().Diff(a, b)
+		util.Assert(t, !d.Equal())
+		added, removed := d.Stat()
+		util.Assert(t, added > 0 && removed > 0)
+	}
+}
@@ -0,0 +1,71 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+binary.go implements the detection of binary files, by their contents
+and by the diff attribute of their paths.
+*/
+package diff
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api"
+)
+
+// firstFewBytes is the number of bytes that git looks
+// at to decide whether a file is binary.
+const firstFewBytes = 8000
+
+// IsBinary returns true if the data looks binary to git,
+// that is, if a NUL byte occurs in its first 8000 bytes.
+func IsBinary(data []byte) bool {
+	if len(data) > firstFewBytes {
+		data = data[:firstFewBytes]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// BinaryDetector decides whether files are binary, by the diff
+// attribute of their paths where it says so, and by their
+// contents otherwise. A nil detector only looks at contents.
+type BinaryDetector struct {
+	attrs  *api.Attributes
+	config *api.Config
+}
+
+// NewBinaryDetector returns a detector that honors the attributes,
+// and the diff.<driver>.binary variables of the config, which may
+// be nil.
+func NewBinaryDetector(attrs *api.Attributes, config *api.Config) *BinaryDetector {
+	return &BinaryDetector{attrs, config}
+}
+
+// IsBinary returns true if the file at the path, with the given
+// contents, is binary. A file is binary if its diff attribute is
+// unset, as by the binary macro, and text if it is set. If the
+// attribute names a diff driver, diff.<driver>.binary decides,
+// if it is set. Otherwise, the contents do.
+func (d *BinaryDetector) IsBinary(pth string, data []byte) bool {
+	if d != nil && d.attrs != nil {
+		v := d.attrs.Get(pth, "diff")
+		switch {
+		case v.IsUnset():
+			return true
+		case v.IsSet() && v.Value() == "":
+			return false
+		case v.Value() != "" && d.config != nil:
+			key := "diff." + v.Value() + ".binary"
+			if _, ok := d.config.Get(key); ok {
+				if binary, err := d.config.Bool(key, false); err == nil {
+					return binary
+				}
+			}
+		}
+	}
+	return IsBinary(data)
+}
@@ -0,0 +1,244 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+binpatch.go implements the binary patches of git-diff --binary. A binary
+patch has a hunk that turns the old contents of a file into the new
+ones, and a hunk for the other direction. Each hunk holds either the
+literal contents or a delta against the other side, whichever is
+smaller, deflated and encoded in base85.
+*/
+package diff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+const (
+	// the number of bytes that a line of a binary hunk encodes
+	binaryLineBytes = 52
+
+	// the source of a delta is indexed in blocks of this size
+	deltaBlock = 16
+
+	// the most that a single copy instruction of a delta copies,
+	// which all versions of git understand
+	maxDeltaCopy = 0x10000
+
+	// the most that a single insert instruction inserts
+	maxDeltaInsert = 0x7f
+)
+
+// the digits of git's base85
+const en85 = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// ================================================================= //
+// BINARY PATCHES
+// ================================================================= //
+
+// writeBinaryPatch prints a binary patch between the old and new
+// contents of a file.
+func writeBinaryPatch(buf *bytes.Buffer, dataA, dataB []byte) error {
+	buf.WriteString("GIT binary patch\n")
+	if err := writeBinaryHunk(buf, dataA, dataB); err != nil {
+		return err
+	}
+	return writeBinaryHunk(buf, dataB, dataA)
+}
+
+// writeBinaryHunk prints the hunk that turns src into dst, as
+// a delta if there is one and it is smaller deflated than the
+// deflated contents of dst.
+func writeBinaryHunk(buf *bytes.Buffer, src, dst []byte) error {
+	literal, err := deflate(dst)
+	if err != nil {
+		return err
+	}
+	header, data := fmt.Sprintf("literal %d\n", len(dst)), literal
+	if len(src) > 0 && len(dst) > 0 {
+		if delta := makeDelta(src, dst, len(literal)); delta != nil {
+			deflated, err := deflate(delta)
+			if err != nil {
+				return err
+			}
+			if len(deflated) < len(literal) {
+				header, data = fmt.Sprintf("delta %d\n", len(delta)), deflated
+			}
+		}
+	}
+	buf.WriteString(header)
+	for len(data) > 0 {
+		n := len(data)
+		if n > binaryLineBytes {
+			n = binaryLineBytes
+		}
+		// the length of the line is a letter
+		if n <= 26 {
+			buf.WriteByte(byte('A' + n - 1))
+		} else {
+			buf.WriteByte(byte('a' + n - 27))
+		}
+		encode85(buf, data[:n])
+		buf.WriteByte('\n')
+		data = data[n:]
+	}
+	buf.WriteByte('\n')
+	return nil
+}
+
+// deflate compresses data with zlib, favoring speed as git does.
+func deflate(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := zlib.NewWriterLevel(buf, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode85 encodes data in base85, turning every 4 bytes into 5
+// digits. A shorter last group is padded with zeros.
+func encode85(buf *bytes.Buffer, data []byte) {
+	var digits [5]byte
+	for len(data) > 0 {
+		var acc uint32
+		for shift := 24; shift >= 0; shift -= 8 {
+			if len(data) > 0 {
+				acc |= uint32(data[0]) << uint(shift)
+				data = data[1:]
+			}
+		}
+		for i := 4; i >= 0; i-- {
+			digits[i] = en85[acc%85]
+			acc /= 85
+		}
+		buf.Write(digits[:])
+	}
+}
+
+// ================================================================= //
+// DELTAS
+// ================================================================= //
+
+// makeDelta returns a delta that turns src into dst, in the form
+// of git's packs: the sizes of src and dst, followed by
+// instructions to copy ranges of src and to insert new bytes.
+// Matches are found by indexing src in blocks. If maxSize is
+// positive and the delta would be larger, it returns nil.
+func makeDelta(src, dst []byte, maxSize int) []byte {
+	index := make(map[string]int)
+	for i := 0; i+deltaBlock <= len(src); i += deltaBlock {
+		key := string(src[i : i+deltaBlock])
+		if _, ok := index[key]; !ok {
+			index[key] = i
+		}
+	}
+	delta := appendDeltaSize(nil, len(src))
+	delta = appendDeltaSize(delta, len(dst))
+	pending := 0 // the start of the bytes to insert
+	for i := 0; i < len(dst); {
+		off, n := 0, 0
+		if i+deltaBlock <= len(dst) {
+			if o, ok := index[string(dst[i:i+deltaBlock])]; ok {
+				off, n = o, deltaBlock
+				for off+n < len(src) && i+n < len(dst) && src[off+n] == dst[i+n] {
+					n++
+				}
+				// take back what matches of the bytes to insert
+				for off > 0 && i > pending && src[off-1] == dst[i-1] {
+					off, i, n = off-1, i-1, n+1
+				}
+			}
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		delta = appendDeltaInsert(delta, dst[pending:i])
+		delta = appendDeltaCopy(delta, off, n)
+		i += n
+		pending = i
+		if maxSize > 0 && len(delta) > maxSize {
+			return nil
+		}
+	}
+	delta = appendDeltaInsert(delta, dst[pending:])
+	if maxSize > 0 && len(delta) > maxSize {
+		return nil
+	}
+	return delta
+}
+
+// appendDeltaSize appends a size in 7-bit groups, the least
+// significant first, with the high bit of all but the last
+// group set.
+func appendDeltaSize(delta []byte, size int) []byte {
+	for size >= 0x80 {
+		delta = append(delta, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(delta, byte(size))
+}
+
+// appendDeltaInsert appends instructions to insert data.
+func appendDeltaInsert(delta, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxDeltaInsert {
+			n = maxDeltaInsert
+		}
+		delta = append(delta, byte(n))
+		delta = append(delta, data[:n]...)
+		data = data[n:]
+	}
+	return delta
+}
+
+// appendDeltaCopy appends instructions to copy n bytes of the
+// source from an offset. The bits of the instruction byte tell
+// which bytes of the offset and the size follow; zero bytes
+// are left out.
+func appendDeltaCopy(delta []byte, off, n int) []byte {
+	for n > 0 {
+		size := n
+		if size > maxDeltaCopy {
+			size = maxDeltaCopy
+		}
+		cmd, args := byte(0x80), make([]byte, 0, 7)
+		for k := uint(0); k < 4; k++ {
+			if b := byte(off >> (8 * k)); b != 0 {
+				cmd |= 1 << k
+				args = append(args, b)
+			}
+		}
+		for k := uint(0); k < 3; k++ {
+			if b := byte(size >> (8 * k)); b != 0 {
+				cmd |= 0x10 << k
+				args = append(args, b)
+			}
+		}
+		delta = append(append(delta, cmd), args...)
+		off, n = off+size, n-size
+	}
+	return delta
+}
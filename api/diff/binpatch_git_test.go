@@ -0,0 +1,149 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+binpatch_git_test.go implements git-comparison tests for the patches of
+binary files.
+*/
+package diff
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test_binaryPatch compares the patches of binary files with the
+// output of git-diff, and checks that git-apply takes binary
+// patches both ways. The patches are applied to the index only,
+// since git-apply writes files relative to where it runs.
+func Test_binaryPatch(t *testing.T) {
+	dir, err := test.Patch.Clone("__diff_binary")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+
+	image := strings.Repeat("\x00\x01\x02\x03 pixels of an image\n", 300)
+	util.AssertNoErr(t, util.TestFile(dir, "image.png", image))
+	util.AssertNoErr(t, util.TestFile(dir, "old.bin", "\x00old"))
+	util.AssertNoErr(t, util.TestFile(dir, "data.txt", "looks like text\n"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "binaries"},
+	))
+	image = strings.Replace(image, "pixels", "PIXELS", 7) + "\x00more"
+	util.AssertNoErr(t, util.TestFile(dir, "image.png", image))
+	util.AssertNoErr(t, util.DeleteFile(dir, "old.bin"))
+	util.AssertNoErr(t, util.TestFile(dir, "new.bin", "\x00new\x00"))
+	util.AssertNoErr(t, util.TestFile(dir, "data.txt", "still looks like text\n"))
+	util.AssertNoErr(t, util.TestFile(dir, ".git/info/attributes", "*.txt binary\n"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "changes"},
+	))
+
+	ta, err := api.TreeFromRevision(repo, "HEAD~")
+	util.AssertNoErrOrDie(t, err)
+	tb, err := api.TreeFromRevision(repo, "HEAD")
+	util.AssertNoErrOrDie(t, err)
+	td, err := DiffTrees(repo, ta, tb, &Options{})
+	util.AssertNoErrOrDie(t, err)
+	attrs, err := api.NewAttributes(repo)
+	util.AssertNoErrOrDie(t, err)
+	source := RepoSource(repo)
+	opts := PatchOptions{Context: DefaultContext, Detector: NewBinaryDetector(attrs, nil)}
+
+	buf := new(bytes.Buffer)
+	util.AssertNoErr(t, NewPatchWriter(buf, source, source, opts).WriteDiff(td))
+	util.AssertEqualString(t, util.GitNow(dir, "diff", "HEAD~", "HEAD"), buf.String())
+
+	opts.Binary = true
+	buf.Reset()
+	util.AssertNoErr(t, NewPatchWriter(buf, source, source, opts).WriteDiff(td))
+	util.Assert(t, strings.Contains(buf.String(), "\ndelta "), "expected a delta hunk")
+	file := path.Join(dir, ".git", "binary.diff")
+	util.AssertNoErrOrDie(t, ioutil.WriteFile(file, buf.Bytes(), 0644))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"apply", "-R", "--cached", file},
+		[]string{"diff", "--cached", "--quiet", "HEAD~"},
+		[]string{"apply", "--cached", file},
+		[]string{"diff", "--cached", "--quiet", "HEAD"},
+	))
+}
+
+func Test_encode85(t *testing.T) {
+	// the deflated empty file, as git encodes it
+	buf := new(bytes.Buffer)
+	encode85(buf, []byte{0x78, 0x01, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01})
+	util.AssertEqualString(t, "cmV?d00001", buf.String())
+	buf.Reset()
+	encode85(buf, []byte{0xff})
+	util.AssertEqualString(t, "{{R30", buf.String())
+}
+
+func Test_makeDelta(t *testing.T) {
+	src := []byte(strings.Repeat("the source of a delta, ", 5000))
+	dst := append([]byte("a new start "), src[100:70000]...)
+	dst = append(dst, src[:3000]...)
+	delta := makeDelta(src, dst, 0)
+	util.Assert(t, len(delta) < 100, "expected a small delta")
+	util.Assert(t, bytes.Equal(dst, applyDelta(t, src, delta)), "the delta doesn't make the target")
+	util.Assert(t, makeDelta(src, dst, 10) == nil, "expected no delta within 10 bytes")
+}
+
+// applyDelta applies a delta of makeDelta to src.
+func applyDelta(t *testing.T, src, delta []byte) []byte {
+	size := func() int {
+		n, shift := 0, uint(0)
+		for {
+			c := delta[0]
+			delta = delta[1:]
+			n |= int(c&0x7f) << shift
+			shift += 7
+			if c < 0x80 {
+				return n
+			}
+		}
+	}
+	util.AssertEqualInt(t, len(src), size())
+	var dst []byte
+	want := size()
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		if cmd&0x80 == 0 {
+			dst = append(dst, delta[:cmd]...)
+			delta = delta[cmd:]
+			continue
+		}
+		off, n := 0, 0
+		for k := uint(0); k < 7; k++ {
+			if cmd&(1<<k) == 0 {
+				continue
+			}
+			if k < 4 {
+				off |= int(delta[0]) << (8 * k)
+			} else {
+				n |= int(delta[0]) << (8 * (k - 4))
+			}
+			delta = delta[1:]
+		}
+		if n == 0 {
+			n = 0x10000
+		}
+		dst = append(dst, src[off:off+n]...)
+	}
+	util.AssertEqualInt(t, want, len(dst))
+	return dst
+}
@@ -0,0 +1,159 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+color.go implements the colors of diffs: the slots that git colors, such
+as "meta" for headers and "new" for added lines, their default colors,
+and the color.diff.<slot> settings that change them.
+*/
+package diff
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// COLOR SLOTS
+// ================================================================= //
+
+// ColorSlot is a part of a diff that has a color of its own.
+type ColorSlot int
+
+const (
+	ColorContext ColorSlot = iota
+	ColorMeta
+	ColorFrag
+	ColorOld
+	ColorNew
+	ColorCommit
+	ColorWhitespace
+	ColorFunc
+	ColorOldMoved
+	ColorOldMovedAlternative
+	ColorOldMovedDimmed
+	ColorOldMovedAlternativeDimmed
+	ColorNewMoved
+	ColorNewMovedAlternative
+	ColorNewMovedDimmed
+	ColorNewMovedAlternativeDimmed
+	ColorContextDimmed
+	ColorOldDimmed
+	ColorNewDimmed
+	ColorContextBold
+	ColorOldBold
+	ColorNewBold
+	numColorSlots
+)
+
+// the names of the slots in color.diff.<slot>
+var colorSlotNames = map[string]ColorSlot{
+	"context":                   ColorContext,
+	"plain":                     ColorContext,
+	"meta":                      ColorMeta,
+	"frag":                      ColorFrag,
+	"old":                       ColorOld,
+	"new":                       ColorNew,
+	"commit":                    ColorCommit,
+	"whitespace":                ColorWhitespace,
+	"func":                      ColorFunc,
+	"oldmoved":                  ColorOldMoved,
+	"oldmovedalternative":       ColorOldMovedAlternative,
+	"oldmoveddimmed":            ColorOldMovedDimmed,
+	"oldmovedalternativedimmed": ColorOldMovedAlternativeDimmed,
+	"newmoved":                  ColorNewMoved,
+	"newmovedalternative":       ColorNewMovedAlternative,
+	"newmoveddimmed":            ColorNewMovedDimmed,
+	"newmovedalternativedimmed": ColorNewMovedAlternativeDimmed,
+	"contextdimmed":             ColorContextDimmed,
+	"olddimmed":                 ColorOldDimmed,
+	"newdimmed":                 ColorNewDimmed,
+	"contextbold":               ColorContextBold,
+	"oldbold":                   ColorOldBold,
+	"newbold":                   ColorNewBold,
+}
+
+// git's default colors
+var defaultColors = [numColorSlots]string{
+	ColorContext:                   "",
+	ColorMeta:                      "\x1b[1m",
+	ColorFrag:                      "\x1b[36m",
+	ColorOld:                       "\x1b[31m",
+	ColorNew:                       "\x1b[32m",
+	ColorCommit:                    "\x1b[33m",
+	ColorWhitespace:                "\x1b[41m",
+	ColorFunc:                      "",
+	ColorOldMoved:                  "\x1b[1;35m",
+	ColorOldMovedAlternative:       "\x1b[1;34m",
+	ColorOldMovedDimmed:            "\x1b[2m",
+	ColorOldMovedAlternativeDimmed: "\x1b[2;3m",
+	ColorNewMoved:                  "\x1b[1;36m",
+	ColorNewMovedAlternative:       "\x1b[1;33m",
+	ColorNewMovedDimmed:            "\x1b[2m",
+	ColorNewMovedAlternativeDimmed: "\x1b[2;3m",
+	ColorContextDimmed:             "\x1b[2m",
+	ColorOldDimmed:                 "\x1b[2;31m",
+	ColorNewDimmed:                 "\x1b[2;32m",
+	ColorContextBold:               "\x1b[1m",
+	ColorOldBold:                   "\x1b[1;31m",
+	ColorNewBold:                   "\x1b[1;32m",
+}
+
+// ================================================================= //
+// COLORS
+// ================================================================= //
+
+// Colors are the escape sequences of the slots of a diff. A nil
+// *Colors colors nothing.
+type Colors struct {
+	slots [numColorSlots]string
+}
+
+// NewColors returns git's default colors, as changed by the
+// color.diff.<slot> settings of the configuration, which may
+// be nil. The older diff.color.<slot> settings are read too.
+func NewColors(config *api.Config) (*Colors, error) {
+	c := &Colors{defaultColors}
+	if config == nil {
+		return c, nil
+	}
+	for name, slot := range colorSlotNames {
+		for _, key := range []string{"color.diff." + name, "diff.color." + name} {
+			value, ok := config.Get(key)
+			if !ok {
+				continue
+			}
+			color, err := util.ParseColor(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad config value for '%s': %s", key, err)
+			}
+			c.slots[slot] = color
+			break
+		}
+	}
+	return c, nil
+}
+
+// Get returns the escape sequence of a slot, which is empty
+// if c is nil.
+func (c *Colors) Get(slot ColorSlot) string {
+	if c == nil {
+		return ""
+	}
+	return c.slots[slot]
+}
+
+// Reset returns the escape sequence that ends colors, which
+// is empty if c is nil.
+func (c *Colors) Reset() string {
+	if c == nil {
+		return ""
+	}
+	return util.ColorReset
+}
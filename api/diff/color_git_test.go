@@ -0,0 +1,98 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+color_git_test.go implements git-comparison tests for colored patches, word
+diffs and moved lines.
+*/
+package diff
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"testing"
+)
+
+// Test_colorPatch compares colored patches, word diffs and the
+// colors of moved lines with the output of git-diff.
+func Test_colorPatch(t *testing.T) {
+	dir, err := test.Patch.Clone("__diff_color")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+
+	block := "func moved() {\n\treturn \"a block that moves around\"\n}\n"
+	other := "func other() {\n\treturn \"another block that moves\"\n}\n"
+	util.AssertNoErr(t, util.TestFile(dir, "a.go", "package a\n\n"+block+"\nvar x = 1\n\n"+other+"\nfunc f() {}\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "b.txt", "the quick brown fox\njumps over\nthe lazy dog\n"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "first"},
+	))
+	util.AssertNoErr(t, util.TestFile(dir, "a.go", "package a\n\nvar x = 2 \n\n"+other+"\nfunc f() {}\n\n"+block+"\t \tend\n\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "b.txt", "the slow brown fox\njumps\nthe lazy dog, twice"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "second"},
+	))
+
+	ta, err := api.TreeFromRevision(repo, "HEAD~")
+	util.AssertNoErrOrDie(t, err)
+	tb, err := api.TreeFromRevision(repo, "HEAD")
+	util.AssertNoErrOrDie(t, err)
+	td, err := DiffTrees(repo, ta, tb, &Options{})
+	util.AssertNoErrOrDie(t, err)
+	colors, err := NewColors(nil)
+	util.AssertNoErrOrDie(t, err)
+	source := RepoSource(repo)
+
+	tests := []struct {
+		args []string
+		opts PatchOptions
+	}{
+		{[]string{"--color"}, PatchOptions{Colors: colors}},
+		{[]string{"--color", "--color-moved=plain"}, PatchOptions{Colors: colors, Moved: MovedPlain}},
+		{[]string{"--color", "--color-moved=blocks"}, PatchOptions{Colors: colors, Moved: MovedBlocks}},
+		{[]string{"--color", "--color-moved=zebra"}, PatchOptions{Colors: colors, Moved: MovedZebra}},
+		{[]string{"--color", "--color-moved=dimmed-zebra"}, PatchOptions{Colors: colors, Moved: MovedDimmedZebra}},
+		{[]string{"--word-diff"}, PatchOptions{WordDiff: WordDiffPlain}},
+		{[]string{"--word-diff=porcelain"}, PatchOptions{WordDiff: WordDiffPorcelain}},
+		{[]string{"--color-words"}, PatchOptions{Colors: colors, WordDiff: WordDiffColor}},
+	}
+	for _, test := range tests {
+		opts := test.opts
+		opts.Context, opts.Whitespace = DefaultContext, DefaultWhitespaceRule
+		buf := new(bytes.Buffer)
+		util.AssertNoErr(t, NewPatchWriter(buf, source, source, opts).WriteDiff(td))
+		args := append([]string{"diff"}, test.args...)
+		util.AssertEqualString(t, util.GitNow(dir, append(args, "HEAD~", "HEAD")...), buf.String())
+	}
+
+	// words are what the regular expression matches
+	re, err := CompileWordRegex("[a-z]")
+	util.AssertNoErrOrDie(t, err)
+	buf := new(bytes.Buffer)
+	opts := PatchOptions{Context: DefaultContext, WordDiff: WordDiffPlain, WordRegex: re}
+	util.AssertNoErr(t, NewPatchWriter(buf, source, source, opts).WriteDiff(td))
+	util.AssertEqualString(t, util.GitNow(dir, "diff", "--word-diff-regex=[a-z]", "HEAD~", "HEAD"), buf.String())
+}
+
+func Test_ParseWhitespaceRule(t *testing.T) {
+	rule, err := ParseWhitespaceRule("")
+	util.AssertNoErr(t, err)
+	util.Assert(t, rule == DefaultWhitespaceRule, "expected the default rule")
+	rule, err = ParseWhitespaceRule("-trailing-space, indent-with-non-tab,tabwidth=4")
+	util.AssertNoErr(t, err)
+	util.Assert(t, rule == WSSpaceBeforeTab|WSIndentWithNonTab|4, "unexpected rule")
+	util.AssertEqualInt(t, 4, rule.TabWidth())
+	_, err = ParseWhitespaceRule("tab-in-indent,indent-with-non-tab")
+	util.Assert(t, err != nil, "expected an error for conflicting rules")
+}
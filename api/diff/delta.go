@@ -0,0 +1,80 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+delta.go implements git's estimate of how much of a file was copied
+into another, which is cheaper than a line diff. Files are cut into
+spans that end with a newline or are 64 bytes long, whichever comes
+first, and the spans are hashed and counted; see diffcore-delta.c.
+*/
+package diff
+
+const (
+	// the longest span
+	maxSpan = 64
+
+	// spans are hashed into this many buckets
+	spanHashBase = 107927
+)
+
+// spanCounts maps the hash of a span to the number of bytes
+// in all spans with that hash.
+type spanCounts map[uint32]int
+
+// hashSpans cuts data into spans and counts them. The CR of
+// a CRLF is ignored in text files.
+func hashSpans(data []byte) spanCounts {
+	counts := make(spanCounts)
+	text := !IsBinary(data)
+	var accum1, accum2 uint32
+	n := 0
+	for i, c := range data {
+		if text && c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+		old1 := accum1
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old1 >> 25)
+		accum1 += uint32(c)
+		if n++; n < maxSpan && c != '\n' {
+			continue
+		}
+		counts[(accum1+accum2*0x61)%spanHashBase] += n
+		n, accum1, accum2 = 0, 0, 0
+	}
+	// like git, we don't count the last span if it is
+	// incomplete
+	return counts
+}
+
+// countChanges estimates the number of bytes of src that were
+// copied into dst, and the number of bytes that dst adds.
+func countChanges(src, dst []byte) (copied, added int) {
+	return hashSpans(src).changes(hashSpans(dst))
+}
+
+// changes estimates the number of bytes of the source, whose
+// spans are sc, that were copied into the destination, whose
+// spans are dc, and the number of bytes that it adds.
+func (sc spanCounts) changes(dc spanCounts) (copied, added int) {
+	for h, s := range sc {
+		d := dc[h]
+		if s < d {
+			copied += s
+			added += d - s
+		} else {
+			copied += d
+		}
+	}
+	for h, d := range dc {
+		if _, ok := sc[h]; !ok {
+			added += d
+		}
+	}
+	return
+}
@@ -0,0 +1,254 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+dirstat.go implements the distribution of the changes of a tree diff
+among directories, as git-diff --dirstat shows it.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// OPTIONS
+// ================================================================= //
+
+// DirstatMode is the way in which the changes to a file are
+// counted in a dirstat.
+type DirstatMode int
+
+const (
+	// DirstatChanges counts the bytes that were removed from
+	// or added to a file.
+	DirstatChanges DirstatMode = iota
+
+	// DirstatLines counts the lines that were removed or added.
+	DirstatLines
+
+	// DirstatFiles counts every changed file as one.
+	DirstatFiles
+)
+
+// DefaultDirstatPermille is the smallest share of the changes, in
+// tenths of a percent, that a directory must have to be shown.
+const DefaultDirstatPermille = 30
+
+// DirstatOptions control the way in which a dirstat is
+// counted and shown.
+type DirstatOptions struct {
+	Mode DirstatMode
+
+	// Permille is the smallest share of the changes, in tenths
+	// of a percent, that a directory must have to be shown.
+	Permille int
+
+	// Cumulative counts the changes of subdirectories that are
+	// shown in their parents too.
+	Cumulative bool
+
+	// Algorithm is the line diff algorithm of DirstatLines.
+	Algorithm Algorithm
+
+	// Detector decides which files are binary in DirstatLines.
+	// If it is nil, only their contents do.
+	Detector *BinaryDetector
+}
+
+// NewDirstatOptions returns the options of a plain --dirstat.
+func NewDirstatOptions() DirstatOptions {
+	return DirstatOptions{Mode: DirstatChanges, Permille: DefaultDirstatPermille}
+}
+
+// Parse applies a comma-separated list of dirstat parameters, as
+// in --dirstat=lines,cumulative,10: the mode, whether to be
+// cumulative, and the limit as a percentage.
+func (opts *DirstatOptions) Parse(params string) error {
+	for _, p := range strings.Split(params, ",") {
+		switch {
+		case p == "":
+		case p == "changes":
+			opts.Mode = DirstatChanges
+		case p == "lines":
+			opts.Mode = DirstatLines
+		case p == "files":
+			opts.Mode = DirstatFiles
+		case p == "noncumulative":
+			opts.Cumulative = false
+		case p == "cumulative":
+			opts.Cumulative = true
+		case p[0] >= '0' && p[0] <= '9':
+			permille, err := parsePermille(p)
+			if err != nil {
+				return fmt.Errorf("failed to parse dirstat cut-off percentage '%s'", p)
+			}
+			opts.Permille = permille
+		default:
+			return fmt.Errorf("unknown dirstat parameter '%s'", p)
+		}
+	}
+	return nil
+}
+
+// parsePermille parses a percentage, of which only the first
+// decimal counts.
+func parsePermille(p string) (int, error) {
+	whole, frac := p, ""
+	if i := strings.Index(p, "."); i >= 0 {
+		whole, frac = p[:i], p[i+1:]
+		if strings.Trim(frac, "0123456789") != "" {
+			return 0, fmt.Errorf("bad percentage")
+		}
+	}
+	n, err := strconv.Atoi(whole)
+	if err != nil {
+		return 0, err
+	}
+	n *= 10
+	if frac != "" {
+		n += int(frac[0] - '0')
+	}
+	return n, nil
+}
+
+// ================================================================= //
+// DIRSTAT
+// ================================================================= //
+
+type dirstatFile struct {
+	name    string
+	changed int
+}
+
+// WriteDirstat prints the share of the changes of every directory
+// that has enough of them, as git-diff --dirstat does. Directories
+// that got all their changes from a single subdirectory are not
+// shown, and neither is the top level. The old sides of the edits
+// are read from one source and the new sides from another.
+func WriteDirstat(w io.Writer, td *TreeDiff, before, after BlobSource, opts DirstatOptions) error {
+	var files []dirstatFile
+	if opts.Mode == DirstatLines {
+		ds, err := NewDiffStat(td, before, after, opts.Algorithm, opts.Detector)
+		if err != nil {
+			return err
+		}
+		for _, fs := range ds {
+			damage := fs.Added + fs.Removed
+			if fs.Binary {
+				// bytes are counted as lines of 64 bytes
+				damage = (damage + 63) / 64
+			}
+			files = append(files, dirstatFile{fs.Edit.Path(), damage})
+		}
+	} else {
+		for _, edit := range td.Edits() {
+			if edit.action == Unmerged {
+				continue
+			}
+			damage, err := editDamage(edit, before, after, opts.Mode, td.workTree)
+			if err != nil {
+				return err
+			}
+			files = append(files, dirstatFile{edit.Path(), damage})
+		}
+	}
+
+	total := 0
+	for _, f := range files {
+		total += f.changed
+	}
+	// this can happen if everything was renamed
+	if total == 0 {
+		return nil
+	}
+	sort.Sort(byDirstatName(files))
+	buf := new(bytes.Buffer)
+	g := &dirstatGatherer{buf, files, total, opts}
+	g.gather("")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// editDamage estimates the number of bytes that an edit
+// removes and adds, or is 1 for every changed file in the
+// DirstatFiles mode. The damage of a file that changed is
+// never 0. Like git, we only trust that a file didn't change
+// because of its oid if it isn't in the working tree.
+func editDamage(edit *TreeEdit, before, after BlobSource, mode DirstatMode, workTree bool) (int, error) {
+	a, b := edit.Before, edit.After
+	if !workTree && a != nil && b != nil && a.ObjectId().String() == b.ObjectId().String() {
+		return 0, nil
+	}
+	if mode == DirstatFiles {
+		return 1, nil
+	}
+	dataA, dataB, err := readSides(before, after, a, b)
+	if err != nil {
+		return 0, err
+	}
+	copied, added := 0, len(dataB)
+	if a != nil && b != nil {
+		copied, added = countChanges(dataA, dataB)
+	}
+	if damage := len(dataA) - copied + added; damage > 0 {
+		return damage, nil
+	}
+	return 1, nil
+}
+
+type dirstatGatherer struct {
+	w     *bytes.Buffer
+	files []dirstatFile
+	total int
+	opts  DirstatOptions
+}
+
+// gather sums the changes of the files in a directory, which
+// are next in the sorted list of files, and prints the share
+// of the directory if it is large enough. The result is 0 for
+// a directory that was printed, unless the dirstat is
+// cumulative.
+func (g *dirstatGatherer) gather(base string) int {
+	sum, sources := 0, 0
+	for len(g.files) > 0 {
+		f := g.files[0]
+		if !strings.HasPrefix(f.name, base) {
+			break
+		}
+		if i := strings.Index(f.name[len(base):], "/"); i >= 0 {
+			sum += g.gather(f.name[:len(base)+i+1])
+			sources++
+		} else {
+			sum += f.changed
+			g.files = g.files[1:]
+			sources += 2
+		}
+	}
+	if base != "" && sources != 1 && sum > 0 {
+		permille := sum * 1000 / g.total
+		if permille >= g.opts.Permille {
+			fmt.Fprintf(g.w, "%4d.%01d%% %s\n", permille/10, permille%10, base)
+			if !g.opts.Cumulative {
+				return 0
+			}
+		}
+	}
+	return sum
+}
+
+type byDirstatName []dirstatFile
+
+func (s byDirstatName) Len() int           { return len(s) }
+func (s byDirstatName) Less(i, j int) bool { return s[i].name < s[j].name }
+func (s byDirstatName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
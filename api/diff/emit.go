@@ -0,0 +1,175 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+emit.go implements the printing of the lines of patches, which are
+collected as symbols first, so that moved lines can be found among all
+of them, and are then printed in their colors as git's
+emit_diff_symbol does.
+*/
+package diff
+
+import (
+	"bytes"
+	"strings"
+)
+
+// ================================================================= //
+// SYMBOLS
+// ================================================================= //
+
+// symbolKind is the kind of a line of a patch.
+type symbolKind int
+
+const (
+	symMeta         symbolKind = iota // a header line, such as "diff --git"
+	symFileOld                        // the label of the old file, in "---"
+	symFileNew                        // the label of the new file, in "+++"
+	symPlain                          // lines that are never colored
+	symHunk                           // a hunk header
+	symContext                        // a context line
+	symMinus                          // a removed line
+	symPlus                           // an added line
+	symIncomplete                     // the marker of a missing line feed
+	symWords                          // printed lines of a word diff
+	symWordsContext                   // a context line of a word diff
+)
+
+// movedFlags tell whether and how a line was moved.
+type movedFlags int
+
+const (
+	movedLine          movedFlags = 1 << iota // the line was moved
+	movedAlternative                          // its block has the alternative color
+	movedUninteresting                        // it is inside of a block, and dimmed
+)
+
+// symbol is a line of a patch, or a few of them for symPlain
+// and symWords. Apart from those, the text ends with a line
+// feed.
+type symbol struct {
+	kind       symbolKind
+	text       string
+	blankAtEOF bool       // the line is a blank one that was added at the end
+	moved      movedFlags // how the line was moved
+	id         int        // the line, when looking for moved lines
+}
+
+// ================================================================= //
+// PRINTING
+// ================================================================= //
+
+// writeSymbols prints symbols in their colors.
+func (pw *PatchWriter) writeSymbols(buf *bytes.Buffer, syms []symbol) {
+	c := pw.opts.Colors
+	reset := c.Reset()
+	for _, s := range syms {
+		switch s.kind {
+		case symMeta:
+			buf.WriteString(c.Get(ColorMeta) + s.text + reset + "\n")
+		case symFileOld, symFileNew:
+			sign := "--- "
+			if s.kind == symFileNew {
+				sign = "+++ "
+			}
+			buf.WriteString(c.Get(ColorMeta) + sign + s.text + reset + labelTab(s.text) + "\n")
+		case symPlain, symWords:
+			buf.WriteString(s.text)
+		case symHunk:
+			pw.writeHunkHeader(buf, s.text)
+		case symContext:
+			writeLine(buf, c.Get(ColorContext), reset, ' ', s.text)
+		case symMinus:
+			writeLine(buf, c.Get(s.moved.slot(ColorOld)), reset, '-', s.text)
+		case symPlus:
+			set, ws := c.Get(s.moved.slot(ColorNew)), c.Get(ColorWhitespace)
+			switch {
+			case ws == "":
+				writeLine(buf, set, reset, '+', s.text)
+			case s.blankAtEOF:
+				// the sign is painted too
+				writeLine(buf, ws, reset, '+', s.text)
+			default:
+				writeLine(buf, set, reset, '+', "")
+				checkWhitespace(buf, s.text, pw.opts.Whitespace, set, reset, ws)
+			}
+		case symIncomplete, symWordsContext:
+			writeLine(buf, c.Get(ColorContext), reset, 0, s.text)
+			if s.kind == symWordsContext && pw.opts.WordDiff == WordDiffPorcelain {
+				buf.WriteString("~\n")
+			}
+		}
+	}
+}
+
+// writeHunkHeader prints a hunk header, with the ranges in the
+// frag color and the function in the func color.
+func (pw *PatchWriter) writeHunkHeader(buf *bytes.Buffer, header string) {
+	c := pw.opts.Colors
+	reset := c.Reset()
+	end := strings.Index(header[2:], "@@") + 4
+	buf.WriteString(c.Get(ColorFrag) + header[:end] + reset)
+	if fn := header[end:]; fn != "" {
+		text := strings.TrimLeft(fn, " \t")
+		if blank := fn[:len(fn)-len(text)]; blank != "" {
+			buf.WriteString(c.Get(ColorContext) + blank + reset)
+		}
+		if text != "" {
+			buf.WriteString(c.Get(ColorFunc) + text + reset)
+		}
+	}
+	buf.WriteByte('\n')
+}
+
+// writeLine prints a line that starts with a sign, if it is not
+// 0, in a color, as git's emit_line_0 does. The color is reset
+// before the line feed and any carriage return.
+func writeLine(buf *bytes.Buffer, set, reset string, sign byte, line string) {
+	newline := strings.HasSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\n")
+	cr := strings.HasSuffix(line, "\r")
+	line = strings.TrimSuffix(line, "\r")
+	if line != "" || sign != 0 {
+		buf.WriteString(set)
+		if sign != 0 {
+			buf.WriteByte(sign)
+		}
+		buf.WriteString(line + reset)
+	}
+	if cr {
+		buf.WriteByte('\r')
+	}
+	if newline {
+		buf.WriteByte('\n')
+	}
+}
+
+// slot returns the slot of the color of a removed or added line
+// that was moved in this way. The slot of a line that was not
+// moved is the one given.
+func (moved movedFlags) slot(slot ColorSlot) ColorSlot {
+	if moved&movedLine == 0 {
+		return slot
+	}
+	slots := []ColorSlot{
+		ColorOldMoved, ColorOldMovedAlternative, ColorOldMovedDimmed, ColorOldMovedAlternativeDimmed,
+	}
+	if slot == ColorNew {
+		slots = []ColorSlot{
+			ColorNewMoved, ColorNewMovedAlternative, ColorNewMovedDimmed, ColorNewMovedAlternativeDimmed,
+		}
+	}
+	i := 0
+	if moved&movedAlternative != 0 {
+		i++
+	}
+	if moved&movedUninteresting != 0 {
+		i += 2
+	}
+	return slots[i]
+}
@@ -0,0 +1,216 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+hunks.go groups the changes of a line diff into the hunks of a unified
+diff, and prints them.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+const (
+	// DefaultContext is the number of context lines
+	// shown around each change.
+	DefaultContext = 3
+
+	// NoNewline is the marker that follows a line that
+	// is missing its line feed.
+	NoNewline = "\\ No newline at end of file"
+
+	// the longest function name shown in a hunk header
+	maxFuncName = 80
+)
+
+// ================================================================= //
+// HUNKS
+// ================================================================= //
+
+// Line is a line of a hunk. Op is one of ' ', '-' and '+', and
+// the text includes the line feed, if the line has one.
+type Line struct {
+	Op   byte
+	Text string
+}
+
+// Hunk is a group of changes that are close enough to each other
+// to share their context lines. Starting lines are numbered from 1,
+// except that they are one less for empty ranges.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Func               string // the function that the hunk is in
+	Lines              []Line
+}
+
+// Hunks groups the changes of the diff into hunks that have
+// the given number of context lines.
+func (d *LineDiff) Hunks(context int) []*Hunk {
+	if context < 0 {
+		context = 0
+	}
+	var hunks []*Hunk
+	funcLine, funcLimit := "", -1
+	for i := 0; i < len(d.Changes); {
+		// changes that are at most 2*context lines apart go together
+		j := i
+		for j+1 < len(d.Changes) {
+			prev, next := d.Changes[j], d.Changes[j+1]
+			if next.A-(prev.A+prev.Del) > 2*context {
+				break
+			}
+			j++
+		}
+		first, last := d.Changes[i], d.Changes[j]
+		s1, s2 := max(first.A-context, 0), max(first.B-context, 0)
+		e1 := min(last.A+last.Del+context, len(d.A))
+		e2 := min(last.B+last.Ins+context, len(d.B))
+
+		h := &Hunk{OldLines: e1 - s1, NewLines: e2 - s2}
+		h.OldStart, h.NewStart = s1+1, s2+1
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+
+		// the function line is searched for from the hunk up to
+		// where the search for the previous hunk started
+		if l, ok := d.funcLine(s1-1, funcLimit); ok {
+			funcLine = l
+		}
+		funcLimit = s1 - 1
+		h.Func = funcLine
+
+		b := s2
+		for ; b < first.B; b++ {
+			h.Lines = append(h.Lines, Line{' ', d.B[b]})
+		}
+		for k := i; k <= j; k++ {
+			c := d.Changes[k]
+			for ; b < c.B; b++ {
+				h.Lines = append(h.Lines, Line{' ', d.B[b]})
+			}
+			for a := c.A; a < c.A+c.Del; a++ {
+				h.Lines = append(h.Lines, Line{'-', d.A[a]})
+			}
+			for ; b < c.B+c.Ins; b++ {
+				h.Lines = append(h.Lines, Line{'+', d.B[b]})
+			}
+		}
+		for ; b < e2; b++ {
+			h.Lines = append(h.Lines, Line{' ', d.B[b]})
+		}
+		hunks = append(hunks, h)
+		i = j + 1
+	}
+	return hunks
+}
+
+// funcLine finds the closest line of the old file, going up from
+// start but not reaching limit, that looks like the start of a
+// function. Like git's default, this is any line that begins with
+// a letter, an underscore or a dollar sign.
+func (d *LineDiff) funcLine(start, limit int) (string, bool) {
+	for l := start; l > limit && l >= 0 && l < len(d.A); l-- {
+		rec := d.A[l]
+		if rec == "" {
+			continue
+		}
+		if c := rec[0]; isAlpha(c) || c == '_' || c == '$' {
+			if len(rec) > maxFuncName {
+				rec = rec[:maxFuncName]
+			}
+			return validPrefix(strings.TrimRight(rec, " \t\n\r\v\f")), true
+		}
+	}
+	return "", false
+}
+
+// validPrefix returns the part of s before the first byte that
+// isn't valid UTF-8, which is where git cuts hunk headers off.
+// Like git, it takes U+FFFE and U+FFFF to be invalid.
+func validPrefix(s string) string {
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && n == 1) || r == 0xfffe || r == 0xffff {
+			return s[:i]
+		}
+		i += n
+	}
+	return s
+}
+
+// Header returns the "@@ -a,b +c,d @@" line of the hunk,
+// without a line feed.
+func (h *Hunk) Header() string {
+	buf := bytes.NewBufferString("@@ -")
+	writeRange(buf, h.OldStart, h.OldLines)
+	buf.WriteString(" +")
+	writeRange(buf, h.NewStart, h.NewLines)
+	buf.WriteString(" @@")
+	if h.Func != "" {
+		buf.WriteString(" " + h.Func)
+	}
+	return buf.String()
+}
+
+func writeRange(buf *bytes.Buffer, start, lines int) {
+	fmt.Fprint(buf, start)
+	if lines != 1 {
+		fmt.Fprintf(buf, ",%d", lines)
+	}
+}
+
+// WriteTo prints the hunk in unified format.
+func (h *Hunk) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(h.Header())
+	buf.WriteByte('\n')
+	for _, l := range h.Lines {
+		buf.WriteByte(l.Op)
+		buf.WriteString(l.Text)
+		if !strings.HasSuffix(l.Text, "\n") {
+			buf.WriteString("\n" + NoNewline + "\n")
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,202 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+index.go compares trees, the index and the working tree against each
+other. Each side is turned into a sorted list of entries named by their
+full paths, and the lists are merged.
+*/
+package diff
+
+import (
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+)
+
+// ================================================================= //
+// DIFFS AGAINST THE INDEX AND WORKING TREE
+// ================================================================= //
+
+// DiffTreeIndex compares a tree, which may be nil, against the
+// index, as in "git diff --cached". Paths with merge conflicts
+// are reported as Unmerged edits.
+func DiffTreeIndex(r api.Repository, t *objects.Tree, idx *api.Index, opts *Options) (*TreeDiff, error) {
+	before, err := flattenTree(r, t, opts.Pathspec)
+	if err != nil {
+		return nil, err
+	}
+	after, unmerged := indexEntries(idx, opts.Pathspec)
+	edits, unchanged := diffEntries(before, after, unmerged)
+	source := RepoSource(r)
+	return newTreeDiff(edits, unchanged, source, source, opts)
+}
+
+// DiffIndexWorkTree compares the index against the working tree,
+// as in "git diff". Files that are not tracked are not compared.
+func DiffIndexWorkTree(r api.Repository, wt *api.WorkTree, idx *api.Index, opts *Options) (*TreeDiff, error) {
+	before, unmerged := indexEntries(idx, opts.Pathspec)
+	after, err := workTreeEntries(wt, idx, opts.Pathspec)
+	if err != nil {
+		return nil, err
+	}
+	edits, unchanged := diffEntries(before, after, unmerged)
+	return newWorkTreeDiff(r, wt, edits, unchanged, opts)
+}
+
+// DiffTreeWorkTree compares a tree, which may be nil, against the
+// files of the working tree that are tracked by the index, as in
+// "git diff <commit>".
+func DiffTreeWorkTree(r api.Repository, t *objects.Tree, wt *api.WorkTree, idx *api.Index, opts *Options) (*TreeDiff, error) {
+	before, err := flattenTree(r, t, opts.Pathspec)
+	if err != nil {
+		return nil, err
+	}
+	_, unmerged := indexEntries(idx, opts.Pathspec)
+	after, err := workTreeEntries(wt, idx, opts.Pathspec)
+	if err != nil {
+		return nil, err
+	}
+	edits, unchanged := diffEntries(before, after, unmerged)
+	return newWorkTreeDiff(r, wt, edits, unchanged, opts)
+}
+
+// newWorkTreeDiff returns the diff of edits whose new sides are
+// files of the working tree.
+func newWorkTreeDiff(r api.Repository, wt *api.WorkTree, edits []*TreeEdit, unchanged []*objects.TreeEntry, opts *Options) (*TreeDiff, error) {
+	td, err := newTreeDiff(edits, unchanged, RepoSource(r), WorkTreeSource(wt), opts)
+	if err != nil {
+		return nil, err
+	}
+	td.workTree = true
+	return td, nil
+}
+
+// diffEntries merges two lists of entries that are sorted by
+// path into edits, and the entries that didn't change. Unmerged
+// paths are taken out of both lists and reported by themselves.
+func diffEntries(before, after []*objects.TreeEntry, unmerged []*objects.TreeEntry) (edits []*TreeEdit, unchanged []*objects.TreeEntry) {
+	conflicted := make(map[string]bool)
+	for _, e := range unmerged {
+		conflicted[e.Name()] = true
+		edits = append(edits, &TreeEdit{action: Unmerged, Before: e})
+	}
+	for len(before) > 0 || len(after) > 0 {
+		var a, b *objects.TreeEntry
+		switch {
+		case len(after) == 0:
+			a, before = before[0], before[1:]
+		case len(before) == 0:
+			b, after = after[0], after[1:]
+		case before[0].Name() < after[0].Name():
+			a, before = before[0], before[1:]
+		case before[0].Name() > after[0].Name():
+			b, after = after[0], after[1:]
+		default:
+			a, b, before, after = before[0], after[0], before[1:], after[1:]
+		}
+		if (a != nil && conflicted[a.Name()]) || (b != nil && conflicted[b.Name()]) {
+			continue
+		}
+		if edit := editFor(a, b); edit != nil {
+			edits = append(edits, edit)
+		} else {
+			unchanged = append(unchanged, a)
+		}
+	}
+	return
+}
+
+// flattenTree returns the non-tree entries of a tree, named by
+// their full paths, in git's order.
+func flattenTree(r api.Repository, t *objects.Tree, ps *pathspec.Pathspec) ([]*objects.TreeEntry, error) {
+	if t == nil {
+		return nil, nil
+	}
+	var entries []*objects.TreeEntry
+	f := func(pth string, e *objects.TreeEntry) error {
+		if e.ObjectType() != objects.ObjectTree {
+			entries = append(entries, withPath(e, pth))
+		}
+		return nil
+	}
+	if ps != nil {
+		f = ps.TreeVisitor(f)
+	}
+	return entries, api.WalkTree(r, t, "", f)
+}
+
+// indexEntries returns the merged entries of the index, leaving
+// out the ones that were only added with the intent to add, and
+// a single entry for each path that has merge conflicts.
+func indexEntries(idx *api.Index, ps *pathspec.Pathspec) (entries, unmerged []*objects.TreeEntry) {
+	for _, e := range idx.Entries() {
+		if ps != nil && !ps.MatchIndexEntry(e) {
+			continue
+		}
+		switch {
+		case e.Stage() != 0:
+			if n := len(unmerged); n == 0 || unmerged[n-1].Name() != e.Name() {
+				unmerged = append(unmerged, indexTreeEntry(e))
+			}
+		case !e.ExtendedFlags().IntentToAdd():
+			entries = append(entries, indexTreeEntry(e))
+		}
+	}
+	return
+}
+
+// workTreeEntries returns the entries for the files of the working
+// tree that are tracked by the index, as they would be staged.
+// Deleted files are left out, and files that have not changed
+// keep the oids of their index entries.
+func workTreeEntries(wt *api.WorkTree, idx *api.Index, ps *pathspec.Pathspec) ([]*objects.TreeEntry, error) {
+	var entries []*objects.TreeEntry
+	for _, e := range idx.Entries() {
+		if e.Stage() != 0 || (ps != nil && !ps.MatchIndexEntry(e)) {
+			continue
+		}
+		status, err := wt.Status(e)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case status == api.WorkDeleted:
+			continue
+		case status == api.WorkUnmodified || e.Mode() == objects.ModeCommit:
+			entries = append(entries, indexTreeEntry(e))
+			continue
+		}
+		data, mode, err := wt.ReadFile(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, objects.NewTreeEntry(mode, modeObjectType(mode), e.Name(), api.BlobOid(data)))
+	}
+	return entries, nil
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+func indexTreeEntry(e *api.IndexEntry) *objects.TreeEntry {
+	return objects.NewTreeEntry(e.Mode(), modeObjectType(e.Mode()), e.Name(), e.ObjectId())
+}
+
+// modeObjectType returns the type of the object that an
+// entry of the given mode points to.
+func modeObjectType(mode objects.FileMode) objects.ObjectType {
+	switch mode {
+	case objects.ModeTree:
+		return objects.ObjectTree
+	case objects.ModeCommit:
+		return objects.ObjectCommit
+	}
+	return objects.ObjectBlob
+}
@@ -0,0 +1,834 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+lines.go implements line-oriented diffs of file contents. The algorithm
+follows git's xdiff closely, so that the hunks we produce are the hunks
+that git produces: Myers' divide-and-conquer algorithm with xdiff's
+heuristics, followed by the sliding of change groups (including the
+indent heuristic) that makes diffs read naturally.
+*/
+package diff
+
+import (
+	"strings"
+)
+
+// ================================================================= //
+// LINE DIFF
+// ================================================================= //
+
+// Change is a group of changed lines: Del lines of the old file,
+// starting at line A, were replaced by Ins lines of the new file,
+// starting at line B. Lines are numbered from 0.
+type Change struct {
+	A, B     int
+	Del, Ins int
+}
+
+// LineDiff is the result of comparing the lines of two files.
+type LineDiff struct {
+	A, B    []string // the lines, including their line feeds
+	Changes []Change
+}
+
+// DiffLines compares two files line by line, using Myers'
+// algorithm.
+func DiffLines(a, b []byte) *LineDiff {
+	return Myers.Diff(a, b)
+}
+
+// diffRecords compares two lists of records with Myers' algorithm.
+// Without the indent heuristic, groups of changes are slid down
+// as far as they go, as xdiff does without XDF_INDENT_HEURISTIC.
+func diffRecords(a, b []string, heuristic bool) *LineDiff {
+	env := newDiffEnv(a, b)
+	env.heuristic = heuristic
+	env.trimEnds()
+	env.cleanupRecords()
+	env.myers(false)
+	return env.result()
+}
+
+// Equal returns true if the files have no differences.
+func (d *LineDiff) Equal() bool {
+	return len(d.Changes) == 0
+}
+
+// Stat returns the number of lines that were added and
+// removed.
+func (d *LineDiff) Stat() (added, removed int) {
+	for _, c := range d.Changes {
+		added += c.Ins
+		removed += c.Del
+	}
+	return
+}
+
+// SplitLines splits data into lines, keeping the line feeds. The
+// last line has no line feed if the data doesn't end with one.
+func SplitLines(data []byte) []string {
+	s := string(data)
+	lines := make([]string, 0, strings.Count(s, "\n")+1)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// ================================================================= //
+// DIFF ENVIRONMENT
+// ================================================================= //
+
+// xdiff's tuning constants
+const (
+	maxEqLimit     = 1024
+	simscanWindow  = 100
+	kpdisRun       = 4
+	maxCostMin     = 256
+	snakeCnt       = 20
+	heurMinCost    = 256
+	kHeur          = 4
+	lineMax        = int(^uint(0) >> 1)
+	indentMaxSlide = 100
+)
+
+// diffFile is one side of a diff, as xdiff's xdfile_t.
+type diffFile struct {
+	recs   []string
+	ha     []int  // the equivalence class of each record
+	rchg   []bool // changed records, offset by one for sentinels
+	rindex []int  // records that take part in the diff...
+	rha    []int  // ...and their classes
+	dstart int
+	dend   int
+}
+
+func (f *diffFile) nrec() int {
+	return len(f.recs)
+}
+
+func (f *diffFile) changed(i int) bool {
+	return f.rchg[i+1]
+}
+
+func (f *diffFile) setChanged(i int, v bool) {
+	f.rchg[i+1] = v
+}
+
+// diffEnv holds both sides of a diff and the counts of each
+// equivalence class of lines on either side.
+type diffEnv struct {
+	f1, f2     diffFile
+	len1, len2 []int
+	heuristic  bool // whether to use the indent heuristic
+}
+
+func newDiffEnv(a, b []string) *diffEnv {
+	env := &diffEnv{heuristic: true}
+	classes := make(map[string]int)
+	classify := func(recs []string, counts *[]int, other *[]int) []int {
+		ha := make([]int, len(recs))
+		for i, rec := range recs {
+			c, ok := classes[rec]
+			if !ok {
+				c = len(classes)
+				classes[rec] = c
+				env.len1 = append(env.len1, 0)
+				env.len2 = append(env.len2, 0)
+			}
+			(*counts)[c]++
+			ha[i] = c
+		}
+		return ha
+	}
+	env.f1 = diffFile{recs: a, rchg: make([]bool, len(a)+2)}
+	env.f2 = diffFile{recs: b, rchg: make([]bool, len(b)+2)}
+	env.f1.ha = classify(a, &env.len1, &env.len2)
+	env.f2.ha = classify(b, &env.len2, &env.len1)
+	env.f1.dstart, env.f1.dend = 0, len(a)-1
+	env.f2.dstart, env.f2.dend = 0, len(b)-1
+	return env
+}
+
+// trimEnds leaves the common prefix and suffix of the files
+// out of the diff.
+func (env *diffEnv) trimEnds() {
+	f1, f2 := &env.f1, &env.f2
+	lim := f1.nrec()
+	if f2.nrec() < lim {
+		lim = f2.nrec()
+	}
+	i := 0
+	for ; i < lim && f1.ha[i] == f2.ha[i]; i++ {
+	}
+	f1.dstart, f2.dstart = i, i
+	lim -= i
+	for i = 0; i < lim && f1.ha[f1.nrec()-1-i] == f2.ha[f2.nrec()-1-i]; i++ {
+	}
+	f1.dend = f1.nrec() - i - 1
+	f2.dend = f2.nrec() - i - 1
+}
+
+// cleanupRecords marks the lines that don't occur on the other
+// side as changed right away, and also the lines that occur too
+// often and are surrounded by such lines; only the remaining
+// lines take part in the diff.
+func (env *diffEnv) cleanupRecords() {
+	dis1 := env.discards(&env.f1, env.len2)
+	dis2 := env.discards(&env.f2, env.len1)
+	env.reduce(&env.f1, dis1)
+	env.reduce(&env.f2, dis2)
+}
+
+func (env *diffEnv) discards(f *diffFile, otherCounts []int) []byte {
+	dis := make([]byte, f.nrec())
+	mlim := bogosqrt(f.nrec())
+	if mlim > maxEqLimit {
+		mlim = maxEqLimit
+	}
+	for i := f.dstart; i <= f.dend; i++ {
+		switch nm := otherCounts[f.ha[i]]; {
+		case nm == 0:
+			dis[i] = 0
+		case nm >= mlim:
+			dis[i] = 2
+		default:
+			dis[i] = 1
+		}
+	}
+	return dis
+}
+
+func (env *diffEnv) reduce(f *diffFile, dis []byte) {
+	f.rindex, f.rha = f.rindex[:0], f.rha[:0]
+	for i := f.dstart; i <= f.dend; i++ {
+		if dis[i] == 1 || (dis[i] == 2 && !cleanMmatch(dis, i, f.dstart, f.dend)) {
+			f.rindex = append(f.rindex, i)
+			f.rha = append(f.rha, f.ha[i])
+		} else {
+			f.setChanged(i, true)
+		}
+	}
+}
+
+// cleanMmatch decides whether a line with many matches on the
+// other side should be discarded, which is the case if it lies
+// in a run of lines that are mostly unmatched.
+func cleanMmatch(dis []byte, i, s, e int) bool {
+	if i-s > simscanWindow {
+		s = i - simscanWindow
+	}
+	if e-i > simscanWindow {
+		e = i + simscanWindow
+	}
+	rdis0, rpdis0 := 0, 1
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			rdis0++
+		} else if dis[i-r] == 2 {
+			rpdis0++
+		} else {
+			break
+		}
+	}
+	if rdis0 == 0 {
+		return false
+	}
+	rdis1, rpdis1 := 0, 1
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			rdis1++
+		} else if dis[i+r] == 2 {
+			rpdis1++
+		} else {
+			break
+		}
+	}
+	if rdis1 == 0 {
+		return false
+	}
+	rdis1 += rdis0
+	rpdis1 += rpdis0
+	return rpdis1*kpdisRun < rpdis1+rdis1
+}
+
+func bogosqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// ================================================================= //
+// MYERS
+// ================================================================= //
+
+type split struct {
+	i1, i2       int
+	minLo, minHi bool
+}
+
+// myersEnv holds the state of xdiff's Myers implementation,
+// which runs over the reduced records of both files.
+type myersEnv struct {
+	ha1, ha2   []int
+	rindex1    []int
+	rindex2    []int
+	f1, f2     *diffFile
+	kvdf, kvdb []int
+	off        int // offset of diagonal 0 in kvdf and kvdb
+	mxcost     int
+}
+
+// myers runs the diff over the reduced records of the files,
+// marking the changed records.
+func (env *diffEnv) myers(needMin bool) {
+	n1, n2 := len(env.f1.rha), len(env.f2.rha)
+	env.myersRange(0, n1, 0, n2, needMin)
+}
+
+// myersRange runs the diff over a range of the reduced records.
+func (env *diffEnv) myersRange(off1, lim1, off2, lim2 int, needMin bool) {
+	n1, n2 := len(env.f1.rha), len(env.f2.rha)
+	ndiags := n1 + n2 + 3
+	m := &myersEnv{
+		ha1:     env.f1.rha,
+		ha2:     env.f2.rha,
+		rindex1: env.f1.rindex,
+		rindex2: env.f2.rindex,
+		f1:      &env.f1,
+		f2:      &env.f2,
+		kvdf:    make([]int, ndiags),
+		kvdb:    make([]int, ndiags),
+		off:     n2 + 1,
+		mxcost:  bogosqrt(ndiags),
+	}
+	if m.mxcost < maxCostMin {
+		m.mxcost = maxCostMin
+	}
+	m.compare(off1, lim1, off2, lim2, needMin)
+}
+
+// compare is xdiff's xdl_recs_cmp.
+func (m *myersEnv) compare(off1, lim1, off2, lim2 int, needMin bool) {
+	ha1, ha2 := m.ha1, m.ha2
+	for off1 < lim1 && off2 < lim2 && ha1[off1] == ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && ha1[lim1-1] == ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			m.f2.setChanged(m.rindex2[off2], true)
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			m.f1.setChanged(m.rindex1[off1], true)
+		}
+	default:
+		spl := m.split(off1, lim1, off2, lim2, needMin)
+		m.compare(off1, spl.i1, off2, spl.i2, spl.minLo)
+		m.compare(spl.i1, lim1, spl.i2, lim2, spl.minHi)
+	}
+}
+
+// split is xdiff's xdl_split, which finds the middle snake of
+// the box, or a good enough split when that becomes expensive.
+func (m *myersEnv) split(off1, lim1, off2, lim2 int, needMin bool) split {
+	ha1, ha2 := m.ha1, m.ha2
+	kvdf := func(d int) *int { return &m.kvdf[d+m.off] }
+	kvdb := func(d int) *int { return &m.kvdb[d+m.off] }
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kvdf(d - 1) >= *kvdf(d + 1) {
+				i1 = *kvdf(d - 1) + 1
+			} else {
+				i1 = *kvdf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > snakeCnt {
+				gotSnake = true
+			}
+			*kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kvdb(d) <= i1 {
+				return split{i1, i2, true, true}
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = lineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = lineMax
+		} else {
+			bmax--
+		}
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kvdb(d - 1) < *kvdb(d + 1) {
+				i1 = *kvdb(d - 1)
+			} else {
+				i1 = *kvdb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > snakeCnt {
+				gotSnake = true
+			}
+			*kvdb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kvdf(d) {
+				return split{i1, i2, true, true}
+			}
+		}
+
+		if needMin {
+			continue
+		}
+
+		// with a good snake and a high cost, settle for a
+		// diagonal that has come a long way
+		if gotSnake && ec > heurMinCost {
+			best, spl := 0, split{}
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > kHeur*ec && v > best &&
+					off1+snakeCnt <= i1 && i1 < lim1 &&
+					off2+snakeCnt <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k++ {
+						if k == snakeCnt {
+							best = v
+							spl.i1, spl.i2 = i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				spl.minLo, spl.minHi = true, false
+				return spl
+			}
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > kHeur*ec && v > best &&
+					off1 < i1 && i1 <= lim1-snakeCnt &&
+					off2 < i2 && i2 <= lim2-snakeCnt {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k++ {
+						if k == snakeCnt-1 {
+							best = v
+							spl.i1, spl.i2 = i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				spl.minLo, spl.minHi = false, true
+				return spl
+			}
+		}
+
+		// enough is enough: take the furthest reaching path
+		if ec >= m.mxcost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := *kvdf(d)
+				if i1 > lim1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+			bbest, bbest1 := lineMax, lineMax
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := *kvdb(d)
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return split{fbest1, fbest - fbest1, true, false}
+			}
+			return split{bbest1, bbest - bbest1, false, true}
+		}
+	}
+}
+
+// ================================================================= //
+// COMPACTION
+// ================================================================= //
+
+// group is a run of changed records, from start to end
+// (exclusive), which may be empty.
+type group struct {
+	start, end int
+}
+
+func (f *diffFile) groupInit() group {
+	g := group{}
+	for f.changed(g.end) {
+		g.end++
+	}
+	return g
+}
+
+func (f *diffFile) groupNext(g *group) bool {
+	if g.end == f.nrec() {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; f.changed(g.end); g.end++ {
+	}
+	return true
+}
+
+func (f *diffFile) groupPrevious(g *group) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; f.changed(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+func (f *diffFile) groupSlideDown(g *group) bool {
+	if g.end < f.nrec() && f.ha[g.start] == f.ha[g.end] {
+		f.setChanged(g.start, false)
+		f.setChanged(g.end, true)
+		g.start++
+		g.end++
+		for f.changed(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (f *diffFile) groupSlideUp(g *group) bool {
+	if g.start > 0 && f.ha[g.start-1] == f.ha[g.end-1] {
+		g.start--
+		g.end--
+		f.setChanged(g.start, true)
+		f.setChanged(g.end, false)
+		for f.changed(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// compact slides the groups of changes in f up and down to line
+// them up with the changes in the other file, or otherwise to the
+// place where the indent heuristic, if it is used, finds them most
+// readable.
+func (f *diffFile) compact(o *diffFile, heuristic bool) {
+	g, go_ := f.groupInit(), o.groupInit()
+	for {
+		if g.end != g.start {
+			var groupSize, earliestEnd, endMatchingOther int
+			for {
+				groupSize = g.end - g.start
+				endMatchingOther = -1
+				for f.groupSlideUp(&g) {
+					o.groupPrevious(&go_)
+				}
+				earliestEnd = g.end
+				if go_.end > go_.start {
+					endMatchingOther = g.end
+				}
+				for f.groupSlideDown(&g) {
+					o.groupNext(&go_)
+					if go_.end > go_.start {
+						endMatchingOther = g.end
+					}
+				}
+				if groupSize == g.end-g.start {
+					break
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+				// no shifting was possible
+			case endMatchingOther != -1:
+				for go_.end == go_.start {
+					f.groupSlideUp(&g)
+					o.groupPrevious(&go_)
+				}
+			case heuristic:
+				shift, bestShift := earliestEnd, -1
+				if g.end-groupSize-1 > shift {
+					shift = g.end - groupSize - 1
+				}
+				if g.end-indentMaxSlide > shift {
+					shift = g.end - indentMaxSlide
+				}
+				var best splitScore
+				for ; shift <= g.end; shift++ {
+					var score splitScore
+					score.add(f.measureSplit(shift))
+					score.add(f.measureSplit(shift - groupSize))
+					if bestShift == -1 || score.cmp(&best) <= 0 {
+						best, bestShift = score, shift
+					}
+				}
+				for g.end > bestShift {
+					f.groupSlideUp(&g)
+					o.groupPrevious(&go_)
+				}
+			}
+		}
+		if !f.groupNext(&g) {
+			break
+		}
+		o.groupNext(&go_)
+	}
+}
+
+// ================================================================= //
+// INDENT HEURISTIC
+// ================================================================= //
+
+const (
+	maxIndent = 200
+	maxBlanks = 20
+
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+	indentWeight                    = 60
+)
+
+type splitMeasurement struct {
+	endOfFile  bool
+	indent     int
+	preBlank   int
+	preIndent  int
+	postBlank  int
+	postIndent int
+}
+
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+// getIndent returns the width of the leading whitespace of a
+// line, or -1 if the line is blank.
+func getIndent(rec string) int {
+	ret := 0
+	for i := 0; i < len(rec); i++ {
+		c := rec[i]
+		switch c {
+		case ' ':
+			ret++
+		case '\t':
+			ret += 8 - ret%8
+		case '\n', '\r', '\v', '\f':
+		default:
+			return ret
+		}
+		if ret >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+func (f *diffFile) measureSplit(split int) (m splitMeasurement) {
+	if split >= f.nrec() {
+		m.endOfFile = true
+		m.indent = -1
+	} else {
+		m.indent = getIndent(f.recs[split])
+	}
+	m.preIndent = -1
+	for i := split - 1; i >= 0; i-- {
+		if m.preIndent = getIndent(f.recs[i]); m.preIndent != -1 {
+			break
+		}
+		if m.preBlank++; m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+	m.postIndent = -1
+	for i := split + 1; i < f.nrec(); i++ {
+		if m.postIndent = getIndent(f.recs[i]); m.postIndent != -1 {
+			break
+		}
+		if m.postBlank++; m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+	return
+}
+
+func (s *splitScore) add(m splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	s.penalty += totalBlankWeight * totalBlank
+	s.penalty += postBlankWeight * postBlank
+
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	s.effectiveIndent += indent
+
+	switch {
+	case indent == -1, m.preIndent == -1, indent == m.preIndent:
+	case indent > m.preIndent:
+		s.penalty += pick(anyBlanks, relativeIndentWithBlankPenalty, relativeIndentPenalty)
+	case m.postIndent != -1 && m.postIndent > indent:
+		s.penalty += pick(anyBlanks, relativeOutdentWithBlankPenalty, relativeOutdentPenalty)
+	default:
+		s.penalty += pick(anyBlanks, relativeDedentWithBlankPenalty, relativeDedentPenalty)
+	}
+}
+
+func (s *splitScore) cmp(o *splitScore) int {
+	cmpIndents := 0
+	if s.effectiveIndent > o.effectiveIndent {
+		cmpIndents = 1
+	} else if s.effectiveIndent < o.effectiveIndent {
+		cmpIndents = -1
+	}
+	return indentWeight*cmpIndents + (s.penalty - o.penalty)
+}
+
+func pick(cond bool, a, b int) int {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// ================================================================= //
+// EDIT SCRIPT
+// ================================================================= //
+
+// result compacts the changes and collects them into a
+// LineDiff.
+func (env *diffEnv) result() *LineDiff {
+	f1, f2 := &env.f1, &env.f2
+	f1.compact(f2, env.heuristic)
+	f2.compact(f1, env.heuristic)
+	d := &LineDiff{A: f1.recs, B: f2.recs}
+	i1, i2 := 0, 0
+	for i1 < f1.nrec() || i2 < f2.nrec() {
+		if f1.changed(i1) || f2.changed(i2) {
+			c := Change{A: i1, B: i2}
+			for ; f1.changed(i1); i1++ {
+				c.Del++
+			}
+			for ; f2.changed(i2); i2++ {
+				c.Ins++
+			}
+			d.Changes = append(d.Changes, c)
+			continue
+		}
+		i1++
+		i2++
+	}
+	return d
+}
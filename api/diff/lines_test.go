@@ -0,0 +1,69 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+lines_test.go implements tests for line diffs and hunks.
+*/
+package diff
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/util"
+	"testing"
+)
+
+func Test_SplitLines(t *testing.T) {
+	util.AssertEqualInt(t, 0, len(SplitLines(nil)))
+	lines := SplitLines([]byte("a\nb\n\nc"))
+	util.AssertEqualInt(t, 4, len(lines))
+	util.AssertEqualString(t, "a\n", lines[0])
+	util.AssertEqualString(t, "\n", lines[2])
+	util.AssertEqualString(t, "c", lines[3])
+}
+
+func Test_DiffLines(t *testing.T) {
+	d := DiffLines([]byte("a\nb\nc\n"), []byte("a\nb\nc\n"))
+	util.Assert(t, d.Equal())
+
+	d = DiffLines([]byte("a\nb\nc\nd\n"), []byte("a\nx\nc\nd\ne\n"))
+	util.AssertEqualInt(t, 2, len(d.Changes))
+	util.Assert(t, d.Changes[0] == Change{A: 1, B: 1, Del: 1, Ins: 1})
+	util.Assert(t, d.Changes[1] == Change{A: 4, B: 4, Del: 0, Ins: 1})
+	added, removed := d.Stat()
+	util.AssertEqualInt(t, 2, added)
+	util.AssertEqualInt(t, 1, removed)
+}
+
+// Test_compaction checks that an inserted block is slid to
+// where it reads naturally, as git does.
+func Test_compaction(t *testing.T) {
+	a := "if a {\n\tx()\n}\n"
+	b := "if a {\n\tx()\n}\nif b {\n\tx()\n}\n"
+	d := DiffLines([]byte(a), []byte(b))
+	util.AssertEqualInt(t, 1, len(d.Changes))
+	util.Assert(t, d.Changes[0] == Change{A: 3, B: 3, Del: 0, Ins: 3})
+}
+
+func Test_Hunks(t *testing.T) {
+	a := "func f() {\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n}"
+	b := "func f() {\n1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n}\n"
+
+	hunks := DiffLines([]byte(a), []byte(b)).Hunks(2)
+	util.AssertEqualInt(t, 2, len(hunks))
+	util.AssertEqualString(t, "@@ -2,5 +2,5 @@ func f() {", hunks[0].Header())
+	util.AssertEqualString(t, "@@ -10,3 +10,3 @@ func f() {", hunks[1].Header())
+
+	buf := new(bytes.Buffer)
+	hunks[1].WriteTo(buf)
+	util.AssertEqualString(t, "@@ -10,3 +10,3 @@ func f() {\n 9\n 10\n-}\n"+NoNewline+"\n+}\n", buf.String())
+
+	// with more context, the changes share a hunk
+	hunks = DiffLines([]byte(a), []byte(b)).Hunks(4)
+	util.AssertEqualInt(t, 1, len(hunks))
+	util.AssertEqualString(t, "@@ -1,12 +1,12 @@", hunks[0].Header())
+}
@@ -0,0 +1,475 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+merge.go implements the three-way merge of file contents, as git's
+xdl_merge does. Both sides are compared against their base, changes that
+only one side made are taken from that side, and changes that overlap
+become conflicts, which are shown between conflict markers, with or
+without their base, unless they are resolved in favor of either side.
+*/
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"strings"
+	"unicode"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+// DefaultMarkerSize is the length of conflict markers.
+const DefaultMarkerSize = 7
+
+// ErrBinaryMerge is returned when binary files are merged.
+var ErrBinaryMerge = errors.New("cannot merge binary files")
+
+// MergeLevel is how hard a merge tries to keep its conflicts
+// small.
+type MergeLevel int
+
+const (
+	MergeMinimal      MergeLevel = iota + 1 // every overlap of changes is a conflict
+	MergeEager                              // identical changes are not conflicts
+	MergeZealous                            // conflicts are narrowed down to the lines that differ
+	MergeZealousAlnum                       // and are joined when they are only separated by lines without letters or digits
+)
+
+// ConflictStyle is how conflicts are shown.
+type ConflictStyle int
+
+const (
+	StyleMerge        ConflictStyle = iota // the two sides are shown
+	StyleDiff3                             // the base is shown between them
+	StyleZealousDiff3                      // as diff3, but lines that both sides share at the ends of conflicts are left out of them
+)
+
+var conflictStyleNames = map[ConflictStyle]string{
+	StyleMerge:        "merge",
+	StyleDiff3:        "diff3",
+	StyleZealousDiff3: "zdiff3",
+}
+
+// ParseConflictStyle returns the conflict style of the given name,
+// as accepted by merge.conflictStyle.
+func ParseConflictStyle(name string) (ConflictStyle, error) {
+	for style, styleName := range conflictStyleNames {
+		if name == styleName {
+			return style, nil
+		}
+	}
+	return StyleMerge, fmt.Errorf("unknown conflict style: %s", name)
+}
+
+func (style ConflictStyle) String() string {
+	return conflictStyleNames[style]
+}
+
+// MergeFavor is how conflicts are resolved, if at all. The values
+// are those of the chunks that conflicts become.
+type MergeFavor int
+
+const (
+	FavorNone   MergeFavor = iota // conflicts are kept
+	FavorOurs                     // conflicts are resolved with our side
+	FavorTheirs                   // conflicts are resolved with their side
+	FavorUnion                    // conflicts are resolved with both sides, ours first
+)
+
+// the ways in which a chunk of the merge is resolved
+const (
+	chunkConflict = iota
+	chunkOurs     // only our side changed it
+	chunkTheirs   // only their side changed it
+	chunkBoth     // both changes are taken, ours first
+	chunkSame     // both sides made the same change
+)
+
+// ================================================================= //
+// MERGE
+// ================================================================= //
+
+// MergeOptions are the options of a three-way merge.
+type MergeOptions struct {
+	// the labels that follow the conflict markers of our side,
+	// of their side and of the base, which may be empty
+	Ours, Theirs, Base string
+
+	// MarkerSize is the length of conflict markers, or 0 for
+	// DefaultMarkerSize.
+	MarkerSize int
+
+	// Level is how hard the merge tries to keep conflicts small,
+	// or 0 for MergeZealous. The diff3 style goes no further than
+	// MergeEager, since the base of conflicts is shown.
+	Level MergeLevel
+
+	Style ConflictStyle
+	Favor MergeFavor
+}
+
+// mergeChunk is a part of the base that was changed by either
+// side, or by both. Lines are numbered from 0 in the base (i0), in
+// our side (i1) and in their side (i2), and the chg fields count
+// the lines of the chunk on each of them.
+type mergeChunk struct {
+	mode             int
+	i0, i1, i2       int
+	chg0, chg1, chg2 int
+}
+
+// merger holds the lines of the three sides of a merge.
+type merger struct {
+	base, ours, theirs []string
+	opts               MergeOptions
+}
+
+// Merge merges the changes that our side and their side made to
+// the base, and returns the result along with the number of
+// conflicts in it.
+func Merge(base, ours, theirs []byte, opts MergeOptions) ([]byte, int) {
+	m := &merger{
+		base:   SplitLines(base),
+		ours:   SplitLines(ours),
+		theirs: SplitLines(theirs),
+		opts:   opts,
+	}
+	if m.opts.MarkerSize <= 0 {
+		m.opts.MarkerSize = DefaultMarkerSize
+	}
+	if m.opts.Level == 0 {
+		m.opts.Level = MergeZealous
+	}
+	if m.opts.Style == StyleDiff3 && m.opts.Level > MergeEager {
+		m.opts.Level = MergeEager
+	}
+	d1 := diffRecords(m.base, m.ours, false)
+	d2 := diffRecords(m.base, m.theirs, false)
+	switch {
+	case d1.Equal():
+		return theirs, 0
+	case d2.Equal():
+		return ours, 0
+	}
+	chunks := m.chunks(d1.Changes, d2.Changes)
+	switch {
+	case m.opts.Style == StyleZealousDiff3:
+		m.trimConflicts(chunks)
+	case m.opts.Level >= MergeZealous:
+		chunks = m.refineConflicts(chunks)
+		chunks = m.simplifyNonConflicts(chunks)
+	}
+	return m.fill(chunks)
+}
+
+// MergeBlobs merges the changes that our side and their side made
+// to the base, as Merge does. A nil blob is empty, as is the base
+// of files that both sides added. Binary blobs are not merged, and
+// ErrBinaryMerge is returned for them.
+func MergeBlobs(base, ours, theirs *objects.Blob, opts MergeOptions) ([]byte, int, error) {
+	var data [3][]byte
+	for i, b := range []*objects.Blob{base, ours, theirs} {
+		if b == nil {
+			continue
+		}
+		if data[i] = b.Data(); IsBinary(data[i]) {
+			return nil, 0, ErrBinaryMerge
+		}
+	}
+	merged, conflicts := Merge(data[0], data[1], data[2], opts)
+	return merged, conflicts, nil
+}
+
+// chunks lines up the changes of both sides, as git's xdl_do_merge
+// does. Changes that overlap, or that touch each other, end up in
+// the same chunk.
+func (m *merger) chunks(x1, x2 []Change) []*mergeChunk {
+	var chunks []*mergeChunk
+	add := func(mode, i0, chg0, i1, chg1, i2, chg2 int) {
+		if n := len(chunks); n > 0 {
+			c := chunks[n-1]
+			if i1 <= c.i1+c.chg1 || i2 <= c.i2+c.chg2 {
+				if mode != c.mode {
+					c.mode = chunkConflict
+				}
+				c.chg0 = i0 + chg0 - c.i0
+				c.chg1 = i1 + chg1 - c.i1
+				c.chg2 = i2 + chg2 - c.i2
+				return
+			}
+		}
+		chunks = append(chunks, &mergeChunk{mode, i0, i1, i2, chg0, chg1, chg2})
+	}
+
+	for len(x1) > 0 && len(x2) > 0 {
+		a, b := x1[0], x2[0]
+		if a.A+a.Del < b.A {
+			add(chunkOurs, a.A, a.Del, a.B, a.Ins, b.B-b.A+a.A, a.Del)
+			x1 = x1[1:]
+			continue
+		}
+		if b.A+b.Del < a.A {
+			add(chunkTheirs, b.A, b.Del, a.B-a.A+b.A, b.Del, b.B, b.Ins)
+			x2 = x2[1:]
+			continue
+		}
+		if m.opts.Level == MergeMinimal || a.A != b.A || a.Del != b.Del || a.Ins != b.Ins ||
+			!equalLines(m.ours[a.B:a.B+a.Ins], m.theirs[b.B:b.B+b.Ins]) {
+			off := a.A - b.A
+			ffo := off + a.Del - b.Del
+			i0, i1, i2 := a.A, a.B, b.B
+			if off > 0 {
+				i0 -= off
+				i1 -= off
+			} else {
+				i2 += off
+			}
+			chg0 := a.A + a.Del - i0
+			chg1 := a.B + a.Ins - i1
+			chg2 := b.B + b.Ins - i2
+			if ffo < 0 {
+				chg0 -= ffo
+				chg1 -= ffo
+			} else {
+				chg2 += ffo
+			}
+			add(chunkConflict, i0, chg0, i1, chg1, i2, chg2)
+		}
+		end1, end2 := a.A+a.Del, b.A+b.Del
+		if end1 >= end2 {
+			x2 = x2[1:]
+		}
+		if end2 >= end1 {
+			x1 = x1[1:]
+		}
+	}
+	for _, a := range x1 {
+		add(chunkOurs, a.A, a.Del, a.B, a.Ins, a.A+len(m.theirs)-len(m.base), a.Del)
+	}
+	for _, b := range x2 {
+		add(chunkTheirs, b.A, b.Del, b.A+len(m.ours)-len(m.base), b.Del, b.B, b.Ins)
+	}
+	return chunks
+}
+
+// refineConflicts compares the two sides of every conflict, so
+// that only the lines that really differ remain in conflict.
+// Conflicts whose sides are the same are no conflicts at all.
+func (m *merger) refineConflicts(chunks []*mergeChunk) []*mergeChunk {
+	var refined []*mergeChunk
+	for _, c := range chunks {
+		if c.mode != chunkConflict || c.chg1 == 0 || c.chg2 == 0 {
+			refined = append(refined, c)
+			continue
+		}
+		d := diffRecords(m.ours[c.i1:c.i1+c.chg1], m.theirs[c.i2:c.i2+c.chg2], false)
+		if d.Equal() {
+			c.mode = chunkSame
+			refined = append(refined, c)
+			continue
+		}
+		for _, x := range d.Changes {
+			refined = append(refined, &mergeChunk{
+				mode: chunkConflict,
+				i0:   c.i0, chg0: c.chg0,
+				i1: c.i1 + x.A, chg1: x.Del,
+				i2: c.i2 + x.B, chg2: x.Ins,
+			})
+		}
+	}
+	return refined
+}
+
+// trimConflicts takes the lines that both sides of a conflict start
+// or end with out of it, which is all that the zdiff3 style refines,
+// since the base of conflicts is shown.
+func (m *merger) trimConflicts(chunks []*mergeChunk) {
+	for _, c := range chunks {
+		if c.mode != chunkConflict {
+			continue
+		}
+		for c.chg1 > 0 && c.chg2 > 0 && m.ours[c.i1] == m.theirs[c.i2] {
+			c.i1, c.chg1 = c.i1+1, c.chg1-1
+			c.i2, c.chg2 = c.i2+1, c.chg2-1
+		}
+		for c.chg1 > 0 && c.chg2 > 0 && m.ours[c.i1+c.chg1-1] == m.theirs[c.i2+c.chg2-1] {
+			c.chg1--
+			c.chg2--
+		}
+	}
+}
+
+// simplifyNonConflicts joins conflicts that are separated by at
+// most three lines, which take up no more room inside of a single
+// conflict. At the alnum level, lines without letters or digits
+// don't keep conflicts apart either.
+func (m *merger) simplifyNonConflicts(chunks []*mergeChunk) []*mergeChunk {
+	for i := 0; i+1 < len(chunks); {
+		c, next := chunks[i], chunks[i+1]
+		begin, end := c.i1+c.chg1, next.i1
+		if c.mode != chunkConflict || next.mode != chunkConflict ||
+			(end-begin > 3 && (m.opts.Level < MergeZealousAlnum || containAlnum(m.ours[begin:end]))) {
+			i++
+			continue
+		}
+		c.chg1 = next.i1 + next.chg1 - c.i1
+		c.chg2 = next.i2 + next.chg2 - c.i2
+		chunks = append(chunks[:i+1], chunks[i+2:]...)
+	}
+	return chunks
+}
+
+// fill writes the result of the merge, taking our side outside of
+// the chunks. Conflicts are resolved as the options favor. It returns
+// the result and the number of conflicts that remain.
+func (m *merger) fill(chunks []*mergeChunk) ([]byte, int) {
+	buf := new(bytes.Buffer)
+	conflicts, i := 0, 0
+	for _, c := range chunks {
+		if c.mode == chunkConflict && m.opts.Favor != FavorNone {
+			c.mode = int(m.opts.Favor)
+		}
+		switch {
+		case c.mode == chunkConflict:
+			conflicts++
+			m.fillConflict(buf, i, c)
+		case c.mode&chunkBoth != 0:
+			copyLines(buf, m.ours[i:c.i1], false, false)
+			if c.mode&chunkOurs != 0 {
+				copyLines(buf, m.ours[c.i1:c.i1+c.chg1], m.needsCR(c), c.mode&chunkTheirs != 0)
+			}
+			if c.mode&chunkTheirs != 0 {
+				copyLines(buf, m.theirs[c.i2:c.i2+c.chg2], false, false)
+			}
+		default:
+			continue
+		}
+		i = c.i1 + c.chg1
+	}
+	copyLines(buf, m.ours[i:], false, false)
+	return buf.Bytes(), conflicts
+}
+
+// fillConflict writes the lines before a conflict, which start at
+// our line i, and then the conflict between its markers.
+func (m *merger) fillConflict(buf *bytes.Buffer, i int, c *mergeChunk) {
+	eol := "\n"
+	if m.needsCR(c) {
+		eol = "\r\n"
+	}
+	marker := func(c byte, label string) {
+		buf.WriteString(strings.Repeat(string(c), m.opts.MarkerSize))
+		if label != "" {
+			buf.WriteString(" " + label)
+		}
+		buf.WriteString(eol)
+	}
+	copyLines(buf, m.ours[i:c.i1], false, false)
+	marker('<', m.opts.Ours)
+	copyLines(buf, m.ours[c.i1:c.i1+c.chg1], eol != "\n", true)
+	if m.opts.Style != StyleMerge {
+		marker('|', m.opts.Base)
+		copyLines(buf, m.base[c.i0:c.i0+c.chg0], eol != "\n", true)
+	}
+	marker('=', "")
+	copyLines(buf, m.theirs[c.i2:c.i2+c.chg2], eol != "\n", true)
+	marker('>', m.opts.Theirs)
+}
+
+// needsCR returns true if the conflict markers of a chunk end in
+// CR LF, which they do if the lines before the chunk on both sides,
+// and the first line of the base, end that way.
+func (m *merger) needsCR(c *mergeChunk) bool {
+	prev := func(i int) int {
+		if i > 0 {
+			return i - 1
+		}
+		return 0
+	}
+	needsCR := eolCRLF(m.ours, prev(c.i1))
+	if needsCR != 0 {
+		needsCR = eolCRLF(m.theirs, prev(c.i2))
+	}
+	if needsCR != 0 {
+		needsCR = eolCRLF(m.base, 0)
+	}
+	return needsCR > 0
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+// eolCRLF returns 1 if line i ends in CR LF, 0 if it ends in a bare
+// line feed, and -1 if that can't be told. A last line that has no
+// line feed ends the way that the line before it does.
+func eolCRLF(lines []string, i int) int {
+	crlf := func(line string) int {
+		if strings.HasSuffix(line, "\r\n") {
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case i < len(lines)-1:
+		return crlf(lines[i])
+	case len(lines) == 0:
+		return -1
+	case strings.HasSuffix(lines[i], "\n"):
+		return crlf(lines[i])
+	case i == 0:
+		return -1
+	}
+	return crlf(lines[i-1])
+}
+
+// copyLines writes lines. If addNewline is true, a last line that
+// has no line feed gets one, which is preceded by a carriage return
+// if crlf is true.
+func copyLines(buf *bytes.Buffer, lines []string, crlf, addNewline bool) {
+	for _, line := range lines {
+		buf.WriteString(line)
+	}
+	if n := len(lines); addNewline && n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		if crlf {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+// equalLines returns true if the two lists of lines are the same.
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// containAlnum returns true if any of the lines has a letter or
+// a digit.
+func containAlnum(lines []string) bool {
+	for _, line := range lines {
+		for i := 0; i < len(line); i++ {
+			if c := rune(line[i]); c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+				return true
+			}
+		}
+	}
+	return false
+}
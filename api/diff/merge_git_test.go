@@ -0,0 +1,91 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+merge_git_test.go implements git-comparison tests for the three-way merge
+of file contents.
+*/
+package diff
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test_Merge compares merges with the output of git-merge-file,
+// in every conflict style, favoring either side or neither.
+func Test_Merge(t *testing.T) {
+	testCase := test.MergeFiles
+	dir := testCase.Repo()
+
+	flags := []string{"", "--diff3", "--zdiff3", "--ours", "--theirs", "--union", "--marker-size=3"}
+	for i, c := range testCase.Info().(*test.InfoMergeFiles).Sides {
+		sides := path.Join(dir, fmt.Sprint(i))
+		for _, flag := range flags {
+			opts := MergeOptions{Ours: "ours", Base: "base", Theirs: "theirs", Level: MergeZealousAlnum}
+			switch flag {
+			case "--diff3":
+				opts.Style = StyleDiff3
+			case "--zdiff3":
+				opts.Style = StyleZealousDiff3
+			case "--ours":
+				opts.Favor = FavorOurs
+			case "--theirs":
+				opts.Favor = FavorTheirs
+			case "--union":
+				opts.Favor = FavorUnion
+			case "--marker-size=3":
+				opts.MarkerSize = 3
+			}
+			args := []string{"merge-file", "-p", "-L", "ours", "-L", "base", "-L", "theirs"}
+			if flag != "" {
+				args = append(args, flag)
+			}
+			args = append(args, path.Join(sides, "ours"), path.Join(sides, "base"), path.Join(sides, "theirs"))
+			expected, err := util.GitExec(dir, args...)
+
+			merged, conflicts := Merge([]byte(c[0]), []byte(c[1]), []byte(c[2]), opts)
+			util.AssertEqualString(t, expected, string(merged))
+			util.Assertf(t, (conflicts > 0) == (err != nil), "expected conflicts only when git finds them (%s)", flag)
+		}
+	}
+}
+
+func Test_ParseConflictStyle(t *testing.T) {
+	for _, name := range []string{"merge", "diff3", "zdiff3"} {
+		style, err := ParseConflictStyle(name)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, name, style.String())
+	}
+	_, err := ParseConflictStyle("diff4")
+	util.Assert(t, err != nil, "expected an unknown style")
+}
+
+func Test_MergeBlobs(t *testing.T) {
+	blob := func(data string) *objects.Blob {
+		return objects.NewBlob(nil, nil, []byte(data))
+	}
+	merged, conflicts, err := MergeBlobs(nil, blob("a\n"), blob("b\n"), MergeOptions{})
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, 1, conflicts)
+	util.AssertEqualString(t, "<<<<<<<\na\n=======\nb\n>>>>>>>\n", string(merged))
+
+	merged, conflicts, err = MergeBlobs(blob("1\n2\n"), blob("0\n1\n2\n"), blob("1\n2\n3\n"), MergeOptions{})
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, 0, conflicts)
+	util.AssertEqualString(t, "0\n1\n2\n3\n", string(merged))
+
+	_, _, err = MergeBlobs(blob("a\n"), blob("\x00b\n"), blob("c\n"), MergeOptions{})
+	util.Assert(t, err == ErrBinaryMerge, "expected binary blobs not to merge")
+	util.Assert(t, strings.Contains(err.Error(), "binary"))
+}
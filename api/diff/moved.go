@@ -0,0 +1,309 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+moved.go implements the detection of moved lines for --color-moved. Lines
+that were removed in one place of a diff and added in another are moved,
+and blocks of them are colored differently from other changes, as
+git's mark_color_as_moved does.
+*/
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ================================================================= //
+// MOVED MODES
+// ================================================================= //
+
+// MovedMode is the way in which moved lines are colored.
+type MovedMode int
+
+const (
+	MovedNo          MovedMode = iota // moved lines are not detected
+	MovedPlain                        // every moved line is colored
+	MovedBlocks                       // blocks of moved lines are colored
+	MovedZebra                        // as blocks, alternating the colors of adjacent blocks
+	MovedDimmedZebra                  // as zebra, dimming the insides of blocks
+	MovedDefault     = MovedZebra
+)
+
+var movedModeNames = map[string]MovedMode{
+	"no":           MovedNo,
+	"default":      MovedDefault,
+	"plain":        MovedPlain,
+	"blocks":       MovedBlocks,
+	"zebra":        MovedZebra,
+	"dimmed-zebra": MovedDimmedZebra,
+	"dimmed_zebra": MovedDimmedZebra,
+}
+
+// ParseMovedMode returns the mode of the given name, as accepted
+// by --color-moved and diff.colorMoved. Booleans are taken to
+// mean no and default.
+func ParseMovedMode(name string) (MovedMode, error) {
+	if mode, ok := movedModeNames[name]; ok {
+		return mode, nil
+	}
+	switch strings.ToLower(name) {
+	case "false", "no", "off", "0":
+		return MovedNo, nil
+	case "true", "yes", "on", "1":
+		return MovedDefault, nil
+	}
+	return MovedNo, fmt.Errorf("color moved setting must be one of 'no', 'default', 'blocks', 'zebra', 'dimmed-zebra', 'plain'")
+}
+
+// MovedWhitespace is the whitespace that is ignored when lines
+// are compared to find moved ones.
+type MovedWhitespace int
+
+const (
+	MovedIgnoreSpaceAtEOL  MovedWhitespace = 1 << iota // whitespace at the ends of lines
+	MovedIgnoreSpaceChange                             // changes in the amount of whitespace
+	MovedIgnoreAllSpace                                // all whitespace
+)
+
+// ParseMovedWhitespace parses the comma-separated list of
+// --color-moved-ws and diff.colorMovedWS, where "no" clears
+// what comes before it.
+func ParseMovedWhitespace(value string) (MovedWhitespace, error) {
+	ws := MovedWhitespace(0)
+	for _, item := range strings.Split(value, ",") {
+		switch strings.TrimSpace(item) {
+		case "no":
+			ws = 0
+		case "ignore-space-at-eol":
+			ws |= MovedIgnoreSpaceAtEOL
+		case "ignore-space-change":
+			ws |= MovedIgnoreSpaceChange
+		case "ignore-all-space":
+			ws |= MovedIgnoreAllSpace
+		case "allow-indentation-change":
+			return 0, fmt.Errorf("color-moved-ws: allow-indentation-change is not supported")
+		default:
+			return 0, fmt.Errorf("unknown color-moved-ws mode '%s', possible values are 'ignore-space-change', 'ignore-space-at-eol', 'ignore-all-space', 'allow-indentation-change'", strings.TrimSpace(item))
+		}
+	}
+	return ws, nil
+}
+
+// normalize returns the form of a line in which lines that are
+// the same but for the ignored whitespace are equal, as xdiff
+// compares them.
+func (ws MovedWhitespace) normalize(line string) string {
+	switch {
+	case ws&MovedIgnoreAllSpace != 0:
+		var b strings.Builder
+		for i := 0; i < len(line); i++ {
+			if !isSpace(line[i]) {
+				b.WriteByte(line[i])
+			}
+		}
+		return b.String()
+	case ws&MovedIgnoreSpaceChange != 0:
+		var b strings.Builder
+		for i := 0; i < len(line); i++ {
+			if !isSpace(line[i]) {
+				b.WriteByte(line[i])
+				continue
+			}
+			for i+1 < len(line) && isSpace(line[i+1]) {
+				i++
+			}
+			if i+1 < len(line) {
+				b.WriteByte(' ')
+			}
+		}
+		return b.String()
+	case ws&MovedIgnoreSpaceAtEOL != 0:
+		return strings.TrimRight(line, " \t\n\r")
+	}
+	return line
+}
+
+// ================================================================= //
+// DETECTION
+// ================================================================= //
+
+// the most alphanumeric characters that a block can have and still
+// be too small to count as moved
+const movedMinAlnum = 20
+
+// movedEntry is a removed or added line, linked to the next line
+// of its block and to the next line of the other kind that is
+// the same.
+type movedEntry struct {
+	n                   int // the index of the symbol
+	nextLine, nextMatch *movedEntry
+}
+
+// markMoved marks the removed and added lines of the symbols of
+// a diff that were moved.
+func markMoved(syms []symbol, mode MovedMode, ws MovedWhitespace) {
+	// the lines of each kind, by their normalized text
+	ids := make(map[string]int)
+	var adds, dels []*movedEntry
+	var prev *movedEntry
+	for n := range syms {
+		s := &syms[n]
+		if s.kind != symPlus && s.kind != symMinus {
+			prev = nil
+			continue
+		}
+		key := ws.normalize(s.text)
+		id, ok := ids[key]
+		if !ok {
+			id = len(ids)
+			ids[key] = id
+			adds, dels = append(adds, nil), append(dels, nil)
+		}
+		s.id = id
+		e := &movedEntry{n: n}
+		if prev != nil && syms[prev.n].kind == s.kind {
+			prev.nextLine = e
+		}
+		prev = e
+		if s.kind == symPlus {
+			e.nextMatch, adds[id] = adds[id], e
+		} else {
+			e.nextMatch, dels[id] = dels[id], e
+		}
+	}
+
+	// the blocks that the current line may continue
+	var pmb []*movedEntry
+	flipped, blockLength := false, 0
+	movedKind := symbolKind(-1)
+	n := 0
+	for ; n < len(syms); n++ {
+		s := &syms[n]
+		var match *movedEntry
+		switch s.kind {
+		case symPlus:
+			match = dels[s.id]
+		case symMinus:
+			match = adds[s.id]
+		default:
+			flipped = false
+		}
+
+		if len(pmb) > 0 && (match == nil || s.kind != movedKind) {
+			if !adjustLastBlock(syms, mode, n, blockLength) && blockLength > 1 {
+				// rewind in case another match starts at the
+				// second line of the block
+				match = nil
+				n -= blockLength
+			}
+			pmb, blockLength, flipped = pmb[:0], 0, false
+		}
+		if match == nil {
+			movedKind = -1
+			continue
+		}
+		if mode == MovedPlain {
+			s.moved |= movedLine
+			continue
+		}
+
+		// keep the blocks that this line continues
+		kept := pmb[:0]
+		for _, e := range pmb {
+			if next := e.nextLine; next != nil && syms[next.n].id == s.id {
+				kept = append(kept, next)
+			}
+		}
+		pmb = kept
+
+		if len(pmb) == 0 {
+			contiguous := adjustLastBlock(syms, mode, n, blockLength)
+			if !contiguous && blockLength > 1 {
+				// rewind as above
+				n -= blockLength
+			} else {
+				for ; match != nil; match = match.nextMatch {
+					pmb = append(pmb, match)
+				}
+			}
+			flipped = contiguous && len(pmb) > 0 && movedKind == s.kind && !flipped
+			if len(pmb) > 0 {
+				movedKind = s.kind
+			} else {
+				movedKind = -1
+			}
+			blockLength = 0
+		}
+		if len(pmb) > 0 {
+			blockLength++
+			s.moved |= movedLine
+			if flipped && mode != MovedBlocks {
+				s.moved |= movedAlternative
+			}
+		}
+	}
+	adjustLastBlock(syms, mode, n, blockLength)
+
+	if mode == MovedDimmedZebra {
+		dimMoved(syms)
+	}
+}
+
+// adjustLastBlock unmarks the block of lines that ends before
+// the nth symbol if it has too few alphanumeric characters, and
+// returns whether it is still marked.
+func adjustLastBlock(syms []symbol, mode MovedMode, n, blockLength int) bool {
+	if mode == MovedPlain {
+		return blockLength > 0
+	}
+	alnum := 0
+	for i := 1; i <= blockLength; i++ {
+		for _, c := range []byte(syms[n-i].text) {
+			if isAlpha(c) || ('0' <= c && c <= '9') {
+				if alnum++; alnum >= movedMinAlnum {
+					return true
+				}
+			}
+		}
+	}
+	for i := 1; i <= blockLength; i++ {
+		syms[n-i].moved &^= movedLine | movedAlternative
+	}
+	return false
+}
+
+// dimMoved marks the moved lines inside of blocks as
+// uninteresting, leaving the ones at the edges of blocks.
+func dimMoved(syms []symbol) {
+	changed := func(n int) *symbol {
+		if n < 0 || n >= len(syms) || (syms[n].kind != symPlus && syms[n].kind != symMinus) {
+			return nil
+		}
+		return &syms[n]
+	}
+	zebra := movedLine | movedAlternative
+	for n := range syms {
+		s := changed(n)
+		if s == nil || s.moved&movedLine == 0 {
+			continue
+		}
+		prev, next := changed(n-1), changed(n+1)
+		if prev != nil && next != nil && prev.moved&zebra == s.moved&zebra && next.moved&zebra == s.moved&zebra {
+			s.moved |= movedUninteresting
+			continue
+		}
+		if prev != nil && prev.moved&movedLine != 0 && prev.moved&movedAlternative != s.moved&movedAlternative {
+			continue
+		}
+		if next != nil && next.moved&movedLine != 0 && next.moved&movedAlternative != s.moved&movedAlternative {
+			continue
+		}
+		s.moved |= movedUninteresting
+	}
+}
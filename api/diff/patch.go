@@ -0,0 +1,414 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+patch.go prints tree diffs as git patches, with "diff --git" headers and
+unified hunks, in a form that git-apply accepts.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/util"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+const (
+	// DevNull is the name of the missing side of a
+	// created or deleted file.
+	DevNull = "/dev/null"
+
+	// the length of abbreviated oids in index lines
+	abbrevLen = 7
+)
+
+// the oid of a missing side, in index lines
+var nullOid = strings.Repeat("0", objects.OidHexSize)
+
+// ================================================================= //
+// BLOB SOURCES
+// ================================================================= //
+
+// BlobSource returns the contents of the file that a
+// tree entry refers to.
+type BlobSource func(e *objects.TreeEntry) ([]byte, error)
+
+// RepoSource reads the contents of entries from the
+// object database of a repository.
+func RepoSource(r api.Repository) BlobSource {
+	return func(e *objects.TreeEntry) ([]byte, error) {
+		if e.Mode() == objects.ModeCommit {
+			return submoduleText(e), nil
+		}
+		o, err := r.ObjectFromOid(e.ObjectId())
+		if err != nil {
+			return nil, err
+		}
+		b, ok := o.(*objects.Blob)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a blob", e.ObjectId())
+		}
+		return b.Data(), nil
+	}
+}
+
+// WorkTreeSource reads the contents of entries from the
+// files of a working tree.
+func WorkTreeSource(wt *api.WorkTree) BlobSource {
+	return func(e *objects.TreeEntry) ([]byte, error) {
+		if e.Mode() == objects.ModeCommit {
+			return submoduleText(e), nil
+		}
+		data, _, err := wt.ReadFile(e.Name())
+		return data, err
+	}
+}
+
+// submoduleText is what git shows as the contents
+// of a submodule.
+func submoduleText(e *objects.TreeEntry) []byte {
+	return []byte(fmt.Sprintf("Subproject commit %s\n", e.ObjectId()))
+}
+
+// ================================================================= //
+// PATCHES
+// ================================================================= //
+
+// PatchOptions control the way in which patches are printed.
+type PatchOptions struct {
+	// Context is the number of context lines around changes.
+	Context int
+
+	// FullIndex prints complete oids in index lines.
+	FullIndex bool
+
+	// Algorithm is the line diff algorithm.
+	Algorithm Algorithm
+
+	// Detector decides which files are binary. If it is nil,
+	// only their contents do.
+	Detector *BinaryDetector
+
+	// Text treats all files as text.
+	Text bool
+
+	// Binary prints binary patches that git-apply can apply,
+	// instead of only saying that binary files differ.
+	Binary bool
+
+	// Colors are the colors of the patch, which is not colored
+	// if they are nil.
+	Colors *Colors
+
+	// Whitespace is the whitespace errors that are highlighted
+	// in the added lines of colored patches.
+	Whitespace WhitespaceRule
+
+	// WordDiff prints the changes of hunks word by word, with
+	// words that match WordRegex, or that are separated by
+	// whitespace if it is nil.
+	WordDiff  WordDiffMode
+	WordRegex *regexp.Regexp
+
+	// Moved colors the lines that were moved in the diff,
+	// comparing them without the whitespace of MovedWhitespace.
+	Moved           MovedMode
+	MovedWhitespace MovedWhitespace
+}
+
+// PatchWriter prints edits as git patches. The old sides of
+// the edits are read from one source and the new sides from
+// another.
+type PatchWriter struct {
+	w             io.Writer
+	before, after BlobSource
+	opts          PatchOptions
+}
+
+// NewPatchWriter returns a writer that prints patches to w.
+func NewPatchWriter(w io.Writer, before, after BlobSource, opts PatchOptions) *PatchWriter {
+	return &PatchWriter{w, before, after, opts}
+}
+
+// WriteDiff prints the patches of all edits of the diff.
+func (pw *PatchWriter) WriteDiff(td *TreeDiff) error {
+	if !pw.markingMoved() {
+		for _, edit := range td.Edits() {
+			if err := pw.WriteEdit(edit); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// lines may move from one file to another, so all of
+	// them are collected before any is printed
+	var syms []symbol
+	for _, edit := range td.Edits() {
+		more, err := pw.symbols(edit)
+		if err != nil {
+			return err
+		}
+		syms = append(syms, more...)
+	}
+	return pw.write(syms)
+}
+
+// WriteEdit prints the patch of a single edit.
+func (pw *PatchWriter) WriteEdit(edit *TreeEdit) error {
+	syms, err := pw.symbols(edit)
+	if err != nil {
+		return err
+	}
+	return pw.write(syms)
+}
+
+// write prints symbols, marking the ones that were moved.
+func (pw *PatchWriter) write(syms []symbol) error {
+	if pw.markingMoved() {
+		markMoved(syms, pw.opts.Moved, pw.opts.MovedWhitespace)
+	}
+	buf := new(bytes.Buffer)
+	pw.writeSymbols(buf, syms)
+	_, err := buf.WriteTo(pw.w)
+	return err
+}
+
+// markingMoved returns true if moved lines are colored, which
+// they are not in word diffs.
+func (pw *PatchWriter) markingMoved() bool {
+	return pw.opts.Colors != nil && pw.opts.Moved != MovedNo && pw.opts.WordDiff == WordDiffNone
+}
+
+// symbols returns the lines of the patch of an edit. A change
+// of type is a deletion followed by a creation, as git prints
+// it.
+func (pw *PatchWriter) symbols(edit *TreeEdit) ([]symbol, error) {
+	switch edit.action {
+	case Unmerged:
+		return []symbol{{kind: symPlain, text: fmt.Sprintf("* Unmerged path %s\n", edit.Before.Name())}}, nil
+	case TypeChange:
+		syms, err := pw.symbols(&TreeEdit{action: Delete, Before: edit.Before})
+		if err != nil {
+			return nil, err
+		}
+		more, err := pw.symbols(&TreeEdit{action: Insert, After: edit.After})
+		return append(syms, more...), err
+	}
+	a, b := edit.Before, edit.After
+	nameA, nameB := pathOf(a, b), pathOf(b, a)
+
+	var syms []symbol
+	meta := func(format string, args ...interface{}) {
+		syms = append(syms, symbol{kind: symMeta, text: fmt.Sprintf(format, args...)})
+	}
+	meta("diff --git %s %s", quotePrefixed("a/", nameA), quotePrefixed("b/", nameB))
+	switch {
+	case a == nil:
+		meta("new file mode %06o", b.Mode())
+	case b == nil:
+		meta("deleted file mode %06o", a.Mode())
+	case a.Mode() != b.Mode():
+		meta("old mode %06o", a.Mode())
+		meta("new mode %06o", b.Mode())
+	}
+	switch edit.action {
+	case Rename, Copy:
+		verb := "rename"
+		if edit.action == Copy {
+			verb = "copy"
+		}
+		meta("similarity index %d%%", int(edit.score))
+		meta("%s from %s", verb, util.QuotePath(nameA))
+		meta("%s to %s", verb, util.QuotePath(nameB))
+	}
+
+	oidA, oidB := nullOid, nullOid
+	if a != nil {
+		oidA = a.ObjectId().String()
+	}
+	if b != nil {
+		oidB = b.ObjectId().String()
+	}
+	if oidA == oidB {
+		return syms, nil
+	}
+	dataA, dataB, err := readSides(pw.before, pw.after, a, b)
+	if err != nil {
+		return nil, err
+	}
+	binary := !pw.opts.Text && (pw.isBinary(a, dataA) || pw.isBinary(b, dataB))
+	// binary patches name the objects in full
+	full := binary && pw.opts.Binary
+	index := fmt.Sprintf("index %s..%s", pw.abbrev(oidA, full), pw.abbrev(oidB, full))
+	if a != nil && b != nil && a.Mode() == b.Mode() {
+		index += fmt.Sprintf(" %06o", a.Mode())
+	}
+	meta("%s", index)
+
+	labelA, labelB := DevNull, DevNull
+	if a != nil {
+		labelA = quotePrefixed("a/", nameA)
+	}
+	if b != nil {
+		labelB = quotePrefixed("b/", nameB)
+	}
+	switch {
+	case binary && pw.opts.Binary:
+		buf := new(bytes.Buffer)
+		if err = writeBinaryPatch(buf, dataA, dataB); err != nil {
+			return nil, err
+		}
+		syms = append(syms, symbol{kind: symPlain, text: buf.String()})
+	case binary:
+		syms = append(syms, symbol{kind: symPlain, text: fmt.Sprintf("Binary files %s and %s differ\n", labelA, labelB)})
+	default:
+		if hunks := pw.opts.Algorithm.Diff(dataA, dataB).Hunks(pw.opts.Context); len(hunks) > 0 {
+			syms = append(syms, symbol{kind: symFileOld, text: labelA}, symbol{kind: symFileNew, text: labelB})
+			syms = append(syms, pw.hunkSymbols(hunks, dataA, dataB)...)
+		}
+	}
+	return syms, nil
+}
+
+// hunkSymbols returns the lines of hunks, or their word diffs.
+// Lines that are missing their line feeds are given one, and
+// are followed by the marker, as xdiff prints them.
+func (pw *PatchWriter) hunkSymbols(hunks []*Hunk, dataA, dataB []byte) []symbol {
+	// added blank lines at the end of the file are only
+	// highlighted in colors
+	eofA, eofB := 0, 0
+	if pw.opts.Colors != nil && pw.opts.Whitespace&WSBlankAtEOF != 0 {
+		eofA, eofB = blankAtEOF(dataA, dataB)
+	}
+
+	var syms []symbol
+	var wd *wordDiffer
+	if pw.opts.WordDiff != WordDiffNone {
+		wd = &wordDiffer{mode: pw.opts.WordDiff, regex: pw.opts.WordRegex, colors: pw.opts.Colors}
+	}
+	flush := func() {
+		if wd == nil {
+			return
+		}
+		buf := new(bytes.Buffer)
+		if wd.flush(buf); buf.Len() > 0 {
+			syms = append(syms, symbol{kind: symWords, text: buf.String()})
+		}
+	}
+
+	for _, h := range hunks {
+		flush()
+		syms = append(syms, symbol{kind: symHunk, text: h.Header()})
+		// like git, count from the starts of the ranges
+		lnoA, lnoB := h.OldStart, h.NewStart
+		for _, l := range h.Lines {
+			text, incomplete := l.Text, !strings.HasSuffix(l.Text, "\n")
+			if incomplete {
+				text += "\n"
+			}
+			if wd != nil {
+				switch l.Op {
+				case '-':
+					wd.minus.WriteString(text)
+				case '+':
+					wd.plus.WriteString(text)
+				default:
+					flush()
+					if pw.opts.WordDiff == WordDiffPorcelain {
+						text = " " + text
+					}
+					syms = append(syms, symbol{kind: symWordsContext, text: text})
+				}
+				continue
+			}
+			switch l.Op {
+			case '-':
+				lnoA++
+				syms = append(syms, symbol{kind: symMinus, text: text})
+			case '+':
+				lnoB++
+				blank := eofA > 0 && eofB > 0 && eofA <= lnoA && eofB <= lnoB && isBlank([]byte(text))
+				syms = append(syms, symbol{kind: symPlus, text: text, blankAtEOF: blank})
+			default:
+				lnoA++
+				lnoB++
+				syms = append(syms, symbol{kind: symContext, text: text})
+			}
+			if incomplete {
+				syms = append(syms, symbol{kind: symIncomplete, text: NoNewline + "\n"})
+			}
+		}
+	}
+	flush()
+	return syms
+}
+
+// readSides returns the contents of both sides of an edit,
+// which are empty for missing sides.
+func readSides(before, after BlobSource, a, b *objects.TreeEntry) (dataA, dataB []byte, err error) {
+	if a != nil {
+		if dataA, err = before(a); err != nil {
+			return
+		}
+	}
+	if b != nil {
+		dataB, err = after(b)
+	}
+	return
+}
+
+func (pw *PatchWriter) abbrev(oid string, full bool) string {
+	if full || pw.opts.FullIndex {
+		return oid
+	}
+	return oid[:abbrevLen]
+}
+
+// isBinary returns true if the side of an edit is binary. A
+// missing side is not.
+func (pw *PatchWriter) isBinary(e *objects.TreeEntry, data []byte) bool {
+	return e != nil && pw.opts.Detector.IsBinary(e.Name(), data)
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+// pathOf returns the path of the entry, or of the other
+// entry if it is missing.
+func pathOf(e, other *objects.TreeEntry) string {
+	if e == nil {
+		return other.Name()
+	}
+	return e.Name()
+}
+
+// quotePrefixed quotes a prefixed path as a whole, as git
+// does in patch headers.
+func quotePrefixed(prefix, pth string) string {
+	return util.QuotePath(prefix + pth)
+}
+
+// labelTab returns the tab that git puts after file labels
+// that contain spaces, so that the end of the name is
+// unambiguous.
+func labelTab(label string) string {
+	if strings.Contains(label, " ") {
+		return "\t"
+	}
+	return ""
+}
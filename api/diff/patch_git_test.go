@@ -0,0 +1,96 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+patch_git_test.go implements git-comparison tests for patches.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test_patchTrees compares the patches between two commits
+// with the output of git-diff.
+func Test_patchTrees(t *testing.T) {
+	testCase := test.Patch
+	dir := testCase.Repo()
+	repo := api.Open(dir)
+
+	ta, err := api.TreeFromRevision(repo, "first")
+	util.AssertNoErrOrDie(t, err)
+	tb, err := api.TreeFromRevision(repo, "second")
+	util.AssertNoErrOrDie(t, err)
+	td, err := DiffTrees(repo, ta, tb, &Options{})
+	util.AssertNoErrOrDie(t, err)
+
+	for _, context := range []int{0, 1, 3, 10} {
+		expected := util.GitNow(dir, "diff", "--no-renames", fmt.Sprintf("-U%d", context), "first", "second")
+		buf := new(bytes.Buffer)
+		err = NewPatchWriter(buf, RepoSource(repo), RepoSource(repo), PatchOptions{Context: context}).WriteDiff(td)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, expected, buf.String())
+	}
+}
+
+// Test_patchIndex compares the patches between HEAD, the
+// index and the working tree with the output of git-diff.
+func Test_patchIndex(t *testing.T) {
+	testCase := test.Patch
+	dir, err := testCase.Clone("__patch_index")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	original := testCase.Info().(*test.InfoPatch).Source
+
+	util.AssertNoErr(t, util.TestFile(dir, "main.go", strings.Replace(original, "i < 10", "i < 20", 1)))
+	util.AssertNoErr(t, util.TestFile(dir, "staged.txt", "staged\n"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir, []string{"add", "main.go", "staged.txt"}))
+	util.AssertNoErr(t, util.TestFile(dir, "main.go", strings.Replace(original, "i < 10", "i < 30", 1)))
+	util.AssertNoErr(t, util.DeleteFile(dir, "gone.txt"))
+	util.AssertNoErr(t, util.TestFile(dir, "nonl.txt", "a\nb\nc\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "untracked.txt", "nobody knows\n"))
+
+	idx, err := repo.Index()
+	util.AssertNoErrOrDie(t, err)
+	head, err := api.TreeFromRevision(repo, "HEAD")
+	util.AssertNoErrOrDie(t, err)
+	wt := api.NewWorkTree(repo)
+	opts := &Options{}
+
+	cached, err := DiffTreeIndex(repo, head, idx, opts)
+	util.AssertNoErrOrDie(t, err)
+	unstaged, err := DiffIndexWorkTree(repo, wt, idx, opts)
+	util.AssertNoErrOrDie(t, err)
+	all, err := DiffTreeWorkTree(repo, head, wt, idx, opts)
+	util.AssertNoErrOrDie(t, err)
+
+	cases := []struct {
+		args  []string
+		diff  *TreeDiff
+		after BlobSource
+	}{
+		{[]string{"diff", "--cached"}, cached, RepoSource(repo)},
+		{[]string{"diff"}, unstaged, WorkTreeSource(wt)},
+		{[]string{"diff", "HEAD"}, all, WorkTreeSource(wt)},
+	}
+	for _, c := range cases {
+		expected := util.GitNow(dir, c.args...)
+		buf := new(bytes.Buffer)
+		err = NewPatchWriter(buf, RepoSource(repo), c.after, PatchOptions{Context: DefaultContext}).WriteDiff(c.diff)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, expected, buf.String())
+	}
+}
@@ -0,0 +1,371 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+patch_ids.go implements the patch ids of diffs, which identify changes
+wherever they were made: a patch id is a hash of the patch of a diff
+without its oids, line numbers and whitespace, so that a commit that was
+picked from one branch onto another has the same patch id as the commit
+that it was picked from. Patch ids are computed from the diffs of commits,
+and from patches that are read as text, as git-patch-id reads them.
+*/
+package diff
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// PATCH IDS
+// ================================================================= //
+
+// PatchIdOptions control the way in which patch ids are computed.
+type PatchIdOptions struct {
+	// Stable sums the hashes of the files of the patch instead of
+	// hashing the patch as a whole, so that the patch id doesn't
+	// depend on the order of the files.
+	Stable bool
+
+	// Detector decides which files are binary, whose oids are
+	// hashed instead of their changes. If it is nil, only their
+	// contents do.
+	Detector *BinaryDetector
+
+	// Verbatim keeps the whitespace of patches that are read as
+	// text.
+	Verbatim bool
+}
+
+// PatchId returns the patch id of a diff, whose files are read
+// from a source, as git computes it for commits. Unmerged paths are
+// left out.
+func PatchId(td *TreeDiff, source BlobSource, opts *PatchIdOptions) (*objects.ObjectId, error) {
+	h := sha1.New()
+	var sum [objects.OidSize]byte
+	for _, edit := range td.Edits() {
+		if edit.action == Unmerged {
+			continue
+		}
+		if err := hashEdit(h, edit, source, opts.Detector); err != nil {
+			return nil, err
+		}
+		if opts.Stable {
+			addHash(&sum, h)
+		}
+	}
+	if !opts.Stable {
+		addHash(&sum, h)
+	}
+	return objects.OidFromArray(sum), nil
+}
+
+// CommitPatchId returns the patch id of the changes that a commit
+// made to its parent, or to the empty tree if it has none. Merges
+// have no patch id, which is nil.
+func CommitPatchId(repo api.Repository, c *objects.Commit, opts *PatchIdOptions) (*objects.ObjectId, error) {
+	parents := c.Parents()
+	if len(parents) > 1 {
+		return nil, nil
+	}
+	var before *objects.Tree
+	if len(parents) == 1 {
+		parent, err := api.CommitFromOid(repo, parents[0])
+		if err != nil {
+			return nil, err
+		}
+		if before, err = api.TreeFromOid(repo, parent.Tree()); err != nil {
+			return nil, err
+		}
+	}
+	after, err := api.TreeFromOid(repo, c.Tree())
+	if err != nil {
+		return nil, err
+	}
+	td, err := DiffTrees(repo, before, after, new(Options))
+	if err != nil {
+		return nil, err
+	}
+	return PatchId(td, RepoSource(repo), opts)
+}
+
+// hashEdit hashes the patch of an edit without whitespace: its
+// header, which is "diff--gita/<path>b/<path>" with the modes that
+// changed, and its file labels and lines, without hunk headers. The
+// oids of binary files are hashed instead of their lines.
+func hashEdit(h hash.Hash, edit *TreeEdit, source BlobSource, detector *BinaryDetector) error {
+	a, b := edit.Before, edit.After
+	nameA, nameB := withoutSpace(pathOf(a, b)), withoutSpace(pathOf(b, a))
+	fmt.Fprintf(h, "diff--gita/%sb/%s", nameA, nameB)
+	switch {
+	case a == nil:
+		fmt.Fprintf(h, "newfilemode%06o", b.Mode())
+	case b == nil:
+		fmt.Fprintf(h, "deletedfilemode%06o", a.Mode())
+	case a.Mode() != b.Mode():
+		fmt.Fprintf(h, "oldmode%06onewmode%06o", a.Mode(), b.Mode())
+	}
+
+	dataA, dataB, err := readSides(source, source, a, b)
+	if err != nil {
+		return err
+	}
+	if a != nil && detector.IsBinary(a.Name(), dataA) || b != nil && detector.IsBinary(b.Name(), dataB) {
+		oidA, oidB := nullOid, nullOid
+		if a != nil {
+			oidA = a.ObjectId().String()
+		}
+		if b != nil {
+			oidB = b.ObjectId().String()
+		}
+		h.Write([]byte(oidA + oidB))
+		return nil
+	}
+	switch {
+	case a == nil:
+		fmt.Fprintf(h, "---%s+++b/%s", DevNull, nameB)
+	case b == nil:
+		fmt.Fprintf(h, "---a/%s+++%s", nameA, DevNull)
+	default:
+		fmt.Fprintf(h, "---a/%s+++b/%s", nameA, nameB)
+	}
+	// like git, without the indent heuristic
+	for _, hunk := range diffRecords(SplitLines(dataA), SplitLines(dataB), false).Hunks(DefaultContext) {
+		for _, l := range hunk.Lines {
+			h.Write([]byte(withoutSpace(string(l.Op) + l.Text)))
+		}
+	}
+	return nil
+}
+
+// addHash adds the hash of what was written to h to a sum, as
+// little-endian numbers, and resets h.
+func addHash(sum *[objects.OidSize]byte, h hash.Hash) {
+	carry := 0
+	for i, b := range h.Sum(nil) {
+		carry += int(sum[i]) + int(b)
+		sum[i] = byte(carry)
+		carry >>= 8
+	}
+	h.Reset()
+}
+
+// withoutSpace returns a string without its whitespace, as patch
+// ids see it.
+func withoutSpace(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\n', '\v', '\f', '\r':
+		default:
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+// ================================================================= //
+// PATCH IDS OF TEXT
+// ================================================================= //
+
+// TextPatchId is the patch id of a patch that was read as text, and
+// the commit that the patch is of, which is the null oid if it wasn't
+// named.
+type TextPatchId struct {
+	PatchId *objects.ObjectId
+	Commit  *objects.ObjectId
+}
+
+// ReadPatchIds reads patches, as git-log -p, git-show and
+// git-format-patch print them, and returns their patch ids, as
+// git-patch-id computes them. A patch starts at a line that names its
+// commit, as in "commit <oid>" or "From <oid>", or with an oid alone,
+// and the log message that comes before its first "diff " line is
+// left out. Patches that hash nothing are skipped.
+func ReadPatchIds(r io.Reader, opts *PatchIdOptions) ([]*TextPatchId, error) {
+	pr := &patchIdReader{r: bufio.NewReader(r), opts: opts}
+	var ids []*TextPatchId
+	commit := objects.OidFromArray([objects.OidSize]byte{})
+	for !pr.eof {
+		id, next, n, err := pr.next()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			ids = append(ids, &TextPatchId{id, commit})
+		}
+		commit = next
+	}
+	return ids, nil
+}
+
+// patchIdReader reads patches one at a time.
+type patchIdReader struct {
+	r    *bufio.Reader
+	opts *PatchIdOptions
+	eof  bool
+}
+
+// next reads a patch, up to the line that names the commit of the
+// next one, if there is one, and returns its patch id, the commit of
+// the next patch, which is the null oid if there is none, and the
+// number of bytes that were hashed.
+func (pr *patchIdReader) next() (id, next *objects.ObjectId, n int, err error) {
+	h := sha1.New()
+	var sum [objects.OidSize]byte
+	next = objects.OidFromArray(sum)
+	before, after := -1, -1
+	binary := false
+	var oidA, oidB string
+	for {
+		line, e := pr.r.ReadString('\n')
+		if e == io.EOF {
+			pr.eof = true
+			if line == "" {
+				break
+			}
+		} else if e != nil {
+			return nil, nil, 0, e
+		}
+
+		// the prefixes of git-log and git-format-patch
+		rest := line
+		if strings.HasPrefix(line, "commit ") {
+			rest = line[len("commit "):]
+		} else if strings.HasPrefix(line, "From ") {
+			rest = line[len("From "):]
+		} else if strings.HasPrefix(line, "\\ ") && len(line) > 12 {
+			if pr.opts.Verbatim {
+				h.Write([]byte(line))
+			}
+			continue
+		}
+		if oid := leadingOid(rest); oid != nil {
+			next = oid
+			break
+		}
+
+		// the log message
+		if n == 0 && !strings.HasPrefix(line, "diff ") {
+			continue
+		}
+
+		// the header of a file
+		if before == -1 {
+			if strings.HasPrefix(line, "GIT binary patch") || strings.HasPrefix(line, "Binary files") {
+				binary, before = true, 0
+				h.Write([]byte(oidA + oidB))
+				if pr.opts.Stable {
+					addHash(&sum, h)
+				}
+				continue
+			} else if strings.HasPrefix(line, "index ") {
+				if i := strings.Index(line, ".."); i >= 0 {
+					j := strings.Index(line[i:], " ")
+					if j < 0 {
+						j = len(line) - 1
+					} else {
+						j += i
+					}
+					oidA, oidB = truncate(line[len("index "):i]), truncate(line[i+2:j])
+				}
+				continue
+			} else if strings.HasPrefix(line, "--- ") {
+				before, after = 1, 1
+			} else if !isAlpha(line[0]) {
+				break
+			}
+		}
+		if binary {
+			if strings.HasPrefix(line, "diff ") {
+				binary, before = false, -1
+			}
+			continue
+		}
+
+		// the end of a hunk
+		if before == 0 && after == 0 {
+			if strings.HasPrefix(line, "@@ -") {
+				before, after = scanHunkHeader(line)
+				continue
+			}
+			if !strings.HasPrefix(line, "diff ") {
+				break
+			}
+			if pr.opts.Stable {
+				addHash(&sum, h)
+			}
+			before, after = -1, -1
+		}
+
+		if line[0] == '-' || line[0] == ' ' {
+			before--
+		}
+		if line[0] == '+' || line[0] == ' ' {
+			after--
+		}
+		if !pr.opts.Verbatim {
+			line = withoutSpace(line)
+		}
+		n += len(line)
+		h.Write([]byte(line))
+	}
+	addHash(&sum, h)
+	return objects.OidFromArray(sum), next, n, nil
+}
+
+// leadingOid returns the oid that a line starts with, or nil.
+func leadingOid(line string) *objects.ObjectId {
+	if len(line) < objects.OidHexSize {
+		return nil
+	}
+	oid, err := objects.OidFromString(line[:objects.OidHexSize])
+	if err != nil {
+		return nil
+	}
+	return oid
+}
+
+// truncate returns at most as many bytes of a string as the longest
+// oid has, as git keeps of the oids of index lines.
+func truncate(s string) string {
+	if len(s) > 64 {
+		return s[:64]
+	}
+	return s
+}
+
+// scanHunkHeader returns the numbers of lines that a hunk has before
+// and after, as in "@@ -1,3 +1,4 @@", which are 1 if they are left
+// out. A header that doesn't parse has no lines after, and no lines
+// before either unless they were read already.
+func scanHunkHeader(line string) (before, after int) {
+	number := func(s string) (int, int, string) {
+		d := len(s) - len(strings.TrimLeft(s, "0123456789"))
+		if d < len(s) && s[d] == ',' {
+			s = s[d+1:]
+			d = len(s) - len(strings.TrimLeft(s, "0123456789"))
+			n, _ := strconv.Atoi(s[:d])
+			return n, d, s[d:]
+		}
+		return 1, d, s[d:]
+	}
+	before, d, rest := number(line[len("@@ -"):])
+	if d == 0 || !strings.HasPrefix(rest, " +") {
+		return before, 0
+	}
+	after, _, _ = number(rest[2:])
+	return before, after
+}
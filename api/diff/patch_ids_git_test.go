@@ -0,0 +1,127 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+patch_ids_git_test.go implements git-comparison tests for the patch ids of
+commits and of patches that are read as text.
+*/
+package diff
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Test_CommitPatchId compares the patch ids of commits with the ones
+// that git-patch-id computes from the output of git-show, both stable
+// and unstable.
+func Test_CommitPatchId(t *testing.T) {
+	dir, err := test.Patch.Clone("__diff_patch_ids")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	original := test.Patch.Info().(*test.InfoPatch).Source
+
+	changed := strings.Replace(original, `"two"`, `"TWO"`, 1)
+	util.AssertNoErr(t, util.TestFile(dir, "main.go", changed))
+	util.AssertNoErr(t, util.TestFile(dir, "sub/dir/deep.txt", "1\n  2\n3\n4\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "sp ace.txt", "x y\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "new.txt", "new\n"))
+	util.AssertNoErr(t, util.DeleteFile(dir, "gone.txt"))
+	util.AssertNoErr(t, util.TestFile(dir, "nonl.txt", "a\nb\nC"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "changes"},
+	))
+
+	for _, rev := range []string{"HEAD~", "HEAD"} {
+		o, err := api.ObjectFromRevision(repo, rev)
+		util.AssertNoErrOrDie(t, err)
+		c, err := api.CommitFromObject(repo, o)
+		util.AssertNoErrOrDie(t, err)
+		for _, stable := range []bool{true, false} {
+			id, err := CommitPatchId(repo, c, &PatchIdOptions{Stable: stable})
+			util.AssertNoErrOrDie(t, err)
+			util.AssertEqualString(t, gitPatchId(t, dir, rev, stable), id.String())
+		}
+	}
+}
+
+// Test_ReadPatchIds compares the patch ids of the patches that
+// git-log -p and git-format-patch print with the ones that
+// git-patch-id computes from them, in all of its modes.
+func Test_ReadPatchIds(t *testing.T) {
+	dir, err := test.Patch.Clone("__diff_read_patch_ids")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	original := test.Patch.Info().(*test.InfoPatch).Source
+
+	changed := strings.Replace(original, `"two"`, `"TWO"`, 1)
+	util.AssertNoErrOrDie(t, util.TestFile(dir, "main.go", changed))
+	util.AssertNoErr(t, util.TestFile(dir, "bin", "x\x00y"))
+	util.AssertNoErr(t, util.TestFile(dir, "nonl.txt", "a\nb\nC"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "changes"},
+	))
+	util.AssertNoErr(t, util.TestFile(dir, "sp ace.txt", "  x  y\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "bin", "x\x00z"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "more changes"},
+	))
+
+	modes := []struct {
+		flag string
+		opts PatchIdOptions
+	}{
+		{"--unstable", PatchIdOptions{}},
+		{"--stable", PatchIdOptions{Stable: true}},
+		{"--verbatim", PatchIdOptions{Stable: true, Verbatim: true}},
+	}
+	for _, patches := range []string{
+		util.GitNow(dir, "log", "-p"),
+		util.GitNow(dir, "log", "-p", "--binary"),
+		util.GitNow(dir, "format-patch", "--stdout", "-2"),
+		util.GitNow(dir, "diff", "HEAD~2"),
+	} {
+		for _, mode := range modes {
+			cmd := exec.Command("git", "patch-id", mode.flag)
+			cmd.Stdin = strings.NewReader(patches)
+			expected, err := cmd.Output()
+			util.AssertNoErrOrDie(t, err)
+			ids, err := ReadPatchIds(strings.NewReader(patches), &mode.opts)
+			util.AssertNoErrOrDie(t, err)
+			actual := ""
+			for _, id := range ids {
+				actual += fmt.Sprintf("%s %s\n", id.PatchId, id.Commit)
+			}
+			util.AssertEqualString(t, string(expected), actual)
+		}
+	}
+}
+
+// gitPatchId returns the patch id that git-patch-id computes for a
+// revision.
+func gitPatchId(t *testing.T, dir, rev string, stable bool) string {
+	mode := "--unstable"
+	if stable {
+		mode = "--stable"
+	}
+	cmd := exec.Command("git", "patch-id", mode)
+	cmd.Stdin = strings.NewReader(util.GitNow(dir, "show", rev))
+	out, err := cmd.Output()
+	util.AssertNoErrOrDie(t, err)
+	return strings.Fields(string(out))[0]
+}
@@ -0,0 +1,590 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+renames.go implements the detection of renamed and copied files, in the
+way of git's diffcore-rename.c. Created files are matched first with
+sources that have the same oid, then with sources that have the same
+basename, and then with every remaining source, by the similarity of
+their contents, which is estimated from their hashed spans.
+*/
+package diff
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"path"
+	"sort"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+const (
+	// MaxScore is the similarity score of identical files.
+	MaxScore = 60000
+
+	// DefaultRenameScore is the smallest similarity of a
+	// rename or copy, which is 50%.
+	DefaultRenameScore = MaxScore / 2
+
+	// DefaultRenameLimit is the default of diff.renameLimit.
+	DefaultRenameLimit = 1000
+
+	// the number of best sources that we remember for each
+	// created file
+	candidatesPerDest = 4
+
+	// the number of identical sources that we look at before
+	// we pick one
+	maxIdenticalSources = 100
+)
+
+// ================================================================= //
+// OPTIONS
+// ================================================================= //
+
+// RenameOptions control the detection of renames and copies.
+type RenameOptions struct {
+	// MinScore is the smallest similarity of a rename or copy,
+	// out of MaxScore. If it is 0, DefaultRenameScore is used.
+	MinScore int
+
+	// Copies looks for created files that were copied from
+	// modified files as well as from deleted ones.
+	Copies bool
+
+	// CopiesHarder looks for copies of unmodified files too,
+	// which is expensive.
+	CopiesHarder bool
+
+	// Limit skips the comparison of contents if the number of
+	// created files times the number of sources is larger than
+	// its square. There is no limit if it is 0.
+	Limit int
+
+	// Relevant, if it is not nil, tells which deleted files are
+	// worth comparing by their contents; the others are only
+	// matched by their oids. Merges, for one, only care about the
+	// renames of files that the other side changed.
+	Relevant func(path string) bool
+}
+
+// NewRenameOptions returns the options of git-diff -M.
+func NewRenameOptions() *RenameOptions {
+	return &RenameOptions{MinScore: DefaultRenameScore, Limit: DefaultRenameLimit}
+}
+
+// ParseRenameScore parses the argument of -M or -C, which is a
+// fraction of one, as in 5 or 0.5 for 50%, or a percentage, as in
+// 50%, into a score out of MaxScore.
+func ParseRenameScore(s string) (int, error) {
+	num, scale, dot := 0, 1, false
+	i := 0
+loop:
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && !dot:
+			scale, dot = 1, true
+		case c == '%':
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+			i++
+			break loop
+		case '0' <= c && c <= '9':
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		default:
+			break loop
+		}
+	}
+	if i != len(s) {
+		return 0, fmt.Errorf("invalid similarity score: %s", s)
+	}
+	if num >= scale {
+		return MaxScore, nil
+	}
+	return MaxScore * num / scale, nil
+}
+
+// ================================================================= //
+// RENAME DETECTION
+// ================================================================= //
+
+// renameFile is a side of an edit whose contents are read, and
+// hashed into spans, on demand.
+type renameFile struct {
+	entry  *objects.TreeEntry
+	source BlobSource
+	data   []byte
+	spans  spanCounts
+	read   bool
+}
+
+func (f *renameFile) load() error {
+	if f.read {
+		return nil
+	}
+	data, err := f.source(f.entry)
+	if err != nil {
+		return err
+	}
+	f.data, f.read = data, true
+	return nil
+}
+
+func (f *renameFile) spanCounts() spanCounts {
+	if f.spans == nil {
+		f.spans = hashSpans(f.data)
+	}
+	return f.spans
+}
+
+// renameSource is a file that a created file may have been
+// renamed or copied from: a deleted file or, when looking for
+// copies, a modified or unmodified one.
+type renameSource struct {
+	renameFile
+	edit *TreeEdit // nil for unmodified files
+	used int       // the number of users of the file
+}
+
+// renameDest is a created file.
+type renameDest struct {
+	renameFile
+	edit  *TreeEdit
+	src   *renameSource // the match, if any
+	score int
+}
+
+// renameScore is a candidate match in the similarity matrix.
+type renameScore struct {
+	dst, src  int // dst is -1 for an unused slot
+	score     int
+	nameScore int
+}
+
+// renameDetector holds the state of one run of rename detection.
+type renameDetector struct {
+	opts     *RenameOptions
+	copies   bool
+	minScore int
+	srcs     []*renameSource
+	dsts     []*renameDest
+
+	// needed is the rename limit that would have been
+	// needed to compare all files, and degraded is true if
+	// unmodified files were skipped to keep within it
+	needed   int
+	degraded bool
+}
+
+// detectRenames turns pairs of created files and the files that
+// they were renamed or copied from into Rename and Copy edits. The
+// unmodified entries are only used when looking harder for copies.
+func (td *TreeDiff) detectRenames(unchanged []*objects.TreeEntry, before, after BlobSource, opts *RenameOptions) error {
+	rd := &renameDetector{
+		opts:     opts,
+		copies:   opts.Copies || opts.CopiesHarder,
+		minScore: opts.MinScore,
+	}
+	if rd.minScore == 0 {
+		rd.minScore = DefaultRenameScore
+	}
+	for _, edit := range td.deleteEdits {
+		rd.srcs = append(rd.srcs, &renameSource{renameFile: renameFile{entry: edit.Before, source: before}, edit: edit})
+	}
+	if rd.copies {
+		// modified files are sources, but their use doesn't
+		// remove them, so they are only ever copied
+		for _, edit := range td.modified {
+			rd.srcs = append(rd.srcs, &renameSource{renameFile: renameFile{entry: edit.Before, source: before}, edit: edit, used: 1})
+		}
+		if opts.CopiesHarder {
+			for _, e := range unchanged {
+				rd.srcs = append(rd.srcs, &renameSource{renameFile: renameFile{entry: e, source: before}, used: 1})
+			}
+		}
+	}
+	for _, edit := range td.insertEdits {
+		rd.dsts = append(rd.dsts, &renameDest{renameFile: renameFile{entry: edit.After, source: after}, edit: edit})
+	}
+	sort.Stable(bySourcePath(rd.srcs))
+	sort.Stable(byDestPath(rd.dsts))
+
+	if err := rd.detect(); err != nil {
+		return err
+	}
+	td.renameLimitNeeded, td.renameDegraded = rd.needed, rd.degraded
+	td.applyRenames(rd.dsts)
+	return nil
+}
+
+func (rd *renameDetector) detect() error {
+	if len(rd.dsts) == 0 || len(rd.srcs) == 0 {
+		return nil
+	}
+	rd.findExact()
+	if rd.minScore == MaxScore {
+		// only exact renames were wanted
+		return nil
+	}
+	rd.cullSources(false)
+	if !rd.copies {
+		// a large share of renames keep the basename, so look
+		// for those first with a stricter score
+		minScore := rd.minScore + (MaxScore-rd.minScore)/2
+		if err := rd.findBasenames(minScore); err != nil {
+			return err
+		}
+		rd.cullSources(true)
+	}
+
+	var dsts []int
+	for i, d := range rd.dsts {
+		if d.src == nil {
+			dsts = append(dsts, i)
+		}
+	}
+	if len(dsts) == 0 || len(rd.srcs) == 0 {
+		return nil
+	}
+	skipUnmodified := false
+	switch rd.tooManyCandidates(len(dsts), len(rd.srcs)) {
+	case limitExceeded:
+		return nil
+	case limitExceededWithUnmodified:
+		rd.degraded, skipUnmodified = true, true
+	}
+
+	// the best candidates of every remaining created file, in the
+	// order of the most to the least similar
+	mx := make([]renameScore, 0, len(dsts)*candidatesPerDest)
+	for _, di := range dsts {
+		m := make([]renameScore, candidatesPerDest)
+		for j := range m {
+			m[j].dst = -1
+		}
+		for si, s := range rd.srcs {
+			if skipUnmodified && s.edit == nil {
+				continue
+			}
+			score, err := rd.similarity(s, rd.dsts[di], rd.minScore)
+			if err != nil {
+				return err
+			}
+			recordIfBetter(m, renameScore{di, si, score, basenameSame(s.entry, rd.dsts[di].entry)})
+		}
+		mx = append(mx, m...)
+	}
+	sort.Stable(byRenameScore(mx))
+	rd.findRenames(mx, false)
+	if rd.copies {
+		rd.findRenames(mx, true)
+	}
+	return nil
+}
+
+// findExact matches created files with sources of the same oid,
+// preferring sources that weren't used yet and that have the
+// same basename.
+func (rd *renameDetector) findExact() {
+	byOid := make(map[string][]*renameSource)
+	for _, s := range rd.srcs {
+		oid := s.entry.ObjectId().String()
+		byOid[oid] = append(byOid[oid], s)
+	}
+	for _, d := range rd.dsts {
+		var best *renameSource
+		bestScore, tries := -1, maxIdenticalSources
+		for _, s := range byOid[d.entry.ObjectId().String()] {
+			// other kinds of files only match if their modes do
+			if (!isRegular(s.entry) || !isRegular(d.entry)) && s.entry.Mode() != d.entry.Mode() {
+				continue
+			}
+			score := 0
+			if s.used == 0 {
+				score = 1
+			} else if !rd.copies {
+				continue
+			}
+			score += basenameSame(s.entry, d.entry)
+			if score > bestScore {
+				best, bestScore = s, score
+				if score == 2 {
+					break
+				}
+			}
+			if tries--; tries == 0 {
+				break
+			}
+		}
+		if best != nil {
+			rd.record(d, best, MaxScore)
+		}
+	}
+}
+
+// findBasenames matches created files with sources of the same
+// basename, if that basename is unique among both the remaining
+// sources and the remaining created files.
+func (rd *renameDetector) findBasenames(minScore int) error {
+	srcs := make(map[string]int)
+	for i, s := range rd.srcs {
+		base := path.Base(s.entry.Name())
+		if _, ok := srcs[base]; ok {
+			srcs[base] = -1
+		} else {
+			srcs[base] = i
+		}
+	}
+	dsts := make(map[string]int)
+	for i, d := range rd.dsts {
+		if d.src != nil {
+			continue
+		}
+		base := path.Base(d.entry.Name())
+		if _, ok := dsts[base]; ok {
+			dsts[base] = -1
+		} else {
+			dsts[base] = i
+		}
+	}
+	for base, si := range srcs {
+		di, ok := dsts[base]
+		if si < 0 || !ok || di < 0 {
+			continue
+		}
+		s, d := rd.srcs[si], rd.dsts[di]
+		if !rd.relevant(s) {
+			continue
+		}
+		score, err := rd.similarity(s, d, minScore)
+		if err != nil {
+			return err
+		}
+		if score >= minScore {
+			rd.record(d, s, score)
+		}
+	}
+	return nil
+}
+
+// findRenames records the matches of the sorted matrix that are
+// good enough, from the best down. Sources are used once, unless
+// copies are wanted.
+func (rd *renameDetector) findRenames(mx []renameScore, copies bool) {
+	for _, m := range mx {
+		if m.dst < 0 || m.score < rd.minScore {
+			break
+		}
+		d, s := rd.dsts[m.dst], rd.srcs[m.src]
+		if d.src != nil || (!copies && s.used > 0) {
+			continue
+		}
+		rd.record(d, s, m.score)
+	}
+}
+
+func (rd *renameDetector) record(d *renameDest, s *renameSource, score int) {
+	s.used++
+	d.src, d.score = s, score
+}
+
+// cullSources removes the sources that were used, unless copies
+// are wanted, in which case they may be used again, and, if asked
+// to, the sources that aren't relevant.
+func (rd *renameDetector) cullSources(relevantOnly bool) {
+	if rd.copies {
+		return
+	}
+	srcs := rd.srcs[:0]
+	for _, s := range rd.srcs {
+		if s.used == 0 && (!relevantOnly || rd.relevant(s)) {
+			srcs = append(srcs, s)
+		}
+	}
+	rd.srcs = srcs
+}
+
+// relevant returns true if a source is worth comparing by its
+// contents.
+func (rd *renameDetector) relevant(s *renameSource) bool {
+	return rd.opts.Relevant == nil || rd.opts.Relevant(s.entry.Name())
+}
+
+const (
+	withinLimit = iota
+	limitExceeded
+	limitExceededWithUnmodified
+)
+
+// tooManyCandidates checks whether comparing every created file
+// with every source would exceed the rename limit, and if it
+// would, whether leaving out the unmodified sources helps.
+func (rd *renameDetector) tooManyCandidates(dsts, srcs int) int {
+	limit := rd.opts.Limit
+	if limit <= 0 || dsts*srcs <= limit*limit {
+		return withinLimit
+	}
+	rd.needed = srcs
+	if dsts > srcs {
+		rd.needed = dsts
+	}
+	if !rd.opts.CopiesHarder {
+		return limitExceeded
+	}
+	modified := 0
+	for _, s := range rd.srcs {
+		if s.edit != nil {
+			modified++
+		}
+	}
+	if dsts*modified <= limit*limit {
+		return limitExceededWithUnmodified
+	}
+	return limitExceeded
+}
+
+// similarity estimates how much of a created file came from a
+// source, out of MaxScore. Files whose sizes are too different
+// to reach the minimum score are not compared. Only regular files
+// are compared; other kinds of files only match exactly.
+func (rd *renameDetector) similarity(s *renameSource, d *renameDest, minScore int) (int, error) {
+	if !isRegular(s.entry) || !isRegular(d.entry) {
+		return 0, nil
+	}
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	if err := d.load(); err != nil {
+		return 0, err
+	}
+	maxSize, baseSize := len(s.data), len(d.data)
+	if maxSize < baseSize {
+		maxSize, baseSize = baseSize, maxSize
+	}
+	if maxSize*(MaxScore-minScore) < (maxSize-baseSize)*MaxScore {
+		return 0, nil
+	}
+	if len(d.data) == 0 {
+		return 0, nil
+	}
+	copied, _ := s.spanCounts().changes(d.spanCounts())
+	return copied * MaxScore / maxSize, nil
+}
+
+// applyRenames replaces created files that were matched, and the
+// deleted files that they were renamed from, with Rename and Copy
+// edits. A deleted file that was used more than once is copied to
+// all but the last of its created files, in order of their paths,
+// and renamed to the last one.
+func (td *TreeDiff) applyRenames(dsts []*renameDest) {
+	matched := make(map[*TreeEdit]*renameDest)
+	used := make(map[*TreeEdit]bool)
+	for _, d := range dsts {
+		if d.src != nil {
+			matched[d.edit] = d
+			used[d.src.edit] = true
+		}
+	}
+	for i, edit := range td.deleteEdits {
+		if used[edit] {
+			td.deleteEdits[i] = nil
+		}
+	}
+	for i, edit := range td.insertEdits {
+		d, ok := matched[edit]
+		if !ok {
+			continue
+		}
+		action := Rename
+		if d.src.used--; d.src.used > 0 {
+			action = Copy
+		}
+		match := &TreeEdit{action: action, Before: d.src.entry, After: d.entry, score: float64(d.score * 100 / MaxScore)}
+		td.edits = append(td.edits, match)
+		if action == Rename {
+			td.renamed = append(td.renamed, match)
+		}
+		td.insertEdits[i] = nil
+	}
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+func isRegular(e *objects.TreeEntry) bool {
+	return e.Mode() == objects.ModeBlob || e.Mode() == objects.ModeBlobExec
+}
+
+// basenameSame is 1 if the entries have the same basename, and
+// 0 otherwise.
+func basenameSame(a, b *objects.TreeEntry) int {
+	if path.Base(a.Name()) == path.Base(b.Name()) {
+		return 1
+	}
+	return 0
+}
+
+// recordIfBetter replaces the worst of the candidates with the
+// new one, if it is better.
+func recordIfBetter(m []renameScore, o renameScore) {
+	worst := 0
+	for i := 1; i < len(m); i++ {
+		if compareScores(m[i], m[worst]) > 0 {
+			worst = i
+		}
+	}
+	if compareScores(m[worst], o) > 0 {
+		m[worst] = o
+	}
+}
+
+// compareScores orders candidates from the most to the least
+// similar, with unused slots last.
+func compareScores(a, b renameScore) int {
+	switch {
+	case a.dst < 0:
+		if b.dst >= 0 {
+			return 1
+		}
+		return 0
+	case b.dst < 0:
+		return -1
+	case a.score == b.score:
+		return b.nameScore - a.nameScore
+	}
+	return b.score - a.score
+}
+
+type byRenameScore []renameScore
+
+func (s byRenameScore) Len() int           { return len(s) }
+func (s byRenameScore) Less(i, j int) bool { return compareScores(s[i], s[j]) < 0 }
+func (s byRenameScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type bySourcePath []*renameSource
+
+func (s bySourcePath) Len() int           { return len(s) }
+func (s bySourcePath) Less(i, j int) bool { return s[i].entry.Name() < s[j].entry.Name() }
+func (s bySourcePath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type byDestPath []*renameDest
+
+func (s byDestPath) Len() int           { return len(s) }
+func (s byDestPath) Less(i, j int) bool { return s[i].entry.Name() < s[j].entry.Name() }
+func (s byDestPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,102 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+renames_git_test.go implements git-comparison tests for the detection
+of renames and copies.
+*/
+package diff
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test_renames compares the renames and copies that are found
+// between two commits with the output of git-diff.
+func Test_renames(t *testing.T) {
+	dir, err := test.Patch.Clone("__diff_renames")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	original := test.Patch.Info().(*test.InfoPatch).Source
+
+	// a rename with changes, an exact rename, a copy of a
+	// modified file and a copy of an unmodified one
+	changed := strings.Replace(original, `"two"`, `"TWO"`, 1)
+	util.AssertNoErr(t, util.DeleteFile(dir, "main.go"))
+	util.AssertNoErr(t, util.TestFile(dir, "cmd/main.go", changed))
+	util.AssertNoErr(t, util.DeleteFile(dir, "gone.txt"))
+	util.AssertNoErr(t, util.TestFile(dir, "sub/here.txt", "bye\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "sub/dir/deep.txt", "1\n2\n3\n4\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "sub/copy.txt", "1\n2\n3\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "run2.sh", "echo\n"))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "second"},
+	))
+
+	ta, err := api.TreeFromRevision(repo, "HEAD~")
+	util.AssertNoErrOrDie(t, err)
+	tb, err := api.TreeFromRevision(repo, "HEAD")
+	util.AssertNoErrOrDie(t, err)
+	source := RepoSource(repo)
+
+	renames := []struct {
+		args []string
+		opts *RenameOptions
+	}{
+		{[]string{"-M"}, &RenameOptions{}},
+		{[]string{"-M90%"}, &RenameOptions{MinScore: 54000}},
+		{[]string{"-M100%"}, &RenameOptions{MinScore: MaxScore}},
+		{[]string{"-C"}, &RenameOptions{Copies: true}},
+		{[]string{"-C", "-C"}, &RenameOptions{Copies: true, CopiesHarder: true}},
+		{[]string{"-C", "-C", "-l1"}, &RenameOptions{Copies: true, CopiesHarder: true, Limit: 1}},
+	}
+	buf := new(bytes.Buffer)
+	for _, r := range renames {
+		td, err := DiffTrees(repo, ta, tb, &Options{Renames: r.opts})
+		util.AssertNoErrOrDie(t, err)
+		git := func(args ...string) string {
+			args = append(append(append([]string{"diff"}, r.args...), args...), "HEAD~", "HEAD")
+			return util.GitNow(dir, args...)
+		}
+		buf.Reset()
+		util.AssertNoErr(t, WriteNameStatus(buf, td))
+		util.AssertEqualString(t, git("--name-status"), buf.String())
+		buf.Reset()
+		util.AssertNoErr(t, NewPatchWriter(buf, source, source, PatchOptions{Context: DefaultContext}).WriteDiff(td))
+		util.AssertEqualString(t, git(), buf.String())
+	}
+}
+
+func Test_ParseRenameScore(t *testing.T) {
+	scores := map[string]int{
+		"":     0,
+		"5":    30000,
+		"50%":  30000,
+		"0.5":  30000,
+		"75%":  45000,
+		"9":    54000,
+		"100%": MaxScore,
+		"2.5%": 1500,
+		"150%": MaxScore,
+	}
+	for s, score := range scores {
+		n, err := ParseRenameScore(s)
+		util.AssertNoErr(t, err)
+		util.AssertEqualInt(t, score, n)
+	}
+	_, err := ParseRenameScore("5x")
+	util.Assert(t, err != nil, "expected an error for 5x")
+}
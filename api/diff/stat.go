@@ -0,0 +1,483 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+stat.go implements the summaries of tree diffs: the diffstat with its
+graph, the numstat and shortstat, the lists of changed names and the
+summary of created, deleted and renamed files, in the formats of
+git-diff.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/util"
+	"io"
+	"strings"
+)
+
+// ================================================================= //
+// NAMES
+// ================================================================= //
+
+// Status returns the letter by which git-diff --name-status
+// shows the edit, which is followed by the similarity score for
+// renames and copies.
+func (te *TreeEdit) Status() string {
+	switch te.action {
+	case Insert:
+		return "A"
+	case Delete:
+		return "D"
+	case Modify:
+		return "M"
+	case TypeChange:
+		return "T"
+	case Unmerged:
+		return "U"
+	case Rename:
+		return fmt.Sprintf("R%03d", int(te.score))
+	case Copy:
+		return fmt.Sprintf("C%03d", int(te.score))
+	}
+	return "X"
+}
+
+// WriteNameOnly prints the path of every edit, as git-diff
+// --name-only does.
+func WriteNameOnly(w io.Writer, td *TreeDiff) error {
+	buf := new(bytes.Buffer)
+	for _, edit := range td.Edits() {
+		fmt.Fprintln(buf, util.QuotePath(edit.Path()))
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// WriteNameStatus prints the status and path of every edit,
+// as git-diff --name-status does. Renames and copies show both
+// of their paths.
+func WriteNameStatus(w io.Writer, td *TreeDiff) error {
+	buf := new(bytes.Buffer)
+	for _, edit := range td.Edits() {
+		switch edit.action {
+		case Rename, Copy:
+			fmt.Fprintf(buf, "%s\t%s\t%s\n", edit.Status(), util.QuotePath(edit.Before.Name()), util.QuotePath(edit.After.Name()))
+		default:
+			fmt.Fprintf(buf, "%s\t%s\n", edit.Status(), util.QuotePath(edit.Path()))
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// ================================================================= //
+// SUMMARY
+// ================================================================= //
+
+// WriteSummary prints the files that were created, deleted,
+// renamed or copied, and the ones whose modes changed, as
+// git-diff --summary does.
+func WriteSummary(w io.Writer, td *TreeDiff) error {
+	buf := new(bytes.Buffer)
+	modeChange := func(a, b *objects.TreeEntry) bool {
+		if a.Mode() == b.Mode() {
+			return false
+		}
+		fmt.Fprintf(buf, " mode change %06o => %06o", a.Mode(), b.Mode())
+		return true
+	}
+	for _, edit := range td.Edits() {
+		a, b := edit.Before, edit.After
+		switch edit.action {
+		case Insert:
+			fmt.Fprintf(buf, " create mode %06o %s\n", b.Mode(), util.QuotePath(b.Name()))
+		case Delete:
+			fmt.Fprintf(buf, " delete mode %06o %s\n", a.Mode(), util.QuotePath(a.Name()))
+		case Rename, Copy:
+			verb := "rename"
+			if edit.action == Copy {
+				verb = "copy"
+			}
+			fmt.Fprintf(buf, " %s %s (%d%%)\n", verb, renameName(a.Name(), b.Name()), int(edit.score))
+			if modeChange(a, b) {
+				buf.WriteByte('\n')
+			}
+		case Modify, TypeChange:
+			if modeChange(a, b) {
+				fmt.Fprintf(buf, " %s\n", util.QuotePath(b.Name()))
+			}
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// ================================================================= //
+// DIFFSTAT
+// ================================================================= //
+
+// FileStat counts the changes of a single edit.
+type FileStat struct {
+	Edit *TreeEdit
+
+	// Added and Removed are the numbers of added and removed
+	// lines, or for binary files the sizes of the new and old
+	// sides, in bytes.
+	Added, Removed int
+
+	// Binary is true if either side is binary.
+	Binary bool
+}
+
+// Unmerged returns true if the stat is of an unmerged path,
+// which has no counts.
+func (fs *FileStat) Unmerged() bool {
+	return fs.Edit.action == Unmerged
+}
+
+// Name returns the name under which the stat is shown, which
+// for renames and copies has both paths.
+func (fs *FileStat) Name() string {
+	switch fs.Edit.action {
+	case Rename, Copy:
+		return renameName(fs.Edit.Before.Name(), fs.Edit.After.Name())
+	}
+	return util.QuotePath(fs.Edit.Path())
+}
+
+// DiffStat holds the stats of the edits of a tree diff.
+type DiffStat []*FileStat
+
+// NewDiffStat counts the changes of every edit of the diff, with
+// the given line diff algorithm. The old sides of the edits are
+// read from one source and the new sides from another, and the
+// detector, which may be nil, decides which of them are binary.
+func NewDiffStat(td *TreeDiff, before, after BlobSource, alg Algorithm, bd *BinaryDetector) (DiffStat, error) {
+	ds := make(DiffStat, 0, len(td.Edits()))
+	for _, edit := range td.Edits() {
+		fs := &FileStat{Edit: edit}
+		ds = append(ds, fs)
+		if edit.action == Unmerged {
+			continue
+		}
+		a, b := edit.Before, edit.After
+		dataA, dataB, err := readSides(before, after, a, b)
+		if err != nil {
+			return nil, err
+		}
+		same := a != nil && b != nil && a.ObjectId().String() == b.ObjectId().String()
+		switch {
+		case (a != nil && bd.IsBinary(a.Name(), dataA)) || (b != nil && bd.IsBinary(b.Name(), dataB)):
+			fs.Binary = true
+			if !same {
+				fs.Added, fs.Removed = len(dataB), len(dataA)
+			}
+		case !same:
+			fs.Added, fs.Removed = alg.Diff(dataA, dataB).Stat()
+		}
+	}
+	return ds, nil
+}
+
+// Totals returns the number of changed files, not counting the
+// unmerged ones, and the numbers of added and removed lines of
+// the files that aren't binary.
+func (ds DiffStat) Totals() (files, added, removed int) {
+	for _, fs := range ds {
+		if fs.Unmerged() {
+			continue
+		}
+		files++
+		if !fs.Binary {
+			added += fs.Added
+			removed += fs.Removed
+		}
+	}
+	return
+}
+
+// WriteNumstat prints the counts of every file in a form that
+// is easy for machines to read, as git-diff --numstat does.
+// The counts of binary files are shown as dashes.
+func (ds DiffStat) WriteNumstat(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	for _, fs := range ds {
+		if fs.Binary {
+			buf.WriteString("-\t-\t")
+		} else {
+			fmt.Fprintf(buf, "%d\t%d\t", fs.Added, fs.Removed)
+		}
+		buf.WriteString(fs.Name())
+		buf.WriteByte('\n')
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// WriteShortstat prints the summary line of the diffstat only,
+// as git-diff --shortstat does.
+func (ds DiffStat) WriteShortstat(w io.Writer) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w, ds.summary())
+	return err
+}
+
+// summary returns the last line of the diffstat, as in
+// " 2 files changed, 3 insertions(+), 1 deletion(-)".
+func (ds DiffStat) summary() string {
+	files, added, removed := ds.Totals()
+	if files == 0 {
+		return " 0 files changed\n"
+	}
+	s := fmt.Sprintf(" %d %s changed", files, plural(files, "file", "files"))
+	if added > 0 || removed == 0 {
+		s += fmt.Sprintf(", %d %s(+)", added, plural(added, "insertion", "insertions"))
+	}
+	if removed > 0 || added == 0 {
+		s += fmt.Sprintf(", %d %s(-)", removed, plural(removed, "deletion", "deletions"))
+	}
+	return s + "\n"
+}
+
+// StatOptions control the layout of the diffstat.
+type StatOptions struct {
+	// Width is the width of the whole diffstat, which is 80
+	// if it is 0.
+	Width int
+
+	// NameWidth and GraphWidth limit the widths of the names
+	// and of the graph, if they are not 0.
+	NameWidth  int
+	GraphWidth int
+
+	// Count limits the number of files that are shown, if it
+	// is not 0.
+	Count int
+
+	// Colors color the graph and the sizes of binary files, if
+	// they are not nil.
+	Colors *Colors
+}
+
+// WriteStat prints the diffstat, as git-diff --stat does: a line
+// for each file with the number of changed lines and a graph of
+// pluses and minuses, which is scaled to fit the width, and then
+// a summary line. Names that are too long are shortened from
+// the left.
+func (ds DiffStat) WriteStat(w io.Writer, opts StatOptions) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	count := len(ds)
+	if opts.Count > 0 && opts.Count < count {
+		count = opts.Count
+	}
+
+	// find the longest name and the largest change
+	maxLen, maxChange, numberWidth, binWidth := 0, 0, 0, 0
+	for _, fs := range ds[:count] {
+		if n := len(fs.Name()); n > maxLen {
+			maxLen = n
+		}
+		switch {
+		case fs.Unmerged():
+			// "Unmerged" is 8 characters
+			binWidth = max(binWidth, 8)
+		case fs.Binary:
+			// "Bin XXX -> YYY bytes"
+			binWidth = max(binWidth, 14+decimalWidth(fs.Added)+decimalWidth(fs.Removed))
+			// changes are aligned with "Bin"
+			numberWidth = 3
+		default:
+			maxChange = max(maxChange, fs.Added+fs.Removed)
+		}
+	}
+	numberWidth = max(numberWidth, decimalWidth(maxChange))
+
+	// a line is " name | NNN graph", which has 6 characters
+	// besides the name, the number and the graph; the graph
+	// gets at least 6 columns and the name at least 10
+	width := opts.Width
+	if width == 0 {
+		width = util.DefaultColumns
+	}
+	width = max(width, 16+6+numberWidth)
+
+	// the part of "Bin XXX -> YYY bytes" from "XXX" on
+	// goes where the graph would
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	if opts.GraphWidth > 0 && opts.GraphWidth < graphWidth {
+		graphWidth = opts.GraphWidth
+	}
+	nameWidth := maxLen
+	if opts.NameWidth > 0 && opts.NameWidth < maxLen {
+		nameWidth = opts.NameWidth
+	}
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = max(width*3/8-numberWidth-6, 6)
+		}
+		if opts.GraphWidth > 0 && graphWidth > opts.GraphWidth {
+			graphWidth = opts.GraphWidth
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	addColor, delColor := opts.Colors.Get(ColorNew), opts.Colors.Get(ColorOld)
+	reset := opts.Colors.Reset()
+	buf := new(bytes.Buffer)
+	for _, fs := range ds[:count] {
+		prefix, name := "", fs.Name()
+		n := nameWidth
+		if nameWidth < len(name) {
+			// keep the end of the name, from a slash if
+			// there is one
+			prefix = "..."
+			n = max(n-3, 0)
+			name = name[len(name)-n:]
+			if i := strings.Index(name, "/"); i >= 0 {
+				name = name[i:]
+			}
+		}
+		padding := strings.Repeat(" ", max(n-len(name), 0))
+		fmt.Fprintf(buf, " %s%s%s | ", prefix, name, padding)
+
+		switch {
+		case fs.Unmerged():
+			fmt.Fprintf(buf, "%*s\n", numberWidth, "Unmerged")
+			continue
+		case fs.Binary:
+			fmt.Fprintf(buf, "%*s", numberWidth, "Bin")
+			if fs.Added != 0 || fs.Removed != 0 {
+				fmt.Fprintf(buf, " %s%d%s -> %s%d%s bytes", delColor, fs.Removed, reset, addColor, fs.Added, reset)
+			}
+			buf.WriteByte('\n')
+			continue
+		}
+
+		added, removed := fs.Added, fs.Removed
+		if graphWidth <= maxChange {
+			total := scaleLinear(added+removed, graphWidth, maxChange)
+			if total < 2 && added > 0 && removed > 0 {
+				total = 2
+			}
+			if added < removed {
+				added = scaleLinear(added, graphWidth, maxChange)
+				removed = total - added
+			} else {
+				removed = scaleLinear(removed, graphWidth, maxChange)
+				added = total - removed
+			}
+		}
+		fmt.Fprintf(buf, "%*d", numberWidth, fs.Added+fs.Removed)
+		if fs.Added+fs.Removed > 0 {
+			buf.WriteByte(' ')
+		}
+		writeGraph(buf, "+", added, addColor, reset)
+		writeGraph(buf, "-", removed, delColor, reset)
+		buf.WriteByte('\n')
+	}
+	for _, fs := range ds[count:] {
+		if !fs.Unmerged() {
+			buf.WriteString(" ...\n")
+			break
+		}
+	}
+	buf.WriteString(ds.summary())
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+// writeGraph prints a bar of the graph of a diffstat in a color.
+func writeGraph(buf *bytes.Buffer, bar string, n int, color, reset string) {
+	if n > 0 {
+		buf.WriteString(color + strings.Repeat(bar, n) + reset)
+	}
+}
+
+// scaleLinear scales a change to the width of the graph, such
+// that every change gets at least one column.
+func scaleLinear(n, width, maxChange int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/maxChange
+}
+
+func decimalWidth(n int) int {
+	return len(fmt.Sprint(n))
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// renameName shows the old and new paths of a rename in a
+// compact form, which factors out their common leading and
+// trailing directories, as in "a/{b => c}/d".
+func renameName(a, b string) string {
+	qa, qb := util.QuotePath(a), util.QuotePath(b)
+	if qa != a || qb != b {
+		return qa + " => " + qb
+	}
+
+	// the common prefix, up to and including a slash
+	pfx := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			pfx = i + 1
+		}
+	}
+
+	// the common suffix, from a slash; if there is a common
+	// prefix, the slash that ends it may start the suffix too
+	adjust := 0
+	if pfx > 0 {
+		adjust = 1
+	}
+	sfx := 0
+	for i, j := len(a), len(b); pfx-adjust <= i && pfx-adjust <= j && byteAt(a, i) == byteAt(b, j); i, j = i-1, j-1 {
+		if byteAt(a, i) == '/' {
+			sfx = len(a) - i
+		}
+	}
+
+	midA, midB := max(len(a)-pfx-sfx, 0), max(len(b)-pfx-sfx, 0)
+	if pfx+sfx == 0 {
+		return a[pfx:pfx+midA] + " => " + b[pfx:pfx+midB]
+	}
+	return a[:pfx] + "{" + a[pfx:pfx+midA] + " => " + b[pfx:pfx+midB] + "}" + a[len(a)-sfx:]
+}
+
+// byteAt returns the byte at position i of s, or 0 at the
+// end of s, like a C string.
+func byteAt(s string, i int) byte {
+	if i >= len(s) {
+		return 0
+	}
+	return s[i]
+}
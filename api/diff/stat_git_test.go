@@ -0,0 +1,113 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+stat_git_test.go implements git-comparison tests for the summaries of
+diffs.
+*/
+package diff
+
+import (
+	"bytes"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test_stats compares the summaries of the changes between two
+// commits with the output of git-diff.
+func Test_stats(t *testing.T) {
+	dir, err := test.Patch.Clone("__diff_stats")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	original := test.Patch.Info().(*test.InfoPatch).Source
+
+	changed := strings.Replace(original, `"two"`, `"TWO"`, 1)
+	changed = strings.Replace(changed, "\tfmt.Println(\"c\")\n", "", 1)
+	changed += strings.Repeat("// more\n", 150)
+	util.AssertNoErr(t, util.TestFile(dir, "main.go", changed))
+	util.AssertNoErr(t, util.DeleteFile(dir, "gone.txt"))
+	util.AssertNoErr(t, util.TestFile(dir, "sub/dir/deep.txt", "1\n2\n3\n4\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "sub/dir/deeper/file_with_a_rather_long_name.txt", "new\n"))
+	util.AssertNoErr(t, util.TestFile(dir, "sub/bin.dat", "\x00\x01\x02"))
+	util.AssertNoErr(t, util.TestFile(dir, "sp ace.txt", "y\r\nz\r\n"))
+	util.AssertNoErr(t, os.Chmod(dir+"/run.sh", 0755))
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"add", "--all"},
+		[]string{"commit", "-m", "second"},
+	))
+
+	ta, err := api.TreeFromRevision(repo, "HEAD~")
+	util.AssertNoErrOrDie(t, err)
+	tb, err := api.TreeFromRevision(repo, "HEAD")
+	util.AssertNoErrOrDie(t, err)
+	td, err := DiffTrees(repo, ta, tb, &Options{})
+	util.AssertNoErrOrDie(t, err)
+	source := RepoSource(repo)
+	ds, err := NewDiffStat(td, source, source, Myers, nil)
+	util.AssertNoErrOrDie(t, err)
+
+	git := func(args ...string) string {
+		return util.GitNow(dir, append(append([]string{"diff", "--no-renames"}, args...), "HEAD~", "HEAD")...)
+	}
+	buf := new(bytes.Buffer)
+
+	util.AssertNoErr(t, WriteNameOnly(buf, td))
+	util.AssertEqualString(t, git("--name-only"), buf.String())
+	buf.Reset()
+	util.AssertNoErr(t, WriteNameStatus(buf, td))
+	util.AssertEqualString(t, git("--name-status"), buf.String())
+	buf.Reset()
+	util.AssertNoErr(t, ds.WriteNumstat(buf))
+	util.AssertEqualString(t, git("--numstat"), buf.String())
+	buf.Reset()
+	util.AssertNoErr(t, ds.WriteShortstat(buf))
+	util.AssertEqualString(t, git("--shortstat"), buf.String())
+
+	stats := []struct {
+		arg  string
+		opts StatOptions
+	}{
+		{"--stat=80", StatOptions{Width: 80}},
+		{"--stat=40", StatOptions{Width: 40}},
+		{"--stat=200,20", StatOptions{Width: 200, NameWidth: 20}},
+		{"--stat=80,0,3", StatOptions{Width: 80, Count: 3}},
+	}
+	for _, s := range stats {
+		buf.Reset()
+		util.AssertNoErr(t, ds.WriteStat(buf, s.opts))
+		util.AssertEqualString(t, git(s.arg), buf.String())
+	}
+
+	for _, params := range []string{"", "0", "lines,0", "files,0,cumulative", "changes,10.5"} {
+		opts := NewDirstatOptions()
+		util.AssertNoErr(t, opts.Parse(params))
+		buf.Reset()
+		util.AssertNoErr(t, WriteDirstat(buf, td, source, source, opts))
+		util.AssertEqualString(t, git("--dirstat="+params), buf.String())
+	}
+}
+
+func Test_renameName(t *testing.T) {
+	names := [][]string{
+		{"a/b/c", "a/d/c", "a/{b => d}/c"},
+		{"a/b", "a/c", "a/{b => c}"},
+		{"b/c", "d/c", "{b => d}/c"},
+		{"a", "b", "a => b"},
+		{"a/b/c", "a/c", "a/{b => }/c"},
+		{"a/c", "a/b/c", "a/{ => b}/c"},
+		{"a\"b", "c", "\"a\\\"b\" => c"},
+	}
+	for _, n := range names {
+		util.AssertEqualString(t, n[2], renameName(n[0], n[1]))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/jbrukh/ggit/api"
 	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
 	"sort"
 )
 
@@ -11,6 +12,18 @@ type TreeDiffer interface {
 	Diff(ta, tb *objects.Tree) (*TreeDiff, error)
 }
 
+// Options control the way in which trees and
+// other collections of files are compared.
+type Options struct {
+	// Pathspec limits the comparison to matching
+	// paths, if it is not nil.
+	Pathspec *pathspec.Pathspec
+
+	// Renames control the detection of renamed and copied
+	// files. If it is nil, they are not detected.
+	Renames *RenameOptions
+}
+
 type TreeDiff struct {
 	edits       []*TreeEdit
 	modified    []*TreeEdit
@@ -19,12 +32,32 @@ type TreeDiff struct {
 	deleteEdits []*TreeEdit
 	inserted    []*objects.TreeEntry
 	deleted     []*objects.TreeEntry
+
+	// the new sides are files of the working tree, whose
+	// oids git doesn't know without reading them
+	workTree bool
+
+	// the rename limit that would have been needed to look
+	// for all renames, and whether copies of unmodified files
+	// were skipped for it
+	renameLimitNeeded int
+	renameDegraded    bool
+}
+
+// Edits returns all edits, ordered by path. Renames
+// are found at the position of the new path.
+func (td *TreeDiff) Edits() []*TreeEdit {
+	return td.edits
 }
 
 func (td *TreeDiff) Modified() []*TreeEdit {
 	return td.modified
 }
 
+func (td *TreeDiff) Renamed() []*TreeEdit {
+	return td.renamed
+}
+
 func (td *TreeDiff) Inserted() []*objects.TreeEntry {
 	return td.inserted
 }
@@ -33,6 +66,19 @@ func (td *TreeDiff) Deleted() []*objects.TreeEntry {
 	return td.deleted
 }
 
+// RenameLimitNeeded returns the rename limit that would have been
+// needed to compare all files when looking for renames, or 0 if
+// the limit wasn't exceeded.
+func (td *TreeDiff) RenameLimitNeeded() int {
+	return td.renameLimitNeeded
+}
+
+// RenameDegraded returns true if copies were only looked for among
+// modified files, because there were too many unmodified ones.
+func (td *TreeDiff) RenameDegraded() bool {
+	return td.renameDegraded
+}
+
 func (td *TreeDiff) String() string {
 	result := ""
 	for _, v := range td.edits {
@@ -53,8 +99,14 @@ func (te *TreeEdit) String() string {
 		return treeFormat("D", te.Before)
 	case Modify:
 		return treeFormat("M", te.Before)
+	case TypeChange:
+		return treeFormat("T", te.Before)
+	case Unmerged:
+		return treeFormat("U", te.Before)
 	case Rename:
 		return treeFormat(treeFormat(fmt.Sprintf("R%d", int(te.score)), te.Before), te.After)
+	case Copy:
+		return treeFormat(treeFormat(fmt.Sprintf("C%d", int(te.score)), te.Before), te.After)
 	}
 	return ""
 }
@@ -69,279 +121,241 @@ type TreeEdit struct {
 	score float64
 }
 
-type editType rune
-
-const (
-	Insert editType = 'i'
-	Delete editType = 'd'
-	Modify editType = 'm'
-	Rename editType = 'r'
-)
-
-type treeDiffer struct {
-	repository api.Repository
-	blobDiffer BlobMatcher
+// Action returns the kind of the edit.
+func (te *TreeEdit) Action() editType {
+	return te.action
 }
 
-func NewTreeDiffer(r api.Repository, blobDiffer BlobMatcher) TreeDiffer {
-	return &treeDiffer{r, blobDiffer}
+// Score returns the similarity of the files of a
+// rename or copy, in percent.
+func (te *TreeEdit) Score() float64 {
+	return te.score
 }
 
-type byOid []*objects.TreeEntry
-
-func (e byOid) Len() int {
-	return len(([]*objects.TreeEntry(e)))
+// Path returns the path that the edit is listed under,
+// which is the new path of renames and copies.
+func (te *TreeEdit) Path() string {
+	if te.After != nil {
+		return te.After.Name()
+	}
+	return te.Before.Name()
 }
 
-func (e byOid) Swap(i, j int) {
-	entries := ([]*objects.TreeEntry(e))
-	entries[i], entries[j] = entries[j], entries[i]
+// NewModifyEdit returns an edit that changes one
+// entry into another.
+func NewModifyEdit(before, after *objects.TreeEntry) *TreeEdit {
+	return &TreeEdit{action: Modify, Before: before, After: after}
 }
 
-func (e byOid) Less(i, j int) bool {
-	entries := []*objects.TreeEntry(e)
-	var a, b *objects.TreeEntry = entries[i], entries[j]
-	return compare(a, b) == Less
+// NewEditDiff returns a diff that consists of the given
+// edits.
+func NewEditDiff(edits ...*TreeEdit) *TreeDiff {
+	td, _ := newTreeDiff(edits, nil, nil, nil, &Options{})
+	return td
 }
 
-type order rune
+type editType rune
 
 const (
-	Less order = iota
-	More
-	Same
+	Insert     editType = 'i'
+	Delete     editType = 'd'
+	Modify     editType = 'm'
+	Rename     editType = 'r'
+	Copy       editType = 'c'
+	TypeChange editType = 't' // e.g. a file that became a symlink
+	Unmerged   editType = 'u' // only Before is set, to the path
 )
 
-func compare(a, b *objects.TreeEntry) order {
-	if a == b {
-		return Same
-	}
-	if a == nil {
-		return Less
-	}
-	if b == nil {
-		return More
-	}
-	aId, bId := a.ObjectId().String(), b.ObjectId().String()
-	if aId < bId {
-		return Less
-	}
-	if aId > bId {
-		return More
-	}
-	return Same
+type treeDiffer struct {
+	repository api.Repository
+	renames    *RenameOptions
+}
+
+// NewTreeDiffer returns a differ of trees that detects renames
+// and copies with the given options, unless they are nil.
+func NewTreeDiffer(r api.Repository, renames *RenameOptions) TreeDiffer {
+	return &treeDiffer{r, renames}
 }
 
 func (d *treeDiffer) Diff(ta, tb *objects.Tree) (*TreeDiff, error) {
-	result := new(TreeDiff)
-	entriesA, entriesB := ta.Entries(), tb.Entries()
-	if blobsA, blobsB, err := findBlobDiffs(d.repository, entriesA, entriesB); err != nil {
+	return DiffTrees(d.repository, ta, tb, &Options{Renames: d.renames})
+}
+
+// DiffTrees compares two trees, either of which may be nil, by
+// walking them side by side. Subtrees that have the same oid on
+// both sides are not looked into, unless unmodified files are
+// needed as the sources of copies, and neither are subtrees
+// that can't contain paths of the pathspec.
+func DiffTrees(r api.Repository, ta, tb *objects.Tree, opts *Options) (*TreeDiff, error) {
+	var edits []*TreeEdit
+	var unchanged []*objects.TreeEntry
+	var keep *[]*objects.TreeEntry
+	if opts.Renames != nil && opts.Renames.CopiesHarder {
+		keep = &unchanged
+	}
+	if err := diffTrees(r, "", ta, tb, opts.Pathspec, &edits, keep); err != nil {
 		return nil, err
-	} else {
-		for _, blob := range blobsA {
-			result.makeDeletionEdit(blob)
-		}
-		for _, blob := range blobsB {
-			result.makeInsertionEdit(blob)
-		}
 	}
-	result.categorizeEdits(d.repository, d.blobDiffer)
-	return result, nil
+	source := RepoSource(r)
+	return newTreeDiff(edits, unchanged, source, source, opts)
 }
 
-func entryKey(entry *objects.TreeEntry, usingMode bool) string {
-	key := entry.ObjectId().String() + entry.Name()
-	if usingMode {
-		key = fmt.Sprintf("%s%d", key, uint16(entry.Mode()))
+// entryPath returns the path of an entry in a base
+// directory, in which trees have a trailing slash for
+// the purpose of sorting.
+func entryPath(base string, e *objects.TreeEntry) string {
+	if e.ObjectType() == objects.ObjectTree {
+		return base + e.Name() + "/"
 	}
-	return key
+	return base + e.Name()
 }
 
-//find the blobs that differ between two TreeEntry slices
-func findBlobDiffs(r api.Repository, a, b []*objects.TreeEntry) (blobsA, blobsB []*objects.TreeEntry, err error) {
-	onlyInA, onlyInB := make(map[string]*objects.TreeEntry), make(map[string]*objects.TreeEntry)
-
-	//determine the entries that only exist on side a or only exist on side b
-
-	for _, entry := range a {
-		onlyInA[entryKey(entry, true)] = entry
+// diffTrees appends the edits between two trees to a list, and
+// the entries that didn't change to another, unless that is nil.
+func diffTrees(r api.Repository, base string, ta, tb *objects.Tree, ps *pathspec.Pathspec, edits *[]*TreeEdit, unchanged *[]*objects.TreeEntry) error {
+	var ea, eb []*objects.TreeEntry
+	if ta != nil {
+		ea = ta.Entries()
 	}
-
-	for _, entry := range b {
-		key := entryKey(entry, true)
-		if onlyInA[key] != nil {
-			delete(onlyInA, key)
-		} else {
-			onlyInB[key] = entry
-		}
+	if tb != nil {
+		eb = tb.Entries()
 	}
-
-	//separate out the blobs, and explode any trees
-
-	var explodedA, explodedB []*objects.TreeEntry
-
-	for _, entry := range onlyInA {
-		switch entry.ObjectType() {
-		case objects.ObjectBlob:
-			blobsA = append(blobsA, entry)
-		case objects.ObjectTree:
-			if exploded, err := flatten(r, entry.Name()+"/", entry); err != nil {
-				return nil, nil, err
-			} else {
-				explodedA = append(explodedA, exploded...)
+	for len(ea) > 0 || len(eb) > 0 {
+		var a, b *objects.TreeEntry
+		switch {
+		case len(eb) == 0:
+			a, ea = ea[0], ea[1:]
+		case len(ea) == 0:
+			b, eb = eb[0], eb[1:]
+		default:
+			pa, pb := entryPath(base, ea[0]), entryPath(base, eb[0])
+			switch {
+			case pa < pb:
+				a, ea = ea[0], ea[1:]
+			case pa > pb:
+				b, eb = eb[0], eb[1:]
+			default:
+				a, b, ea, eb = ea[0], eb[0], ea[1:], eb[1:]
 			}
 		}
-	}
-	for _, entry := range onlyInB {
-		switch entry.ObjectType() {
-		case objects.ObjectBlob:
-			blobsB = append(blobsB, entry)
-		case objects.ObjectTree:
-			if exploded, err := flatten(r, entry.Name()+"/", entry); err != nil {
-				return nil, nil, err
-			} else {
-				explodedB = append(explodedB, exploded...)
+		some := a
+		if some == nil {
+			some = b
+		}
+		pth := base + some.Name()
+		if some.ObjectType() == objects.ObjectTree {
+			if unchanged == nil && a != nil && b != nil && a.ObjectId().String() == b.ObjectId().String() {
+				continue
+			}
+			if ps != nil && !ps.Match(pth, true) && !ps.Leads(pth) {
+				continue
+			}
+			var sa, sb *objects.Tree
+			var err error
+			if a != nil {
+				if sa, err = api.TreeFromOid(r, a.ObjectId()); err != nil {
+					return err
+				}
 			}
+			if b != nil {
+				if sb, err = api.TreeFromOid(r, b.ObjectId()); err != nil {
+					return err
+				}
+			}
+			if err = diffTrees(r, pth+"/", sa, sb, ps, edits, unchanged); err != nil {
+				return err
+			}
+			continue
+		}
+		if ps != nil && !ps.Match(pth, false) {
+			continue
+		}
+		if edit := editFor(withPath(a, pth), withPath(b, pth)); edit != nil {
+			*edits = append(*edits, edit)
+		} else if unchanged != nil {
+			*unchanged = append(*unchanged, withPath(a, pth))
 		}
 	}
-
-	//when there are no more exploded tree entries to explore, we are at the deepest level and can terminate the search
-	if len(explodedA) == 0 && len(explodedB) == 0 {
-		return
-	}
-	if moreBlobsA, moreBlobsB, err := findBlobDiffs(r, explodedA, explodedB); err != nil {
-		return nil, nil, err
-	} else {
-		blobsA = append(blobsA, moreBlobsA...)
-		blobsB = append(blobsB, moreBlobsB...)
-	}
-	return
-}
-
-func (result *TreeDiff) makeInsertionEdit(entry *objects.TreeEntry) {
-	result.edits = append(result.edits, &TreeEdit{
-		action: Insert,
-		Before: nil,
-		After:  entry,
-	})
+	return nil
 }
 
-func (result *TreeDiff) makeDeletionEdit(entry *objects.TreeEntry) {
-	result.edits = append(result.edits, &TreeEdit{
-		action: Delete,
-		Before: entry,
-		After:  nil,
-	})
+// editFor returns the edit that turns entry a into entry b,
+// where either may be nil, or nil if they are the same.
+func editFor(a, b *objects.TreeEntry) *TreeEdit {
+	switch {
+	case a == nil:
+		return &TreeEdit{action: Insert, After: b}
+	case b == nil:
+		return &TreeEdit{action: Delete, Before: a}
+	case a.ObjectId().String() == b.ObjectId().String() && a.Mode() == b.Mode():
+		return nil
+	case modeType(a.Mode()) != modeType(b.Mode()):
+		return &TreeEdit{action: TypeChange, Before: a, After: b}
+	}
+	return &TreeEdit{action: Modify, Before: a, After: b}
 }
 
-func flatten(r api.Repository, base string, treeEntry *objects.TreeEntry) (result []*objects.TreeEntry, err error) {
-	result = make([]*objects.TreeEntry, 0)
-	var object objects.Object
-	object, err = r.ObjectFromOid(treeEntry.ObjectId())
-	if err != nil {
-		return nil, err
+// modeType groups modes into regular files, symlinks and
+// submodules, which a file can't change between without
+// a type change.
+func modeType(mode objects.FileMode) objects.FileMode {
+	if mode == objects.ModeBlobExec {
+		return objects.ModeBlob
 	}
-	tree, _ := object.(*objects.Tree)
-	for _, entry := range tree.Entries() {
-		result = append(result, objects.NewTreeEntry(entry.Mode(), entry.ObjectType(), base+entry.Name(), entry.ObjectId()))
-	}
-	return result, nil
+	return mode
 }
 
-func (result *TreeDiff) categorizeEdits(r api.Repository, blobMatcher BlobMatcher) error {
-	result.detectModified()
-	if err := result.detectRenamed(r, blobMatcher); err != nil {
-		return err
+// withPath returns a copy of the entry that is named
+// by its full path.
+func withPath(e *objects.TreeEntry, pth string) *objects.TreeEntry {
+	if e == nil {
+		return nil
 	}
-	return nil
+	return objects.NewTreeEntry(e.Mode(), e.ObjectType(), pth, e.ObjectId())
 }
 
-func (result *TreeDiff) detectModified() {
-	var conflated []*TreeEdit
-	paths := make(map[string]*TreeEdit)
-	uniques := make(map[string]*TreeEdit)
-	for _, edit := range result.edits {
-		var blob *objects.TreeEntry
-		switch edit.action {
-		case Insert:
-			blob = edit.After
-		case Delete:
-			blob = edit.Before
-		default:
-			return
-		}
-		if existing := paths[blob.Name()]; existing == nil {
-			conflated = append(conflated, edit)
-			paths[blob.Name()] = edit
-			uniques[blob.Name()] = edit
-		} else {
-			delete(uniques, blob.Name())
-			switch existing.action {
-			case Insert:
-				existing.Before = blob
-			case Delete:
-				existing.After = blob
-			}
-			existing.action = Modify
-			result.modified = append(result.modified, existing)
-		}
-	}
-	for _, edit := range uniques {
+// newTreeDiff sorts the edits into categories, detects
+// renames and orders the result by path. The old sides of the
+// edits are read from one source and the new sides from
+// another, and the unchanged entries are the sources of copies
+// that are looked for harder.
+func newTreeDiff(edits []*TreeEdit, unchanged []*objects.TreeEntry, before, after BlobSource, opts *Options) (*TreeDiff, error) {
+	result := new(TreeDiff)
+	for _, edit := range edits {
 		switch edit.action {
 		case Insert:
 			result.insertEdits = append(result.insertEdits, edit)
 		case Delete:
 			result.deleteEdits = append(result.deleteEdits, edit)
+		case Modify, TypeChange:
+			result.modified = append(result.modified, edit)
 		}
-	}
-	result.edits = conflated
-}
-
-func (result *TreeDiff) detectRenamed(r api.Repository, blobMatcher BlobMatcher) (err error) {
-	//build a matrix of similarity scores
-	deletes, inserts := result.deleteEdits, result.insertEdits
-	scores := make([]float64, len(deletes)*len(inserts))
-	for di, delete := range deletes {
-		for ii, insert := range inserts {
-			var objA, objB objects.Object
-			if objA, err = r.ObjectFromOid(delete.Before.ObjectId()); err != nil {
-				return
-			}
-			if objB, err = r.ObjectFromOid(insert.After.ObjectId()); err != nil {
-				return err
-			}
-			a, _ := objA.(*objects.Blob)
-			b, _ := objB.(*objects.Blob)
-			index := di*(len(deletes)-1) + ii
-			scores[index] = blobMatcher.Match(a, b)
+		if edit.action != Insert && edit.action != Delete {
+			result.edits = append(result.edits, edit)
 		}
 	}
-	//get the indices of the score matrix in sorted order (i.e., from greatest score to least)
-	sorted := indexedInOrder(scores)
-	deletesDone, insertsDone := make([]bool, len(deletes)), make([]bool, len(inserts))
-	renameCount := 0
-	for _, index := range sorted {
-		score := scores[index]
-		if score < 60 {
-			break
+	if opts.Renames != nil {
+		if err := result.detectRenames(unchanged, before, after, opts.Renames); err != nil {
+			return nil, err
 		}
-		di := int(index) / (len(deletes) - 1)
-		ii := int(index) - (di * (len(deletes) - 1))
-		if deletesDone[di] || insertsDone[ii] {
-			continue
-		}
-		renameCount++
-		rename, insert := result.deleteEdits[di], result.insertEdits[ii]
-		result.insertEdits[ii], result.deleteEdits[di] = nil, nil
-		rename.After = insert.After
-		rename.action = Rename
-		rename.score = score
-		result.renamed = append(result.renamed, rename)
-		deletesDone[di], insertsDone[ii] = true, true
 	}
-	//prune the removed (nil) edits and populate the \deleted\ []*objects.TreeEntry and \inserted\ []*objects.TreeEntry
+	result.collect()
+	sort.Stable(byPath(result.edits))
+	return result, nil
+}
+
+type byPath []*TreeEdit
+
+func (e byPath) Len() int           { return len(e) }
+func (e byPath) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byPath) Less(i, j int) bool { return e[i].Path() < e[j].Path() }
+
+// collect prunes the edits that were removed by rename
+// detection (nil) and populates the inserted and deleted
+// entries.
+func (result *TreeDiff) collect() {
 	deleteEdits, insertEdits := result.deleteEdits, result.insertEdits
-	result.edits, result.deleteEdits, result.insertEdits = nil, nil, nil
+	result.deleteEdits, result.insertEdits = nil, nil
 	for _, v := range insertEdits {
 		if v == nil {
 			continue
@@ -358,36 +372,4 @@ func (result *TreeDiff) detectRenamed(r api.Repository, blobMatcher BlobMatcher)
 		result.deleteEdits = append(result.deleteEdits, v)
 		result.deleted = append(result.deleted, v.Before)
 	}
-	result.edits = append(result.edits, result.modified...)
-	result.edits = append(result.edits, result.renamed...)
-	return
-}
-
-type byValueInReverse struct {
-	values  []float64
-	indices []int
-}
-
-func indexedInOrder(scores []float64) []int {
-	//1. create a slice of score indices with initial values from 0 to len(scores) - 1
-	//2. sort that slice as though its values were the scores
-	bv := &byValueInReverse{scores, make([]int, len(scores))}
-	for i := 0; i < len(bv.values); i++ {
-		bv.indices[i] = i
-	}
-	sort.Sort(bv)
-	return bv.indices
-}
-
-func (bv *byValueInReverse) Len() int {
-	return len(bv.values)
-}
-
-func (bv *byValueInReverse) Swap(i, j int) {
-	bv.indices[i], bv.indices[j] = bv.indices[j], bv.indices[i]
-}
-
-func (bv *byValueInReverse) Less(i, j int) bool {
-	a, b := bv.values[bv.indices[i]], bv.values[bv.indices[j]]
-	return int(a) > int(b)
 }
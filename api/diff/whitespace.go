@@ -0,0 +1,350 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+whitespace.go implements the whitespace errors of core.whitespace, which
+colored diffs highlight in added lines and git-apply warns about or fixes,
+as git's ws.c does.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// WHITESPACE RULES
+// ================================================================= //
+
+// WhitespaceRule is a set of kinds of whitespace errors, and the
+// width of a tab, which is kept in the lowest bits.
+type WhitespaceRule uint
+
+const (
+	WSBlankAtEOL       WhitespaceRule = 1 << (iota + 6) // whitespace at the end of a line
+	WSSpaceBeforeTab                                    // a space before a tab in the indent
+	WSIndentWithNonTab                                  // an indent of spaces that could be a tab
+	WSCRAtEOL                                           // carriage returns at the end of lines are fine
+	WSBlankAtEOF                                        // blank lines at the end of the file
+	WSTabInIndent                                       // a tab in the indent
+
+	// WSTrailingSpace is both kinds of trailing whitespace.
+	WSTrailingSpace = WSBlankAtEOL | WSBlankAtEOF
+
+	// DefaultWhitespaceRule is the rule when core.whitespace
+	// is not set.
+	DefaultWhitespaceRule = WSTrailingSpace | WSSpaceBeforeTab | 8
+
+	wsTabWidthMask WhitespaceRule = 077
+)
+
+// the names of the errors in core.whitespace
+var whitespaceNames = []struct {
+	name string
+	rule WhitespaceRule
+}{
+	{"trailing-space", WSTrailingSpace},
+	{"space-before-tab", WSSpaceBeforeTab},
+	{"indent-with-non-tab", WSIndentWithNonTab},
+	{"cr-at-eol", WSCRAtEOL},
+	{"blank-at-eol", WSBlankAtEOL},
+	{"blank-at-eof", WSBlankAtEOF},
+	{"tab-in-indent", WSTabInIndent},
+}
+
+// ParseWhitespaceRule parses core.whitespace, a comma-separated
+// list of errors that are added to the default rule, or taken
+// out of it with a leading "-", and of tabwidth=<n>. Unknown
+// errors are ignored.
+func ParseWhitespaceRule(value string) (WhitespaceRule, error) {
+	rule := DefaultWhitespaceRule
+	for _, item := range strings.Split(value, ",") {
+		item = strings.Trim(item, " \t\n\r\v\f")
+		if item == "" {
+			continue
+		}
+		neg := strings.HasPrefix(item, "-")
+		item = strings.TrimPrefix(item, "-")
+		if strings.HasPrefix(item, "tabwidth=") {
+			n, err := strconv.Atoi(item[len("tabwidth="):])
+			if err != nil || n <= 0 || WhitespaceRule(n) > wsTabWidthMask {
+				return 0, fmt.Errorf("tabwidth %s out of range", item[len("tabwidth="):])
+			}
+			rule = rule&^wsTabWidthMask | WhitespaceRule(n)
+			continue
+		}
+		for _, ws := range whitespaceNames {
+			if item != ws.name {
+				continue
+			}
+			if neg {
+				rule &^= ws.rule
+			} else {
+				rule |= ws.rule
+			}
+		}
+	}
+	if rule&WSTabInIndent != 0 && rule&WSIndentWithNonTab != 0 {
+		return 0, fmt.Errorf("cannot enforce both tab-in-indent and indent-with-non-tab")
+	}
+	return rule, nil
+}
+
+// TabWidth returns the width of a tab.
+func (rule WhitespaceRule) TabWidth() int {
+	return int(rule & wsTabWidthMask)
+}
+
+// ================================================================= //
+// CHECKING AND HIGHLIGHTING
+// ================================================================= //
+
+// CheckWhitespace returns the whitespace errors of a line.
+func CheckWhitespace(line string, rule WhitespaceRule) WhitespaceRule {
+	return checkWhitespace(nil, line, rule, "", "", "")
+}
+
+// checkWhitespace returns the whitespace errors of a line and,
+// if buf is not nil, prints the line with its errors in the ws
+// color and the rest of it in the set color, as git's
+// ws_check_emit does. The indent before the first error is not
+// colored at all.
+func checkWhitespace(buf *bytes.Buffer, line string, rule WhitespaceRule, set, reset, ws string) (errs WhitespaceRule) {
+	emit := func(s string) {
+		if buf != nil {
+			buf.WriteString(s)
+		}
+	}
+	newline := strings.HasSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\n")
+	cr := rule&WSCRAtEOL != 0 && strings.HasSuffix(line, "\r")
+	if cr {
+		line = line[:len(line)-1]
+	}
+
+	trailing := len(line)
+	if rule&WSBlankAtEOL != 0 {
+		for trailing > 0 && isSpace(line[trailing-1]) {
+			trailing--
+			errs |= WSBlankAtEOL
+		}
+	}
+
+	// the indent
+	written, i := 0, 0
+	for ; i < trailing; i++ {
+		if line[i] == ' ' {
+			continue
+		}
+		if line[i] != '\t' {
+			break
+		}
+		switch {
+		case rule&WSSpaceBeforeTab != 0 && written < i:
+			errs |= WSSpaceBeforeTab
+			emit(ws + line[written:i] + reset + "\t")
+		case rule&WSTabInIndent != 0:
+			errs |= WSTabInIndent
+			emit(line[written:i] + ws + "\t" + reset)
+		default:
+			emit(line[written : i+1])
+		}
+		written = i + 1
+	}
+	if rule&WSIndentWithNonTab != 0 && i-written >= rule.TabWidth() {
+		errs |= WSIndentWithNonTab
+		emit(ws + line[written:i] + reset)
+		written = i
+	}
+
+	if trailing > written {
+		emit(set + line[written:trailing] + reset)
+	}
+	if trailing < len(line) {
+		emit(ws + line[trailing:] + reset)
+	}
+	if cr {
+		emit("\r")
+	}
+	if newline {
+		emit("\n")
+	}
+	return errs
+}
+
+// Describe returns the names of whitespace errors, as git's
+// whitespace_error_string does.
+func (errs WhitespaceRule) Describe() string {
+	var names []string
+	switch {
+	case errs&WSTrailingSpace == WSTrailingSpace:
+		names = append(names, "trailing whitespace")
+	case errs&WSBlankAtEOL != 0:
+		names = append(names, "trailing whitespace")
+	case errs&WSBlankAtEOF != 0:
+		names = append(names, "new blank line at EOF")
+	}
+	if errs&WSSpaceBeforeTab != 0 {
+		names = append(names, "space before tab in indent")
+	}
+	if errs&WSIndentWithNonTab != 0 {
+		names = append(names, "indent with spaces")
+	}
+	if errs&WSTabInIndent != 0 {
+		names = append(names, "tab in indent")
+	}
+	return strings.Join(names, ", ")
+}
+
+// ================================================================= //
+// FIXING
+// ================================================================= //
+
+// FixWhitespace fixes the whitespace errors of a line, as git's
+// ws_fix_copy does: trailing whitespace is removed, and the indent
+// is rewritten with tabs, or with spaces for tab-in-indent. It
+// returns false if the line had nothing to fix.
+func FixWhitespace(line string, rule WhitespaceRule) (string, bool) {
+	fixed := false
+	tail := ""
+	if rule&WSBlankAtEOL != 0 {
+		if strings.HasSuffix(line, "\n") {
+			line, tail = line[:len(line)-1], "\n"
+			if strings.HasSuffix(line, "\r") {
+				line = line[:len(line)-1]
+				if rule&WSCRAtEOL != 0 {
+					tail = "\r\n"
+				}
+			}
+		}
+		if trimmed := strings.TrimRight(line, " \t\n\r"); len(trimmed) < len(line) {
+			line, fixed = trimmed, true
+		}
+	}
+
+	// look for errors in the indent
+	tabWidth := rule.TabWidth()
+	lastTab, lastSpace := -1, -1
+	fixIndent := false
+	for i := 0; i < len(line); i++ {
+		if c := line[i]; c == '\t' {
+			lastTab = i
+			if rule&WSSpaceBeforeTab != 0 && lastSpace >= 0 {
+				fixIndent = true
+			}
+		} else if c == ' ' {
+			lastSpace = i
+			if rule&WSIndentWithNonTab != 0 && i-lastTab >= tabWidth {
+				fixIndent = true
+			}
+		} else {
+			break
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	switch {
+	case fixIndent:
+		// spaces are dropped, or turned into tabs
+		last := lastTab + 1
+		if rule&WSIndentWithNonTab != 0 && lastTab < lastSpace {
+			last = lastSpace + 1
+		}
+		spaces := 0
+		for i := 0; i < last; i++ {
+			if line[i] != ' ' {
+				spaces = 0
+				buf.WriteByte(line[i])
+			} else if spaces++; spaces == tabWidth {
+				buf.WriteByte('\t')
+				spaces = 0
+			}
+		}
+		buf.WriteString(strings.Repeat(" ", spaces))
+		line, fixed = line[last:], true
+	case rule&WSTabInIndent != 0 && lastTab >= 0:
+		// tabs are expanded
+		for i := 0; i <= lastTab; i++ {
+			if line[i] != '\t' {
+				buf.WriteByte(line[i])
+				continue
+			}
+			for buf.WriteByte(' '); buf.Len()%tabWidth != 0; {
+				buf.WriteByte(' ')
+			}
+		}
+		line, fixed = line[lastTab+1:], true
+	}
+	buf.WriteString(line + tail)
+	return buf.String(), fixed
+}
+
+// ================================================================= //
+// BLANK LINES AT THE END
+// ================================================================= //
+
+// blankAtEOF finds the first of the blank lines that were added
+// to the end of a file, in both versions of the file, counting
+// lines from 1. They are 0 if no blank lines were added.
+func blankAtEOF(a, b []byte) (lineA, lineB int) {
+	blankA, blankB := countTrailingBlank(a), countTrailingBlank(b)
+	if blankB <= blankA {
+		return 0, 0
+	}
+	return countLines(a) - blankA + 1, countLines(b) - blankB + 1
+}
+
+// countTrailingBlank counts the blank lines at the end of data,
+// as git's count_trailing_blank does.
+func countTrailingBlank(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	n, end := 0, len(data)-1
+	if data[end] == '\n' {
+		end--
+	}
+	for 0 < end {
+		eol := bytes.LastIndexByte(data[:end+1], '\n')
+		if !isBlank(data[eol+1 : end+1]) {
+			break
+		}
+		n++
+		end = eol - 1
+	}
+	return n
+}
+
+// countLines counts the lines of data, including a last line
+// that has no line feed.
+func countLines(data []byte) int {
+	n := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// isBlank returns true if a line has nothing but whitespace.
+func isBlank(line []byte) bool {
+	for _, c := range line {
+		if !isSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSpace returns true for the bytes that git takes to be
+// whitespace, which don't include \v and \f.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
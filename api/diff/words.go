@@ -0,0 +1,238 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+words.go implements word diffs, which show the changes of each hunk word by
+word instead of line by line. The removed and added lines of each group
+of changes are split into words, which are compared as though they were
+lines, and the words that changed are marked in the text of the new
+lines.
+*/
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ================================================================= //
+// WORD DIFF MODES
+// ================================================================= //
+
+// WordDiffMode is the way in which changed words are marked.
+type WordDiffMode int
+
+const (
+	WordDiffNone      WordDiffMode = iota // no word diff
+	WordDiffPlain                         // [-removed-]{+added+}
+	WordDiffColor                         // removed and added words in their colors
+	WordDiffPorcelain                     // a line for each run of words, for scripts
+)
+
+var wordDiffModeNames = map[string]WordDiffMode{
+	"none":      WordDiffNone,
+	"plain":     WordDiffPlain,
+	"color":     WordDiffColor,
+	"porcelain": WordDiffPorcelain,
+}
+
+// ParseWordDiffMode returns the mode of the given name, as
+// accepted by --word-diff, which defaults to plain.
+func ParseWordDiffMode(name string) (WordDiffMode, error) {
+	if name == "" {
+		return WordDiffPlain, nil
+	}
+	if mode, ok := wordDiffModeNames[name]; ok {
+		return mode, nil
+	}
+	return WordDiffNone, fmt.Errorf("bad --word-diff argument: %s", name)
+}
+
+// CompileWordRegex compiles a regular expression that matches
+// words, as given by --word-diff-regex or diff.wordRegex. As in
+// git, ^ and $ match at line feeds, and the longest match is
+// taken.
+func CompileWordRegex(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?m:" + expr + ")")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %s", expr)
+	}
+	re.Longest()
+	return re, nil
+}
+
+// the way in which a word diff marks a kind of text
+type wordStyle struct {
+	prefix, suffix string
+	slot           ColorSlot
+}
+
+// the styles of removed, added and unchanged text, and what
+// marks the end of a line, in each mode
+var wordDiffStyles = map[WordDiffMode]struct {
+	old, new, ctx wordStyle
+	newline       string
+}{
+	WordDiffPlain: {
+		wordStyle{"[-", "-]", ColorOld},
+		wordStyle{"{+", "+}", ColorNew},
+		wordStyle{"", "", ColorContext},
+		"\n",
+	},
+	WordDiffColor: {
+		wordStyle{"", "", ColorOld},
+		wordStyle{"", "", ColorNew},
+		wordStyle{"", "", ColorContext},
+		"\n",
+	},
+	WordDiffPorcelain: {
+		wordStyle{"-", "\n", ColorOld},
+		wordStyle{"+", "\n", ColorNew},
+		wordStyle{" ", "\n", ColorContext},
+		"~\n",
+	},
+}
+
+// ================================================================= //
+// WORD DIFFS
+// ================================================================= //
+
+// wordDiffer collects the removed and added lines of a group of
+// changes, and prints them as a word diff.
+type wordDiffer struct {
+	mode        WordDiffMode
+	regex       *regexp.Regexp
+	colors      *Colors
+	minus, plus bytes.Buffer
+}
+
+// words splits text into words, and returns them as lines along
+// with where they are in the text. The first word is a fake
+// empty one at the start.
+func (wd *wordDiffer) words(text string) (lines []string, bounds [][2]int) {
+	bounds = [][2]int{{0, 0}}
+	for i := 0; i < len(text); {
+		begin, end, ok := wd.nextWord(text, i)
+		if !ok {
+			break
+		}
+		lines = append(lines, text[begin:end]+"\n")
+		bounds = append(bounds, [2]int{begin, end})
+		i = end
+	}
+	return
+}
+
+// nextWord finds the next word of text from i on, as git's
+// find_word_boundaries does. Words match the regular expression,
+// and end at line feeds; without one, they are runs of bytes
+// other than whitespace.
+func (wd *wordDiffer) nextWord(text string, i int) (begin, end int, ok bool) {
+	for wd.regex != nil && i < len(text) {
+		m := wd.regex.FindStringIndex(text[i:])
+		if m == nil {
+			return 0, 0, false
+		}
+		begin, end = i+m[0], i+m[1]
+		if j := strings.IndexByte(text[begin:end], '\n'); j >= 0 {
+			end = begin + j
+		}
+		if begin != end {
+			return begin, end, true
+		}
+		i = begin + 1
+	}
+	for i < len(text) && isSpace(text[i]) {
+		i++
+	}
+	if i >= len(text) {
+		return 0, 0, false
+	}
+	end = i + 1
+	for end < len(text) && !isSpace(text[end]) {
+		end++
+	}
+	return i, end, true
+}
+
+// flush prints the word diff of the lines that were collected,
+// if there are any, as git's diff_words_show does. The text
+// between the changed words is taken from the new lines.
+func (wd *wordDiffer) flush(buf *bytes.Buffer) {
+	if wd.minus.Len() == 0 && wd.plus.Len() == 0 {
+		return
+	}
+	style := wordDiffStyles[wd.mode]
+	minus, plus := wd.minus.String(), wd.plus.String()
+	wd.minus.Reset()
+	wd.plus.Reset()
+
+	// only removals
+	if plus == "" {
+		wd.write(buf, style.old, style.newline, minus)
+		return
+	}
+
+	linesA, boundsA := wd.words(minus)
+	linesB, boundsB := wd.words(plus)
+	current := 0
+	for _, c := range diffRecords(linesA, linesB, false).Changes {
+		// the ranges of changed words are found in the way that
+		// xdiff numbers hunks, which the fake first word allows
+		var beginA, endA, beginB, endB int
+		if c.Del > 0 {
+			beginA, endA = boundsA[c.A+1][0], boundsA[c.A+c.Del][1]
+		} else {
+			beginA, endA = boundsA[c.A][1], boundsA[c.A][1]
+		}
+		if c.Ins > 0 {
+			beginB, endB = boundsB[c.B+1][0], boundsB[c.B+c.Ins][1]
+		} else {
+			beginB, endB = boundsB[c.B][1], boundsB[c.B][1]
+		}
+		if current != beginB {
+			wd.write(buf, style.ctx, style.newline, plus[current:beginB])
+		}
+		if beginA != endA {
+			wd.write(buf, style.old, style.newline, minus[beginA:endA])
+		}
+		if beginB != endB {
+			wd.write(buf, style.new, style.newline, plus[beginB:endB])
+		}
+		current = endB
+	}
+	if current != len(plus) {
+		wd.write(buf, style.ctx, style.newline, plus[current:])
+	}
+}
+
+// write prints text in a style, line by line, ending each line
+// with the newline of the mode.
+func (wd *wordDiffer) write(buf *bytes.Buffer, style wordStyle, newline, text string) {
+	color, reset := wd.colors.Get(style.slot), ""
+	if color != "" {
+		reset = wd.colors.Reset()
+	}
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		if i != 0 {
+			line := text
+			if i > 0 {
+				line = text[:i]
+			}
+			buf.WriteString(color + style.prefix + line + style.suffix + reset)
+		}
+		if i < 0 {
+			return
+		}
+		buf.WriteString(newline)
+		text = text[i+1:]
+	}
+}
@@ -10,12 +10,14 @@ package api
 import (
 	"bufio"
 	"compress/zlib"
+	"crypto/sha1"
 	"errors"
 	"fmt"
 	"github.com/jbrukh/ggit/api/objects"
 	"github.com/jbrukh/ggit/api/parse"
 	"github.com/jbrukh/ggit/util"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -51,6 +53,19 @@ func (repo *DiskRepository) Destroy() error {
 	return os.RemoveAll(dir)
 }
 
+// Path returns the path of the git directory of this
+// repository, usually ending in .git.
+func (repo *DiskRepository) Path() string {
+	return repo.path
+}
+
+// WorkDir returns the path of the working tree of this
+// repository, which is the directory that encloses the
+// git directory.
+func (repo *DiskRepository) WorkDir() string {
+	return filepath.Dir(repo.path)
+}
+
 func (repo *DiskRepository) ObjectFromOid(oid *objects.ObjectId) (obj objects.Object, err error) {
 	var (
 		f  *os.File
@@ -126,6 +141,70 @@ func (repo *DiskRepository) ObjectFromShortOid(short string) (objects.Object, er
 	return repo.ObjectFromOid(matching[0])
 }
 
+// HasObject returns true if the repository has the object
+// with the given oid, either loose or packed.
+func (repo *DiskRepository) HasObject(oid *objects.ObjectId) bool {
+	if _, err := os.Stat(objectPath(repo, oid)); err == nil {
+		return true
+	}
+	if err := loadPacks(repo); err != nil {
+		return false
+	}
+	return parse.Contains(repo.packs, oid)
+}
+
+// WriteObject stores an object in the repository as a loose
+// object and returns its oid. Objects that the repository
+// already has are not written again.
+func (repo *DiskRepository) WriteObject(o objects.Object) (*objects.ObjectId, error) {
+	data, err := encodeObject(o)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(data)
+	oid := objects.OidFromArray(sum)
+	if repo.HasObject(oid) {
+		return oid, nil
+	}
+
+	// the object is written to a temporary file first, so
+	// that nobody sees it half-written
+	pth := objectPath(repo, oid)
+	if err = os.MkdirAll(filepath.Dir(pth), 0777); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(pth), "tmp_obj_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	wz := zlib.NewWriter(f)
+	_, err = wz.Write(data)
+	if e := wz.Close(); err == nil {
+		err = e
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0444)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), pth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
+// WriteBlob stores data in the repository as a blob and
+// returns its oid.
+func (repo *DiskRepository) WriteBlob(data []byte) (*objects.ObjectId, error) {
+	hdr := objects.NewObjectHeader(objects.ObjectBlob, int64(len(data)))
+	return repo.WriteObject(objects.NewBlob(nil, hdr, data))
+}
+
 // Ref is a repository-based baseline method for getting refs. The
 // ref spec is the full path of the ref that is relative to the .git
 // directory.
@@ -140,8 +219,12 @@ func (repo *DiskRepository) Ref(spec string) (objects.Ref, error) {
 	file, e := relativeFile(repo, spec)
 	if e == nil {
 		defer file.Close()
-		p := parse.NewRefParser(bufio.NewReader(file), spec)
-		return p.ParseRef()
+		// a directory of refs is no ref
+		if info, err := file.Stat(); err != nil || !info.IsDir() {
+			p := parse.NewRefParser(bufio.NewReader(file), spec)
+			return p.ParseRef()
+		}
+		e = os.ErrNotExist
 	}
 	if os.IsNotExist(e) {
 		refs, err := repo.PackedRefs()
@@ -201,6 +284,36 @@ func (repo *DiskRepository) Index() (idx *Index, err error) {
 	return toIndex(bufio.NewReader(file))
 }
 
+// LockIndex takes the lock of the index. The new index is
+// written to the lock, which replaces the index when it is
+// committed.
+func (repo *DiskRepository) LockIndex() (*LockFile, error) {
+	return Lock(path.Join(repo.path, IndexFile))
+}
+
+// WriteIndex replaces the index with the given one through the
+// lock taken by LockIndex, which is released either way.
+func (repo *DiskRepository) WriteIndex(lock *LockFile, idx *Index) error {
+	if _, err := idx.WriteTo(lock); err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// Reflog returns the entries of the log of a ref, oldest first. A
+// ref without a log has no entries.
+func (repo *DiskRepository) Reflog(ref string) ([]*objects.ReflogEntry, error) {
+	file, e := relativeFile(repo, path.Join(LogsDir, ref))
+	if os.IsNotExist(e) {
+		return nil, nil
+	} else if e != nil {
+		return nil, e
+	}
+	defer file.Close()
+	return parse.NewReflogParser(bufio.NewReader(file)).ParseReflog()
+}
+
 func (repo *DiskRepository) PackedRefs() ([]objects.Ref, error) {
 	if repo.packedRefs == nil {
 		file, e := relativeFile(repo, PackedRefsFile)
@@ -316,13 +429,16 @@ func AssertDiskRepo(repo Repository) (*DiskRepository, error) {
 	return nil, errors.New("fatal: not a disk repository")
 }
 
-// objectFile turns an oid into a path relative to the
-// git directory of a repository where that object should
-// be located (if it is a loose object).
+// objectFile opens the file of a loose object.
 func objectFile(repo *DiskRepository, oid *objects.ObjectId) (file *os.File, err error) {
+	return os.Open(objectPath(repo, oid))
+}
+
+// objectPath turns an oid into the path where that object
+// should be located, if it is a loose object.
+func objectPath(repo *DiskRepository, oid *objects.ObjectId) string {
 	hex := oid.String()
-	path := path.Join(repo.path, DefaultObjectsDir, hex[0:2], hex[2:])
-	return os.Open(path)
+	return path.Join(repo.path, DefaultObjectsDir, hex[0:2], hex[2:])
 }
 
 // relativeFile returns the full path (including the repository path)
@@ -0,0 +1,177 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+idents.go implements the identities that are recorded in commits and tags,
+which are taken from the environment and the configuration, as git does.
+*/
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================================================================= //
+// IDENTITIES
+// ================================================================= //
+
+// the roles that identities are recorded in
+const (
+	RoleAuthor    = "author"
+	RoleCommitter = "committer"
+)
+
+// ErrNoEmail is returned when the email of an identity isn't set
+// anywhere.
+var ErrNoEmail = errors.New("unable to auto-detect email address")
+
+// Ident returns the identity of the author or the committer of a
+// commit that is made now. Names and emails come from the variables
+// GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL (or GIT_COMMITTER_NAME and
+// GIT_COMMITTER_EMAIL), or from the configuration of author.name and
+// author.email (or committer.name and committer.email), user.name and
+// user.email. Emails may also come from EMAIL. The date is the one
+// of GIT_AUTHOR_DATE (or GIT_COMMITTER_DATE), or the current time.
+func Ident(config *Config, role string) (*objects.WhoWhen, error) {
+	env := "GIT_" + strings.ToUpper(role) + "_"
+	name := identValue(config, env+"NAME", role+".name", "user.name")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = strings.SplitN(u.Name, ",", 2)[0]
+			if name == "" {
+				name = u.Username
+			}
+		}
+	}
+	email := identValue(config, env+"EMAIL", role+".email", "user.email")
+	if email == "" {
+		email = os.Getenv("EMAIL")
+	}
+	if email == "" {
+		return nil, ErrNoEmail
+	}
+	name, email = withoutCrud(name), withoutCrud(email)
+	if name == "" {
+		return nil, fmt.Errorf("empty ident name (for <%s>) not allowed", email)
+	}
+
+	seconds, offset, err := IdentDate(role)
+	if err != nil {
+		return nil, err
+	}
+	return objects.NewWhoWhen(name, email, seconds, offset), nil
+}
+
+// IdentDate returns the date of an identity of the given role,
+// which is the one of GIT_AUTHOR_DATE (or GIT_COMMITTER_DATE), or
+// the current time, in seconds since the epoch and the offset of
+// the time zone in minutes.
+func IdentDate(role string) (int64, int, error) {
+	if date := os.Getenv("GIT_" + strings.ToUpper(role) + "_DATE"); date != "" {
+		return ParseDate(date)
+	}
+	seconds, offset := now()
+	return seconds, offset, nil
+}
+
+// identValue returns the value of the first of an environment
+// variable and configuration variables that is set.
+func identValue(config *Config, env string, keys ...string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	for _, key := range keys {
+		if v, ok := config.Get(key); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// withoutCrud strips the punctuation and the whitespace that names
+// and emails start or end with, and removes the characters that
+// delimit them in identities.
+func withoutCrud(s string) string {
+	s = strings.TrimFunc(s, func(r rune) bool {
+		return r <= ' ' || strings.ContainsRune(".,:;<>\"\\'", r)
+	})
+	return strings.NewReplacer("\n", "", "<", "", ">", "").Replace(s)
+}
+
+// ================================================================= //
+// DATES
+// ================================================================= //
+
+// matches raw dates, as in "1300000000 +0100" and "@1300000000"
+var rawDate = regexp.MustCompile(`^@?([0-9]+)(?: ([+-][0-9]{4}))?$`)
+
+// the layouts of the other dates that are understood, the ones
+// without a zone being in local time
+var dateLayouts = []string{
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// ParseDate parses a date as git's environment variables give them,
+// either raw, as in "1300000000 +0100", or in the formats of RFC 2822
+// or ISO 8601. It returns the seconds since the epoch and the offset
+// of the time zone in minutes.
+func ParseDate(date string) (int64, int, error) {
+	date = strings.TrimSpace(date)
+	if m := rawDate.FindStringSubmatch(date); m != nil {
+		seconds, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid date format: %s", date)
+		}
+		if m[2] == "" {
+			_, offset := now()
+			return seconds, offset, nil
+		}
+		return seconds, parseZone(m[2]), nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			_, offset := t.Zone()
+			return t.Unix(), offset / 60, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid date format: %s", date)
+}
+
+// parseZone parses a time zone such as "-0130" into minutes.
+func parseZone(zone string) int {
+	hours, _ := strconv.Atoi(zone[1:3])
+	minutes, _ := strconv.Atoi(zone[3:5])
+	offset := hours*60 + minutes
+	if zone[0] == '-' {
+		return -offset
+	}
+	return offset
+}
+
+// now returns the current time, in seconds since the epoch, and the
+// offset of the local time zone in minutes.
+func now() (int64, int) {
+	t := time.Now()
+	_, offset := t.Zone()
+	return t.Unix(), offset / 60
+}
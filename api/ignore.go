@@ -0,0 +1,270 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+ignore.go implements gitignore rules, which decide which untracked files
+in the working tree are intentionally left untracked.
+*/
+package api
+
+import (
+	"bufio"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+const (
+	// IgnoreFile is the name of the per-directory ignore file.
+	IgnoreFile = ".gitignore"
+
+	// InfoExcludeFile is the repository-wide ignore file,
+	// relative to the git directory.
+	InfoExcludeFile = "info/exclude"
+)
+
+// ================================================================= //
+// IGNORE PATTERNS
+// ================================================================= //
+
+// ignorePattern is a single line of an ignore file.
+type ignorePattern struct {
+	base     string // directory of the defining file, with a trailing slash
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	basename bool // the pattern has no slash and matches the basename
+}
+
+// parseIgnorePattern parses one line of an ignore file,
+// returning nil for blank lines and comments.
+func parseIgnorePattern(line, base string) *ignorePattern {
+	line = strings.TrimRight(line, "\r")
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" {
+		return nil
+	}
+	p := &ignorePattern{base: base}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	p.basename = !strings.Contains(line, "/")
+	p.pattern = strings.TrimPrefix(line, "/")
+	return p
+}
+
+// match returns true if the pattern matches the given
+// path, which is relative to the top of the working tree.
+func (p *ignorePattern) match(pth string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.basename {
+		return util.Wildmatch(p.pattern, path.Base(pth), 0)
+	}
+	if !strings.HasPrefix(pth, p.base) {
+		return false
+	}
+	return util.Wildmatch(p.pattern, pth[len(p.base):], util.WildPathname)
+}
+
+// ignoreList is an ordered list of patterns, in which
+// the last matching pattern decides the outcome.
+type ignoreList []*ignorePattern
+
+// match returns whether some pattern of the list matched
+// the path and, if so, whether the path is ignored.
+func (l ignoreList) match(pth string, isDir bool) (matched, ignored bool) {
+	for i := len(l) - 1; i >= 0; i-- {
+		if l[i].match(pth, isDir) {
+			return true, !l[i].negate
+		}
+	}
+	return false, false
+}
+
+// readIgnoreFile reads the patterns of an ignore file. A
+// file that doesn't exist yields no patterns.
+func readIgnoreFile(file, base string) (ignoreList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var list ignoreList
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p := parseIgnorePattern(scanner.Text(), base); p != nil {
+			list = append(list, p)
+		}
+	}
+	return list, scanner.Err()
+}
+
+// ================================================================= //
+// IGNORER
+// ================================================================= //
+
+// Ignorer decides whether paths in a working tree are ignored.
+// Patterns are consulted in order of precedence: patterns given
+// explicitly, then those of the per-directory files from the
+// deepest directory up, and finally those of the global files.
+// Within each of these, the last matching pattern wins.
+type Ignorer struct {
+	workDir string
+	cmdline ignoreList
+	perDir  string
+	dirs    map[string]ignoreList
+	files   ignoreList
+}
+
+// NewIgnorer returns an ignorer for the given working
+// tree, which has no patterns at all.
+func NewIgnorer(workDir string) *Ignorer {
+	return &Ignorer{
+		workDir: workDir,
+		dirs:    make(map[string]ignoreList),
+	}
+}
+
+// NewStandardIgnorer returns an ignorer with git's standard
+// rules: the per-directory .gitignore files, the exclude
+// file of the repository and the user's global ignore file.
+func NewStandardIgnorer(repo *DiskRepository) (*Ignorer, error) {
+	ig := NewIgnorer(repo.WorkDir())
+	ig.SetPerDirectoryFile(IgnoreFile)
+	if file := globalIgnoreFile(); file != "" {
+		if err := ig.AddPatternFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := ig.AddPatternFile(path.Join(repo.path, InfoExcludeFile)); err != nil {
+		return nil, err
+	}
+	return ig, nil
+}
+
+// AddPattern adds a pattern with the highest precedence,
+// as if it was given on the command line.
+func (ig *Ignorer) AddPattern(pattern string) {
+	if p := parseIgnorePattern(pattern, ""); p != nil {
+		ig.cmdline = append(ig.cmdline, p)
+	}
+}
+
+// AddPatternFile reads patterns from a file, which have
+// a lower precedence than per-directory patterns. Files
+// that are added later take precedence.
+func (ig *Ignorer) AddPatternFile(file string) error {
+	list, err := readIgnoreFile(file, "")
+	if err != nil {
+		return err
+	}
+	ig.files = append(ig.files, list...)
+	return nil
+}
+
+// SetPerDirectoryFile sets the name of the ignore file
+// that is read from every directory.
+func (ig *Ignorer) SetPerDirectoryFile(name string) {
+	ig.perDir = name
+	ig.dirs = make(map[string]ignoreList)
+}
+
+// Ignored returns true if and only if the path, which is
+// relative to the top of the working tree, is ignored. A path
+// is also ignored when any of its leading directories are.
+func (ig *Ignorer) Ignored(pth string, isDir bool) bool {
+	parts := strings.Split(pth, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.ignored(pth, isDir)
+}
+
+// ignored decides a single path without looking at
+// its leading directories.
+func (ig *Ignorer) ignored(pth string, isDir bool) bool {
+	if matched, ignored := ig.cmdline.match(pth, isDir); matched {
+		return ignored
+	}
+	if ig.perDir != "" {
+		dir := path.Dir(pth)
+		for {
+			if dir == "." {
+				dir = ""
+			}
+			if matched, ignored := ig.dirList(dir).match(pth, isDir); matched {
+				return ignored
+			}
+			if dir == "" {
+				break
+			}
+			dir = path.Dir(dir)
+		}
+	}
+	_, ignored := ig.files.match(pth, isDir)
+	return ignored
+}
+
+// dirList returns the patterns defined in the given
+// directory, reading them the first time around.
+func (ig *Ignorer) dirList(dir string) ignoreList {
+	list, ok := ig.dirs[dir]
+	if !ok {
+		base := dir
+		if base != "" {
+			base += "/"
+		}
+		file := filepath.Join(ig.workDir, filepath.FromSlash(dir), ig.perDir)
+		list, _ = readIgnoreFile(file, base) // unreadable files are skipped
+		ig.dirs[dir] = list
+	}
+	return list
+}
+
+// globalIgnoreFile returns the location of the user's
+// global ignore file, or the empty string if the user
+// doesn't have one.
+func globalIgnoreFile() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package api
+
+import (
+	"github.com/jbrukh/ggit/util"
+	"testing"
+)
+
+func Test_parseIgnorePattern(t *testing.T) {
+	util.Assert(t, parseIgnorePattern("", "") == nil)
+	util.Assert(t, parseIgnorePattern("# comment", "") == nil)
+	util.Assert(t, parseIgnorePattern("   ", "") == nil)
+
+	p := parseIgnorePattern("!/build/  ", "a/")
+	util.Assert(t, p.negate && p.dirOnly && !p.basename)
+	util.AssertEqualString(t, "build", p.pattern)
+	util.AssertEqualString(t, "a/", p.base)
+
+	p = parseIgnorePattern(`\#notacomment`, "")
+	util.AssertEqualString(t, "#notacomment", p.pattern)
+	util.Assert(t, p.basename)
+}
+
+func Test_Ignored(t *testing.T) {
+	ig := NewIgnorer("/nonexistent")
+	ig.AddPattern("*.o")
+	ig.AddPattern("!keep.o")
+	ig.AddPattern("/top")
+	ig.AddPattern("build/")
+	ig.AddPattern("doc/**/*.html")
+
+	util.Assert(t, ig.Ignored("x.o", false))
+	util.Assert(t, ig.Ignored("deep/down/x.o", false))
+	util.Assert(t, !ig.Ignored("keep.o", false))
+	util.Assert(t, ig.Ignored("top", false))
+	util.Assert(t, !ig.Ignored("sub/top", false))
+	util.Assert(t, ig.Ignored("build", true))
+	util.Assert(t, !ig.Ignored("build", false))
+	util.Assert(t, ig.Ignored("sub/build/file.c", false))
+	util.Assert(t, ig.Ignored("doc/index.html", false))
+	util.Assert(t, ig.Ignored("doc/a/b/index.html", false))
+	util.Assert(t, !ig.Ignored("src/index.html", false))
+}
@@ -10,12 +10,16 @@ package api
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha1"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/jbrukh/ggit/api/objects"
 	"github.com/jbrukh/ggit/api/token"
 	"github.com/jbrukh/ggit/util"
+	"io"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -62,8 +66,7 @@ type Index struct {
 }
 
 // Version returns the version of the index
-// file. Currently I am supporting version 2.
-// TODO: support 3, 4, ...
+// file. Versions 2 through 4 are supported.
 func (inx *Index) Version() int32 {
 	return inx.version
 }
@@ -75,6 +78,71 @@ func (inx *Index) Entries() []*IndexEntry {
 	return inx.entries
 }
 
+// Entry returns the entry with the given name at the
+// given stage, or nil if the index has no such entry.
+func (inx *Index) Entry(name string, stage int) *IndexEntry {
+	i := sort.Search(len(inx.entries), func(i int) bool {
+		e := inx.entries[i]
+		return e.name > name || (e.name == name && e.Stage() >= stage)
+	})
+	if i < len(inx.entries) && inx.entries[i].name == name && inx.entries[i].Stage() == stage {
+		return inx.entries[i]
+	}
+	return nil
+}
+
+// Add adds an entry to the index, replacing the entry of the
+// same name at the same stage. An entry at stage 0 replaces
+// the other stages of its name, and the other way around.
+// Entries that are in the way of its path, those named after
+// its leading directories and those inside of it, are removed
+// as well. The conflicts that an entry at stage 0 resolves are
+// recorded, so that they can be undone.
+func (inx *Index) Add(entry *IndexEntry) {
+	name, stage := entry.name, entry.Stage()
+	inx.invalidate(name)
+	entries := inx.entries[:0]
+	for _, e := range inx.entries {
+		switch {
+		case e.name == name && (e.Stage() == stage || e.Stage() == 0 || stage == 0):
+			if stage == 0 {
+				inx.recordResolveUndo(e)
+			}
+		case strings.HasPrefix(name, e.name+"/"), strings.HasPrefix(e.name, name+"/"):
+			inx.invalidate(e.name)
+			inx.recordResolveUndo(e)
+		default:
+			entries = append(entries, e)
+		}
+	}
+	i := sort.Search(len(entries), func(i int) bool {
+		e := entries[i]
+		return e.name > name || (e.name == name && e.Stage() > stage)
+	})
+	entries = append(entries, nil)
+	copy(entries[i+1:], entries[i:])
+	entries[i] = entry
+	inx.entries = entries
+}
+
+// Remove removes all the stages of the entry with the given
+// name, and returns false if the index has no such entry. The
+// conflict that it resolves, if any, is recorded.
+func (inx *Index) Remove(name string) bool {
+	inx.invalidate(name)
+	entries := inx.entries[:0]
+	for _, e := range inx.entries {
+		if e.name != name {
+			entries = append(entries, e)
+		} else {
+			inx.recordResolveUndo(e)
+		}
+	}
+	removed := len(entries) < len(inx.entries)
+	inx.entries = entries
+	return removed
+}
+
 // Extentions will visit and/or return the
 // index file extentions.
 func (inx *Index) Extentions() []*IndexExtention {
@@ -104,40 +172,193 @@ func (inx *Index) String() string {
 	return buf.String()
 }
 
+// WriteTo writes the index in the format that git reads, in its
+// version, or in version 2 if it is new. Version 2 indexes are
+// written as version 3 if an entry has extended flags. Of the
+// extentions, only the cached trees and the resolved conflicts
+// are written.
+func (inx *Index) WriteTo(w io.Writer) (int64, error) {
+	version := inx.version
+	if version == 0 {
+		version = 2
+	}
+	for _, entry := range inx.entries {
+		if entry.extFlags != 0 && version < 3 {
+			version = 3
+		}
+	}
+	buf := new(bytes.Buffer)
+	hdr := indexHeader{Version: version, Count: int32(len(inx.entries))}
+	copy(hdr.Sig[:], SIG_INDEX_FILE)
+	binary.Write(buf, ord, &hdr)
+	prev := ""
+	for _, entry := range inx.entries {
+		writeIndexEntry(buf, entry, version, prev)
+		prev = entry.name
+	}
+	if tree := inx.CachedTree(); tree != nil && len(tree.entries) > 0 {
+		writeCachedTree(buf, tree)
+	}
+	if undo := inx.ResolveUndo(); undo != nil && len(undo.entries) > 0 {
+		writeResolveUndo(buf, undo)
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.WriteTo(w)
+}
+
+// IndexEntry is an entry of the index, which stages a blob
+// (or a commit, for a submodule) under a path.
 type IndexEntry struct {
-	eid   *objects.ObjectId // TODO: is this an object id, or just a SHA??
-	flags EntryFlagsV2
-	name  string
-	info  *statInfo
+	eid      *objects.ObjectId // TODO: is this an object id, or just a SHA??
+	flags    EntryFlagsV2
+	extFlags EntryFlagsV3
+	name     string
+	info     *StatInfo
+}
+
+// NewIndexEntry returns an entry that stages the object with
+// the given oid and mode under a name, at a stage. The stat data
+// is that of the file that the entry was staged from, and may
+// be nil if there is no such file.
+func NewIndexEntry(name string, stage int, oid *objects.ObjectId, mode objects.FileMode, info *StatInfo) *IndexEntry {
+	stat := new(StatInfo)
+	if info != nil {
+		*stat = *info
+	}
+	stat.Mode = int32(mode)
+	flags := EntryFlagsV2(stage<<flagStageShift) & flagStageMask
+	if len(name) < int(flagNameMask) {
+		flags |= EntryFlagsV2(len(name))
+	} else {
+		flags |= flagNameMask
+	}
+	return &IndexEntry{
+		eid:   oid,
+		flags: flags,
+		name:  name,
+		info:  stat,
+	}
+}
+
+// IndexFromTree returns a new index, held in memory, that stages
+// the files of a tree at stage 0, as git-read-tree does. The
+// entries have no stat data.
+func IndexFromTree(repo Repository, t *objects.Tree) (*Index, error) {
+	idx := new(Index)
+	err := WalkTree(repo, t, "", func(pth string, e *objects.TreeEntry) error {
+		if e.ObjectType() != objects.ObjectTree {
+			idx.entries = append(idx.entries, NewIndexEntry(pth, 0, e.ObjectId(), e.Mode(), nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
 }
 
 func (entry *IndexEntry) String() string {
 	return fmt.Sprint(entry.eid.String(), " ", entry.info.String(), " ", entry.name)
 }
 
+// ObjectId returns the oid of the blob (or, for gitlinks,
+// the commit) that is staged for this path.
+func (entry *IndexEntry) ObjectId() *objects.ObjectId {
+	return entry.eid
+}
+
+// Name returns the full path of this entry, relative to
+// the top of the working tree.
+func (entry *IndexEntry) Name() string {
+	return entry.name
+}
+
+// Mode returns the file mode of this entry, as it would
+// appear in a tree.
+func (entry *IndexEntry) Mode() objects.FileMode {
+	return objects.FileMode(entry.info.Mode)
+}
+
+// Stage returns the merge stage of this entry, which is
+// 0 for normal entries and 1-3 for unmerged entries.
+func (entry *IndexEntry) Stage() int {
+	return int(entry.flags.Stage())
+}
+
+// Flags returns the flags of this entry.
+func (entry *IndexEntry) Flags() EntryFlagsV2 {
+	return entry.flags
+}
+
+// ExtendedFlags returns the version 3 extended flags of
+// this entry, which are zero if the entry doesn't have them.
+func (entry *IndexEntry) ExtendedFlags() EntryFlagsV3 {
+	return entry.extFlags
+}
+
+// StatInfo returns the cached stat data of the file that
+// this entry was last updated from.
+func (entry *IndexEntry) StatInfo() *StatInfo {
+	return entry.info
+}
+
 // EntryFlags for version 2
-type EntryFlagsV2 int16
+type EntryFlagsV2 uint16
+
+// flag bits of EntryFlagsV2
+const (
+	flagAssumeValid EntryFlagsV2 = 0x8000
+	flagExtended    EntryFlagsV2 = 0x4000
+	flagStageMask   EntryFlagsV2 = 0x3000
+	flagStageShift               = 12
+	flagNameMask    EntryFlagsV2 = 0x0fff
+)
 
-// TODO: document
-func (f *EntryFlagsV2) AssumeValid() bool {
-	return false
+// AssumeValid returns true if git should not check the
+// working tree file for changes.
+func (f EntryFlagsV2) AssumeValid() bool {
+	return f&flagAssumeValid != 0
 }
 
-func (f *EntryFlagsV2) Extended() bool {
-	return false // should be false for version 2
+// Extended returns true if the entry is followed by
+// a set of extended flags, which is only valid for
+// version 3 and above.
+func (f EntryFlagsV2) Extended() bool {
+	return f&flagExtended != 0
 }
 
-// TODO: this returns some two-bit result
-// not yet clear what it is for
-func (f *EntryFlagsV2) Stage() byte {
-	return 0x000 // TODO TODO TODO
+// Stage returns the two-bit merge stage of the entry.
+func (f EntryFlagsV2) Stage() byte {
+	return byte((f & flagStageMask) >> flagStageShift)
 }
 
 // 12-bit name length if less than 0xFFF, and
 // 0xFFF otherwise
-func (f *EntryFlagsV2) NameLength() int {
-	// TODO
-	return 0
+func (f EntryFlagsV2) NameLength() int {
+	return int(f & flagNameMask)
+}
+
+// EntryFlags for version 3, which follow the version 2
+// flags when the extended bit is set.
+type EntryFlagsV3 uint16
+
+// flag bits of EntryFlagsV3
+const (
+	flagSkipWorktree EntryFlagsV3 = 0x4000
+	flagIntentToAdd  EntryFlagsV3 = 0x2000
+)
+
+// SkipWorktree returns true if the entry is excluded
+// from the working tree by a sparse checkout.
+func (f EntryFlagsV3) SkipWorktree() bool {
+	return f&flagSkipWorktree != 0
+}
+
+// IntentToAdd returns true if the entry was added with
+// git add -N and has no real content yet.
+func (f EntryFlagsV3) IntentToAdd() bool {
+	return f&flagIntentToAdd != 0
 }
 
 // ================================================================= //
@@ -153,6 +374,9 @@ type IndexExtention interface {
 	Size() int
 }
 
+// CachedTreeIndexExtention records the trees that the directories
+// of the index were last written as, so that those that haven't
+// changed since needn't be written again.
 type CachedTreeIndexExtention struct {
 	entries []*CachedTreeEntry
 	size    int
@@ -166,6 +390,11 @@ func (ext *CachedTreeIndexExtention) Size() int {
 	return ext.size
 }
 
+// CachedTreeEntry is the cached tree of a directory of the index,
+// whose path is empty for the top of the working tree. Count is
+// the number of index entries inside of the directory, or -1 if
+// the tree is invalid because some of them changed since it was
+// written, in which case it usually has no oid.
 type CachedTreeEntry struct {
 	Path         string
 	Count        int
@@ -173,6 +402,9 @@ type CachedTreeEntry struct {
 	Oid          *objects.ObjectId
 }
 
+// ResolveUndoIndexExtention records the stages of the paths whose
+// merge conflicts were resolved, so that the conflicts can be
+// recreated.
 type ResolveUndoIndexExtention struct {
 	entries []*ResolveUndoEntry
 	size    int
@@ -186,8 +418,13 @@ func (ext *ResolveUndoIndexExtention) Size() int {
 	return ext.size
 }
 
+// ResolveUndoEntry is the conflict of a path that was resolved:
+// the modes and the oids of its stages 1 to 3, where a stage that
+// was missing has a zero mode and no oid.
 type ResolveUndoEntry struct {
-	// TODO
+	Path  string
+	Modes [3]objects.FileMode
+	Oids  [3]*objects.ObjectId
 }
 
 // ================================================================= //
@@ -212,9 +449,9 @@ func (hdr *indexHeader) String() string {
 
 // index entry version 2
 type indexEntry struct {
-	Info  statInfo
+	Info  StatInfo
 	Sha1  [20]byte
-	Flags int16 // TODO: this should be wrapped in the appropriate EntryFlags
+	Flags uint16
 }
 
 // the header of an index extention
@@ -227,7 +464,7 @@ type indexExtentionHeader struct {
 // to detect when a file is changed. It appears (according to some docs)
 // that the particular kind of data is not as relevant as the fact that
 // it changes.
-type statInfo struct {
+type StatInfo struct {
 	CTimeSecs  int32
 	CTimeNanos int32
 	MTimeSecs  int32
@@ -241,16 +478,16 @@ type statInfo struct {
 }
 
 // CTime returns the last time file metadata has changed
-func (info *statInfo) CTime() time.Time {
+func (info *StatInfo) CTime() time.Time {
 	return time.Unix(int64(info.CTimeSecs), int64(info.CTimeNanos))
 }
 
 // MTime returns the last time file metadata has changed
-func (info *statInfo) MTime() time.Time {
+func (info *StatInfo) MTime() time.Time {
 	return time.Unix(int64(info.MTimeSecs), int64(info.MTimeNanos))
 }
 
-func (info *statInfo) String() string {
+func (info *StatInfo) String() string {
 	const FMT_STATINFO = "%v  %v  %9d  %9d  %6o  %5d  %5d  %6d"
 	return fmt.Sprintf(
 		FMT_STATINFO,
@@ -278,19 +515,50 @@ func parseIndexHeader(r *bufio.Reader) (hdr *indexHeader, err error) {
 		return
 	}
 	sig := toSig(h.Sig)
-	if sig != SIG_INDEX_FILE || h.Version != 2 || h.Count < 0 {
+	if sig != SIG_INDEX_FILE || h.Version < 2 || h.Version > 4 || h.Count < 0 {
 		return nil, errors.New("bad header")
 	}
 	return &h, nil
 }
 
-func parseIndexEntry(r *bufio.Reader) (entry *IndexEntry, err error) {
+func parseIndexEntry(r *bufio.Reader, version int32, prev string) (entry *IndexEntry, err error) {
 	var binEntry indexEntry
 	err = binary.Read(r, ord, &binEntry)
 	if err != nil {
 		return nil, err
 	}
 
+	// the extended flags follow, when present
+	size := 62
+	var extFlags uint16
+	if EntryFlagsV2(binEntry.Flags).Extended() {
+		if version < 3 {
+			return nil, errors.New("extended flags in a version 2 index")
+		}
+		if err = binary.Read(r, ord, &extFlags); err != nil {
+			return nil, err
+		}
+		size += 2
+	}
+
+	// version 4 compresses the name against the previous
+	// entry, and does not pad the entry
+	if version >= 4 {
+		strip, e := readOffset(r)
+		if e != nil {
+			return nil, e
+		}
+		if strip > len(prev) {
+			return nil, errors.New("bad path compression in index entry")
+		}
+		suffix, e := r.ReadBytes(token.NUL)
+		if e != nil {
+			return nil, e
+		}
+		name := prev[:len(prev)-strip] + util.TrimLastStr(suffix)
+		return toIndexEntry(&binEntry, EntryFlagsV3(extFlags), name), nil
+	}
+
 	// TODO: what if it is corrupted and too long?
 	name, e := r.ReadBytes(token.NUL)
 	if e != nil {
@@ -298,9 +566,9 @@ func parseIndexEntry(r *bufio.Reader) (entry *IndexEntry, err error) {
 	}
 	name = util.TrimLastByte(name) // get rid of NUL
 
-	// don't ask me how I figured this out after
-	// a 14 hour workday
-	leftOver := 7 - (len(name)+6)%8
+	// entries are padded with 1-8 NULs to a multiple
+	// of eight bytes, and we have already read one
+	leftOver := 7 - (size+len(name))%8
 	for j := 0; j < leftOver; j++ {
 		// TODO: read the bytes at once somehow
 		if _, err = r.ReadByte(); err != nil {
@@ -309,35 +577,227 @@ func parseIndexEntry(r *bufio.Reader) (entry *IndexEntry, err error) {
 	}
 
 	// record the entry
-	return toIndexEntry(&binEntry, string(name)), nil
+	return toIndexEntry(&binEntry, EntryFlagsV3(extFlags), string(name)), nil
 }
 
+// readOffset reads a variable-length integer in the
+// encoding that git uses for offsets, where every
+// continuation adds one to the value.
+func readOffset(r *bufio.Reader) (int, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	n := int(c & 0x7f)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		n = ((n + 1) << 7) | int(c&0x7f)
+	}
+	return n, nil
+}
+
+// parseIndexExt parses an extention, and returns nil for the
+// optional extentions that are not supported, which are skipped.
 func parseIndexExt(r *bufio.Reader) (ext IndexExtention, err error) {
 	var hdr indexExtentionHeader
 	err = binary.Read(r, ord, &hdr)
 	if err != nil {
 		return nil, err
 	}
+	if hdr.Count < 0 {
+		return nil, errors.New("bad extention size")
+	}
+	data := make([]byte, hdr.Count)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
 
 	// do we support this extention type?
 	sig := toExtType(hdr.Sig)
 	switch sig {
 	case SIG_CACHED_TREE:
-		return parseCachedTree(r)
+		return parseCachedTree(data)
 	case SIG_RESOLVE_UNDO:
-		return parseResolveUndo(r)
+		return parseResolveUndo(data)
+	}
+	if isExtIgnorable(signature(sig)) {
+		return nil, nil
 	}
 	return nil, errors.New("unsupported extention signature: " + string(sig))
 }
 
-// TODO: implement
-func parseCachedTree(r *bufio.Reader) (ext *CachedTreeIndexExtention, err error) {
-	return nil, nil
+// parseCachedTree parses the cached trees of the index, which are
+// listed parent first, each as:
+//
+//	<name> NUL <count> SP <subtree count> LF [<oid>]
+//
+// where the oid is left out of invalid trees.
+func parseCachedTree(data []byte) (ext *CachedTreeIndexExtention, err error) {
+	ext = &CachedTreeIndexExtention{size: len(data)}
+	p := util.ParserForBytes(data)
+	err = util.SafeParse(func() {
+		// the paths of the trees whose subtrees are left to parse,
+		// and how many are left
+		var dirs []string
+		var left []int
+		for !p.EOF() {
+			name := p.ReadString(token.NUL)
+			count := int(p.ParseInt(token.SP, 10, 32))
+			subtrees := int(p.ParseInt(token.LF, 10, 32))
+			pth := name
+			if n := len(dirs); n > 0 {
+				pth = joinPath(dirs[n-1], name)
+				left[n-1]--
+			}
+			entry := &CachedTreeEntry{pth, count, subtrees, nil}
+			if count >= 0 {
+				oid, e := objects.OidFromBytes(p.Consume(objects.OidSize))
+				if e != nil {
+					util.PanicErr(e.Error())
+				}
+				entry.Oid = oid
+			}
+			ext.entries = append(ext.entries, entry)
+			dirs, left = append(dirs, pth), append(left, subtrees)
+			for n := len(dirs); n > 0 && left[n-1] == 0; n-- {
+				dirs, left = dirs[:n-1], left[:n-1]
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ext, nil
 }
 
-// TODO: implement
-func parseResolveUndo(r *bufio.Reader) (ext *ResolveUndoIndexExtention, err error) {
-	return nil, nil
+// parseResolveUndo parses the resolved conflicts of the index, which
+// are listed by path, each as:
+//
+//	<path> NUL <mode 1> NUL <mode 2> NUL <mode 3> NUL [<oid>...]
+//
+// with the modes in octal, and an oid for every mode that is not 0.
+func parseResolveUndo(data []byte) (ext *ResolveUndoIndexExtention, err error) {
+	ext = &ResolveUndoIndexExtention{size: len(data)}
+	p := util.ParserForBytes(data)
+	err = util.SafeParse(func() {
+		for !p.EOF() {
+			entry := &ResolveUndoEntry{Path: p.ReadString(token.NUL)}
+			for i := range entry.Modes {
+				entry.Modes[i] = objects.FileMode(p.ParseInt(token.NUL, 8, 32))
+			}
+			for i, mode := range entry.Modes {
+				if mode == 0 {
+					continue
+				}
+				oid, e := objects.OidFromBytes(p.Consume(objects.OidSize))
+				if e != nil {
+					util.PanicErr(e.Error())
+				}
+				entry.Oids[i] = oid
+			}
+			ext.entries = append(ext.entries, entry)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// ================================================================= //
+// WRITING FUNCTIONS
+// ================================================================= //
+
+// writeIndexEntry writes an entry of an index of the given
+// version, following the previous entry.
+func writeIndexEntry(buf *bytes.Buffer, entry *IndexEntry, version int32, prev string) {
+	binEntry := indexEntry{Info: *entry.info, Flags: uint16(entry.flags &^ flagExtended)}
+	copy(binEntry.Sha1[:], entry.eid.Bytes())
+	size := 62
+	if entry.extFlags != 0 {
+		binEntry.Flags |= uint16(flagExtended)
+		size += 2
+	}
+	binary.Write(buf, ord, &binEntry)
+	if entry.extFlags != 0 {
+		binary.Write(buf, ord, uint16(entry.extFlags))
+	}
+
+	// version 4 compresses the name against the previous
+	// entry, and does not pad the entry
+	if version >= 4 {
+		common := 0
+		for common < len(prev) && common < len(entry.name) && prev[common] == entry.name[common] {
+			common++
+		}
+		writeOffset(buf, len(prev)-common)
+		buf.WriteString(entry.name[common:])
+		buf.WriteByte(token.NUL)
+		return
+	}
+
+	// entries are padded with 1-8 NULs to a multiple
+	// of eight bytes
+	buf.WriteString(entry.name)
+	padding := 8 - (size+len(entry.name))%8
+	buf.Write(make([]byte, padding))
+}
+
+// writeCachedTree writes the cached trees of an index as an
+// extention, in the format that parseCachedTree reads.
+func writeCachedTree(buf *bytes.Buffer, ext *CachedTreeIndexExtention) {
+	data := new(bytes.Buffer)
+	for _, e := range ext.entries {
+		data.WriteString(e.Path[strings.LastIndex(e.Path, "/")+1:])
+		data.WriteByte(token.NUL)
+		fmt.Fprintf(data, "%d %d\n", e.Count, e.SubtreeCount)
+		if e.Count >= 0 {
+			data.Write(e.Oid.Bytes())
+		}
+	}
+	hdr := indexExtentionHeader{Count: int32(data.Len())}
+	copy(hdr.Sig[:], SIG_CACHED_TREE)
+	binary.Write(buf, ord, &hdr)
+	data.WriteTo(buf)
+}
+
+// writeResolveUndo writes the resolved conflicts of an index as an
+// extention, in the format that parseResolveUndo reads.
+func writeResolveUndo(buf *bytes.Buffer, ext *ResolveUndoIndexExtention) {
+	data := new(bytes.Buffer)
+	for _, e := range ext.entries {
+		data.WriteString(e.Path)
+		data.WriteByte(token.NUL)
+		for _, mode := range e.Modes {
+			fmt.Fprintf(data, "%o", mode)
+			data.WriteByte(token.NUL)
+		}
+		for i, mode := range e.Modes {
+			if mode != 0 {
+				data.Write(e.Oids[i].Bytes())
+			}
+		}
+	}
+	hdr := indexExtentionHeader{Count: int32(data.Len())}
+	copy(hdr.Sig[:], SIG_RESOLVE_UNDO)
+	binary.Write(buf, ord, &hdr)
+	data.WriteTo(buf)
+}
+
+// writeOffset writes a variable-length integer in the
+// encoding that readOffset reads.
+func writeOffset(buf *bytes.Buffer, n int) {
+	var varint [16]byte
+	pos := len(varint) - 1
+	varint[pos] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		n--
+		pos--
+		varint[pos] = 0x80 | byte(n&0x7f)
+	}
+	buf.Write(varint[pos:])
 }
 
 // ================================================================= //
@@ -364,33 +824,41 @@ func toIndex(file *bufio.Reader) (idx *Index, err error) {
 
 	// read the entries
 	var i int32
+	prev := ""
 	for i = 0; i < hdr.Count; i++ {
-		entry, e := parseIndexEntry(file)
+		entry, e := parseIndexEntry(file, hdr.Version, prev)
 		if e != nil {
 			return nil, e
 		}
 		idx.entries = append(idx.entries, entry)
+		prev = entry.name
 	}
 
-	// // read the extentions
-	// idx.extentions = make([]*IndexExtention, 4)
-	// for {
-	// 	ext, e := parseIndexExt(file)
-	// 	if e != nil {
-	// 		return nil, e
-	// 	}
-	// 	idx.extentions = append(idx.extentions, ext)
-	// }
+	// read the extentions, which are followed by the checksum
+	// of the index
+	for {
+		if _, e := file.Peek(8 + sha1.Size); e != nil {
+			break
+		}
+		ext, e := parseIndexExt(file)
+		if e != nil {
+			return nil, e
+		}
+		if ext != nil {
+			idx.extentions = append(idx.extentions, &ext)
+		}
+	}
 
 	return
 }
 
-func toIndexEntry(entry *indexEntry, name string) *IndexEntry {
+func toIndexEntry(entry *indexEntry, extFlags EntryFlagsV3, name string) *IndexEntry {
 	return &IndexEntry{
-		eid:   objects.OidFromArray(entry.Sha1),
-		flags: EntryFlagsV2(entry.Flags),
-		name:  name,
-		info:  &entry.Info,
+		eid:      objects.OidFromArray(entry.Sha1),
+		flags:    EntryFlagsV2(entry.Flags),
+		extFlags: extFlags,
+		name:     name,
+		info:     &entry.Info,
 	}
 }
 
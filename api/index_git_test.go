@@ -0,0 +1,64 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+index_git_test.go implements git-comparison tests for index parsing.
+*/
+package api
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"strings"
+	"testing"
+)
+
+// Test_readIndex compares the staged entries that we parse
+// with the output of git-ls-files --stage.
+func Test_readIndex(t *testing.T) {
+	testCase := test.Tree
+	repo := Open(testCase.Repo())
+
+	idx, err := repo.Index()
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, 2, int(idx.Version()))
+
+	lines := make([]string, 0)
+	for _, entry := range idx.Entries() {
+		util.AssertEqualInt(t, 0, entry.Stage())
+		util.AssertEqualInt(t, len(entry.Name()), entry.Flags().NameLength())
+		lines = append(lines, fmt.Sprintf("%06o %s %d\t%s", entry.Mode(), entry.ObjectId(), entry.Stage(), entry.Name()))
+	}
+	expected := strings.TrimSpace(util.GitNow(testCase.Repo(), "ls-files", "--stage"))
+	util.AssertEqualString(t, expected, strings.Join(lines, "\n"))
+}
+
+// Test_workTreeStatus checks that a freshly committed working
+// tree has no modifications and that no files are untracked.
+func Test_workTreeStatus(t *testing.T) {
+	testCase := test.Tree
+	repo := Open(testCase.Repo())
+
+	idx, err := repo.Index()
+	util.AssertNoErr(t, err)
+	wt := NewWorkTree(repo)
+	for _, entry := range idx.Entries() {
+		status, err := wt.Status(entry)
+		util.AssertNoErr(t, err)
+		util.Assert(t, status == WorkUnmodified, entry.Name())
+
+		oid, err := wt.HashFile(entry.Name())
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, entry.ObjectId().String(), oid.String())
+	}
+
+	others, err := wt.Others(idx, nil, false)
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, 0, len(others))
+}
@@ -0,0 +1,74 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+lock.go implements git's lock files, which are how files such as the index
+are replaced: the new contents are written next to the file, under its
+name with ".lock" appended, and then renamed over it. While the lock file
+exists, nobody else may write the file.
+*/
+package api
+
+import (
+	"fmt"
+	"os"
+)
+
+// LockSuffix is appended to the name of a file to get the
+// name of its lock file.
+const LockSuffix = ".lock"
+
+// ================================================================= //
+// LOCK FILES
+// ================================================================= //
+
+// LockFile is the lock of a file, which holds its new contents
+// until they are committed.
+type LockFile struct {
+	path string
+	file *os.File
+}
+
+// Lock takes the lock of the file at the given path, failing if
+// someone else holds it.
+func Lock(pth string) (*LockFile, error) {
+	f, err := os.OpenFile(pth+LockSuffix, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("Unable to create '%s%s': File exists.", pth, LockSuffix)
+		}
+		return nil, err
+	}
+	return &LockFile{pth, f}, nil
+}
+
+// Path returns the path of the file that is locked.
+func (l *LockFile) Path() string {
+	return l.path
+}
+
+// Write writes to the new contents of the file.
+func (l *LockFile) Write(p []byte) (int, error) {
+	return l.file.Write(p)
+}
+
+// Commit replaces the file with its new contents, which
+// releases the lock.
+func (l *LockFile) Commit() error {
+	if err := l.file.Close(); err != nil {
+		os.Remove(l.file.Name())
+		return err
+	}
+	return os.Rename(l.file.Name(), l.path)
+}
+
+// Rollback releases the lock, leaving the file as it was.
+func (l *LockFile) Rollback() error {
+	l.file.Close()
+	return os.Remove(l.file.Name())
+}
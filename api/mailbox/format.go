@@ -0,0 +1,347 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+format.go implements the formatting of the emails of patches, as
+git-format-patch writes them. Every email starts with a "From " line that
+has the oid of its commit and a fixed date, followed by the author, the
+date and the subject of the commit as headers, the names and subjects that
+aren't ASCII being encoded as RFC 2047 says, and by the rest of the message
+of the commit.
+*/
+package mailbox
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ================================================================= //
+// MESSAGES
+// ================================================================= //
+
+// FromLineDate is the date of the "From " lines that start the
+// emails of patches, which tells them apart from the ones of other
+// emails.
+const FromLineDate = "Mon Sep 17 00:00:00 2001"
+
+// DateLayout is the layout of the dates of emails, per RFC 2822.
+const DateLayout = "Mon, 2 Jan 2006 15:04:05 -0700"
+
+// the width that headers are wrapped at
+const headerWidth = 78
+
+// the width of the lines of encoded words, per RFC 2047
+const encodedWidth = 76
+
+// Message is the part of the email of a patch that comes before the
+// patch: its headers and the message of its commit after the subject.
+type Message struct {
+	Commit  *objects.ObjectId // the commit of the "From " line
+	Author  *objects.WhoWhen  // the sender and the date
+	Prefix  string            // the prefix of the subject, as in "[PATCH 1/2]"
+	Subject string
+	Body    string
+
+	// EightBit adds the MIME headers that declare the body to be
+	// 8-bit UTF-8.
+	EightBit bool
+}
+
+// NewMessage returns the message of the email of the patch of a
+// commit, whose subject has the given prefix. The body is declared to
+// be 8-bit if the message of the commit isn't ASCII.
+func NewMessage(c *objects.Commit, prefix string) *Message {
+	subject, body := SplitMessage(c.Message())
+	return &Message{
+		Commit:   c.ObjectId(),
+		Author:   c.Author(),
+		Prefix:   prefix,
+		Subject:  subject,
+		Body:     body,
+		EightBit: !isASCII(c.Message()),
+	}
+}
+
+// SplitMessage splits the message of a commit into its subject, whose
+// lines are joined, and its body, without the blank lines that it
+// starts with and the whitespace that its lines end with.
+func SplitMessage(msg string) (subject, body string) {
+	lines := strings.Split(msg, "\n")
+	var title []string
+	for len(lines) > 0 {
+		line := strings.TrimRight(lines[0], " \t\r\n\v\f")
+		lines = lines[1:]
+		if line == "" {
+			break
+		}
+		title = append(title, line)
+	}
+	var rest []string
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r\n\v\f")
+		if line != "" || len(rest) > 0 {
+			rest = append(rest, line)
+		}
+	}
+	return strings.Join(title, " "), strings.TrimRight(strings.Join(rest, "\n"), " \t\r\n\v\f")
+}
+
+// WriteTo writes the message, which ends with a newline.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From %s %s\n", m.Commit, FromLineDate)
+	writeFrom(&b, m.Author.Name(), m.Author.Email())
+	fmt.Fprintf(&b, "Date: %s\n", m.Author.Time().Format(DateLayout))
+	b.WriteString("Subject: ")
+	if m.Prefix != "" {
+		b.WriteString(m.Prefix + " ")
+	}
+	if needsEncoding(m.Subject) {
+		b.WriteString(encodeWords(m.Subject, lastLineLength(b.Bytes()), false))
+	} else {
+		b.WriteString(WrapText(m.Subject, -lastLineLength(b.Bytes()), 1, headerWidth))
+	}
+	b.WriteString("\n")
+	if m.EightBit {
+		b.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n")
+	}
+	b.WriteString("\n")
+	if m.Body != "" {
+		b.WriteString(m.Body + "\n")
+	}
+	return b.WriteTo(w)
+}
+
+// writeFrom writes the From header of a sender, whose name is quoted
+// if it has special characters, and encoded if it isn't ASCII.
+func writeFrom(b *bytes.Buffer, name, email string) {
+	b.WriteString("From: ")
+	width := headerWidth
+	switch {
+	case needsEncoding(name):
+		b.WriteString(encodeWords(name, lastLineLength(b.Bytes()), true))
+		width = encodedWidth
+	case strings.ContainsAny(name, `()<>[]:;@,."\`):
+		quoted := `"` + strings.NewReplacer(`"`, `\"`, `\`, `\\`).Replace(name) + `"`
+		b.WriteString(WrapText(quoted, -6, 1, width))
+	default:
+		b.WriteString(WrapText(name, -6, 1, width))
+	}
+	if width < lastLineLength(b.Bytes())+len(" <")+len(email)+len(">") {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(b, " <%s>\n", email)
+}
+
+// ================================================================= //
+// ENCODED WORDS
+// ================================================================= //
+
+// needsEncoding returns true if a header has to be encoded, which it
+// does if it isn't ASCII, has newlines, or looks encoded already.
+func needsEncoding(s string) bool {
+	return !isASCII(s) || strings.Contains(s, "\n") || strings.Contains(s, "=?")
+}
+
+// encodeWords encodes text as the Q-encoded words of RFC 2047, which
+// start at a column of a line, and are broken into lines that are no
+// wider than 76 columns. The words of addresses encode more of the
+// special characters.
+func encodeWords(text string, column int, address bool) string {
+	const start = "=?UTF-8?q?"
+	var b bytes.Buffer
+	b.WriteString(start)
+	column += len(start)
+	for len(text) > 0 {
+		n := 1
+		if text[0] >= utf8.RuneSelf {
+			if r, size := utf8.DecodeRuneInString(text); r != utf8.RuneError {
+				n = size
+			}
+		}
+		special := n > 1 || isSpecial(text[0], address)
+		width := n
+		if special {
+			width = 3 * n
+		}
+		if column+width+2 > encodedWidth {
+			b.WriteString("?=\n " + start)
+			column = len(start) + 1
+		}
+		for i := 0; i < n; i++ {
+			if special {
+				fmt.Fprintf(&b, "=%02X", text[i])
+			} else {
+				b.WriteByte(text[i])
+			}
+		}
+		column += width
+		text = text[n:]
+	}
+	b.WriteString("?=")
+	return b.String()
+}
+
+// isSpecial returns true if a character has to be encoded in an
+// encoded word.
+func isSpecial(c byte, address bool) bool {
+	if c >= utf8.RuneSelf || c < ' ' || c == 0x7f || c == ' ' || c == '=' || c == '?' || c == '_' {
+		return true
+	}
+	if !address {
+		return false
+	}
+	return !isAlnum(c) && !strings.ContainsRune("!*+-/", rune(c))
+}
+
+// ================================================================= //
+// WRAPPING
+// ================================================================= //
+
+// WrapText wraps text at a width, breaking its lines at whitespace.
+// Its first line is indented by indent1 columns, or, if indent1 is
+// negative, is known to start at the column -indent1 without being
+// indented, and the other lines are indented by indent2 columns.
+// Single newlines of the text are joined with spaces unless the next
+// line doesn't start with a letter or a digit.
+func WrapText(text string, indent1, indent2, width int) string {
+	var b bytes.Buffer
+	at := func(i int) byte {
+		if i < len(text) {
+			return text[i]
+		}
+		return 0
+	}
+	bol, space := 0, -1
+	w, indent := indent1, indent1
+	if indent < 0 {
+		w, space = -indent, 0
+	}
+	for i := 0; ; {
+		c := at(i)
+		if c != 0 && !isSpace(c) {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			w++
+			i += size
+			continue
+		}
+		newLine := w > width && space >= 0
+		if !newLine {
+			start := bol
+			if c == 0 && i == start {
+				return b.String()
+			}
+			if space >= 0 {
+				start = space
+			} else {
+				b.WriteString(strings.Repeat(" ", indent))
+			}
+			b.WriteString(text[start:i])
+			if c == 0 {
+				return b.String()
+			}
+			space = i
+			switch c {
+			case '\t':
+				w |= 0x07
+			case '\n':
+				space++
+				if at(space) == '\n' {
+					b.WriteByte('\n')
+					newLine = true
+				} else if !isAlnum(at(space)) {
+					newLine = true
+				} else {
+					b.WriteByte(' ')
+				}
+			}
+			if !newLine {
+				w++
+				i++
+				continue
+			}
+		}
+		b.WriteByte('\n')
+		i = space
+		if isSpace(at(space)) {
+			i++
+		}
+		bol, space = i, -1
+		w, indent = indent2, indent2
+	}
+}
+
+// ================================================================= //
+// FILE NAMES
+// ================================================================= //
+
+// the longest names of the files of patches
+const maxFileName = 64
+
+// FileName returns the name of the file of a numbered patch, which
+// is made of its number and of its subject, as in
+// "0001-Fix-the-build.patch".
+func FileName(nr int, subject string) string {
+	const suffix = ".patch"
+	name := fmt.Sprintf("%04d-%s", nr, sanitize(subject))
+	if max := maxFileName - len(suffix) - 1; len(name) > max {
+		name = name[:max]
+	}
+	return name + suffix
+}
+
+// sanitize replaces the runs of the characters of a subject that
+// aren't letters, digits, dots or underscores with dashes, and the
+// runs of dots with one dot.
+func sanitize(subject string) string {
+	var b []byte
+	space := 2
+	for i := 0; i < len(subject); i++ {
+		c := subject[i]
+		if !isAlnum(c) && c != '.' && c != '_' {
+			space |= 1
+			continue
+		}
+		if space == 1 {
+			b = append(b, '-')
+		}
+		space = 0
+		b = append(b, c)
+		for c == '.' && i+1 < len(subject) && subject[i+1] == '.' {
+			i++
+		}
+	}
+	return strings.TrimRight(string(b), ".-")
+}
+
+// ================================================================= //
+// UTIL
+// ================================================================= //
+
+// lastLineLength returns the length of the last line of data.
+func lastLineLength(data []byte) int {
+	return len(data) - bytes.LastIndexByte(data, '\n') - 1
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
@@ -0,0 +1,104 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+mailbox.go implements the splitting of mailboxes into the emails that they
+hold, as git-mailsplit does. Patches are exchanged as emails in the mbox
+format, where every email starts with a "From " line that has the date of
+its delivery, and git-format-patch writes such emails, which git-am splits
+and applies.
+*/
+package mailbox
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// ================================================================= //
+// MAILBOXES
+// ================================================================= //
+
+// Split splits the emails of a mailbox. Carriage returns are removed
+// from the ends of the lines. A mailbox whose first line is not a
+// "From " line holds a single email.
+func Split(data []byte) [][]byte {
+	data = bytes.TrimLeft(data, " \t\r\n\v\f")
+	if len(data) == 0 {
+		return nil
+	}
+	var mails [][]byte
+	var mail []byte
+	bare := !IsFromLine(firstLine(data))
+	for i, line := range lines(data) {
+		if i > 0 && !bare && IsFromLine(line) {
+			mails = append(mails, mail)
+			mail = nil
+		}
+		if n := len(line); n > 1 && line[n-2] == '\r' && line[n-1] == '\n' {
+			line = append(line[:n-2:n-2], '\n')
+		}
+		mail = append(mail, line...)
+	}
+	return append(mails, mail)
+}
+
+// IsFromLine returns true if a line starts an email of a mailbox,
+// which it does if it is a "From " line that ends with something that
+// looks like a date, as in "From 1a2b3c Mon Sep 17 00:00:00 2001".
+func IsFromLine(line []byte) bool {
+	if len(line) < 20 || !bytes.HasPrefix(line, []byte("From ")) {
+		return false
+	}
+	colon := bytes.LastIndex(line[5:len(line)-2], []byte(":"))
+	if colon < 0 {
+		return false
+	}
+	colon += 5
+	digit := func(i int) bool {
+		return i >= 0 && i < len(line) && '0' <= line[i] && line[i] <= '9'
+	}
+	if !digit(colon-4) || !digit(colon-2) || !digit(colon-1) || !digit(colon+1) || !digit(colon+2) {
+		return false
+	}
+
+	// the year follows the seconds
+	year := bytes.TrimLeft(line[colon+3:], " \t")
+	end := 0
+	for end < len(year) && '0' <= year[end] && year[end] <= '9' {
+		end++
+	}
+	n, err := strconv.Atoi(string(year[:end]))
+	return err == nil && n > 90
+}
+
+// ================================================================= //
+// UTIL
+// ================================================================= //
+
+// lines splits data after its newlines.
+func lines(data []byte) [][]byte {
+	var result [][]byte
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		result = append(result, data[:i])
+		data = data[i:]
+	}
+	return result
+}
+
+// firstLine returns the first line of data, with its newline.
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[:i+1]
+	}
+	return data
+}
@@ -0,0 +1,173 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+mailbox_git_test.go implements git-comparison tests for the emails of
+patches, which are checked against the ones that git-format-patch writes,
+and against what git-mailsplit and git-mailinfo make of them.
+*/
+package mailbox
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test_Message compares the messages of the emails of commits with
+// the ones that git-format-patch writes before their patches, and
+// the names of their files.
+func Test_Message(t *testing.T) {
+	testCase := test.Mails
+	dir, err := testCase.Clone("__mailbox_message")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	revs := testCase.Info().(*test.InfoMails).Revs
+
+	out := path.Join(dir, ".git", "patches")
+	for i, rev := range revs {
+		mail, err := util.GitExec(dir, "format-patch", "-1", "--stdout", rev)
+		util.AssertNoErrOrDie(t, err)
+		expected := mail[:strings.Index(mail, "\n---\n")+1]
+
+		c, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, rev)))
+		util.AssertNoErrOrDie(t, err)
+		var b bytes.Buffer
+		_, err = NewMessage(c, "[PATCH]").WriteTo(&b)
+		util.AssertNoErrOrDie(t, err)
+		util.AssertEqualString(t, expected, b.String())
+
+		name, err := util.GitExec(dir, "format-patch", "-1", "-o", out, "--start-number", fmt.Sprint(i+1), rev)
+		util.AssertNoErrOrDie(t, err)
+		subject, _ := SplitMessage(c.Message())
+		util.AssertEqualString(t, path.Base(strings.TrimSpace(name)), FileName(i+1, subject))
+	}
+}
+
+// Test_WrapText checks the wrapping of text, which git-shortlog does
+// as git does it for headers.
+func Test_WrapText(t *testing.T) {
+	text := "a fairly long subject line that goes on and on and on beyond the limit of seventy-two"
+	util.AssertEqualString(t, "  a fairly long subject line that goes on and on and on beyond the limit\n    of seventy-two", WrapText(text, 2, 4, 72))
+	util.AssertEqualString(t, "short", WrapText("short", -10, 1, 78))
+	util.AssertEqualString(t, "one two\n three", WrapText("one two three", -5, 1, 12))
+	util.AssertEqualString(t, "", WrapText("", 2, 4, 72))
+}
+
+// Test_SplitAndParse splits a mailbox of the emails of all the
+// commits, and of handwritten emails, as git-mailsplit does, and
+// parses them as git-mailinfo does.
+func Test_SplitAndParse(t *testing.T) {
+	testCase := test.Mails
+	dir, err := testCase.Clone("__mailbox_parse")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	commits := len(testCase.Info().(*test.InfoMails).Revs)
+
+	mbox, err := util.GitExec(dir, "format-patch", "--stdout", "--root")
+	util.AssertNoErrOrDie(t, err)
+	mbox += strings.Join([]string{
+		"From nobody Mon Sep 17 00:00:00 2001",
+		`From: "Doe, John" <john@example.com>`,
+		"Date: Mon, 14 Mar 2011 10:00:00 -0500",
+		"Subject: Re: [PATCH v2 1/3] =?ISO-8859-1?Q?caf=E9?=",
+		" continued\there",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"",
+		"From: Jane Roe <jane@example.com>",
+		"Subject: [PATCH] the real one",
+		"",
+		"Body caf=C3=A9 line=20",
+		"second =3D line",
+		"",
+		"---",
+		" a | 1 +",
+		"diff --git a/a b/a",
+		"",
+		"From nobody Mon Sep 17 00:00:00 2001\r",
+		"From: author@example.com (A U Thor)\r",
+		"Subject: [PATCH 2/2] crlf\r",
+		"\r",
+		"hi\r",
+		"--- a/b\r",
+		"",
+	}, "\n")
+	file := path.Join(dir, ".git", "mbox")
+	util.AssertNoErrOrDie(t, ioutil.WriteFile(file, []byte(mbox), 0666))
+	split := path.Join(dir, ".git", "split")
+	util.AssertNoErrOrDie(t, os.Mkdir(split, 0777))
+	_, err = util.GitExec(dir, "mailsplit", "-o"+split, file)
+	util.AssertNoErrOrDie(t, err)
+
+	mails := Split([]byte(mbox))
+	util.AssertEqualInt(t, commits+2, len(mails))
+	for i, mail := range mails {
+		expected, err := ioutil.ReadFile(path.Join(split, fmt.Sprintf("%04d", i+1)))
+		util.AssertNoErrOrDie(t, err)
+		util.AssertEqualString(t, string(expected), string(mail))
+
+		for _, keep := range []bool{false, true} {
+			// git-mailinfo -k keeps the newline of a subject that
+			// starts a body
+			if keep && i == commits {
+				continue
+			}
+			info, msg, patch := gitMailinfo(t, dir, mail, keep)
+			m, err := Parse(mail, keep)
+			util.AssertNoErrOrDie(t, err)
+			util.AssertEqualString(t, info, formatInfo(m))
+			util.AssertEqualString(t, msg, m.Message)
+			util.AssertEqualString(t, patch, m.Patch)
+		}
+	}
+}
+
+// formatInfo formats what an email says of its commit as git-mailinfo
+// prints it, leaving out the headers that the email doesn't have.
+func formatInfo(m *Mail) string {
+	var info string
+	if m.Email != "" {
+		info += fmt.Sprintf("Author: %s\nEmail: %s\n", m.Name, m.Email)
+	}
+	info += fmt.Sprintf("Subject: %s\n", m.Subject)
+	if m.Date != "" {
+		info += fmt.Sprintf("Date: %s\n", m.Date)
+	}
+	return info + "\n"
+}
+
+// gitMailinfo returns what git-mailinfo says of an email: its info,
+// its message and its patch.
+func gitMailinfo(t *testing.T, dir string, mail []byte, keep bool) (info, msg, patch string) {
+	msgFile, patchFile := path.Join(dir, ".git", "msg"), path.Join(dir, ".git", "patch")
+	args := []string{"mailinfo", msgFile, patchFile}
+	if keep {
+		args = []string{"mailinfo", "-k", msgFile, patchFile}
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Stdin = strings.NewReader(string(mail))
+	out, err := cmd.Output()
+	util.AssertNoErrOrDie(t, err)
+	msgData, err := ioutil.ReadFile(msgFile)
+	util.AssertNoErrOrDie(t, err)
+	patchData, err := ioutil.ReadFile(patchFile)
+	util.AssertNoErrOrDie(t, err)
+	return string(out), string(msgData), string(patchData)
+}
@@ -0,0 +1,376 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+mailinfo.go implements the parsing of the emails of patches, as
+git-mailinfo does. The author, the date and the subject of the commit of a
+patch come from the headers of its email, or from the headers that start
+its body, and the message of the commit is the body up to the patch, which
+starts at a "---" line or at the first "diff -" line.
+*/
+package mailbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"unicode/utf8"
+)
+
+// ================================================================= //
+// MAILS
+// ================================================================= //
+
+// Mail is what the email of a patch says about the commit of the
+// patch.
+type Mail struct {
+	Name, Email string // the author of the commit
+	Date        string // the date of the commit, as the email gives it
+	Subject     string // the subject, without the prefixes of emails
+	Message     string // the message of the commit after its subject
+	Patch       string // the patch, from the line that it starts at
+}
+
+// the headers that the commits of patches are made from
+const (
+	fromHeader     = "From"
+	subjectHeader  = "Subject"
+	dateHeader     = "Date"
+	typeHeader     = "Content-Type"
+	encodingHeader = "Content-Transfer-Encoding"
+)
+
+// Parse parses an email of a patch. The subject is cleaned of the
+// prefixes of replies and of the bracketed prefixes of patches, as in
+// "Re: [PATCH 1/2]", unless it is kept as it is.
+func Parse(data []byte, keepSubject bool) (*Mail, error) {
+	m := new(Mail)
+	headers, body := parseHeaders(data)
+	if from, ok := headers[fromHeader]; ok {
+		m.handleFrom(from)
+	}
+	m.Date = headers[dateHeader]
+	m.Subject = headers[subjectHeader]
+
+	body, err := decodeBody(body, headers[encodingHeader])
+	if err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	inHeaders := true
+	for len(body) > 0 {
+		line := firstLine(body)
+		if inHeaders {
+			if len(bytes.TrimRight(line, "\n")) == 0 {
+				body = body[len(line):]
+				continue
+			}
+			inHeaders = m.parseInBodyHeader(string(line))
+			if inHeaders {
+				body = body[len(line):]
+				continue
+			}
+		}
+		if isPatchBreak(line) {
+			m.Patch = string(body)
+			break
+		}
+		msg.Write(line)
+		body = body[len(line):]
+	}
+	m.Message = string(toUTF8(msg.Bytes(), headers[typeHeader]))
+
+	if !keepSubject {
+		m.Subject = strings.Join(strings.Fields(cleanupSubject(m.Subject)), " ")
+	}
+	return m, nil
+}
+
+// parseHeaders parses the headers of an email, which end at a blank
+// line, and returns them, decoded, with the body of the email. Only
+// the first of the headers with the same name is kept, and only the
+// headers that commits are made from are decoded.
+func parseHeaders(data []byte) (map[string]string, []byte) {
+	headers := make(map[string]string)
+	var header string
+	flush := func() {
+		if header == "" {
+			return
+		}
+		if i := strings.Index(header, ":"); i > 0 {
+			name, value := canonicalHeader(header[:i]), strings.TrimSpace(header[i+1:])
+			if _, ok := headers[name]; !ok && name != "" {
+				headers[name] = decodeHeader(value)
+			}
+		}
+		header = ""
+	}
+	for len(data) > 0 {
+		line := firstLine(data)
+		text := strings.TrimRight(string(line), "\r\n")
+		if text == "" {
+			data = data[len(line):]
+			break
+		}
+		if (text[0] == ' ' || text[0] == '\t') && header != "" {
+			header += " " + strings.TrimRight(text[1:], " \t")
+		} else {
+			flush()
+			header = text
+		}
+		data = data[len(line):]
+	}
+	flush()
+	return headers, data
+}
+
+// canonicalHeader returns the name of a header that commits are made
+// from, in its canonical case, or an empty string.
+func canonicalHeader(name string) string {
+	for _, h := range []string{fromHeader, subjectHeader, dateHeader, typeHeader, encodingHeader} {
+		if strings.EqualFold(name, h) {
+			return h
+		}
+	}
+	return ""
+}
+
+// decodeHeader decodes the encoded words of a header, as in
+// "=?UTF-8?q?J=C3=B6hn?=", leaving the ones that can't be decoded.
+func decodeHeader(value string) string {
+	dec := new(mime.WordDecoder)
+	if decoded, err := dec.DecodeHeader(value); err == nil {
+		return decoded
+	}
+	return value
+}
+
+// parseInBodyHeader parses a line at the start of a body that may
+// be a header, which overrides the one of the email, and returns true
+// if it was one.
+func (m *Mail) parseInBodyHeader(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "[PATCH]") && len(line) > 7 && isSpace(line[7]) {
+		m.Subject = line
+		return true
+	}
+	i := strings.Index(line, ":")
+	if i <= 0 || i+1 >= len(line) || !isSpace(line[i+1]) {
+		return false
+	}
+	value := decodeHeader(strings.TrimSpace(line[i+1:]))
+	switch canonicalHeader(line[:i]) {
+	case fromHeader:
+		m.Name, m.Email = "", ""
+		m.handleFrom(value)
+	case subjectHeader:
+		m.Subject = value
+	case dateHeader:
+		m.Date = value
+	default:
+		return false
+	}
+	return true
+}
+
+// isPatchBreak returns true if a line of a body starts its patch,
+// which it does if it starts a diff, or if it is a "---" separator,
+// or the "--- <name>" line of a diff without a header.
+func isPatchBreak(line []byte) bool {
+	if bytes.HasPrefix(line, []byte("diff -")) || bytes.HasPrefix(line, []byte("Index: ")) {
+		return true
+	}
+	if len(line) < 4 || !bytes.HasPrefix(line, []byte("---")) {
+		return false
+	}
+	if line[3] == ' ' && !isSpace(line[4]) {
+		return true
+	}
+	for _, c := range line[3:] {
+		if c == '\n' {
+			return true
+		}
+		if !isSpace(c) {
+			break
+		}
+	}
+	return false
+}
+
+// ================================================================= //
+// BODIES
+// ================================================================= //
+
+// decodeBody decodes a body that is quoted-printable or in base64.
+func decodeBody(body []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	case "base64":
+		return base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil)))
+	}
+	return body, nil
+}
+
+// toUTF8 converts text in the charset of a content type to UTF-8,
+// which it only knows how to do for Latin-1.
+func toUTF8(text []byte, contentType string) []byte {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || utf8.Valid(text) {
+		return text
+	}
+	switch strings.ToLower(params["charset"]) {
+	case "iso-8859-1", "latin1", "latin-1":
+		runes := make([]rune, len(text))
+		for i, c := range text {
+			runes[i] = rune(c)
+		}
+		return []byte(string(runes))
+	}
+	return text
+}
+
+// ================================================================= //
+// AUTHORS
+// ================================================================= //
+
+// handleFrom finds the name and the email of the author in a From
+// header, as in "A U Thor <author@example.com>" and
+// "author@example.com (A U Thor)".
+func (m *Mail) handleFrom(from string) {
+	f := unquotePairs(from)
+	at := strings.Index(f, "@")
+	if at < 0 {
+		m.bogusFrom(from)
+		return
+	}
+
+	// the email is the word around the @, which may be in <>
+	start := at
+	for start > 0 {
+		c := f[start-1]
+		if isSpace(c) {
+			break
+		}
+		if c == '<' {
+			f = f[:start-1] + " " + f[start:]
+			break
+		}
+		start--
+	}
+	end := start + strings.IndexAny(f[start:], " \n\t\r\v\f>")
+	if end < start {
+		end = len(f)
+	}
+	m.Email = f[start:end]
+	if end < len(f) {
+		end++
+	}
+	name := strings.Join(strings.Fields(f[:start]+f[end:]), " ")
+	if strings.HasPrefix(name, "(") && strings.HasSuffix(name, ")") {
+		name = name[1 : len(name)-1]
+	}
+	m.Name = saneName(name, m.Email)
+}
+
+// bogusFrom finds the name and the email of the author in a From
+// header whose email has no @, as in "A U Thor <author>".
+func (m *Mail) bogusFrom(from string) {
+	bra := strings.Index(from, "<")
+	if bra < 0 {
+		return
+	}
+	ket := strings.Index(from[bra:], ">")
+	if ket < 0 {
+		return
+	}
+	m.Email = from[bra+1 : bra+ket]
+	m.Name = saneName(strings.TrimSpace(from[:bra]), m.Email)
+}
+
+// saneName returns a name, or the email if the name is empty, too
+// long, or looks like an email.
+func saneName(name, email string) string {
+	if name == "" || len(name) > 60 || strings.ContainsAny(name, "@<>") {
+		return email
+	}
+	return name
+}
+
+// unquotePairs removes the quotes of the quoted strings of a From
+// header and the backslashes of the quoted pairs of its strings and
+// comments.
+func unquotePairs(s string) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				out = append(out, s[i])
+			}
+		case '(':
+			out = append(out, '(')
+			for depth := 1; depth > 0 && i+1 < len(s); {
+				i++
+				switch s[i] {
+				case '\\':
+					if i+1 < len(s) {
+						i++
+					}
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+				out = append(out, s[i])
+			}
+		default:
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+// ================================================================= //
+// SUBJECTS
+// ================================================================= //
+
+// cleanupSubject removes the prefixes of replies and the bracketed
+// prefixes from a subject, as in "Re: [PATCH 1/2] subject".
+func cleanupSubject(subject string) string {
+prefixes:
+	for len(subject) > 0 {
+		switch c := subject[0]; {
+		case c == 'r' || c == 'R':
+			if len(subject) > 3 && (subject[1] == 'e' || subject[1] == 'E') && subject[2] == ':' {
+				subject = subject[3:]
+				continue
+			}
+		case c == ' ' || c == '\t' || c == ':':
+			subject = subject[1:]
+			continue
+		case c == '[':
+			if i := strings.Index(subject, "]"); i >= 0 {
+				subject = subject[i+1:]
+				continue
+			}
+		}
+		break prefixes
+	}
+	return strings.TrimSpace(subject)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
@@ -0,0 +1,109 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+commits.go implements the merge of commits, in the way of git's
+merge-ort.c. The merge bases of the commits are found first, and when
+there are several of them, as in criss-cross merges, they are merged into
+one virtual base, oldest first. Commits that have no common ancestor are
+merged as if they had an empty one.
+*/
+package merge
+
+import (
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// ================================================================= //
+// MERGING COMMITS
+// ================================================================= //
+
+// the labels of the sides of the merges of merge bases
+const (
+	virtualOurs   = "Temporary merge branch 1"
+	virtualTheirs = "Temporary merge branch 2"
+)
+
+// side is a side of a merge of commits, which is a commit, or the
+// tree of a virtual commit, which has no parents.
+type side struct {
+	commit *objects.Commit
+	tree   *objects.Tree
+}
+
+func commitSide(repo *api.DiskRepository, c *objects.Commit) (side, error) {
+	t, err := api.TreeFromOid(repo, c.Tree())
+	return side{c, t}, err
+}
+
+// MergeCommits merges the changes that two commits made since their
+// merge bases. The label of the base is chosen as git chooses it:
+// it is the abbreviated oid of the merge base if there is only one,
+// "merged common ancestors" if there are several, and "empty tree"
+// if there are none.
+func MergeCommits(repo *api.DiskRepository, ours, theirs *objects.Commit, opts *Options) (*Result, error) {
+	one, err := commitSide(repo, ours)
+	if err != nil {
+		return nil, err
+	}
+	two, err := commitSide(repo, theirs)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSides(repo, one, two, opts)
+}
+
+func mergeSides(repo *api.DiskRepository, one, two side, opts *Options) (*Result, error) {
+	var bases []*objects.Commit
+	if one.commit != nil && two.commit != nil {
+		var err error
+		if bases, err = api.MergeBases(repo, one.commit, two.commit); err != nil {
+			return nil, err
+		}
+	}
+
+	// the bases are merged oldest first
+	var base *objects.Tree
+	label := "empty tree"
+	if n := len(bases); n > 0 {
+		merged, err := commitSide(repo, bases[n-1])
+		if err != nil {
+			return nil, err
+		}
+		label = bases[n-1].ObjectId().String()[:7]
+		if n > 1 {
+			label = "merged common ancestors"
+		}
+		// the conflicts of the inner merges have longer markers, and
+		// favor neither side
+		inner := *opts
+		inner.Ours, inner.Theirs = virtualOurs, virtualTheirs
+		if inner.MarkerSize <= 0 {
+			inner.MarkerSize = diff.DefaultMarkerSize
+		}
+		inner.MarkerSize += 2
+		inner.Favor = diff.FavorNone
+		for i := n - 2; i >= 0; i-- {
+			next, err := commitSide(repo, bases[i])
+			if err != nil {
+				return nil, err
+			}
+			r, err := mergeSides(repo, merged, next, &inner)
+			if err != nil {
+				return nil, err
+			}
+			merged = side{nil, r.Tree}
+		}
+		base = merged.tree
+	}
+	outer := *opts
+	outer.Base = label
+	return MergeTrees(repo, base, one.tree, two.tree, &outer)
+}
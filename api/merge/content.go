@@ -0,0 +1,134 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+content.go implements the merges of the versions of a file, which merge
+their modes and their contents. Regular files are merged line by line,
+while the sides of binary files, symlinks and submodules are only ever
+picked.
+*/
+package merge
+
+import (
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// mergeContents merges the versions of a file, whose sides are of the
+// same type, into a path. The pathnames are the paths that the versions
+// came from, which label conflicts if they differ, and the extra marker
+// size lengthens conflict markers, for merges whose results are merged
+// again. It returns whether the merge is clean.
+func (m *merger) mergeContents(pth string, stages [3]version, pathnames [3]string, extraMarkerSize int) (version, bool, error) {
+	o, a, b := stages[base], stages[ours], stages[theirs]
+	result, clean := version{mode: b.mode}, true
+	if a.mode != b.mode && a.mode != o.mode {
+		// one side made the file executable
+		result.mode, clean = a.mode, b.mode == o.mode
+	}
+
+	switch {
+	case sameOid(a, b) || sameOid(a, o):
+		result.oid = b.oid
+	case sameOid(b, o):
+		result.oid = a.oid
+
+	case fileType(a.mode) == objects.ModeBlob:
+		oid, ok, err := m.mergeBlobs(pth, stages, pathnames, extraMarkerSize)
+		if err != nil {
+			return result, false, err
+		}
+		result.oid, clean = oid, clean && ok
+		m.message(AutoMerging, []string{pth}, "Auto-merging %s", pth)
+
+	case a.mode == objects.ModeCommit:
+		// submodules aren't checked out, so they can't be merged
+		result.oid, clean = a.oid, false
+		if !o.null() && !a.null() && !b.null() {
+			m.message(ConflictSubmodule, []string{pathnames[base]}, "Failed to merge submodule %s (not checked out)", pathnames[base])
+		}
+
+	default:
+		// symlinks are kept as they are, unless a side is favored
+		result.oid, clean = a.oid, false
+		switch m.opts.Favor {
+		case diff.FavorOurs:
+			clean = true
+		case diff.FavorTheirs:
+			result.oid, clean = b.oid, true
+		}
+	}
+	return result, clean, nil
+}
+
+// mergeBlobs merges the contents of regular files and stores the
+// result. A base of another type is left out, making the merge a
+// two-way merge.
+func (m *merger) mergeBlobs(pth string, stages [3]version, pathnames [3]string, extraMarkerSize int) (*objects.ObjectId, bool, error) {
+	var blobs [3]*objects.Blob
+	for i, v := range stages {
+		if v.null() || fileType(v.mode) != objects.ModeBlob {
+			continue
+		}
+		b, err := api.BlobFromOid(m.repo, v.oid)
+		if err != nil {
+			return nil, false, err
+		}
+		blobs[i] = b
+	}
+
+	opts := diff.MergeOptions{
+		Ours:       m.opts.Ours,
+		Theirs:     m.opts.Theirs,
+		Base:       m.opts.Base,
+		MarkerSize: m.opts.MarkerSize,
+		Level:      diff.MergeZealous,
+		Style:      m.opts.Style,
+		Favor:      m.opts.Favor,
+	}
+	if pathnames[base] != pathnames[ours] || pathnames[base] != pathnames[theirs] {
+		opts.Ours += ":" + pathnames[ours]
+		opts.Theirs += ":" + pathnames[theirs]
+		opts.Base += ":" + pathnames[base]
+	}
+	if opts.MarkerSize <= 0 {
+		opts.MarkerSize = diff.DefaultMarkerSize
+	}
+	opts.MarkerSize += extraMarkerSize
+
+	merged, conflicts, err := diff.MergeBlobs(blobs[base], blobs[ours], blobs[theirs], opts)
+	if err == diff.ErrBinaryMerge {
+		// binary files are merged by picking a side
+		switch m.opts.Favor {
+		case diff.FavorOurs:
+			return stages[ours].oid, true, nil
+		case diff.FavorTheirs:
+			return stages[theirs].oid, true, nil
+		}
+		m.message(ConflictBinary, []string{pth}, "warning: Cannot merge binary files: %s (%s vs. %s)", pth, opts.Ours, opts.Theirs)
+		return stages[ours].oid, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	oid, err := m.repo.WriteBlob(merged)
+	if err != nil {
+		return nil, false, err
+	}
+	return oid, conflicts == 0, nil
+}
+
+// sameOid returns true if two versions have the same contents, or
+// are both missing.
+func sameOid(v, w version) bool {
+	if v.oid == nil || w.oid == nil {
+		return v.oid == w.oid
+	}
+	return v.oid.String() == w.oid.String()
+}
@@ -0,0 +1,731 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+merge.go implements the three-way merge of trees, in the way of git's
+merge-ort.c. The paths of the three trees are collected first, and the
+paths that are trivially resolved are set aside. Renames are detected on
+both sides and moved onto the paths that they lead to, and the remaining
+paths are then resolved one by one, from the deepest up. The merge works
+on objects alone, so the working tree and the index are never touched.
+*/
+package merge
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"sort"
+	"strings"
+)
+
+// ================================================================= //
+// OPTIONS
+// ================================================================= //
+
+// DefaultRenameLimit is the default of merge.renameLimit.
+const DefaultRenameLimit = 7000
+
+// Options are the options of a merge of trees.
+type Options struct {
+	// Ours, Theirs and Base are the names of the sides of the
+	// merge and of their common ancestor, which label conflicts.
+	Ours   string
+	Theirs string
+	Base   string
+
+	// Renames control the detection of renames on both sides.
+	// If it is nil, renames are not detected. Copies are never
+	// looked for.
+	Renames *diff.RenameOptions
+
+	// Style, Favor and MarkerSize are the options of the merges
+	// of the contents of files.
+	Style      diff.ConflictStyle
+	Favor      diff.MergeFavor
+	MarkerSize int
+}
+
+// NewRenameOptions returns the options of the detection of renames
+// that merges use by default.
+func NewRenameOptions() *diff.RenameOptions {
+	return &diff.RenameOptions{MinScore: diff.DefaultRenameScore, Limit: DefaultRenameLimit}
+}
+
+// ================================================================= //
+// RESULTS
+// ================================================================= //
+
+// MessageType is the kind of a message of a merge.
+type MessageType int
+
+const (
+	AutoMerging            MessageType = iota // the contents of a file were merged
+	ConflictContents                          // the contents of a file conflict
+	ConflictBinary                            // the contents of a binary file differ
+	ConflictSubmodule                         // a submodule could not be merged
+	ConflictFileDirectory                     // a file was moved out of the way of a directory
+	ConflictDistinctTypes                     // a path has different types on each side
+	ConflictModifyDelete                      // a file was modified on one side and deleted on the other
+	ConflictRenameRename                      // a file was renamed differently on each side
+	ConflictRenameCollides                    // a file was renamed onto another one and their contents conflict
+	ConflictRenameDelete                      // a file was renamed on one side and deleted on the other
+)
+
+// Message describes something that happened to some paths during
+// a merge, most often a conflict.
+type Message struct {
+	Type MessageType
+
+	// Paths are the paths that the message is about, of which
+	// the first is the one that the message is listed under.
+	Paths []string
+
+	// Text is the message as git shows it.
+	Text string
+}
+
+// Conflict returns true if the message is about a conflict.
+func (m *Message) Conflict() bool {
+	return m.Type != AutoMerging
+}
+
+func (m *Message) String() string {
+	return m.Text
+}
+
+// Result is the result of a merge of trees.
+type Result struct {
+	// Tree is the merged tree, which has the files of conflicts
+	// in it, with conflict markers where their contents were
+	// merged.
+	Tree *objects.Tree
+
+	// Clean is true if nothing conflicted.
+	Clean bool
+
+	// Unmerged are the index entries of the paths that
+	// conflicted, at the stages of their base, ours and theirs
+	// versions, ordered by path.
+	Unmerged []*api.IndexEntry
+
+	// Messages are the messages of the merge, ordered by the
+	// paths that they are listed under.
+	Messages []*Message
+}
+
+// Conflicts returns the messages of the merge that are about
+// conflicts.
+func (r *Result) Conflicts() []*Message {
+	var conflicts []*Message
+	for _, m := range r.Messages {
+		if m.Conflict() {
+			conflicts = append(conflicts, m)
+		}
+	}
+	return conflicts
+}
+
+// ================================================================= //
+// MERGING
+// ================================================================= //
+
+// the sides of a merge, as indices into the versions of a path
+const (
+	base = iota
+	ours
+	theirs
+)
+
+// version is a version of a file, or the merged tree of a
+// directory. Versions that are missing have a mode of 0.
+type version struct {
+	mode objects.FileMode
+	oid  *objects.ObjectId
+}
+
+func (v version) null() bool {
+	return v.mode == 0
+}
+
+// same returns true if two versions have the same mode and
+// contents, neither of them being missing.
+func (v version) same(w version) bool {
+	return v.mode != 0 && v.mode == w.mode && v.oid.String() == w.oid.String()
+}
+
+// pathInfo is what is known about a path of a merge. The masks
+// have a bit for each side, in the order of base, ours and theirs.
+type pathInfo struct {
+	// the versions of the path on each side, which are files or
+	// directories, and the paths that they came from, which differ
+	// for renames
+	stages    [3]version
+	pathnames [3]string
+
+	// the sides that have a file at the path, the sides that have
+	// a directory there, and the sides whose versions match, if
+	// any do
+	filemask  int
+	dirmask   int
+	matchMask int
+
+	// a directory is in the way of the file, or the path is
+	// involved in renames that conflict
+	dfConflict   bool
+	pathConflict bool
+
+	// the merged version, and whether it is clean
+	result version
+	clean  bool
+}
+
+type merger struct {
+	repo *api.DiskRepository
+	opts *Options
+
+	// the paths of the merge, of which some are added while
+	// conflicts are resolved, and the ones that conflict
+	paths      map[string]*pathInfo
+	conflicted map[string]*pathInfo
+
+	// the directories that only one side changed, which are put
+	// off until we know whether they need to be looked into, and
+	// the sides whose directories have been looked into
+	deferred [3][]string
+	looked   [3]bool
+
+	// the paths of directories that aren't empty in the merged
+	// tree
+	filled map[string]bool
+
+	// the messages of the merge, by the path that they are
+	// listed under
+	messages map[string][]*Message
+}
+
+// MergeTrees merges the changes that two trees made to a common
+// base, which may be nil, and stores the merged tree along with the
+// contents of the files that were merged. The working tree and the
+// index are left alone.
+func MergeTrees(repo *api.DiskRepository, base, ours, theirs *objects.Tree, opts *Options) (*Result, error) {
+	m := &merger{
+		repo:       repo,
+		opts:       opts,
+		paths:      make(map[string]*pathInfo),
+		conflicted: make(map[string]*pathInfo),
+		filled:     make(map[string]bool),
+		messages:   make(map[string][]*Message),
+	}
+	trees := [3]*objects.Tree{base, ours, theirs}
+	if err := m.collect("", trees); err != nil {
+		return nil, err
+	}
+	if err := m.collectDeferred(); err != nil {
+		return nil, err
+	}
+	clean := true
+	if opts.Renames != nil {
+		var err error
+		if clean, err = m.mergeRenames(trees); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.processEntries(); err != nil {
+		return nil, err
+	}
+	return m.result(clean && len(m.conflicted) == 0)
+}
+
+// collect walks the three trees side by side and records the paths
+// that they have. Paths whose versions are the same on all sides are
+// resolved right away and, if they are directories, not looked into;
+// so are files that only one side changed. Directories that only one
+// side changed are put off, since they may not need to be looked into
+// either.
+func (m *merger) collect(dir string, trees [3]*objects.Tree) error {
+	entries := make(map[string]*[3]*objects.TreeEntry)
+	var names []string
+	for i, t := range trees {
+		if t == nil {
+			continue
+		}
+		for _, e := range t.Entries() {
+			es, ok := entries[e.Name()]
+			if !ok {
+				es = new([3]*objects.TreeEntry)
+				entries[e.Name()] = es
+				names = append(names, e.Name())
+			}
+			es[i] = e
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pth := dir + name
+		info := &pathInfo{pathnames: [3]string{pth, pth, pth}}
+		for i, e := range entries[name] {
+			if e == nil {
+				continue
+			}
+			info.stages[i] = version{e.Mode(), e.ObjectId()}
+			if e.ObjectType() == objects.ObjectTree {
+				info.dirmask |= 1 << uint(i)
+			} else {
+				info.filemask |= 1 << uint(i)
+			}
+		}
+		vs := info.stages
+		oursMatch, theirsMatch, sidesMatch := vs[ours].same(vs[base]), vs[theirs].same(vs[base]), vs[ours].same(vs[theirs])
+		m.paths[pth] = info
+
+		// trivial resolutions
+		switch {
+		case oursMatch && theirsMatch:
+			m.resolve(pth, info, vs[base])
+			continue
+		case info.filemask == 7 && sidesMatch:
+			m.resolve(pth, info, vs[ours])
+			continue
+		case info.filemask == 7 && oursMatch:
+			m.resolve(pth, info, vs[theirs])
+			continue
+		case info.filemask == 7 && theirsMatch:
+			m.resolve(pth, info, vs[ours])
+			continue
+		}
+		switch {
+		case oursMatch:
+			info.matchMask = 3
+		case theirsMatch:
+			info.matchMask = 5
+		case sidesMatch:
+			info.matchMask = 6
+		}
+		info.dfConflict = info.filemask != 0 && info.dirmask != 0
+		if info.dirmask == 0 {
+			continue
+		}
+
+		// the side that changed the directory, if only one did
+		side := base
+		switch {
+		case info.filemask == 0 && (info.dirmask == 2 || info.dirmask == 4):
+			// a new directory
+			info.matchMask = 7 - info.dirmask
+			side = info.dirmask / 2
+		case oursMatch:
+			side = theirs
+		case theirsMatch:
+			side = ours
+		}
+		if side != base && !m.looked[side] {
+			m.deferred[side] = append(m.deferred[side], pth)
+			continue
+		}
+		if err := m.collectDir(pth, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectDir collects the paths of a directory. The sides whose
+// versions of the directory match share their trees.
+func (m *merger) collectDir(pth string, info *pathInfo) error {
+	var trees [3]*objects.Tree
+	for i, v := range info.stages {
+		switch {
+		case i == ours && info.matchMask == 3:
+			trees[i] = trees[base]
+		case i == theirs && info.matchMask == 5:
+			trees[i] = trees[base]
+		case i == theirs && info.matchMask == 6:
+			trees[i] = trees[ours]
+		case info.dirmask&(1<<uint(i)) != 0:
+			t, err := api.TreeFromOid(m.repo, v.oid)
+			if err != nil {
+				return err
+			}
+			trees[i] = t
+		}
+	}
+	return m.collect(pth+"/", trees)
+}
+
+// collectDeferred handles the directories that only one side changed.
+// If that side deleted no files whose renames matter, the directories
+// can't have the targets of such renames in them, and they are taken
+// from that side as they are; otherwise, they are looked into.
+func (m *merger) collectDeferred() error {
+	for _, side := range []int{ours, theirs} {
+		trivial := m.opts.Renames == nil || !m.relevantDeletions(side)
+		m.looked[side] = true
+		for _, pth := range m.deferred[side] {
+			info := m.paths[pth]
+			if trivial {
+				m.resolve(pth, info, info.stages[side])
+				info.matchMask = 0
+				continue
+			}
+			if err := m.collectDir(pth, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve records the version that a path was cleanly resolved to.
+func (m *merger) resolve(pth string, info *pathInfo, v version) {
+	info.result, info.clean = v, true
+	if !v.null() {
+		m.fill(pth)
+	}
+}
+
+// fill records that the directories that lead to a path are not
+// empty.
+func (m *merger) fill(pth string) {
+	for i := strings.LastIndex(pth, "/"); i > 0; i = strings.LastIndex(pth, "/") {
+		pth = pth[:i]
+		if m.filled[pth] {
+			return
+		}
+		m.filled[pth] = true
+	}
+}
+
+// message records a message of the merge, which is listed under the
+// first of its paths.
+func (m *merger) message(t MessageType, paths []string, format string, args ...interface{}) {
+	msg := &Message{t, paths, fmt.Sprintf(format, args...)}
+	m.messages[paths[0]] = append(m.messages[paths[0]], msg)
+}
+
+// branch returns the name of a side.
+func (m *merger) branch(side int) string {
+	if side == ours {
+		return m.opts.Ours
+	}
+	return m.opts.Theirs
+}
+
+// ================================================================= //
+// RESOLVING CONFLICTS
+// ================================================================= //
+
+// processEntries resolves the paths that weren't resolved while they
+// were collected, from the last path to the first, in an order in
+// which directories come right before the paths in them. The paths
+// in directories are thus resolved before the directories are, and
+// directories that end up empty don't get in the way of files.
+func (m *merger) processEntries() error {
+	paths := make([]string, 0, len(m.paths))
+	for pth := range m.paths {
+		paths = append(paths, pth)
+	}
+	sort.Sort(dirsNextToChildren(paths))
+	for i := len(paths) - 1; i >= 0; i-- {
+		pth := paths[i]
+		info := m.paths[pth]
+		if info.clean {
+			if !info.result.null() {
+				m.fill(pth)
+			}
+			continue
+		}
+		if err := m.processEntry(pth, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processEntry resolves a path whose versions differ.
+func (m *merger) processEntry(pth string, info *pathInfo) error {
+	if info.dirmask != 0 {
+		// the contents of the directory were resolved already
+		if m.filled[pth] {
+			info.result = version{mode: objects.ModeTree}
+			m.fill(pth)
+		}
+		if info.filemask == 0 {
+			return nil
+		}
+	}
+
+	dfSide := 0
+	if info.dfConflict && info.result.null() {
+		// the directory went away, which leaves room for the file
+		info.dfConflict = false
+		info.matchMask &^= info.dirmask
+		info.dirmask = 0
+		info.zeroDirectories()
+	} else if info.dfConflict {
+		// the file is moved out of the way of the directory, unless
+		// both sides deleted it
+		if info.filemask == 1 {
+			info.filemask = 0
+			return nil
+		}
+		moved := *info
+		moved.matchMask &^= moved.dirmask
+		moved.dirmask = 0
+		moved.zeroDirectories()
+
+		dfSide = ours
+		if info.dirmask&(1<<ours) != 0 {
+			dfSide = theirs
+		}
+		branch := m.branch(dfSide)
+		newPath := m.uniquePath(pth, branch)
+		m.paths[newPath] = &moved
+		m.message(ConflictFileDirectory, []string{newPath, pth},
+			"CONFLICT (file/directory): directory in the way of %s from %s; moving it to %s instead.",
+			pth, branch, newPath)
+		info.filemask = 0
+		pth, info = newPath, &moved
+	}
+
+	switch {
+	case info.matchMask != 0:
+		info.clean = !info.dfConflict && !info.pathConflict
+		if info.matchMask == 6 {
+			info.result = info.stages[ours]
+			break
+		}
+		side := ours
+		if info.matchMask == 3 {
+			side = theirs
+		}
+		info.result = info.stages[side]
+		if info.result.null() {
+			info.clean = true
+		}
+
+	case info.filemask >= 6 && fileType(info.stages[ours].mode) != fileType(info.stages[theirs].mode):
+		pth = m.splitTypes(pth, info)
+
+	case info.filemask >= 6:
+		merged, clean, err := m.mergeContents(pth, info.stages, info.pathnames, 0)
+		if err != nil {
+			return err
+		}
+		info.clean = clean && !info.dfConflict && !info.pathConflict
+		info.result = merged
+		if clean && info.dfConflict {
+			info.filemask = 1 << uint(dfSide)
+			info.stages[dfSide] = merged
+		}
+		if !clean {
+			reason := "content"
+			if info.filemask == 6 {
+				reason = "add/add"
+			}
+			if merged.mode == objects.ModeCommit {
+				reason = "submodule"
+			}
+			m.message(ConflictContents, []string{pth}, "CONFLICT (%s): Merge conflict in %s", reason, pth)
+		}
+
+	case info.filemask == 3 || info.filemask == 5:
+		side, other := ours, theirs
+		if info.filemask == 5 {
+			side, other = theirs, ours
+		}
+		info.result, info.clean = info.stages[side], false
+		if info.pathConflict && info.stages[base].oid.String() == info.stages[side].oid.String() {
+			// a rename/delete that carried no change of the contents,
+			// which was reported as such already
+			break
+		}
+		modified, deleted := m.branch(side), m.branch(other)
+		m.message(ConflictModifyDelete, []string{pth},
+			"CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.",
+			pth, deleted, modified, modified, pth)
+
+	case info.filemask == 2 || info.filemask == 4:
+		side := ours
+		if info.filemask == 4 {
+			side = theirs
+		}
+		info.result = info.stages[side]
+		info.clean = !info.dfConflict && !info.pathConflict
+
+	case info.filemask == 1:
+		info.result = version{}
+		info.clean = !info.pathConflict
+	}
+
+	if !info.clean {
+		m.conflicted[pth] = info
+	}
+	if !info.result.null() {
+		m.fill(pth)
+	}
+	return nil
+}
+
+// zeroDirectories clears the versions of the sides that have no
+// file at the path.
+func (info *pathInfo) zeroDirectories() {
+	for i := range info.stages {
+		if info.filemask&(1<<uint(i)) == 0 {
+			info.stages[i] = version{}
+		}
+	}
+}
+
+// splitTypes resolves a path at which the sides have files of
+// different types, such as a file and a symlink, by moving regular
+// files, or both files if neither is regular, to paths of their own.
+// It returns the path of our version.
+func (m *merger) splitTypes(pth string, info *pathInfo) string {
+	o, a, b := info.stages[base].mode, info.stages[ours].mode, info.stages[theirs].mode
+	renameOurs, renameTheirs := fileType(a) == objects.ModeBlob, false
+	if !renameOurs {
+		renameTheirs = fileType(b) == objects.ModeBlob
+		if !renameTheirs {
+			renameOurs, renameTheirs = true, true
+		}
+	}
+	if renameOurs && renameTheirs {
+		m.message(ConflictDistinctTypes, []string{pth},
+			"CONFLICT (distinct types): %s had different types on each side; renamed both of them so each can be recorded somewhere.", pth)
+	} else {
+		m.message(ConflictDistinctTypes, []string{pth},
+			"CONFLICT (distinct types): %s had different types on each side; renamed one of them so each can be recorded somewhere.", pth)
+	}
+
+	// their version goes to a path of its own
+	info.clean = false
+	split := *info
+	split.result = info.stages[theirs]
+	split.stages[ours] = version{}
+	split.filemask = 5
+	if fileType(b) != fileType(o) {
+		split.stages[base] = version{}
+		split.filemask = 4
+	}
+
+	// and ours stays
+	info.result = info.stages[ours]
+	info.stages[theirs] = version{}
+	info.filemask = 3
+	if fileType(a) != fileType(o) {
+		info.stages[base] = version{}
+		info.filemask = 2
+	}
+
+	oursPath, theirsPath := pth, pth
+	if renameOurs {
+		oursPath = m.uniquePath(pth, m.opts.Ours)
+		m.paths[oursPath] = info
+	}
+	if renameTheirs {
+		theirsPath = m.uniquePath(pth, m.opts.Theirs)
+	}
+	m.paths[theirsPath] = &split
+	if renameOurs && renameTheirs {
+		delete(m.paths, pth)
+	}
+	m.conflicted[theirsPath] = &split
+	if !split.result.null() {
+		m.fill(theirsPath)
+	}
+	return oursPath
+}
+
+// uniquePath returns a path for a file of a side that has to move
+// out of the way, which no other file of the merge has.
+func (m *merger) uniquePath(pth, branch string) string {
+	prefix := pth + "~" + strings.Replace(branch, "/", "_", -1)
+	newPath := prefix
+	for i := 0; m.paths[newPath] != nil; i++ {
+		newPath = fmt.Sprintf("%s_%d", prefix, i)
+	}
+	return newPath
+}
+
+// fileType groups modes into regular files, symlinks and submodules.
+func fileType(mode objects.FileMode) objects.FileMode {
+	if mode == objects.ModeBlobExec {
+		return objects.ModeBlob
+	}
+	return mode
+}
+
+// dirsNextToChildren sorts paths as if they ended in a slash, which
+// puts directories right before the paths in them.
+type dirsNextToChildren []string
+
+func (s dirsNextToChildren) Len() int           { return len(s) }
+func (s dirsNextToChildren) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s dirsNextToChildren) Less(i, j int) bool { return s[i]+"/" < s[j]+"/" }
+
+// ================================================================= //
+// RESULTS
+// ================================================================= //
+
+// result writes the merged tree and gathers the conflicts and the
+// messages of the merge.
+func (m *merger) result(clean bool) (*Result, error) {
+	var entries []*objects.TreeEntry
+	for pth, info := range m.paths {
+		v := info.result
+		if v.null() || v.oid == nil {
+			// the trees of directories are written from their files
+			continue
+		}
+		entries = append(entries, objects.NewTreeEntry(v.mode, objectType(v.mode), pth, v.oid))
+	}
+	tree, err := api.WriteTree(m.repo, entries)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{Tree: tree, Clean: clean}
+
+	var paths []string
+	for pth := range m.conflicted {
+		paths = append(paths, pth)
+	}
+	sort.Strings(paths)
+	for _, pth := range paths {
+		info := m.conflicted[pth]
+		for i, v := range info.stages {
+			if info.filemask&(1<<uint(i)) != 0 {
+				r.Unmerged = append(r.Unmerged, api.NewIndexEntry(pth, i+1, v.oid, v.mode, nil))
+			}
+		}
+	}
+
+	paths = paths[:0]
+	for pth := range m.messages {
+		paths = append(paths, pth)
+	}
+	sort.Strings(paths)
+	for _, pth := range paths {
+		r.Messages = append(r.Messages, m.messages[pth]...)
+	}
+	return r, nil
+}
+
+// objectType returns the type of the objects that entries of a mode
+// refer to.
+func objectType(mode objects.FileMode) objects.ObjectType {
+	switch mode {
+	case objects.ModeTree:
+		return objects.ObjectTree
+	case objects.ModeCommit:
+		return objects.ObjectCommit
+	}
+	return objects.ObjectBlob
+}
@@ -0,0 +1,123 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+merge_git_test.go implements git-comparison tests for the merges of trees,
+which are checked against the output of git-merge-tree.
+*/
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// formatResult formats the result of a merge as git-merge-tree
+// does, which only shows conflicts and messages if there are
+// conflicts.
+func formatResult(r *Result) string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, r.Tree.ObjectId())
+	if r.Clean {
+		return buf.String()
+	}
+	for _, e := range r.Unmerged {
+		fmt.Fprintf(buf, "%.6o %s %d\t%s\n", e.Mode(), e.ObjectId(), e.Stage(), e.Name())
+	}
+	fmt.Fprintln(buf)
+	for _, m := range r.Messages {
+		fmt.Fprintln(buf, m.Text)
+	}
+	return buf.String()
+}
+
+// Test_MergeTrees compares merges of trees with the ones of
+// git-merge-tree, in the default conflict style and in diff3.
+func Test_MergeTrees(t *testing.T) {
+	testCase := test.Merges
+	repo := api.Open(testCase.Repo())
+	info := testCase.Info().(*test.InfoMerges)
+	for _, name := range info.Names {
+		ours, theirs := name+"/ours", name+"/theirs"
+		var trees [3]*objects.Tree
+		for i, rev := range []string{name + "/base", ours, theirs} {
+			tree, err := api.TreeFromRevision(repo, rev)
+			util.AssertNoErrOrDie(t, err)
+			trees[i] = tree
+		}
+		for _, style := range []string{"merge", "diff3"} {
+			expected, err := util.GitExec(testCase.Repo(), "-c", "merge.conflictStyle="+style, "merge-tree", "--write-tree", ours, theirs)
+			opts := &Options{
+				Ours:    ours,
+				Theirs:  theirs,
+				Base:    strings.TrimSpace(util.GitNow(testCase.Repo(), "rev-parse", "--short", name+"/base")),
+				Renames: NewRenameOptions(),
+			}
+			opts.Style, _ = diff.ParseConflictStyle(style)
+			r, e := MergeTrees(repo, trees[0], trees[1], trees[2], opts)
+			util.AssertNoErrOrDie(t, e)
+			util.AssertEqualString(t, expected, formatResult(r))
+			util.Assertf(t, r.Clean == (err == nil), "%s: expected the merge to be clean only if git's is", name)
+		}
+	}
+}
+
+// Test_MergeTreesWithoutRenames checks that renamed files conflict
+// with the changes of the other side when renames aren't detected.
+func Test_MergeTreesWithoutRenames(t *testing.T) {
+	repo := api.Open(test.Merges.Repo())
+	base, err := api.TreeFromRevision(repo, "renames/base")
+	util.AssertNoErrOrDie(t, err)
+	ours, err := api.TreeFromRevision(repo, "renames/ours")
+	util.AssertNoErrOrDie(t, err)
+	theirs, err := api.TreeFromRevision(repo, "renames/theirs")
+	util.AssertNoErrOrDie(t, err)
+
+	r, err := MergeTrees(repo, base, ours, theirs, &Options{Ours: "ours", Theirs: "theirs"})
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, !r.Clean, "expected conflicts")
+	var paths []string
+	for _, m := range r.Conflicts() {
+		paths = append(paths, m.Paths[0])
+	}
+	sort.Strings(paths)
+	util.AssertEqualString(t, "a b e2", strings.Join(paths, " "))
+}
+
+// Test_MergeCommits compares merges of commits with the ones of
+// git-merge-tree, when the commits have several merge bases that
+// conflict, as in criss-cross merges, and when they have none.
+func Test_MergeCommits(t *testing.T) {
+	dir := test.CrissCross.Repo()
+	repo := api.Open(dir)
+	for _, pair := range [][2]string{{"ours", "theirs"}, {"ours", "unrelated"}} {
+		for _, style := range []string{"merge", "diff3"} {
+			expected, err := util.GitExec(dir, "-c", "merge.conflictStyle="+style, "merge-tree", "--write-tree", "--allow-unrelated-histories", pair[0], pair[1])
+			var sides [2]*objects.Commit
+			for i, rev := range pair {
+				c, e := api.CommitFromRef(repo, "refs/heads/"+rev)
+				util.AssertNoErrOrDie(t, e)
+				sides[i] = c
+			}
+			opts := &Options{Ours: pair[0], Theirs: pair[1], Renames: NewRenameOptions()}
+			opts.Style, _ = diff.ParseConflictStyle(style)
+			r, e := MergeCommits(repo, sides[0], sides[1], opts)
+			util.AssertNoErrOrDie(t, e)
+			util.AssertEqualString(t, expected, formatResult(r))
+			util.Assertf(t, r.Clean == (err == nil), "%s: expected the merge to be clean only if git's is", pair[1])
+		}
+	}
+}
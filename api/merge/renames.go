@@ -0,0 +1,204 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+renames.go implements the handling of renames in merges. The renames of
+both sides are detected with the rename detection of diffs, and the
+versions of the files that they renamed are moved onto the paths that
+they renamed them to, so that the changes of the other side follow them
+there.
+*/
+package merge
+
+import (
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"sort"
+)
+
+// rename is a file that a side renamed.
+type rename struct {
+	side     int
+	old, new string
+}
+
+// mergeRenames detects the renames of both sides and moves the
+// versions of the renamed files onto their new paths. It returns
+// false if the contents of some of them conflict.
+func (m *merger) mergeRenames(trees [3]*objects.Tree) (bool, error) {
+	var renames []*rename
+	for _, side := range []int{ours, theirs} {
+		// renames only matter for files that the other side
+		// changed
+		if !m.relevantDeletions(side) {
+			continue
+		}
+		opts := *m.opts.Renames
+		opts.Copies, opts.CopiesHarder = false, false
+		opts.Relevant = m.relevant
+		td, err := diff.DiffTrees(m.repo, trees[base], trees[side], &diff.Options{Renames: &opts})
+		if err != nil {
+			return false, err
+		}
+		for _, edit := range td.Renamed() {
+			renames = append(renames, &rename{side, edit.Before.Name(), edit.After.Name()})
+		}
+	}
+	sort.Sort(byOldPath(renames))
+	return m.processRenames(renames)
+}
+
+// relevantDeletions returns true if a side deleted files that the
+// other side changed, which may have been renamed.
+func (m *merger) relevantDeletions(side int) bool {
+	for pth, info := range m.paths {
+		if info.filemask&1 != 0 && info.filemask&(1<<uint(side)) == 0 && m.relevant(pth) {
+			return true
+		}
+	}
+	return false
+}
+
+// relevant returns true if the contents of the file at a path
+// differ between the sides and the base, so that a rename of it
+// needs the contents to be merged.
+func (m *merger) relevant(pth string) bool {
+	info := m.paths[pth]
+	return info != nil && !info.clean && info.matchMask&info.filemask == 0
+}
+
+// processRenames moves the versions of renamed files onto the paths
+// that they were renamed to, where they are merged with the other
+// side's version of the file. Renames are sorted by their old paths,
+// so the renames of a file by both sides are next to each other.
+func (m *merger) processRenames(renames []*rename) (bool, error) {
+	clean := true
+	for i := 0; i < len(renames); i++ {
+		r := renames[i]
+		oldInfo, newInfo := m.paths[r.old], m.paths[r.new]
+		if oldInfo == nil || oldInfo.clean {
+			// the other side didn't change the file
+			continue
+		}
+
+		if i+1 < len(renames) && renames[i+1].old == r.old {
+			// both sides renamed the file
+			other := renames[i+1].new
+			i++
+			if r.new == other {
+				newInfo.stages[base] = oldInfo.stages[base]
+				newInfo.filemask |= 1
+				oldInfo.result, oldInfo.clean = version{}, true
+				continue
+			}
+
+			pathnames := [3]string{r.old, r.new, other}
+			oursInfo, theirsInfo := newInfo, m.paths[other]
+			stages := [3]version{oldInfo.stages[base], oursInfo.stages[ours], theirsInfo.stages[theirs]}
+			merged, ok, err := m.mergeContents(r.old, stages, pathnames, 1)
+			if err != nil {
+				return false, err
+			}
+			clean = clean && ok
+			// binary files aren't merged, so each path keeps its
+			// own side's version
+			binary := !ok && merged.same(stages[ours])
+			oursInfo.stages[ours] = merged
+			if binary {
+				merged = stages[theirs]
+			}
+			theirsInfo.stages[theirs] = merged
+			oursInfo.pathConflict, theirsInfo.pathConflict, oldInfo.pathConflict = true, true, true
+			m.message(ConflictRenameRename, []string{r.old, r.new, other},
+				"CONFLICT (rename/rename): %s renamed to %s in %s and to %s in %s.",
+				r.old, r.new, m.opts.Ours, other, m.opts.Theirs)
+			continue
+		}
+
+		otherSide := ours + theirs - r.side
+		sourceDeleted := oldInfo.filemask == 1
+		collision := newInfo.filemask&(1<<uint(otherSide)) != 0
+		typeChanged := !sourceDeleted &&
+			(fileType(oldInfo.stages[otherSide].mode) == objects.ModeBlob) != (fileType(newInfo.stages[r.side].mode) == objects.ModeBlob)
+		if typeChanged && collision {
+			// the other side renamed the file as well, but replaced
+			// it with a file of another type, so the rename wasn't
+			// detected
+			collision = false
+		}
+		renamed, deleted := m.branch(r.side), m.branch(otherSide)
+
+		switch {
+		case collision && !sourceDeleted:
+			// the file was renamed onto a file that the other side
+			// added or renamed there
+			var pathnames [3]string
+			pathnames[base], pathnames[otherSide], pathnames[r.side] = r.old, r.old, r.new
+			var stages [3]version
+			stages[base] = m.paths[pathnames[base]].stages[base]
+			stages[ours] = m.paths[pathnames[ours]].stages[ours]
+			stages[theirs] = m.paths[pathnames[theirs]].stages[theirs]
+			merged, ok, err := m.mergeContents(r.old, stages, pathnames, 1)
+			if err != nil {
+				return false, err
+			}
+			newInfo.stages[r.side] = merged
+			if !ok {
+				m.message(ConflictRenameCollides, []string{r.new, r.old},
+					"CONFLICT (rename involved in collision): rename of %s -> %s has content conflicts AND collides with another path; this may result in nested conflict markers.",
+					r.old, r.new)
+			}
+
+		case collision && sourceDeleted:
+			// the other side deleted the file, which leaves an
+			// add/add conflict
+			newInfo.pathConflict = true
+			m.message(ConflictRenameDelete, []string{r.new, r.old},
+				"CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.",
+				r.old, r.new, renamed, deleted)
+
+		default:
+			newInfo.stages[base] = oldInfo.stages[base]
+			newInfo.filemask |= 1
+			newInfo.pathnames[base] = r.old
+			switch {
+			case typeChanged:
+				// the old path keeps the other side's file
+				oldInfo.stages[base] = version{}
+				oldInfo.filemask &= 6
+			case sourceDeleted:
+				newInfo.pathConflict = true
+				m.message(ConflictRenameDelete, []string{r.new, r.old},
+					"CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.",
+					r.old, r.new, renamed, deleted)
+			default:
+				newInfo.stages[otherSide] = oldInfo.stages[otherSide]
+				newInfo.filemask |= 1 << uint(otherSide)
+				newInfo.pathnames[otherSide] = r.old
+			}
+		}
+
+		if !typeChanged {
+			oldInfo.result, oldInfo.clean = version{}, true
+		}
+	}
+	return clean, nil
+}
+
+// byOldPath sorts renames by the paths that they renamed, keeping
+// the renames of our side first.
+type byOldPath []*rename
+
+func (s byOldPath) Len() int      { return len(s) }
+func (s byOldPath) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byOldPath) Less(i, j int) bool {
+	if s[i].old != s[j].old {
+		return s[i].old < s[j].old
+	}
+	return s[i].side < s[j].side
+}
@@ -0,0 +1,302 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+merge_bases.go implements the computation of merge bases, which are the
+best common ancestors of commits, as git-merge-base does. A common ancestor
+is best if it isn't an ancestor of another common ancestor.
+*/
+package api
+
+import (
+	"container/heap"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// ================================================================= //
+// MERGE BASES
+// ================================================================= //
+
+// MergeBase returns the first of the merge bases of a commit and
+// others, which is the youngest one, or nil if they have no common
+// ancestor.
+func MergeBase(repo Repository, one *objects.Commit, twos ...*objects.Commit) (*objects.Commit, error) {
+	bases, err := MergeBases(repo, one, twos...)
+	if err != nil || len(bases) == 0 {
+		return nil, err
+	}
+	return bases[0], nil
+}
+
+// MergeBases returns the merge bases of a commit and others, that is,
+// the best common ancestors of the first commit and any of the others,
+// youngest first. The merge base of a commit and itself is itself.
+func MergeBases(repo Repository, one *objects.Commit, twos ...*objects.Commit) ([]*objects.Commit, error) {
+	for _, two := range twos {
+		if sameCommit(one, two) {
+			return []*objects.Commit{one}, nil
+		}
+	}
+	p := newPainter(repo)
+	common, err := p.paint(one, twos)
+	if err != nil {
+		return nil, err
+	}
+	var bases []*objects.Commit
+	for _, c := range common {
+		if p.flags[c.ObjectId().String()]&markStale == 0 {
+			bases = insertByDate(bases, c)
+		}
+	}
+	if len(bases) <= 1 {
+		return bases, nil
+	}
+
+	// common ancestors may still be ancestors of each other if
+	// they were found before the walk reached them from the others
+	if bases, err = removeRedundant(repo, bases); err != nil {
+		return nil, err
+	}
+	var sorted []*objects.Commit
+	for _, c := range bases {
+		sorted = insertByDate(sorted, c)
+	}
+	return sorted, nil
+}
+
+// OctopusMergeBases returns the merge bases of all of the given
+// commits together, which are the bases of an octopus merge of
+// them, as git-merge-base --octopus does.
+func OctopusMergeBases(repo Repository, commits []*objects.Commit) ([]*objects.Commit, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	bases := []*objects.Commit{commits[0]}
+	for _, c := range commits[1:] {
+		var next []*objects.Commit
+		for _, b := range bases {
+			more, err := MergeBases(repo, c, b)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, more...)
+		}
+		bases = next
+	}
+	return IndependentCommits(repo, bases)
+}
+
+// IndependentCommits returns the commits that aren't reachable from
+// any of the others, in the order in which they were given and
+// without duplicates, as git-merge-base --independent does.
+func IndependentCommits(repo Repository, commits []*objects.Commit) ([]*objects.Commit, error) {
+	seen := make(map[string]bool)
+	var unique []*objects.Commit
+	for _, c := range commits {
+		if id := c.ObjectId().String(); !seen[id] {
+			seen[id] = true
+			unique = append(unique, c)
+		}
+	}
+	return removeRedundant(repo, unique)
+}
+
+// IsAncestor returns true if a commit is reachable from another,
+// which includes the other commit itself.
+func IsAncestor(repo Repository, ancestor, c *objects.Commit) (bool, error) {
+	p := newPainter(repo)
+	if _, err := p.paint(ancestor, []*objects.Commit{c}); err != nil {
+		return false, err
+	}
+	return p.flags[ancestor.ObjectId().String()]&markParent2 != 0, nil
+}
+
+// ForkPoint returns the commit at which a commit forked from a ref,
+// as git-merge-base --fork-point does. This is the merge base of the
+// commit and the values that the log of the ref records, which must
+// be one of them, so that the fork point is found even if the ref
+// was rewritten since. It returns nil if there is no fork point, and
+// an error that can be checked with IsNoSuchRef if there is no ref.
+func ForkPoint(repo *DiskRepository, spec string, c *objects.Commit) (*objects.Commit, error) {
+	ref, err := PeeledRefFromSpec(repo, spec)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := repo.Reflog(ref.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []*objects.Commit
+	seen := make(map[string]bool)
+	add := func(oid *objects.ObjectId) {
+		id := oid.String()
+		if seen[id] || id == nullOid {
+			return
+		}
+		if o, err := repo.ObjectFromOid(oid); err == nil {
+			if rev, ok := o.(*objects.Commit); ok {
+				seen[id] = true
+				revs = append(revs, rev)
+			}
+		}
+	}
+	for i, e := range entries {
+		if i == 0 {
+			add(e.Old())
+		}
+		add(e.New())
+	}
+	if len(revs) == 0 {
+		add(ref.ObjectId())
+	}
+
+	bases, err := MergeBases(repo, c, revs...)
+	if err != nil || len(bases) != 1 || !seen[bases[0].ObjectId().String()] {
+		return nil, err
+	}
+	return bases[0], nil
+}
+
+// ================================================================= //
+// PAINTING
+// ================================================================= //
+
+// the marks that painting leaves on commits
+const (
+	markParent1 = 1 << iota // reachable from the first side
+	markParent2             // reachable from the second side
+	markStale               // reachable from a common ancestor
+	markResult              // found to be a common ancestor
+)
+
+// the object id that refers to no object
+const nullOid = "0000000000000000000000000000000000000000"
+
+// painter paints the ancestors of commits with the sides that they
+// are reachable from, to find their common ancestors.
+type painter struct {
+	repo  Repository
+	flags map[string]int
+}
+
+func newPainter(repo Repository) *painter {
+	return &painter{repo, make(map[string]int)}
+}
+
+// paint walks the ancestors of a commit and others, youngest first,
+// until the only ones left to walk are ancestors of common ancestors.
+// It returns the common ancestors that it found, youngest first,
+// some of which may be ancestors of others.
+func (p *painter) paint(one *objects.Commit, twos []*objects.Commit) ([]*objects.Commit, error) {
+	p.flags[one.ObjectId().String()] |= markParent1
+	if len(twos) == 0 {
+		return []*objects.Commit{one}, nil
+	}
+	q := new(commitQueue)
+	heap.Push(q, one)
+	for _, two := range twos {
+		p.flags[two.ObjectId().String()] |= markParent2
+		heap.Push(q, two)
+	}
+
+	var common []*objects.Commit
+	for p.nonStale(q) {
+		c := heap.Pop(q).(*objects.Commit)
+		id := c.ObjectId().String()
+		flags := p.flags[id] & (markParent1 | markParent2 | markStale)
+		if flags == markParent1|markParent2 {
+			if p.flags[id]&markResult == 0 {
+				p.flags[id] |= markResult
+				common = insertByDate(common, c)
+			}
+			// the ancestors of a common ancestor aren't best
+			flags |= markStale
+		}
+		for _, oid := range c.Parents() {
+			pid := oid.String()
+			if p.flags[pid]&flags == flags {
+				continue
+			}
+			parent, err := CommitFromOid(p.repo, oid)
+			if err != nil {
+				return nil, err
+			}
+			p.flags[pid] |= flags
+			heap.Push(q, parent)
+		}
+	}
+	return common, nil
+}
+
+// nonStale returns true if some of the commits that are left to
+// walk aren't ancestors of common ancestors.
+func (p *painter) nonStale(q *commitQueue) bool {
+	for _, c := range q.commits {
+		if p.flags[c.ObjectId().String()]&markStale == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// removeRedundant returns the commits that aren't reachable from
+// any of the others, in the order in which they were given.
+func removeRedundant(repo Repository, commits []*objects.Commit) ([]*objects.Commit, error) {
+	redundant := make([]bool, len(commits))
+	for i, c := range commits {
+		if redundant[i] {
+			continue
+		}
+		var others []*objects.Commit
+		var indexes []int
+		for j, other := range commits {
+			if i != j && !redundant[j] {
+				others = append(others, other)
+				indexes = append(indexes, j)
+			}
+		}
+		p := newPainter(repo)
+		if _, err := p.paint(c, others); err != nil {
+			return nil, err
+		}
+		if p.flags[c.ObjectId().String()]&markParent2 != 0 {
+			redundant[i] = true
+		}
+		for k, other := range others {
+			if p.flags[other.ObjectId().String()]&markParent1 != 0 {
+				redundant[indexes[k]] = true
+			}
+		}
+	}
+	var independent []*objects.Commit
+	for i, c := range commits {
+		if !redundant[i] {
+			independent = append(independent, c)
+		}
+	}
+	return independent, nil
+}
+
+// insertByDate inserts a commit into a list of commits sorted by
+// decreasing commit date, after the commits of the same date.
+func insertByDate(commits []*objects.Commit, c *objects.Commit) []*objects.Commit {
+	date := c.Committer().Seconds()
+	i := 0
+	for i < len(commits) && commits[i].Committer().Seconds() >= date {
+		i++
+	}
+	commits = append(commits, nil)
+	copy(commits[i+1:], commits[i:])
+	commits[i] = c
+	return commits
+}
+
+func sameCommit(a, b *objects.Commit) bool {
+	return a.ObjectId().String() == b.ObjectId().String()
+}
@@ -0,0 +1,125 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+merge_bases_git_test.go implements git-comparison tests for merge bases,
+which are checked against the output of git-merge-base.
+*/
+package api
+
+import (
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"strings"
+	"testing"
+)
+
+// formatCommits formats commits as git-merge-base prints them.
+func formatCommits(commits []*objects.Commit) string {
+	s := ""
+	for _, c := range commits {
+		s += c.ObjectId().String() + "\n"
+	}
+	return s
+}
+
+// Test_MergeBases compares merge bases with the ones of git-merge-base,
+// in each of its modes.
+func Test_MergeBases(t *testing.T) {
+	dir := test.MergeBases.Repo()
+	repo := Open(dir)
+
+	revs := func(names []string) []*objects.Commit {
+		commits := make([]*objects.Commit, len(names))
+		for i, name := range names {
+			o, err := ObjectFromRevision(repo, name)
+			util.AssertNoErrOrDie(t, err)
+			c, err := CommitFromObject(repo, o)
+			util.AssertNoErrOrDie(t, err)
+			commits[i] = c
+		}
+		return commits
+	}
+
+	cases := [][]string{
+		{"x", "y"},
+		{"y", "x"},
+		{"x", "x"},
+		{"x", "B"},
+		{"B", "x"},
+		{"x", "z"},
+		{"x", "lonely"},
+		{"x", "y", "z"},
+		{"z", "x", "y"},
+		{"topic", "up"},
+		{"x", "y^", "x^"},
+	}
+	for _, names := range cases {
+		commits := revs(names)
+
+		expected, _ := util.GitExec(dir, append([]string{"merge-base", "--all"}, names...)...)
+		bases, err := MergeBases(repo, commits[0], commits[1:]...)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, expected, formatCommits(bases))
+
+		expected, _ = util.GitExec(dir, append([]string{"merge-base"}, names...)...)
+		base, err := MergeBase(repo, commits[0], commits[1:]...)
+		util.AssertNoErr(t, err)
+		actual := ""
+		if base != nil {
+			actual = formatCommits([]*objects.Commit{base})
+		}
+		util.AssertEqualString(t, expected, actual)
+
+		expected, _ = util.GitExec(dir, append([]string{"merge-base", "--all", "--octopus"}, names...)...)
+		bases, err = OctopusMergeBases(repo, commits)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, expected, formatCommits(bases))
+
+		expected, _ = util.GitExec(dir, append([]string{"merge-base", "--independent"}, names...)...)
+		bases, err = IndependentCommits(repo, commits)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, expected, formatCommits(bases))
+
+		_, notAncestor := util.GitExec(dir, "merge-base", "--is-ancestor", names[0], names[1])
+		ok, err := IsAncestor(repo, commits[0], commits[1])
+		util.AssertNoErr(t, err)
+		util.Assertf(t, ok == (notAncestor == nil), "%s: expected is-ancestor to be %v", strings.Join(names, " "), !ok)
+	}
+}
+
+// Test_ForkPoint compares fork points with the ones of
+// git-merge-base --fork-point, which read reflogs.
+func Test_ForkPoint(t *testing.T) {
+	dir := test.MergeBases.Repo()
+	repo := Open(dir)
+
+	entries, err := repo.Reflog("refs/heads/up")
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualInt(t, 4, len(entries))
+	util.AssertEqualString(t, "commit: U2", entries[3].Message())
+
+	for _, names := range [][]string{{"up", "topic"}, {"up", "x"}, {"x", "topic"}, {"lonely", "x"}, {"HEAD", "up"}} {
+		expected, _ := util.GitExec(dir, "merge-base", "--fork-point", names[0], names[1])
+		o, err := ObjectFromRevision(repo, names[1])
+		util.AssertNoErrOrDie(t, err)
+		c, err := CommitFromObject(repo, o)
+		util.AssertNoErrOrDie(t, err)
+		fork, err := ForkPoint(repo, names[0], c)
+		util.AssertNoErr(t, err)
+		actual := ""
+		if fork != nil {
+			actual = formatCommits([]*objects.Commit{fork})
+		}
+		util.AssertEqualString(t, expected, actual)
+	}
+
+	_, err = ForkPoint(repo, "nope", nil)
+	util.Assert(t, IsNoSuchRef(err), "expected no such ref")
+}
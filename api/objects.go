@@ -7,7 +7,10 @@
 //
 package api
 
-import "github.com/jbrukh/ggit/api/objects"
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+)
 
 // ================================================================= //
 // OPERATIONS
@@ -23,13 +26,55 @@ func ObjectFromRef(repo Repository, spec string) (objects.Object, error) {
 	return repo.ObjectFromOid(ref.ObjectId())
 }
 
+// PeelObject follows annotated tags, including tags of tags,
+// until it reaches an object that is not a tag, and returns
+// that object. Other objects are returned as they are.
+func PeelObject(repo Repository, o objects.Object) (objects.Object, error) {
+	for {
+		t, ok := o.(*objects.Tag)
+		if !ok {
+			return o, nil
+		}
+		var err error
+		if o, err = repo.ObjectFromOid(t.Object()); err != nil {
+			return nil, err
+		}
+	}
+}
+
 // ObjectFromRevision takes a revision specification and obtains the
 // object that this revision specifies.
 func ObjectFromRevision(repo Repository, rev string) (objects.Object, error) {
-	p := newRevParser(repo, rev)
+	return objectFromRevision(repo, rev, false)
+}
+
+// LookupRevision is like ObjectFromRevision, but a revision that
+// doesn't resolve is only an error, without the debugging output of
+// the parser, as befits arguments that may just as well turn out to
+// be paths, or that users mistype.
+func LookupRevision(repo Repository, rev string) (objects.Object, error) {
+	return objectFromRevision(repo, rev, true)
+}
+
+func objectFromRevision(repo Repository, rev string, quiet bool) (objects.Object, error) {
+	p := newRevParser(repo, rev, quiet)
 	e := p.Parse()
 	if e != nil {
 		return nil, e
 	}
 	return p.Object(), nil
 }
+
+// BlobFromOid returns the blob with the given oid, failing if
+// the object is not a blob.
+func BlobFromOid(repo Repository, oid *objects.ObjectId) (*objects.Blob, error) {
+	o, err := repo.ObjectFromOid(oid)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := o.(*objects.Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a blob", oid)
+	}
+	return b, nil
+}
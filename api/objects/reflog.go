@@ -0,0 +1,45 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package objects
+
+// ================================================================= //
+// REFLOG ENTRIES
+// ================================================================= //
+
+// ReflogEntry is an entry of the log of a ref, which records
+// an update of the ref from one object id to another, who made
+// it and when, and why.
+type ReflogEntry struct {
+	old, new *ObjectId
+	who      *WhoWhen
+	msg      string
+}
+
+func NewReflogEntry(old, new *ObjectId, who *WhoWhen, msg string) *ReflogEntry {
+	return &ReflogEntry{old, new, who, msg}
+}
+
+// Old returns the object id that the ref pointed to before the
+// update, which is all zeros if the ref was created.
+func (e *ReflogEntry) Old() *ObjectId {
+	return e.old
+}
+
+// New returns the object id that the ref was updated to, which
+// is all zeros if the ref was deleted.
+func (e *ReflogEntry) New() *ObjectId {
+	return e.new
+}
+
+func (e *ReflogEntry) Who() *WhoWhen {
+	return e.who
+}
+
+func (e *ReflogEntry) Message() string {
+	return e.msg
+}
@@ -56,6 +56,11 @@ func (w *When) Offset() int {
 	return w.offset
 }
 
+// Time returns the time in its own time zone.
+func (w *When) Time() time.Time {
+	return time.Unix(w.seconds, 0).In(time.FixedZone("", w.offset*60))
+}
+
 func (w *When) Date() string {
 	t := time.Unix(w.seconds, int64(0))
 	// standard time: Mon Jan 2 15:04:05 -0700 MST 2006
@@ -237,6 +237,17 @@ func Unpack(packs []*Pack, oid *objects.ObjectId) (obj objects.Object, ok bool)
 	return
 }
 
+// Contains returns true if one of the packs has the object
+// with the given ObjectId.
+func Contains(packs []*Pack, oid *objects.ObjectId) bool {
+	for _, pack := range packs {
+		if pack.idx.entryById(oid) != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func ObjectIdsFromPacks(packs []*Pack) (ids []*objects.ObjectId) {
 	var count int64
 	for _, pack := range packs {
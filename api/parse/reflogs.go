@@ -0,0 +1,56 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package parse
+
+import (
+	"bufio"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/token"
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// REFLOG PARSING
+// ================================================================= //
+
+// reflogParser implements the parsing of the logs of refs, whose
+// lines are of the form:
+//
+//	<old oid> SP <new oid> SP <name> SP <email> SP <when> [TAB <message>] LF
+type reflogParser struct {
+	objectParser
+}
+
+func NewReflogParser(buf *bufio.Reader) *reflogParser {
+	return &reflogParser{
+		*NewObjectParser(buf, nil),
+	}
+}
+
+// ParseReflog parses the entries of a reflog, oldest first.
+func (p *reflogParser) ParseReflog() ([]*objects.ReflogEntry, error) {
+	r := make([]*objects.ReflogEntry, 0)
+	err := util.SafeParse(func() {
+		for !p.EOF() {
+			old := p.ParseOid()
+			p.ConsumeByte(token.SP)
+			new := p.ParseOid()
+			who := p.parseWhoWhen("")
+			msg := ""
+			switch p.ReadByte() {
+			case token.TAB:
+				msg = p.ReadString(token.LF)
+			case token.LF:
+			default:
+				util.PanicErrf("expected: message or end of line")
+			}
+			r = append(r, objects.NewReflogEntry(old, new, who, msg))
+		}
+	})
+	return r, err
+}
@@ -51,6 +51,8 @@ func deduceObjectType(mode objects.FileMode) objects.ObjectType {
 		return objects.ObjectBlob
 	case objects.ModeTree:
 		return objects.ObjectTree
+	case objects.ModeCommit:
+		return objects.ObjectCommit
 	}
 	// TODO
 	panic("unknown mode")
@@ -0,0 +1,482 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+Package pathspec implements git pathspecs, the patterns that limit
+commands to a subset of the paths in a tree, the index or the working
+tree.
+
+A pathspec is a literal path prefix, a wildcard pattern, or either of
+those preceded by magic, given in long form as in ":(top,icase)path"
+or in short form as in ":/path" or ":!path". The supported magic is:
+
+	top      the path is relative to the top of the working tree
+	literal  wildcards in the path are treated as literal characters
+	glob     wildcards do not match '/', and "**" matches directories
+	icase    the path is matched regardless of case
+	exclude  matching paths are removed from the result (also ! and ^)
+	attr     the path must have the given attributes, as in
+	         ":(attr:text -diff eol=lf)"
+*/
+package pathspec
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// MAGIC
+// ================================================================= //
+
+// Magic is a bit set of the magic of a pathspec item.
+type Magic int
+
+const (
+	MagicTop Magic = 1 << iota
+	MagicLiteral
+	MagicGlob
+	MagicIcase
+	MagicExclude
+	MagicAttr
+)
+
+var magicNames = map[string]Magic{
+	"top":     MagicTop,
+	"literal": MagicLiteral,
+	"glob":    MagicGlob,
+	"icase":   MagicIcase,
+	"exclude": MagicExclude,
+}
+
+// Flags that change the way pathspecs are parsed.
+const (
+	// PreferCwd makes an empty pathspec, or one that only
+	// excludes paths, match the current directory rather
+	// than the whole tree.
+	PreferCwd = 1 << iota
+
+	// AllLiteral turns off wildcards in every pathspec,
+	// as git-ls-tree does.
+	AllLiteral
+)
+
+// ================================================================= //
+// ITEMS
+// ================================================================= //
+
+type attrRequirement struct {
+	name  string
+	value api.AttrValue
+}
+
+// Item is a single parsed pathspec.
+type Item struct {
+	original string
+	match    string // relative to the top, with wildcards
+	magic    Magic
+	nowild   int // length of the leading part without wildcards
+	attrs    []attrRequirement
+}
+
+// Original returns the pathspec as it was given.
+func (it *Item) Original() string {
+	return it.original
+}
+
+// Match returns the pattern of the pathspec, relative to the
+// top of the working tree.
+func (it *Item) Match() string {
+	return it.match
+}
+
+// Magic returns the magic that applies to the pathspec.
+func (it *Item) Magic() Magic {
+	return it.magic
+}
+
+// HasWildcards returns true if the pathspec is not
+// matched literally.
+func (it *Item) HasWildcards() bool {
+	return it.nowild < len(it.match)
+}
+
+type matchKind int
+
+const (
+	noMatch matchKind = iota
+	matchedRecursively
+	matchedWildcard
+	matchedExactly
+)
+
+// matchPath matches a single path against the item, without
+// considering attributes.
+func (it *Item) matchPath(name string, isDir bool) matchKind {
+	m := it.match
+	if m == "" {
+		return matchedRecursively
+	}
+	if it.hasPrefix(name, m) {
+		if len(name) == len(m) {
+			return matchedExactly
+		}
+		if m[len(m)-1] == '/' || name[len(m)] == '/' {
+			return matchedRecursively
+		}
+	} else if isDir && m[len(m)-1] == '/' && len(name) == len(m)-1 && it.hasPrefix(m, name) {
+		return matchedExactly
+	}
+	if !it.HasWildcards() || !it.hasPrefix(name, m[:it.nowild]) {
+		return noMatch
+	}
+	flags := 0
+	if it.magic&MagicGlob != 0 {
+		flags |= util.WildPathname
+	}
+	if it.magic&MagicIcase != 0 {
+		flags |= util.WildCasefold
+	}
+	pattern, text := m[it.nowild:], name[it.nowild:]
+	if util.Wildmatch(pattern, text, flags) || (isDir && util.Wildmatch(pattern, text+"/", flags)) {
+		return matchedWildcard
+	}
+	return noMatch
+}
+
+// leads returns true if the item could match paths inside the
+// given directory that are not already matched by the directory.
+func (it *Item) leads(dir string) bool {
+	d := dir + "/"
+	lit := it.match[:it.nowild]
+	if it.hasPrefix(lit, d) {
+		return true
+	}
+	return it.HasWildcards() && it.hasPrefix(d, lit)
+}
+
+// hasPrefix compares the way the item wants it to.
+func (it *Item) hasPrefix(s, prefix string) bool {
+	if it.magic&MagicIcase != 0 {
+		return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(s, prefix)
+}
+
+// ================================================================= //
+// PATHSPEC
+// ================================================================= //
+
+// Pathspec is a list of pathspec items, which together decide
+// whether a path is included. A path is included if it matches
+// any item that is not an exclusion (or there are none of them),
+// and it matches none of the exclusions.
+type Pathspec struct {
+	items []*Item
+	attrs *api.Attributes
+}
+
+// Parse parses the pathspecs given on the command line of a
+// command that runs in the given prefix, which is the current
+// directory relative to the top of the working tree, with a
+// trailing slash.
+func Parse(prefix string, args []string, flags int) (*Pathspec, error) {
+	ps := new(Pathspec)
+	positive := false
+	for _, arg := range args {
+		it, err := parseItem(prefix, arg, flags)
+		if err != nil {
+			return nil, err
+		}
+		if it.magic&MagicExclude == 0 {
+			positive = true
+		}
+		ps.items = append(ps.items, it)
+	}
+	if !positive && flags&PreferCwd != 0 && prefix != "" {
+		it, _ := parseItem(prefix, "", flags|AllLiteral)
+		ps.items = append(ps.items, it)
+	}
+	return ps, nil
+}
+
+// MustParse is like Parse, but panics on bad input.
+func MustParse(prefix string, args []string, flags int) *Pathspec {
+	ps, err := Parse(prefix, args, flags)
+	if err != nil {
+		panic(err)
+	}
+	return ps
+}
+
+// Items returns the items of the pathspec.
+func (ps *Pathspec) Items() []*Item {
+	return ps.items
+}
+
+// Empty returns true if the pathspec has no items, and so
+// matches everything.
+func (ps *Pathspec) Empty() bool {
+	return len(ps.items) == 0
+}
+
+// Has returns true if any item has the given magic.
+func (ps *Pathspec) Has(magic Magic) bool {
+	for _, it := range ps.items {
+		if it.magic&magic != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAttributes sets the attributes that are used to match
+// items with attr magic. Without attributes, such items match
+// nothing.
+func (ps *Pathspec) SetAttributes(attrs *api.Attributes) {
+	ps.attrs = attrs
+}
+
+// Match returns true if the path, which is relative to the top
+// of the working tree, is included by the pathspec. Directories
+// ending with a slash in the pathspec only match if isDir is set.
+func (ps *Pathspec) Match(name string, isDir bool) bool {
+	positive, matched := false, false
+	for _, it := range ps.items {
+		if it.magic&MagicExclude != 0 {
+			continue
+		}
+		positive = true
+		if it.matchPath(name, isDir) != noMatch && ps.matchAttrs(it, name) {
+			matched = true
+			break
+		}
+	}
+	if positive && !matched {
+		return false
+	}
+	for _, it := range ps.items {
+		if it.magic&MagicExclude != 0 && it.matchPath(name, isDir) != noMatch && ps.matchAttrs(it, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Leads returns true if the directory may contain paths that
+// are included by the pathspec, even though the directory
+// itself is not. Whenever this returns false (and Match returns
+// false for the directory), the directory can be skipped.
+func (ps *Pathspec) Leads(dir string) bool {
+	positive, leads := false, false
+	for _, it := range ps.items {
+		if it.magic&MagicExclude != 0 {
+			continue
+		}
+		positive = true
+		if it.matchPath(dir, true) != noMatch || it.leads(dir) {
+			leads = true
+			break
+		}
+	}
+	if positive && !leads {
+		return false
+	}
+	// an exclusion that literally names the directory or one
+	// of its parents excludes everything inside of it
+	for _, it := range ps.items {
+		if it.magic&MagicExclude == 0 || len(it.attrs) > 0 {
+			continue
+		}
+		if k := it.matchPath(dir, true); k == matchedExactly || k == matchedRecursively {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTreeEntry returns true if the tree entry at the given
+// path is included by the pathspec.
+func (ps *Pathspec) MatchTreeEntry(pth string, e *objects.TreeEntry) bool {
+	return ps.Match(pth, e.ObjectType() == objects.ObjectTree)
+}
+
+// MatchIndexEntry returns true if the index entry is included
+// by the pathspec.
+func (ps *Pathspec) MatchIndexEntry(e *api.IndexEntry) bool {
+	return ps.Match(e.Name(), false)
+}
+
+// TreeVisitor wraps a tree visitor so that it is only called for
+// the entries that the pathspec includes, and subtrees that
+// cannot contain such entries are not walked at all.
+func (ps *Pathspec) TreeVisitor(f api.TreeVisitor) api.TreeVisitor {
+	return func(pth string, e *objects.TreeEntry) error {
+		isTree := e.ObjectType() == objects.ObjectTree
+		if ps.Match(pth, isTree) {
+			return f(pth, e)
+		}
+		if isTree && ps.Leads(pth) {
+			return nil
+		}
+		return api.SkipTree
+	}
+}
+
+// matchAttrs returns true if the path has all attributes
+// required by the item.
+func (ps *Pathspec) matchAttrs(it *Item, name string) bool {
+	if len(it.attrs) == 0 {
+		return true
+	}
+	if ps.attrs == nil {
+		return false
+	}
+	for _, req := range it.attrs {
+		if ps.attrs.Get(name, req.name) != req.value {
+			return false
+		}
+	}
+	return true
+}
+
+// ================================================================= //
+// PARSING
+// ================================================================= //
+
+// parseItem parses a single pathspec.
+func parseItem(prefix, arg string, flags int) (*Item, error) {
+	it := &Item{original: arg}
+	pattern := arg
+	if strings.HasPrefix(arg, ":(") {
+		end := strings.Index(arg, ")")
+		if end < 0 {
+			return nil, fmt.Errorf("Missing ')' at the end of pathspec magic in '%s'", arg)
+		}
+		for _, word := range strings.Split(arg[2:end], ",") {
+			if err := it.addMagic(word); err != nil {
+				return nil, fmt.Errorf("%s in '%s'", err, arg)
+			}
+		}
+		pattern = arg[end+1:]
+	} else if strings.HasPrefix(arg, ":") {
+		i := 1
+	short:
+		for ; i < len(arg); i++ {
+			switch arg[i] {
+			case '/':
+				it.magic |= MagicTop
+			case '!', '^':
+				it.magic |= MagicExclude
+			case ':':
+				i++
+				break short
+			default:
+				break short
+			}
+		}
+		pattern = arg[i:]
+	}
+
+	// the environment may change the default
+	switch {
+	case flags&AllLiteral != 0, envBool("GIT_LITERAL_PATHSPECS"):
+		it.magic = it.magic&^MagicGlob | MagicLiteral
+	case it.magic&MagicLiteral == 0 && envBool("GIT_GLOB_PATHSPECS"):
+		it.magic |= MagicGlob
+	case it.magic&MagicGlob == 0 && envBool("GIT_NOGLOB_PATHSPECS"):
+		it.magic |= MagicLiteral
+	}
+	if envBool("GIT_ICASE_PATHSPECS") {
+		it.magic |= MagicIcase
+	}
+	if it.magic&MagicLiteral != 0 && it.magic&MagicGlob != 0 {
+		return nil, fmt.Errorf("'literal' and 'glob' are incompatible in '%s'", arg)
+	}
+
+	if it.magic&MagicTop != 0 {
+		prefix = ""
+	}
+	match, err := normalize(prefix, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: '%s' is outside repository", arg, pattern)
+	}
+	it.match = match
+	it.nowild = len(match)
+	if it.magic&MagicLiteral == 0 {
+		if i := strings.IndexAny(match, "*?[\\"); i >= 0 {
+			it.nowild = i
+		}
+	}
+	return it, nil
+}
+
+// addMagic adds a single word of long magic to the item.
+func (it *Item) addMagic(word string) error {
+	word = strings.TrimSpace(word)
+	if strings.HasPrefix(word, "attr:") {
+		for _, field := range strings.Fields(word[len("attr:"):]) {
+			name, v := api.ParseAttrValue(field)
+			if name == "" {
+				return errors.New("invalid attribute name")
+			}
+			it.attrs = append(it.attrs, attrRequirement{name, v})
+		}
+		if len(it.attrs) == 0 {
+			return errors.New("attr spec must not be empty")
+		}
+		it.magic |= MagicAttr
+		return nil
+	}
+	if strings.HasPrefix(word, "prefix:") || word == "" {
+		return nil
+	}
+	magic, ok := magicNames[word]
+	if !ok {
+		return fmt.Errorf("Invalid pathspec magic '%s'", word)
+	}
+	it.magic |= magic
+	return nil
+}
+
+// normalize joins the pattern to the prefix and cleans the result,
+// which must not leave the working tree. A trailing slash is kept,
+// since it means that only directories should match, and so is the
+// one of the prefix when the pattern names its directory, as in "."
+// or "", or one of the directories above it, as in "e/..".
+func normalize(prefix, pattern string) (string, error) {
+	base := path.Base(pattern)
+	slash := strings.HasSuffix(pattern, "/") || pattern == "" || base == "." || base == ".."
+	p := path.Clean(prefix + pattern)
+	if p == ".." || strings.HasPrefix(p, "../") || strings.HasPrefix(p, "/") {
+		return "", errors.New("outside repository")
+	}
+	if p == "." {
+		return "", nil
+	}
+	if slash {
+		p += "/"
+	}
+	return p, nil
+}
+
+// envBool returns true if the environment variable is set
+// to a true value.
+func envBool(name string) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package pathspec
+
+import (
+	"github.com/jbrukh/ggit/util"
+	"testing"
+)
+
+func Test_parseItem(t *testing.T) {
+	it, err := parseItem("sub/", "../a/*.c", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, "a/*.c", it.Match())
+	util.AssertEqualInt(t, 2, it.nowild)
+
+	it, err = parseItem("sub/", ":/top", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, "top", it.Match())
+	util.Assert(t, it.Magic() == MagicTop)
+
+	it, err = parseItem("sub/", ":!dir/", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, "sub/dir/", it.Match())
+	util.Assert(t, it.Magic() == MagicExclude)
+
+	it, err = parseItem("", ":(top,literal,icase)a*b", 0)
+	util.AssertNoErr(t, err)
+	util.Assert(t, it.Magic() == MagicTop|MagicLiteral|MagicIcase)
+	util.Assert(t, !it.HasWildcards())
+
+	it, err = parseItem("", ":(attr:text -diff eol=lf)", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, 3, len(it.attrs))
+
+	_, err = parseItem("", ":(bogus)x", 0)
+	util.Assert(t, err != nil)
+	_, err = parseItem("", ":(glob,literal)x", 0)
+	util.Assert(t, err != nil)
+	_, err = parseItem("sub/", "../../x", 0)
+	util.Assert(t, err != nil)
+	_, err = parseItem("", ":(top", 0)
+	util.Assert(t, err != nil)
+}
+
+func Test_Match(t *testing.T) {
+	ps := MustParse("", []string{"dir", "*.c", ":(glob)src/*.h", ":(icase)README", ":(literal)lit*"}, 0)
+	util.Assert(t, ps.Match("dir", false))
+	util.Assert(t, ps.Match("dir/a/b", false))
+	util.Assert(t, !ps.Match("dirt", false))
+	util.Assert(t, ps.Match("x.c", false))
+	util.Assert(t, ps.Match("deep/x.c", false))
+	util.Assert(t, ps.Match("src/x.h", false))
+	util.Assert(t, !ps.Match("src/deep/x.h", false))
+	util.Assert(t, ps.Match("readme", false))
+	util.Assert(t, ps.Match("lit*", false))
+	util.Assert(t, !ps.Match("literal", false))
+
+	ps = MustParse("", []string{"dir/"}, 0)
+	util.Assert(t, ps.Match("dir", true))
+	util.Assert(t, !ps.Match("dir", false))
+	util.Assert(t, ps.Match("dir/file", false))
+
+	ps = MustParse("", nil, 0)
+	util.Assert(t, ps.Empty())
+	util.Assert(t, ps.Match("anything", false))
+}
+
+func Test_MatchExclude(t *testing.T) {
+	ps := MustParse("sub/", []string{":!vendor", ":^*.o"}, PreferCwd)
+	util.Assert(t, ps.Match("sub/a.c", false))
+	util.Assert(t, !ps.Match("other/a.c", false))
+	util.Assert(t, !ps.Match("sub/vendor/a.c", false))
+	util.Assert(t, !ps.Match("sub/a.o", false))
+
+	ps = MustParse("sub/", []string{":!vendor"}, 0)
+	util.Assert(t, ps.Match("other/a.c", false))
+	util.Assert(t, !ps.Match("sub/vendor/x", false))
+}
+
+// Test_ParseCwd checks that the pathspecs that name the current
+// directory, or no pathspecs at all, match its contents, as in git,
+// where commands like git-ls-tree list them from a subdirectory.
+func Test_ParseCwd(t *testing.T) {
+	for _, args := range [][]string{nil, {"."}, {"./"}, {"e/.."}, {":!x"}} {
+		ps := MustParse("sub/", args, PreferCwd)
+		it := ps.Items()[len(ps.Items())-1]
+		util.AssertEqualString(t, "sub/", it.Match())
+		util.Assert(t, ps.Match("sub", true))
+		util.Assert(t, ps.Match("sub/a", false))
+		util.Assert(t, !ps.Match("subway", false))
+		util.Assert(t, !ps.Match("other/a", false))
+	}
+	it, err := parseItem("sub/deep/", "..", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, "sub/", it.Match())
+	it, err = parseItem("sub/", "..", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, "", it.Match())
+	it, err = parseItem("sub/", "e", 0)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, "sub/e", it.Match())
+}
+
+func Test_Leads(t *testing.T) {
+	ps := MustParse("", []string{"a/b/c", ":!a/b/c/d"}, 0)
+	util.Assert(t, ps.Leads("a"))
+	util.Assert(t, ps.Leads("a/b"))
+	util.Assert(t, !ps.Leads("b"))
+	util.Assert(t, !ps.Leads("a/bc"))
+	util.Assert(t, ps.Leads("a/b/c"))
+	util.Assert(t, !ps.Leads("a/b/c/d"))
+
+	ps = MustParse("", []string{"src/*.c"}, 0)
+	util.Assert(t, ps.Leads("src"))
+	util.Assert(t, ps.Leads("src/deep"))
+	util.Assert(t, !ps.Leads("doc"))
+}
@@ -0,0 +1,548 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+ref_updates.go implements the updating of the refs of a disk repository,
+which are written as loose refs under their locks, and whose updates are
+appended to their reflogs.
+*/
+package api
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/format"
+	"github.com/jbrukh/ggit/api/objects"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ================================================================= //
+// HEAD
+// ================================================================= //
+
+// HeadRef is the name of the ref of the current branch.
+const HeadRef = "HEAD"
+
+// Head returns the name of the ref that HEAD points at, such as
+// "refs/heads/master", or "HEAD" itself if it is detached, along
+// with the oid of the commit of HEAD, which is nil if the branch
+// is yet to be born.
+func Head(repo *DiskRepository) (string, *objects.ObjectId, error) {
+	r, err := repo.Ref(HeadRef)
+	if err != nil {
+		return "", nil, err
+	}
+	symbolic, target := r.Target()
+	if !symbolic {
+		return HeadRef, r.ObjectId(), nil
+	}
+	name := target.(string)
+	oid, err := refOid(repo, name)
+	return name, oid, err
+}
+
+// refOid returns the oid that a ref points at, or nil if the ref
+// doesn't exist.
+func refOid(repo *DiskRepository, name string) (*objects.ObjectId, error) {
+	r, err := repo.Ref(name)
+	if IsNoSuchRef(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if r, err = PeelRef(repo, r); err != nil {
+		return nil, nil
+	}
+	return r.ObjectId(), nil
+}
+
+// SetHead points HEAD at a branch, given the full name of its ref,
+// or detaches it at an oid if the branch is empty, and logs the
+// move with the given identity and message.
+func SetHead(repo *DiskRepository, branch string, oid *objects.ObjectId, who *objects.WhoWhen, msg string) error {
+	_, old, err := Head(repo)
+	if err != nil {
+		return err
+	}
+	lock, err := Lock(path.Join(repo.path, HeadRef))
+	if err != nil {
+		return err
+	}
+	if branch != "" {
+		_, err = fmt.Fprintf(lock, "ref: %s\n", branch)
+	} else {
+		_, err = fmt.Fprintln(lock, oid)
+	}
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	if err = lock.Commit(); err != nil {
+		return err
+	}
+	if branch != "" {
+		if oid, err = refOid(repo, branch); err != nil || oid == nil {
+			// an unborn branch has nothing to log
+			return err
+		}
+	}
+	return appendReflog(repo, HeadRef, old, oid, who, msg)
+}
+
+// ================================================================= //
+// REF UPDATES
+// ================================================================= //
+
+// UpdateRef points a ref at an oid, and logs the update with the
+// given identity and message. A symbolic ref, such as HEAD, has
+// its target updated instead, and both of them are logged, as is
+// HEAD whenever the branch that it points at is updated. If old is
+// not nil, the ref must point at it before the update.
+func UpdateRef(repo *DiskRepository, name string, oid, old *objects.ObjectId, who *objects.WhoWhen, msg string) error {
+	target := name
+	if r, err := repo.Ref(name); err == nil {
+		if symbolic, spec := r.Target(); symbolic {
+			target = spec.(string)
+		}
+	}
+	if err := refConflict(repo, target, ""); err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %s", name, err)
+	}
+
+	pth := path.Join(repo.path, target)
+	if err := os.MkdirAll(path.Dir(pth), 0777); err != nil {
+		return err
+	}
+	lock, err := Lock(pth)
+	if err != nil {
+		return err
+	}
+	current, err := refOid(repo, target)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	if old != nil && (current == nil || current.String() != old.String()) {
+		lock.Rollback()
+		if current == nil {
+			return fmt.Errorf("cannot lock ref '%s': unable to resolve reference '%s'", name, target)
+		}
+		return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", name, current, old)
+	}
+
+	// a ref that points at the oid already is neither written nor
+	// logged, but HEAD logs the update all the same, as git does
+	var logged []string
+	if current != nil && current.String() == oid.String() {
+		lock.Rollback()
+	} else {
+		if _, err = fmt.Fprintln(lock, oid); err != nil {
+			lock.Rollback()
+			return err
+		}
+		if err = lock.Commit(); err != nil {
+			return err
+		}
+		logged = append(logged, target)
+	}
+	if target != HeadRef {
+		if head, err := repo.Ref(HeadRef); err == nil {
+			if symbolic, spec := head.Target(); symbolic && spec.(string) == target {
+				logged = append(logged, HeadRef)
+			}
+		}
+	}
+	for _, ref := range logged {
+		if err = appendReflog(repo, ref, current, oid, who, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRef deletes a ref, both its loose file and its packed
+// entry, along with its log. If old is not nil, the ref must point
+// at it before the deletion.
+func DeleteRef(repo *DiskRepository, name string, old *objects.ObjectId) error {
+	pth := path.Join(repo.path, name)
+	if err := os.MkdirAll(path.Dir(pth), 0777); err != nil {
+		return err
+	}
+	lock, err := Lock(pth)
+	if err != nil {
+		return err
+	}
+	err = deleteLockedRef(repo, name, old)
+	lock.Rollback()
+	if err != nil {
+		return err
+	}
+	removeEmptyDirs(repo.path, path.Dir(name))
+	removeEmptyDirs(repo.path, path.Join(LogsDir, path.Dir(name)))
+	return nil
+}
+
+// deleteLockedRef deletes a ref whose lock is held.
+func deleteLockedRef(repo *DiskRepository, name string, old *objects.ObjectId) error {
+	current, err := refOid(repo, name)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("unable to resolve reference '%s'", name)
+	}
+	if old != nil && current.String() != old.String() {
+		return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", name, current, old)
+	}
+	if err = removePackedRef(repo, name); err != nil {
+		return err
+	}
+	pth := path.Join(repo.path, name)
+	if err = os.Remove(pth); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Remove(path.Join(repo.path, LogsDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RenameRef renames a ref, which takes its log along, and logs the
+// rename with the given identity and message. A ref that has the
+// new name already is replaced. HEAD follows the ref if it points
+// at it, and logs the rename as a deletion and a creation, as git
+// does. Nothing is renamed if the new name conflicts with another
+// ref, and the old ref and its log are put back if the new ref
+// can't be written.
+func RenameRef(repo *DiskRepository, old, new string, who *objects.WhoWhen, msg string) error {
+	oid, err := refOid(repo, old)
+	if err != nil {
+		return err
+	}
+	if oid == nil {
+		return fmt.Errorf("refname %s not found", old)
+	}
+	if err = refConflict(repo, new, old); err != nil {
+		return err
+	}
+	headRef, _, err := Head(repo)
+	if err != nil {
+		return err
+	}
+
+	// the log is put aside while the old ref is deleted
+	oldLog, newLog := path.Join(repo.path, LogsDir, old), path.Join(repo.path, LogsDir, new)
+	tmpLog := path.Join(repo.path, LogsDir, "refs_rename_tmp")
+	logged := true
+	if err = os.Rename(oldLog, tmpLog); os.IsNotExist(err) {
+		logged = false
+	} else if err != nil {
+		return err
+	}
+	movedLog := tmpLog
+	rollback := func(err error) error {
+		if logged {
+			os.MkdirAll(path.Dir(oldLog), 0777)
+			os.Rename(movedLog, oldLog)
+		}
+		if current, e := refOid(repo, old); e == nil && current == nil {
+			writeRef(repo, old, oid)
+		}
+		return err
+	}
+	if err = DeleteRef(repo, old, oid); err != nil {
+		return rollback(err)
+	}
+	if new != old {
+		if replaced, err := refOid(repo, new); err != nil {
+			return rollback(err)
+		} else if replaced != nil {
+			if err = DeleteRef(repo, new, nil); err != nil {
+				return rollback(err)
+			}
+		}
+	}
+	if logged {
+		if err = os.MkdirAll(path.Dir(newLog), 0777); err != nil {
+			return rollback(err)
+		}
+		if err = os.Rename(tmpLog, newLog); err != nil {
+			return rollback(err)
+		}
+		movedLog = newLog
+	}
+	if err = writeRef(repo, new, oid); err != nil {
+		return rollback(err)
+	}
+
+	if err = appendReflog(repo, new, oid, oid, who, msg); err != nil {
+		return err
+	}
+	if headRef != old {
+		return nil
+	}
+	if err = appendReflog(repo, HeadRef, oid, nil, who, msg); err != nil {
+		return err
+	}
+	// a ref that is renamed to itself is recreated over itself
+	var created *objects.ObjectId
+	if new == old {
+		created = oid
+	}
+	if err = appendReflog(repo, HeadRef, created, oid, who, msg); err != nil {
+		return err
+	}
+	lock, err := Lock(path.Join(repo.path, HeadRef))
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(lock, "ref: %s\n", new); err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// writeRef writes a loose ref that points at an oid, without
+// logging it.
+func writeRef(repo *DiskRepository, name string, oid *objects.ObjectId) error {
+	pth := path.Join(repo.path, name)
+	if err := os.MkdirAll(path.Dir(pth), 0777); err != nil {
+		return err
+	}
+	lock, err := Lock(pth)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(lock, oid); err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// CheckNewRef returns an error if a ref can't be created because
+// another ref is in the way: one that has the name of one of its
+// directories, or one that is under it, as if it were a directory.
+func CheckNewRef(repo *DiskRepository, name string) error {
+	if err := refConflict(repo, name, ""); err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %s", name, err)
+	}
+	return nil
+}
+
+// refConflict returns an error if another ref is in the way of a
+// ref, as CheckNewRef says. The ref that is being renamed to it, if
+// any, is not in the way, since it goes away.
+func refConflict(repo *DiskRepository, name, renamed string) error {
+	conflict := func(existing string) error {
+		return fmt.Errorf("'%s' exists; cannot create '%s'", existing, name)
+	}
+	for dir := path.Dir(name); dir != "." && dir != "refs"; dir = path.Dir(dir) {
+		if dir == renamed {
+			continue
+		}
+		if oid, err := refOid(repo, dir); err != nil {
+			return err
+		} else if oid != nil {
+			return conflict(dir)
+		}
+	}
+
+	// the first of the refs under it, whether loose or packed
+	var first string
+	prefix := name + "/"
+	dir := path.Join(repo.path, name)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || first != "" {
+				return nil
+			}
+			if ref := name + strings.TrimPrefix(filepath.ToSlash(pth), dir); ref != renamed {
+				first = ref
+			}
+			return nil
+		})
+	}
+	refs, err := repo.PackedRefs()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, r := range refs {
+		if n := r.Name(); strings.HasPrefix(n, prefix) && n != renamed && (first == "" || n < first) {
+			first = n
+		}
+	}
+	if first != "" {
+		return conflict(first)
+	}
+	return nil
+}
+
+// removePackedRef removes a ref from the packed refs, along with
+// the peeled oid that may follow it.
+func removePackedRef(repo *DiskRepository, name string) error {
+	pth := path.Join(repo.path, PackedRefsFile)
+	data, err := ioutil.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	var kept []string
+	found := false
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		if len(fields) == 2 && fields[1] == name {
+			found = true
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "^") {
+				i++
+			}
+			continue
+		}
+		kept = append(kept, lines[i])
+	}
+	if !found {
+		return nil
+	}
+	lock, err := Lock(pth)
+	if err != nil {
+		return err
+	}
+	if _, err = lock.Write([]byte(strings.Join(kept, ""))); err != nil {
+		lock.Rollback()
+		return err
+	}
+	repo.packedRefs = nil
+	return lock.Commit()
+}
+
+// removeEmptyDirs removes a directory, given relative to the git
+// directory, and the ones that lead to it, for as long as they are
+// empty, stopping short of the top of the refs and of the logs.
+func removeEmptyDirs(gitDir, dir string) {
+	for ; dir != "." && dir != "refs" && dir != LogsDir && dir != path.Join(LogsDir, "refs"); dir = path.Dir(dir) {
+		if os.Remove(path.Join(gitDir, dir)) != nil {
+			return
+		}
+	}
+}
+
+// ================================================================= //
+// REFLOGS
+// ================================================================= //
+
+// appendReflog appends an entry to the log of a ref. Logs that
+// don't exist yet are only created for HEAD and for branches,
+// remote-tracking branches and notes, unless the configuration
+// says otherwise, as in git.
+func appendReflog(repo *DiskRepository, name string, old, oid *objects.ObjectId, who *objects.WhoWhen, msg string) error {
+	pth := path.Join(repo.path, LogsDir, name)
+	if _, err := os.Stat(pth); os.IsNotExist(err) {
+		create, err := autoCreateReflog(repo, name)
+		if err != nil || !create {
+			return err
+		}
+	}
+	if err := os.MkdirAll(path.Dir(pth), 0777); err != nil {
+		return err
+	}
+
+	f := format.NewStrFormat()
+	oldHex := nullOid
+	if old != nil {
+		oldHex = old.String()
+	}
+	newHex := nullOid
+	if oid != nil {
+		newHex = oid.String()
+	}
+	f.Printf("%s %s ", oldHex, newHex)
+	f.WhoWhen(who)
+	if msg = reflogMessage(msg); msg != "" {
+		f.Printf("\t%s", msg)
+	}
+	f.Lf()
+
+	file, err := os.OpenFile(pth, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(f.String()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// autoCreateReflog returns true if a new log should be created
+// for the ref, according to core.logAllRefUpdates, which is on by
+// default for repositories that have a working tree.
+func autoCreateReflog(repo *DiskRepository, name string) (bool, error) {
+	config, err := ReadConfig(repo)
+	if err != nil {
+		return false, err
+	}
+	bare, err := config.Bool("core.bare", false)
+	if err != nil {
+		return false, err
+	}
+	if v, _ := config.Get("core.logAllRefUpdates"); strings.ToLower(v) == "always" {
+		return true, nil
+	}
+	all, err := config.Bool("core.logAllRefUpdates", !bare)
+	if err != nil || !all {
+		return false, err
+	}
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/", "refs/notes/"} {
+		if strings.HasPrefix(name, prefix) {
+			return true, nil
+		}
+	}
+	return name == HeadRef, nil
+}
+
+// reflogMessage cleans up the message of a reflog entry, which
+// must be a single line, by collapsing the runs of whitespace
+// in it.
+func reflogMessage(msg string) string {
+	return strings.Join(strings.Fields(msg), " ")
+}
+
+// ================================================================= //
+// REF NAMES
+// ================================================================= //
+
+// ValidRefName returns true if a name is a valid name of a ref, by
+// the rules of git-check-ref-format: none of its components may
+// start with a dot or end with ".lock", and it may not contain "..",
+// "@{", "//", control characters, spaces or any of ~^:?*[\, nor end
+// with a slash or a dot, nor be "@".
+func ValidRefName(name string) bool {
+	if name == "" || name == "@" || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "@{") || strings.ContainsAny(name, " ~^:?*[\\\x7f") {
+		return false
+	}
+	for _, c := range name {
+		if c < ' ' {
+			return false
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+ref_updates_git_test.go implements git-comparison tests for the updating,
+the deletion and the renaming of refs and their reflogs, and for the
+staging of working tree files.
+*/
+package api
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test_updateRef checks that refs are updated through HEAD, and that
+// the updates are logged as git logs them.
+func Test_updateRef(t *testing.T) {
+	// an unborn branch has no oid
+	name, oid, err := Head(Open(test.Empty.Repo()))
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, "refs/heads/master", name)
+	util.Assert(t, oid == nil, "expected an unborn branch")
+
+	dir, err := test.RefUpdates.Clone("__update_ref")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+	first := objects.OidNow(util.RevOid(dir, "HEAD~"))
+	second := objects.OidNow(util.RevOid(dir, "HEAD"))
+	_, oid, err = Head(repo)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, second.String(), oid.String())
+
+	who := objects.NewWhoWhen("A U Thor", "author@example.com", 1300000000, 90)
+	err = UpdateRef(repo, HeadRef, first, first, who, "moving")
+	util.Assert(t, err != nil, "expected the old value to be checked")
+	util.AssertNoErr(t, UpdateRef(repo, HeadRef, first, second, who, "reset:  moving\nback"))
+	util.AssertEqualString(t, first.String(), strings.TrimSpace(util.GitNow(dir, "rev-parse", "master")))
+
+	// both HEAD and the branch log the update
+	expected := second.String() + " " + first.String() + " A U Thor <author@example.com> 1300000000 +0130\treset: moving back"
+	for _, ref := range []string{HeadRef, "refs/heads/master"} {
+		data, err := ioutil.ReadFile(path.Join(dir, ".git", LogsDir, ref))
+		util.AssertNoErrOrDie(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		util.AssertEqualString(t, expected, lines[len(lines)-1])
+	}
+	util.AssertEqualString(t, "reset: moving back", strings.TrimSpace(util.GitNow(dir, "log", "-g", "-1", "--format=%gs", "master")))
+
+	// new branches are logged, but tags aren't
+	util.AssertNoErr(t, UpdateRef(repo, "refs/heads/topic/x", second, nil, who, "branch: Created"))
+	util.AssertNoErr(t, UpdateRef(repo, "refs/tags/v2", second, nil, who, "tag"))
+	util.AssertEqualString(t, second.String(), strings.TrimSpace(util.GitNow(dir, "rev-parse", "topic/x")))
+	util.AssertEqualString(t, second.String(), strings.TrimSpace(util.GitNow(dir, "rev-parse", "v2")))
+	_, err = os.Stat(path.Join(dir, ".git", LogsDir, "refs/heads/topic/x"))
+	util.AssertNoErr(t, err)
+	_, err = os.Stat(path.Join(dir, ".git", LogsDir, "refs/tags/v2"))
+	util.Assert(t, os.IsNotExist(err), "expected no log for a tag")
+}
+
+// Test_deleteRef checks that loose and packed refs are deleted, along
+// with their logs and the directories that they leave empty.
+func Test_deleteRef(t *testing.T) {
+	dir, err := test.RefUpdates.Clone("__delete_ref")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+	oid := objects.OidNow(util.RevOid(dir, "HEAD"))
+
+	util.Assert(t, DeleteRef(repo, "refs/heads/topic/loose", objects.OidNow(nullOid)) != nil, "expected the old value to be checked")
+	for _, name := range []string{"refs/heads/topic/loose", "refs/heads/topic/packed", "refs/tags/v1"} {
+		util.AssertNoErr(t, DeleteRef(repo, name, nil))
+	}
+	util.AssertEqualString(t, "refs/heads/a\nrefs/heads/b/c\nrefs/heads/master\nrefs/heads/p/c\n", util.GitNow(dir, "for-each-ref", "--format=%(refname)"))
+	for _, name := range []string{"refs/heads/topic", path.Join(LogsDir, "refs/heads/topic")} {
+		_, err = os.Stat(path.Join(dir, ".git", name))
+		util.Assertf(t, os.IsNotExist(err), "expected %s to be removed", name)
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, ".git", PackedRefsFile))
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, !strings.Contains(string(data), "^"), "expected the peeled tag to be removed")
+	_, oid2, err := Head(repo)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, oid.String(), oid2.String())
+}
+
+// Test_renameRef compares the renaming of the current branch with
+// the one of git-branch -m.
+func Test_renameRef(t *testing.T) {
+	gitDir, err := test.RefUpdates.Clone("__rename_ref_git")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(gitDir)
+	dir, err := test.RefUpdates.Clone("__rename_ref")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	who := objects.NewWhoWhen("A U Thor", "author@example.com", 1300000000, 90)
+	cmd := exec.Command("git", "branch", "-m", "master", "renamed/branch")
+	cmd.Dir = gitDir
+	cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME="+who.Name(), "GIT_COMMITTER_EMAIL="+who.Email(),
+		"GIT_COMMITTER_DATE=1300000000 +0130")
+	util.AssertNoErrOrDie(t, cmd.Run())
+	repo := Open(dir)
+	util.AssertNoErrOrDie(t, RenameRef(repo, "refs/heads/master", "refs/heads/renamed/branch", who,
+		"Branch: renamed refs/heads/master to refs/heads/renamed/branch"))
+
+	for _, args := range [][]string{
+		{"symbolic-ref", "HEAD"},
+		{"for-each-ref", "--format=%(refname) %(objectname)"},
+		{"log", "-g", "--format=%H %gs", "HEAD"},
+		{"log", "-g", "--format=%H %gs", "renamed/branch"},
+	} {
+		util.AssertEqualString(t, util.GitNow(gitDir, args...), util.GitNow(dir, args...))
+	}
+	for _, name := range []string{HeadRef, "refs/heads/renamed/branch"} {
+		expected, err := ioutil.ReadFile(path.Join(gitDir, ".git", LogsDir, name))
+		util.AssertNoErrOrDie(t, err)
+		actual, err := ioutil.ReadFile(path.Join(dir, ".git", LogsDir, name))
+		util.AssertNoErrOrDie(t, err)
+		util.AssertEqualString(t, string(expected), string(actual))
+	}
+}
+
+// Test_refConflict checks that refs that would be directories of
+// other refs, or have them as directories, are neither created nor
+// renamed to, and that a failed rename leaves the ref and its log as
+// they were, while a ref is renamed to one of its own directories.
+func Test_refConflict(t *testing.T) {
+	dir, err := test.RefUpdates.Clone("__ref_conflict")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+	oid := objects.OidNow(util.RevOid(dir, "HEAD"))
+	who := objects.NewWhoWhen("A U Thor", "author@example.com", 1300000000, 90)
+	refs := util.GitNow(dir, "for-each-ref", "--format=%(refname)")
+
+	for _, c := range []struct{ name, existing string }{
+		{"refs/heads/a/x", "refs/heads/a"},
+		{"refs/heads/b", "refs/heads/b/c"},
+		{"refs/heads/p", "refs/heads/p/c"},
+	} {
+		expected := fmt.Sprintf("cannot lock ref '%s': '%s' exists; cannot create '%s'", c.name, c.existing, c.name)
+		err = CheckNewRef(repo, c.name)
+		util.Assert(t, err != nil && err.Error() == expected, err)
+		err = UpdateRef(repo, c.name, oid, nil, who, "branch: Created from master")
+		util.Assert(t, err != nil && err.Error() == expected, err)
+	}
+	util.AssertNoErr(t, CheckNewRef(repo, "refs/tags/a/x"))
+
+	err = RenameRef(repo, "refs/heads/master", "refs/heads/a/x", who, "Branch: renamed")
+	util.Assert(t, err != nil && err.Error() == "'refs/heads/a' exists; cannot create 'refs/heads/a/x'", err)
+	util.AssertEqualString(t, refs, util.GitNow(dir, "for-each-ref", "--format=%(refname)"))
+	util.AssertEqualString(t, "refs/heads/master\n", util.GitNow(dir, "symbolic-ref", "HEAD"))
+	_, err = os.Stat(path.Join(dir, ".git", LogsDir, "refs/heads/master"))
+	util.AssertNoErr(t, err)
+	_, err = os.Stat(path.Join(dir, ".git", LogsDir, "refs_rename_tmp"))
+	util.Assert(t, os.IsNotExist(err), "expected no log to be put aside")
+
+	util.AssertNoErr(t, RenameRef(repo, "refs/heads/a", "refs/heads/a/q", who, "Branch: renamed"))
+	util.AssertEqualString(t, oid.String()+"\n", util.GitNow(dir, "rev-parse", "a/q"))
+	util.AssertEqualString(t, "Branch: renamed\nbranch: Created from master\n", util.GitNow(dir, "log", "-g", "--format=%gs", "a/q"))
+}
+
+// Test_stageFile compares the index entries that stage files of the
+// working tree with the ones of git-add.
+func Test_stageFile(t *testing.T) {
+	dir, err := test.Stage.Clone("__stage_file")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+	wt := NewWorkTree(repo)
+
+	expected := util.GitNow(dir, "ls-files", "-s")
+	e, err := wt.StageFile("a/b")
+	util.AssertNoErrOrDie(t, err)
+	i, err := wt.IntentToAddEntry("c")
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, i.ExtendedFlags().IntentToAdd(), "expected an intent to add")
+	var actual string
+	for _, entry := range []*IndexEntry{e, i} {
+		actual += fmt.Sprintf("%06o %s 0\t%s\n", entry.Mode(), entry.ObjectId(), entry.Name())
+	}
+	util.AssertEqualString(t, expected, actual)
+
+	status, err := wt.Status(e)
+	util.AssertNoErr(t, err)
+	util.Assert(t, status == WorkUnmodified, "expected the staged file to be unmodified")
+}
@@ -15,6 +15,7 @@ const (
 	DefaultPackDir    = "pack"
 	IndexFile         = "index"
 	PackedRefsFile    = "packed-refs"
+	LogsDir           = "logs"
 )
 
 // Repository. Currently, this interface is tracking
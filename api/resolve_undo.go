@@ -0,0 +1,86 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+resolve_undo.go implements the recording of resolved merge conflicts in
+the index, as git does. When an entry at stage 0 replaces the stages of
+a conflict, or a conflicted path is removed, its stages are kept in the
+REUC extention of the index, so that the conflict can be recreated. The
+records stay until a tree is checked out over the index.
+*/
+package api
+
+import (
+	"sort"
+)
+
+// ================================================================= //
+// RESOLVED CONFLICTS
+// ================================================================= //
+
+// ResolveUndo returns the resolved conflicts of the index, or nil if
+// the index has none.
+func (inx *Index) ResolveUndo() *ResolveUndoIndexExtention {
+	for _, ext := range inx.extentions {
+		if undo, ok := (*ext).(*ResolveUndoIndexExtention); ok {
+			return undo
+		}
+	}
+	return nil
+}
+
+// ClearResolveUndo forgets about the conflicts that were resolved,
+// as checking out a tree over the index does.
+func (inx *Index) ClearResolveUndo() {
+	if undo := inx.ResolveUndo(); undo != nil {
+		undo.entries = nil
+	}
+}
+
+// Entries returns the resolved conflicts, ordered by path.
+func (ext *ResolveUndoIndexExtention) Entries() []*ResolveUndoEntry {
+	return ext.entries
+}
+
+// Entry returns the resolved conflict of a path, or nil if the path
+// has none.
+func (ext *ResolveUndoIndexExtention) Entry(pth string) *ResolveUndoEntry {
+	i := sort.Search(len(ext.entries), func(i int) bool {
+		return ext.entries[i].Path >= pth
+	})
+	if i < len(ext.entries) && ext.entries[i].Path == pth {
+		return ext.entries[i]
+	}
+	return nil
+}
+
+// recordResolveUndo records an entry of a conflict that is removed
+// from the index. Entries at stage 0 are not recorded.
+func (inx *Index) recordResolveUndo(e *IndexEntry) {
+	stage := e.Stage()
+	if stage == 0 {
+		return
+	}
+	undo := inx.ResolveUndo()
+	if undo == nil {
+		undo = new(ResolveUndoIndexExtention)
+		var ext IndexExtention = undo
+		inx.extentions = append(inx.extentions, &ext)
+	}
+	entry := undo.Entry(e.name)
+	if entry == nil {
+		entry = &ResolveUndoEntry{Path: e.name}
+		i := sort.Search(len(undo.entries), func(i int) bool {
+			return undo.entries[i].Path > e.name
+		})
+		undo.entries = append(undo.entries, nil)
+		copy(undo.entries[i+1:], undo.entries[i:])
+		undo.entries[i] = entry
+	}
+	entry.Modes[stage-1], entry.Oids[stage-1] = e.Mode(), e.ObjectId()
+}
@@ -0,0 +1,82 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+resolve_undo_git_test.go implements git-comparison tests for the resolved
+conflicts that are kept in the index.
+*/
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// formatResolveUndo formats the resolved conflicts of an index as
+// git-ls-files --resolve-undo does.
+func formatResolveUndo(idx *Index) string {
+	buf := new(bytes.Buffer)
+	if undo := idx.ResolveUndo(); undo != nil {
+		for _, e := range undo.Entries() {
+			for i, mode := range e.Modes {
+				if mode != 0 {
+					fmt.Fprintf(buf, "%.6o %s %d\t%s\n", mode, e.Oids[i], i+1, e.Path)
+				}
+			}
+		}
+	}
+	return buf.String()
+}
+
+// Test_resolveUndo checks that the conflicts that we resolve are
+// recorded as git records them, and that the records that git
+// writes are parsed and written back as git wrote them.
+func Test_resolveUndo(t *testing.T) {
+	dir, err := test.Conflict.Clone("__resolve_undo")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+
+	idx, err := repo.Index()
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, idx.ResolveUndo() == nil, "expected no resolved conflicts")
+	ours := idx.Entry("f", 2)
+	idx.Add(NewIndexEntry("f", 0, ours.ObjectId(), ours.Mode(), nil))
+	idx.Remove("g")
+	ours = idx.Entry("h", 2)
+	idx.Add(NewIndexEntry("h", 0, ours.ObjectId(), ours.Mode(), nil))
+
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+		[]string{"checkout", "--ours", "f", "h"},
+		[]string{"add", "f", "h"},
+		[]string{"rm", "-q", "--cached", "g"},
+	))
+	expected := util.GitNow(dir, "ls-files", "--resolve-undo")
+	util.Assert(t, expected != "", "expected git to record resolved conflicts")
+	util.AssertEqualString(t, expected, formatResolveUndo(idx))
+	util.Assert(t, idx.ResolveUndo().Entry("same") == nil)
+
+	idx, err = repo.Index()
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualString(t, expected, formatResolveUndo(idx))
+	data, err := ioutil.ReadFile(path.Join(dir, ".git", IndexFile))
+	util.AssertNoErrOrDie(t, err)
+	buf := new(bytes.Buffer)
+	_, err = idx.WriteTo(buf)
+	util.AssertNoErr(t, err)
+	util.Assert(t, bytes.Equal(data, buf.Bytes()), "expected the index to be written as git wrote it")
+
+	idx.ClearResolveUndo()
+	util.AssertEqualString(t, "", formatResolveUndo(idx))
+}
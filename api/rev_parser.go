@@ -9,11 +9,15 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"github.com/jbrukh/ggit/api/objects"
-	"github.com/jbrukh/ggit/api/token"
 	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 // ================================================================= //
@@ -45,15 +49,19 @@ type revParser struct {
 	rev string
 
 	o objects.Object
+
+	// quiet keeps the parser from printing its debugging output
+	quiet bool
 }
 
-func newRevParser(repo Repository, rev string) *revParser {
+func newRevParser(repo Repository, rev string, quiet bool) *revParser {
 	return &revParser{
 		*util.NewDataParser(util.ReaderForString(rev)),
 		repo,
 		0,
 		rev,
 		nil,
+		quiet,
 	}
 }
 
@@ -90,13 +98,22 @@ func (p *revParser) number() (n int) {
 }
 
 func (p *revParser) Parse() error {
-	e := util.SafeParse(func() {
+	parse := util.SafeParse
+	if p.quiet {
+		parse = util.QuietParse
+	}
+	e := parse(func() {
 		if p.rev == "" {
 			util.PanicErr("revision spec is empty")
 		}
 
 		if p.PeekByte() == ':' {
-			util.PanicErr(": syntaxes not supported") // TODO
+			p.parseColon()
+			return
+		}
+		if i := pathColon(p.rev); i >= 0 {
+			p.parseTreePath(i)
+			return
 		}
 
 		start := p.Count()
@@ -126,12 +143,7 @@ func (p *revParser) Parse() error {
 			if b == '^' {
 				if !p.EOF() && p.PeekByte() == '{' {
 					p.ConsumeByte('{')
-					otype := objects.ObjectType(p.ConsumeStrings(token.ObjectTypes))
-					err = applyDereference(p, otype)
-					if err != nil {
-
-					}
-					p.ConsumeByte('}')
+					err = applyDereference(p, p.braced())
 				} else {
 					err = applyParentFunc(p, CommitNthParent)
 				}
@@ -149,6 +161,102 @@ func (p *revParser) Parse() error {
 	return e
 }
 
+// parseColon handles the revisions that start with a colon:
+// ":/regex" names the youngest commit reachable from any ref
+// whose message matches, while ":path" and ":n:path" name the
+// blob that is staged in the index at stage 0 or n.
+func (p *revParser) parseColon() {
+	rest := p.rev[1:]
+	if strings.HasPrefix(rest, "/") {
+		starts, err := refCommits(p.repo)
+		if err != nil {
+			util.PanicErr(err.Error())
+		}
+		c, err := commitFromMessage(p.repo, starts, rest[1:])
+		if err != nil {
+			util.PanicErr(err.Error())
+		}
+		p.o = c
+		return
+	}
+
+	stage := 0
+	if len(rest) >= 2 && '0' <= rest[0] && rest[0] <= '3' && rest[1] == ':' {
+		stage, rest = int(rest[0]-'0'), rest[2:]
+	}
+	rest = p.relativePath(rest)
+	idx, err := p.repo.Index()
+	if err != nil {
+		util.PanicErr(err.Error())
+	}
+	entry := idx.Entry(rest, stage)
+	if entry == nil {
+		if stage == 0 {
+			for n := 1; n <= 3; n++ {
+				if idx.Entry(rest, n) != nil {
+					util.PanicErrf("path '%s' is in the index, but not at stage 0", rest)
+				}
+			}
+		}
+		util.PanicErrf("path '%s' does not exist in the index at stage %d", rest, stage)
+	}
+	if p.o, err = p.repo.ObjectFromOid(entry.ObjectId()); err != nil {
+		util.PanicErr(err.Error())
+	}
+}
+
+// parseTreePath handles revisions of the form "rev:path", which
+// name the object at the path in the tree of rev. The colon is
+// found at index i.
+func (p *revParser) parseTreePath(i int) {
+	rev, pth := p.rev[:i], p.rev[i+1:]
+	o, err := objectFromRevision(p.repo, rev, p.quiet)
+	if err != nil {
+		util.PanicErr(err.Error())
+	}
+	tree, err := TreeFromObject(p.repo, o)
+	if err != nil {
+		util.PanicErr(err.Error())
+	}
+	if pth = p.relativePath(pth); pth == "" {
+		p.o = tree
+		return
+	}
+	e, err := TreeEntryFromPath(p.repo, tree, pth)
+	if err != nil {
+		util.PanicErrf("path '%s' does not exist in '%s'", pth, rev)
+	}
+	if p.o, err = p.repo.ObjectFromOid(e.ObjectId()); err != nil {
+		util.PanicErr(err.Error())
+	}
+}
+
+// relativePath turns a path after a colon that starts with "./" or
+// "../", which is relative to the current directory, into a path from
+// the top of the working tree. Other paths are already from the top.
+func (p *revParser) relativePath(pth string) string {
+	if !strings.HasPrefix(pth, "./") && !strings.HasPrefix(pth, "../") {
+		return pth
+	}
+	var workDir, prefix string
+	if repo, ok := p.repo.(*DiskRepository); ok {
+		workDir = repo.WorkDir()
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(workDir, cwd); err == nil && !strings.HasPrefix(rel, "..") {
+				prefix = filepath.ToSlash(rel)
+			}
+		}
+	}
+	rel := path.Join(prefix, pth)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		util.PanicErrf("'%s' is outside repository at '%s'", pth, workDir)
+	}
+	if rel == "." {
+		return ""
+	}
+	return rel
+}
+
 func applyParentFunc(p *revParser, f parentFunc) (err error) {
 	n := p.number()
 	var c, parent *objects.Commit
@@ -164,33 +272,62 @@ func applyParentFunc(p *revParser, f parentFunc) (err error) {
 	return
 }
 
-func applyDereference(p *revParser, otype objects.ObjectType) error {
-	switch otype {
-	case objects.ObjectCommit:
-		c, err := CommitFromObject(p.repo, p.o)
-		if err != nil {
-			return err
+// braced reads the text up to the closing brace, which is
+// consumed, allowing for nested braces.
+func (p *revParser) braced() string {
+	start, depth := p.Count(), 0
+	for !p.EOF() {
+		switch p.ReadByte() {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return p.rev[start : p.Count()-1]
+			}
+			depth--
 		}
-		p.o = c
-	case objects.ObjectTree:
+	}
+	util.PanicErrf("missing '}' in revision: %s", p.rev)
+	return ""
+}
+
+// applyDereference applies the suffix "^{spec}" to the current
+// object. The spec may name an object type that the object is
+// peeled to, be empty to peel tags until a non-tag is found, or
+// be "/regex" to search the messages of the commit's history.
+func applyDereference(p *revParser, spec string) (err error) {
+	if strings.HasPrefix(spec, "/") {
 		c, err := CommitFromObject(p.repo, p.o)
 		if err != nil {
 			return err
 		}
-		p.o, err = p.repo.ObjectFromOid(c.Tree())
-		if err != nil {
-			return err
-		}
+		p.o, err = commitFromMessage(p.repo, []*objects.Commit{c}, spec[1:])
+		return err
+	}
+	switch otype := objects.ObjectType(spec); otype {
+	case "":
+		p.o, err = PeelObject(p.repo, p.o)
+	case "object":
+		// any object will do
+	case objects.ObjectCommit:
+		p.o, err = CommitFromObject(p.repo, p.o)
+	case objects.ObjectTree:
+		p.o, err = TreeFromObject(p.repo, p.o)
 	case objects.ObjectTag:
 		if p.o.Header().Type() != objects.ObjectTag {
 			return errors.New("cannot dereference non-tag to tag")
 		}
 	case objects.ObjectBlob:
+		if p.o, err = PeelObject(p.repo, p.o); err != nil {
+			return err
+		}
 		if p.o.Header().Type() != objects.ObjectBlob {
 			return errors.New("cannot dereference non-blob to blob")
 		}
+	default:
+		return fmt.Errorf("unknown object type: '%s'", spec)
 	}
-	return nil
+	return err
 }
 
 func (p *revParser) findObject(spec string) (err error) {
@@ -220,6 +357,90 @@ func (p *revParser) findObject(spec string) (err error) {
 // UTILITY METHODS
 // ================================================================= //
 
+// commitFromMessage returns the youngest commit reachable from
+// the given commits whose message matches the regular expression.
+// A pattern starting with "!-" finds the youngest commit whose
+// message does not match, and "!!" stands for a literal "!".
+func commitFromMessage(repo Repository, starts []*objects.Commit, pattern string) (*objects.Commit, error) {
+	negate := false
+	if strings.HasPrefix(pattern, "!") {
+		switch {
+		case strings.HasPrefix(pattern, "!-"):
+			negate, pattern = true, pattern[2:]
+		case strings.HasPrefix(pattern, "!!"):
+			pattern = pattern[1:]
+		default:
+			return nil, fmt.Errorf("invalid message search: '%s'", pattern)
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var found *objects.Commit
+	err = WalkCommits(repo, starts, func(c *objects.Commit) error {
+		if re.MatchString(c.Message()) != negate {
+			found = c
+			return StopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no commit message matches '%s'", pattern)
+	}
+	return found, nil
+}
+
+// refCommits returns the commits that HEAD and all other
+// refs of the repository point to, leaving out refs that
+// point to other kinds of objects.
+func refCommits(repo Repository) ([]*objects.Commit, error) {
+	refs, err := repo.Refs()
+	if err != nil {
+		return nil, err
+	}
+	if head, err := PeeledRefFromSpec(repo, "HEAD"); err == nil {
+		refs = append([]objects.Ref{head}, refs...)
+	}
+	var commits []*objects.Commit
+	for _, ref := range refs {
+		o, err := repo.ObjectFromOid(ref.ObjectId())
+		if err != nil {
+			return nil, err
+		}
+		for o.Header().Type() == objects.ObjectTag {
+			if o, err = repo.ObjectFromOid(o.(*objects.Tag).Object()); err != nil {
+				return nil, err
+			}
+		}
+		if c, ok := o.(*objects.Commit); ok {
+			commits = append(commits, c)
+		}
+	}
+	return commits, nil
+}
+
+// pathColon returns the index of the colon that separates a
+// revision from a path, or -1 if there is none. Colons inside
+// of braces, as in "rev^{/fix: bug}", don't count.
+func pathColon(rev string) int {
+	depth := 0
+	for i := 0; i < len(rev); i++ {
+		switch {
+		case rev[i] == '{':
+			depth++
+		case rev[i] == '}' && depth > 0:
+			depth--
+		case rev[i] == ':' && depth == 0:
+			return i
+		}
+	}
+	return -1
+}
+
 // isModifier returns true if and only if the parameter
 // is a supported modifier that may appear in rev parsing.
 // The modifier usually comes after the rev spec, signifying
@@ -11,6 +11,8 @@ import (
 	"github.com/jbrukh/ggit/api/objects"
 	"github.com/jbrukh/ggit/test"
 	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
 	"testing"
 )
 
@@ -78,7 +80,77 @@ func Test_revParse__derefs(t *testing.T) {
 	testObjectExpected(t, repo, info.TagName, tagOid, objects.ObjectTag)
 	testObjectExpected(t, repo, info.TagName+"^{commit}", commitOid, objects.ObjectCommit)
 	testObjectExpected(t, repo, info.TagName+"^{commit}^{tree}", treeOid, objects.ObjectTree)
+	testObjectExpected(t, repo, info.TagName+"^{}", commitOid, objects.ObjectCommit)
+	testObjectExpected(t, repo, info.TagName+"^{object}", tagOid, objects.ObjectTag)
+	testObjectExpected(t, repo, info.TagName+"^{tree}", treeOid, objects.ObjectTree)
+	testObjectExpected(t, repo, "HEAD^{}", commitOid, objects.ObjectCommit)
+	testObjectExpected(t, repo, "HEAD^{tree}^{tree}", treeOid, objects.ObjectTree)
+
+	for _, rev := range []string{"HEAD^{tag}", "HEAD^{blob}", "HEAD^{tree}^{commit}", "HEAD^{bogus}", "HEAD^{"} {
+		_, err := ObjectFromRevision(repo, rev)
+		util.Assert(t, err != nil, rev)
+	}
+}
+
+func Test_revParse__messages(t *testing.T) {
+	testCase := test.Linear
+	repo := Open(testCase.Repo())
+	info := testCase.Info().(*test.InfoLinear)
+
+	util.Assert(t, info.N > 2)
+	last := info.Commits[info.N-1]
+	head := objects.OidNow(last.CommitOid)
+	testObjectExpected(t, repo, "HEAD^{/Commit}", head, objects.ObjectCommit)
+	testObjectExpected(t, repo, last.TagName+"^{/Commit}", head, objects.ObjectCommit)
+	testObjectExpected(t, repo, "HEAD^{/!-Commit: [^0]}", objects.OidNow(info.Commits[0].CommitOid), objects.ObjectCommit)
+	testObjectExpected(t, repo, "HEAD^{/Commit: 1}", objects.OidNow(info.Commits[1].CommitOid), objects.ObjectCommit)
+	testObjectExpected(t, repo, ":/Commit: 0", objects.OidNow(info.Commits[0].CommitOid), objects.ObjectCommit)
+
+	_, err := ObjectFromRevision(repo, "HEAD^{/!-Commit}")
+	util.Assert(t, err != nil)
+	_, err = ObjectFromRevision(repo, "HEAD^{/!x}")
+	util.Assert(t, err != nil)
+}
+
+func Test_revParse__paths(t *testing.T) {
+	testCase := test.Tree
+	repo := Open(testCase.Repo())
+	info := testCase.Info().(*test.InfoTree)
+
+	revs := []string{"HEAD:1.txt", "HEAD:4", "HEAD:4/haha.txt", "HEAD^{tree}:5/hehe.txt", "HEAD:", ":2.txt", ":0:4/haha.txt", ":/complicated"}
+	for _, rev := range revs {
+		o, err := ObjectFromRevision(repo, rev)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, util.RevOid(testCase.Repo(), rev), o.ObjectId().String())
+	}
+	testObjectExpected(t, repo, "HEAD:3.txt", objects.OidNow(info.File3Oid), objects.ObjectBlob)
+
+	for _, rev := range []string{"HEAD:nope", "HEAD:1.txt/x", ":nope", ":2:1.txt", ":/no such message"} {
+		_, err := ObjectFromRevision(repo, rev)
+		util.Assert(t, err != nil, rev)
+	}
+}
+
+func Test_revParse__relativePaths(t *testing.T) {
+	testCase := test.Tree
+	repo := Open(testCase.Repo())
+	cwd, err := os.Getwd()
+	util.AssertNoErrOrDie(t, err)
+	defer os.Chdir(cwd)
+	util.AssertNoErrOrDie(t, os.Chdir(path.Join(testCase.Repo(), "4")))
+
+	// git runs in the current directory too
+	revs := []string{"HEAD:./haha.txt", "HEAD:../1.txt", "HEAD:./", "HEAD:../5/hehe.txt", "HEAD:./../4", ":./haha.txt", ":0:../2.txt", "HEAD:4/haha.txt"}
+	for _, rev := range revs {
+		o, err := ObjectFromRevision(repo, rev)
+		util.AssertNoErr(t, err)
+		util.AssertEqualString(t, util.RevOid(testCase.Repo(), rev), o.ObjectId().String())
+	}
 
+	for _, rev := range []string{"HEAD:../../1.txt", "HEAD:./1.txt", ":./2.txt"} {
+		_, err := LookupRevision(repo, rev)
+		util.Assert(t, err != nil, rev)
+	}
 }
 
 // testShortOid retrives the object by all possible combinations of
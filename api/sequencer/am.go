@@ -0,0 +1,352 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+am.go implements the state of the application of a mailbox that is in
+progress, which git keeps in the .git/rebase-apply directory. The directory
+holds the emails of the mailbox, one per file, named by their numbers, as in
+"0001", the number of the email that is applied next, in "next", and of the
+last one, in "last", and the options of the application, as "t" or "f". The
+email that is being applied leaves what it says of its commit behind: its
+info, its message and its patch, and the author and the message that its
+commit is made with, in "author-script" and "final-commit".
+*/
+package sequencer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/mailbox"
+	"github.com/jbrukh/ggit/api/objects"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// MAILBOXES
+// ================================================================= //
+
+// AmDir is the directory of the repository that keeps the state of
+// the application of a mailbox that is in progress.
+const AmDir = "rebase-apply"
+
+// the files of the state of an application of a mailbox
+const (
+	nextFile        = "next"
+	lastFile        = "last"
+	infoFile        = "info"
+	msgFile         = "msg"
+	finalCommitFile = "final-commit"
+	dirtyIndexFile  = "dirtyindex"
+	applyingFile    = "applying"
+	mergeIndexFile  = "patch-merge-index"
+)
+
+// the files of the options of an application of a mailbox
+const (
+	threewayFile  = "threeway"
+	signFile      = "sign"
+	utf8File      = "utf8"
+	keepFile      = "keep"
+	messageIdFile = "messageid"
+	scissorsFile  = "scissors"
+	quotedCrFile  = "quoted-cr"
+	applyOptFile  = "apply-opt"
+)
+
+// ErrAmInProgress is returned when a mailbox is applied while the
+// application of another one is in progress.
+var ErrAmInProgress = errors.New("an application of a mailbox is already in progress")
+
+// Am is the state of the application of a mailbox that is kept while
+// it is in progress.
+type Am struct {
+	// Next is the number of the email that is applied next, and
+	// Last is the number of the last one.
+	Next, Last int
+
+	Quiet    bool // the progress is not reported
+	ThreeWay bool // patches that don't apply are merged
+	Keep     bool // the subjects of the emails are kept as they are
+}
+
+func amPath(repo *api.DiskRepository, name string) string {
+	return path.Join(repo.Path(), AmDir, name)
+}
+
+// AmInProgress returns true if the application of a mailbox is in
+// progress. A directory without the numbers of the emails is a stray
+// that doesn't count.
+func AmInProgress(repo *api.DiskRepository) bool {
+	for _, name := range []string{nextFile, lastFile} {
+		if _, err := os.Stat(amPath(repo, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// StartAm records the state of a new application of the emails of a
+// mailbox, and the commit that HEAD is at, which is nil on an unborn
+// branch.
+func StartAm(repo *api.DiskRepository, am *Am, mails [][]byte, head *objects.ObjectId) error {
+	if err := os.Mkdir(path.Join(repo.Path(), AmDir), 0777); os.IsExist(err) {
+		return ErrAmInProgress
+	} else if err != nil {
+		return err
+	}
+	for i, mail := range mails {
+		if err := writeAmState(repo, mailName(i+1), string(mail)); err != nil {
+			return err
+		}
+	}
+	flag := func(b bool) string {
+		if b {
+			return "t\n"
+		}
+		return "f\n"
+	}
+	files := []struct{ name, contents string }{
+		{threewayFile, flag(am.ThreeWay)},
+		{quietFile, flag(am.Quiet)},
+		{signFile, flag(false)},
+		{utf8File, flag(true)},
+		{keepFile, flag(am.Keep)},
+		{messageIdFile, flag(false)},
+		{scissorsFile, ""},
+		{quotedCrFile, ""},
+		{applyOptFile, ""},
+		{applyingFile, ""},
+	}
+	for _, f := range files {
+		if err := writeAmState(repo, f.name, f.contents); err != nil {
+			return err
+		}
+	}
+	if err := UpdateAmAbortSafety(repo, head); err != nil {
+		return err
+	}
+	if err := writeAmState(repo, nextFile, fmt.Sprintf("%d\n", am.Next)); err != nil {
+		return err
+	}
+	return writeAmState(repo, lastFile, fmt.Sprintf("%d\n", am.Last))
+}
+
+// ReadAm returns the state of the application of a mailbox that is
+// in progress.
+func ReadAm(repo *api.DiskRepository) (*Am, error) {
+	am := new(Am)
+	var err error
+	if am.Next, err = readAmCount(repo, nextFile); err != nil {
+		return nil, err
+	}
+	if am.Last, err = readAmCount(repo, lastFile); err != nil {
+		return nil, err
+	}
+	if am.Quiet, err = readAmFlag(repo, quietFile); err != nil {
+		return nil, err
+	}
+	if am.ThreeWay, err = readAmFlag(repo, threewayFile); err != nil {
+		return nil, err
+	}
+	if am.Keep, err = readAmFlag(repo, keepFile); err != nil {
+		return nil, err
+	}
+	return am, nil
+}
+
+// RemoveAm forgets about the application of a mailbox that is in
+// progress.
+func RemoveAm(repo *api.DiskRepository) error {
+	return os.RemoveAll(path.Join(repo.Path(), AmDir))
+}
+
+// ReadMail returns an email of the mailbox, by its number, or nil if
+// there is no such email.
+func ReadMail(repo *api.DiskRepository, n int) ([]byte, error) {
+	data, err := ioutil.ReadFile(amPath(repo, mailName(n)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// mailName returns the name of the file of an email, as in "0001".
+func mailName(n int) string {
+	return fmt.Sprintf("%04d", n)
+}
+
+// ================================================================= //
+// EMAILS
+// ================================================================= //
+
+// WriteMail records what the email that is being applied says of its
+// commit: its info, its message and its patch.
+func WriteMail(repo *api.DiskRepository, m *mailbox.Mail) error {
+	var info string
+	if m.Email != "" {
+		info += fmt.Sprintf("Author: %s\nEmail: %s\n", m.Name, m.Email)
+	}
+	info += fmt.Sprintf("Subject: %s\n", m.Subject)
+	if m.Date != "" {
+		info += fmt.Sprintf("Date: %s\n", m.Date)
+	}
+	files := []struct{ name, contents string }{
+		{infoFile, info + "\n"},
+		{msgFile, m.Message},
+		{patchFile, m.Patch},
+	}
+	for _, f := range files {
+		if err := writeAmState(repo, f.name, f.contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAmCommit records the author of the commit of the email that is
+// being applied, and the message that the commit is made with.
+func WriteAmCommit(repo *api.DiskRepository, m *mailbox.Mail, msg string) error {
+	if err := writeAmState(repo, authorScriptFile, formatAuthorScript(m.Name, m.Email, m.Date)); err != nil {
+		return err
+	}
+	return writeAmState(repo, finalCommitFile, msg)
+}
+
+// AmPatch returns the patch of the email that is being applied, and
+// the path of its file.
+func AmPatch(repo *api.DiskRepository) ([]byte, string, error) {
+	name := amPath(repo, patchFile)
+	data, err := ioutil.ReadFile(name)
+	return data, path.Join(api.DefaultGitDir, AmDir, patchFile), err
+}
+
+// WriteAmMergeIndex records an index that a three-way merge of the
+// patch of the email that is being applied is made with: the files
+// that the patch was made against, or the same files once it applies.
+func WriteAmMergeIndex(repo *api.DiskRepository, idx *api.Index) error {
+	f, err := os.Create(amPath(repo, mergeIndexFile))
+	if err != nil {
+		return err
+	}
+	if _, err = idx.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AmCommit returns the name, the email and the date of the author of
+// the commit of the email that is being applied, and its message. The
+// application can't be resumed without them.
+func AmCommit(repo *api.DiskRepository) (name, email, date, msg string, err error) {
+	for _, file := range []string{finalCommitFile, authorScriptFile} {
+		if _, err = os.Stat(amPath(repo, file)); os.IsNotExist(err) {
+			err = fmt.Errorf("cannot resume: %s does not exist.", path.Join(api.DefaultGitDir, AmDir, file))
+			return
+		}
+	}
+	data, err := ioutil.ReadFile(amPath(repo, finalCommitFile))
+	if err != nil {
+		return
+	}
+	name, email, date, err = readAuthorScript(repo, AmDir)
+	return name, email, date, string(data), err
+}
+
+// NextMail moves on to the next email of the mailbox, now that HEAD
+// is at a commit, which is nil on an unborn branch.
+func NextMail(repo *api.DiskRepository, am *Am, head *objects.ObjectId) error {
+	am.Next++
+	if err := writeAmState(repo, nextFile, fmt.Sprintf("%d\n", am.Next)); err != nil {
+		return err
+	}
+	for _, name := range []string{authorScriptFile, finalCommitFile} {
+		if err := os.Remove(amPath(repo, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return UpdateAmAbortSafety(repo, head)
+}
+
+// ================================================================= //
+// ABORTING
+// ================================================================= //
+
+// UpdateAmAbortSafety records the commit that HEAD is at, which is
+// nil on an unborn branch.
+func UpdateAmAbortSafety(repo *api.DiskRepository, head *objects.ObjectId) error {
+	oid := ""
+	if head != nil {
+		oid = head.String() + "\n"
+	}
+	return writeAmState(repo, abortSafetyFile, oid)
+}
+
+// AmAbortIsSafe returns true if HEAD, which is nil on an unborn
+// branch, is where the application last left it, so that rolling it
+// back loses no commits.
+func AmAbortIsSafe(repo *api.DiskRepository, head *objects.ObjectId) bool {
+	data, _ := ioutil.ReadFile(amPath(repo, abortSafetyFile))
+	expected := strings.TrimSpace(string(data))
+	if expected == "" {
+		return head == nil
+	}
+	return head != nil && head.String() == expected
+}
+
+// AmIndexWasDirty returns true if the application couldn't start
+// because the index had changes, which rolling it back mustn't lose.
+func AmIndexWasDirty(repo *api.DiskRepository) bool {
+	_, err := os.Stat(amPath(repo, dirtyIndexFile))
+	return err == nil
+}
+
+// MarkDirtyIndex records whether the application couldn't start
+// because the index had changes.
+func MarkDirtyIndex(repo *api.DiskRepository, dirty bool) error {
+	if dirty {
+		return writeAmState(repo, dirtyIndexFile, "t\n")
+	}
+	if err := os.Remove(amPath(repo, dirtyIndexFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ================================================================= //
+// FILES
+// ================================================================= //
+
+func writeAmState(repo *api.DiskRepository, name, contents string) error {
+	return ioutil.WriteFile(amPath(repo, name), []byte(contents), 0666)
+}
+
+func readAmCount(repo *api.DiskRepository, name string) (int, error) {
+	data, err := ioutil.ReadFile(amPath(repo, name))
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s", path.Join(api.DefaultGitDir, AmDir, name))
+	}
+	return n, nil
+}
+
+func readAmFlag(repo *api.DiskRepository, name string) (bool, error) {
+	data, err := ioutil.ReadFile(amPath(repo, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return strings.TrimSpace(string(data)) == "t", err
+}
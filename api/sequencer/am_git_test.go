@@ -0,0 +1,85 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+am_git_test.go implements git-comparison tests for the state of the
+applications of mailboxes, which are checked against what git-am leaves
+behind, and resumed by git-am.
+*/
+package sequencer
+
+import (
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/mailbox"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test_AmState checks that the state of an application of a mailbox
+// that git-am stopped at a conflict is read back, that the same state
+// is written as git writes it, and that git-am carries on with it.
+func Test_AmState(t *testing.T) {
+	testCase := test.Am
+	dir, err := testCase.Clone("__sequencer_am")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+	mbox := testCase.Info().(*test.InfoAm).Mbox
+
+	_, err = util.GitExec(dir, "am", path.Join(dir, ".git", "mbox"))
+	util.Assert(t, err != nil)
+	util.Assert(t, AmInProgress(repo))
+	names := []string{nextFile, lastFile, infoFile, msgFile, patchFile, finalCommitFile, authorScriptFile,
+		abortSafetyFile, threewayFile, quietFile, signFile, utf8File, keepFile, messageIdFile,
+		"0001", "0002", "0003"}
+	gitFiles := make(map[string]string)
+	for _, name := range names {
+		gitFiles[name] = readStateFile(t, dir, AmDir, name)
+	}
+
+	am, err := ReadAm(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, *am == Am{Next: 2, Last: 3})
+	name, email, _, msg, err := AmCommit(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualString(t, "A U Thor", name)
+	util.AssertEqualString(t, "author@example.com", email)
+	util.AssertEqualString(t, "change a\n", msg)
+	data, _, err := AmPatch(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, strings.HasPrefix(string(data), "---\n a | 2 +-\n"))
+	_, head, err := api.Head(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, AmAbortIsSafe(repo, head))
+	util.Assert(t, !AmIndexWasDirty(repo))
+	util.AssertNoErrOrDie(t, RemoveAm(repo))
+	util.Assert(t, !AmInProgress(repo))
+
+	mails := mailbox.Split([]byte(mbox))
+	util.AssertEqualInt(t, 3, len(mails))
+	util.AssertNoErrOrDie(t, StartAm(repo, am, mails, head))
+	util.Assert(t, StartAm(repo, am, mails, head) == ErrAmInProgress)
+	m, err := mailbox.Parse(mails[1], false)
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, WriteMail(repo, m))
+	util.AssertNoErrOrDie(t, WriteAmCommit(repo, m, m.Subject+"\n"))
+	for _, name := range names {
+		util.AssertEqualString(t, gitFiles[name], readStateFile(t, dir, AmDir, name))
+	}
+
+	// git-am skips the patch that conflicts, and applies the last one
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir, []string{"am", "--skip"}))
+	util.Assert(t, !AmInProgress(repo))
+	subject, err := util.GitExec(dir, "log", "-1", "--format=%s")
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualString(t, "add d\n", subject)
+}
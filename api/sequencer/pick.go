@@ -0,0 +1,182 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+pick.go implements the picks and the reverts of single commits, which
+merge the changes that a commit made to one of its parents into a tree,
+or undo them, with a three-way merge of trees: a pick merges the commit
+into the tree with the parent as the base, and a revert merges the parent
+with the commit as the base.
+*/
+package sequencer
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/merge"
+	"github.com/jbrukh/ggit/api/objects"
+	"strings"
+)
+
+// ================================================================= //
+// PICKS
+// ================================================================= //
+
+// Parent returns the parent of a commit that a pick or a revert
+// takes its changes against, which is the parent that mainline
+// numbers for merges, and the only parent otherwise. A root commit
+// has no parent, which is nil.
+func Parent(repo api.Repository, c *objects.Commit, mainline int) (*objects.Commit, error) {
+	parents := c.Parents()
+	switch {
+	case len(parents) > 1 && mainline == 0:
+		return nil, fmt.Errorf("commit %s is a merge but no -m option was given.", c.ObjectId())
+	case len(parents) > 1 && mainline > len(parents):
+		return nil, fmt.Errorf("commit %s does not have parent %d", c.ObjectId(), mainline)
+	case len(parents) > 1:
+		return api.CommitFromOid(repo, parents[mainline-1])
+	case mainline > 1:
+		return nil, fmt.Errorf("commit %s does not have parent %d", c.ObjectId(), mainline)
+	case len(parents) == 0:
+		return nil, nil
+	}
+	return api.CommitFromOid(repo, parents[0])
+}
+
+// Apply picks or reverts a commit onto a tree, taking its changes
+// against the given parent, which may be nil, as may the tree. The
+// conflicts are labeled by the commit, its parent, or "(empty tree)"
+// for root commits, and "HEAD", which is the side of the tree.
+func Apply(repo *api.DiskRepository, cmd Command, c, parent *objects.Commit, ours *objects.Tree, opts *merge.Options) (*merge.Result, error) {
+	label := Label(c)
+	parentLabel := "parent of " + label
+	if parent == nil {
+		parentLabel = "(empty tree)"
+	}
+	base, next := parent, c
+	baseLabel, nextLabel := parentLabel, label
+	if cmd == Revert {
+		base, next = c, parent
+		baseLabel, nextLabel = label, parentLabel
+	}
+	var baseTree, nextTree *objects.Tree
+	var err error
+	if base != nil {
+		if baseTree, err = api.TreeFromOid(repo, base.Tree()); err != nil {
+			return nil, err
+		}
+	}
+	if next != nil {
+		if nextTree, err = api.TreeFromOid(repo, next.Tree()); err != nil {
+			return nil, err
+		}
+	}
+	inner := *opts
+	inner.Ours, inner.Theirs, inner.Base = "HEAD", nextLabel, baseLabel
+	return merge.MergeTrees(repo, baseTree, ours, nextTree, &inner)
+}
+
+// ================================================================= //
+// MESSAGES
+// ================================================================= //
+
+// Subject returns the first line of the message of a commit.
+func Subject(c *objects.Commit) string {
+	return strings.SplitN(c.Message(), "\n", 2)[0]
+}
+
+// Label returns the label of a commit in conflicts, which is its
+// abbreviated oid and its subject, as in "44410c2 (Fix typo)".
+func Label(c *objects.Commit) string {
+	return fmt.Sprintf("%s (%s)", c.ObjectId().String()[:7], Subject(c))
+}
+
+// Message returns the message of the commit that picks or reverts
+// a commit. A pick keeps the message of the commit, and names the
+// commit that it was picked from if recordOrigin is true. A revert
+// names the commit that it reverts, and the parent that a merge is
+// reverted to.
+func Message(cmd Command, c, parent *objects.Commit, recordOrigin bool) string {
+	if cmd == Revert {
+		msg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", Subject(c), c.ObjectId())
+		if len(c.Parents()) > 1 {
+			msg += fmt.Sprintf(", reversing\nchanges made to %s", parent.ObjectId())
+		}
+		return msg + ".\n"
+	}
+	msg := c.Message()
+	if !recordOrigin {
+		return msg
+	}
+	if msg != "" && !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	if !hasTrailers(msg) {
+		msg += "\n"
+	}
+	return msg + fmt.Sprintf("%s%s)\n", cherryPickedPrefix, c.ObjectId())
+}
+
+// ================================================================= //
+// TRAILERS
+// ================================================================= //
+
+// the prefixes of the trailers that git itself adds
+const (
+	signedOffPrefix    = "Signed-off-by: "
+	cherryPickedPrefix = "(cherry picked from commit "
+)
+
+// hasTrailers returns true if a message ends with a block of
+// trailers, as in "Signed-off-by: A U Thor <author@example.com>",
+// which the trailer of a pick joins without a blank line. The block
+// is the last paragraph, other than the subject, of which every line
+// is a trailer, or at least a quarter of the lines are, if one of
+// them is a trailer that git adds.
+func hasTrailers(msg string) bool {
+	paragraphs := strings.Split(strings.TrimRight(msg, "\n"), "\n\n")
+	if len(paragraphs) < 2 {
+		return false
+	}
+	var trailers, others int
+	recognized := false
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		switch {
+		case strings.HasPrefix(line, signedOffPrefix) || strings.HasPrefix(line, cherryPickedPrefix):
+			recognized = true
+			trailers++
+		case isTrailer(line):
+			trailers++
+		case line != "" && (line[0] == ' ' || line[0] == '\t'):
+			// continues the trailer before it
+		default:
+			others++
+		}
+	}
+	return trailers > 0 && others == 0 || recognized && trailers*3 >= others
+}
+
+// isTrailer returns true if a line is a token and its value, as in
+// "Reviewed-by: A U Thor", where the token is made of letters,
+// digits and dashes.
+func isTrailer(line string) bool {
+	i := strings.Index(line, ":")
+	if i <= 0 {
+		return false
+	}
+	token := strings.TrimRight(line[:i], " \t")
+	if token == "" {
+		return false
+	}
+	for _, r := range token {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,633 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+rebase.go implements the state of a rebase that is in progress, which git
+keeps in the .git/rebase-merge directory, so that either of them can resume
+a rebase that the other one stopped. The directory holds the branch that is
+rebased, in "head-name", the commit that it is rebased onto, in "onto", the
+commit that the branch was at, in "orig-head", the instructions that are
+left, in "git-rebase-todo", and the ones that are done, in "done". A commit
+that stopped the rebase leaves its oid, its message and its author behind,
+and the commits that were rewritten are listed with the commits that they
+were rewritten as, in "rewritten-list".
+*/
+package sequencer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// REBASES
+// ================================================================= //
+
+// RebaseDir is the directory of the repository that keeps the state
+// of the rebase that is in progress.
+const RebaseDir = "rebase-merge"
+
+// the files of the state of a rebase
+const (
+	headNameFile         = "head-name"
+	ontoFile             = "onto"
+	origHeadFile         = "orig-head"
+	quietFile            = "quiet"
+	rebaseTodoFile       = "git-rebase-todo"
+	backupFile           = "git-rebase-todo.backup"
+	doneFile             = "done"
+	msgnumFile           = "msgnum"
+	endFile              = "end"
+	authorScriptFile     = "author-script"
+	messageFile          = "message"
+	patchFile            = "patch"
+	stoppedShaFile       = "stopped-sha"
+	amendFile            = "amend"
+	rewrittenListFile    = "rewritten-list"
+	rewrittenPendingFile = "rewritten-pending"
+	squashMsgFile        = "message-squash"
+	fixupMsgFile         = "message-fixup"
+	currentFixupsFile    = "current-fixups"
+)
+
+// the empty files that mark the options of a rebase
+const (
+	interactiveFile   = "interactive"
+	noRescheduleFile  = "no-reschedule-failed-exec"
+	dropRedundantFile = "drop_redundant_commits"
+	keepRedundantFile = "keep_redundant_commits"
+)
+
+// DetachedHead is the head name of a rebase of a detached HEAD.
+const DetachedHead = "detached HEAD"
+
+// ErrRebaseInProgress is returned when a rebase is started while one
+// is in progress already.
+var ErrRebaseInProgress = errors.New("a rebase is already in progress")
+
+// Rebase is the state of a rebase that is kept while it is in
+// progress.
+type Rebase struct {
+	// HeadName is the full name of the ref of the branch that is
+	// rebased, as in "refs/heads/topic", or DetachedHead.
+	HeadName string
+
+	// Onto is the commit that the branch is rebased onto, and
+	// OrigHead is the commit that the branch was at.
+	Onto, OrigHead *objects.ObjectId
+
+	// Quiet suppresses the progress of the rebase.
+	Quiet bool
+
+	// KeepRedundant keeps the commits that end up changing nothing,
+	// as they do when commands are run after every commit, instead
+	// of dropping them.
+	KeepRedundant bool
+}
+
+func rebasePath(repo *api.DiskRepository, name string) string {
+	return path.Join(repo.Path(), RebaseDir, name)
+}
+
+// RebaseInProgress returns true if a rebase is in progress.
+func RebaseInProgress(repo *api.DiskRepository) bool {
+	_, err := os.Stat(path.Join(repo.Path(), RebaseDir))
+	return err == nil
+}
+
+// StartRebase records the state of a new rebase and its todo list,
+// which is backed up with the help that git-rebase -i shows. The
+// revisions that are rebased are named in the help by their
+// abbreviated oids, as in "e8da2fd..efc7861".
+func StartRebase(repo *api.DiskRepository, r *Rebase, todo []*Instruction, revisions string) error {
+	if err := os.Mkdir(path.Join(repo.Path(), RebaseDir), 0777); os.IsExist(err) {
+		return ErrRebaseInProgress
+	} else if err != nil {
+		return err
+	}
+	redundant := dropRedundantFile
+	if r.KeepRedundant {
+		redundant = keepRedundantFile
+	}
+	files := []struct{ name, contents string }{
+		{headNameFile, r.HeadName + "\n"},
+		{ontoFile, r.Onto.String() + "\n"},
+		{origHeadFile, r.OrigHead.String() + "\n"},
+		{interactiveFile, ""},
+		{noRescheduleFile, ""},
+		{redundant, ""},
+	}
+	if r.Quiet {
+		files = append(files, struct{ name, contents string }{quietFile, ""})
+	}
+	for _, f := range files {
+		if err := writeRebaseState(repo, f.name, f.contents); err != nil {
+			return err
+		}
+	}
+	commands := "commands"
+	if len(todo) == 1 {
+		commands = "command"
+	}
+	backup := fmt.Sprintf("%s\n# Rebase %s onto %s (%d %s)\n%s", FormatTodo(todo), revisions, r.Onto.String()[:7], len(todo), commands, todoHelp)
+	if err := writeRebaseState(repo, backupFile, backup); err != nil {
+		return err
+	}
+	return WriteRebaseTodo(repo, todo)
+}
+
+// the help that follows the todo list of a rebase, as git-rebase -i
+// shows it
+const todoHelp = `#
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup [-C | -c] <commit> = like "squash" but keep only the previous
+#                    commit's log message, unless -C is used, in which case
+#                    keep only this commit's message; -c is same as -C but
+#                    opens the editor
+# x, exec <command> = run command (the rest of the line) using shell
+# b, break = stop here (continue rebase later with 'git rebase --continue')
+# d, drop <commit> = remove commit
+# l, label <label> = label current HEAD with a name
+# t, reset <label> = reset HEAD to a label
+# m, merge [-C <commit> | -c <commit>] <label> [# <oneline>]
+#         create a merge commit using the original merge commit's
+#         message (or the oneline, if no original merge commit was
+#         specified); use -c <commit> to reword the commit message
+# u, update-ref <ref> = track a placeholder for the <ref> to be updated
+#                       to this position in the new commits. The <ref> is
+#                       updated at the end of the rebase
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+// ReadRebase returns the state of the rebase that is in progress.
+func ReadRebase(repo *api.DiskRepository) (*Rebase, error) {
+	r := new(Rebase)
+	var err error
+	if r.HeadName, err = readRebaseLine(repo, headNameFile); err != nil {
+		return nil, err
+	}
+	if r.Onto, err = readRebaseOid(repo, ontoFile); err != nil {
+		return nil, err
+	}
+	if r.OrigHead, err = readRebaseOid(repo, origHeadFile); err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(rebasePath(repo, quietFile))
+	r.Quiet = err == nil
+	_, err = os.Stat(rebasePath(repo, keepRedundantFile))
+	r.KeepRedundant = err == nil
+	return r, nil
+}
+
+// RemoveRebase forgets about the rebase that is in progress.
+func RemoveRebase(repo *api.DiskRepository) error {
+	return os.RemoveAll(path.Join(repo.Path(), RebaseDir))
+}
+
+// ReadRebaseTodo returns the instructions that are left of the
+// rebase that is in progress.
+func ReadRebaseTodo(repo *api.DiskRepository) ([]*Instruction, error) {
+	data, err := ioutil.ReadFile(rebasePath(repo, rebaseTodoFile))
+	if err != nil {
+		return nil, err
+	}
+	return ParseTodo(repo, string(data))
+}
+
+// WriteRebaseTodo replaces the instructions that are left.
+func WriteRebaseTodo(repo *api.DiskRepository, todo []*Instruction) error {
+	return writeRebaseState(repo, rebaseTodoFile, FormatTodo(todo))
+}
+
+// MarkDone adds instructions to the ones that are done.
+func MarkDone(repo *api.DiskRepository, done ...*Instruction) error {
+	return appendRebaseState(repo, doneFile, FormatTodo(done))
+}
+
+// DoneCount returns the number of instructions of the rebase in
+// progress that are done.
+func DoneCount(repo *api.DiskRepository) (int, error) {
+	data, err := ioutil.ReadFile(rebasePath(repo, doneFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// WriteProgress records the number of instructions that are done,
+// and the number of instructions in all.
+func WriteProgress(repo *api.DiskRepository, done, total int) error {
+	if err := writeRebaseState(repo, endFile, fmt.Sprintf("%d\n", total)); err != nil {
+		return err
+	}
+	return writeRebaseState(repo, msgnumFile, fmt.Sprintf("%d\n", done))
+}
+
+// ================================================================= //
+// STOPS
+// ================================================================= //
+
+// WriteAuthorScript records the author of the commit that is being
+// picked, in the format of a shell script that sets the environment
+// of git-commit, so that the commit keeps its author if the rebase
+// stops.
+func WriteAuthorScript(repo *api.DiskRepository, author *objects.WhoWhen) error {
+	date := fmt.Sprintf("@%d %s", author.Seconds(), author.Time().Format("-0700"))
+	return writeRebaseState(repo, authorScriptFile, formatAuthorScript(author.Name(), author.Email(), date))
+}
+
+// ReadAuthorScript returns the author that was recorded for the
+// commit that stopped the rebase.
+func ReadAuthorScript(repo *api.DiskRepository) (*objects.WhoWhen, error) {
+	name, email, date, err := readAuthorScript(repo, RebaseDir)
+	if err != nil {
+		return nil, err
+	}
+	seconds, offset, err := api.ParseDate(date)
+	if err != nil {
+		return nil, authorScriptError(RebaseDir)
+	}
+	return objects.NewWhoWhen(name, email, seconds, offset), nil
+}
+
+// formatAuthorScript formats the script that sets the author of a
+// commit, whose values are quoted for the shell.
+func formatAuthorScript(name, email, date string) string {
+	quote := func(s string) string {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}
+	return fmt.Sprintf("GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n", quote(name), quote(email), quote(date))
+}
+
+// readAuthorScript returns the values of the author script of the
+// state of a directory.
+func readAuthorScript(repo *api.DiskRepository, dir string) (name, email, date string, err error) {
+	data, err := ioutil.ReadFile(path.Join(repo.Path(), dir, authorScriptFile))
+	if err != nil {
+		return "", "", "", err
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return "", "", "", authorScriptError(dir)
+		}
+		value := strings.NewReplacer(`'\''`, "'", `'\!'`, "!").Replace(line[i+1:])
+		if len(value) < 2 || value[0] != '\'' || value[len(value)-1] != '\'' {
+			return "", "", "", authorScriptError(dir)
+		}
+		values[line[:i]] = value[1 : len(value)-1]
+	}
+	name, ok1 := values["GIT_AUTHOR_NAME"]
+	email, ok2 := values["GIT_AUTHOR_EMAIL"]
+	date, ok3 := values["GIT_AUTHOR_DATE"]
+	if !ok1 || !ok2 || !ok3 {
+		return "", "", "", authorScriptError(dir)
+	}
+	return name, email, date, nil
+}
+
+func authorScriptError(dir string) error {
+	return fmt.Errorf("unable to parse '%s'", path.Join(api.DefaultGitDir, dir, authorScriptFile))
+}
+
+// WriteStop records the commit that stopped the rebase: its oid, its
+// patch and its message, unless a message was recorded for it
+// already.
+func WriteStop(repo *api.DiskRepository, c *objects.Commit) error {
+	if err := writeRebaseState(repo, stoppedShaFile, c.ObjectId().String()+"\n"); err != nil {
+		return err
+	}
+	patch, err := commitPatch(repo, c)
+	if err != nil {
+		return err
+	}
+	if err = writeRebaseState(repo, patchFile, patch); err != nil {
+		return err
+	}
+	if _, err = os.Stat(rebasePath(repo, messageFile)); os.IsNotExist(err) {
+		return writeRebaseState(repo, messageFile, c.Message()+"\n")
+	}
+	return err
+}
+
+// commitPatch returns the patch of the changes that a commit made to
+// its parent, as git-diff-tree -p shows it. Merges have none.
+func commitPatch(repo *api.DiskRepository, c *objects.Commit) (string, error) {
+	if len(c.Parents()) > 1 {
+		return "", nil
+	}
+	var before *objects.Tree
+	if len(c.Parents()) == 1 {
+		parent, err := api.CommitFromOid(repo, c.Parents()[0])
+		if err != nil {
+			return "", err
+		}
+		if before, err = api.TreeFromOid(repo, parent.Tree()); err != nil {
+			return "", err
+		}
+	}
+	after, err := api.TreeFromOid(repo, c.Tree())
+	if err != nil {
+		return "", err
+	}
+	td, err := diff.DiffTrees(repo, before, after, new(diff.Options))
+	if err != nil {
+		return "", err
+	}
+	attrs, err := api.NewAttributes(repo)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	source := diff.RepoSource(repo)
+	opts := diff.PatchOptions{Context: diff.DefaultContext, Detector: diff.NewBinaryDetector(attrs, nil)}
+	if err = diff.NewPatchWriter(buf, source, source, opts).WriteDiff(td); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Stopped returns the commit that stopped the rebase, or nil if it
+// didn't stop at a commit.
+func Stopped(repo *api.DiskRepository) (*objects.ObjectId, error) {
+	oid, err := readRebaseOid(repo, stoppedShaFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return oid, err
+}
+
+// StopMessage returns the message that was recorded for the commit
+// that stopped the rebase.
+func StopMessage(repo *api.DiskRepository) (string, error) {
+	data, err := ioutil.ReadFile(rebasePath(repo, messageFile))
+	return string(data), err
+}
+
+// WriteStopMessage records the message for the commit that stopped
+// the rebase.
+func WriteStopMessage(repo *api.DiskRepository, msg string) error {
+	return writeRebaseState(repo, messageFile, msg)
+}
+
+// WriteAmend records that the commit that stopped the rebase is to
+// be amended into HEAD, which is at the given commit, when the rebase
+// continues, as fixups and squashes are.
+func WriteAmend(repo *api.DiskRepository, head *objects.ObjectId) error {
+	return writeRebaseState(repo, amendFile, head.String()+"\n")
+}
+
+// Amend returns the commit that HEAD was at when the commit that is
+// to be amended into it stopped the rebase, or nil if there is no
+// such commit.
+func Amend(repo *api.DiskRepository) (*objects.ObjectId, error) {
+	oid, err := readRebaseOid(repo, amendFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return oid, err
+}
+
+// ClearStop forgets about the commit that last stopped the rebase,
+// before the next instruction is carried out.
+func ClearStop(repo *api.DiskRepository) error {
+	for _, name := range []string{messageFile, authorScriptFile, stoppedShaFile, amendFile} {
+		if err := os.Remove(rebasePath(repo, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ================================================================= //
+// REWRITTEN COMMITS
+// ================================================================= //
+
+// RecordRewritten records that a commit was rewritten as HEAD, which
+// is at the given commit. A commit that is followed by fixups is kept
+// pending until they are done, as are the fixups themselves, since
+// they are all rewritten as the same commit.
+func RecordRewritten(repo *api.DiskRepository, old, head *objects.ObjectId, pending bool) error {
+	if err := appendRebaseState(repo, rewrittenPendingFile, old.String()+"\n"); err != nil {
+		return err
+	}
+	if pending {
+		return nil
+	}
+	return flushRewritten(repo, head)
+}
+
+// flushRewritten records that the commits that are pending were
+// rewritten as HEAD, which is at the given commit.
+func flushRewritten(repo *api.DiskRepository, head *objects.ObjectId) error {
+	data, err := ioutil.ReadFile(rebasePath(repo, rewrittenPendingFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var list string
+	for _, line := range strings.Fields(string(data)) {
+		list += line + " " + head.String() + "\n"
+	}
+	if err = appendRebaseState(repo, rewrittenListFile, list); err != nil {
+		return err
+	}
+	return os.Remove(rebasePath(repo, rewrittenPendingFile))
+}
+
+// ================================================================= //
+// SQUASHES
+// ================================================================= //
+
+// UpdateSquashMessage adds the message of a commit that is a fixup or
+// a squash of HEAD to the message of the commit that they make
+// together, which lists the messages of all of them. A fixup adds its
+// message as a comment, and so does a squash that marks itself as a
+// fixup or a squash by its subject, to its subject. The message is
+// started with the one of HEAD, which is kept as it is as long as all
+// of the commits are fixups.
+func UpdateSquashMessage(repo *api.DiskRepository, cmd Command, c, head *objects.Commit) error {
+	fixups, err := ioutil.ReadFile(rebasePath(repo, currentFixupsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	count := 0
+	if len(fixups) > 0 {
+		count = strings.Count(string(fixups), "\n") + 1
+	}
+	var msg string
+	if count > 0 {
+		data, err := ioutil.ReadFile(rebasePath(repo, squashMsgFile))
+		if err != nil {
+			return fmt.Errorf("could not read '%s'", path.Join(api.DefaultGitDir, RebaseDir, squashMsgFile))
+		}
+		msg = string(data)
+		if strings.HasPrefix(msg, "#") {
+			if i := strings.Index(msg, "\n"); i >= 0 {
+				msg = msg[i:]
+			} else {
+				msg = ""
+			}
+		}
+		msg = fmt.Sprintf("# This is a combination of %d commits.", count+2) + msg
+	} else {
+		if cmd == Fixup {
+			if err = writeRebaseState(repo, fixupMsgFile, head.Message()); err != nil {
+				return err
+			}
+		}
+		msg = "# This is a combination of 2 commits.\n# This is the 1st commit message:\n\n" + head.Message()
+	}
+
+	body := c.Message()
+	if cmd == Squash {
+		if err = os.Remove(rebasePath(repo, fixupMsgFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		subject := 0
+		if strings.HasPrefix(body, "squash!") || strings.HasPrefix(body, "fixup!") {
+			subject = len(strings.SplitN(body, "\n\n", 2)[0])
+			if subject < len(body) {
+				subject++
+			}
+		}
+		msg += fmt.Sprintf("\n# This is the commit message #%d:\n\n", count+2) + commentLines(body[:subject]) + body[subject:]
+	} else {
+		msg += fmt.Sprintf("\n# The commit message #%d will be skipped:\n\n", count+2) + commentLines(body)
+	}
+	if err = writeRebaseState(repo, squashMsgFile, msg); err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s %s", cmd, c.ObjectId())
+	if count > 0 {
+		line = "\n" + line
+	}
+	return appendRebaseState(repo, currentFixupsFile, line)
+}
+
+// commentLines comments out the lines of a message with "# ", or with
+// "#" alone if they are blank or start with a tab.
+func commentLines(s string) string {
+	if s == "" {
+		return ""
+	}
+	var out string
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == '\n' || line[0] == '\t' {
+			out += "#" + line
+		} else {
+			out += "# " + line
+		}
+	}
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out
+}
+
+// SquashMessage returns the message that lists the messages of the
+// commits of a chain of fixups and squashes so far, comments and all.
+func SquashMessage(repo *api.DiskRepository) (string, error) {
+	data, err := ioutil.ReadFile(rebasePath(repo, squashMsgFile))
+	return string(data), err
+}
+
+// FixupMessage returns the message of the commit that a chain of
+// fixups was melded into, which the chain keeps as it is, and false if
+// there are squashes in the chain.
+func FixupMessage(repo *api.DiskRepository) (string, bool, error) {
+	data, err := ioutil.ReadFile(rebasePath(repo, fixupMsgFile))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	return string(data), err == nil, err
+}
+
+// ClearSquash forgets about a chain of fixups and squashes once they
+// are done.
+func ClearSquash(repo *api.DiskRepository) error {
+	for _, name := range []string{fixupMsgFile, squashMsgFile, currentFixupsFile} {
+		if err := os.Remove(rebasePath(repo, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ================================================================= //
+// FILES
+// ================================================================= //
+
+func writeRebaseState(repo *api.DiskRepository, name, contents string) error {
+	return ioutil.WriteFile(rebasePath(repo, name), []byte(contents), 0666)
+}
+
+func appendRebaseState(repo *api.DiskRepository, name, contents string) error {
+	f, err := os.OpenFile(rebasePath(repo, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(contents); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readRebaseLine returns the line that a file of the state holds,
+// without its newline.
+func readRebaseLine(repo *api.DiskRepository, name string) (string, error) {
+	data, err := ioutil.ReadFile(rebasePath(repo, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readRebaseOid(repo *api.DiskRepository, name string) (*objects.ObjectId, error) {
+	line, err := readRebaseLine(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := objects.OidFromString(line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid contents: '%s'", path.Join(api.DefaultGitDir, RebaseDir, name))
+	}
+	return oid, nil
+}
@@ -0,0 +1,127 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+rebase_git_test.go implements git-comparison tests for the todo lists and
+the state of rebases, which are checked against what git-rebase leaves
+behind.
+*/
+package sequencer
+
+import (
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test_RebaseTodo compares the todo lists of rebases of topic onto
+// master with the ones that git-rebase backs up, with and without
+// autosquash, which git only does with -i. A command that fails is
+// run after every commit to stop git-rebase early.
+func Test_RebaseTodo(t *testing.T) {
+	dir, err := test.Rebase.Clone("__rebase_todo")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+
+	upstream, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "master")))
+	util.AssertNoErrOrDie(t, err)
+	head, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "topic")))
+	util.AssertNoErrOrDie(t, err)
+	dup, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "topic~6")))
+	util.AssertNoErrOrDie(t, err)
+	for _, autosquash := range []bool{false, true} {
+		args := []string{"rebase", "-x", "false", "master"}
+		if autosquash {
+			args = []string{"-c", "sequence.editor=:", "rebase", "-i", "--autosquash", "-x", "false", "master"}
+		}
+		util.GitExec(dir, args...)
+		util.Assert(t, RebaseInProgress(repo))
+		var expected string
+		for _, line := range strings.SplitAfter(readStateFile(t, dir, RebaseDir, backupFile), "\n") {
+			if line != "\n" && !strings.HasPrefix(line, "#") {
+				expected += line
+			}
+		}
+		util.AssertNoErrOrDie(t, util.GitExecMany(dir, []string{"rebase", "--abort"}))
+
+		todo, skipped, err := RebaseTodo(repo, upstream, head, &RebaseOptions{Autosquash: autosquash, Exec: []string{"false"}})
+		util.AssertNoErrOrDie(t, err)
+		util.AssertEqualString(t, expected, FormatTodo(todo))
+		util.Assert(t, len(skipped) == 1 && skipped[0].ObjectId().String() == dup.ObjectId().String())
+	}
+
+	// a branch that has nothing to rebase has a noop
+	base, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "master~2")))
+	util.AssertNoErrOrDie(t, err)
+	todo, _, err := RebaseTodo(repo, upstream, base, new(RebaseOptions))
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualString(t, "noop\n", FormatTodo(todo))
+}
+
+// Test_RebaseState checks that the state of a rebase that git-rebase
+// stopped at a conflict is read back, and that the same state is
+// written as git writes it.
+func Test_RebaseState(t *testing.T) {
+	dir, err := test.Rebase.Clone("__rebase_state")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+
+	_, err = util.GitExec(dir, "rebase", "master")
+	util.Assert(t, err != nil)
+	util.Assert(t, RebaseInProgress(repo))
+	names := []string{headNameFile, ontoFile, origHeadFile, backupFile, rebaseTodoFile,
+		authorScriptFile, stoppedShaFile, patchFile, messageFile, endFile, msgnumFile}
+	gitFiles := make(map[string]string)
+	for _, name := range names {
+		gitFiles[name] = readStateFile(t, dir, RebaseDir, name)
+	}
+
+	r, err := ReadRebase(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualString(t, "refs/heads/topic", r.HeadName)
+	util.Assert(t, !r.Quiet && !r.KeepRedundant)
+	author, err := ReadAuthorScript(repo)
+	util.AssertNoErrOrDie(t, err)
+	stopped, err := Stopped(repo)
+	util.AssertNoErrOrDie(t, err)
+	c, err := api.CommitFromOid(repo, stopped)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, *author == *c.Author())
+	todo, err := ReadRebaseTodo(repo)
+	util.AssertNoErrOrDie(t, err)
+	done, err := DoneCount(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir, []string{"rebase", "--abort"}))
+	util.Assert(t, !RebaseInProgress(repo))
+
+	upstream, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "master")))
+	util.AssertNoErrOrDie(t, err)
+	head, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "topic")))
+	util.AssertNoErrOrDie(t, err)
+	all, _, err := RebaseTodo(repo, upstream, head, new(RebaseOptions))
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, len(all) == done+len(todo))
+	revisions := r.Onto.String()[:7] + ".." + r.OrigHead.String()[:7]
+	util.AssertNoErrOrDie(t, StartRebase(repo, r, all, revisions))
+	util.Assert(t, StartRebase(repo, r, all, revisions) == ErrRebaseInProgress)
+	util.AssertNoErrOrDie(t, WriteRebaseTodo(repo, todo))
+	util.AssertNoErrOrDie(t, WriteProgress(repo, done, done+len(todo)))
+	util.AssertNoErrOrDie(t, WriteAuthorScript(repo, c.Author()))
+	util.AssertNoErrOrDie(t, WriteStop(repo, c))
+	for _, name := range names {
+		util.AssertEqualString(t, gitFiles[name], readStateFile(t, dir, RebaseDir, name))
+	}
+	util.AssertNoErrOrDie(t, RemoveRebase(repo))
+	util.Assert(t, !RebaseInProgress(repo))
+}
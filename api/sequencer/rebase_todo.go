@@ -0,0 +1,397 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+rebase_todo.go implements the todo lists of rebases, which pick the commits
+of a branch that its upstream doesn't have, oldest first, leaving out the
+ones whose changes the upstream has already. The todo list can move fixups
+and squashes after the commits that they are for, and run shell commands
+after every commit.
+*/
+package sequencer
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"strings"
+)
+
+// ================================================================= //
+// TODO LISTS
+// ================================================================= //
+
+// RebaseOptions control the todo list of a rebase.
+type RebaseOptions struct {
+	// Fork is the commit at which the branch forked from its
+	// upstream, if it is known, whose history is left out of the
+	// rebase along with the upstream's.
+	Fork *objects.Commit
+
+	// Autosquash moves the commits whose subjects start with
+	// "fixup! " or "squash! " after the commits that they name,
+	// as fixups or squashes of them.
+	Autosquash bool
+
+	// Exec are shell commands that are run after every commit.
+	Exec []string
+}
+
+// RebaseTodo returns the todo list of the rebase of a branch, at the
+// given head, onto its upstream, along with the commits that were
+// left out because the upstream has the same changes, as their patch
+// ids show. Merges are left out, and a list that is empty otherwise
+// has a noop. Commits whose changes are empty to begin with are kept,
+// and marked as such.
+func RebaseTodo(repo api.Repository, upstream, head *objects.Commit, opts *RebaseOptions) ([]*Instruction, []*objects.Commit, error) {
+	excluded := make(map[string]bool)
+	visit := func(c *objects.Commit) error {
+		excluded[c.ObjectId().String()] = true
+		return nil
+	}
+	if opts.Fork != nil {
+		if err := api.WalkCommits(repo, []*objects.Commit{opts.Fork}, visit); err != nil {
+			return nil, nil, err
+		}
+	}
+	var right []*objects.Commit
+	inHead := make(map[string]bool)
+	err := api.WalkCommits(repo, []*objects.Commit{head}, func(c *objects.Commit) error {
+		inHead[c.ObjectId().String()] = true
+		if !excluded[c.ObjectId().String()] {
+			right = append(right, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var left []*objects.Commit
+	inUpstream := make(map[string]bool)
+	err = api.WalkCommits(repo, []*objects.Commit{upstream}, func(c *objects.Commit) error {
+		id := c.ObjectId().String()
+		inUpstream[id] = true
+		if !excluded[id] && !inHead[id] {
+			left = append(left, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var walked []*objects.Commit
+	for _, c := range right {
+		if !inUpstream[c.ObjectId().String()] {
+			walked = append(walked, c)
+		}
+	}
+
+	applied := make(map[string]bool)
+	idOpts := &diff.PatchIdOptions{Stable: true}
+	for _, c := range left {
+		id, err := diff.CommitPatchId(repo, c, idOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if id != nil {
+			applied[id.String()] = true
+		}
+	}
+
+	var (
+		todo    []*Instruction
+		skipped []*objects.Commit
+	)
+	sorted := graphOrder(walked)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		c := sorted[i]
+		if len(c.Parents()) > 1 {
+			continue
+		}
+		empty, err := isEmptyCommit(repo, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !empty && len(applied) > 0 {
+			id, err := diff.CommitPatchId(repo, c, idOpts)
+			if err != nil {
+				return nil, nil, err
+			}
+			if applied[id.String()] {
+				skipped = append(skipped, c)
+				continue
+			}
+		}
+		ins := NewRebaseInstruction(Pick, c)
+		if empty {
+			ins.Line += " # empty"
+		}
+		todo = append(todo, ins)
+	}
+	if opts.Autosquash {
+		if todo, err = autosquash(repo, todo); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(opts.Exec) > 0 {
+		todo = addExec(todo, opts.Exec)
+	}
+	if len(todo) == 0 {
+		todo = []*Instruction{{Noop, nil, "", Noop.String()}}
+	}
+	return todo, skipped, nil
+}
+
+// NewRebaseInstruction returns the instruction that applies a command
+// to a commit in a rebase, whose line names the commit by its full
+// oid and its subject, as a single line.
+func NewRebaseInstruction(cmd Command, c *objects.Commit) *Instruction {
+	return &Instruction{cmd, c, "", fmt.Sprintf("%s %s %s", cmd, c.ObjectId(), onelineSubject(c))}
+}
+
+// onelineSubject returns the first paragraph of the message of a
+// commit, with its lines joined by spaces.
+func onelineSubject(c *objects.Commit) string {
+	paragraph := strings.SplitN(c.Message(), "\n\n", 2)[0]
+	return strings.Join(strings.Split(strings.TrimSuffix(paragraph, "\n"), "\n"), " ")
+}
+
+// isEmptyCommit returns true if a commit changes nothing in the tree
+// of its first parent, or in the empty tree if it has none.
+func isEmptyCommit(repo api.Repository, c *objects.Commit) (bool, error) {
+	if len(c.Parents()) == 0 {
+		tree, err := api.TreeFromOid(repo, c.Tree())
+		if err != nil {
+			return false, err
+		}
+		return len(tree.Entries()) == 0, nil
+	}
+	parent, err := api.CommitFromOid(repo, c.Parents()[0])
+	if err != nil {
+		return false, err
+	}
+	return parent.Tree().String() == c.Tree().String(), nil
+}
+
+// graphOrder sorts commits, which are given by date, youngest first,
+// so that every commit comes before its parents, and the commits of
+// a line of history are kept together, as git-rev-list --topo-order
+// does.
+func graphOrder(commits []*objects.Commit) []*objects.Commit {
+	indegree := make(map[string]int)
+	byId := make(map[string]*objects.Commit)
+	for _, c := range commits {
+		indegree[c.ObjectId().String()] = 1
+		byId[c.ObjectId().String()] = c
+	}
+	for _, c := range commits {
+		for _, oid := range c.Parents() {
+			if indegree[oid.String()] > 0 {
+				indegree[oid.String()]++
+			}
+		}
+	}
+
+	// the tips are taken in the order given, and the parents of a
+	// commit right after it
+	var stack []*objects.Commit
+	for i := len(commits) - 1; i >= 0; i-- {
+		if indegree[commits[i].ObjectId().String()] == 1 {
+			stack = append(stack, commits[i])
+		}
+	}
+	sorted := make([]*objects.Commit, 0, len(commits))
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, oid := range c.Parents() {
+			id := oid.String()
+			if indegree[id] == 0 {
+				continue
+			}
+			if indegree[id]--; indegree[id] == 1 {
+				stack = append(stack, byId[id])
+			}
+		}
+		sorted = append(sorted, c)
+	}
+	return sorted
+}
+
+// ================================================================= //
+// AUTOSQUASH
+// ================================================================= //
+
+// the prefixes of the subjects of fixups and squashes
+const (
+	fixupPrefix  = "fixup! "
+	squashPrefix = "squash! "
+)
+
+// skipFixupPrefix returns a subject without its prefix, and true, if
+// it is the subject of a fixup or a squash.
+func skipFixupPrefix(subject string) (string, bool) {
+	for _, prefix := range []string{fixupPrefix, squashPrefix} {
+		if strings.HasPrefix(subject, prefix) {
+			return subject[len(prefix):], true
+		}
+	}
+	return subject, false
+}
+
+// autosquash moves fixups and squashes after the commits that they
+// are for, which are found by their subjects, by their names, or by
+// the start of their subjects, in that order. Fixups and squashes of
+// the same commit keep their order.
+func autosquash(repo api.Repository, todo []*Instruction) ([]*Instruction, error) {
+	n := len(todo)
+	next, tail := make([]int, n), make([]int, n)
+	subjects := make([]string, n)
+	bySubject := make(map[string]int)
+	byCommit := make(map[string]int)
+	rearranged := false
+	for i, ins := range todo {
+		next[i], tail[i] = -1, -1
+		if ins.Commit == nil {
+			continue
+		}
+		subject := onelineSubject(ins.Commit)
+		subjects[i] = subject
+		target := -1
+		if p, ok := skipFixupPrefix(subject); ok {
+			for {
+				p = strings.TrimLeft(p, " \t\n\v\f\r")
+				if p, ok = skipFixupPrefix(p); !ok {
+					break
+				}
+			}
+			if j, ok := bySubject[p]; ok {
+				target = j
+			} else if j, ok := commitIndex(repo, byCommit, p); ok {
+				target = j
+			} else {
+				for j := 0; j < i; j++ {
+					if subjects[j] != "" && strings.HasPrefix(subjects[j], p) {
+						target = j
+						break
+					}
+				}
+			}
+		}
+		if target >= 0 {
+			rearranged = true
+			if strings.HasPrefix(subject, "fixup!") {
+				ins = &Instruction{Fixup, ins.Commit, "", strings.Replace(ins.Line, Pick.String(), Fixup.String(), 1)}
+			} else {
+				ins = &Instruction{Squash, ins.Commit, "", strings.Replace(ins.Line, Pick.String(), Squash.String(), 1)}
+			}
+			todo[i] = ins
+			if tail[target] < 0 {
+				next[i], next[target] = next[target], i
+			} else {
+				next[i], next[tail[target]] = next[tail[target]], i
+			}
+			tail[target] = i
+		} else if _, ok := bySubject[subject]; !ok {
+			bySubject[subject] = i
+		}
+		byCommit[ins.Commit.ObjectId().String()] = i
+	}
+	if !rearranged {
+		return todo, nil
+	}
+	squashed := make([]*Instruction, 0, n)
+	for i, ins := range todo {
+		if ins.Command == Fixup || ins.Command == Squash {
+			continue
+		}
+		for j := i; j >= 0; j = next[j] {
+			squashed = append(squashed, todo[j])
+		}
+	}
+	return squashed, nil
+}
+
+// commitIndex returns the index of the instruction of the commit that
+// a name resolves to, if the name is a single word and the commit was
+// seen already.
+func commitIndex(repo api.Repository, seen map[string]int, name string) (int, bool) {
+	if strings.Contains(name, " ") {
+		return 0, false
+	}
+
+	// most subjects are not revisions, which is not worth a word
+	o, err := api.LookupRevision(repo, name)
+	if err != nil {
+		return 0, false
+	}
+	c, err := api.CommitFromObject(repo, o)
+	if err != nil {
+		return 0, false
+	}
+	i, ok := seen[c.ObjectId().String()]
+	return i, ok
+}
+
+// ================================================================= //
+// EXEC
+// ================================================================= //
+
+// addExec adds instructions that run shell commands after every pick,
+// and the fixups and squashes that follow it.
+func addExec(todo []*Instruction, commands []string) []*Instruction {
+	var execs []*Instruction
+	for _, cmd := range commands {
+		execs = append(execs, &Instruction{Exec, nil, cmd, fmt.Sprintf("%s %s", Exec, cmd)})
+	}
+	var out []*Instruction
+	insert := false
+	for _, ins := range todo {
+		if insert && ins.Command != Fixup && ins.Command != Squash {
+			out = append(out, execs...)
+			insert = false
+		}
+		out = append(out, ins)
+		if ins.Command == Pick {
+			insert = true
+		}
+	}
+	if insert {
+		out = append(out, execs...)
+	}
+	return out
+}
+
+// ================================================================= //
+// FAST-FORWARDS
+// ================================================================= //
+
+// FastForwardPicks returns the number of picks that a todo list
+// starts with, noops aside, that are already on top of the commit
+// that it is rebased onto, one after the other, so that they need no
+// picking, and the last of them, which the rest of the todo list is
+// rebased onto instead.
+func FastForwardPicks(todo []*Instruction, onto *objects.ObjectId) (int, *objects.ObjectId) {
+	i := 0
+	for ; i < len(todo); i++ {
+		ins := todo[i]
+		if ins.Command == Noop {
+			continue
+		}
+		if ins.Command != Pick {
+			break
+		}
+		parents := ins.Commit.Parents()
+		if len(parents) != 1 || parents[0].String() != onto.String() {
+			break
+		}
+		onto = ins.Commit.ObjectId()
+	}
+	return i, onto
+}
@@ -0,0 +1,339 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+sequencer.go implements the state of a sequence of picks or reverts that
+is in progress, which git keeps in the .git/sequencer directory. The
+directory holds the commit that HEAD was at when the sequence started,
+in "head", the instructions that are left, in "todo", the options of the
+sequence, in "opts", and the commit that HEAD was last seen at, in
+"abort-safety", so that the sequence isn't rolled back over commits that
+were made since.
+*/
+package sequencer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// INSTRUCTIONS
+// ================================================================= //
+
+// Command is what an instruction does with its commit.
+type Command int
+
+const (
+	Pick   Command = iota // the changes of the commit are applied
+	Revert                // the changes of the commit are undone
+	Fixup                 // the commit is melded into the one before
+	Squash                // so is its message
+	Exec                  // a shell command is run
+	Noop                  // nothing is done
+)
+
+// the names of the commands, and their abbreviations
+var commandNames = []struct{ name, abbrev string }{
+	{"pick", "p"},
+	{"revert", ""},
+	{"fixup", "f"},
+	{"squash", "s"},
+	{"exec", "x"},
+	{"noop", ""},
+}
+
+// String returns the name of a command, as in "pick".
+func (c Command) String() string {
+	return commandNames[c].name
+}
+
+// parseCommand returns the command of a word of an instruction,
+// which is the name of the command or its abbreviation.
+func parseCommand(word string) (Command, bool) {
+	for i, names := range commandNames {
+		if word == names.name || word != "" && word == names.abbrev {
+			return Command(i), true
+		}
+	}
+	return 0, false
+}
+
+// Instruction is a line of a todo list, which applies a command to
+// a commit, or runs the shell command of an exec.
+type Instruction struct {
+	Command Command
+	Commit  *objects.Commit
+
+	// Arg is the shell command of an exec.
+	Arg string
+
+	// Line is the line of the instruction as it is written,
+	// without its newline.
+	Line string
+}
+
+// NewInstruction returns the instruction that applies a command to
+// a commit, whose line names the commit by its abbreviated oid and
+// its subject.
+func NewInstruction(cmd Command, c *objects.Commit) *Instruction {
+	line := fmt.Sprintf("%s %s %s", cmd, c.ObjectId().String()[:7], Subject(c))
+	return &Instruction{cmd, c, "", line}
+}
+
+// ParseTodo parses a todo list, in which blank lines and lines that
+// start with '#' are skipped, and every other line is an instruction
+// whose command is followed by the oid of a commit, which may be
+// abbreviated, except for an exec, which is followed by its shell
+// command, and a noop.
+func ParseTodo(repo *api.DiskRepository, data string) ([]*Instruction, error) {
+	var todo []*Instruction
+	for i, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		cmd, ok := parseCommand(fields[0])
+		switch {
+		case ok && cmd == Noop:
+			todo = append(todo, &Instruction{cmd, nil, "", line})
+			continue
+		case ok && cmd == Exec && len(fields) > 1:
+			arg := strings.TrimLeft(strings.TrimLeft(line, " \t")[len(fields[0]):], " \t")
+			todo = append(todo, &Instruction{cmd, nil, arg, line})
+			continue
+		case !ok || cmd == Exec || len(fields) < 2:
+			return nil, fmt.Errorf("invalid line %d: %s", i+1, line)
+		}
+		o, err := repo.ObjectFromShortOid(fields[1])
+		var c *objects.Commit
+		if err == nil {
+			c, err = api.CommitFromObject(repo, o)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d: %s", i+1, line)
+		}
+		todo = append(todo, &Instruction{cmd, c, "", line})
+	}
+	return todo, nil
+}
+
+// FormatTodo returns a todo list as it is written, one instruction
+// to a line.
+func FormatTodo(todo []*Instruction) string {
+	buf := new(bytes.Buffer)
+	for _, ins := range todo {
+		fmt.Fprintln(buf, ins.Line)
+	}
+	return buf.String()
+}
+
+// ================================================================= //
+// OPTIONS
+// ================================================================= //
+
+// Options are the options of a sequence that are kept while it is
+// in progress.
+type Options struct {
+	// NoCommit applies the changes to the index and the working
+	// tree without committing them.
+	NoCommit bool
+
+	// RecordOrigin adds a line naming the commit that is picked
+	// to the message of the commit that picks it.
+	RecordOrigin bool
+
+	// Mainline is the number of the parent of merge commits that
+	// their changes are taken against, counting from 1, or 0.
+	Mainline int
+}
+
+// formatOptions returns the options as git writes them, in the
+// format of configuration files, or "" if none of them are set.
+func formatOptions(opts *Options) string {
+	if *opts == (Options{}) {
+		return ""
+	}
+	s := "[options]\n"
+	if opts.NoCommit {
+		s += "\tno-commit = true\n"
+	}
+	if opts.RecordOrigin {
+		s += "\trecord-origin = true\n"
+	}
+	if opts.Mainline > 0 {
+		s += fmt.Sprintf("\tmainline = %d\n", opts.Mainline)
+	}
+	return s
+}
+
+// ================================================================= //
+// STATE
+// ================================================================= //
+
+// Dir is the directory of the repository that keeps the state of
+// the sequence that is in progress.
+const Dir = "sequencer"
+
+// the files of the state of a sequence
+const (
+	headFile        = "head"
+	todoFile        = "todo"
+	optsFile        = "opts"
+	abortSafetyFile = "abort-safety"
+)
+
+// the oid of the head of a sequence that started on an unborn branch
+var nullOid = objects.OidFromArray([objects.OidSize]byte{})
+
+// ErrInProgress is returned when a sequence is started while one is
+// in progress already.
+var ErrInProgress = errors.New("a cherry-pick or revert is already in progress")
+
+func statePath(repo *api.DiskRepository, name string) string {
+	return path.Join(repo.Path(), Dir, name)
+}
+
+// InProgress returns true if a sequence is in progress.
+func InProgress(repo *api.DiskRepository) bool {
+	_, err := os.Stat(path.Join(repo.Path(), Dir))
+	return err == nil
+}
+
+// Start records the state of a new sequence: the commit that HEAD
+// is at, which is nil on an unborn branch, the instructions and the
+// options.
+func Start(repo *api.DiskRepository, head *objects.ObjectId, todo []*Instruction, opts *Options) error {
+	if err := os.Mkdir(path.Join(repo.Path(), Dir), 0777); os.IsExist(err) {
+		return ErrInProgress
+	} else if err != nil {
+		return err
+	}
+	oid := nullOid
+	if head != nil {
+		oid = head
+	}
+	if err := writeState(repo, headFile, oid.String()+"\n"); err != nil {
+		return err
+	}
+	if err := WriteTodo(repo, todo); err != nil {
+		return err
+	}
+	if s := formatOptions(opts); s != "" {
+		return writeState(repo, optsFile, s)
+	}
+	return nil
+}
+
+// Remove forgets about the sequence that is in progress.
+func Remove(repo *api.DiskRepository) error {
+	return os.RemoveAll(path.Join(repo.Path(), Dir))
+}
+
+// Head returns the commit that HEAD was at when the sequence that is
+// in progress started, or nil if the branch was yet to be born.
+func Head(repo *api.DiskRepository) (*objects.ObjectId, error) {
+	data, err := ioutil.ReadFile(statePath(repo, headFile))
+	if err != nil {
+		return nil, err
+	}
+	oid, err := objects.OidFromString(strings.TrimSuffix(string(data), "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("stored pre-cherry-pick HEAD file '%s' is corrupt", path.Join(api.DefaultGitDir, Dir, headFile))
+	}
+	if oid.String() == nullOid.String() {
+		return nil, nil
+	}
+	return oid, nil
+}
+
+// ReadTodo returns the instructions that are left of the sequence
+// that is in progress.
+func ReadTodo(repo *api.DiskRepository) ([]*Instruction, error) {
+	data, err := ioutil.ReadFile(statePath(repo, todoFile))
+	if err != nil {
+		return nil, err
+	}
+	return ParseTodo(repo, string(data))
+}
+
+// WriteTodo replaces the instructions that are left.
+func WriteTodo(repo *api.DiskRepository, todo []*Instruction) error {
+	return writeState(repo, todoFile, FormatTodo(todo))
+}
+
+// LastCommand returns the command of the next instruction of the
+// sequence that is in progress, and false if there is none.
+func LastCommand(repo *api.DiskRepository) (Command, bool) {
+	data, err := ioutil.ReadFile(statePath(repo, todoFile))
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	return parseCommand(fields[0])
+}
+
+// ReadOptions returns the options of the sequence that is in
+// progress.
+func ReadOptions(repo *api.DiskRepository) (*Options, error) {
+	config := new(api.Config)
+	if err := config.ReadFile(statePath(repo, optsFile)); err != nil {
+		return nil, err
+	}
+	opts := new(Options)
+	var err error
+	if opts.NoCommit, err = config.Bool("options.no-commit", false); err != nil {
+		return nil, err
+	}
+	if opts.RecordOrigin, err = config.Bool("options.record-origin", false); err != nil {
+		return nil, err
+	}
+	if opts.Mainline, err = config.Int("options.mainline", 0); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// UpdateAbortSafety records the commit that HEAD is at, which is nil
+// on an unborn branch, unless no sequence is in progress.
+func UpdateAbortSafety(repo *api.DiskRepository, head *objects.ObjectId) error {
+	if !InProgress(repo) {
+		return nil
+	}
+	oid := ""
+	if head != nil {
+		oid = head.String()
+	}
+	return writeState(repo, abortSafetyFile, oid+"\n")
+}
+
+// AbortIsSafe returns true if HEAD, which is nil on an unborn branch,
+// is where the sequence last left it, so that rolling the sequence
+// back loses no commits.
+func AbortIsSafe(repo *api.DiskRepository, head *objects.ObjectId) bool {
+	data, err := ioutil.ReadFile(statePath(repo, abortSafetyFile))
+	expected := strings.TrimSpace(string(data))
+	if err != nil || expected == "" {
+		return head == nil
+	}
+	return head != nil && head.String() == expected
+}
+
+func writeState(repo *api.DiskRepository, name, contents string) error {
+	return ioutil.WriteFile(statePath(repo, name), []byte(contents), 0666)
+}
@@ -0,0 +1,189 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+sequencer_git_test.go implements git-comparison tests for picks, reverts
+and the state of sequences, which are checked against what git-cherry-pick
+and git-revert leave behind.
+*/
+package sequencer
+
+import (
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/merge"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/test"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// readStateFile returns the contents of a file of the state that is
+// kept in the given directory of .git while a sequence is in
+// progress, or dies.
+func readStateFile(t *testing.T, dir, stateDir, name string) string {
+	data, err := ioutil.ReadFile(path.Join(dir, ".git", stateDir, name))
+	util.AssertNoErrOrDie(t, err)
+	return string(data)
+}
+
+// Test_Apply compares picks and reverts of commits with the ones
+// of git-cherry-pick -n and git-revert -n, by the trees that they
+// merged and the messages that they left.
+func Test_Apply(t *testing.T) {
+	dir, err := test.Picks.Clone("__sequencer_apply")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+
+	cases := []struct {
+		cmd          Command
+		rev          string
+		mainline     int
+		recordOrigin bool
+	}{
+		{Pick, "master~3", 0, false},
+		{Pick, "master~2", 0, true},
+		{Pick, "master~1", 0, false},
+		{Pick, "master~1", 0, true},
+		{Pick, "side", 0, true},
+		{Pick, "master", 1, false},
+		{Pick, "master", 2, false},
+		{Revert, "master~3", 0, false},
+		{Revert, "other", 0, false},
+		{Revert, "master", 1, false},
+	}
+	for _, c := range cases {
+		util.AssertNoErrOrDie(t, util.GitExecMany(dir,
+			[]string{"reset", "-q", "--hard", "other"},
+		))
+		args := []string{replayCommand(c.cmd), "-n"}
+		if c.mainline > 0 {
+			args = append(args, "-m", string('0'+rune(c.mainline)))
+		}
+		if c.recordOrigin {
+			args = append(args, "-x")
+		}
+		_, err := util.GitExec(dir, append(args, c.rev)...)
+		clean := err == nil
+
+		commit, e := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, c.rev)))
+		util.AssertNoErrOrDie(t, e)
+		other, e := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "other")))
+		util.AssertNoErrOrDie(t, e)
+		ours, e := api.TreeFromOid(repo, other.Tree())
+		util.AssertNoErrOrDie(t, e)
+		parent, e := Parent(repo, commit, c.mainline)
+		util.AssertNoErrOrDie(t, e)
+		r, e := Apply(repo, c.cmd, commit, parent, ours, &merge.Options{Renames: merge.NewRenameOptions()})
+		util.AssertNoErrOrDie(t, e)
+
+		autoMerge, e := ioutil.ReadFile(path.Join(dir, ".git", "AUTO_MERGE"))
+		util.AssertNoErrOrDie(t, e)
+		util.AssertEqualString(t, string(autoMerge), r.Tree.ObjectId().String()+"\n")
+		util.Assertf(t, r.Clean == clean, "%s %s: expected the merge to be clean only if git's is", c.cmd, c.rev)
+		msg, e := ioutil.ReadFile(path.Join(dir, ".git", "MERGE_MSG"))
+		util.AssertNoErrOrDie(t, e)
+		expected := strings.SplitN(string(msg), "\n# Conflicts:\n", 2)[0]
+		util.AssertEqualString(t, expected, Message(c.cmd, commit, parent, c.recordOrigin))
+	}
+}
+
+// replayCommand returns the git command that replays commits by a
+// command.
+func replayCommand(cmd Command) string {
+	if cmd == Revert {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// Test_Parent checks the errors of parents that merges and other
+// commits don't have.
+func Test_Parent(t *testing.T) {
+	dir := test.Picks.Repo()
+	repo := api.Open(dir)
+
+	merged, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "master")))
+	util.AssertNoErrOrDie(t, err)
+	root, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, "master~3")))
+	util.AssertNoErrOrDie(t, err)
+	for _, c := range []struct {
+		commit   *objects.Commit
+		mainline int
+		err      string
+	}{
+		{merged, 0, "is a merge but no -m option was given."},
+		{merged, 3, "does not have parent 3"},
+		{root, 2, "does not have parent 2"},
+	} {
+		_, err = Parent(repo, c.commit, c.mainline)
+		util.Assert(t, err != nil && strings.HasSuffix(err.Error(), c.err))
+	}
+	parent, err := Parent(repo, root, 1)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, parent == nil)
+}
+
+// Test_State checks that the state of a sequence that git-cherry-pick
+// stopped is read back, and that the same sequence is written as git
+// writes it.
+func Test_State(t *testing.T) {
+	dir, err := test.Picks.Clone("__sequencer_state")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := api.Open(dir)
+
+	// the first commit picks cleanly, and the second one conflicts
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir, []string{"checkout", "-q", "other"}))
+	_, err = util.GitExec(dir, "cherry-pick", "-x", "-m", "1", "master~2", "master~1", "master")
+	util.Assert(t, err != nil)
+	util.Assert(t, InProgress(repo))
+
+	read := func(name string) string {
+		return readStateFile(t, dir, Dir, name)
+	}
+	gitTodo, gitHead, gitOpts := read(todoFile), read(headFile), read(optsFile)
+	todo, err := ReadTodo(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, len(todo) == 2)
+	cmd, ok := LastCommand(repo)
+	util.Assert(t, ok && cmd == Pick)
+	opts, err := ReadOptions(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, *opts == Options{RecordOrigin: true, Mainline: 1})
+	head, err := Head(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.AssertEqualString(t, gitHead, head.String()+"\n")
+
+	// HEAD has moved on by the commit that was picked
+	_, current, err := api.Head(repo)
+	util.AssertNoErrOrDie(t, err)
+	util.Assert(t, AbortIsSafe(repo, current))
+	util.Assert(t, !AbortIsSafe(repo, head))
+
+	util.AssertNoErrOrDie(t, util.GitExecMany(dir, []string{"cherry-pick", "--abort"}))
+	util.Assert(t, !InProgress(repo))
+	var all []*Instruction
+	for _, rev := range []string{"master~2", "master~1", "master"} {
+		c, err := api.CommitFromOid(repo, objects.OidNow(util.RevOid(dir, rev)))
+		util.AssertNoErrOrDie(t, err)
+		all = append(all, NewInstruction(Pick, c))
+	}
+	util.AssertNoErrOrDie(t, Start(repo, head, all, opts))
+	util.Assert(t, Start(repo, head, all, opts) == ErrInProgress)
+	util.AssertNoErrOrDie(t, WriteTodo(repo, all[1:]))
+	util.AssertEqualString(t, gitTodo, read(todoFile))
+	util.AssertEqualString(t, gitHead, read(headFile))
+	util.AssertEqualString(t, gitOpts, read(optsFile))
+	util.AssertNoErrOrDie(t, Remove(repo))
+	util.Assert(t, !InProgress(repo))
+}
@@ -0,0 +1,32 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+//go:build darwin
+// +build darwin
+
+package api
+
+import (
+	"os"
+	"syscall"
+)
+
+// fillStatInfo copies the stat data that the index keeps
+// from the file info, truncating it to 32 bits as git does.
+func fillStatInfo(stat *StatInfo, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		fillModTime(stat, info)
+		return
+	}
+	stat.CTimeSecs, stat.CTimeNanos = int32(st.Ctimespec.Sec), int32(st.Ctimespec.Nsec)
+	stat.MTimeSecs, stat.MTimeNanos = int32(st.Mtimespec.Sec), int32(st.Mtimespec.Nsec)
+	stat.Dev, stat.Ino = int32(st.Dev), int32(st.Ino)
+	stat.Uid, stat.Gid = int32(st.Uid), int32(st.Gid)
+	stat.Size = int32(st.Size)
+}
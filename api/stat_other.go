@@ -0,0 +1,21 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package api
+
+import "os"
+
+// fillStatInfo copies the stat data that the index keeps from
+// the file info. Only the time of modification and the size are
+// known on this platform.
+func fillStatInfo(stat *StatInfo, info os.FileInfo) {
+	fillModTime(stat, info)
+}
@@ -0,0 +1,38 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+tags.go implements the writing of annotated tags.
+*/
+package api
+
+import (
+	"github.com/jbrukh/ggit/api/format"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// ================================================================= //
+// TAG WRITING
+// ================================================================= //
+
+// WriteTag stores an annotated tag of an object, with the given
+// name, tagger and message, and returns it. The tag isn't pointed
+// at by any ref.
+func WriteTag(repo *DiskRepository, o objects.Object, name string, tagger *objects.WhoWhen, msg string) (*objects.Tag, error) {
+	target, otype := o.ObjectId(), o.Header().Type()
+	f := format.NewStrFormat()
+	if _, err := f.Tag(objects.NewTag(nil, target, otype, nil, name, msg, tagger)); err != nil {
+		return nil, err
+	}
+	hdr := objects.NewObjectHeader(objects.ObjectTag, int64(len(f.String())))
+	oid, err := repo.WriteObject(objects.NewTag(nil, target, otype, hdr, name, msg, tagger))
+	if err != nil {
+		return nil, err
+	}
+	return objects.NewTag(oid, target, otype, hdr, name, msg, tagger), nil
+}
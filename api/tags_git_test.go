@@ -7,7 +7,8 @@
 //
 
 /*
-tags_git_test.go implements git comparison tests for tag reading.
+tags_git_test.go implements git comparison tests for tag reading and
+writing.
 */
 package api
 
@@ -16,6 +17,9 @@ import (
 	"github.com/jbrukh/ggit/api/objects"
 	"github.com/jbrukh/ggit/test"
 	"github.com/jbrukh/ggit/util"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -63,3 +67,35 @@ func Test_readTags(t *testing.T) {
 		util.AssertEqualString(t, detail.TagRepr, f.String())
 	}
 }
+
+// Test_writeTag compares the tags that we write with the ones of
+// git-tag -a.
+func Test_writeTag(t *testing.T) {
+	dir, err := test.Linear.Clone("__write_tag")
+	util.AssertNoErrOrDie(t, err)
+	defer os.RemoveAll(dir)
+	repo := Open(dir)
+	who := objects.NewWhoWhen("A U Thor", "author@example.com", 1300000000, -300)
+
+	for _, c := range []struct {
+		rev, name, msg string
+	}{
+		{"HEAD", "v1", "a message\n\nwith a body\n"},
+		{"HEAD^{tree}", "tree", ""},
+		{"v1", "nested", "a tag of a tag\n"},
+	} {
+		cmd := exec.Command("git", "tag", "-a", "-m", c.msg, c.name, c.rev)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME="+who.Name(), "GIT_COMMITTER_EMAIL="+who.Email(),
+			"GIT_COMMITTER_DATE=1300000000 -0500")
+		util.AssertNoErrOrDie(t, cmd.Run())
+		expected := strings.TrimSpace(util.GitNow(dir, "rev-parse", c.name))
+
+		o, err := ObjectFromRevision(repo, c.rev)
+		util.AssertNoErrOrDie(t, err)
+		tag, err := WriteTag(repo, o, c.name, who, c.msg)
+		util.AssertNoErrOrDie(t, err)
+		util.AssertEqualString(t, expected, tag.ObjectId().String())
+		util.AssertEqualString(t, o.ObjectId().String(), tag.Object().String())
+	}
+}
@@ -0,0 +1,219 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+trees.go implements operations for resolving and navigating tree objects.
+*/
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jbrukh/ggit/api/objects"
+	"sort"
+	"strings"
+)
+
+// SkipTree is used as a return value from TreeVisitor to
+// indicate that the tree named in the call is not to be
+// descended into. It is not returned as an error by any
+// function.
+var SkipTree = errors.New("skip this tree")
+
+// TreeVisitor is the type of the function called for each
+// entry visited by WalkTree. The path argument is the full
+// path of the entry, relative to the root of the walk, with
+// components separated by '/'.
+//
+// If the function returns SkipTree while visiting a tree
+// entry, WalkTree will not descend into that tree. Any other
+// non-nil error stops the walk and is returned by WalkTree.
+type TreeVisitor func(path string, e *objects.TreeEntry) error
+
+// ================================================================= //
+// TREE OPERATIONS
+// ================================================================= //
+
+// TreeFromObject returns the tree being referred to. Trees are
+// returned as they are, commits yield their root tree, and
+// annotated tags are peeled until a tree or commit is found.
+// Blobs cause an error to be returned.
+func TreeFromObject(repo Repository, o objects.Object) (*objects.Tree, error) {
+	for {
+		switch t := o.(type) {
+		case *objects.Tree:
+			return t, nil
+		case *objects.Commit:
+			return TreeFromOid(repo, t.Tree())
+		case *objects.Tag:
+			var err error
+			if o, err = repo.ObjectFromOid(t.Object()); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("not a tree object")
+		}
+	}
+}
+
+// TreeFromOid takes an oid and turns it into a tree object,
+// peeling commits and tags as in TreeFromObject.
+func TreeFromOid(repo Repository, oid *objects.ObjectId) (*objects.Tree, error) {
+	o, err := repo.ObjectFromOid(oid)
+	if err != nil {
+		return nil, err
+	}
+	return TreeFromObject(repo, o)
+}
+
+// TreeFromRevision resolves a revision specification, such
+// as HEAD~2, into the tree that it refers to.
+func TreeFromRevision(repo Repository, rev string) (*objects.Tree, error) {
+	o, err := ObjectFromRevision(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	return TreeFromObject(repo, o)
+}
+
+// TreeEntryFromPath finds the entry with the given path,
+// which is relative to the tree and separated by slashes, by
+// walking down through the subtrees along the way.
+func TreeEntryFromPath(repo Repository, t *objects.Tree, pth string) (*objects.TreeEntry, error) {
+	parts := strings.Split(strings.Trim(pth, "/"), "/")
+	for i, name := range parts {
+		var found *objects.TreeEntry
+		for _, e := range t.Entries() {
+			if e.Name() == name {
+				found = e
+				break
+			}
+		}
+		if found == nil {
+			break
+		}
+		if i == len(parts)-1 {
+			return found, nil
+		}
+		if found.ObjectType() != objects.ObjectTree {
+			break
+		}
+		var err error
+		if t, err = TreeFromOid(repo, found.ObjectId()); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("path '%s' does not exist in the tree", pth)
+}
+
+// WalkTree walks the given tree in depth-first order, calling
+// f for each entry, including subtree entries themselves. The
+// entries of each tree are visited in the order in which
+// they are stored, which is git's canonical order. The base
+// is prepended to every path given to the visitor; it may be
+// empty.
+func WalkTree(repo Repository, t *objects.Tree, base string, f TreeVisitor) error {
+	for _, e := range t.Entries() {
+		pth := joinPath(base, e.Name())
+		err := f(pth, e)
+		if e.ObjectType() != objects.ObjectTree {
+			if err != nil && err != SkipTree {
+				return err
+			}
+			continue
+		}
+		if err == SkipTree {
+			continue
+		} else if err != nil {
+			return err
+		}
+		subtree, err := TreeFromOid(repo, e.ObjectId())
+		if err != nil {
+			return err
+		}
+		if err = WalkTree(repo, subtree, pth, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ================================================================= //
+// TREE WRITING
+// ================================================================= //
+
+// WriteTree stores the tree of the given entries, which are named
+// by their paths, along with the subtrees that their paths need,
+// and returns it. Entries may themselves be trees, which are used
+// as they are.
+func WriteTree(repo *DiskRepository, entries []*objects.TreeEntry) (*objects.Tree, error) {
+	var own []*objects.TreeEntry
+	var dirs []string
+	subs := make(map[string][]*objects.TreeEntry)
+	for _, e := range entries {
+		i := strings.IndexByte(e.Name(), '/')
+		if i < 0 {
+			own = append(own, e)
+			continue
+		}
+		dir := e.Name()[:i]
+		if _, ok := subs[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		subs[dir] = append(subs[dir], objects.NewTreeEntry(e.Mode(), e.ObjectType(), e.Name()[i+1:], e.ObjectId()))
+	}
+	for _, dir := range dirs {
+		t, err := WriteTree(repo, subs[dir])
+		if err != nil {
+			return nil, err
+		}
+		own = append(own, objects.NewTreeEntry(objects.ModeTree, objects.ObjectTree, dir, t.ObjectId()))
+	}
+	sort.Sort(treeEntryOrder(own))
+
+	size := 0
+	for _, e := range own {
+		size += len(fmt.Sprintf("%o %s", e.Mode(), e.Name())) + 1 + objects.OidSize
+	}
+	hdr := objects.NewObjectHeader(objects.ObjectTree, int64(size))
+	oid, err := repo.WriteObject(objects.NewTree(nil, hdr, own))
+	if err != nil {
+		return nil, err
+	}
+	return objects.NewTree(oid, hdr, own), nil
+}
+
+// treeEntryOrder sorts the entries of a tree in git's order, in
+// which the names of trees are compared as if they ended in a
+// slash.
+type treeEntryOrder []*objects.TreeEntry
+
+func (s treeEntryOrder) Len() int      { return len(s) }
+func (s treeEntryOrder) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s treeEntryOrder) Less(i, j int) bool {
+	name := func(e *objects.TreeEntry) string {
+		if e.ObjectType() == objects.ObjectTree {
+			return e.Name() + "/"
+		}
+		return e.Name()
+	}
+	return name(s[i]) < name(s[j])
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+// joinPath joins a base path and a name with a '/' separator,
+// unless the base is empty.
+func joinPath(base, name string) string {
+	if base == "" {
+		return name
+	}
+	return base + "/" + name
+}
@@ -65,3 +65,38 @@ func Test_readTree(t *testing.T) {
 	f.Object(o)
 	util.AssertEqualString(t, info.TreeRepr, f.String())
 }
+
+// Test_walkTree will compare the paths visited by WalkTree
+// with the structure of the test tree.
+func Test_walkTree(t *testing.T) {
+	testCase := test.Tree
+	repo := Open(testCase.Repo())
+	info := testCase.Info().(*test.InfoTree)
+
+	tree, err := TreeFromRevision(repo, info.CommitOid)
+	util.AssertNoErr(t, err)
+	util.AssertEqualString(t, info.TreeOid, tree.ObjectId().String())
+
+	// visit everything
+	var visited []string
+	err = WalkTree(repo, tree, "", func(pth string, e *objects.TreeEntry) error {
+		visited = append(visited, pth)
+		return nil
+	})
+	util.AssertNoErr(t, err)
+	expected := []string{"1.txt", "2.txt", "3.txt", "4", "4/haha.txt", "5", "5/hehe.txt"}
+	util.AssertEqualInt(t, len(expected), len(visited))
+	for i := range expected {
+		util.AssertEqualString(t, expected[i], visited[i])
+	}
+
+	// skip all subtrees
+	visited = nil
+	err = WalkTree(repo, tree, "base", func(pth string, e *objects.TreeEntry) error {
+		visited = append(visited, pth)
+		return SkipTree
+	})
+	util.AssertNoErr(t, err)
+	util.AssertEqualInt(t, info.N, len(visited))
+	util.AssertEqualString(t, "base/4", visited[3])
+}
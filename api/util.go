@@ -22,7 +22,18 @@ var sha hash.Hash = sha1.New()
 
 // produce the SHA1 hash for any Object.
 func MakeHash(o objects.Object) (hash.Hash, error) {
+	toHash, err := encodeObject(o)
+	if err != nil {
+		return nil, err
+	}
 	sha.Reset()
+	sha.Write(toHash)
+	return sha, nil
+}
+
+// encodeObject returns an object as git hashes and stores
+// it: its type and size, followed by its contents.
+func encodeObject(o objects.Object) ([]byte, error) {
 	kind := string(o.Header().Type())
 	f := format.NewStrFormat()
 	if _, err := f.Object(o); err != nil {
@@ -31,9 +42,15 @@ func MakeHash(o objects.Object) (hash.Hash, error) {
 	content := f.String()
 	len := len([]byte(content))
 	value := kind + string(token.SP) + fmt.Sprint(len) + string(token.NUL) + content
-	toHash := []byte(value)
-	sha.Write(toHash)
-	return sha, nil
+	return []byte(value), nil
+}
+
+// BlobOid returns the oid that a blob with the given
+// contents has.
+func BlobOid(data []byte) *objects.ObjectId {
+	hdr := objects.NewObjectHeader(objects.ObjectBlob, int64(len(data)))
+	h, _ := MakeHash(objects.NewBlob(nil, hdr, data)) // blobs always format
+	return objects.OidFromHash(h)
 }
 
 func min(a, b int) int {
@@ -0,0 +1,420 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+work_tree.go implements operations that compare the working tree of a
+disk repository against the entries of its index, and that write the
+files of the working tree.
+*/
+package api
+
+import (
+	"errors"
+	"github.com/jbrukh/ggit/api/objects"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// ================================================================= //
+// WORK TREE
+// ================================================================= //
+
+// WorkStatus describes the state of a working tree file
+// with respect to its index entry.
+type WorkStatus int
+
+const (
+	WorkUnmodified WorkStatus = iota
+	WorkModified
+	WorkDeleted
+)
+
+// WorkTree provides access to the files in the working
+// directory of a disk repository.
+type WorkTree struct {
+	repo      *DiskRepository
+	indexTime time.Time
+}
+
+// NewWorkTree returns the working tree of the repository.
+func NewWorkTree(repo *DiskRepository) *WorkTree {
+	wt := &WorkTree{repo: repo}
+	if info, err := os.Stat(path.Join(repo.path, IndexFile)); err == nil {
+		wt.indexTime = info.ModTime()
+	}
+	return wt
+}
+
+// Dir returns the top directory of the working tree.
+func (wt *WorkTree) Dir() string {
+	return wt.repo.WorkDir()
+}
+
+// Path returns the location on disk of a file that is
+// named relative to the top of the working tree.
+func (wt *WorkTree) Path(name string) string {
+	return filepath.Join(wt.Dir(), filepath.FromSlash(name))
+}
+
+// Lstat returns the file info of a file in the working tree
+// without following symbolic links.
+func (wt *WorkTree) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(wt.Path(name))
+}
+
+// ReadFile returns the contents of a file in the working tree
+// as they would be stored in a blob, along with the mode that
+// the file would have in a tree. Symbolic links are read as
+// the path that they point to.
+func (wt *WorkTree) ReadFile(name string) ([]byte, objects.FileMode, error) {
+	info, err := wt.Lstat(name)
+	if err != nil {
+		return nil, objects.ModeNew, err
+	}
+	mode := FileModeFromInfo(info)
+	if mode == objects.ModeLink {
+		target, err := os.Readlink(wt.Path(name))
+		if err != nil {
+			return nil, mode, err
+		}
+		return []byte(target), mode, nil
+	}
+	data, err := ioutil.ReadFile(wt.Path(name))
+	return data, mode, err
+}
+
+// HashFile returns the oid of the blob that a file in the
+// working tree would be stored as.
+func (wt *WorkTree) HashFile(name string) (*objects.ObjectId, error) {
+	data, _, err := wt.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return BlobOid(data), nil
+}
+
+// Status compares an index entry against the file it was
+// staged from. The cached stat data is consulted first and
+// the contents are only hashed when the stat data cannot
+// vouch for the file, which includes files that were
+// modified in the same instant the index was written, and
+// entries that have no stat data.
+func (wt *WorkTree) Status(entry *IndexEntry) (WorkStatus, error) {
+	info, err := wt.Lstat(entry.Name())
+	if err != nil {
+		if os.IsNotExist(err) || isNotDir(err) {
+			return WorkDeleted, nil
+		}
+		return WorkUnmodified, err
+	}
+	if entry.Mode() == objects.ModeCommit {
+		// we don't look inside of submodules
+		if info.IsDir() {
+			return WorkUnmodified, nil
+		}
+		return WorkModified, nil
+	}
+	if entry.Flags().AssumeValid() {
+		return WorkUnmodified, nil
+	}
+	if FileModeFromInfo(info) != entry.Mode() {
+		return WorkModified, nil
+	}
+	if stat := entry.StatInfo(); stat != nil {
+		if uint32(stat.Size) != uint32(info.Size()) {
+			return WorkModified, nil
+		}
+		mtime := stat.MTime()
+		if mtime.Equal(info.ModTime()) && mtime.Before(wt.indexTime) {
+			return WorkUnmodified, nil
+		}
+	}
+	oid, err := wt.HashFile(entry.Name())
+	if err != nil {
+		return WorkUnmodified, err
+	}
+	if oid.String() != entry.ObjectId().String() {
+		return WorkModified, nil
+	}
+	return WorkUnmodified, nil
+}
+
+// Refresh returns the status of the file of an index entry, and an
+// entry that stages the same object with the stat data of the file,
+// if the file has no changes, as git-update-index --refresh does.
+// Entries whose files have changes, or which have flags of their
+// own, are returned as they are.
+func (wt *WorkTree) Refresh(entry *IndexEntry) (*IndexEntry, WorkStatus, error) {
+	status, err := wt.Status(entry)
+	if err != nil || status != WorkUnmodified || entry.Stage() != 0 || entry.Flags().AssumeValid() || entry.Flags().Extended() {
+		return entry, status, err
+	}
+	info, err := wt.Lstat(entry.Name())
+	if err != nil {
+		return entry, status, err
+	}
+	return NewIndexEntry(entry.Name(), 0, entry.ObjectId(), entry.Mode(), NewStatInfo(info)), status, nil
+}
+
+// ErrNoCheckout is returned when a nested repository, which is
+// staged as a gitlink, has no commit checked out.
+var ErrNoCheckout = errors.New("does not have a commit checked out")
+
+// StageFile stores a file of the working tree as a blob, and
+// returns the index entry that stages it, with the stat data
+// of the file. A nested repository is staged as a gitlink to the
+// commit that is checked out in it.
+func (wt *WorkTree) StageFile(name string) (*IndexEntry, error) {
+	info, err := wt.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		sub := &DiskRepository{path: filepath.Join(wt.Path(name), DefaultGitDir)}
+		_, oid, err := Head(sub)
+		if err != nil || oid == nil {
+			return nil, ErrNoCheckout
+		}
+		return NewIndexEntry(name, 0, oid, objects.ModeCommit, NewStatInfo(info)), nil
+	}
+	data, mode, err := wt.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := wt.repo.WriteBlob(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewIndexEntry(name, 0, oid, mode, NewStatInfo(info)), nil
+}
+
+// IntentToAddEntry returns the index entry that records the
+// intention to add a file of the working tree later, as git add
+// -N does. The entry stages the empty blob and has no stat data.
+func (wt *WorkTree) IntentToAddEntry(name string) (*IndexEntry, error) {
+	info, err := wt.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := wt.repo.WriteBlob(nil)
+	if err != nil {
+		return nil, err
+	}
+	entry := NewIndexEntry(name, 0, oid, FileModeFromInfo(info), nil)
+	entry.extFlags |= flagIntentToAdd
+	return entry, nil
+}
+
+// WriteFile creates or replaces a file in the working tree with
+// the contents of a blob of the given mode: executable files are
+// made executable, and symbolic links point at the path that
+// the contents name. Missing directories are created, and files
+// that are in their way are removed.
+func (wt *WorkTree) WriteFile(name string, data []byte, mode objects.FileMode) error {
+	pth := wt.Path(name)
+	if err := wt.makeDirs(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := os.Remove(pth); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if mode == objects.ModeLink {
+		return os.Symlink(string(data), pth)
+	}
+	perm := os.FileMode(0666)
+	if mode == objects.ModeBlobExec {
+		perm = 0777
+	}
+	f, err := os.OpenFile(pth, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CheckoutEntry writes the file of an index entry to the working
+// tree, and returns an entry that stages it with the stat data of
+// the new file. A directory that is in the way is removed along
+// with everything inside of it, and gitlinks are checked out as
+// empty directories.
+func (wt *WorkTree) CheckoutEntry(entry *IndexEntry) (*IndexEntry, error) {
+	name := entry.Name()
+	if info, err := wt.Lstat(name); err == nil && info.IsDir() && entry.Mode() != objects.ModeCommit {
+		if err = os.RemoveAll(wt.Path(name)); err != nil {
+			return nil, err
+		}
+	}
+	if entry.Mode() == objects.ModeCommit {
+		if err := wt.makeDirs(name); err != nil {
+			return nil, err
+		}
+	} else {
+		blob, err := BlobFromOid(wt.repo, entry.ObjectId())
+		if err != nil {
+			return nil, err
+		}
+		if err = wt.WriteFile(name, blob.Data(), entry.Mode()); err != nil {
+			return nil, err
+		}
+	}
+	info, err := wt.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewIndexEntry(name, 0, entry.ObjectId(), entry.Mode(), NewStatInfo(info)), nil
+}
+
+// RemoveFile removes a file from the working tree, along with
+// the directories that it leaves empty. Files that are already
+// gone are not an error.
+func (wt *WorkTree) RemoveFile(name string) error {
+	if err := os.Remove(wt.Path(name)); err != nil && !os.IsNotExist(err) && !isNotDir(err) {
+		return err
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(wt.Path(dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// makeDirs creates a directory of the working tree and the
+// directories that lead to it, removing any files in the way.
+func (wt *WorkTree) makeDirs(dir string) error {
+	if dir == "." {
+		return nil
+	}
+	info, err := wt.Lstat(dir)
+	if err == nil && info.IsDir() {
+		return nil
+	}
+	if err = wt.makeDirs(path.Dir(dir)); err != nil {
+		return err
+	}
+	if info != nil {
+		if err = os.Remove(wt.Path(dir)); err != nil {
+			return err
+		}
+	}
+	return os.Mkdir(wt.Path(dir), 0777)
+}
+
+// Others returns the sorted paths of the files in the working
+// tree that are not tracked by the index. If the ignorer is not
+// nil, ignored files are left out of the result. If ignored
+// is true, then the opposite happens, and only the ignored files
+// are returned. Nested repositories are reported as a single
+// path with a trailing slash.
+func (wt *WorkTree) Others(idx *Index, ig *Ignorer, ignored bool) ([]string, error) {
+	tracked := make(map[string]bool)
+	if idx != nil {
+		for _, entry := range idx.Entries() {
+			tracked[entry.Name()] = true
+		}
+	}
+	root := wt.Dir()
+	var result []string
+	var walk func(dir string, parentIgnored bool) error
+	walk = func(dir string, parentIgnored bool) error {
+		infos, err := ioutil.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			name := joinPath(dir, info.Name())
+			if dir == "" && info.Name() == DefaultGitDir {
+				continue
+			}
+			isDir := info.IsDir()
+			if tracked[name] {
+				continue // a file or a submodule
+			}
+			isIgnored := parentIgnored
+			if !isIgnored && ig != nil {
+				isIgnored = ig.Ignored(name, isDir)
+			}
+			if !isDir {
+				if isIgnored == ignored {
+					result = append(result, name)
+				}
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name), DefaultGitDir)); err == nil {
+				if isIgnored == ignored {
+					result = append(result, name+"/")
+				}
+				continue
+			}
+			if isIgnored && !ignored {
+				continue
+			}
+			if err := walk(name, isIgnored); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", false); err != nil {
+		return nil, err
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// ================================================================= //
+// UTILITY METHODS
+// ================================================================= //
+
+// FileModeFromInfo returns the mode that a file in the
+// working tree would have as a tree entry.
+func FileModeFromInfo(info os.FileInfo) objects.FileMode {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return objects.ModeLink
+	case info.IsDir():
+		return objects.ModeTree
+	case info.Mode()&0100 != 0:
+		return objects.ModeBlobExec
+	}
+	return objects.ModeBlob
+}
+
+// NewStatInfo returns the stat data that an index entry keeps
+// of the file that it was staged from.
+func NewStatInfo(info os.FileInfo) *StatInfo {
+	stat := &StatInfo{Mode: int32(FileModeFromInfo(info))}
+	fillStatInfo(stat, info)
+	return stat
+}
+
+// fillModTime copies the time of modification and the size
+// of a file into its stat data.
+func fillModTime(stat *StatInfo, info os.FileInfo) {
+	mtime := info.ModTime()
+	stat.MTimeSecs, stat.MTimeNanos = int32(mtime.Unix()), int32(mtime.Nanosecond())
+	stat.Size = int32(info.Size())
+}
+
+// isNotDir returns true if the error was caused by some
+// component of the path not being a directory.
+func isNotDir(err error) bool {
+	if e, ok := err.(*os.PathError); ok {
+		return e.Err == syscall.ENOTDIR
+	}
+	return false
+}
@@ -0,0 +1,399 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ================================================================= //
+// ADD
+// ================================================================= //
+
+// AddBuiltin implements git-add, which stages the contents of
+// the files of the working tree in the index.
+type AddBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagDryRun      bool
+	flagVerbose     bool
+	flagForce       bool
+	flagUpdate      bool
+	flagAll         bool
+	flagIntentToAdd bool
+}
+
+var AddFiles = &AddBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "add",
+		Description: "Add file contents to the index",
+		UsageLine:   "[-n] [-v] [-f] [-u | -A] [-N] [--] [<pathspec>...]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	AddFiles.BoolVar(&AddFiles.flagDryRun, "n", false, "Don't actually add the files, just show what would be added.")
+	AddFiles.BoolVar(&AddFiles.flagDryRun, "dry-run", false, "Don't actually add the files, just show what would be added.")
+	AddFiles.BoolVar(&AddFiles.flagVerbose, "v", false, "Be verbose.")
+	AddFiles.BoolVar(&AddFiles.flagVerbose, "verbose", false, "Be verbose.")
+	AddFiles.BoolVar(&AddFiles.flagForce, "f", false, "Allow adding otherwise ignored files.")
+	AddFiles.BoolVar(&AddFiles.flagForce, "force", false, "Allow adding otherwise ignored files.")
+	AddFiles.BoolVar(&AddFiles.flagUpdate, "u", false, "Only update the files that are already tracked.")
+	AddFiles.BoolVar(&AddFiles.flagUpdate, "update", false, "Only update the files that are already tracked.")
+	AddFiles.BoolVar(&AddFiles.flagAll, "A", false, "Add, modify and remove the files of the whole working tree.")
+	AddFiles.BoolVar(&AddFiles.flagAll, "all", false, "Add, modify and remove the files of the whole working tree.")
+	AddFiles.BoolVar(&AddFiles.flagIntentToAdd, "N", false, "Record only the fact that untracked files will be added later.")
+	AddFiles.BoolVar(&AddFiles.flagIntentToAdd, "intent-to-add", false, "Record only the fact that untracked files will be added later.")
+
+	AddFiles.Usage = func() {}
+
+	// add to command list
+	Add(AddFiles)
+}
+
+// the explanations that git-add gives
+const (
+	nothingSpecified = `Nothing specified, nothing added.
+hint: Maybe you wanted to say 'git add .'?
+hint: Turn this message off by running
+hint: "git config advice.addEmptyPathspec false"
+`
+	ignoredPaths = "The following paths are ignored by one of your .gitignore files:\n"
+	ignoredHint  = `hint: Use -f if you really want to add them.
+hint: Turn this message off by running
+hint: "git config advice.addIgnoredFile false"
+`
+	embeddedHint = `hint: You've added another git repository inside your current repository.
+hint: Clones of the outer repository will not contain the contents of
+hint: the embedded repository and will not know how to obtain it.
+hint: If you meant to add a submodule, use:
+hint:
+hint: 	git submodule add <url> %s
+hint:
+hint: If you added this path by mistake, you can remove it from the
+hint: index with:
+hint:
+hint: 	git rm --cached %s
+hint:
+hint: See "git help submodule" for more information.
+`
+)
+
+func (b *AddBuiltin) Execute(p *Params, args []string) {
+	b.flagDryRun, b.flagVerbose, b.flagForce = false, false, false
+	b.flagUpdate, b.flagAll, b.flagIntentToAdd = false, false, false
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if b.flagUpdate && b.flagAll {
+		fmt.Fprintln(p.Werr, "fatal: options '-A' and '-u' cannot be used together")
+		p.Status = 128
+		return
+	}
+	if len(args) == 0 && !b.flagUpdate && !b.flagAll {
+		fmt.Fprint(p.Werr, nothingSpecified)
+		return
+	}
+	status, err := b.add(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *AddBuiltin) add(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := workPrefix(repo)
+	ps, err := addPathspec(repo, prefix, args)
+	if err != nil {
+		return 0, err
+	}
+	wt := api.NewWorkTree(repo)
+	var ig *api.Ignorer
+	if !b.flagForce {
+		if ig, err = api.NewStandardIgnorer(repo); err != nil {
+			return 0, err
+		}
+	}
+	others, err := wt.Others(idx, ig, false)
+	if err != nil {
+		return 0, err
+	}
+
+	// every pathspec must match a file, even if it is ignored
+	var ignored []string
+	if ig != nil {
+		if ignored, err = wt.Others(idx, ig, true); err != nil {
+			return 0, err
+		}
+	}
+	for _, arg := range args {
+		one, _ := pathspec.Parse(prefix, []string{arg}, 0)
+		if !matchesIndex(one, idx) && !matchesNames(one, others) && !matchesNames(one, ignored) {
+			return 0, fmt.Errorf("pathspec '%s' did not match any files", arg)
+		}
+	}
+
+	status := 0
+	if ig != nil {
+		if reported := ignoredPathsOf(wt, ig, idx, prefix, args); len(reported) > 0 {
+			fmt.Fprint(p.Werr, ignoredPaths)
+			for _, name := range reported {
+				fmt.Fprintln(p.Werr, name)
+			}
+			fmt.Fprint(p.Werr, ignoredHint)
+			status = 1
+		}
+	}
+
+	show := func(verb, name string) {
+		if b.flagVerbose || b.flagDryRun {
+			fmt.Fprintf(p.Wout, "%s '%s'\n", verb, name)
+		}
+	}
+	if err = stageTracked(wt, idx, ps, b.flagDryRun, show); err != nil {
+		return 0, err
+	}
+	if !b.flagUpdate {
+		if err = b.stageOthers(p, wt, idx, ps, others, show); err != nil {
+			return 0, err
+		}
+	}
+
+	if b.flagDryRun {
+		return status, nil
+	}
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return 0, err
+	}
+	return status, nil
+}
+
+// stageOthers stages the untracked files that match the pathspec,
+// or records the intention to add them.
+func (b *AddBuiltin) stageOthers(p *Params, wt *api.WorkTree, idx *api.Index, ps *pathspec.Pathspec, others []string, show func(verb, name string)) error {
+	hinted := false
+	for _, name := range others {
+		isDir := strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		if !ps.Match(name, isDir) {
+			continue
+		}
+		if b.flagDryRun {
+			show("add", name)
+			continue
+		}
+		var (
+			entry *api.IndexEntry
+			err   error
+		)
+		if b.flagIntentToAdd && !isDir {
+			entry, err = wt.IntentToAddEntry(name)
+		} else {
+			entry, err = wt.StageFile(name)
+		}
+		if err == api.ErrNoCheckout {
+			fmt.Fprintf(p.Werr, "error: '%s/' %s\n", name, err)
+			return fmt.Errorf("adding files failed")
+		} else if err != nil {
+			return err
+		}
+		if isDir {
+			fmt.Fprintf(p.Werr, "warning: adding embedded git repository: %s\n", name)
+			if !hinted {
+				fmt.Fprintf(p.Werr, embeddedHint, name, name)
+				hinted = true
+			}
+		}
+		show("add", name)
+		idx.Add(entry)
+	}
+	return nil
+}
+
+// stageTracked brings the tracked files that match the pathspec
+// up to date in the index: modified files are staged again, and
+// deleted ones are removed. The verb "add" or "remove" is shown
+// for each of them.
+func stageTracked(wt *api.WorkTree, idx *api.Index, ps *pathspec.Pathspec, dryRun bool, show func(verb, name string)) error {
+	var names []string
+	unmerged := make(map[string]bool)
+	for _, e := range idx.Entries() {
+		if !ps.MatchIndexEntry(e) {
+			continue
+		}
+		if e.Stage() != 0 {
+			unmerged[e.Name()] = true
+		}
+		if len(names) == 0 || names[len(names)-1] != e.Name() {
+			names = append(names, e.Name())
+		}
+	}
+	for _, name := range names {
+		entry := idx.Entry(name, 0)
+		info, err := wt.Lstat(name)
+		if err != nil || (info.IsDir() && (entry == nil || entry.Mode() != objects.ModeCommit)) {
+			show("remove", name)
+			if !dryRun {
+				idx.Remove(name)
+			}
+			continue
+		}
+		fresh := entry == nil || unmerged[name] || entry.ExtendedFlags().IntentToAdd()
+		if !fresh && entry.Mode() != objects.ModeCommit {
+			status, err := wt.Status(entry)
+			if err != nil {
+				return err
+			}
+			if status == api.WorkUnmodified {
+				continue
+			}
+		}
+		if dryRun {
+			if fresh || entry.Mode() != objects.ModeCommit {
+				show("add", name)
+			}
+			continue
+		}
+		staged, err := wt.StageFile(name)
+		if err != nil {
+			return err
+		}
+		if fresh || staged.ObjectId().String() != entry.ObjectId().String() || staged.Mode() != entry.Mode() {
+			show("add", name)
+		}
+		idx.Add(staged)
+	}
+	return nil
+}
+
+// ignoredPathsOf returns the ignored paths that the pathspecs
+// name literally, which are the paths themselves or the ignored
+// directories that they are inside of.
+func ignoredPathsOf(wt *api.WorkTree, ig *api.Ignorer, idx *api.Index, prefix string, args []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, arg := range args {
+		one, err := pathspec.Parse(prefix, []string{arg}, 0)
+		if err != nil || matchesIndex(one, idx) {
+			continue
+		}
+		it := one.Items()[0]
+		if it.HasWildcards() || it.Magic()&pathspec.MagicExclude != 0 {
+			continue
+		}
+		name := strings.TrimSuffix(it.Match(), "/")
+		if name == "" {
+			continue
+		}
+		names := strings.Split(name, "/")
+		for i := range names {
+			pth := path.Join(names[:i+1]...)
+			info, err := wt.Lstat(pth)
+			if err != nil {
+				break
+			}
+			if ig.Ignored(pth, info.IsDir()) {
+				if !seen[pth] {
+					seen[pth] = true
+					result = append(result, pth)
+				}
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ================================================================= //
+// INDEX UTILITIES
+// ================================================================= //
+
+// readIndex reads the index of a repository, which is empty if
+// there is no index yet.
+func readIndex(repo *api.DiskRepository) (*api.Index, error) {
+	idx, err := repo.Index()
+	if os.IsNotExist(err) {
+		return new(api.Index), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("index file corrupt")
+	}
+	return idx, nil
+}
+
+// addPathspec parses the pathspecs of a command that changes the
+// index, which may have attr magic.
+func addPathspec(repo *api.DiskRepository, prefix string, args []string) (*pathspec.Pathspec, error) {
+	ps, err := pathspec.Parse(prefix, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if ps.Has(pathspec.MagicAttr) {
+		attrs, err := api.NewAttributes(repo)
+		if err != nil {
+			return nil, err
+		}
+		ps.SetAttributes(attrs)
+	}
+	return ps, nil
+}
+
+// matchesIndex returns true if the pathspec matches an entry of
+// the index.
+func matchesIndex(ps *pathspec.Pathspec, idx *api.Index) bool {
+	for _, e := range idx.Entries() {
+		if ps.MatchIndexEntry(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNames returns true if the pathspec matches one of the
+// names, which are those of directories if they have a trailing
+// slash.
+func matchesNames(ps *pathspec.Pathspec, names []string) bool {
+	for _, name := range names {
+		if ps.Match(strings.TrimSuffix(name, "/"), strings.HasSuffix(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,826 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/apply"
+	"github.com/jbrukh/ggit/api/checkout"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/mailbox"
+	"github.com/jbrukh/ggit/api/merge"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/sequencer"
+	"github.com/jbrukh/ggit/util"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ================================================================= //
+// AM
+// ================================================================= //
+
+// AmBuiltin implements git-am, which applies the patches of the
+// emails of a mailbox, as git-format-patch writes them, and commits
+// each of them with the author, the date and the message of its
+// email. The state of the application is kept as git keeps it, so
+// that either of them can carry on with an application that the other
+// one stopped.
+type AmBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagQuiet    bool
+	flagThreeWay bool
+	flagKeep     bool
+	flagAction   string
+	flagShow     optionalFlag
+}
+
+var Am = &AmBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "am",
+		Description: "Apply a series of patches from a mailbox",
+		UsageLine:   "[-q] [-3 | --3way] [-k] [<mbox>...] | (--continue | --skip | --abort | --quit | --show-current-patch[=(diff|raw)])",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Am.BoolVar(&Am.flagQuiet, "q", false, "Be quiet.")
+	Am.BoolVar(&Am.flagQuiet, "quiet", false, "Be quiet.")
+	Am.BoolVar(&Am.flagThreeWay, "3", false, "Fall back to a three-way merge when a patch does not apply.")
+	Am.BoolVar(&Am.flagThreeWay, "3way", false, "Synonym of -3.")
+	Am.BoolVar(&Am.flagKeep, "k", false, "Keep the subjects of the emails as they are.")
+	Am.BoolVar(&Am.flagKeep, "keep", false, "Synonym of -k.")
+	for _, name := range []string{"continue", "skip", "abort", "quit"} {
+		Am.Var(modeFlag{&Am.flagAction, name}, name, "")
+	}
+	Am.Var(modeFlag{&Am.flagAction, "continue"}, "r", "")
+	Am.Var(modeFlag{&Am.flagAction, "continue"}, "resolved", "")
+	Am.Lookup("continue").Usage = "Commit the resolution of the patch that stopped the application, and continue with the rest."
+	Am.Lookup("r").Usage = "Synonym of --continue."
+	Am.Lookup("resolved").Usage = "Synonym of --continue."
+	Am.Lookup("skip").Usage = "Skip the patch that stopped the application, and continue with the rest."
+	Am.Lookup("abort").Usage = "Give up, and go back to the branch as it was before."
+	Am.Lookup("quit").Usage = "Forget about the application, leaving HEAD where it is."
+	Am.Var(&Am.flagShow, "show-current-patch", "Show the email (raw) or the patch (diff) that stopped the application.")
+
+	Am.Usage = func() {}
+
+	// add to command list
+	Add(Am)
+}
+
+func (b *AmBuiltin) Execute(p *Params, args []string) {
+	b.flagQuiet, b.flagThreeWay, b.flagKeep, b.flagAction, b.flagShow = false, false, false, "", optionalFlag{}
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil || b.flagShow.set && b.flagAction != "" {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if b.flagShow.set {
+		switch b.flagShow.value {
+		case "", "raw", "diff":
+			b.flagAction = "show-current-patch"
+		default:
+			fmt.Fprintf(p.Werr, "error: Invalid value for --show-current-patch: %s\n", b.flagShow.value)
+			p.Status = 129
+			return
+		}
+	}
+	status, err := b.am(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *AmBuiltin) am(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	dir := path.Join(api.DefaultGitDir, sequencer.AmDir)
+	if sequencer.AmInProgress(repo) {
+		// mailboxes, or a patch on the standard input, are not
+		// applied on top of another application
+		f, ok := p.Rin.(*os.File)
+		if len(args) > 0 || b.flagAction == "" && !(ok && util.IsTerminal(f)) {
+			return 0, fmt.Errorf("previous rebase directory %s still exists but mbox given.", dir)
+		}
+		switch b.flagAction {
+		case "continue":
+			return b.resolve(p, repo)
+		case "skip":
+			return b.skip(p, repo)
+		case "abort":
+			return b.abort(p, repo)
+		case "quit":
+			return 0, sequencer.RemoveAm(repo)
+		case "show-current-patch":
+			return b.showPatch(p, repo)
+		}
+		am, err := sequencer.ReadAm(repo)
+		if err != nil {
+			return 0, err
+		}
+		return b.run(p, repo, am, true)
+	}
+	if _, err := os.Stat(path.Join(repo.Path(), sequencer.AmDir)); err == nil {
+		if b.flagAction == "abort" || b.flagAction == "quit" {
+			return 0, sequencer.RemoveAm(repo)
+		}
+		return 0, fmt.Errorf("Stray %s directory found.\nUse \"git am --abort\" to remove it.", dir)
+	}
+	if b.flagAction != "" {
+		return 0, fmt.Errorf("Resolve operation not in progress, we are not resuming.")
+	}
+	return b.start(p, repo, args)
+}
+
+// the advice that a patch that stops the application gets
+const amResolveAdvice = `When you have resolved this problem, run "git am --continue".
+If you prefer to skip this patch, run "git am --skip" instead.
+To restore the original branch and stop patching, run "git am --abort".
+`
+
+// stop stops the application at the patch that is being applied.
+func (b *AmBuiltin) stop(p *Params) (int, error) {
+	fmt.Fprint(p.Wout, amResolveAdvice)
+	return 128, nil
+}
+
+// ================================================================= //
+// STARTING
+// ================================================================= //
+
+// start splits the mailboxes, which are read from the standard input
+// if there are none, into the emails that are applied, and applies
+// them on top of HEAD.
+func (b *AmBuiltin) start(p *Params, repo *api.DiskRepository, files []string) (int, error) {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	var mails [][]byte
+	if len(files) == 0 || files[0] == "-" {
+		data, err := ioutil.ReadAll(p.Rin)
+		if err != nil {
+			return 0, err
+		}
+		mails = mailbox.Split(data)
+	} else {
+		for i, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return 0, fmt.Errorf("could not open '%s' for reading: %s", file, describeError(err))
+			}
+			if i == 0 && !isMailbox(data) {
+				fmt.Fprintln(p.Werr, "Patch format detection failed.")
+				return 128, nil
+			}
+			mails = append(mails, mailbox.Split(data)...)
+		}
+	}
+
+	am := &sequencer.Am{Next: 1, Last: len(mails), Quiet: b.flagQuiet, ThreeWay: b.flagThreeWay, Keep: b.flagKeep}
+	if !am.ThreeWay {
+		if am.ThreeWay, err = config.Bool("am.threeWay", false); err != nil {
+			return 0, err
+		}
+	}
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	if err = sequencer.StartAm(repo, am, mails, headOid); err != nil {
+		return 0, err
+	}
+	if headOid != nil {
+		who, err := identity(p.Werr, config, api.RoleCommitter)
+		if err != nil {
+			return 0, err
+		}
+		if err = api.UpdateRef(repo, "ORIG_HEAD", headOid, nil, who, "am"); err != nil {
+			return 0, err
+		}
+	} else if orig, err := repo.Ref("ORIG_HEAD"); err == nil {
+		if err = api.DeleteRef(repo, orig.Name(), nil); err != nil {
+			return 0, err
+		}
+	}
+	return b.run(p, repo, am, false)
+}
+
+// matches the lines of the headers of emails
+var mailHeader = regexp.MustCompile(`^[!-9;-~]+:`)
+
+// isMailbox returns true if a file looks like a mailbox, or like an
+// email: its first line that isn't blank is a "From " line or a From
+// header, or it starts with lines that are all headers.
+func isMailbox(data []byte) bool {
+	first := bytes.TrimLeft(data, "\r\n")
+	if bytes.HasPrefix(first, []byte("From ")) || bytes.HasPrefix(first, []byte("From: ")) {
+		return true
+	}
+	if len(first) == 0 || first[0] == ' ' || first[0] == '\t' {
+		return false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// folded headers go on
+			continue
+		}
+		if !mailHeader.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// ================================================================= //
+// APPLYING
+// ================================================================= //
+
+// run applies the emails that are left, one by one, and commits their
+// patches, stopping at a patch that doesn't apply. The email that is
+// being applied is parsed again unless the application is resumed,
+// in which case the commit that was parsed before is kept.
+func (b *AmBuiltin) run(p *Params, repo *api.DiskRepository, am *sequencer.Am, resume bool) (int, error) {
+	if err := sequencer.MarkDirtyIndex(repo, false); err != nil {
+		return 0, err
+	}
+	staged, err := stagedChanges(repo)
+	if err != nil {
+		return 0, err
+	}
+	if len(staged) > 0 {
+		if err = sequencer.MarkDirtyIndex(repo, true); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("Dirty index: cannot apply patches (dirty: %s)", strings.Join(staged, " "))
+	}
+
+	for ; am.Next <= am.Last; resume = false {
+		data, err := sequencer.ReadMail(repo, am.Next)
+		if err != nil {
+			return 0, err
+		}
+		if data != nil {
+			if !resume {
+				m, err := mailbox.Parse(data, am.Keep)
+				if err != nil {
+					return 0, err
+				}
+				if err = sequencer.WriteMail(repo, m); err != nil {
+					return 0, err
+				}
+				if m.Patch == "" {
+					fmt.Fprintln(p.Wout, "Patch is empty.")
+					return b.stop(p)
+				}
+				if err = sequencer.WriteAmCommit(repo, m, cleanupMessage(m.Subject+"\n\n"+m.Message)); err != nil {
+					return 0, err
+				}
+			}
+			name, email, date, msg, err := sequencer.AmCommit(repo)
+			if err != nil {
+				return 0, err
+			}
+			subject := strings.SplitN(msg, "\n", 2)[0]
+			if !am.Quiet {
+				fmt.Fprintf(p.Wout, "Applying: %s\n", subject)
+			}
+			applied, err := b.apply(p, repo, am)
+			if err != nil {
+				return 0, err
+			}
+			merged := false
+			if !applied && am.ThreeWay {
+				if applied, err = b.fallBackThreeway(p, repo, am, subject); err != nil {
+					return 0, err
+				}
+				merged = applied
+			}
+			if !applied {
+				fmt.Fprintf(p.Wout, "Patch failed at %04d %s\n", am.Next, subject)
+				if err = adviseShowPatch(p, repo); err != nil {
+					return 0, err
+				}
+				return b.stop(p)
+			}
+			if merged {
+				// merging the patch may have changed nothing
+				if staged, err := stagedChanges(repo); err != nil {
+					return 0, err
+				} else if len(staged) == 0 {
+					if !am.Quiet {
+						fmt.Fprintln(p.Wout, "No changes -- Patch already applied.")
+					}
+					applied = false
+				}
+			}
+			if applied {
+				if err = b.commit(p, repo, am, name, email, date, msg); err != nil {
+					return 0, err
+				}
+			}
+		}
+		_, headOid, err := api.Head(repo)
+		if err != nil {
+			return 0, err
+		}
+		if err = sequencer.NextMail(repo, am, headOid); err != nil {
+			return 0, err
+		}
+	}
+	return 0, sequencer.RemoveAm(repo)
+}
+
+// adviseShowPatch tells how to see the patch that didn't apply,
+// unless advice.amWorkDir says otherwise.
+func adviseShowPatch(p *Params, repo *api.DiskRepository) error {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+	if advise, err := config.Bool("advice.amWorkDir", true); err != nil {
+		return err
+	} else if advise {
+		fmt.Fprintln(p.Werr, "hint: Use 'git am --show-current-patch=diff' to see the failed patch")
+	}
+	return nil
+}
+
+// stagedChanges returns the paths whose changes are staged, which
+// are all of the paths of the index on an unborn branch.
+func stagedChanges(repo *api.DiskRepository) ([]string, error) {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	t, err := headTree(repo)
+	if err != nil {
+		return nil, err
+	}
+	td, err := diff.DiffTreeIndex(repo, t, idx, new(diff.Options))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, edit := range td.Edits() {
+		name := edit.Path()
+		if len(paths) == 0 || paths[len(paths)-1] != name {
+			paths = append(paths, name)
+		}
+	}
+	return paths, nil
+}
+
+// applyOptions returns the options of the application of patches,
+// from the configuration.
+func applyOptions(config *api.Config) (apply.Options, error) {
+	opts := apply.Options{Strip: -1, Context: apply.AllContext, Whitespace: apply.WhitespaceWarn}
+	var err error
+	if action := config.String("apply.whitespace", ""); action != "" {
+		if opts.Whitespace, err = apply.ParseWhitespaceAction(action); err != nil {
+			return opts, err
+		}
+	}
+	opts.Rule, err = diff.ParseWhitespaceRule(config.String("core.whitespace", ""))
+	return opts, err
+}
+
+// apply applies the patch of the email that is being applied to the
+// index and the working tree, and returns true if it applied. Why it
+// didn't apply isn't reported if it is merged instead.
+func (b *AmBuiltin) apply(p *Params, repo *api.DiskRepository, am *sequencer.Am) (bool, error) {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return false, err
+	}
+	opts, err := applyOptions(config)
+	if err != nil {
+		return false, err
+	}
+	data, name, err := sequencer.AmPatch(repo)
+	if err != nil {
+		return false, err
+	}
+
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return false, err
+	}
+	var log io.Writer = p.Werr
+	if am.ThreeWay {
+		log = ioutil.Discard
+	}
+	applier := apply.NewApplier(repo, apply.NewIndexTarget(repo, idx, api.NewWorkTree(repo)), opts, log)
+	if err = applier.Apply(name, data); err == apply.ErrFailed {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err = applier.Finish(); err != nil {
+		return false, err
+	}
+	err, lock = repo.WriteIndex(lock, idx), nil
+	return err == nil, err
+}
+
+// fallBackThreeway merges the patch of the email that is being applied
+// into the index and the working tree, when it doesn't apply, and
+// returns true if it merged without conflicts. The patch is applied to
+// the files that it was made against, which are found by the oids of
+// their blobs that it records, and the result is merged with HEAD.
+func (b *AmBuiltin) fallBackThreeway(p *Params, repo *api.DiskRepository, am *sequencer.Am, subject string) (bool, error) {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return false, err
+	}
+	opts, err := applyOptions(config)
+	if err != nil {
+		return false, err
+	}
+	data, name, err := sequencer.AmPatch(repo)
+	if err != nil {
+		return false, err
+	}
+	patches, err := apply.Parse(data, opts.Strip)
+	if err != nil {
+		return false, err
+	}
+
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return false, err
+	}
+	ours, err := headTree(repo)
+	if err != nil {
+		return false, err
+	}
+
+	// the files that the patch was made against
+	fake, err := apply.FakeAncestor(repo, patches, idx)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "error: %s\n", err)
+		fmt.Fprintln(p.Werr, "error: could not build fake ancestor")
+		return false, nil
+	}
+	if err = sequencer.WriteAmMergeIndex(repo, fake); err != nil {
+		return false, err
+	}
+	baseOid, err := fake.WriteTree(repo, false)
+	if err != nil {
+		return false, err
+	}
+	if !am.Quiet {
+		fmt.Fprintln(p.Wout, "Using index info to reconstruct a base tree...")
+		td, err := diff.DiffTreeIndex(repo, ours, fake, new(diff.Options))
+		if err != nil {
+			return false, err
+		}
+		for _, edit := range td.Edits() {
+			switch edit.Action() {
+			case diff.Insert:
+				fmt.Fprintf(p.Wout, "A\t%s\n", util.QuotePath(edit.Path()))
+			case diff.Modify:
+				fmt.Fprintf(p.Wout, "M\t%s\n", util.QuotePath(edit.Path()))
+			}
+		}
+	}
+
+	// the patch applied to them
+	applier := apply.NewApplier(repo, apply.NewIndexTarget(repo, fake, nil), opts, p.Werr)
+	if err = applier.Apply(name, data); err == nil {
+		err = applier.Finish()
+	}
+	if err == apply.ErrFailed {
+		fmt.Fprintln(p.Werr, "error: Did you hand edit your patch?\nIt does not apply to blobs recorded in its index.")
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err = sequencer.WriteAmMergeIndex(repo, fake); err != nil {
+		return false, err
+	}
+	theirsOid, err := fake.WriteTree(repo, false)
+	if err != nil {
+		return false, err
+	}
+	if !am.Quiet {
+		fmt.Fprintln(p.Wout, "Falling back to patching base and 3-way merge...")
+	}
+
+	base, err := api.TreeFromOid(repo, baseOid)
+	if err != nil {
+		return false, err
+	}
+	theirs, err := api.TreeFromOid(repo, theirsOid)
+	if err != nil {
+		return false, err
+	}
+	mopts, err := mergeOptions(config)
+	if err != nil {
+		return false, err
+	}
+	mopts.Ours, mopts.Theirs, mopts.Base = "HEAD", subject, "constructed merge base"
+	r, err := merge.MergeTrees(repo, base, ours, theirs, mopts)
+	if err != nil {
+		return false, err
+	}
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return false, err
+	}
+	err = checkout.SwitchTrees(repo, idx, ours, r.Tree, &checkout.Options{Ignorer: ig, Action: "merge"})
+	if e, ok := err.(*checkout.Error); ok {
+		printCheckoutError(p, e)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, e := range r.Unmerged {
+		idx.Add(e)
+	}
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return false, err
+	}
+	if !am.Quiet {
+		for _, m := range r.Messages {
+			fmt.Fprintln(p.Wout, m.Text)
+		}
+	}
+	if !r.Clean {
+		fmt.Fprintln(p.Werr, "error: Failed to merge in the changes.")
+	}
+	return r.Clean, nil
+}
+
+// commit commits the index on top of HEAD, with the author and the
+// message of the email that is being applied. An email without a date
+// is dated now.
+func (b *AmBuiltin) commit(p *Params, repo *api.DiskRepository, am *sequencer.Am, name, email, date, msg string) error {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	tree, err := idx.WriteTree(repo, false)
+	if err != nil {
+		return err
+	}
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return err
+	}
+	var parents []*objects.ObjectId
+	if headOid != nil {
+		parents = append(parents, headOid)
+	} else if !am.Quiet {
+		fmt.Fprintln(p.Werr, "applying to an empty history")
+	}
+
+	var seconds int64
+	var offset int
+	if date == "" {
+		seconds, offset, err = api.IdentDate(api.RoleAuthor)
+	} else {
+		seconds, offset, err = api.ParseDate(date)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid date format: %s", date)
+	}
+	author := objects.NewWhoWhen(name, email, seconds, offset)
+	committer, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return err
+	}
+	c, err := api.WriteCommit(repo, tree, parents, author, committer, msg)
+	if err != nil {
+		return err
+	}
+	action := os.Getenv("GIT_REFLOG_ACTION")
+	if action == "" {
+		action = "am"
+	}
+	subject := strings.SplitN(msg, "\n", 2)[0]
+	return api.UpdateRef(repo, api.HeadRef, c.ObjectId(), headOid, committer, action+": "+subject)
+}
+
+// ================================================================= //
+// APPLICATIONS IN PROGRESS
+// ================================================================= //
+
+// resolve commits the changes that are staged for the patch that
+// stopped the application, and carries on with the rest of it.
+func (b *AmBuiltin) resolve(p *Params, repo *api.DiskRepository) (int, error) {
+	am, err := sequencer.ReadAm(repo)
+	if err != nil {
+		return 0, err
+	}
+	name, email, date, msg, err := sequencer.AmCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	if !am.Quiet {
+		fmt.Fprintf(p.Wout, "Applying: %s\n", strings.SplitN(msg, "\n", 2)[0])
+	}
+	staged, err := stagedChanges(repo)
+	if err != nil {
+		return 0, err
+	}
+	if len(staged) == 0 {
+		fmt.Fprintln(p.Wout, "No changes - did you forget to use 'git add'?")
+		fmt.Fprintln(p.Wout, "If there is nothing left to stage, chances are that something else")
+		fmt.Fprintln(p.Wout, "already introduced the same changes; you might want to skip this patch.")
+		return b.stop(p)
+	}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+	if unmerged(idx) {
+		fmt.Fprintln(p.Wout, "You still have unmerged paths in your index.")
+		fmt.Fprintln(p.Wout, "You should 'git add' each file with resolved conflicts to mark them as such.")
+		fmt.Fprintln(p.Wout, "You might run `git rm` on a file to accept \"deleted by them\" for it.")
+		return b.stop(p)
+	}
+	if err = b.commit(p, repo, am, name, email, date, msg); err != nil {
+		return 0, err
+	}
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	if err = sequencer.NextMail(repo, am, headOid); err != nil {
+		return 0, err
+	}
+	return b.run(p, repo, am, false)
+}
+
+// skip throws away the changes of the patch that stopped the
+// application, and carries on with the rest of it.
+func (b *AmBuiltin) skip(p *Params, repo *api.DiskRepository) (int, error) {
+	am, err := sequencer.ReadAm(repo)
+	if err != nil {
+		return 0, err
+	}
+	t, err := headTree(repo)
+	if err != nil {
+		return 0, err
+	}
+	if err = cleanIndex(repo, t); err != nil {
+		return 0, err
+	}
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	if err = sequencer.NextMail(repo, am, headOid); err != nil {
+		return 0, err
+	}
+	return b.run(p, repo, am, false)
+}
+
+// abort gives up on the application, and goes back to the branch as
+// it was before, unless HEAD has moved since the application stopped,
+// or the application couldn't start, in which case the application is
+// forgotten about.
+func (b *AmBuiltin) abort(p *Params, repo *api.DiskRepository) (int, error) {
+	headRef, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	if sequencer.AmIndexWasDirty(repo) {
+		return 0, sequencer.RemoveAm(repo)
+	}
+	if !sequencer.AmAbortIsSafe(repo, headOid) {
+		fmt.Fprintln(p.Werr, "warning: You seem to have moved HEAD since the last 'am' failure.\nNot rewinding to ORIG_HEAD")
+		return 0, sequencer.RemoveAm(repo)
+	}
+
+	var orig *objects.ObjectId
+	var t *objects.Tree
+	if ref, err := repo.Ref("ORIG_HEAD"); err == nil {
+		orig = ref.ObjectId()
+		c, err := api.CommitFromOid(repo, orig)
+		if err != nil {
+			return 0, err
+		}
+		if t, err = api.TreeFromOid(repo, c.Tree()); err != nil {
+			return 0, err
+		}
+	}
+	if err = cleanIndex(repo, t); err != nil {
+		return 0, err
+	}
+	switch {
+	case orig != nil:
+		config, err := api.ReadConfig(repo)
+		if err != nil {
+			return 0, err
+		}
+		who, err := identity(p.Werr, config, api.RoleCommitter)
+		if err != nil {
+			return 0, err
+		}
+		if err = api.UpdateRef(repo, api.HeadRef, orig, headOid, who, "am --abort"); err != nil {
+			return 0, err
+		}
+	case headRef != api.HeadRef && headOid != nil:
+		if err = api.DeleteRef(repo, headRef, nil); err != nil {
+			return 0, err
+		}
+	}
+	return 0, sequencer.RemoveAm(repo)
+}
+
+// cleanIndex checks out a tree, which is nil for the empty tree, into
+// the index and the working tree, in the way of git-reset --merge,
+// throwing away the changes of the patch that stopped the application,
+// and forgets about a merge in progress.
+func cleanIndex(repo *api.DiskRepository, t *objects.Tree) error {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	if err = checkout.MergeTree(repo, idx, t, new(checkout.Options)); err != nil {
+		return fmt.Errorf("failed to clean index")
+	}
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return err
+	}
+	return removeBranchState(repo)
+}
+
+// showPatch shows the email, or the patch of the email, that stopped
+// the application.
+func (b *AmBuiltin) showPatch(p *Params, repo *api.DiskRepository) (int, error) {
+	var data []byte
+	var err error
+	if b.flagShow.value == "diff" {
+		data, _, err = sequencer.AmPatch(repo)
+	} else {
+		var am *sequencer.Am
+		if am, err = sequencer.ReadAm(repo); err == nil {
+			data, err = sequencer.ReadMail(repo, am.Next)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = p.Wout.Write(data)
+	return 0, err
+}
@@ -0,0 +1,245 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/apply"
+	"github.com/jbrukh/ggit/api/diff"
+	"io/ioutil"
+	"os"
+	"regexp"
+)
+
+// ================================================================= //
+// APPLY
+// ================================================================= //
+
+// ApplyBuiltin implements git-apply, which applies patches to
+// the working tree, to the index, or to both.
+type ApplyBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagCheck            bool
+	flagIndex            bool
+	flagCached           bool
+	flagThreeWay         bool
+	flagReverse          bool
+	flagContext          int
+	flagStrip            int
+	flagWhitespace       string
+	flagIgnoreWhitespace bool
+	flagUnidiffZero      bool
+	flagVerbose          bool
+	flagAllowEmpty       bool
+}
+
+var Apply = &ApplyBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "apply",
+		Description: "Apply a patch to files and/or to the index",
+		UsageLine:   "[--check] [--index | --cached] [-3 | --3way] [-R | --reverse] [-p<n>] [-C<n>] [--unidiff-zero] [--ignore-whitespace] [--whitespace=<action>] [--allow-empty] [-v] [<patch>...]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Apply.BoolVar(&Apply.flagCheck, "check", false, "Only check that the patches apply, without applying them.")
+	Apply.BoolVar(&Apply.flagIndex, "index", false, "Apply the patches to the index as well as to the working tree.")
+	Apply.BoolVar(&Apply.flagCached, "cached", false, "Apply the patches to the index only.")
+	Apply.BoolVar(&Apply.flagThreeWay, "3way", false, "Fall back to a three-way merge when a patch does not apply. Implies --index.")
+	Apply.BoolVar(&Apply.flagThreeWay, "3", false, "Synonym of --3way.")
+	Apply.BoolVar(&Apply.flagReverse, "R", false, "Apply the patches in reverse.")
+	Apply.BoolVar(&Apply.flagReverse, "reverse", false, "Synonym of -R.")
+	Apply.IntVar(&Apply.flagContext, "C", apply.AllContext, "Reduce the context of hunks that don't apply to no less than <n> lines.")
+	Apply.IntVar(&Apply.flagStrip, "p", -1, "Remove <n> leading directories from the paths in the patches.")
+	Apply.StringVar(&Apply.flagWhitespace, "whitespace", "", "What to do with whitespace errors: nowarn, warn, fix, error or error-all.")
+	Apply.BoolVar(&Apply.flagIgnoreWhitespace, "ignore-whitespace", false, "Ignore changes in whitespace when matching context lines.")
+	Apply.BoolVar(&Apply.flagIgnoreWhitespace, "ignore-space-change", false, "Synonym of --ignore-whitespace.")
+	Apply.BoolVar(&Apply.flagUnidiffZero, "unidiff-zero", false, "Apply hunks without context anywhere.")
+	Apply.BoolVar(&Apply.flagVerbose, "v", false, "Report the progress.")
+	Apply.BoolVar(&Apply.flagVerbose, "verbose", false, "Synonym of -v.")
+	Apply.BoolVar(&Apply.flagAllowEmpty, "allow-empty", false, "Don't fail on inputs without patches.")
+
+	Apply.Usage = func() {}
+
+	// add to command list
+	Add(Apply)
+}
+
+// matches the stuck forms of -p and -C, as in -p0 and -C1
+var applyStuckValue = regexp.MustCompile(`^-([pC])([0-9]+)$`)
+
+func (b *ApplyBuiltin) Execute(p *Params, args []string) {
+	for i, arg := range args {
+		if m := applyStuckValue.FindStringSubmatch(arg); m != nil {
+			args[i] = "-" + m[1] + "=" + m[2]
+		}
+	}
+	b.flagCheck, b.flagIndex, b.flagCached, b.flagThreeWay = false, false, false, false
+	b.flagReverse, b.flagContext, b.flagStrip = false, apply.AllContext, -1
+	b.flagWhitespace, b.flagIgnoreWhitespace, b.flagUnidiffZero = "", false, false
+	b.flagVerbose, b.flagAllowEmpty = false, false
+	if err := b.Parse(args); err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if err := b.apply(p, b.Args()); err != nil {
+		fmt.Fprintf(p.Werr, "error: %s\n", err)
+		p.Status = 128
+	}
+}
+
+func (b *ApplyBuiltin) apply(p *Params, files []string) error {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return err
+	}
+	opts, err := b.options(repo)
+	if err != nil {
+		return err
+	}
+
+	// read the patches first, so that a missing one changes nothing
+	type input struct {
+		name string
+		data []byte
+	}
+	var inputs []input
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, file := range files {
+		if file == "-" {
+			data, err := ioutil.ReadAll(p.Rin)
+			if err != nil {
+				return err
+			}
+			inputs = append(inputs, input{"<stdin>", data})
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("can't open patch '%s': %s", file, describeError(err))
+		}
+		inputs = append(inputs, input{file, data})
+	}
+
+	wt := api.NewWorkTree(repo)
+	target := apply.NewWorkTreeTarget(wt)
+	var idx *api.Index
+	var lock *api.LockFile
+	if b.flagIndex || b.flagCached || b.flagThreeWay {
+		if !b.flagCheck {
+			if lock, err = repo.LockIndex(); err != nil {
+				return err
+			}
+			defer func() {
+				if lock != nil {
+					lock.Rollback()
+				}
+			}()
+		}
+		if idx, err = repo.Index(); os.IsNotExist(err) {
+			idx, err = new(api.Index), nil
+		}
+		if err != nil {
+			return err
+		}
+		if b.flagCached {
+			wt = nil
+		}
+		target = apply.NewIndexTarget(repo, idx, wt)
+	}
+
+	applier := apply.NewApplier(repo, target, opts, p.Werr)
+	for _, in := range inputs {
+		switch err := applier.Apply(in.name, in.data); err {
+		case nil:
+		case apply.ErrFailed:
+			// nothing else is applied, as in git
+			p.Status = 1
+			return nil
+		case apply.ErrConflicts:
+			p.Status = 1
+		default:
+			return err
+		}
+	}
+	if err = applier.Finish(); err != nil {
+		return err
+	}
+	if lock != nil {
+		err, lock = repo.WriteIndex(lock, idx), nil
+	}
+	return err
+}
+
+// options returns the options of the application, some of which
+// are configured.
+func (b *ApplyBuiltin) options(repo *api.DiskRepository) (opts apply.Options, err error) {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return opts, err
+	}
+	opts = apply.Options{
+		Strip:            b.flagStrip,
+		Context:          b.flagContext,
+		Reverse:          b.flagReverse,
+		UnidiffZero:      b.flagUnidiffZero,
+		IgnoreWhitespace: b.flagIgnoreWhitespace,
+		ThreeWay:         b.flagThreeWay,
+		Check:            b.flagCheck,
+		Verbose:          b.flagVerbose,
+		AllowEmpty:       b.flagAllowEmpty,
+		Prefix:           workPrefix(repo),
+	}
+	if value, ok := config.Get("apply.ignoreWhitespace"); ok && !b.flagIgnoreWhitespace {
+		switch value {
+		case "change":
+			opts.IgnoreWhitespace = true
+		case "no", "none", "never", "false":
+		default:
+			return opts, fmt.Errorf("unrecognized whitespace ignore option '%s'", value)
+		}
+	}
+
+	// whitespace errors are only looked for by default when the
+	// patches are applied
+	action := b.flagWhitespace
+	if action == "" {
+		action = config.String("apply.whitespace", "")
+	}
+	switch {
+	case action != "":
+		if opts.Whitespace, err = apply.ParseWhitespaceAction(action); err != nil {
+			return opts, err
+		}
+	case b.flagCheck:
+		opts.Whitespace = apply.WhitespaceNoWarn
+	default:
+		opts.Whitespace = apply.WhitespaceWarn
+	}
+	opts.Rule, err = diff.ParseWhitespaceRule(config.String("core.whitespace", ""))
+	return opts, err
+}
+
+// describeError returns the description of the error of a system
+// call, as strerror does.
+func describeError(err error) string {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	if os.IsNotExist(err) {
+		return "No such file or directory"
+	}
+	return err.Error()
+}
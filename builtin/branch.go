@@ -0,0 +1,796 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/util"
+	"strings"
+)
+
+// ================================================================= //
+// BRANCH
+// ================================================================= //
+
+// BranchBuiltin implements git-branch, which lists, creates,
+// deletes and renames branches.
+type BranchBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagVerbose     int
+	flagAll         bool
+	flagRemotes     bool
+	flagList        bool
+	flagContains    stringsFlag
+	flagNoContains  stringsFlag
+	flagMerged      stringsFlag
+	flagNoMerged    stringsFlag
+	flagDelete      bool
+	flagForceDelete bool
+	flagMove        bool
+	flagForceMove   bool
+	flagForce       bool
+	flagUpstream    string
+}
+
+var Branch = &BranchBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "branch",
+		Description: "List, create, or delete branches",
+		UsageLine:   "[-v] [-a | -r] [--list] [--contains <commit>] [--merged [<commit>]] [<pattern>...] | [-f] <name> [<start-point>] | (-d | -D) <name>... | (-m | -M) [<old>] <new> | -u <upstream> [<name>]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Branch.Var(countFlag{&Branch.flagVerbose, 1}, "v", "Show the commit of each branch, and how it relates to its upstream.")
+	Branch.Var(countFlag{&Branch.flagVerbose, 2}, "vv", "Show the names of the upstream branches as well.")
+	Branch.Var(countFlag{&Branch.flagVerbose, 1}, "verbose", "Show the commit of each branch, and how it relates to its upstream.")
+	Branch.BoolVar(&Branch.flagAll, "a", false, "List both local and remote-tracking branches.")
+	Branch.BoolVar(&Branch.flagAll, "all", false, "List both local and remote-tracking branches.")
+	Branch.BoolVar(&Branch.flagRemotes, "r", false, "List or delete remote-tracking branches.")
+	Branch.BoolVar(&Branch.flagRemotes, "remotes", false, "List or delete remote-tracking branches.")
+	Branch.BoolVar(&Branch.flagList, "l", false, "List the branches that match the patterns.")
+	Branch.BoolVar(&Branch.flagList, "list", false, "List the branches that match the patterns.")
+	Branch.Var(&Branch.flagContains, "contains", "Only list the branches that contain the commit.")
+	Branch.Var(&Branch.flagNoContains, "no-contains", "Only list the branches that don't contain the commit.")
+	Branch.Var(&Branch.flagMerged, "merged", "Only list the branches that are merged into the commit.")
+	Branch.Var(&Branch.flagNoMerged, "no-merged", "Only list the branches that are not merged into the commit.")
+	Branch.BoolVar(&Branch.flagDelete, "d", false, "Delete branches, which must be merged.")
+	Branch.BoolVar(&Branch.flagDelete, "delete", false, "Delete branches, which must be merged.")
+	Branch.BoolVar(&Branch.flagForceDelete, "D", false, "Delete branches, merged or not.")
+	Branch.BoolVar(&Branch.flagMove, "m", false, "Rename a branch.")
+	Branch.BoolVar(&Branch.flagMove, "move", false, "Rename a branch.")
+	Branch.BoolVar(&Branch.flagForceMove, "M", false, "Rename a branch, even over an existing one.")
+	Branch.BoolVar(&Branch.flagForce, "f", false, "Reset a branch that exists already.")
+	Branch.BoolVar(&Branch.flagForce, "force", false, "Reset a branch that exists already.")
+	Branch.StringVar(&Branch.flagUpstream, "u", "", "Set up the upstream of a branch.")
+	Branch.StringVar(&Branch.flagUpstream, "set-upstream-to", "", "Set up the upstream of a branch.")
+
+	Branch.Usage = func() {}
+
+	// add to command list
+	Add(Branch)
+}
+
+func (b *BranchBuiltin) Execute(p *Params, args []string) {
+	b.flagVerbose, b.flagAll, b.flagRemotes, b.flagList = 0, false, false, false
+	b.flagContains, b.flagNoContains, b.flagMerged, b.flagNoMerged = nil, nil, nil, nil
+	b.flagDelete, b.flagForceDelete, b.flagMove, b.flagForceMove = false, false, false, false
+	b.flagForce, b.flagUpstream = false, ""
+	args = lastArgDefault(args, api.HeadRef, "--contains", "--no-contains", "--merged", "--no-merged")
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.branch(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *BranchBuiltin) branch(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	filtered := len(b.flagContains) > 0 || len(b.flagNoContains) > 0 || len(b.flagMerged) > 0 || len(b.flagNoMerged) > 0
+	switch {
+	case b.flagDelete || b.flagForceDelete:
+		if len(args) == 0 {
+			return 0, errors.New("branch name required")
+		}
+		return b.delete(p, repo, args)
+	case b.flagMove || b.flagForceMove:
+		return b.rename(p, repo, args)
+	case b.flagUpstream != "":
+		return b.setUpstream(p, repo, args)
+	case len(args) == 0 || b.flagList || filtered:
+		return b.list(p, repo, args)
+	case b.flagAll || b.flagRemotes:
+		fmt.Fprintln(p.Werr, "fatal: The -a, and -r, options to 'git branch' do not take a branch name.")
+		fmt.Fprintln(p.Werr, "Did you mean to use: -a|-r --list <pattern>?")
+		return 128, nil
+	case len(args) > 2:
+		return 0, errors.New("too many arguments")
+	}
+	return b.create(p, repo, args)
+}
+
+// ================================================================= //
+// LISTING
+// ================================================================= //
+
+// a branch as it is listed
+type listedBranch struct {
+	name    string // as it is shown
+	ref     string // the full name of the ref, or HEAD
+	current bool
+	target  string // the branch that a symbolic ref points at
+	commit  *objects.Commit
+}
+
+// list lists the branches that match the patterns, if there are any,
+// and the filters.
+func (b *BranchBuiltin) list(p *Params, repo *api.DiskRepository, patterns []string) (int, error) {
+	headRef, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	filters, status := b.filters(p, repo)
+	if status != 0 {
+		return status, nil
+	}
+
+	var branches []*listedBranch
+	if headRef == api.HeadRef && !b.flagRemotes && len(patterns) == 0 {
+		c, err := api.CommitFromOid(repo, headOid)
+		if err != nil {
+			return 0, err
+		}
+		name := "(no branch)"
+		if desc := detachedStatus(repo, headOid); strings.HasPrefix(desc, "HEAD ") {
+			name = "(" + desc + ")"
+		}
+		branches = append(branches, &listedBranch{name, api.HeadRef, true, "", c})
+	}
+	refs, err := repo.Refs()
+	if err != nil {
+		return 0, err
+	}
+	var prefixes []string
+	if !b.flagRemotes {
+		prefixes = append(prefixes, "refs/heads/")
+	}
+	if b.flagRemotes || b.flagAll {
+		prefixes = append(prefixes, "refs/remotes/")
+	}
+	for _, prefix := range prefixes {
+		for _, r := range refs {
+			if !strings.HasPrefix(r.Name(), prefix) {
+				continue
+			}
+			short := strings.TrimPrefix(r.Name(), prefix)
+			if !matchesAny(patterns, short) {
+				continue
+			}
+			name := short
+			if prefix == "refs/remotes/" && !b.flagRemotes {
+				name = "remotes/" + short
+			}
+			l := &listedBranch{name: name, ref: r.Name(), current: r.Name() == headRef}
+			if sym, err := repo.Ref(r.Name()); err == nil {
+				if symbolic, target := sym.Target(); symbolic {
+					l.target = shortRefName(target.(string))
+				}
+			}
+			if l.commit, err = api.CommitFromOid(repo, r.ObjectId()); err != nil {
+				continue
+			}
+			branches = append(branches, l)
+		}
+	}
+
+	var listed []*listedBranch
+	width := 0
+	for _, l := range branches {
+		ok, err := filters(l.commit)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			listed = append(listed, l)
+			if len(l.name) > width {
+				width = len(l.name)
+			}
+		}
+	}
+
+	var config *api.Config
+	if b.flagVerbose > 0 {
+		if config, err = api.ReadConfig(repo); err != nil {
+			return 0, err
+		}
+	}
+	for _, l := range listed {
+		marker := ' '
+		if l.current {
+			marker = '*'
+		}
+		switch {
+		case l.target != "" && b.flagVerbose > 0:
+			fmt.Fprintf(p.Wout, "%c %-*s -> %s\n", marker, width, l.name, l.target)
+		case l.target != "":
+			fmt.Fprintf(p.Wout, "%c %s -> %s\n", marker, l.name, l.target)
+		case b.flagVerbose > 0:
+			tracking, err := b.tracking(repo, config, l)
+			if err != nil {
+				return 0, err
+			}
+			fmt.Fprintf(p.Wout, "%c %-*s %s %s%s\n", marker, width, l.name, abbrev(l.commit.ObjectId()), tracking, commitSubject(l.commit))
+		default:
+			fmt.Fprintf(p.Wout, "%c %s\n", marker, l.name)
+		}
+	}
+	return 0, nil
+}
+
+// filters returns a function that says whether a commit passes the
+// filters of --contains, --merged and their negations, or a status
+// to exit with if one of their commits is malformed.
+func (b *BranchBuiltin) filters(p *Params, repo *api.DiskRepository) (func(c *objects.Commit) (bool, error), int) {
+	resolve := func(revs []string) ([]*objects.Commit, bool) {
+		var commits []*objects.Commit
+		for _, rev := range revs {
+			c, err := quietCommit(repo, rev)
+			if err != nil {
+				fmt.Fprintf(p.Werr, "error: malformed object name %s\n", rev)
+				return nil, false
+			}
+			commits = append(commits, c)
+		}
+		return commits, true
+	}
+	contains, ok1 := resolve(b.flagContains)
+	noContains, ok2 := resolve(b.flagNoContains)
+	merged, ok3 := resolve(b.flagMerged)
+	noMerged, ok4 := resolve(b.flagNoMerged)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, 129
+	}
+
+	// a commit passes if it is related to any of the commits of
+	// a filter, or to none of them for the negations
+	related := func(commits []*objects.Commit, f func(other *objects.Commit) (bool, error)) (bool, error) {
+		for _, other := range commits {
+			if ok, err := f(other); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	return func(c *objects.Commit) (bool, error) {
+		isIn := func(other *objects.Commit) (bool, error) { return api.IsAncestor(repo, c, other) }
+		has := func(other *objects.Commit) (bool, error) { return api.IsAncestor(repo, other, c) }
+		for _, filter := range []struct {
+			commits []*objects.Commit
+			f       func(*objects.Commit) (bool, error)
+			want    bool
+		}{
+			{contains, has, true},
+			{noContains, has, false},
+			{merged, isIn, true},
+			{noMerged, isIn, false},
+		} {
+			if filter.commits == nil {
+				continue
+			}
+			ok, err := related(filter.commits, filter.f)
+			if err != nil || ok != filter.want {
+				return false, err
+			}
+		}
+		return true, nil
+	}, 0
+}
+
+// tracking describes how a listed branch relates to its upstream,
+// as in "[ahead 1] ", or "[origin/master: ahead 1] " when very
+// verbose.
+func (b *BranchBuiltin) tracking(repo *api.DiskRepository, config *api.Config, l *listedBranch) (string, error) {
+	if !strings.HasPrefix(l.ref, "refs/heads/") {
+		return "", nil
+	}
+	upstream := upstreamRef(config, strings.TrimPrefix(l.ref, "refs/heads/"))
+	if upstream == "" {
+		return "", nil
+	}
+	var desc string
+	if c, err := quietCommit(repo, upstream); err != nil {
+		desc = "gone"
+	} else {
+		ahead, behind, err := aheadBehind(repo, l.commit, c)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case ahead > 0 && behind > 0:
+			desc = fmt.Sprintf("ahead %d, behind %d", ahead, behind)
+		case ahead > 0:
+			desc = fmt.Sprintf("ahead %d", ahead)
+		case behind > 0:
+			desc = fmt.Sprintf("behind %d", behind)
+		}
+	}
+	switch {
+	case b.flagVerbose < 2 && desc == "":
+		return "", nil
+	case b.flagVerbose < 2:
+		return "[" + desc + "] ", nil
+	case desc == "":
+		return "[" + shortRefName(upstream) + "] ", nil
+	}
+	return "[" + shortRefName(upstream) + ": " + desc + "] ", nil
+}
+
+// aheadBehind counts the commits that one commit has and another
+// doesn't, and the other way around.
+func aheadBehind(repo *api.DiskRepository, one, two *objects.Commit) (ahead, behind int, err error) {
+	reachable := func(c *objects.Commit) (map[string]bool, error) {
+		seen := make(map[string]bool)
+		err := api.WalkCommits(repo, []*objects.Commit{c}, func(c *objects.Commit) error {
+			seen[c.ObjectId().String()] = true
+			return nil
+		})
+		return seen, err
+	}
+	ones, err := reachable(one)
+	if err != nil {
+		return 0, 0, err
+	}
+	twos, err := reachable(two)
+	if err != nil {
+		return 0, 0, err
+	}
+	for oid := range ones {
+		if !twos[oid] {
+			ahead++
+		}
+	}
+	for oid := range twos {
+		if !ones[oid] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// ================================================================= //
+// CREATING
+// ================================================================= //
+
+// create creates a branch at a start point, which is HEAD by
+// default, or resets it there with -f.
+func (b *BranchBuiltin) create(p *Params, repo *api.DiskRepository, args []string) (int, error) {
+	name := args[0]
+	full := "refs/heads/" + name
+	if !validBranchName(name) {
+		return 0, fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	headRef, _, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	old, err := quietCommit(repo, full)
+	if err == nil {
+		if !b.flagForce {
+			return 0, fmt.Errorf("a branch named '%s' already exists", name)
+		}
+		if full == headRef {
+			return 0, fmt.Errorf("cannot force update the branch '%s' checked out at '%s'", name, repo.WorkDir())
+		}
+	}
+
+	// the start point is named as the user named it, or after the
+	// current branch
+	start := api.HeadRef
+	if len(args) > 1 {
+		start = args[1]
+	} else if headRef != api.HeadRef {
+		start = shortRefName(headRef)
+	}
+	c, err := quietCommit(repo, start)
+	if err != nil {
+		return 0, fmt.Errorf("not a valid object name: '%s'", start)
+	}
+
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	msg := "branch: Created from " + start
+	var expected *objects.ObjectId
+	if old != nil {
+		msg, expected = "branch: Reset to "+start, old.ObjectId()
+	}
+	if err = api.UpdateRef(repo, full, c.ObjectId(), expected, who, msg); err != nil {
+		return 0, err
+	}
+
+	// branches that start at remote-tracking branches track them
+	if len(args) > 1 {
+		if upstream := dwimRemoteRef(repo, start); upstream != "" {
+			return 0, trackUpstream(p, repo, config, name, upstream)
+		}
+	}
+	return 0, nil
+}
+
+// ================================================================= //
+// DELETING
+// ================================================================= //
+
+// delete deletes branches, which must be merged into their upstream,
+// or into HEAD, unless -D is given.
+func (b *BranchBuiltin) delete(p *Params, repo *api.DiskRepository, names []string) (int, error) {
+	headRef, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	var head *objects.Commit
+	if headOid != nil {
+		if head, err = api.CommitFromOid(repo, headOid); err != nil {
+			return 0, err
+		}
+	}
+	force := b.flagForceDelete || b.flagForce
+	status := 0
+	for _, name := range names {
+		full, kind := "refs/heads/"+name, "branch"
+		if b.flagRemotes {
+			full, kind = "refs/remotes/"+name, "remote-tracking branch"
+		}
+		c, err := quietCommit(repo, full)
+		if err != nil {
+			fmt.Fprintf(p.Werr, "error: %s '%s' not found.\n", kind, name)
+			status = 1
+			continue
+		}
+		if full == headRef {
+			fmt.Fprintf(p.Werr, "error: Cannot delete branch '%s' checked out at '%s'\n", name, repo.WorkDir())
+			status = 1
+			continue
+		}
+		if !force && !b.flagRemotes {
+			merged, err := branchMerged(p, repo, config, name, c, head)
+			if err != nil {
+				return 0, err
+			}
+			if !merged {
+				fmt.Fprintf(p.Werr, "error: The branch '%s' is not fully merged.\n", name)
+				fmt.Fprintf(p.Werr, "If you are sure you want to delete it, run 'git branch -D %s'.\n", name)
+				status = 1
+				continue
+			}
+		}
+		if err = api.DeleteRef(repo, full, c.ObjectId()); err != nil {
+			return 0, err
+		}
+		if !b.flagRemotes {
+			if err = api.RemoveConfigSection(repo, "branch."+name); err != nil {
+				return 0, err
+			}
+		}
+		fmt.Fprintf(p.Wout, "Deleted %s %s (was %s).\n", kind, name, abbrev(c.ObjectId()))
+	}
+	return status, nil
+}
+
+// branchMerged returns true if a branch is merged into its upstream,
+// or into HEAD if it has none, and warns if HEAD disagrees.
+func branchMerged(p *Params, repo *api.DiskRepository, config *api.Config, name string, c, head *objects.Commit) (bool, error) {
+	reference := head
+	upstream := upstreamRef(config, name)
+	if upstream != "" {
+		if u, err := quietCommit(repo, upstream); err == nil {
+			reference = u
+		} else {
+			upstream = ""
+		}
+	}
+	if reference == nil {
+		return false, nil
+	}
+	merged, err := api.IsAncestor(repo, c, reference)
+	if err != nil || upstream == "" || head == nil {
+		return merged, err
+	}
+	expected, err := api.IsAncestor(repo, c, head)
+	if err != nil || expected == merged {
+		return merged, err
+	}
+	if merged {
+		fmt.Fprintf(p.Werr, "warning: deleting branch '%s' that has been merged to\n         '%s', but not yet merged to HEAD.\n", name, upstream)
+	} else {
+		fmt.Fprintf(p.Werr, "warning: not deleting branch '%s' that is not yet merged to\n         '%s', even though it is merged to HEAD.\n", name, upstream)
+	}
+	return merged, nil
+}
+
+// ================================================================= //
+// RENAMING
+// ================================================================= //
+
+// rename renames a branch, which is the current one if only the new
+// name is given, along with its log and its configuration.
+func (b *BranchBuiltin) rename(p *Params, repo *api.DiskRepository, args []string) (int, error) {
+	headRef, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	var old, name string
+	switch len(args) {
+	case 0:
+		return 0, errors.New("branch name required")
+	case 1:
+		if headRef == api.HeadRef {
+			return 0, errors.New("cannot rename the current branch while not on any branch")
+		}
+		old, name = strings.TrimPrefix(headRef, "refs/heads/"), args[0]
+	case 2:
+		old, name = args[0], args[1]
+	default:
+		return 0, errors.New("too many arguments for a rename operation")
+	}
+	oldRef, full := "refs/heads/"+old, "refs/heads/"+name
+
+	// an unborn current branch is only renamed in HEAD
+	unborn := oldRef == headRef && headOid == nil
+	if _, err := quietCommit(repo, oldRef); err != nil && !unborn {
+		return 0, fmt.Errorf("No branch named '%s'.", old)
+	}
+	if !validBranchName(name) {
+		return 0, fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	if _, err := quietCommit(repo, full); err == nil && full != oldRef {
+		if !b.flagForceMove {
+			return 0, fmt.Errorf("a branch named '%s' already exists", name)
+		}
+		if full == headRef {
+			return 0, fmt.Errorf("cannot force update the branch '%s' checked out at '%s'", name, repo.WorkDir())
+		}
+	}
+	if unborn {
+		return 0, api.SetHead(repo, full, nil, nil, "")
+	}
+
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	msg := fmt.Sprintf("Branch: renamed %s to %s", oldRef, full)
+	if err = api.RenameRef(repo, oldRef, full, who, msg); err != nil {
+		fmt.Fprintf(p.Werr, "error: %s\n", err)
+		return 0, errors.New("Branch rename failed")
+	}
+	return 0, api.RenameConfigSection(repo, "branch."+old, "branch."+name)
+}
+
+// ================================================================= //
+// TRACKING
+// ================================================================= //
+
+// the advice that is given when an upstream doesn't exist
+const upstreamAdvice = `hint: 
+hint: If you are planning on basing your work on an upstream
+hint: branch that already exists at the remote, you may need to
+hint: run "git fetch" to retrieve it.
+hint: 
+hint: If you are planning to push out a new local branch that
+hint: will track its remote counterpart, you may want to use
+hint: "git push -u" to set the upstream config as you push.
+hint: Disable this message with "git config advice.setUpstreamFailure false"
+`
+
+// setUpstream sets up a branch, which is the current one by default,
+// to track an upstream branch.
+func (b *BranchBuiltin) setUpstream(p *Params, repo *api.DiskRepository, args []string) (int, error) {
+	if len(args) > 1 {
+		return 0, errors.New("too many arguments to set new upstream")
+	}
+	var name string
+	if len(args) == 1 {
+		name = args[0]
+	} else {
+		headRef, _, err := api.Head(repo)
+		if err != nil {
+			return 0, err
+		}
+		if headRef == api.HeadRef {
+			return 0, fmt.Errorf("could not set upstream of HEAD to %s when it does not point to any branch.", b.flagUpstream)
+		}
+		name = strings.TrimPrefix(headRef, "refs/heads/")
+	}
+	if _, err := quietCommit(repo, "refs/heads/"+name); err != nil {
+		return 0, fmt.Errorf("branch '%s' does not exist", name)
+	}
+	upstream := dwimRemoteRef(repo, b.flagUpstream)
+	if upstream == "" {
+		if _, err := quietCommit(repo, "refs/heads/"+b.flagUpstream); err == nil {
+			upstream = "refs/heads/" + b.flagUpstream
+		}
+	}
+	if upstream == "" {
+		fmt.Fprintf(p.Werr, "fatal: the requested upstream branch '%s' does not exist\n", b.flagUpstream)
+		fmt.Fprint(p.Werr, upstreamAdvice)
+		return 128, nil
+	}
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	return 0, trackUpstream(p, repo, config, name, upstream)
+}
+
+// trackUpstream configures a branch to track an upstream ref, which
+// is a local branch or a remote-tracking branch, and says so.
+func trackUpstream(p *Params, repo *api.DiskRepository, config *api.Config, name, upstream string) error {
+	remote, merge := ".", upstream
+	if strings.HasPrefix(upstream, "refs/remotes/") {
+		remote = strings.SplitN(strings.TrimPrefix(upstream, "refs/remotes/"), "/", 2)[0]
+		merge = ""
+		for _, spec := range config.GetAll("remote." + remote + ".fetch") {
+			if src, ok := reverseRefspec(spec, upstream); ok {
+				merge = src
+				break
+			}
+		}
+		if merge == "" {
+			return fmt.Errorf("cannot set up tracking information; starting point '%s' is not a branch", shortRefName(upstream))
+		}
+	}
+	if err := api.SetConfig(repo, "branch."+name+".remote", remote); err != nil {
+		return err
+	}
+	if err := api.SetConfig(repo, "branch."+name+".merge", merge); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.Wout, "branch '%s' set up to track '%s'.\n", name, shortRefName(upstream))
+	return nil
+}
+
+// upstreamRef returns the ref that a branch tracks, according to
+// the configuration, or the empty string if it tracks nothing.
+func upstreamRef(config *api.Config, name string) string {
+	remote, ok1 := config.Get("branch." + name + ".remote")
+	merge, ok2 := config.Get("branch." + name + ".merge")
+	if !ok1 || !ok2 {
+		return ""
+	}
+	if remote == "." {
+		return merge
+	}
+	for _, spec := range config.GetAll("remote." + remote + ".fetch") {
+		if dst, ok := applyRefspec(spec, merge); ok {
+			return dst
+		}
+	}
+	return ""
+}
+
+// applyRefspec maps a ref through a fetch refspec, as in
+// "+refs/heads/*:refs/remotes/origin/*", from its source to its
+// destination, if the ref matches the source.
+func applyRefspec(spec, ref string) (string, bool) {
+	src, dst := splitRefspec(spec)
+	return mapRef(src, dst, ref)
+}
+
+// reverseRefspec maps a ref through a fetch refspec from its
+// destination back to its source.
+func reverseRefspec(spec, ref string) (string, bool) {
+	src, dst := splitRefspec(spec)
+	return mapRef(dst, src, ref)
+}
+
+func splitRefspec(spec string) (src, dst string) {
+	spec = strings.TrimPrefix(spec, "+")
+	if i := strings.Index(spec, ":"); i >= 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, ""
+}
+
+// mapRef maps a ref that matches a pattern, which may have a "*",
+// to the other side of the pattern.
+func mapRef(from, to, ref string) (string, bool) {
+	i := strings.Index(from, "*")
+	if i < 0 {
+		return to, from == ref && to != ""
+	}
+	prefix, suffix := from[:i], from[i+1:]
+	if !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) || len(ref) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	return strings.Replace(to, "*", ref[len(prefix):len(ref)-len(suffix)], 1), true
+}
+
+// dwimRemoteRef returns the full name of the remote-tracking branch
+// that a name stands for, or the empty string if it stands for none.
+func dwimRemoteRef(repo *api.DiskRepository, name string) string {
+	for _, full := range []string{name, "refs/" + name, "refs/remotes/" + name} {
+		if strings.HasPrefix(full, "refs/remotes/") {
+			if _, err := quietCommit(repo, full); err == nil {
+				return full
+			}
+		}
+	}
+	return ""
+}
+
+// ================================================================= //
+// UTILITIES
+// ================================================================= //
+
+// shortRefName returns the name of a branch, a remote-tracking
+// branch or a tag without the prefix of its kind.
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/", "refs/tags/"} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+// matchesAny returns true if there are no patterns, or if a name
+// matches one of them.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if util.Wildmatch(pattern, name, 0) {
+			return true
+		}
+	}
+	return len(patterns) == 0
+}
+
+// quietCommit resolves a revision to a commit, quietly.
+func quietCommit(repo *api.DiskRepository, rev string) (*objects.Commit, error) {
+	o, err := api.LookupRevision(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	if o, err = api.PeelObject(repo, o); err != nil {
+		return nil, err
+	}
+	c, ok := o.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a commit", rev)
+	}
+	return c, nil
+}
@@ -59,8 +59,13 @@ func All() []Builtin {
 
 type Params struct {
 	Repo api.Repository
+	Rin  io.Reader
 	Wout io.Writer
 	Werr io.Writer
+
+	// Status is the exit status of the builtin, which it sets
+	// when it fails.
+	Status int
 }
 
 type Builtin interface {
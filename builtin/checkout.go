@@ -0,0 +1,659 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/checkout"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"sort"
+	"strings"
+)
+
+// ================================================================= //
+// CHECKOUT
+// ================================================================= //
+
+// CheckoutBuiltin implements git-checkout, which switches branches
+// or restores the files of the working tree.
+type CheckoutBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagBranch string
+	flagForce  bool
+	flagQuiet  bool
+	flagDetach bool
+}
+
+var Checkout = &CheckoutBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "checkout",
+		Description: "Switch branches or restore working tree files",
+		UsageLine:   "[-q] [-f] [--detach] [-b <new-branch>] [<branch> | <commit>] | [<tree-ish>] [--] <pathspec>...",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Checkout.StringVar(&Checkout.flagBranch, "b", "", "Create a new branch and switch to it.")
+	Checkout.BoolVar(&Checkout.flagForce, "f", false, "Throw away local changes when switching branches.")
+	Checkout.BoolVar(&Checkout.flagForce, "force", false, "Throw away local changes when switching branches.")
+	Checkout.BoolVar(&Checkout.flagQuiet, "q", false, "Suppress feedback messages.")
+	Checkout.BoolVar(&Checkout.flagQuiet, "quiet", false, "Suppress feedback messages.")
+	Checkout.BoolVar(&Checkout.flagDetach, "detach", false, "Detach HEAD at the commit instead of switching to a branch.")
+
+	Checkout.Usage = func() {}
+
+	// add to command list
+	Add(Checkout)
+}
+
+func (b *CheckoutBuiltin) Execute(p *Params, args []string) {
+	b.flagBranch, b.flagForce, b.flagQuiet, b.flagDetach = "", false, false, false
+	args, paths, dashdash := splitDashDash(args)
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.checkout(p, args, paths, dashdash)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *CheckoutBuiltin) checkout(p *Params, args, paths []string, dashdash bool) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	if dashdash {
+		switch len(args) {
+		case 0:
+			return checkoutPaths(p, repo, nil, paths, false)
+		case 1:
+			t, err := quietTree(repo, args[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid reference: %s", args[0])
+			}
+			return checkoutPaths(p, repo, t, paths, false)
+		}
+		return 0, fmt.Errorf("only one reference expected, %d given.", len(args))
+	}
+
+	// the first argument is a branch or a commit if it names one,
+	// and the arguments are otherwise all paths
+	var target *switchTarget
+	if len(args) > 0 {
+		if target, err = resolveSwitchTarget(repo, args[0]); err != nil {
+			return 0, err
+		}
+	}
+	switch {
+	case target == nil && len(args) > 0:
+		if b.flagBranch != "" {
+			return 0, fmt.Errorf("'%s' is not a commit and a branch '%s' cannot be created from it", args[0], b.flagBranch)
+		}
+		return checkoutPaths(p, repo, nil, args, !b.flagQuiet)
+	case len(args) > 1:
+		if b.flagBranch != "" {
+			return 0, fmt.Errorf("Cannot update paths and switch to branch '%s' at the same time.", b.flagBranch)
+		}
+		t, err := api.TreeFromOid(repo, target.commit.Tree())
+		if err != nil {
+			return 0, err
+		}
+		return checkoutPaths(p, repo, t, args[1:], !b.flagQuiet)
+	case target == nil:
+		// the current branch is checked out again
+		if target, err = currentTarget(repo); err != nil {
+			return 0, err
+		}
+	}
+	if b.flagDetach {
+		if b.flagBranch != "" {
+			return 0, errors.New("'--detach' cannot be used with '-b/-B/--orphan'")
+		}
+		target.branch = ""
+	}
+	opts := &switchOptions{
+		newBranch: b.flagBranch,
+		force:     b.flagForce,
+		quiet:     b.flagQuiet,
+		detach:    b.flagDetach,
+		advice:    "-c with the switch command",
+	}
+	return switchBranches(p, repo, target, opts)
+}
+
+// ================================================================= //
+// SWITCHING BRANCHES
+// ================================================================= //
+
+// switchTarget is what HEAD is switched to: a branch, or else a
+// commit that HEAD is detached at.
+type switchTarget struct {
+	name   string // as the user named it
+	branch string // the full name of the branch, if it is one
+	commit *objects.Commit
+}
+
+// switchOptions are the options of a switch of branches.
+type switchOptions struct {
+	newBranch string // the name of a branch to create first
+	force     bool
+	quiet     bool
+	detach    bool   // HEAD is detached on purpose
+	hide      bool   // local changes aren't shown
+	advice    string // how to create a branch, in the advice
+}
+
+// the advice that is given when HEAD is detached
+const detachedAdvice = `Note: switching to '%s'.
+
+You are in 'detached HEAD' state. You can look around, make experimental
+changes and commit them, and you can discard any commits you make in this
+state without impacting any branches by switching back to a branch.
+
+If you want to create a new branch to retain commits you create, you may
+do so (now or later) by using %s. Example:
+
+  git switch -c <new-branch-name>
+
+Or undo this operation with:
+
+  git switch -
+
+Turn off this advice by setting config variable advice.detachedHead to false
+
+`
+
+// resolveSwitchTarget resolves a name to a branch, or to a commit,
+// or returns nil if it names neither. A name of "-" stands for
+// what was checked out before the current branch.
+func resolveSwitchTarget(repo *api.DiskRepository, name string) (*switchTarget, error) {
+	if name == "-" {
+		if name = previousCheckout(repo); name == "" {
+			return nil, errors.New("invalid reference: @{-1}")
+		}
+	}
+	if api.ValidRefName("refs/heads/" + name) {
+		if r, err := repo.Ref("refs/heads/" + name); err == nil {
+			if r, err = api.PeelRef(repo, r); err == nil {
+				c, err := api.CommitFromOid(repo, r.ObjectId())
+				if err != nil {
+					return nil, err
+				}
+				return &switchTarget{name, "refs/heads/" + name, c}, nil
+			}
+		}
+	}
+	o, err := api.LookupRevision(repo, name)
+	if err != nil {
+		return nil, nil
+	}
+	if o, err = api.PeelObject(repo, o); err != nil {
+		return nil, err
+	}
+	c, ok := o.(*objects.Commit)
+	if !ok {
+		return nil, nil
+	}
+	return &switchTarget{name: name, commit: c}, nil
+}
+
+// currentTarget returns the target of a switch to what is checked
+// out already.
+func currentTarget(repo *api.DiskRepository) (*switchTarget, error) {
+	ref, _, err := api.Head(repo)
+	if err != nil {
+		return nil, err
+	}
+	c, err := headCommit(repo)
+	if err != nil {
+		return nil, err
+	}
+	if ref == api.HeadRef {
+		ref = ""
+	}
+	return &switchTarget{api.HeadRef, ref, c}, nil
+}
+
+// previousCheckout returns the name of what was checked out before
+// the latest checkout, according to the log of HEAD, or the empty
+// string if there was nothing.
+func previousCheckout(repo *api.DiskRepository) string {
+	entries, err := repo.Reflog(api.HeadRef)
+	if err != nil {
+		return ""
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message()
+		if !strings.HasPrefix(msg, "checkout: moving from ") {
+			continue
+		}
+		msg = strings.TrimPrefix(msg, "checkout: moving from ")
+		if j := strings.LastIndex(msg, " to "); j >= 0 {
+			return msg[:j]
+		}
+	}
+	return ""
+}
+
+// switchBranches checks out the tree of the commit of a target,
+// and points HEAD at it, creating a new branch first if asked.
+func switchBranches(p *Params, repo *api.DiskRepository, target *switchTarget, opts *switchOptions) (int, error) {
+	if opts.newBranch != "" {
+		full := "refs/heads/" + opts.newBranch
+		if !validBranchName(opts.newBranch) {
+			return 0, fmt.Errorf("'%s' is not a valid branch name", opts.newBranch)
+		}
+		if _, err := repo.Ref(full); err == nil {
+			return 0, fmt.Errorf("a branch named '%s' already exists", opts.newBranch)
+		}
+		if err := api.CheckNewRef(repo, full); err != nil {
+			return 0, err
+		}
+		if target.commit == nil {
+			// an unborn branch is only renamed
+			if err := api.SetHead(repo, full, nil, nil, ""); err != nil {
+				return 0, err
+			}
+			if !opts.quiet {
+				fmt.Fprintf(p.Werr, "Switched to a new branch '%s'\n", opts.newBranch)
+			}
+			return 0, nil
+		}
+	}
+	if target.commit == nil {
+		return 0, errors.New("You are on a branch yet to be born")
+	}
+	oldRef, oldOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	old, err := headCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	newOid := target.commit.ObjectId()
+
+	// the index and the working tree are only touched if the
+	// commit changes, or if local changes are thrown away, and
+	// not at all for a new branch that starts at HEAD
+	current := target.name == api.HeadRef
+	if !current || opts.newBranch == "" {
+		if old == nil || old.ObjectId().String() != newOid.String() || opts.force {
+			status, err := switchTrees(p, repo, old, target.commit, opts.force)
+			if status != 0 || err != nil {
+				return status, err
+			}
+		}
+		if !opts.quiet && !opts.force && !opts.hide {
+			if err = showLocalChanges(p, repo, target.commit); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if current && opts.newBranch == "" && !opts.detach {
+		return 0, nil
+	}
+	if !opts.quiet && oldRef == api.HeadRef && old != nil && old.ObjectId().String() != newOid.String() {
+		if err = warnOrphans(p, repo, old, target.commit); err != nil {
+			return 0, err
+		}
+	}
+
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	name, branch := target.name, target.branch
+	if opts.newBranch != "" {
+		branch, name = "refs/heads/"+opts.newBranch, opts.newBranch
+		if err = api.UpdateRef(repo, branch, newOid, nil, who, "branch: Created from "+target.name); err != nil {
+			return 0, err
+		}
+	}
+	from := strings.TrimPrefix(oldRef, "refs/heads/")
+	if oldRef == api.HeadRef {
+		from = oldOid.String()
+	}
+	// a HEAD that is detached at the commit already isn't moved
+	if branch != "" || oldRef != api.HeadRef || oldOid.String() != newOid.String() {
+		if err = api.SetHead(repo, branch, newOid, who, fmt.Sprintf("checkout: moving from %s to %s", from, name)); err != nil {
+			return 0, err
+		}
+	}
+
+	if opts.quiet {
+		return 0, nil
+	}
+	short := strings.TrimPrefix(branch, "refs/heads/")
+	switch {
+	case branch == "":
+		if oldRef != api.HeadRef && !opts.detach {
+			fmt.Fprintf(p.Werr, detachedAdvice, target.name, opts.advice)
+		}
+		fmt.Fprintf(p.Werr, "HEAD is now at %s %s\n", abbrev(newOid), commitSubject(target.commit))
+	case branch == oldRef:
+		fmt.Fprintf(p.Werr, "Already on '%s'\n", short)
+	case opts.newBranch != "":
+		fmt.Fprintf(p.Werr, "Switched to a new branch '%s'\n", short)
+	default:
+		fmt.Fprintf(p.Werr, "Switched to branch '%s'\n", short)
+	}
+	return 0, nil
+}
+
+// switchTrees checks out the tree of a commit into the index and
+// the working tree, over the tree of another, which may be nil. It
+// explains why it can't if that would lose local changes.
+func switchTrees(p *Params, repo *api.DiskRepository, old, c *objects.Commit, force bool) (int, error) {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+	var from *objects.Tree
+	if old != nil {
+		if from, err = api.TreeFromOid(repo, old.Tree()); err != nil {
+			return 0, err
+		}
+	}
+	to, err := api.TreeFromOid(repo, c.Tree())
+	if err != nil {
+		return 0, err
+	}
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkout.SwitchTrees(repo, idx, from, to, &checkout.Options{Force: force, Ignorer: ig})
+	switch e := err.(type) {
+	case nil:
+	case *checkout.UnmergedError:
+		fmt.Fprintf(p.Werr, "error: %s\n", e)
+		for _, name := range e.Paths {
+			fmt.Fprintf(p.Wout, "%s: needs merge\n", name)
+		}
+		return 1, nil
+	case *checkout.Error:
+		for _, msg := range e.Messages() {
+			fmt.Fprintf(p.Werr, "error: %s\n", msg)
+		}
+		fmt.Fprintln(p.Werr, "Aborting")
+		return 1, nil
+	default:
+		return 0, err
+	}
+
+	err, lock = repo.WriteIndex(lock, idx), nil
+	return 0, err
+}
+
+// showLocalChanges lists the files whose local changes were carried
+// over to a commit that was checked out.
+func showLocalChanges(p *Params, repo *api.DiskRepository, c *objects.Commit) error {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	t, err := api.TreeFromOid(repo, c.Tree())
+	if err != nil {
+		return err
+	}
+	td, err := diff.DiffTreeWorkTree(repo, t, api.NewWorkTree(repo), idx, new(diff.Options))
+	if err != nil {
+		return err
+	}
+	return diff.WriteNameStatus(p.Wout, td)
+}
+
+// the number of commits that are left behind that are listed
+const orphanCutoff = 4
+
+// warnOrphans warns about the commits that a detached HEAD is
+// leaving behind, which no ref can reach anymore, or else tells
+// where HEAD was.
+func warnOrphans(p *Params, repo *api.DiskRepository, old, c *objects.Commit) error {
+	lost, err := lostCommits(repo, old, c)
+	if err != nil {
+		return err
+	}
+	if len(lost) == 0 {
+		fmt.Fprintf(p.Werr, "Previous HEAD position was %s %s\n", abbrev(old.ObjectId()), commitSubject(old))
+		return nil
+	}
+	var list string
+	for i, l := range lost {
+		if i < orphanCutoff {
+			list += fmt.Sprintf("  %s %s\n", abbrev(l.ObjectId()), commitSubject(l))
+		}
+	}
+	switch more := len(lost) - orphanCutoff; {
+	case more == 1:
+		l := lost[len(lost)-1]
+		list += fmt.Sprintf("  %s %s\n", abbrev(l.ObjectId()), commitSubject(l))
+	case more > 1:
+		list += fmt.Sprintf(" ... and %d more.\n", more)
+	}
+	commits, them := "commit", "it"
+	if len(lost) > 1 {
+		commits, them = "commits", "them"
+	}
+	fmt.Fprintf(p.Werr, "Warning: you are leaving %d %s behind, not connected to\nany of your branches:\n\n%s\n", len(lost), commits, list)
+	fmt.Fprintf(p.Werr, "If you want to keep %s by creating a new branch, this may be a good time\nto do so with:\n\n git branch <new-branch-name> %s\n\n", them, abbrev(old.ObjectId()))
+	return nil
+}
+
+// lostCommits returns the commits that are reachable from a commit
+// but from no ref, nor from another commit, newest first.
+func lostCommits(repo *api.DiskRepository, old, c *objects.Commit) ([]*objects.Commit, error) {
+	reachable := make(map[string]bool)
+	starts := []*objects.Commit{c}
+	refs, err := repo.Refs()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Name(), "refs/") {
+			continue
+		}
+		if r, err = api.PeelRef(repo, r); err != nil {
+			continue
+		}
+		if o, err := repo.ObjectFromOid(r.ObjectId()); err == nil {
+			if o, err = api.PeelObject(repo, o); err == nil {
+				if rc, ok := o.(*objects.Commit); ok {
+					starts = append(starts, rc)
+				}
+			}
+		}
+	}
+	walk := func(starts []*objects.Commit, f func(*objects.Commit) bool) error {
+		queue := starts
+		for len(queue) > 0 {
+			next := queue[0]
+			queue = queue[1:]
+			if !f(next) {
+				continue
+			}
+			for _, oid := range next.Parents() {
+				parent, err := api.CommitFromOid(repo, oid)
+				if err != nil {
+					return err
+				}
+				queue = append(queue, parent)
+			}
+		}
+		return nil
+	}
+	err = walk(starts, func(c *objects.Commit) bool {
+		if reachable[c.ObjectId().String()] {
+			return false
+		}
+		reachable[c.ObjectId().String()] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var lost []*objects.Commit
+	err = walk([]*objects.Commit{old}, func(c *objects.Commit) bool {
+		if reachable[c.ObjectId().String()] {
+			return false
+		}
+		reachable[c.ObjectId().String()] = true
+		lost = append(lost, c)
+		return true
+	})
+	sort.SliceStable(lost, func(i, j int) bool {
+		return lost[i].Committer().Seconds() > lost[j].Committer().Seconds()
+	})
+	return lost, err
+}
+
+// ================================================================= //
+// CHECKING OUT PATHS
+// ================================================================= //
+
+// checkoutPaths restores the paths that match the pathspecs from
+// the index, or from a tree into both the index and the working
+// tree, and says how many files it updated if asked to.
+func checkoutPaths(p *Params, repo *api.DiskRepository, t *objects.Tree, args []string, count bool) (int, error) {
+	opts := &checkout.RestoreOptions{Source: t, Staged: t != nil, WorkTree: true, Overlay: true}
+	n, status, err := restorePaths(p, repo, args, opts)
+	if status != 0 || err != nil || !count {
+		return status, err
+	}
+	paths := "paths"
+	if n == 1 {
+		paths = "path"
+	}
+	from := "the index"
+	if t != nil {
+		from = abbrev(t.ObjectId())
+	}
+	fmt.Fprintf(p.Werr, "Updated %d %s from %s\n", n, paths, from)
+	return 0, nil
+}
+
+// restorePaths restores the paths that match the pathspecs, each of
+// which must match a path of the index or of the source. It returns
+// the number of files that were updated.
+func restorePaths(p *Params, repo *api.DiskRepository, args []string, opts *checkout.RestoreOptions) (n, status int, err error) {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, 0, err
+	}
+	prefix := workPrefix(repo)
+	for _, arg := range args {
+		one, err := pathspec.Parse(prefix, []string{arg}, 0)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !matchesIndex(one, idx) && !matchesTree(repo, one, opts.Source) {
+			fmt.Fprintf(p.Werr, "error: pathspec '%s' did not match any file(s) known to git\n", arg)
+			status = 1
+		}
+	}
+	if status != 0 {
+		return 0, status, nil
+	}
+	ps, err := addPathspec(repo, prefix, args)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n, err = checkout.RestorePaths(repo, idx, ps, opts)
+	if e, ok := err.(*checkout.PathError); ok {
+		fmt.Fprintf(p.Werr, "error: %s\n", e)
+		return 0, 1, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	err, lock = repo.WriteIndex(lock, idx), nil
+	return n, 0, err
+}
+
+// matchesTree returns true if the pathspec matches a file of a
+// tree, which may be nil.
+func matchesTree(repo *api.DiskRepository, ps *pathspec.Pathspec, t *objects.Tree) bool {
+	if t == nil {
+		return false
+	}
+	matched := false
+	api.WalkTree(repo, t, "", func(pth string, e *objects.TreeEntry) error {
+		if e.ObjectType() != objects.ObjectTree && ps.Match(pth, false) {
+			matched = true
+		}
+		return nil
+	})
+	return matched
+}
+
+// ================================================================= //
+// UTILITIES
+// ================================================================= //
+
+// splitDashDash splits the arguments at the first "--", and returns
+// the ones before it and the ones after it, and whether it was given.
+func splitDashDash(args []string) (before, after []string, dashdash bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:], true
+		}
+	}
+	return args, nil, false
+}
+
+// validBranchName returns true if a name can be given to a branch.
+func validBranchName(name string) bool {
+	return name != api.HeadRef && !strings.HasPrefix(name, "-") && api.ValidRefName("refs/heads/"+name)
+}
+
+// quietTree resolves a revision to a tree, without the debugging
+// output of the parser.
+func quietTree(repo *api.DiskRepository, rev string) (*objects.Tree, error) {
+	o, err := api.LookupRevision(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	return api.TreeFromObject(repo, o)
+}
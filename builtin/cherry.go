@@ -0,0 +1,189 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"strings"
+)
+
+// ================================================================= //
+// CHERRY
+// ================================================================= //
+
+// CherryBuiltin implements git-cherry, which lists the commits of a
+// branch that its upstream doesn't have, marking the ones whose
+// changes the upstream has anyway, as their patch ids show, with "-",
+// and the others with "+".
+type CherryBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagVerbose bool
+}
+
+var Cherry = &CherryBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "cherry",
+		Description: "Find commits yet to be applied to upstream",
+		UsageLine:   "[-v] [<upstream> [<head> [<limit>]]]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Cherry.BoolVar(&Cherry.flagVerbose, "v", false, "Show the subjects of the commits.")
+	Cherry.BoolVar(&Cherry.flagVerbose, "verbose", false, "Synonym of -v.")
+
+	Cherry.Usage = func() {}
+
+	// add to command list
+	Add(Cherry)
+}
+
+func (b *CherryBuiltin) Execute(p *Params, args []string) {
+	b.flagVerbose = false
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil || len(args) > 3 {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.cherry(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+// cherry lists the commits that are reachable from head, which is
+// HEAD unless it is given, but neither from the upstream, which is the
+// branch that HEAD tracks unless it is given, nor from the limit,
+// oldest first.
+func (b *CherryBuiltin) cherry(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	var upstreamName, headName, limitName string
+	switch len(args) {
+	case 3:
+		limitName = args[2]
+		fallthrough
+	case 2:
+		headName = args[1]
+		fallthrough
+	case 1:
+		upstreamName = args[0]
+	default:
+		config, err := api.ReadConfig(repo)
+		if err != nil {
+			return 0, err
+		}
+		if ref, _, err := api.Head(repo); err != nil {
+			return 0, err
+		} else if strings.HasPrefix(ref, "refs/heads/") {
+			upstreamName = upstreamRef(config, strings.TrimPrefix(ref, "refs/heads/"))
+		}
+		if upstreamName == "" {
+			fmt.Fprintln(p.Werr, "Could not find a tracked remote branch, please specify <upstream> manually.")
+			b.WriteUsage(p.Werr)
+			return 129, nil
+		}
+	}
+	if headName == "" {
+		headName = api.HeadRef
+	}
+	head, err := quietCommit(repo, headName)
+	if err != nil {
+		return 0, fmt.Errorf("unknown commit %s", headName)
+	}
+	upstream, err := quietCommit(repo, upstreamName)
+	if err != nil {
+		return 0, fmt.Errorf("unknown commit %s", upstreamName)
+	}
+
+	// nothing is said of a branch that is its upstream
+	if head.ObjectId().String() == upstream.ObjectId().String() {
+		return 0, nil
+	}
+
+	// the patch ids of the commits that the upstream has and the
+	// branch doesn't
+	inHead := make(map[string]bool)
+	err = api.WalkCommits(repo, []*objects.Commit{head}, func(c *objects.Commit) error {
+		inHead[c.ObjectId().String()] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	opts := &diff.PatchIdOptions{Stable: true}
+	upstreamIds := make(map[string]bool)
+	excluded := make(map[string]bool)
+	err = api.WalkCommits(repo, []*objects.Commit{upstream}, func(c *objects.Commit) error {
+		excluded[c.ObjectId().String()] = true
+		if inHead[c.ObjectId().String()] || len(c.Parents()) > 1 {
+			return nil
+		}
+		id, err := diff.CommitPatchId(repo, c, opts)
+		if err == nil {
+			upstreamIds[id.String()] = true
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if limitName != "" {
+		limit, err := quietCommit(repo, limitName)
+		if err != nil {
+			return 0, fmt.Errorf("unknown commit %s", limitName)
+		}
+		err = api.WalkCommits(repo, []*objects.Commit{limit}, func(c *objects.Commit) error {
+			excluded[c.ObjectId().String()] = true
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	var commits []*objects.Commit
+	err = api.WalkCommits(repo, []*objects.Commit{head}, func(c *objects.Commit) error {
+		if !excluded[c.ObjectId().String()] && len(c.Parents()) <= 1 {
+			commits = append(commits, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := commits[i]
+		id, err := diff.CommitPatchId(repo, c, opts)
+		if err != nil {
+			return 0, err
+		}
+		sign := "+"
+		if upstreamIds[id.String()] {
+			sign = "-"
+		}
+		if b.flagVerbose {
+			fmt.Fprintf(p.Wout, "%s %s %s\n", sign, c.ObjectId(), commitSubject(c))
+		} else {
+			fmt.Fprintf(p.Wout, "%s %s\n", sign, c.ObjectId())
+		}
+	}
+	return 0, nil
+}
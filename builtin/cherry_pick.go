@@ -0,0 +1,43 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"github.com/jbrukh/ggit/api/sequencer"
+)
+
+// ================================================================= //
+// CHERRY-PICK
+// ================================================================= //
+
+// CherryPickBuiltin implements git-cherry-pick, which applies the
+// changes of existing commits onto the current branch, committing
+// each of them anew.
+type CherryPickBuiltin struct {
+	replayBuiltin
+}
+
+var CherryPick = &CherryPickBuiltin{
+	replayBuiltin{
+		HelpInfo: HelpInfo{
+			Name:        "cherry-pick",
+			Description: "Apply the changes introduced by some existing commits",
+			UsageLine:   "[-n] [-m <parent-number>] [-x] <commit>... | (--continue | --skip | --abort | --quit)",
+			ManPage:     "TODO",
+		},
+		cmd: sequencer.Pick,
+	},
+}
+
+func init() {
+	CherryPick.initReplay()
+	CherryPick.BoolVar(&CherryPick.flagRecordOrigin, "x", false, "Add a line naming the commit that was picked to the message.")
+
+	// add to command list
+	Add(CherryPick)
+}
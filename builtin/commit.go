@@ -0,0 +1,738 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ================================================================= //
+// COMMIT
+// ================================================================= //
+
+// CommitBuiltin implements git-commit, which records the staged
+// changes in a new commit on the current branch.
+type CommitBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagMessages          stringsFlag
+	flagFile              string
+	flagAll               bool
+	flagAmend             bool
+	flagAllowEmpty        bool
+	flagAllowEmptyMessage bool
+	flagNoEdit            bool
+	flagAuthor            string
+	flagDate              string
+	flagQuiet             bool
+}
+
+var Commit = &CommitBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "commit",
+		Description: "Record changes to the repository",
+		UsageLine:   "[-a] [--amend] [--allow-empty] [--allow-empty-message] [(-m <msg>)... | -F <file>] [--author=<author>] [--date=<date>] [-q] [--] [<pathspec>...]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Commit.Var(&Commit.flagMessages, "m", "A paragraph of the commit message.")
+	Commit.StringVar(&Commit.flagFile, "F", "", "Read the commit message from a file, or from the standard input if it is -.")
+	Commit.StringVar(&Commit.flagFile, "file", "", "Read the commit message from a file, or from the standard input if it is -.")
+	Commit.BoolVar(&Commit.flagAll, "a", false, "Stage the files that have been modified and deleted first.")
+	Commit.BoolVar(&Commit.flagAll, "all", false, "Stage the files that have been modified and deleted first.")
+	Commit.BoolVar(&Commit.flagAmend, "amend", false, "Replace the tip of the current branch with a new commit.")
+	Commit.BoolVar(&Commit.flagAllowEmpty, "allow-empty", false, "Allow a commit with the same tree as its parent.")
+	Commit.BoolVar(&Commit.flagAllowEmptyMessage, "allow-empty-message", false, "Allow a commit with an empty message.")
+	Commit.BoolVar(&Commit.flagNoEdit, "no-edit", false, "Use the message of the merge in progress as it is, comments and all.")
+	Commit.StringVar(&Commit.flagAuthor, "author", "", "Override the author of the commit.")
+	Commit.StringVar(&Commit.flagDate, "date", "", "Override the date of the author of the commit.")
+	Commit.BoolVar(&Commit.flagQuiet, "q", false, "Suppress the summary of the commit.")
+	Commit.BoolVar(&Commit.flagQuiet, "quiet", false, "Suppress the summary of the commit.")
+
+	Commit.Usage = func() {}
+
+	// add to command list
+	Add(Commit)
+}
+
+// the explanations that git-commit gives
+const (
+	unmergedFiles = `error: Committing is not possible because you have unmerged files.
+hint: Fix them up in the work tree, and then use 'git add/rm <file>'
+hint: as appropriate to mark resolution and make a commit.
+`
+	emptyAmend = `You asked to amend the most recent commit, but doing so would make
+it empty. You can repeat your command with --allow-empty, or you can
+remove the commit entirely with "git reset HEAD^".
+`
+	emptyCherryPick = `The previous cherry-pick is now empty, possibly due to conflict resolution.
+If you wish to commit it anyway, use:
+
+    git commit --allow-empty
+
+Otherwise, please use 'git cherry-pick --skip'
+`
+)
+
+func (b *CommitBuiltin) Execute(p *Params, args []string) {
+	b.flagMessages, b.flagFile = nil, ""
+	b.flagAll, b.flagAmend, b.flagAllowEmpty, b.flagAllowEmptyMessage, b.flagNoEdit = false, false, false, false, false
+	b.flagAuthor, b.flagDate, b.flagQuiet = "", "", false
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.commit(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *CommitBuiltin) commit(p *Params, args []string) (int, error) {
+	switch {
+	case len(b.flagMessages) > 0 && b.flagFile != "":
+		return 0, errors.New("options '-m' and '-F' cannot be used together")
+	case len(args) > 0 && b.flagAll:
+		return 0, fmt.Errorf("paths '%s ...' with -a does not make sense", args[0])
+	}
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	headRef, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	head, err := headCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	if b.flagAmend && head == nil {
+		return 0, errors.New("You have nothing to amend.")
+	}
+	merged, err := mergeHeads(repo)
+	if err != nil {
+		return 0, err
+	}
+	var picked *objects.Commit
+	if merged == nil {
+		if picked, err = cherryPickHead(repo); err != nil {
+			return 0, err
+		}
+	}
+	whence := ""
+	switch {
+	case merged != nil:
+		whence = "merge"
+	case picked != nil:
+		whence = "cherry-pick"
+	}
+	if whence != "" {
+		switch {
+		case b.flagAmend:
+			return 0, fmt.Errorf("You are in the middle of a %s -- cannot amend.", whence)
+		case len(args) > 0:
+			return 0, fmt.Errorf("cannot do a partial commit during a %s.", whence)
+		}
+	}
+
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+	wt := api.NewWorkTree(repo)
+	noShow := func(verb, name string) {}
+
+	// the index that is committed, which is the index itself
+	// unless only some paths are committed
+	committed := idx
+	switch {
+	case b.flagAll:
+		all, _ := pathspec.Parse("", nil, 0)
+		if err = stageTracked(wt, idx, all, false, noShow); err != nil {
+			return 0, err
+		}
+	case len(args) > 0:
+		prefix := workPrefix(repo)
+		for _, arg := range args {
+			one, err := pathspec.Parse(prefix, []string{arg}, 0)
+			if err != nil {
+				return 0, err
+			}
+			if !matchesIndex(one, idx) {
+				fmt.Fprintf(p.Werr, "error: pathspec '%s' did not match any file(s) known to git\n", arg)
+				return 1, nil
+			}
+		}
+		ps, err := addPathspec(repo, prefix, args)
+		if err != nil {
+			return 0, err
+		}
+		if err = stageTracked(wt, idx, ps, false, noShow); err != nil {
+			return 0, err
+		}
+		if committed, err = onlyPaths(repo, head, idx, ps); err != nil {
+			return 0, err
+		}
+	}
+	var unmerged []string
+	for _, e := range committed.Entries() {
+		if n := len(unmerged); e.Stage() != 0 && (n == 0 || unmerged[n-1] != e.Name()) {
+			unmerged = append(unmerged, e.Name())
+		}
+	}
+	if len(unmerged) > 0 {
+		fmt.Fprint(p.Werr, unmergedFiles)
+		prefix := workPrefix(repo)
+		for _, name := range unmerged {
+			fmt.Fprintf(p.Wout, "U\t%s\n", relativePath(prefix, name))
+		}
+		return 0, errors.New("Exiting because of an unresolved conflict.")
+	}
+
+	tree, err := committed.WriteTree(repo, false)
+	if err != nil {
+		return 0, err
+	}
+	var parents []*objects.ObjectId
+	switch {
+	case b.flagAmend:
+		parents = head.Parents()
+	case head != nil:
+		parents = append([]*objects.ObjectId{headOid}, merged...)
+	}
+	var parent *objects.Commit
+	if len(parents) > 0 {
+		if parent, err = api.CommitFromOid(repo, parents[0]); err != nil {
+			return 0, err
+		}
+	}
+
+	// there must be something to commit, which files that are
+	// only intended to be added are not
+	empty := true
+	for _, e := range committed.Entries() {
+		empty = empty && e.ExtendedFlags().IntentToAdd()
+	}
+	if parent != nil {
+		empty = len(parents) == 1 && parent.Tree().String() == tree.String()
+	}
+	if empty && !b.flagAllowEmpty {
+		switch {
+		case b.flagAmend:
+			fmt.Fprint(p.Werr, emptyAmend)
+		case picked != nil:
+			fmt.Fprint(p.Werr, emptyCherryPick)
+		}
+		if err = b.printStatus(p, repo, wt, idx, headRef, headOid); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	msg, err := b.message(p.Rin, repo, head)
+	if err != nil {
+		return 0, err
+	}
+	if msg == "" && !b.flagAllowEmptyMessage {
+		fmt.Fprintln(p.Werr, "Aborting commit due to empty commit message.")
+		return 1, nil
+	}
+
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	author, err := b.author(p, repo, config, head, picked)
+	if err != nil {
+		return 0, err
+	}
+	committer, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	c, err := api.WriteCommit(repo, tree, parents, author, committer, msg)
+	if err != nil {
+		return 0, err
+	}
+
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return 0, err
+	}
+	action := "commit"
+	switch {
+	case b.flagAmend:
+		action = "commit (amend)"
+	case head == nil:
+		action = "commit (initial)"
+	case merged != nil:
+		action = "commit (merge)"
+	case picked != nil:
+		action = "commit (cherry-pick)"
+	}
+	subject := strings.SplitN(msg, "\n", 2)[0]
+	if err = api.UpdateRef(repo, api.HeadRef, c.ObjectId(), headOid, committer, action+": "+subject); err != nil {
+		return 0, err
+	}
+	if err = removeBranchState(repo); err != nil {
+		return 0, err
+	}
+
+	if b.flagQuiet {
+		return 0, nil
+	}
+	showDate := b.flagAmend || b.flagDate != "" || picked != nil
+	return 0, printCommitSummary(p, repo, config, headRef, head == nil, showDate, c, parent)
+}
+
+// onlyPaths returns the index that commits the given paths as they
+// are staged in the index, and everything else as it is in HEAD.
+func onlyPaths(repo *api.DiskRepository, head *objects.Commit, idx *api.Index, ps *pathspec.Pathspec) (*api.Index, error) {
+	only := new(api.Index)
+	if head != nil {
+		t, err := api.TreeFromOid(repo, head.Tree())
+		if err != nil {
+			return nil, err
+		}
+		if only, err = api.IndexFromTree(repo, t); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range only.Entries() {
+		if ps.MatchIndexEntry(e) && idx.Entry(e.Name(), 0) == nil {
+			only.Remove(e.Name())
+		}
+	}
+	for _, e := range idx.Entries() {
+		if ps.MatchIndexEntry(e) {
+			only.Add(e)
+		}
+	}
+	return only, nil
+}
+
+// message returns the commit message, cleaned up, whose paragraphs
+// are given by -m, or which is read by -F. An amended commit keeps
+// its message unless a new one is given, and the commit of a merge
+// or a squash that is in progress takes the message that was
+// prepared for it, whose comments are dropped as if it had been
+// edited, unless --no-edit says otherwise.
+func (b *CommitBuiltin) message(in io.Reader, repo *api.DiskRepository, head *objects.Commit) (string, error) {
+	switch {
+	case len(b.flagMessages) > 0:
+		return cleanupMessage(strings.Join(b.flagMessages, "\n\n")), nil
+	case b.flagFile == "-":
+		data, err := ioutil.ReadAll(in)
+		if err != nil {
+			return "", errors.New("could not read log from standard input")
+		}
+		return cleanupMessage(string(data)), nil
+	case b.flagFile != "":
+		data, err := ioutil.ReadFile(b.flagFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read log file '%s': %s", b.flagFile, describeError(err))
+		}
+		return cleanupMessage(string(data)), nil
+	case b.flagAmend:
+		return cleanupMessage(head.Message()), nil
+	}
+	for _, name := range []string{mergeMsgFile, squashMsgFile} {
+		data, err := ioutil.ReadFile(path.Join(repo.Path(), name))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return "", err
+		case b.flagNoEdit:
+			return cleanupMessage(string(data)), nil
+		}
+		return stripComments(string(data)), nil
+	}
+	// there is no editor to ask for the message with
+	return "", errors.New("please supply the message using either -m or -F option")
+}
+
+// cleanupMessage cleans up a commit message as git does when it
+// isn't edited: trailing whitespace is stripped from its lines,
+// runs of blank lines are collapsed into one, and the blank lines
+// that it starts and ends with are removed.
+func cleanupMessage(msg string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRight(line, " \t\r\f\v")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// matches explicit identities, as in "A U Thor <author@example.com>"
+var identPattern = regexp.MustCompile(`^(.*?)\s*<([^<>]*)>$`)
+
+// author returns the author of the commit, which is the one of an
+// amended commit, or of the commit that is being cherry-picked,
+// unless --author says otherwise. The date is the one of --date, if
+// it is given.
+func (b *CommitBuiltin) author(p *Params, repo *api.DiskRepository, config *api.Config, head, picked *objects.Commit) (*objects.WhoWhen, error) {
+	kept := picked
+	if b.flagAmend {
+		kept = head
+	}
+	var author *objects.WhoWhen
+	switch {
+	case b.flagAuthor != "":
+		name, email, err := findAuthor(repo, head, b.flagAuthor)
+		if err != nil {
+			return nil, err
+		}
+		var seconds int64
+		var offset int
+		if kept != nil {
+			seconds, offset = kept.Author().Seconds(), kept.Author().Offset()
+		} else if seconds, offset, err = api.IdentDate(api.RoleAuthor); err != nil {
+			return nil, err
+		}
+		author = objects.NewWhoWhen(name, email, seconds, offset)
+	case kept != nil:
+		author = kept.Author()
+	default:
+		var err error
+		if author, err = identity(p.Werr, config, api.RoleAuthor); err != nil {
+			return nil, err
+		}
+	}
+	if b.flagDate != "" {
+		seconds, offset, err := api.ParseDate(b.flagDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date format: %s", b.flagDate)
+		}
+		author = objects.NewWhoWhen(author.Name(), author.Email(), seconds, offset)
+	}
+	return author, nil
+}
+
+// findAuthor returns the name and the email of an author that is
+// given as "Name <email>", or else of the most recent author in the
+// history of HEAD that matches the given string.
+func findAuthor(repo *api.DiskRepository, head *objects.Commit, who string) (string, string, error) {
+	if m := identPattern.FindStringSubmatch(who); m != nil {
+		return m[1], m[2], nil
+	}
+	needle := strings.ToLower(who)
+	seen := make(map[string]bool)
+	queue := []*objects.Commit{}
+	if head != nil {
+		queue = append(queue, head)
+	}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		a := c.Author()
+		if strings.Contains(strings.ToLower(fmt.Sprintf("%s <%s>", a.Name(), a.Email())), needle) {
+			return a.Name(), a.Email(), nil
+		}
+		for _, oid := range c.Parents() {
+			if seen[oid.String()] {
+				continue
+			}
+			seen[oid.String()] = true
+			parent, err := api.CommitFromOid(repo, oid)
+			if err != nil {
+				return "", "", err
+			}
+			queue = append(queue, parent)
+		}
+	}
+	return "", "", fmt.Errorf("--author '%s' is not 'Name <email>' and matches no existing author", who)
+}
+
+// printCommitSummary prints the summary of a new commit: its branch,
+// its abbreviated oid and its subject, the author if it isn't the
+// committer, the date of the author if it is of interest, and the
+// files that it changed from its first parent. The initial commit of
+// a branch is marked as a root commit.
+func printCommitSummary(p *Params, repo *api.DiskRepository, config *api.Config, headRef string, initial, showDate bool, c, parent *objects.Commit) error {
+	branch := strings.TrimPrefix(headRef, "refs/heads/")
+	if headRef == api.HeadRef {
+		branch = "detached HEAD"
+	}
+	if initial {
+		branch += " (root-commit)"
+	}
+	fmt.Fprintf(p.Wout, "[%s %s] %s\n", branch, abbrev(c.ObjectId()), commitSubject(c))
+
+	author, committer := c.Author(), c.Committer()
+	if author.Name() != committer.Name() || author.Email() != committer.Email() {
+		fmt.Fprintf(p.Wout, " Author: %s <%s>\n", author.Name(), author.Email())
+	}
+	if showDate {
+		fmt.Fprintf(p.Wout, " Date: %s\n", author.Time().Format("Mon Jan 2 15:04:05 2006 -0700"))
+	}
+
+	// merges are summarized by their subject alone
+	if len(c.Parents()) > 1 {
+		return nil
+	}
+
+	var before *objects.Tree
+	if parent != nil {
+		var err error
+		if before, err = api.TreeFromOid(repo, parent.Tree()); err != nil {
+			return err
+		}
+	}
+	after, err := api.TreeFromOid(repo, c.Tree())
+	if err != nil {
+		return err
+	}
+	opts := new(diff.Options)
+	if renames, err := config.Bool("diff.renames", true); err != nil {
+		return err
+	} else if renames {
+		opts.Renames = diff.NewRenameOptions()
+	}
+	td, err := diff.DiffTrees(repo, before, after, opts)
+	if err != nil {
+		return err
+	}
+	attrs, err := api.NewAttributes(repo)
+	if err != nil {
+		return err
+	}
+	source := diff.RepoSource(repo)
+	ds, err := diff.NewDiffStat(td, source, source, diff.Myers, diff.NewBinaryDetector(attrs, config))
+	if err != nil {
+		return err
+	}
+	if err = ds.WriteShortstat(p.Wout); err != nil {
+		return err
+	}
+	return diff.WriteSummary(p.Wout, td)
+}
+
+// ================================================================= //
+// STATUS
+// ================================================================= //
+
+// printStatus explains that there is nothing to commit, as git
+// status would: it shows the branch, the changes that are not
+// staged and the files that are not tracked.
+func (b *CommitBuiltin) printStatus(p *Params, repo *api.DiskRepository, wt *api.WorkTree, idx *api.Index, headRef string, headOid *objects.ObjectId) error {
+	if headRef == api.HeadRef {
+		fmt.Fprintln(p.Wout, detachedStatus(repo, headOid))
+	} else {
+		fmt.Fprintf(p.Wout, "On branch %s\n", strings.TrimPrefix(headRef, "refs/heads/"))
+	}
+	if err := printSequenceStatus(p, repo, idx); err != nil {
+		return err
+	}
+	if headOid == nil {
+		fmt.Fprint(p.Wout, "\nInitial commit\n\n")
+	}
+	prefix := workPrefix(repo)
+
+	td, err := diff.DiffIndexWorkTree(repo, wt, idx, new(diff.Options))
+	if err != nil {
+		return err
+	}
+	if edits := td.Edits(); len(edits) > 0 {
+		verb := "git add"
+		for _, edit := range edits {
+			if edit.Action() == diff.Delete {
+				verb = "git add/rm"
+			}
+		}
+		fmt.Fprintln(p.Wout, "Changes not staged for commit:")
+		fmt.Fprintf(p.Wout, "  (use \"%s <file>...\" to update what will be committed)\n", verb)
+		fmt.Fprintln(p.Wout, "  (use \"git restore <file>...\" to discard changes in working directory)")
+		for _, edit := range edits {
+			label := "modified:"
+			switch edit.Action() {
+			case diff.Insert:
+				label = "new file:"
+			case diff.Delete:
+				label = "deleted:"
+			case diff.TypeChange:
+				label = "typechange:"
+			}
+			fmt.Fprintf(p.Wout, "\t%-12s%s\n", label, relativePath(prefix, edit.Path()))
+		}
+		fmt.Fprintln(p.Wout)
+	}
+
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return err
+	}
+	others, err := wt.Others(idx, ig, false)
+	if err != nil {
+		return err
+	}
+	untracked := untrackedDirs(idx, others)
+	if len(untracked) > 0 {
+		fmt.Fprintln(p.Wout, "Untracked files:")
+		fmt.Fprintln(p.Wout, "  (use \"git add <file>...\" to include in what will be committed)")
+		for _, name := range untracked {
+			shown := relativePath(prefix, strings.TrimSuffix(name, "/"))
+			if strings.HasSuffix(name, "/") {
+				shown += "/"
+			}
+			fmt.Fprintf(p.Wout, "\t%s\n", shown)
+		}
+		fmt.Fprintln(p.Wout)
+	}
+
+	switch {
+	case b.flagAmend:
+		fmt.Fprintln(p.Wout, "No changes")
+	case len(td.Edits()) > 0:
+		fmt.Fprintln(p.Wout, "no changes added to commit (use \"git add\" and/or \"git commit -a\")")
+	case len(untracked) > 0:
+		fmt.Fprintln(p.Wout, "nothing added to commit but untracked files present (use \"git add\" to track)")
+	case headOid == nil:
+		fmt.Fprintln(p.Wout, "nothing to commit (create/copy files and use \"git add\" to track)")
+	default:
+		fmt.Fprintln(p.Wout, "nothing to commit, working tree clean")
+	}
+	return nil
+}
+
+// untrackedDirs collapses the untracked files that are inside of
+// directories with nothing tracked into those directories, which
+// are given a trailing slash.
+func untrackedDirs(idx *api.Index, others []string) []string {
+	tracked := make(map[string]bool)
+	for _, e := range idx.Entries() {
+		for dir := path.Dir(e.Name()); dir != "."; dir = path.Dir(dir) {
+			tracked[dir] = true
+		}
+	}
+	var result []string
+	for _, name := range others {
+		parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+		for i := 1; i < len(parts); i++ {
+			if dir := strings.Join(parts[:i], "/"); !tracked[dir] {
+				name = dir + "/"
+				break
+			}
+		}
+		if n := len(result); n == 0 || result[n-1] != name {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// detachedStatus describes a detached HEAD by the revision that
+// was last checked out, according to the log of HEAD.
+func detachedStatus(repo *api.DiskRepository, headOid *objects.ObjectId) string {
+	entries, err := repo.Reflog(api.HeadRef)
+	if err != nil {
+		entries = nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message()
+		if !strings.HasPrefix(msg, "checkout: moving from ") {
+			continue
+		}
+		j := strings.LastIndex(msg, " to ")
+		if j < 0 {
+			break
+		}
+		// the name is kept only if it is a branch or a tag that
+		// still points at what was checked out
+		rev, oid := msg[j+len(" to "):], entries[i].New()
+		o, err := api.ObjectFromRef(repo, rev)
+		if err == nil {
+			o, err = api.PeelObject(repo, o)
+		}
+		if err != nil || o.ObjectId().String() != oid.String() {
+			rev = abbrev(oid)
+		}
+		if headOid != nil && oid.String() == headOid.String() {
+			return "HEAD detached at " + rev
+		}
+		return "HEAD detached from " + rev
+	}
+	return "Not currently on any branch."
+}
+
+// ================================================================= //
+// HEAD UTILITIES
+// ================================================================= //
+
+// headCommit returns the commit of HEAD, or nil if the current
+// branch is yet to be born.
+func headCommit(repo *api.DiskRepository) (*objects.Commit, error) {
+	_, oid, err := api.Head(repo)
+	if err != nil || oid == nil {
+		return nil, err
+	}
+	return api.CommitFromOid(repo, oid)
+}
+
+// headTree returns the tree of HEAD, or nil if the current branch
+// is yet to be born.
+func headTree(repo *api.DiskRepository) (*objects.Tree, error) {
+	c, err := headCommit(repo)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return api.TreeFromOid(repo, c.Tree())
+}
+
+// commitSubject returns the subject of a commit, which is the first
+// paragraph of its message, joined into one line.
+func commitSubject(c *objects.Commit) string {
+	paragraph := strings.SplitN(c.Message(), "\n\n", 2)[0]
+	return strings.Join(strings.Split(strings.TrimSuffix(paragraph, "\n"), "\n"), " ")
+}
+
+// abbrev returns the abbreviated form of an oid that git shows
+// by default.
+func abbrev(oid *objects.ObjectId) string {
+	return oid.String()[:7]
+}
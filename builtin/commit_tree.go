@@ -0,0 +1,203 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ================================================================= //
+// COMMIT-TREE
+// ================================================================= //
+
+// CommitTreeBuiltin implements git-commit-tree, which creates a
+// commit of a tree.
+type CommitTreeBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagParents  stringsFlag
+	flagMessages stringsFlag
+}
+
+var CommitTree = &CommitTreeBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "commit-tree",
+		Description: "Create a new commit object",
+		UsageLine:   "<tree> [(-p <parent>)...] [(-m <message>)...] [(-F <file>)...]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	CommitTree.Var(&CommitTree.flagParents, "p", "A parent of the commit.")
+	CommitTree.Var(orderedFlag{"m", &CommitTree.flagMessages}, "m", "A paragraph of the commit message.")
+	CommitTree.Var(orderedFlag{"F", &CommitTree.flagMessages}, "F", "Read the commit message from a file, or from the standard input if it is -.")
+
+	CommitTree.Usage = func() {}
+
+	// add to command list
+	Add(CommitTree)
+}
+
+func (b *CommitTreeBuiltin) Execute(p *Params, args []string) {
+	b.flagParents, b.flagMessages = nil, nil
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if err = b.commitTree(p, args); err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+	}
+}
+
+// the explanation of a missing identity, given its role
+const unknownIdentity = `%s identity unknown
+
+*** Please tell me who you are.
+
+Run
+
+  git config --global user.email "you@example.com"
+  git config --global user.name "Your Name"
+
+to set your account's default identity.
+Omit --global to set the identity only in this repository.
+
+`
+
+func (b *CommitTreeBuiltin) commitTree(p *Params, args []string) error {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("must give exactly one tree")
+	}
+	tree, err := objectOfType(repo, args[0], objects.ObjectTree, false)
+	if err != nil {
+		return err
+	}
+
+	var parents []*objects.ObjectId
+	seen := make(map[string]bool)
+	for _, arg := range b.flagParents {
+		parent, err := objectOfType(repo, arg, objects.ObjectCommit, true)
+		if err != nil {
+			return err
+		}
+		if seen[parent.String()] {
+			fmt.Fprintf(p.Werr, "error: duplicate parent %s ignored\n", parent)
+			continue
+		}
+		seen[parent.String()] = true
+		parents = append(parents, parent)
+	}
+
+	msg, err := b.message(p.Rin)
+	if err != nil {
+		return err
+	}
+
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+	author, err := identity(p.Werr, config, api.RoleAuthor)
+	if err != nil {
+		return err
+	}
+	committer, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return err
+	}
+	c, err := api.WriteCommit(repo, tree, parents, author, committer, msg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(p.Wout, c.ObjectId())
+	return nil
+}
+
+// identity returns the identity of a role, explaining how to set
+// it if it isn't set anywhere.
+func identity(w io.Writer, config *api.Config, role string) (*objects.WhoWhen, error) {
+	ww, err := api.Ident(config, role)
+	if err == api.ErrNoEmail {
+		fmt.Fprintf(w, unknownIdentity, strings.Title(role))
+	}
+	return ww, err
+}
+
+// message returns the commit message, whose paragraphs are given by
+// -m and read by -F, in order, or which is read from the standard
+// input if there are none.
+func (b *CommitTreeBuiltin) message(in io.Reader) (string, error) {
+	buf := new(bytes.Buffer)
+	for _, value := range b.flagMessages {
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		tag, value := value[0], value[1:]
+		if tag == 'm' {
+			buf.WriteString(value)
+			if !strings.HasSuffix(value, "\n") && value != "" {
+				buf.WriteByte('\n')
+			}
+			continue
+		}
+		var (
+			data []byte
+			err  error
+		)
+		if value == "-" {
+			data, err = ioutil.ReadAll(in)
+		} else {
+			data, err = ioutil.ReadFile(value)
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not open '%s' for reading: %s", value, describeError(err))
+		}
+		buf.Write(data)
+	}
+	if buf.Len() == 0 {
+		data, err := ioutil.ReadAll(in)
+		if err != nil {
+			return "", fmt.Errorf("git commit-tree: failed to read")
+		}
+		return string(data), nil
+	}
+	return buf.String(), nil
+}
+
+// objectOfType resolves a revision to the oid of an object of the
+// given type, peeling tags if peel is true.
+func objectOfType(repo api.Repository, rev string, t objects.ObjectType, peel bool) (*objects.ObjectId, error) {
+	o, err := api.LookupRevision(repo, rev)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid object name %s", rev)
+	}
+	if peel {
+		if peeled, err := api.PeelObject(repo, o); err == nil {
+			o = peeled
+		}
+	}
+	if o.Header().Type() != t {
+		return nil, fmt.Errorf("%s is not a valid '%s' object", o.ObjectId(), t)
+	}
+	return o.ObjectId(), nil
+}
@@ -8,71 +8,696 @@
 package builtin
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/jbrukh/ggit/api"
 	"github.com/jbrukh/ggit/api/diff"
 	"github.com/jbrukh/ggit/api/objects"
-	"github.com/mikebosw/gdiff"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"github.com/jbrukh/ggit/util"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
-func init() {
-	// add to command list
-	Add(Diff)
-}
+// ================================================================= //
+// DIFF
+// ================================================================= //
 
+// DiffBuiltin implements git-diff, which shows the changes
+// between commits, the index and the working tree as a
+// patch, or as a summary of the changed files.
 type DiffBuiltin struct {
 	HelpInfo
+	flag.FlagSet
+	flagCached         bool
+	flagContext        int
+	flagFullIndex      bool
+	flagAlgorithm      string
+	flagMinimal        bool
+	flagPatience       bool
+	flagHistogram      bool
+	flagPatch          bool
+	flagPatchWithStat  bool
+	flagStat           optionalFlag
+	flagStatWidth      int
+	flagStatNameWidth  int
+	flagStatGraphWidth int
+	flagStatCount      int
+	flagNumstat        bool
+	flagShortstat      bool
+	flagDirstat        optionalFlag
+	flagDirstatByFile  optionalFlag
+	flagCumulative     bool
+	flagNameOnly       bool
+	flagNameStatus     bool
+	flagRenames        stringsFlag
+	flagCopiesHarder   bool
+	flagRenameLimit    int
+	flagBinary         bool
+	flagText           bool
+	flagColor          stringsFlag
+	flagWordDiff       optionalFlag
+	flagWordDiffRegex  string
+	flagColorWords     optionalFlag
+	flagColorMoved     stringsFlag
+	flagColorMovedWS   string
 }
 
 var Diff = &DiffBuiltin{
 	HelpInfo: HelpInfo{
 		Name:        "diff",
-		Description: "Describe the difference between two files or trees", //TODO: between commits
-		UsageLine:   "",
+		Description: "Show changes between commits, commit and working tree, etc",
+		UsageLine:   "[-p] [-U<n>] [--full-index] [--binary] [-a] [--diff-algorithm=<algorithm>] [--stat[=<width>[,<name-width>[,<count>]]]] [--numstat] [--shortstat] [--dirstat[=<param>,...]] [--name-only|--name-status] [-M[<n>]] [-C[<n>]] [--find-copies-harder] [--no-renames] [-l<n>] [--color[=<when>]|--no-color] [--word-diff[=<mode>]] [--word-diff-regex=<regex>] [--color-words[=<regex>]] [--color-moved[=<mode>]|--no-color-moved] [--color-moved-ws=<modes>] [--cached] [<commit> [<commit>]] [--] [<path>...]",
 		ManPage:     "TODO",
 	},
 }
 
-func (db *DiffBuiltin) Execute(p *Params, args []string) {
-	if len(args) < 2 {
-		fmt.Printf("expected two arguments; got %d\n", len(args))
-		return
+func init() {
+	Diff.BoolVar(&Diff.flagCached, "cached", false, "Compare the index against a commit, HEAD by default.")
+	Diff.BoolVar(&Diff.flagCached, "staged", false, "Synonym of --cached.")
+	Diff.IntVar(&Diff.flagContext, "U", diff.DefaultContext, "Generate diffs with <n> lines of context.")
+	Diff.IntVar(&Diff.flagContext, "unified", diff.DefaultContext, "Generate diffs with <n> lines of context.")
+	Diff.BoolVar(&Diff.flagFullIndex, "full-index", false, "Show full object names in index lines.")
+	Diff.BoolVar(&Diff.flagBinary, "binary", false, "Output binary patches that git-apply can apply.")
+	Diff.BoolVar(&Diff.flagText, "a", false, "Treat all files as text.")
+	Diff.BoolVar(&Diff.flagText, "text", false, "Synonym of -a.")
+	Diff.StringVar(&Diff.flagAlgorithm, "diff-algorithm", "", "Choose a diff algorithm: myers, minimal, patience or histogram.")
+	Diff.BoolVar(&Diff.flagMinimal, "minimal", false, "Spend extra time to make sure the smallest possible diff is produced.")
+	Diff.BoolVar(&Diff.flagPatience, "patience", false, "Generate a diff using the patience diff algorithm.")
+	Diff.BoolVar(&Diff.flagHistogram, "histogram", false, "Generate a diff using the histogram diff algorithm.")
+	Diff.BoolVar(&Diff.flagPatch, "p", false, "Generate a patch, which is the default.")
+	Diff.BoolVar(&Diff.flagPatch, "u", false, "Synonym of -p.")
+	Diff.BoolVar(&Diff.flagPatch, "patch", false, "Synonym of -p.")
+	Diff.BoolVar(&Diff.flagPatchWithStat, "patch-with-stat", false, "Synonym of -p --stat.")
+	Diff.Var(&Diff.flagStat, "stat", "Generate a diffstat, of the given <width>[,<name-width>[,<count>]].")
+	Diff.IntVar(&Diff.flagStatWidth, "stat-width", 0, "Limit the width of the diffstat.")
+	Diff.IntVar(&Diff.flagStatNameWidth, "stat-name-width", 0, "Limit the width of the names in the diffstat.")
+	Diff.IntVar(&Diff.flagStatGraphWidth, "stat-graph-width", 0, "Limit the width of the graph in the diffstat.")
+	Diff.IntVar(&Diff.flagStatCount, "stat-count", 0, "Limit the number of files in the diffstat.")
+	Diff.BoolVar(&Diff.flagNumstat, "numstat", false, "Show the numbers of added and deleted lines of each file.")
+	Diff.BoolVar(&Diff.flagShortstat, "shortstat", false, "Show only the last line of the diffstat.")
+	Diff.Var(&Diff.flagDirstat, "dirstat", "Show the share of the changes of each directory.")
+	Diff.Var(&Diff.flagDirstatByFile, "dirstat-by-file", "Synonym of --dirstat=files.")
+	Diff.BoolVar(&Diff.flagCumulative, "cumulative", false, "Synonym of --dirstat=cumulative.")
+	Diff.BoolVar(&Diff.flagNameOnly, "name-only", false, "Show only the names of changed files.")
+	Diff.BoolVar(&Diff.flagNameStatus, "name-status", false, "Show only the names and statuses of changed files.")
+	Diff.Var(taggedFlag{"M", &Diff.flagRenames}, "M", "Detect renames, of at least the given similarity.")
+	Diff.Var(taggedFlag{"M", &Diff.flagRenames}, "find-renames", "Synonym of -M.")
+	Diff.Var(taggedFlag{"C", &Diff.flagRenames}, "C", "Detect copies as well as renames. Given twice, same as --find-copies-harder.")
+	Diff.Var(taggedFlag{"C", &Diff.flagRenames}, "find-copies", "Synonym of -C.")
+	Diff.Var(taggedFlag{"N", &Diff.flagRenames}, "no-renames", "Turn off rename detection.")
+	Diff.BoolVar(&Diff.flagCopiesHarder, "find-copies-harder", false, "Look for copies of unmodified files too.")
+	Diff.IntVar(&Diff.flagRenameLimit, "l", -1, "Skip rename detection if there are more than <n> files on either side.")
+	Diff.Var(taggedFlag{"C", &Diff.flagColor}, "color", "Color the diff: always, which is the default, never or auto.")
+	Diff.Var(taggedFlag{"N", &Diff.flagColor}, "no-color", "Turn off colors.")
+	Diff.Var(&Diff.flagWordDiff, "word-diff", "Show changed words: plain, which is the default, color, porcelain or none.")
+	Diff.StringVar(&Diff.flagWordDiffRegex, "word-diff-regex", "", "Match words with <regex>. Implies --word-diff.")
+	Diff.Var(&Diff.flagColorWords, "color-words", "Synonym of --word-diff=color --word-diff-regex=<regex>.")
+	Diff.Var(taggedFlag{"C", &Diff.flagColorMoved}, "color-moved", "Color moved lines: default, plain, blocks, zebra, dimmed-zebra or no.")
+	Diff.Var(taggedFlag{"N", &Diff.flagColorMoved}, "no-color-moved", "Turn off the colors of moved lines.")
+	Diff.StringVar(&Diff.flagColorMovedWS, "color-moved-ws", "", "Ignore whitespace when comparing moved lines.")
+
+	Diff.Usage = func() {}
+
+	// add to command list
+	Add(Diff)
+}
+
+// matches the stuck forms of flags with values, as in -U5,
+// -M50% and -l1000
+var stuckValue = regexp.MustCompile(`^-([UMCl])([^=].*)$`)
+
+func (b *DiffBuiltin) Execute(p *Params, args []string) {
+	// paths after "--" are never revisions
+	var paths []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, paths = args[:i], args[i+1:]
+			break
+		}
 	}
-	var e error
-	var oa, ob objects.Object
-	if oa, e = api.ObjectFromRevision(p.Repo, args[0]); e != nil {
-		fmt.Printf(e.Error())
-		return
+	for i, arg := range args {
+		if m := stuckValue.FindStringSubmatch(arg); m != nil {
+			args[i] = "-" + m[1] + "=" + m[2]
+		}
 	}
-	if ob, e = api.ObjectFromRevision(p.Repo, args[1]); e != nil {
-		fmt.Printf(e.Error())
+	b.flagCached, b.flagContext, b.flagFullIndex = false, diff.DefaultContext, false
+	b.flagAlgorithm, b.flagMinimal, b.flagPatience, b.flagHistogram = "", false, false, false
+	b.flagPatch, b.flagPatchWithStat, b.flagNumstat, b.flagShortstat = false, false, false, false
+	b.flagStat, b.flagDirstat, b.flagDirstatByFile = optionalFlag{}, optionalFlag{}, optionalFlag{}
+	b.flagStatWidth, b.flagStatNameWidth, b.flagStatGraphWidth, b.flagStatCount = 0, 0, 0, 0
+	b.flagCumulative, b.flagNameOnly, b.flagNameStatus = false, false, false
+	b.flagRenames, b.flagCopiesHarder, b.flagRenameLimit = nil, false, -1
+	b.flagBinary, b.flagText = false, false
+	b.flagColor, b.flagColorMoved, b.flagColorMovedWS = nil, nil, ""
+	b.flagWordDiff, b.flagWordDiffRegex, b.flagColorWords = optionalFlag{}, "", optionalFlag{}
+	if err := b.Parse(args); err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
 		return
 	}
-	var ot objects.ObjectType
-	if oah, obh := oa.Header().Type(), ob.Header().Type(); oah != obh {
-		fmt.Printf("objects are not the same type: \n%s [%s]\n%s [%s]\n", args[0], oah.String(), args[1], obh.String())
+	args = b.Args()
+
+	// leading arguments that name revisions are revisions,
+	// and the rest are paths
+	var revs []string
+	for len(args) > 0 && isDiffRev(p.Repo, args[0]) {
+		revs, args = append(revs, args[0]), args[1:]
+	}
+	paths = append(args, paths...)
+
+	if err := b.diff(p, revs, paths); err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+	}
+}
+
+func (b *DiffBuiltin) diff(p *Params, revs, paths []string) error {
+	if b.flagNameOnly && b.flagNameStatus {
+		return errors.New("options '--name-only' and '--name-status' cannot be used together")
+	}
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return err
+	}
+	ps, err := pathspec.Parse(workPrefix(p.Repo), paths, 0)
+	if err != nil {
+		return err
+	}
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+	renames, err := b.renameOptions(config)
+	if err != nil {
+		return err
+	}
+	opts := &diff.Options{Pathspec: ps, Renames: renames}
+	repoSource := diff.RepoSource(repo)
+
+	// A..B is the same as A B
+	if len(revs) == 1 && strings.Contains(revs[0], "..") {
+		if strings.Contains(revs[0], "...") {
+			return errors.New("symmetric differences are not supported")
+		}
+		i := strings.Index(revs[0], "..")
+		revs = []string{orHead(revs[0][:i]), orHead(revs[0][i+2:])}
+	}
+
+	var td *diff.TreeDiff
+	after := repoSource
+	switch {
+	case len(revs) == 2:
+		if b.flagCached {
+			return errors.New("--cached takes at most one commit")
+		}
+		oa, err := api.ObjectFromRevision(repo, revs[0])
+		if err != nil {
+			return err
+		}
+		ob, err := api.ObjectFromRevision(repo, revs[1])
+		if err != nil {
+			return err
+		}
+		if blobA, ok := oa.(*objects.Blob); ok {
+			if td, err = diffBlobs(revs, blobA, ob); err != nil {
+				return err
+			}
+			break
+		}
+		ta, err := api.TreeFromObject(repo, oa)
+		if err != nil {
+			return err
+		}
+		tb, err := api.TreeFromObject(repo, ob)
+		if err != nil {
+			return err
+		}
+		if td, err = diff.DiffTrees(repo, ta, tb, opts); err != nil {
+			return err
+		}
+	case len(revs) < 2:
+		idx, err := repo.Index()
+		if os.IsNotExist(err) {
+			idx, err = new(api.Index), nil
+		}
+		if err != nil {
+			return err
+		}
+		var t *objects.Tree
+		if len(revs) == 1 {
+			if t, err = api.TreeFromRevision(repo, revs[0]); err != nil {
+				return err
+			}
+		} else if b.flagCached {
+			// an unborn branch is compared as an empty tree
+			t, _ = api.TreeFromRevision(repo, "HEAD")
+		}
+		wt := api.NewWorkTree(repo)
+		switch {
+		case b.flagCached:
+			td, err = diff.DiffTreeIndex(repo, t, idx, opts)
+		case len(revs) == 1:
+			td, err = diff.DiffTreeWorkTree(repo, t, wt, idx, opts)
+			after = diff.WorkTreeSource(wt)
+		default:
+			td, err = diff.DiffIndexWorkTree(repo, wt, idx, opts)
+			after = diff.WorkTreeSource(wt)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("too many revisions: %s", strings.Join(revs, " "))
+	}
+	attrs, err := api.NewAttributes(repo)
+	if err != nil {
+		return err
+	}
+	bd := diff.NewBinaryDetector(attrs, config)
+	if err = b.output(p, config, bd, td, repoSource, after); err != nil {
+		return err
+	}
+	warnRenameLimit(p, td)
+	return nil
+}
+
+// output prints the diff in the formats that were asked for:
+// either the names of the changed files, or the stats and the
+// patch, which is the default. The detector decides which
+// files are binary.
+func (b *DiffBuiltin) output(p *Params, config *api.Config, bd *diff.BinaryDetector, td *diff.TreeDiff, before, after diff.BlobSource) error {
+	if len(td.Edits()) == 0 {
+		return nil
+	}
+
+	// the names replace all other formats
+	switch {
+	case b.flagNameStatus:
+		return diff.WriteNameStatus(p.Wout, td)
+	case b.flagNameOnly:
+		return diff.WriteNameOnly(p.Wout, td)
+	}
+
+	alg, err := b.algorithm(config)
+	if err != nil {
+		return err
+	}
+	words, wordRegex, err := b.wordDiff(config)
+	if err != nil {
+		return err
+	}
+	colors, err := b.colors(p, config, words)
+	if err != nil {
+		return err
+	}
+	stat := b.flagStat.set || b.flagPatchWithStat || b.flagStatWidth != 0 ||
+		b.flagStatNameWidth != 0 || b.flagStatGraphWidth != 0 || b.flagStatCount != 0
+	dirstat := b.flagDirstat.set || b.flagDirstatByFile.set || b.flagCumulative
+	stats := stat || b.flagNumstat || b.flagShortstat || dirstat
+	patch := b.flagPatch || b.flagPatchWithStat || b.flagBinary || !stats
+
+	separate := false
+	if stat || b.flagNumstat || b.flagShortstat {
+		ds, err := diff.NewDiffStat(td, before, after, alg, bd)
+		if err != nil {
+			return err
+		}
+		if b.flagNumstat {
+			if err = ds.WriteNumstat(p.Wout); err != nil {
+				return err
+			}
+		}
+		if stat {
+			statOpts, err := b.statOptions(config)
+			if err != nil {
+				return err
+			}
+			statOpts.Colors = colors
+			if err = ds.WriteStat(p.Wout, statOpts); err != nil {
+				return err
+			}
+		}
+		if b.flagShortstat {
+			if err = ds.WriteShortstat(p.Wout); err != nil {
+				return err
+			}
+		}
+		separate = true
+	}
+	if dirstat {
+		dirstatOpts, err := b.dirstatOptions(config)
+		if err != nil {
+			return err
+		}
+		dirstatOpts.Algorithm, dirstatOpts.Detector = alg, bd
+		if err = diff.WriteDirstat(p.Wout, td, before, after, dirstatOpts); err != nil {
+			return err
+		}
+		separate = separate || dirstatOpts.Mode == diff.DirstatLines
+	}
+
+	if !patch {
+		return nil
+	}
+	if separate {
+		fmt.Fprintln(p.Wout)
+	}
+	patchOpts := diff.PatchOptions{
+		Context:   b.flagContext,
+		FullIndex: b.flagFullIndex,
+		Algorithm: alg,
+		Detector:  bd,
+		Text:      b.flagText,
+		Binary:    b.flagBinary,
+		Colors:    colors,
+		WordDiff:  words,
+		WordRegex: wordRegex,
+	}
+	if patchOpts.Whitespace, err = diff.ParseWhitespaceRule(config.String("core.whitespace", "")); err != nil {
+		return err
+	}
+	if patchOpts.Moved, patchOpts.MovedWhitespace, err = b.moved(config); err != nil {
+		return err
+	}
+	return diff.NewPatchWriter(p.Wout, before, after, patchOpts).WriteDiff(td)
+}
+
+// algorithm returns the diff algorithm that was asked for,
+// either on the command line or by diff.algorithm.
+func (b *DiffBuiltin) algorithm(config *api.Config) (diff.Algorithm, error) {
+	switch {
+	case b.flagAlgorithm != "":
+		return diff.ParseAlgorithm(b.flagAlgorithm)
+	case b.flagHistogram:
+		return diff.Histogram, nil
+	case b.flagPatience:
+		return diff.Patience, nil
+	case b.flagMinimal:
+		return diff.Minimal, nil
+	}
+	if name, ok := config.Get("diff.algorithm"); ok {
+		return diff.ParseAlgorithm(name)
+	}
+	return diff.Myers, nil
+}
+
+// wordDiff returns the mode of the word diff and the regular
+// expression of words, which --word-diff-regex, --color-words
+// and then diff.wordRegex give. A regular expression turns on
+// the plain word diff, unless a mode was given.
+func (b *DiffBuiltin) wordDiff(config *api.Config) (mode diff.WordDiffMode, re *regexp.Regexp, err error) {
+	expr := b.flagWordDiffRegex
+	switch {
+	case b.flagWordDiff.set:
+		if mode, err = diff.ParseWordDiffMode(b.flagWordDiff.value); err != nil {
+			return
+		}
+	case b.flagColorWords.set:
+		mode = diff.WordDiffColor
+	case expr != "":
+		mode = diff.WordDiffPlain
+	}
+	if expr == "" {
+		expr = b.flagColorWords.value
+	}
+	if expr == "" {
+		expr = config.String("diff.wordRegex", "")
+	}
+	if mode != diff.WordDiffNone && expr != "" {
+		re, err = diff.CompileWordRegex(expr)
+	}
+	return
+}
+
+// colors returns the colors of the diff, or nil if it is not
+// colored. Unless --color or --no-color say otherwise, color.diff
+// and then color.ui decide, and output to terminals is colored
+// by default. Color word diffs are always colored.
+func (b *DiffBuiltin) colors(p *Params, config *api.Config, words diff.WordDiffMode) (*diff.Colors, error) {
+	when := util.ColorAuto
+	for _, key := range []string{"color.diff", "color.ui"} {
+		if _, ok := config.Get(key); ok {
+			var err error
+			if when, err = colorSetting(config, key); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if n := len(b.flagColor); n > 0 {
+		switch f := b.flagColor[n-1]; {
+		case f == "N":
+			when = util.ColorNever
+		case f == "C":
+			// the bare --color
+			when = util.ColorAlways
+		default:
+			var err error
+			if when, err = util.ParseColorWhen(f[1:]); err != nil {
+				return nil, fmt.Errorf("option `color' expects \"always\", \"auto\", or \"never\"")
+			}
+		}
+	}
+	if !when.Enabled(p.Wout) && words != diff.WordDiffColor {
+		return nil, nil
+	}
+	return diff.NewColors(config)
+}
+
+// colorSetting reads a setting such as color.ui, which may also
+// be a boolean.
+func colorSetting(config *api.Config, key string) (util.ColorWhen, error) {
+	if v, _ := config.Get(key); v != "" {
+		return util.ParseColorWhen(v)
+	}
+	on, err := config.Bool(key, false)
+	if !on {
+		return util.ColorNever, err
+	}
+	return util.ColorAuto, nil
+}
+
+// moved returns the way in which moved lines are colored, and
+// the whitespace that is ignored when they are compared, from
+// diff.colorMoved and diff.colorMovedWS and then from the
+// command line.
+func (b *DiffBuiltin) moved(config *api.Config) (mode diff.MovedMode, ws diff.MovedWhitespace, err error) {
+	if v, ok := config.Get("diff.colorMoved"); ok {
+		if v == "" {
+			v = "true"
+		}
+		if mode, err = diff.ParseMovedMode(v); err != nil {
+			return
+		}
+	}
+	if n := len(b.flagColorMoved); n > 0 {
+		switch f := b.flagColorMoved[n-1]; {
+		case f == "N":
+			mode = diff.MovedNo
+		case f == "C":
+			mode = diff.MovedDefault
+		default:
+			if mode, err = diff.ParseMovedMode(f[1:]); err != nil {
+				return
+			}
+		}
+	}
+	value := b.flagColorMovedWS
+	if value == "" {
+		value = config.String("diff.colorMovedWS", "")
+	}
+	if value != "" {
+		ws, err = diff.ParseMovedWhitespace(value)
+	}
+	return
+}
+
+// statOptions returns the layout of the diffstat, which is as
+// wide as the terminal unless --stat says otherwise. The width
+// of the graph may be limited by diff.statGraphWidth.
+func (b *DiffBuiltin) statOptions(config *api.Config) (opts diff.StatOptions, err error) {
+	if opts.GraphWidth, err = config.Int("diff.statGraphWidth", 0); err != nil {
 		return
-	} else {
-		ot = oah
-	}
-	switch ot {
-	case objects.ObjectBlob:
-		oab, _ := oa.(*objects.Blob)
-		obb, _ := ob.(*objects.Blob)
-		d := diff.NewBlobComparator().Diff(oab, obb, gdiff.LineSplit)
-		gdiff.Unified().Print(d, os.Stdout)
-	case objects.ObjectTree:
-		oat, _ := oa.(*objects.Tree)
-		obt, _ := ob.(*objects.Tree)
-		td := diff.NewTreeDiffer(p.Repo, diff.NewBlobComparator())
-		if result, err := td.Diff(oat, obt); err != nil {
-			fmt.Printf(err.Error())
-		} else {
-			fmt.Printf(result.String())
-		}
-		//TODO
+	}
+	opts.Width = util.TermColumns()
+	if b.flagStat.value != "" {
+		widths := strings.Split(b.flagStat.value, ",")
+		if len(widths) > 3 {
+			return opts, fmt.Errorf("bad --stat value: %s", b.flagStat.value)
+		}
+		fields := []*int{&opts.Width, &opts.NameWidth, &opts.Count}
+		for i, w := range widths {
+			if *fields[i], err = strconv.Atoi(w); err != nil {
+				return opts, fmt.Errorf("bad --stat value: %s", b.flagStat.value)
+			}
+		}
+	}
+	if b.flagStatWidth != 0 {
+		opts.Width = b.flagStatWidth
+	}
+	if b.flagStatNameWidth != 0 {
+		opts.NameWidth = b.flagStatNameWidth
+	}
+	if b.flagStatGraphWidth != 0 {
+		opts.GraphWidth = b.flagStatGraphWidth
+	}
+	if b.flagStatCount != 0 {
+		opts.Count = b.flagStatCount
+	}
+	return opts, nil
+}
+
+// dirstatOptions returns the options of the dirstat, from
+// diff.dirstat and then from the command line.
+func (b *DiffBuiltin) dirstatOptions(config *api.Config) (diff.DirstatOptions, error) {
+	opts := diff.NewDirstatOptions()
+	if params, ok := config.Get("diff.dirstat"); ok {
+		if err := opts.Parse(params); err != nil {
+			return opts, err
+		}
+	}
+	if b.flagCumulative {
+		opts.Cumulative = true
+	}
+	if b.flagDirstatByFile.set {
+		opts.Mode = diff.DirstatFiles
+		if err := opts.Parse(b.flagDirstatByFile.value); err != nil {
+			return opts, err
+		}
+	}
+	if err := opts.Parse(b.flagDirstat.value); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// renameOptions returns the options of rename detection, or nil
+// if renames are not detected. Renames are detected unless
+// diff.renames turns them off, and -M, -C and --no-renames
+// override it in the order that they are given.
+func (b *DiffBuiltin) renameOptions(config *api.Config) (*diff.RenameOptions, error) {
+	opts, detect := diff.NewRenameOptions(), true
+	var err error
+	if v, ok := config.Get("diff.renames"); ok && (strings.EqualFold(v, "copy") || strings.EqualFold(v, "copies")) {
+		opts.Copies = true
+	} else if detect, err = config.Bool("diff.renames", true); err != nil {
+		return nil, err
+	}
+	limit, err := config.Int("diff.renameLimit", diff.DefaultRenameLimit)
+	if err != nil {
+		return nil, err
+	}
+	opts.Limit = limit
+	for _, f := range b.flagRenames {
+		tag, value := f[0], f[1:]
+		switch tag {
+		case 'N':
+			detect = false
+			continue
+		case 'C':
+			// -C after -C looks for copies harder
+			opts.CopiesHarder = opts.CopiesHarder || (detect && opts.Copies)
+			opts.Copies = true
+		case 'M':
+			opts.Copies = false
+		}
+		detect = true
+		if opts.MinScore, err = diff.ParseRenameScore(value); err != nil {
+			return nil, err
+		}
+	}
+	// looking for copies harder turns copies back on, even
+	// after --no-renames
+	if b.flagCopiesHarder || opts.CopiesHarder {
+		detect, opts.Copies, opts.CopiesHarder = true, true, true
+	}
+	if !detect {
+		return nil, nil
+	}
+	if b.flagRenameLimit >= 0 {
+		opts.Limit = b.flagRenameLimit
+	}
+	return opts, nil
+}
+
+// warnRenameLimit warns if rename detection was cut short by
+// the rename limit.
+func warnRenameLimit(p *Params, td *diff.TreeDiff) {
+	switch {
+	case td.RenameDegraded():
+		fmt.Fprintln(p.Werr, "warning: only found copies from modified paths due to too many files.")
+	case td.RenameLimitNeeded() > 0:
+		fmt.Fprintln(p.Werr, "warning: exhaustive rename detection was skipped due to too many files.")
 	default:
-		fmt.Printf("objects are of type %s; only blobs (files) and trees are currently supported", ot)
+		return
+	}
+	fmt.Fprintf(p.Werr, "warning: you may want to set your diff.renameLimit variable to at least %d and retry the command.\n", td.RenameLimitNeeded())
+}
+
+// diffBlobs compares two blobs, which are named after the
+// revisions that they were given by, or after their paths if
+// those revisions are of the form <rev>:<path>.
+func diffBlobs(revs []string, blobA *objects.Blob, ob objects.Object) (*diff.TreeDiff, error) {
+	blobB, ok := ob.(*objects.Blob)
+	if !ok {
+		return nil, fmt.Errorf("cannot compare blob %s to %s %s", revs[0], ob.Header().Type(), revs[1])
+	}
+	if blobA.ObjectId().String() == blobB.ObjectId().String() {
+		return diff.NewEditDiff(), nil
+	}
+	edit := diff.NewModifyEdit(
+		objects.NewTreeEntry(objects.ModeBlob, objects.ObjectBlob, blobName(revs[0]), blobA.ObjectId()),
+		objects.NewTreeEntry(objects.ModeBlob, objects.ObjectBlob, blobName(revs[1]), blobB.ObjectId()),
+	)
+	return diff.NewEditDiff(edit), nil
+}
+
+// blobName returns the name under which a blob that was
+// given by a revision is shown.
+func blobName(rev string) string {
+	if strings.HasPrefix(rev, ":") {
+		// :<path> or :<n>:<path>
+		if len(rev) > 2 && rev[2] == ':' && util.IsDigit(rev[1]) {
+			return rev[3:]
+		}
+		return rev[1:]
+	}
+	if i := strings.Index(rev, ":"); i >= 0 {
+		return rev[i+1:]
+	}
+	return rev
+}
+
+// isDiffRev returns true if the argument names a revision,
+// or a range of them. Arguments that name existing files are
+// taken to be paths.
+func isDiffRev(repo api.Repository, arg string) bool {
+	if strings.HasPrefix(arg, "-") {
+		return false
+	}
+	if _, err := os.Lstat(arg); err == nil {
+		return false
+	}
+	if i := strings.Index(arg, ".."); i >= 0 {
+		j := i + 2
+		if strings.HasPrefix(arg[j:], ".") {
+			j++
+		}
+		return isDiffRev(repo, orHead(arg[:i])) && isDiffRev(repo, orHead(arg[j:]))
+	}
+	_, err := api.LookupRevision(repo, arg)
+	return err == nil
+}
+
+// orHead returns the revision, or HEAD if it is empty, which
+// is what the omitted end of a range means.
+func orHead(rev string) string {
+	if rev == "" {
+		return "HEAD"
 	}
+	return rev
 }
@@ -0,0 +1,282 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// ================================================================= //
+// FLAG TYPES
+// ================================================================= //
+
+// stringsFlag is a flag.Value that collects every
+// occurrence of a repeatable flag, in order.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// optionalFlag is a flag.Value for flags whose value is
+// optional, as in --stat and --stat=100. The bare form sets
+// the flag with an empty value.
+type optionalFlag struct {
+	set   bool
+	value string
+}
+
+func (f *optionalFlag) String() string {
+	return f.value
+}
+
+func (f *optionalFlag) Set(value string) error {
+	f.set = true
+	if value == "true" {
+		// the flag package passes "true" for the bare form
+		value = ""
+	}
+	f.value = value
+	return nil
+}
+
+func (f *optionalFlag) IsBoolFlag() bool {
+	return true
+}
+
+// taggedFlag is a flag.Value for flags whose value is optional
+// and whose order matters, as in -M and -C. The occurrences of
+// all such flags are collected in one list, each value prefixed
+// by the tag of its flag.
+type taggedFlag struct {
+	tag    string
+	values *stringsFlag
+}
+
+func (f taggedFlag) String() string {
+	return ""
+}
+
+func (f taggedFlag) Set(value string) error {
+	if value == "true" {
+		// the flag package passes "true" for the bare form
+		value = ""
+	}
+	return f.values.Set(f.tag + value)
+}
+
+func (f taggedFlag) IsBoolFlag() bool {
+	return true
+}
+
+// orderedFlag is a flag.Value for flags that take values and
+// whose order matters among other flags, as in -m and -F. The
+// occurrences of all such flags are collected in one list, each
+// value prefixed by the tag of its flag.
+type orderedFlag struct {
+	tag    string
+	values *stringsFlag
+}
+
+func (f orderedFlag) String() string {
+	return ""
+}
+
+func (f orderedFlag) Set(value string) error {
+	return f.values.Set(f.tag + value)
+}
+
+// choiceFlag is a flag.Value for bare flags that choose among
+// the values of a shared variable, as in --ours and --theirs,
+// so that the last of them wins.
+type choiceFlag struct {
+	choice *int
+	value  int
+}
+
+func (f choiceFlag) String() string {
+	return ""
+}
+
+func (f choiceFlag) Set(value string) error {
+	if value != "true" {
+		return fmt.Errorf("unexpected value: %s", value)
+	}
+	*f.choice = f.value
+	return nil
+}
+
+func (f choiceFlag) IsBoolFlag() bool {
+	return true
+}
+
+// modeFlag is a flag.Value for bare flags that choose the mode
+// of a command, as in --octopus and --independent, of which at
+// most one may be given.
+type modeFlag struct {
+	mode *string
+	name string
+}
+
+func (f modeFlag) String() string {
+	return ""
+}
+
+func (f modeFlag) Set(value string) error {
+	if value != "true" {
+		return fmt.Errorf("unexpected value: %s", value)
+	}
+	if *f.mode != "" && *f.mode != f.name {
+		return fmt.Errorf("option `%s' is incompatible with --%s", f.name, *f.mode)
+	}
+	*f.mode = f.name
+	return nil
+}
+
+func (f modeFlag) IsBoolFlag() bool {
+	return true
+}
+
+// countFlag is a flag.Value for bare flags that count how many
+// times they are given, as in -v, where -vv counts twice.
+type countFlag struct {
+	count *int
+	by    int
+}
+
+func (f countFlag) String() string {
+	return ""
+}
+
+func (f countFlag) Set(value string) error {
+	if value != "true" {
+		return fmt.Errorf("unexpected value: %s", value)
+	}
+	*f.count += f.by
+	return nil
+}
+
+func (f countFlag) IsBoolFlag() bool {
+	return true
+}
+
+// ================================================================= //
+// PARSING
+// ================================================================= //
+
+// parseInterspersed parses flags that may come after arguments, as
+// git's options may, and returns the arguments. Everything after
+// "--" is an argument. Short flags may be given together, as in -am.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+	args = splitShortFlags(fs, args)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if args = fs.Args(); len(args) == 0 {
+			break
+		}
+		positional, args = append(positional, args[0]), args[1:]
+	}
+	return append(positional, rest...), nil
+}
+
+// splitShortFlags splits the short flags that are given together,
+// as in -am, into flags of their own. A flag that takes a value ends
+// them, and what is left of the argument is its value, as in -mfix.
+// Arguments that aren't made of defined flags are left as they are.
+func splitShortFlags(fs *flag.FlagSet, args []string) []string {
+	var split []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[0] != '-' {
+			split = append(split, arg)
+			continue
+		}
+		if f := fs.Lookup(strings.TrimLeft(arg, "-")); f != nil {
+			// the value of the flag may be the next argument,
+			// which is left alone even if it looks like flags
+			split = append(split, arg)
+			if !isBoolFlag(f) && i+1 < len(args) {
+				i++
+				split = append(split, args[i])
+			}
+			continue
+		}
+		flags, takesNext := splitShortFlag(fs, arg)
+		split = append(split, flags...)
+		if takesNext && i+1 < len(args) {
+			i++
+			split = append(split, args[i])
+		}
+	}
+	return split
+}
+
+// splitShortFlag splits one argument of short flags, and tells
+// whether its last flag takes the next argument as its value.
+func splitShortFlag(fs *flag.FlagSet, arg string) ([]string, bool) {
+	var flags []string
+	for i := 1; i < len(arg); i++ {
+		f := fs.Lookup(arg[i : i+1])
+		if f == nil {
+			return []string{arg}, false
+		}
+		if isBoolFlag(f) {
+			flags = append(flags, "-"+f.Name)
+			continue
+		}
+		value := arg[i+1:]
+		if value == "" {
+			return append(flags, "-"+f.Name), true
+		} else if value[0] == '=' && i == 1 {
+			// as in -m=fix, which the flag package parses
+			return []string{arg}, false
+		}
+		return append(flags, "-"+f.Name+"="+value), false
+	}
+	return flags, false
+}
+
+// isBoolFlag returns true if the flag needs no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// lastArgDefault gives a default value to flags whose value is
+// optional only when they come last, as in --merged, by appending
+// the default after such a flag.
+func lastArgDefault(args []string, def string, names ...string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	for _, name := range names {
+		if args[len(args)-1] == name {
+			return append(append([]string{}, args...), def)
+		}
+	}
+	return args
+}
@@ -0,0 +1,553 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/mailbox"
+	"github.com/jbrukh/ggit/api/objects"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ================================================================= //
+// FORMAT-PATCH
+// ================================================================= //
+
+// the ways in which the subjects of patches may be numbered
+const (
+	numberedAuto = iota
+	numberedAlways
+	numberedNever
+)
+
+// the width of the diffstats of patches
+const patchStatWidth = 72
+
+// the name of the file of the cover letter
+const coverLetterName = "0000-cover-letter.patch"
+
+// FormatPatchBuiltin implements git-format-patch, which writes the
+// commits of a range as emails in the mbox format, one per commit,
+// each with the message of the commit, a diffstat and the patch,
+// either into files or to the standard output.
+type FormatPatchBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagNumbered      int
+	flagStartNumber   int
+	flagCoverLetter   bool
+	flagStdout        bool
+	flagOutputDir     string
+	flagSubjectPrefix string
+	flagSignature     stringsFlag
+	flagNoSignature   bool
+	flagQuiet         bool
+	flagRoot          bool
+	flagMaxCount      int
+}
+
+var FormatPatch = &FormatPatchBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "format-patch",
+		Description: "Prepare patches for e-mail submission",
+		UsageLine:   "[-<n>] [-n | -N] [--start-number <n>] [--cover-letter] [--stdout | -o <dir>] [--subject-prefix=<prefix>] [--signature=<signature> | --no-signature] [-q] [--root] [<since> | <revision-range>]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	FormatPatch.Var(choiceFlag{&FormatPatch.flagNumbered, numberedAlways}, "n", "Number the patches, as in [PATCH n/m], even if there is only one.")
+	FormatPatch.Var(choiceFlag{&FormatPatch.flagNumbered, numberedAlways}, "numbered", "Synonym of -n.")
+	FormatPatch.Var(choiceFlag{&FormatPatch.flagNumbered, numberedNever}, "N", "Don't number the patches, as in [PATCH].")
+	FormatPatch.Var(choiceFlag{&FormatPatch.flagNumbered, numberedNever}, "no-numbered", "Synonym of -N.")
+	FormatPatch.IntVar(&FormatPatch.flagStartNumber, "start-number", 1, "Number the patches starting at <n>.")
+	FormatPatch.BoolVar(&FormatPatch.flagCoverLetter, "cover-letter", false, "Write a cover letter that sums up the patches.")
+	FormatPatch.BoolVar(&FormatPatch.flagStdout, "stdout", false, "Write the patches to the standard output as a mailbox.")
+	FormatPatch.StringVar(&FormatPatch.flagOutputDir, "o", "", "Write the files of the patches into <dir>.")
+	FormatPatch.StringVar(&FormatPatch.flagOutputDir, "output-directory", "", "Synonym of -o.")
+	FormatPatch.StringVar(&FormatPatch.flagSubjectPrefix, "subject-prefix", "", "Prefix the subjects with [<prefix>] instead of [PATCH].")
+	FormatPatch.Var(&FormatPatch.flagSignature, "signature", "End the patches with <signature> instead of the version of ggit.")
+	FormatPatch.BoolVar(&FormatPatch.flagNoSignature, "no-signature", false, "Don't end the patches with a signature.")
+	FormatPatch.BoolVar(&FormatPatch.flagQuiet, "q", false, "Don't print the names of the files.")
+	FormatPatch.BoolVar(&FormatPatch.flagQuiet, "quiet", false, "Synonym of -q.")
+	FormatPatch.BoolVar(&FormatPatch.flagRoot, "root", false, "Take a single revision as the commits that it reaches, rather than as <since>.")
+	FormatPatch.IntVar(&FormatPatch.flagMaxCount, "max-count", -1, "Format the patches of the <n> newest commits only.")
+
+	FormatPatch.Usage = func() {}
+
+	// add to command list
+	Add(FormatPatch)
+}
+
+// matches the counts of commits, as in -3
+var formatPatchCount = regexp.MustCompile(`^-([0-9]+)$`)
+
+func (b *FormatPatchBuiltin) Execute(p *Params, args []string) {
+	for i, arg := range args {
+		if m := formatPatchCount.FindStringSubmatch(arg); m != nil {
+			args[i] = "--max-count=" + m[1]
+		}
+	}
+	b.flagNumbered, b.flagStartNumber, b.flagCoverLetter, b.flagStdout = numberedAuto, 1, false, false
+	b.flagOutputDir, b.flagSubjectPrefix, b.flagSignature, b.flagNoSignature = "", "", nil, false
+	b.flagQuiet, b.flagRoot, b.flagMaxCount = false, false, -1
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.formatPatch(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+// patchWriter writes the emails of patches, and the cover letter
+// that comes before them.
+type patchWriter struct {
+	repo      *api.DiskRepository
+	config    *api.Config
+	prefix    string // the subject prefix, as in "PATCH"
+	numbered  bool
+	start     int // the number of the first patch
+	total     int // the number of the last patch
+	signature string
+	detector  *diff.BinaryDetector
+	algorithm diff.Algorithm
+}
+
+func (b *FormatPatchBuiltin) formatPatch(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	commits, origin, status, err := b.commits(p, repo, args)
+	if status != 0 || err != nil || len(commits) == 0 {
+		return status, err
+	}
+
+	pw := &patchWriter{
+		repo:   repo,
+		config: config,
+		prefix: b.flagSubjectPrefix,
+		start:  b.flagStartNumber,
+		total:  len(commits) + b.flagStartNumber - 1,
+	}
+	if pw.prefix == "" {
+		pw.prefix = config.String("format.subjectPrefix", "PATCH")
+	}
+	switch b.flagNumbered {
+	case numberedAlways:
+		pw.numbered = true
+	case numberedAuto:
+		if value, ok := config.Get("format.numbered"); ok && !strings.EqualFold(value, "auto") {
+			if pw.numbered, err = config.Bool("format.numbered", false); err != nil {
+				return 0, err
+			}
+		} else {
+			pw.numbered = pw.total > 1 || b.flagCoverLetter
+		}
+	}
+	if !b.flagNoSignature {
+		pw.signature = config.String("format.signature", ggit.Version)
+		if n := len(b.flagSignature); n > 0 {
+			pw.signature = b.flagSignature[n-1]
+		}
+	}
+	attrs, err := api.NewAttributes(repo)
+	if err != nil {
+		return 0, err
+	}
+	pw.detector = diff.NewBinaryDetector(attrs, config)
+	pw.algorithm = diff.Myers
+	if name, ok := config.Get("diff.algorithm"); ok {
+		if pw.algorithm, err = diff.ParseAlgorithm(name); err != nil {
+			return 0, err
+		}
+	}
+
+	dir := b.flagOutputDir
+	if dir == "" {
+		dir = config.String("format.outputDirectory", "")
+	}
+	if dir != "" && !b.flagStdout {
+		if err = os.MkdirAll(dir, 0777); err != nil {
+			return 0, fmt.Errorf("could not create directory '%s'", dir)
+		}
+		if !strings.HasSuffix(dir, "/") {
+			dir += "/"
+		}
+	}
+
+	// every patch but the first that is shown is set apart from the
+	// one before it in the mailbox, which the cover letter isn't
+	shown := false
+	output := func(name string, patch bool, write func(w io.Writer) (bool, error)) error {
+		if b.flagStdout {
+			var buf bytes.Buffer
+			written, err := write(&buf)
+			if err != nil {
+				return err
+			}
+			if shown && written && patch {
+				fmt.Fprintln(p.Wout)
+			}
+			shown = shown || written && patch
+			_, err = buf.WriteTo(p.Wout)
+			return err
+		}
+		if !b.flagQuiet {
+			fmt.Fprintln(p.Wout, dir+name)
+		}
+		f, err := os.Create(dir + name)
+		if err != nil {
+			return fmt.Errorf("cannot open patch file %s", dir+name)
+		}
+		if _, err = write(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	if b.flagCoverLetter {
+		err = output(coverLetterName, false, func(w io.Writer) (bool, error) {
+			return true, pw.writeCoverLetter(p, w, commits, origin)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	for i, c := range commits {
+		nr := pw.start + i
+		subject, _ := mailbox.SplitMessage(c.Message())
+		err = output(mailbox.FileName(nr, subject), true, func(w io.Writer) (bool, error) {
+			return pw.writePatch(w, c, nr)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// commits returns the commits whose patches are formatted, oldest
+// first, and the commit that they all come after, if there is only
+// one. A single revision names the commits that HEAD has and it
+// doesn't, unless it is taken as the commits that it reaches, which
+// it is with --root or a count, in which case HEAD is the revision
+// if none is given. Merges have no patches.
+func (b *FormatPatchBuiltin) commits(p *Params, repo *api.DiskRepository, args []string) ([]*objects.Commit, *objects.Commit, int, error) {
+	var positive, negative []*objects.Commit
+	for _, arg := range args {
+		revs, excluded := []string{arg}, []bool{false}
+		switch {
+		case strings.Contains(arg, ".."):
+			i := strings.Index(arg, "..")
+			from, to := arg[:i], arg[i+2:]
+			if from == "" {
+				from = api.HeadRef
+			}
+			if to == "" {
+				to = api.HeadRef
+			}
+			revs, excluded = []string{from, to}, []bool{true, false}
+		case strings.HasPrefix(arg, "^"):
+			revs, excluded = []string{arg[1:]}, []bool{true}
+		case len(args) == 1 && b.flagMaxCount < 0 && !b.flagRoot:
+			revs, excluded = []string{arg, api.HeadRef}, []bool{true, false}
+		}
+		for i, rev := range revs {
+			o, err := api.LookupRevision(repo, rev)
+			if err == nil {
+				o, err = api.PeelObject(repo, o)
+			}
+			c, ok := o.(*objects.Commit)
+			if err != nil || !ok {
+				fmt.Fprintf(p.Werr, "fatal: ambiguous argument '%s': unknown revision or path not in the working tree.\n", arg)
+				fmt.Fprintln(p.Werr, "Use '--' to separate paths from revisions, like this:")
+				fmt.Fprintln(p.Werr, "'git <command> [<revision>...] -- [<file>...]'")
+				return nil, nil, 128, nil
+			}
+			if excluded[i] {
+				negative = append(negative, c)
+			} else {
+				positive = append(positive, c)
+			}
+		}
+	}
+	if len(args) == 0 && (b.flagMaxCount >= 0 || b.flagRoot) {
+		head, err := headCommit(repo)
+		if err != nil || head == nil {
+			return nil, nil, 0, err
+		}
+		positive = append(positive, head)
+	}
+
+	excluded := make(map[string]bool)
+	err := api.WalkCommits(repo, negative, func(c *objects.Commit) error {
+		excluded[c.ObjectId().String()] = true
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var commits []*objects.Commit
+	included := make(map[string]bool)
+	err = api.WalkCommits(repo, positive, func(c *objects.Commit) error {
+		if b.flagMaxCount >= 0 && len(commits) == b.flagMaxCount {
+			return api.StopWalk
+		}
+		if !excluded[c.ObjectId().String()] && len(c.Parents()) <= 1 {
+			commits = append(commits, c)
+			included[c.ObjectId().String()] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+
+	// the parents of the commits that aren't formatted themselves
+	var boundary []*objects.ObjectId
+	for _, c := range commits {
+		for _, oid := range c.Parents() {
+			if !included[oid.String()] {
+				included[oid.String()] = true
+				boundary = append(boundary, oid)
+			}
+		}
+	}
+	var origin *objects.Commit
+	if len(boundary) == 1 {
+		if origin, err = api.CommitFromOid(repo, boundary[0]); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	return commits, origin, 0, nil
+}
+
+// ================================================================= //
+// PATCHES
+// ================================================================= //
+
+// subjectPrefix returns the prefix of the subject of a patch with a
+// number, as in "[PATCH 2/3]".
+func (pw *patchWriter) subjectPrefix(nr int) string {
+	if !pw.numbered {
+		return "[" + pw.prefix + "]"
+	}
+	digits := len(fmt.Sprint(pw.total))
+	return fmt.Sprintf("[%s %0*d/%d]", pw.prefix, digits, nr, pw.total)
+}
+
+// writePatch writes the email of the patch of a commit, and returns
+// true if there was one to write, which there isn't for a commit that
+// changes nothing.
+func (pw *patchWriter) writePatch(w io.Writer, c *objects.Commit, nr int) (bool, error) {
+	var parent *objects.Tree
+	if len(c.Parents()) > 0 {
+		pc, err := api.CommitFromOid(pw.repo, c.Parents()[0])
+		if err != nil {
+			return false, err
+		}
+		if parent, err = api.TreeFromOid(pw.repo, pc.Tree()); err != nil {
+			return false, err
+		}
+	}
+	t, err := api.TreeFromOid(pw.repo, c.Tree())
+	if err != nil {
+		return false, err
+	}
+	td, err := pw.diff(parent, t)
+	if err != nil || len(td.Edits()) == 0 {
+		return false, err
+	}
+
+	if _, err = mailbox.NewMessage(c, pw.subjectPrefix(nr)).WriteTo(w); err != nil {
+		return false, err
+	}
+	if _, err = io.WriteString(w, "---\n"); err != nil {
+		return false, err
+	}
+	if err = pw.writeDiffstat(w, td); err != nil {
+		return false, err
+	}
+	source := diff.RepoSource(pw.repo)
+	opts := diff.PatchOptions{
+		Context:   3,
+		Algorithm: pw.algorithm,
+		Detector:  pw.detector,
+		Binary:    true,
+	}
+	if err = diff.NewPatchWriter(w, source, source, opts).WriteDiff(td); err != nil {
+		return false, err
+	}
+	return true, pw.writeSignature(w)
+}
+
+// diff compares two trees, finding renames unless diff.renames turns
+// them off.
+func (pw *patchWriter) diff(before, after *objects.Tree) (*diff.TreeDiff, error) {
+	opts := new(diff.Options)
+	if renames, err := pw.config.Bool("diff.renames", true); err != nil {
+		return nil, err
+	} else if renames {
+		opts.Renames = diff.NewRenameOptions()
+	}
+	return diff.DiffTrees(pw.repo, before, after, opts)
+}
+
+// writeDiffstat writes the diffstat and the summary of a diff,
+// followed by a blank line.
+func (pw *patchWriter) writeDiffstat(w io.Writer, td *diff.TreeDiff) error {
+	source := diff.RepoSource(pw.repo)
+	ds, err := diff.NewDiffStat(td, source, source, pw.algorithm, pw.detector)
+	if err != nil {
+		return err
+	}
+	if err = ds.WriteStat(w, diff.StatOptions{Width: patchStatWidth}); err != nil {
+		return err
+	}
+	if err = diff.WriteSummary(w, td); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// writeSignature writes the signature that ends every email, if
+// there is one.
+func (pw *patchWriter) writeSignature(w io.Writer) error {
+	if pw.signature == "" {
+		return nil
+	}
+	sig := pw.signature
+	if !strings.HasSuffix(sig, "\n") {
+		sig += "\n"
+	}
+	_, err := fmt.Fprintf(w, "-- \n%s\n", sig)
+	return err
+}
+
+// ================================================================= //
+// COVER LETTERS
+// ================================================================= //
+
+// writeCoverLetter writes the cover letter of the patches of commits,
+// which are sent by the committer. It has placeholders for its subject
+// and its blurb, the subjects of the commits by author, and, if they
+// all come after one commit, the diffstat of all of them.
+func (pw *patchWriter) writeCoverLetter(p *Params, w io.Writer, commits []*objects.Commit, origin *objects.Commit) error {
+	who, err := identity(p.Werr, pw.config, api.RoleCommitter)
+	if err != nil {
+		return err
+	}
+	head := commits[len(commits)-1]
+	m := &mailbox.Message{
+		Commit:  head.ObjectId(),
+		Author:  who,
+		Prefix:  pw.subjectPrefix(0),
+		Subject: "*** SUBJECT HERE ***",
+		Body:    "*** BLURB HERE ***\n\n" + shortlog(commits),
+	}
+	for _, c := range commits {
+		if !isASCII(c.Author().Name()) || !isASCII(c.Committer().Name()) || !isASCII(c.Message()) {
+			m.EightBit = true
+		}
+	}
+	if _, err = m.WriteTo(w); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	if origin != nil {
+		before, err := api.TreeFromOid(pw.repo, origin.Tree())
+		if err != nil {
+			return err
+		}
+		after, err := api.TreeFromOid(pw.repo, head.Tree())
+		if err != nil {
+			return err
+		}
+		td, err := pw.diff(before, after)
+		if err != nil {
+			return err
+		}
+		if err = pw.writeDiffstat(w, td); err != nil {
+			return err
+		}
+	}
+	return pw.writeSignature(w)
+}
+
+// shortlog lists the subjects of commits by author, as git-shortlog
+// does, with the authors in order of their names, and the subjects of
+// each of them oldest first.
+func shortlog(commits []*objects.Commit) string {
+	subjects := make(map[string][]string)
+	var authors []string
+	for _, c := range commits {
+		name := c.Author().Name()
+		if _, ok := subjects[name]; !ok {
+			authors = append(authors, name)
+		}
+		subject := commitSubject(c)
+		if strings.HasPrefix(subject, "[PATCH") {
+			if i := strings.Index(subject, "]"); i >= 0 {
+				subject = strings.TrimLeft(subject[i+1:], " \t")
+			}
+		}
+		subjects[name] = append(subjects[name], subject)
+	}
+	sort.Strings(authors)
+	var b bytes.Buffer
+	for i, name := range authors {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "%s (%d):\n", name, len(subjects[name]))
+		for j, subject := range subjects[name] {
+			if j > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(mailbox.WrapText(subject, 2, 4, patchStatWidth))
+		}
+	}
+	return b.String()
+}
+
+// isASCII returns true if a string has no bytes beyond ASCII.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,241 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"strings"
+)
+
+// ================================================================= //
+// LS-FILES
+// ================================================================= //
+
+// LsFilesBuiltin implements git-ls-files, which shows
+// information about files in the index and the working
+// tree.
+type LsFilesBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagCached          bool
+	flagDeleted         bool
+	flagModified        bool
+	flagOthers          bool
+	flagIgnored         bool
+	flagStage           bool
+	flagUnmerged        bool
+	flagDebug           bool
+	flagNulTerm         bool
+	flagFullName        bool
+	flagExcludeStandard bool
+	flagExcludePerDir   string
+	flagExclude         stringsFlag
+	flagExcludeFrom     stringsFlag
+}
+
+var LsFiles = &LsFilesBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "ls-files",
+		Description: "Show information about files in the index and the working tree",
+		UsageLine:   "[-c] [-d] [-m] [-o] [-i] [-s] [-u] [--debug] [-z] [-x <pattern>] [-X <file>] [--exclude-standard] [--] [<file>...]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	LsFiles.BoolVar(&LsFiles.flagCached, "c", false, "Show cached files in the output (default).")
+	LsFiles.BoolVar(&LsFiles.flagCached, "cached", false, "Show cached files in the output (default).")
+	LsFiles.BoolVar(&LsFiles.flagDeleted, "d", false, "Show deleted files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagDeleted, "deleted", false, "Show deleted files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagModified, "m", false, "Show modified files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagModified, "modified", false, "Show modified files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagOthers, "o", false, "Show other (i.e. untracked) files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagOthers, "others", false, "Show other (i.e. untracked) files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagIgnored, "i", false, "Show only ignored files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagIgnored, "ignored", false, "Show only ignored files in the output.")
+	LsFiles.BoolVar(&LsFiles.flagStage, "s", false, "Show staged contents' mode bits, object name and stage number.")
+	LsFiles.BoolVar(&LsFiles.flagStage, "stage", false, "Show staged contents' mode bits, object name and stage number.")
+	LsFiles.BoolVar(&LsFiles.flagUnmerged, "u", false, "Show unmerged files in the output (forces --stage).")
+	LsFiles.BoolVar(&LsFiles.flagUnmerged, "unmerged", false, "Show unmerged files in the output (forces --stage).")
+	LsFiles.BoolVar(&LsFiles.flagDebug, "debug", false, "Show the cached stat data of each file.")
+	LsFiles.BoolVar(&LsFiles.flagNulTerm, "z", false, "\\0 line termination on output.")
+	LsFiles.BoolVar(&LsFiles.flagFullName, "full-name", false, "Show full path names instead of relative ones.")
+	LsFiles.BoolVar(&LsFiles.flagExcludeStandard, "exclude-standard", false, "Add the standard git exclusions.")
+	LsFiles.StringVar(&LsFiles.flagExcludePerDir, "exclude-per-directory", "", "Read additional exclude patterns that apply only to the directory and its subdirectories in <file>.")
+	LsFiles.Var(&LsFiles.flagExclude, "x", "Skip untracked files matching pattern.")
+	LsFiles.Var(&LsFiles.flagExclude, "exclude", "Skip untracked files matching pattern.")
+	LsFiles.Var(&LsFiles.flagExcludeFrom, "X", "Read exclude patterns from <file>; 1 per line.")
+	LsFiles.Var(&LsFiles.flagExcludeFrom, "exclude-from", "Read exclude patterns from <file>; 1 per line.")
+
+	LsFiles.Usage = func() {}
+
+	// add to command list
+	Add(LsFiles)
+}
+
+func (b *LsFilesBuiltin) Execute(p *Params, args []string) {
+	if err := b.Parse(args); err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if err := b.listFiles(p, b.Args()); err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+	}
+}
+
+// listFiles lists the files that the flags select, which match
+// the pathspecs in the arguments.
+func (b *LsFilesBuiltin) listFiles(p *Params, args []string) error {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return err
+	}
+
+	idx, err := p.Repo.Index()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if b.flagUnmerged {
+		b.flagStage = true
+	}
+	if !(b.flagCached || b.flagDeleted || b.flagModified || b.flagOthers || b.flagStage) {
+		b.flagCached = true
+	}
+
+	// exclusion rules
+	var ig *api.Ignorer
+	if b.flagExcludeStandard {
+		if ig, err = api.NewStandardIgnorer(repo); err != nil {
+			return err
+		}
+	}
+	if len(b.flagExclude) > 0 || len(b.flagExcludeFrom) > 0 || b.flagExcludePerDir != "" {
+		if ig == nil {
+			ig = api.NewIgnorer(repo.WorkDir())
+		}
+		for _, pattern := range b.flagExclude {
+			ig.AddPattern(pattern)
+		}
+		for _, file := range b.flagExcludeFrom {
+			if err = ig.AddPatternFile(file); err != nil {
+				return err
+			}
+		}
+		if b.flagExcludePerDir != "" {
+			ig.SetPerDirectoryFile(b.flagExcludePerDir)
+		}
+	}
+	if b.flagIgnored && ig == nil {
+		return errors.New("ls-files -i must be used with either -o or -c, and needs some exclude patterns")
+	}
+
+	prefix := workPrefix(p.Repo)
+	ps, err := pathspec.Parse(prefix, args, pathspec.PreferCwd)
+	if err != nil {
+		return err
+	}
+	if ps.Has(pathspec.MagicAttr) {
+		attrs, err := api.NewAttributes(repo)
+		if err != nil {
+			return err
+		}
+		ps.SetAttributes(attrs)
+	}
+	if b.flagFullName {
+		prefix = ""
+	}
+
+	wt := api.NewWorkTree(repo)
+
+	// untracked files come first
+	if b.flagOthers {
+		others, err := wt.Others(idx, ig, b.flagIgnored)
+		if err != nil {
+			return err
+		}
+		for _, name := range others {
+			if ps.Match(strings.TrimSuffix(name, "/"), strings.HasSuffix(name, "/")) {
+				b.showName(p, prefix, name)
+			}
+		}
+	}
+	if idx == nil {
+		return nil
+	}
+
+	for _, entry := range idx.Entries() {
+		if !b.showEntry(ig, ps, entry) {
+			continue
+		}
+		if (b.flagCached || b.flagStage) && !(b.flagUnmerged && entry.Stage() == 0) {
+			b.show(p, prefix, entry)
+		}
+		if !(b.flagDeleted || b.flagModified) || entry.ExtendedFlags().SkipWorktree() {
+			continue
+		}
+		status, err := wt.Status(entry)
+		if err != nil {
+			return err
+		}
+		if status == api.WorkDeleted && b.flagDeleted {
+			b.show(p, prefix, entry)
+		}
+		if status != api.WorkUnmodified && b.flagModified {
+			b.show(p, prefix, entry)
+		}
+	}
+	return nil
+}
+
+// showEntry decides whether an index entry is to be
+// listed at all.
+func (b *LsFilesBuiltin) showEntry(ig *api.Ignorer, ps *pathspec.Pathspec, entry *api.IndexEntry) bool {
+	if b.flagIgnored && !ig.Ignored(entry.Name(), false) {
+		return false
+	}
+	return ps.MatchIndexEntry(entry)
+}
+
+// show prints an index entry.
+func (b *LsFilesBuiltin) show(p *Params, prefix string, entry *api.IndexEntry) {
+	if !b.flagStage {
+		b.showName(p, prefix, entry.Name())
+	} else {
+		fmt.Fprintf(p.Wout, "%06o %s %d\t", entry.Mode(), entry.ObjectId(), entry.Stage())
+		b.showName(p, prefix, entry.Name())
+	}
+	if b.flagDebug {
+		stat := entry.StatInfo()
+		flags := uint32(entry.Flags()&^0xfff) | uint32(entry.ExtendedFlags())<<16
+		fmt.Fprintf(p.Wout, "  ctime: %d:%d\n", uint32(stat.CTimeSecs), uint32(stat.CTimeNanos))
+		fmt.Fprintf(p.Wout, "  mtime: %d:%d\n", uint32(stat.MTimeSecs), uint32(stat.MTimeNanos))
+		fmt.Fprintf(p.Wout, "  dev: %d\tino: %d\n", uint32(stat.Dev), uint32(stat.Ino))
+		fmt.Fprintf(p.Wout, "  uid: %d\tgid: %d\n", uint32(stat.Uid), uint32(stat.Gid))
+		fmt.Fprintf(p.Wout, "  size: %d\tflags: %x\n", uint32(stat.Size), flags)
+	}
+}
+
+// showName prints a path, relative to the prefix, and
+// terminates the line.
+func (b *LsFilesBuiltin) showName(p *Params, prefix, name string) {
+	name = relativePath(prefix, name)
+	if b.flagNulTerm {
+		fmt.Fprint(p.Wout, name, "\000")
+	} else {
+		fmt.Fprint(p.Wout, util.QuotePath(name), "\n")
+	}
+}
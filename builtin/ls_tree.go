@@ -0,0 +1,182 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"github.com/jbrukh/ggit/util"
+	"strings"
+)
+
+// ================================================================= //
+// LS-TREE
+// ================================================================= //
+
+// LsTreeBuiltin implements git-ls-tree, which lists
+// the contents of a tree object.
+type LsTreeBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagRecursive bool
+	flagShowTrees bool
+	flagTreesOnly bool
+	flagLong      bool
+	flagNameOnly  bool
+	flagFullName  bool
+	flagFullTree  bool
+	flagNulTerm   bool
+}
+
+var LsTree = &LsTreeBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "ls-tree",
+		Description: "List the contents of a tree object",
+		UsageLine:   "[-r] [-t] [-d] [-l] [--name-only] [--full-name] [--full-tree] [-z] <tree-ish> [<path>...]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	LsTree.BoolVar(&LsTree.flagRecursive, "r", false, "Recurse into sub-trees.")
+	LsTree.BoolVar(&LsTree.flagShowTrees, "t", false, "Show tree entries even when going to recurse them.")
+	LsTree.BoolVar(&LsTree.flagTreesOnly, "d", false, "Show only the named tree entry itself, not its children.")
+	LsTree.BoolVar(&LsTree.flagLong, "l", false, "Show object size of blob entries.")
+	LsTree.BoolVar(&LsTree.flagLong, "long", false, "Show object size of blob entries.")
+	LsTree.BoolVar(&LsTree.flagNameOnly, "name-only", false, "List only filenames, one per line.")
+	LsTree.BoolVar(&LsTree.flagNameOnly, "name-status", false, "List only filenames, one per line.")
+	LsTree.BoolVar(&LsTree.flagFullName, "full-name", false, "Show full path names instead of relative ones.")
+	LsTree.BoolVar(&LsTree.flagFullTree, "full-tree", false, "Do not limit the listing to the current directory.")
+	LsTree.BoolVar(&LsTree.flagNulTerm, "z", false, "\\0 line termination on output.")
+
+	LsTree.Usage = func() {}
+
+	// add to command list
+	Add(LsTree)
+}
+
+func (b *LsTreeBuiltin) Execute(p *Params, args []string) {
+	if err := b.Parse(args); err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	args = b.Args()
+	if len(args) < 1 {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+
+	o, err := api.LookupRevision(p.Repo, args[0])
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: Not a valid object name %s\n", args[0])
+		p.Status = 128
+		return
+	}
+	tree, err := api.TreeFromObject(p.Repo, o)
+	if err != nil {
+		fmt.Fprintln(p.Werr, "fatal: not a tree object")
+		p.Status = 128
+		return
+	}
+
+	// -d together with -r means we show every tree on the way
+	if b.flagTreesOnly && b.flagRecursive {
+		b.flagShowTrees = true
+	}
+
+	prefix := ""
+	if !b.flagFullTree {
+		prefix = workPrefix(p.Repo)
+	}
+	ps, err := pathspec.Parse(prefix, args[1:], pathspec.PreferCwd|pathspec.AllLiteral)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+		return
+	}
+	if b.flagFullName {
+		prefix = ""
+	}
+
+	err = api.WalkTree(p.Repo, tree, "", func(pth string, e *objects.TreeEntry) error {
+		isTree := e.ObjectType() == objects.ObjectTree
+		if !ps.Match(pth, isTree) && !(isTree && ps.Leads(pth)) {
+			return api.SkipTree
+		}
+		if isTree {
+			if b.showRecursive(ps, pth) {
+				if b.flagShowTrees {
+					return b.show(p, prefix, pth, e)
+				}
+				return nil
+			}
+			if err := b.show(p, prefix, pth, e); err != nil {
+				return err
+			}
+			return api.SkipTree
+		}
+		if b.flagTreesOnly {
+			return nil
+		}
+		return b.show(p, prefix, pth, e)
+	})
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+	}
+}
+
+// show prints a single entry of the listing.
+func (b *LsTreeBuiltin) show(p *Params, prefix, pth string, e *objects.TreeEntry) error {
+	name := relativePath(prefix, pth)
+	term := "\n"
+	if b.flagNulTerm {
+		term = "\000"
+	} else {
+		name = util.QuotePath(name)
+	}
+	if b.flagNameOnly {
+		fmt.Fprint(p.Wout, name, term)
+		return nil
+	}
+	if b.flagLong {
+		size := "-"
+		if e.ObjectType() == objects.ObjectBlob {
+			o, err := p.Repo.ObjectFromOid(e.ObjectId())
+			if err != nil {
+				return err
+			}
+			size = fmt.Sprint(o.Header().Size())
+		}
+		fmt.Fprintf(p.Wout, "%06o %s %s %7s\t%s%s", e.Mode(), e.ObjectType(), e.ObjectId(), size, name, term)
+		return nil
+	}
+	fmt.Fprintf(p.Wout, "%06o %s %s\t%s%s", e.Mode(), e.ObjectType(), e.ObjectId(), name, term)
+	return nil
+}
+
+// showRecursive decides whether we should descend into the
+// tree at the given path, which is the case for recursive
+// listings or when a path names something inside of it.
+func (b *LsTreeBuiltin) showRecursive(ps *pathspec.Pathspec, pth string) bool {
+	if b.flagRecursive {
+		return true
+	}
+	for _, it := range ps.Items() {
+		spec := it.Match()
+		if len(spec) > len(pth) && spec[len(pth)] == '/' && strings.HasPrefix(spec, pth) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,724 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/checkout"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/merge"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// MERGE
+// ================================================================= //
+
+// the ways in which a merge may fast-forward
+const (
+	ffDefault = iota
+	ffAllow
+	ffNo
+	ffOnly
+)
+
+// whether a merge is committed
+const (
+	commitDefault = iota
+	commitYes
+	commitNo
+)
+
+// the files of a merge that is in progress
+const (
+	mergeHeadFile = "MERGE_HEAD"
+	mergeMsgFile  = "MERGE_MSG"
+	mergeModeFile = "MERGE_MODE"
+	autoMergeFile = "AUTO_MERGE"
+	squashMsgFile = "SQUASH_MSG"
+)
+
+// MergeBuiltin implements git-merge, which joins the history of
+// another commit with the current branch, by fast-forwarding to
+// it or by merging its changes and committing them.
+type MergeBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagFF             int
+	flagCommit         int
+	flagSquash         bool
+	flagMessages       stringsFlag
+	flagQuiet          bool
+	flagNoStat         bool
+	flagAbort          bool
+	flagContinue       bool
+	flagAllowUnrelated bool
+}
+
+var Merge = &MergeBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "merge",
+		Description: "Join two development histories together",
+		UsageLine:   "[-n] [--ff | --no-ff | --ff-only] [--squash] [--[no-]commit] [-m <msg>] [<commit>] | --abort | --continue",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Merge.Var(choiceFlag{&Merge.flagFF, ffAllow}, "ff", "Fast-forward when possible.")
+	Merge.Var(choiceFlag{&Merge.flagFF, ffNo}, "no-ff", "Create a merge commit even when fast-forwarding is possible.")
+	Merge.Var(choiceFlag{&Merge.flagFF, ffOnly}, "ff-only", "Refuse to merge unless fast-forwarding is possible.")
+	Merge.Var(choiceFlag{&Merge.flagCommit, commitYes}, "commit", "Commit the merge.")
+	Merge.Var(choiceFlag{&Merge.flagCommit, commitNo}, "no-commit", "Stop before committing the merge.")
+	Merge.BoolVar(&Merge.flagSquash, "squash", false, "Update the index and the working tree, but neither commit nor record the merge.")
+	Merge.Var(&Merge.flagMessages, "m", "A paragraph of the message of the merge commit.")
+	Merge.BoolVar(&Merge.flagQuiet, "q", false, "Be more quiet.")
+	Merge.BoolVar(&Merge.flagQuiet, "quiet", false, "Be more quiet.")
+	Merge.BoolVar(&Merge.flagNoStat, "n", false, "Do not show a diffstat at the end of the merge.")
+	Merge.BoolVar(&Merge.flagNoStat, "no-stat", false, "Do not show a diffstat at the end of the merge.")
+	Merge.BoolVar(&Merge.flagAbort, "abort", false, "Abort the merge that is in progress.")
+	Merge.BoolVar(&Merge.flagContinue, "continue", false, "Commit the merge that is in progress, once its conflicts are resolved.")
+	Merge.BoolVar(&Merge.flagAllowUnrelated, "allow-unrelated-histories", false, "Allow merging histories that have no common ancestor.")
+
+	Merge.Usage = func() {}
+
+	// add to command list
+	Add(Merge)
+}
+
+func (b *MergeBuiltin) Execute(p *Params, args []string) {
+	b.flagFF, b.flagCommit, b.flagSquash, b.flagMessages = ffDefault, commitDefault, false, nil
+	b.flagQuiet, b.flagNoStat, b.flagAbort, b.flagContinue, b.flagAllowUnrelated = false, false, false, false, false
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.merge(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *MergeBuiltin) merge(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b.flagAbort:
+		return b.abort(p, repo, args)
+	case b.flagContinue:
+		return b.resume(p, repo, args)
+	case b.flagSquash && b.flagFF == ffNo:
+		return 0, errors.New("options '--squash' and '--no-ff.' cannot be used together")
+	case b.flagSquash && b.flagCommit == commitYes:
+		return 0, errors.New("options '--squash' and '--commit.' cannot be used together")
+	}
+	if err = b.assertNoMergeInProgress(p, repo); err != nil {
+		return 0, err
+	}
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	if b.flagFF == ffDefault {
+		b.flagFF = ffAllow
+		switch value, _ := config.Get("merge.ff"); value {
+		case "false":
+			b.flagFF = ffNo
+		case "only":
+			b.flagFF = ffOnly
+		}
+	}
+	headRef, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	// with no commit, the branch is merged with its upstream
+	if len(args) == 0 {
+		switch {
+		case headRef == api.HeadRef:
+			return 0, errors.New("No current branch.")
+		case upstreamRef(config, strings.TrimPrefix(headRef, "refs/heads/")) == "":
+			return 0, errors.New("No remote for the current branch.")
+		}
+		args = []string{upstreamRef(config, strings.TrimPrefix(headRef, "refs/heads/"))}
+	}
+	var heads []*objects.Commit
+	for _, arg := range args {
+		c, err := quietCommit(repo, arg)
+		if err != nil {
+			if o, e := api.LookupRevision(repo, arg); e == nil {
+				fmt.Fprintf(p.Werr, "error: %s: expected commit type, but the object dereferences to %s type\n", arg, o.Header().Type())
+			}
+			fmt.Fprintf(p.Werr, "merge: %s - not something we can merge\n", arg)
+			return 1, nil
+		}
+		heads = append(heads, c)
+	}
+
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	action := os.Getenv("GIT_REFLOG_ACTION")
+	if action == "" {
+		action = "merge " + strings.Join(args, " ")
+	}
+	if headOid == nil {
+		return b.mergeIntoUnborn(p, repo, heads, who)
+	}
+	head, err := api.CommitFromOid(repo, headOid)
+	if err != nil {
+		return 0, err
+	}
+
+	// the commits that the branch has already are left out
+	var theirs *objects.Commit
+	var name string
+	for i, c := range heads {
+		merged, err := api.IsAncestor(repo, c, head)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case merged, theirs != nil && theirs.ObjectId().String() == c.ObjectId().String():
+		case theirs != nil:
+			return 0, errors.New("merging more than one commit is not supported")
+		default:
+			theirs, name = c, args[i]
+		}
+	}
+	if err = api.UpdateRef(repo, "ORIG_HEAD", headOid, nil, who, "updating ORIG_HEAD"); err != nil {
+		return 0, err
+	}
+	if theirs == nil {
+		switch {
+		case b.flagQuiet:
+		case b.flagSquash:
+			fmt.Fprintln(p.Wout, "Already up to date. (nothing to squash)")
+		default:
+			fmt.Fprintln(p.Wout, "Already up to date.")
+		}
+		return 0, nil
+	}
+	bases, err := api.MergeBases(repo, head, theirs)
+	if err != nil {
+		return 0, err
+	}
+	if len(bases) == 0 && !b.flagAllowUnrelated {
+		return 0, errors.New("refusing to merge unrelated histories")
+	}
+	if b.flagFF == ffAllow && throwawayTag(repo, name) {
+		b.flagFF = ffNo
+	}
+	switch {
+	case len(bases) == 1 && bases[0].ObjectId().String() == headOid.String() && b.flagFF != ffNo:
+		return b.fastForward(p, repo, config, head, theirs, who, action)
+	case b.flagFF == ffOnly:
+		return 0, errors.New("Not possible to fast-forward, aborting.")
+	}
+	return b.trueMerge(p, repo, config, headRef, head, theirs, name, who, action)
+}
+
+// assertNoMergeInProgress fails if a merge, a cherry-pick or a
+// revert is in progress, or if the index has conflicts.
+func (b *MergeBuiltin) assertNoMergeInProgress(p *Params, repo *api.DiskRepository) error {
+	for _, what := range []struct{ file, action string }{
+		{mergeHeadFile, "merge"},
+		{"CHERRY_PICK_HEAD", "cherry-pick"},
+	} {
+		if _, err := os.Stat(path.Join(repo.Path(), what.file)); err == nil {
+			return fmt.Errorf("You have not concluded your %s (%s exists).\nPlease, commit your changes before you merge.", what.action, what.file)
+		}
+	}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	if unmerged(idx) {
+		fmt.Fprint(p.Werr, strings.Replace(unmergedFiles, "Committing", "Merging", 1))
+		return errors.New("Exiting because of an unresolved conflict.")
+	}
+	return nil
+}
+
+// throwawayTag returns true if a revision names an annotated tag
+// that isn't the one of refs/tags/ by its name, which is merged
+// with a merge commit rather than fast-forwarded to.
+func throwawayTag(repo *api.DiskRepository, rev string) bool {
+	o, err := api.LookupRevision(repo, rev)
+	if err != nil {
+		return false
+	}
+	tag, ok := o.(*objects.Tag)
+	if !ok {
+		return false
+	}
+	ref, err := api.LookupRevision(repo, "refs/tags/"+tag.Name())
+	return err != nil || ref.ObjectId().String() != tag.ObjectId().String()
+}
+
+// ================================================================= //
+// FAST-FORWARDS
+// ================================================================= //
+
+// mergeIntoUnborn merges a commit into a branch that is yet to be
+// born, which starts at the commit.
+func (b *MergeBuiltin) mergeIntoUnborn(p *Params, repo *api.DiskRepository, heads []*objects.Commit, who *objects.WhoWhen) (int, error) {
+	switch {
+	case b.flagSquash:
+		return 0, errors.New("Squash commit into empty head not supported yet")
+	case len(heads) != 1:
+		return 0, errors.New("Can merge only exactly one commit into empty head")
+	}
+	if status, err := b.checkoutTree(p, repo, nil, heads[0]); status != 0 || err != nil {
+		return status, err
+	}
+	return 0, api.UpdateRef(repo, api.HeadRef, heads[0].ObjectId(), nil, who, "initial pull")
+}
+
+// fastForward moves the branch to a commit that has it in its
+// history, or only checks the commit out if the merge is squashed.
+func (b *MergeBuiltin) fastForward(p *Params, repo *api.DiskRepository, config *api.Config, head, theirs *objects.Commit, who *objects.WhoWhen, action string) (int, error) {
+	if !b.flagQuiet {
+		fmt.Fprintf(p.Wout, "Updating %s..%s\n", abbrev(head.ObjectId()), abbrev(theirs.ObjectId()))
+	}
+	if status, err := b.checkoutTree(p, repo, head, theirs); status != 0 || err != nil {
+		return status, err
+	}
+	if !b.flagQuiet {
+		fmt.Fprintln(p.Wout, "Fast-forward")
+	}
+	if b.flagSquash {
+		if err := b.squash(p, repo, head, theirs); err != nil {
+			return 0, err
+		}
+	} else if err := api.UpdateRef(repo, api.HeadRef, theirs.ObjectId(), head.ObjectId(), who, action+": Fast-forward"); err != nil {
+		return 0, err
+	}
+	return 0, b.printDiffstat(p, repo, config, head, theirs)
+}
+
+// checkoutTree checks out the tree of a commit over the one of
+// HEAD, which may be nil, unless that would lose changes.
+func (b *MergeBuiltin) checkoutTree(p *Params, repo *api.DiskRepository, head, c *objects.Commit) (int, error) {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+	var from *objects.Tree
+	if head != nil {
+		if from, err = api.TreeFromOid(repo, head.Tree()); err != nil {
+			return 0, err
+		}
+	}
+	to, err := api.TreeFromOid(repo, c.Tree())
+	if err != nil {
+		return 0, err
+	}
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return 0, err
+	}
+	err = checkout.SwitchTrees(repo, idx, from, to, &checkout.Options{Ignorer: ig, Action: "merge"})
+	if e, ok := err.(*checkout.Error); ok {
+		printCheckoutError(p, e)
+		return 1, nil
+	} else if err != nil {
+		return 0, err
+	}
+	err, lock = repo.WriteIndex(lock, idx), nil
+	return 0, err
+}
+
+// printCheckoutError explains why a tree can't be checked out.
+func printCheckoutError(p *Params, e *checkout.Error) {
+	for _, msg := range e.Messages() {
+		fmt.Fprintf(p.Werr, "error: %s\n", msg)
+	}
+	fmt.Fprintln(p.Werr, "Aborting")
+}
+
+// ================================================================= //
+// TRUE MERGES
+// ================================================================= //
+
+// trueMerge merges the changes of a commit into the index and the
+// working tree, and commits the merge unless it conflicts, or is
+// not to be committed.
+func (b *MergeBuiltin) trueMerge(p *Params, repo *api.DiskRepository, config *api.Config, headRef string, head, theirs *objects.Commit, name string, who *objects.WhoWhen, action string) (int, error) {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+	headTree, err := api.TreeFromOid(repo, head.Tree())
+	if err != nil {
+		return 0, err
+	}
+
+	// the index may not have changes of its own
+	td, err := diff.DiffTreeIndex(repo, headTree, idx, new(diff.Options))
+	if err != nil {
+		return 0, err
+	}
+	if edits := td.Edits(); len(edits) > 0 {
+		var names []string
+		for _, edit := range edits {
+			names = append(names, edit.Path())
+		}
+		fmt.Fprintf(p.Werr, "error: Your local changes to the following files would be overwritten by merge:\n  %s\n", strings.Join(names, " "))
+		fmt.Fprintln(p.Werr, "Merge with strategy ort failed.")
+		return 2, nil
+	}
+
+	opts, err := mergeOptions(config)
+	if err != nil {
+		return 0, err
+	}
+	opts.Ours, opts.Theirs = "HEAD", name
+	r, err := merge.MergeCommits(repo, head, theirs, opts)
+	if err != nil {
+		return 0, err
+	}
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return 0, err
+	}
+	err = checkout.SwitchTrees(repo, idx, headTree, r.Tree, &checkout.Options{Ignorer: ig, Action: "merge"})
+	if e, ok := err.(*checkout.Error); ok {
+		printCheckoutError(p, e)
+		fmt.Fprintln(p.Werr, "Merge with strategy ort failed.")
+		return 2, nil
+	} else if err != nil {
+		return 0, err
+	}
+	for _, e := range r.Unmerged {
+		idx.Add(e)
+	}
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return 0, err
+	}
+	for _, m := range r.Messages {
+		fmt.Fprintln(p.Wout, m.Text)
+	}
+
+	msg, err := b.message(repo, headRef, name)
+	if err != nil {
+		return 0, err
+	}
+	if r.Clean && !b.flagSquash && b.flagCommit != commitNo {
+		author, err := identity(p.Werr, config, api.RoleAuthor)
+		if err != nil {
+			return 0, err
+		}
+		c, err := api.WriteCommit(repo, r.Tree.ObjectId(), []*objects.ObjectId{head.ObjectId(), theirs.ObjectId()}, author, who, msg)
+		if err != nil {
+			return 0, err
+		}
+		strategy := "Merge made by the 'ort' strategy."
+		if !b.flagQuiet {
+			fmt.Fprintln(p.Wout, strategy)
+		}
+		if err = api.UpdateRef(repo, api.HeadRef, c.ObjectId(), head.ObjectId(), who, action+": "+strategy); err != nil {
+			return 0, err
+		}
+		return 0, b.printDiffstat(p, repo, config, head, c)
+	}
+
+	// the merge stops before it is committed
+	if err = writeStateFile(repo, autoMergeFile, r.Tree.ObjectId().String()+"\n"); err != nil {
+		return 0, err
+	}
+	if b.flagSquash {
+		msg = ""
+		if err = b.squash(p, repo, head, theirs); err != nil {
+			return 0, err
+		}
+	} else {
+		mode := ""
+		if b.flagFF == ffNo {
+			mode = "no-ff"
+		}
+		if err = writeStateFile(repo, mergeHeadFile, theirs.ObjectId().String()+"\n"); err != nil {
+			return 0, err
+		}
+		if err = writeStateFile(repo, mergeModeFile, mode); err != nil {
+			return 0, err
+		}
+	}
+	if !r.Clean {
+		msg += "\n# Conflicts:\n"
+		for i, e := range r.Unmerged {
+			if i == 0 || r.Unmerged[i-1].Name() != e.Name() {
+				msg += "#\t" + e.Name() + "\n"
+			}
+		}
+	}
+	if msg != "" || !b.flagSquash {
+		if err = writeStateFile(repo, mergeMsgFile, msg); err != nil {
+			return 0, err
+		}
+	}
+	if !r.Clean {
+		fmt.Fprintln(p.Wout, "Automatic merge failed; fix conflicts and then commit the result.")
+		return 1, nil
+	}
+	fmt.Fprintln(p.Werr, "Automatic merge went well; stopped before committing as requested")
+	return 0, nil
+}
+
+// mergeOptions returns the options of merges of trees, from the
+// configuration.
+func mergeOptions(config *api.Config) (*merge.Options, error) {
+	opts := new(merge.Options)
+	renames, err := config.Bool("diff.renames", true)
+	if err != nil {
+		return nil, err
+	}
+	if renames, err = config.Bool("merge.renames", renames); err != nil {
+		return nil, err
+	}
+	if renames {
+		opts.Renames = merge.NewRenameOptions()
+		if opts.Renames.Limit, err = config.Int("merge.renameLimit", merge.DefaultRenameLimit); err != nil {
+			return nil, err
+		}
+	}
+	if value, ok := config.Get("merge.conflictStyle"); ok {
+		if opts.Style, err = diff.ParseConflictStyle(value); err != nil {
+			return nil, fmt.Errorf("unknown style '%s' given for 'merge.conflictstyle'", value)
+		}
+	}
+	return opts, nil
+}
+
+// message returns the message of the merge commit, which is given
+// by -m, or else describes what is merged, as in "Merge branch
+// 'topic'", and into which branch, unless it is master or main.
+func (b *MergeBuiltin) message(repo *api.DiskRepository, headRef, name string) (string, error) {
+	if len(b.flagMessages) > 0 {
+		return strings.Join(b.flagMessages, "\n\n") + "\n", nil
+	}
+	msg := "Merge " + describeMerged(repo, name)
+	switch branch := strings.TrimPrefix(headRef, "refs/heads/"); branch {
+	case "master", "main":
+	default:
+		msg += " into " + branch
+	}
+	msg += "\n"
+
+	// the message of an annotated tag is kept
+	if o, err := api.LookupRevision(repo, name); err == nil {
+		if tag, ok := o.(*objects.Tag); ok {
+			msg += "\n" + tag.Message()
+		}
+	}
+	return msg, nil
+}
+
+// describeMerged describes a revision that is merged, as in
+// "branch 'topic'", "tag 'v1.0'" or "commit '44410c2'".
+func describeMerged(repo *api.DiskRepository, name string) string {
+	trimmed := strings.TrimSuffix(strings.TrimSuffix(name, "^0"), "~0")
+	for _, kind := range []struct{ prefix, desc string }{
+		{"refs/heads/", "branch"},
+		{"refs/tags/", "tag"},
+		{"refs/remotes/", "remote-tracking branch"},
+	} {
+		for _, full := range []string{trimmed, "refs/" + trimmed, kind.prefix + trimmed} {
+			if !strings.HasPrefix(full, kind.prefix) {
+				continue
+			}
+			if _, err := api.LookupRevision(repo, full); err == nil {
+				return fmt.Sprintf("%s '%s'", kind.desc, trimmed)
+			}
+		}
+	}
+	return fmt.Sprintf("commit '%s'", name)
+}
+
+// ================================================================= //
+// SQUASHES
+// ================================================================= //
+
+// squash explains that HEAD is left alone, and writes the message
+// of the commit that squashes the commits that are merged.
+func (b *MergeBuiltin) squash(p *Params, repo *api.DiskRepository, head, theirs *objects.Commit) error {
+	fmt.Fprintln(p.Wout, "Squash commit -- not updating HEAD")
+	mine := make(map[string]bool)
+	err := api.WalkCommits(repo, []*objects.Commit{head}, func(c *objects.Commit) error {
+		mine[c.ObjectId().String()] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	msg := "Squashed commit of the following:\n"
+	err = api.WalkCommits(repo, []*objects.Commit{theirs}, func(c *objects.Commit) error {
+		if mine[c.ObjectId().String()] {
+			return nil
+		}
+		msg += "\ncommit " + c.ObjectId().String() + "\n"
+		if parents := c.Parents(); len(parents) > 1 {
+			var abbrevs []string
+			for _, parent := range parents {
+				abbrevs = append(abbrevs, abbrev(parent))
+			}
+			msg += "Merge: " + strings.Join(abbrevs, " ") + "\n"
+		}
+		author := c.Author()
+		msg += fmt.Sprintf("Author: %s <%s>\n", author.Name(), author.Email())
+		msg += "Date:   " + author.Time().Format("Mon Jan 2 15:04:05 2006 -0700") + "\n\n"
+		for _, line := range strings.Split(strings.TrimSuffix(c.Message(), "\n"), "\n") {
+			if line != "" {
+				line = "    " + line
+			}
+			msg += line + "\n"
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeStateFile(repo, squashMsgFile, msg)
+}
+
+// ================================================================= //
+// MERGES IN PROGRESS
+// ================================================================= //
+
+// abort gives up on the merge that is in progress, as git-reset
+// --merge does.
+func (b *MergeBuiltin) abort(p *Params, repo *api.DiskRepository, args []string) (int, error) {
+	if len(args) > 0 {
+		fmt.Fprintln(p.Werr, "fatal: --abort expects no arguments")
+		b.WriteUsage(p.Werr)
+		return 129, nil
+	}
+	if _, err := os.Stat(path.Join(repo.Path(), mergeHeadFile)); err != nil {
+		return 0, errors.New("There is no merge to abort (MERGE_HEAD missing).")
+	}
+	Reset.Execute(p, []string{"--merge"})
+	return p.Status, nil
+}
+
+// resume commits the merge that is in progress, once its conflicts
+// are resolved.
+func (b *MergeBuiltin) resume(p *Params, repo *api.DiskRepository, args []string) (int, error) {
+	if len(args) > 0 {
+		fmt.Fprintln(p.Werr, "fatal: --continue expects no arguments")
+		b.WriteUsage(p.Werr)
+		return 129, nil
+	}
+	if _, err := os.Stat(path.Join(repo.Path(), mergeHeadFile)); err != nil {
+		return 0, errors.New("There is no merge in progress (MERGE_HEAD missing).")
+	}
+	Commit.Execute(p, nil)
+	return p.Status, nil
+}
+
+// mergeHeads returns the commits that the merge in progress merges,
+// or nil if no merge is in progress.
+func mergeHeads(repo *api.DiskRepository) ([]*objects.ObjectId, error) {
+	data, err := ioutil.ReadFile(path.Join(repo.Path(), mergeHeadFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var oids []*objects.ObjectId
+	for _, line := range strings.Fields(string(data)) {
+		oid, err := objects.OidFromString(line)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse MERGE_HEAD")
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
+
+// writeStateFile writes a file that keeps the state of a merge, a
+// cherry-pick or a revert that is in progress.
+func writeStateFile(repo *api.DiskRepository, name, contents string) error {
+	return ioutil.WriteFile(path.Join(repo.Path(), name), []byte(contents), 0666)
+}
+
+// ================================================================= //
+// DIFFSTATS
+// ================================================================= //
+
+// printDiffstat shows what changed from one commit to another, in
+// a diffstat and a summary, unless the merge is quiet.
+func (b *MergeBuiltin) printDiffstat(p *Params, repo *api.DiskRepository, config *api.Config, from, to *objects.Commit) error {
+	if b.flagQuiet || b.flagNoStat {
+		return nil
+	}
+	before, err := api.TreeFromOid(repo, from.Tree())
+	if err != nil {
+		return err
+	}
+	after, err := api.TreeFromOid(repo, to.Tree())
+	if err != nil {
+		return err
+	}
+	opts := new(diff.Options)
+	if renames, err := config.Bool("diff.renames", true); err != nil {
+		return err
+	} else if renames {
+		opts.Renames = diff.NewRenameOptions()
+	}
+	td, err := diff.DiffTrees(repo, before, after, opts)
+	if err != nil {
+		return err
+	}
+	attrs, err := api.NewAttributes(repo)
+	if err != nil {
+		return err
+	}
+	source := diff.RepoSource(repo)
+	ds, err := diff.NewDiffStat(td, source, source, diff.Myers, diff.NewBinaryDetector(attrs, config))
+	if err != nil {
+		return err
+	}
+	if err = ds.WriteStat(p.Wout, diff.StatOptions{Width: util.TermColumns()}); err != nil {
+		return err
+	}
+	return diff.WriteSummary(p.Wout, td)
+}
@@ -0,0 +1,204 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"io"
+)
+
+// ================================================================= //
+// MERGE-BASE
+// ================================================================= //
+
+// MergeBaseBuiltin implements git-merge-base, which finds the best
+// common ancestors of commits. Its exit status tells whether any
+// were found, for use in conditionals.
+type MergeBaseBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagAll  bool
+	flagMode string
+}
+
+var MergeBase = &MergeBaseBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "merge-base",
+		Description: "Find as good common ancestors as possible for a merge",
+		UsageLine:   "[-a | --all] [--octopus | --independent | --is-ancestor | --fork-point] <commit>...",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	MergeBase.BoolVar(&MergeBase.flagAll, "a", false, "Output all merge bases instead of the first.")
+	MergeBase.BoolVar(&MergeBase.flagAll, "all", false, "Synonym of -a.")
+	for _, mode := range []string{"octopus", "independent", "is-ancestor", "fork-point"} {
+		MergeBase.Var(modeFlag{&MergeBase.flagMode, mode}, mode, "")
+	}
+	MergeBase.Lookup("octopus").Usage = "Find the merge bases of all of the commits together, for an octopus merge."
+	MergeBase.Lookup("independent").Usage = "List the commits that aren't reachable from any of the others."
+	MergeBase.Lookup("is-ancestor").Usage = "Exit with 0 if the first commit is an ancestor of the second, and with 1 otherwise."
+	MergeBase.Lookup("fork-point").Usage = "Find where <commit>, HEAD by default, forked from the history of <ref>, using its reflog."
+
+	MergeBase.Usage = func() {}
+
+	// add to command list
+	Add(MergeBase)
+}
+
+func (b *MergeBaseBuiltin) Execute(p *Params, args []string) {
+	b.flagAll, b.flagMode = false, ""
+	if err := b.Parse(args); err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	args = b.Args()
+	if (b.flagMode == "is-ancestor" && len(args) < 2) ||
+		(b.flagMode == "fork-point" && (len(args) < 1 || len(args) > 2)) ||
+		(b.flagMode == "" && len(args) < 2) {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+
+	var (
+		status int
+		err    error
+	)
+	switch {
+	case b.flagAll && (b.flagMode == "is-ancestor" || b.flagMode == "independent"):
+		err = fmt.Errorf("options '--%s' and '--all' cannot be used together", b.flagMode)
+	case b.flagMode == "is-ancestor":
+		status, err = b.isAncestor(p, args)
+	case b.flagMode == "fork-point":
+		status, err = b.forkPoint(p, args)
+	default:
+		status, err = b.mergeBases(p, args)
+	}
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+// mergeBases prints the merge bases of the commits, as the mode
+// computes them, and returns 1 if there are none.
+func (b *MergeBaseBuiltin) mergeBases(p *Params, args []string) (int, error) {
+	commits := make([]*objects.Commit, len(args))
+	for i, arg := range args {
+		c, err := commitReference(p.Werr, p.Repo, arg)
+		if err != nil {
+			return 0, err
+		}
+		commits[i] = c
+	}
+
+	var (
+		bases []*objects.Commit
+		err   error
+	)
+	switch b.flagMode {
+	case "octopus":
+		bases, err = api.OctopusMergeBases(p.Repo, commits)
+	case "independent":
+		// independent commits are all printed
+		b.flagAll = true
+		bases, err = api.IndependentCommits(p.Repo, commits)
+	default:
+		bases, err = api.MergeBases(p.Repo, commits[0], commits[1:]...)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(bases) == 0 {
+		return 1, nil
+	}
+	if !b.flagAll {
+		bases = bases[:1]
+	}
+	for _, c := range bases {
+		fmt.Fprintln(p.Wout, c.ObjectId())
+	}
+	return 0, nil
+}
+
+// isAncestor returns 0 if the first commit is an ancestor of the
+// second, and 1 otherwise.
+func (b *MergeBaseBuiltin) isAncestor(p *Params, args []string) (int, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("--is-ancestor takes exactly two commits")
+	}
+	var commits [2]*objects.Commit
+	for i, arg := range args {
+		c, err := commitReference(p.Werr, p.Repo, arg)
+		if err != nil {
+			return 0, err
+		}
+		commits[i] = c
+	}
+	ok, err := api.IsAncestor(p.Repo, commits[0], commits[1])
+	if err != nil || !ok {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// forkPoint prints the point at which a commit forked from a ref,
+// and returns 1 if there is none.
+func (b *MergeBaseBuiltin) forkPoint(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	name := "HEAD"
+	if len(args) == 2 {
+		name = args[1]
+	}
+	o, err := api.LookupRevision(repo, name)
+	if err != nil {
+		return 0, fmt.Errorf("Not a valid object name: '%s'", name)
+	}
+	c, err := api.CommitFromObject(repo, o)
+	if err != nil {
+		return 0, fmt.Errorf("Not a valid commit name %s", name)
+	}
+
+	fork, err := api.ForkPoint(repo, args[0], c)
+	if api.IsNoSuchRef(err) {
+		return 0, fmt.Errorf("No such ref: '%s'", args[0])
+	} else if err != nil {
+		return 0, err
+	}
+	if fork == nil {
+		return 1, nil
+	}
+	fmt.Fprintln(p.Wout, fork.ObjectId())
+	return 0, nil
+}
+
+// commitReference resolves a revision to a commit, peeling tags.
+func commitReference(w io.Writer, repo api.Repository, rev string) (*objects.Commit, error) {
+	o, err := api.LookupRevision(repo, rev)
+	if err != nil {
+		return nil, fmt.Errorf("Not a valid object name %s", rev)
+	}
+	c, err := api.CommitFromObject(repo, o)
+	if err != nil {
+		if peeled, e := api.PeelObject(repo, o); e == nil {
+			fmt.Fprintf(w, "error: object %s is a %s, not a commit\n", peeled.ObjectId(), peeled.Header().Type())
+		}
+		return nil, fmt.Errorf("Not a valid commit name %s", rev)
+	}
+	return c, nil
+}
@@ -0,0 +1,165 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+	"io"
+	"io/ioutil"
+	"regexp"
+)
+
+// ================================================================= //
+// MERGE-FILE
+// ================================================================= //
+
+// MergeFileBuiltin implements git-merge-file, which merges the
+// changes that two files made to a common base into the first.
+type MergeFileBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagStdout     bool
+	flagStyle      int
+	flagFavor      int
+	flagMarkerSize int
+	flagQuiet      bool
+	flagLabels     stringsFlag
+}
+
+var MergeFile = &MergeFileBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "merge-file",
+		Description: "Run a three-way file merge",
+		UsageLine:   "[-p | --stdout] [--diff3 | --zdiff3] [--ours | --theirs | --union] [--marker-size=<n>] [-q] [-L <name1> [-L <orig> [-L <name2>]]] <file1> <orig-file> <file2>",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	MergeFile.BoolVar(&MergeFile.flagStdout, "p", false, "Send the results to standard output instead of overwriting <file1>.")
+	MergeFile.BoolVar(&MergeFile.flagStdout, "stdout", false, "Synonym of -p.")
+	MergeFile.Var(choiceFlag{&MergeFile.flagStyle, int(diff.StyleDiff3)}, "diff3", "Show the base of conflicts as well.")
+	MergeFile.Var(choiceFlag{&MergeFile.flagStyle, int(diff.StyleZealousDiff3)}, "zdiff3", "Show the base of conflicts, leaving the lines that both sides share out of them.")
+	MergeFile.Var(choiceFlag{&MergeFile.flagFavor, int(diff.FavorOurs)}, "ours", "Resolve conflicts with our side.")
+	MergeFile.Var(choiceFlag{&MergeFile.flagFavor, int(diff.FavorTheirs)}, "theirs", "Resolve conflicts with their side.")
+	MergeFile.Var(choiceFlag{&MergeFile.flagFavor, int(diff.FavorUnion)}, "union", "Resolve conflicts with both sides.")
+	MergeFile.IntVar(&MergeFile.flagMarkerSize, "marker-size", 0, "The length of conflict markers.")
+	MergeFile.BoolVar(&MergeFile.flagQuiet, "q", false, "Don't warn about conflicts.")
+	MergeFile.BoolVar(&MergeFile.flagQuiet, "quiet", false, "Synonym of -q.")
+	MergeFile.Var(&MergeFile.flagLabels, "L", "The labels of <file1>, <orig-file> and <file2>, in that order.")
+
+	MergeFile.Usage = func() {}
+
+	// add to command list
+	Add(MergeFile)
+}
+
+// matches the stuck form of -L, as in -Lname
+var mergeFileStuckLabel = regexp.MustCompile(`^-L(.+)$`)
+
+func (b *MergeFileBuiltin) Execute(p *Params, args []string) {
+	for i, arg := range args {
+		if m := mergeFileStuckLabel.FindStringSubmatch(arg); m != nil && m[1][0] != '=' {
+			args[i] = "-L=" + m[1]
+		}
+	}
+	b.flagStdout, b.flagStyle, b.flagFavor = false, -1, int(diff.FavorNone)
+	b.flagMarkerSize, b.flagQuiet, b.flagLabels = 0, false, nil
+	if err := b.Parse(args); err != nil || b.NArg() != 3 {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if len(b.flagLabels) > 3 {
+		fmt.Fprintln(p.Werr, "error: too many labels on the command line")
+		p.Status = 129
+		return
+	}
+
+	// quiet silences errors as well, as in git
+	werr := p.Werr
+	if b.flagQuiet {
+		werr = ioutil.Discard
+	}
+	opts, err := b.options(p)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+		return
+	}
+	conflicts, err := b.merge(p.Wout, b.Args(), opts)
+	if err != nil {
+		fmt.Fprintf(werr, "error: %s\n", err)
+		p.Status = 255
+		return
+	}
+	if conflicts > 127 {
+		conflicts = 127
+	}
+	p.Status = conflicts
+}
+
+// options returns the options of the merge, whose conflict style
+// is configured unless it is given.
+func (b *MergeFileBuiltin) options(p *Params) (opts diff.MergeOptions, err error) {
+	opts = diff.MergeOptions{
+		MarkerSize: b.flagMarkerSize,
+		Level:      diff.MergeZealousAlnum,
+		Style:      diff.ConflictStyle(b.flagStyle),
+		Favor:      diff.MergeFavor(b.flagFavor),
+	}
+	if b.flagStyle >= 0 {
+		return opts, nil
+	}
+	opts.Style = diff.StyleMerge
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return opts, err
+	}
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return opts, err
+	}
+	if value, ok := config.Get("merge.conflictStyle"); ok {
+		if opts.Style, err = diff.ParseConflictStyle(value); err != nil {
+			return opts, fmt.Errorf("unknown style '%s' given for 'merge.conflictstyle'", value)
+		}
+	}
+	return opts, nil
+}
+
+// merge merges the files and writes the result to the first one, or
+// to w. It returns the number of conflicts.
+func (b *MergeFileBuiltin) merge(w io.Writer, files []string, opts diff.MergeOptions) (int, error) {
+	var data [3][]byte
+	for i, file := range files {
+		var err error
+		if data[i], err = ioutil.ReadFile(file); err != nil {
+			return 0, fmt.Errorf("Could not stat %s: %s", file, describeError(err))
+		}
+		if diff.IsBinary(data[i]) {
+			return 0, fmt.Errorf("Cannot merge binary files: %s", file)
+		}
+	}
+	labels := append([]string(nil), files...)
+	copy(labels, b.flagLabels)
+	opts.Ours, opts.Base, opts.Theirs = labels[0], labels[1], labels[2]
+
+	merged, conflicts := diff.Merge(data[1], data[0], data[2], opts)
+	if b.flagStdout {
+		_, err := w.Write(merged)
+		return conflicts, err
+	}
+	if err := ioutil.WriteFile(files[0], merged, 0666); err != nil {
+		return 0, fmt.Errorf("Could not write to %s: %s", files[0], describeError(err))
+	}
+	return conflicts, nil
+}
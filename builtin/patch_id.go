@@ -0,0 +1,102 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/diff"
+)
+
+// ================================================================= //
+// PATCH-ID
+// ================================================================= //
+
+// PatchIdBuiltin implements git-patch-id, which reads patches from
+// the standard input and prints their patch ids, each with the commit
+// that its patch is of, so that the same changes can be found
+// wherever they were made.
+type PatchIdBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagMode string
+}
+
+var PatchId = &PatchIdBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "patch-id",
+		Description: "Compute unique ID for a patch",
+		UsageLine:   "[--stable | --unstable | --verbatim]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	for _, mode := range []string{"stable", "unstable", "verbatim"} {
+		PatchId.Var(modeFlag{&PatchId.flagMode, mode}, mode, "")
+	}
+	PatchId.Lookup("stable").Usage = "Use the patch id that doesn't depend on the order of the files of the patch."
+	PatchId.Lookup("unstable").Usage = "Use the patch id that hashes the patch as a whole."
+	PatchId.Lookup("verbatim").Usage = "Don't strip the whitespace of the patch; implies --stable."
+
+	PatchId.Usage = func() {}
+
+	// add to command list
+	Add(PatchId)
+}
+
+func (b *PatchIdBuiltin) Execute(p *Params, args []string) {
+	b.flagMode = ""
+	if err := b.Parse(args); err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if err := b.patchIds(p); err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+	}
+}
+
+// patchIds prints the patch ids of the patches of the standard input,
+// which are stable if patchid.stable or patchid.verbatim say so,
+// unless the mode says otherwise.
+func (b *PatchIdBuiltin) patchIds(p *Params) error {
+	opts := new(diff.PatchIdOptions)
+	switch b.flagMode {
+	case "stable":
+		opts.Stable = true
+	case "verbatim":
+		opts.Stable, opts.Verbatim = true, true
+	case "":
+		repo, err := api.AssertDiskRepo(p.Repo)
+		if err != nil {
+			return err
+		}
+		config, err := api.ReadConfig(repo)
+		if err != nil {
+			return err
+		}
+		if opts.Stable, err = config.Bool("patchid.stable", false); err != nil {
+			return err
+		}
+		if opts.Verbatim, err = config.Bool("patchid.verbatim", false); err != nil {
+			return err
+		}
+		opts.Stable = opts.Stable || opts.Verbatim
+	}
+	ids, err := diff.ReadPatchIds(p.Rin, opts)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fmt.Fprintf(p.Wout, "%s %s\n", id.PatchId, id.Commit)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+paths.go implements helpers for dealing with paths in the working tree
+and for displaying them the way git does.
+*/
+package builtin
+
+import (
+	"github.com/jbrukh/ggit/api"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ================================================================= //
+// PATH UTILITIES
+// ================================================================= //
+
+// workPrefix returns the path of the current directory relative
+// to the top of the working tree, with a trailing slash, or the
+// empty string if we are at the top (or the repository has no
+// working tree on disk).
+func workPrefix(repo api.Repository) string {
+	diskRepo, err := api.AssertDiskRepo(repo)
+	if err != nil {
+		return ""
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(diskRepo.WorkDir(), cwd)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel) + "/"
+}
+
+// relativePath returns the path as it should be displayed to
+// a user whose current directory is the given prefix, which is
+// "./" itself.
+func relativePath(prefix, pth string) string {
+	if prefix == "" {
+		return pth
+	}
+	if strings.HasPrefix(pth, prefix) {
+		return pth[len(prefix):]
+	}
+	if pth+"/" == prefix {
+		return "./"
+	}
+	rel, err := filepath.Rel(prefix, pth)
+	if err != nil {
+		return pth
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,1061 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/checkout"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/merge"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/sequencer"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// REBASE
+// ================================================================= //
+
+// the file that names the commit that stopped a rebase
+const rebaseHeadFile = "REBASE_HEAD"
+
+// RebaseBuiltin implements git-rebase, which replays the commits of
+// a branch that its upstream doesn't have on top of the upstream, or
+// of another commit, as git-rebase does with its merge backend. The
+// state of the rebase is kept as git keeps it, so that either of them
+// can carry on with a rebase that the other one stopped.
+type RebaseBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagOnto       string
+	flagExec       stringsFlag
+	flagAutosquash int
+	flagQuiet      bool
+	flagAction     string
+}
+
+var Rebase = &RebaseBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "rebase",
+		Description: "Reapply commits on top of another base tip",
+		UsageLine:   "[-q] [--onto <newbase>] [-x <cmd>] [--[no-]autosquash] [<upstream> [<branch>]] | (--continue | --skip | --abort | --quit)",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Rebase.StringVar(&Rebase.flagOnto, "onto", "", "The commit to replay the commits onto, instead of the upstream.")
+	Rebase.Var(&Rebase.flagExec, "x", "A shell command to run after every commit.")
+	Rebase.Var(&Rebase.flagExec, "exec", "A shell command to run after every commit.")
+	Rebase.Var(choiceFlag{&Rebase.flagAutosquash, 1}, "autosquash", "Move the commits that are fixups or squashes of others after them.")
+	Rebase.Var(choiceFlag{&Rebase.flagAutosquash, 0}, "no-autosquash", "Keep fixups and squashes where they are.")
+	Rebase.BoolVar(&Rebase.flagQuiet, "q", false, "Be quiet.")
+	Rebase.BoolVar(&Rebase.flagQuiet, "quiet", false, "Be quiet.")
+	for _, name := range []string{"continue", "skip", "abort", "quit"} {
+		Rebase.Var(modeFlag{&Rebase.flagAction, name}, name, "")
+	}
+	Rebase.Lookup("continue").Usage = "Continue after the conflicts of a commit are resolved."
+	Rebase.Lookup("skip").Usage = "Skip the commit that stopped the rebase, and continue with the rest."
+	Rebase.Lookup("abort").Usage = "Give up, and go back to the branch as it was before."
+	Rebase.Lookup("quit").Usage = "Forget about the rebase, leaving HEAD where it is."
+
+	Rebase.Usage = func() {}
+
+	// add to command list
+	Add(Rebase)
+}
+
+func (b *RebaseBuiltin) Execute(p *Params, args []string) {
+	b.flagOnto, b.flagExec, b.flagAutosquash, b.flagQuiet, b.flagAction = "", nil, 0, false, ""
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil || len(args) > 2 || len(args) > 0 && b.flagAction != "" {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.rebase(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+// the complaint about a rebase that is started while another one is
+// in progress
+const rebaseInProgress = `It seems that there is already a rebase-merge directory, and
+I wonder if you are in the middle of another rebase.  If that is the
+case, please try
+	git rebase (--continue | --abort | --skip)
+If that is not the case, please
+	rm -fr ".git/rebase-merge"
+and run me again.  I am stopping in case you still have something
+valuable there.
+`
+
+func (b *RebaseBuiltin) rebase(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	inProgress := sequencer.RebaseInProgress(repo)
+	switch {
+	case b.flagAction != "" && !inProgress:
+		return 0, fmt.Errorf("No rebase in progress?")
+	case b.flagAction == "continue":
+		return b.resume(p, repo)
+	case b.flagAction == "skip":
+		return b.skip(p, repo)
+	case b.flagAction == "abort":
+		return b.abort(p, repo)
+	case b.flagAction == "quit":
+		return 0, sequencer.RemoveRebase(repo)
+	case inProgress:
+		return 0, fmt.Errorf("%s", rebaseInProgress)
+	}
+	return b.start(p, repo, args)
+}
+
+// ================================================================= //
+// STARTING
+// ================================================================= //
+
+// start rebases a branch, which is HEAD unless it is named, onto its
+// upstream, which is the one that it tracks unless it is named, or
+// onto the commit given by --onto.
+func (b *RebaseBuiltin) start(p *Params, repo *api.DiskRepository, args []string) (int, error) {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	headRef, _, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	// the upstream
+	var upstreamName string
+	forkPoint := false
+	switch {
+	case len(args) > 0 && args[0] == "-":
+		upstreamName = "@{-1}"
+	case len(args) > 0:
+		upstreamName = args[0]
+	case headRef == api.HeadRef:
+		fmt.Fprint(p.Wout, "You are not currently on a branch.\n"+noUpstream)
+		return 1, nil
+	default:
+		short := strings.TrimPrefix(headRef, "refs/heads/")
+		if upstreamName = upstreamRef(config, short); upstreamName == "" {
+			fmt.Fprint(p.Wout, "There is no tracking information for the current branch.\n"+noUpstream)
+			fmt.Fprintln(p.Wout, "If you wish to set tracking information for this branch you can do so with:")
+			fmt.Fprintf(p.Wout, "\n    git branch --set-upstream-to=<remote>/<branch> %s\n\n", short)
+			return 1, nil
+		}
+		forkPoint = true
+	}
+	rev := upstreamName
+	if rev == "@{-1}" {
+		rev = previousCheckout(repo)
+	}
+	upstream, err := quietCommit(repo, rev)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upstream '%s'", upstreamName)
+	}
+
+	// the commit to rebase onto
+	onto, ontoName := upstream, upstreamName
+	if b.flagOnto != "" {
+		ontoName = b.flagOnto
+		if onto, err = quietCommit(repo, b.flagOnto); err != nil {
+			return 0, fmt.Errorf("Does not point to a valid commit '%s'", b.flagOnto)
+		}
+	}
+
+	// the branch, which is detached at a commit that isn't one
+	r := &sequencer.Rebase{HeadName: headRef, Onto: onto.ObjectId(), Quiet: b.flagQuiet, KeepRedundant: len(b.flagExec) > 0}
+	branchName, switchTo := strings.TrimPrefix(headRef, "refs/heads/"), ""
+	var orig *objects.Commit
+	if len(args) == 2 {
+		branchName, switchTo = args[1], args[1]
+		if _, err := repo.Ref("refs/heads/" + args[1]); err == nil && api.ValidRefName("refs/heads/"+args[1]) {
+			r.HeadName = "refs/heads/" + args[1]
+		} else {
+			r.HeadName = api.HeadRef
+		}
+		if orig, err = quietCommit(repo, args[1]); err != nil {
+			return 0, fmt.Errorf("no such branch/commit '%s'", args[1])
+		}
+	} else if orig, err = headCommit(repo); err != nil {
+		return 0, err
+	} else if orig == nil {
+		return 0, fmt.Errorf("no such branch/commit '%s'", branchName)
+	}
+	if r.HeadName == api.HeadRef {
+		r.HeadName, branchName = sequencer.DetachedHead, api.HeadRef
+		if switchTo != "" {
+			branchName = switchTo
+		}
+	}
+	r.OrigHead = orig.ObjectId()
+
+	var fork *objects.Commit
+	if forkPoint {
+		if fork, err = api.ForkPoint(repo, upstreamName, orig); err != nil && !api.IsNoSuchRef(err) {
+			return 0, err
+		}
+	}
+	if clean, err := b.requireCleanTree(p, repo, "Please commit or stash them."); err != nil || !clean {
+		return 1, err
+	}
+
+	// a branch that is on top of what it is rebased onto already
+	// needs no rebasing
+	if len(b.flagExec) == 0 && b.flagAutosquash == 0 {
+		upToDate, err := canFastForward(repo, onto, upstream, fork, orig)
+		if err != nil {
+			return 0, err
+		}
+		if upToDate {
+			return b.upToDate(p, repo, config, r, switchTo, branchName, orig)
+		}
+	}
+
+	opts := &sequencer.RebaseOptions{Fork: fork, Autosquash: b.flagAutosquash == 1, Exec: b.flagExec}
+	todo, skipped, err := sequencer.RebaseTodo(repo, upstream, orig, opts)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range skipped {
+		if !b.flagQuiet {
+			fmt.Fprintf(p.Werr, "warning: skipped previously applied commit %s\n", abbrev(c.ObjectId()))
+		}
+	}
+	if advise, err := config.Bool("advice.skippedCherryPicks", true); err != nil {
+		return 0, err
+	} else if advise && len(skipped) > 0 {
+		fmt.Fprintln(p.Werr, "hint: use --reapply-cherry-picks to include skipped commits")
+		fmt.Fprintln(p.Werr, "hint: Disable this message with \"git config advice.skippedCherryPicks false\"")
+	}
+
+	revisions := abbrev(upstream.ObjectId()) + ".." + abbrev(orig.ObjectId())
+	if err = sequencer.StartRebase(repo, r, todo, revisions); err != nil {
+		return 0, err
+	}
+
+	// the picks that are on top of what the branch is rebased onto
+	// already are done by checking it out further along
+	n, base := sequencer.FastForwardPicks(todo, onto.ObjectId())
+	if n > 0 {
+		if err = sequencer.MarkDone(repo, todo[:n]...); err != nil {
+			return 0, err
+		}
+		if todo = todo[n:]; len(todo) > 0 && isFixup(todo[0].Command) {
+			if err = sequencer.RecordRewritten(repo, base, base, true); err != nil {
+				return 0, err
+			}
+		}
+		if err = sequencer.WriteRebaseTodo(repo, todo); err != nil {
+			return 0, err
+		}
+	}
+	if status, err := b.checkoutOnto(p, repo, config, base, ontoName, orig); status != 0 || err != nil {
+		return status, err
+	}
+	if err = sequencer.WriteProgress(repo, n, n+len(todo)); err != nil {
+		return 0, err
+	}
+	return b.pickAll(p, repo, r, todo)
+}
+
+// the explanation of a rebase that has no upstream to rebase onto
+const noUpstream = `Please specify which branch you want to rebase against.
+See git-rebase(1) for details.
+
+    git rebase '<branch>'
+
+`
+
+// canFastForward returns true if a branch, at the given head, has the
+// commit that it is rebased onto as its merge base with both it and
+// its upstream, and with the fork point of the branch, if there is
+// one, so that rebasing it changes nothing.
+func canFastForward(repo *api.DiskRepository, onto, upstream, fork, head *objects.Commit) (bool, error) {
+	same := func(c *objects.Commit) (bool, error) {
+		bases, err := api.MergeBases(repo, c, head)
+		if err != nil || len(bases) != 1 {
+			return false, err
+		}
+		return bases[0].ObjectId().String() == onto.ObjectId().String(), nil
+	}
+	if ok, err := same(onto); err != nil || !ok {
+		return false, err
+	}
+	if fork != nil && fork.ObjectId().String() != onto.ObjectId().String() {
+		return false, nil
+	}
+	return same(upstream)
+}
+
+// upToDate leaves a branch that is up to date as it is, checking it
+// out if it was named.
+func (b *RebaseBuiltin) upToDate(p *Params, repo *api.DiskRepository, config *api.Config, r *sequencer.Rebase, switchTo, branchName string, orig *objects.Commit) (int, error) {
+	if switchTo != "" {
+		head, err := headCommit(repo)
+		if err != nil {
+			return 0, err
+		}
+		if status, err := switchTrees(p, repo, head, orig, false); status != 0 || err != nil {
+			return status, err
+		}
+		who, err := identity(p.Werr, config, api.RoleCommitter)
+		if err != nil {
+			return 0, err
+		}
+		branch := r.HeadName
+		if branch == sequencer.DetachedHead {
+			branch = ""
+		}
+		if err = api.SetHead(repo, branch, orig.ObjectId(), who, reflogAction()+": checkout "+switchTo); err != nil {
+			return 0, err
+		}
+	}
+	if !b.flagQuiet {
+		if branchName == api.HeadRef {
+			fmt.Fprintln(p.Wout, "HEAD is up to date.")
+		} else {
+			fmt.Fprintf(p.Wout, "Current branch %s is up to date.\n", branchName)
+		}
+	}
+	return 0, removeRebaseHead(repo)
+}
+
+// checkoutOnto detaches HEAD at the commit that the branch is rebased
+// onto, and points ORIG_HEAD at the branch as it was, giving up on the
+// rebase if the commit can't be checked out.
+func (b *RebaseBuiltin) checkoutOnto(p *Params, repo *api.DiskRepository, config *api.Config, onto *objects.ObjectId, ontoName string, orig *objects.Commit) (int, error) {
+	head, err := headCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	c, err := api.CommitFromOid(repo, onto)
+	if err != nil {
+		return 0, err
+	}
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	if status, err := switchTrees(p, repo, head, c, false); status != 0 || err != nil {
+		if err == nil {
+			fmt.Fprintln(p.Werr, "error: could not detach HEAD")
+			err = sequencer.RemoveRebase(repo)
+		}
+		return status, err
+	}
+	if err = api.UpdateRef(repo, "ORIG_HEAD", orig.ObjectId(), nil, who, ""); err != nil {
+		return 0, err
+	}
+	return 0, api.SetHead(repo, "", onto, who, reflogAction()+" (start): checkout "+ontoName)
+}
+
+// requireCleanTree explains which local changes there are, in the
+// index and in the working tree, as git-rebase refuses to start or go
+// on with them, with a hint if one is given. It returns true if there
+// are none.
+func (b *RebaseBuiltin) requireCleanTree(p *Params, repo *api.DiskRepository, hint string) (bool, error) {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return false, err
+	}
+	unstaged, err := diff.DiffIndexWorkTree(repo, api.NewWorkTree(repo), idx, new(diff.Options))
+	if err != nil {
+		return false, err
+	}
+	t, err := headTree(repo)
+	if err != nil {
+		return false, err
+	}
+	staged, err := diff.DiffTreeIndex(repo, t, idx, new(diff.Options))
+	if err != nil {
+		return false, err
+	}
+	dirty := len(unstaged.Edits()) > 0
+	if dirty {
+		fmt.Fprintln(p.Werr, "error: cannot rebase: You have unstaged changes.")
+	}
+	if len(staged.Edits()) > 0 {
+		if dirty {
+			fmt.Fprintln(p.Werr, "error: additionally, your index contains uncommitted changes.")
+		} else {
+			fmt.Fprintln(p.Werr, "error: cannot rebase: Your index contains uncommitted changes.")
+		}
+		dirty = true
+	}
+	if dirty && hint != "" {
+		fmt.Fprintf(p.Werr, "error: %s\n", hint)
+	}
+	return !dirty, nil
+}
+
+// reflogAction returns what the reflogs of the rebase say that it
+// does, which is "rebase" unless $GIT_REFLOG_ACTION says otherwise.
+func reflogAction() string {
+	if action := os.Getenv("GIT_REFLOG_ACTION"); action != "" {
+		return action
+	}
+	return "rebase"
+}
+
+// ================================================================= //
+// PICKS
+// ================================================================= //
+
+// pickAll carries out the instructions that are left of the rebase in
+// progress one by one, and finishes the rebase once they are all
+// done. The instruction that stops the rebase is marked done.
+func (b *RebaseBuiltin) pickAll(p *Params, repo *api.DiskRepository, r *sequencer.Rebase, todo []*sequencer.Instruction) (int, error) {
+	done, err := sequencer.DoneCount(repo)
+	if err != nil {
+		return 0, err
+	}
+	total := done + len(todo)
+	for len(todo) > 0 {
+		ins := todo[0]
+		if err = sequencer.WriteRebaseTodo(repo, todo[1:]); err != nil {
+			return 0, err
+		}
+		if err = sequencer.MarkDone(repo, ins); err != nil {
+			return 0, err
+		}
+		done++
+		if err = sequencer.WriteProgress(repo, done, total); err != nil {
+			return 0, err
+		}
+		if !r.Quiet {
+			fmt.Fprintf(p.Werr, "Rebasing (%d/%d)\r", done, total)
+		}
+		if err = sequencer.ClearStop(repo); err != nil {
+			return 0, err
+		}
+		for _, name := range []string{mergeHeadFile, autoMergeFile, rebaseHeadFile} {
+			if err = os.Remove(path.Join(repo.Path(), name)); err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+		}
+
+		next := sequencer.Noop
+		if len(todo) > 1 {
+			next = todo[1].Command
+		}
+		var status int
+		switch ins.Command {
+		case sequencer.Exec:
+			status, err = b.exec(p, repo, ins.Arg)
+		case sequencer.Noop:
+		default:
+			status, err = b.pick(p, repo, r, ins, next)
+		}
+		if status != 0 || err != nil {
+			return status, err
+		}
+		todo = todo[1:]
+	}
+	return b.finish(p, repo, r)
+}
+
+// isFixup returns true if a command melds its commit into the one
+// before.
+func isFixup(cmd sequencer.Command) bool {
+	return cmd == sequencer.Fixup || cmd == sequencer.Squash
+}
+
+// pick replays a commit onto HEAD, and commits the result, unless it
+// conflicts, or changes nothing that HEAD doesn't have already. A
+// fixup or a squash is melded into HEAD instead, with the message of
+// the commits that they make together.
+func (b *RebaseBuiltin) pick(p *Params, repo *api.DiskRepository, r *sequencer.Rebase, ins *sequencer.Instruction, next sequencer.Command) (int, error) {
+	c, cmd := ins.Commit, ins.Command
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	committer, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	head, err := headCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	parent, err := sequencer.Parent(repo, c, 0)
+	if err != nil {
+		return 0, err
+	}
+	if err = sequencer.WriteAuthorScript(repo, c.Author()); err != nil {
+		return 0, err
+	}
+
+	// a commit on top of HEAD already is checked out as it is
+	if cmd == sequencer.Pick && parent != nil && parent.ObjectId().String() == headOid.String() {
+		if status, err := switchTrees(p, repo, head, c, false); status != 0 || err != nil {
+			return status, err
+		}
+		if err = api.UpdateRef(repo, api.HeadRef, c.ObjectId(), headOid, committer, reflogAction()+": fast-forward"); err != nil {
+			return 0, err
+		}
+		return 0, sequencer.RecordRewritten(repo, c.ObjectId(), c.ObjectId(), isFixup(next))
+	}
+
+	if isFixup(cmd) {
+		if err = sequencer.UpdateSquashMessage(repo, cmd, c, head); err != nil {
+			return 0, err
+		}
+	}
+	r2, status, err := b.merge(p, repo, config, c, parent)
+	if status != 0 || err != nil {
+		return status, err
+	}
+	if !r2.Clean {
+		fmt.Fprintf(p.Werr, "error: could not apply %s... %s\n", abbrev(c.ObjectId()), sequencer.Subject(c))
+		fmt.Fprint(p.Werr, rebaseResolveAdvice)
+		if isFixup(cmd) {
+			// the message of the commits that are melded together
+			// is the one to resolve the conflicts with
+			msg, err := sequencer.SquashMessage(repo)
+			if err != nil {
+				return 0, err
+			}
+			if err = sequencer.WriteStopMessage(repo, msg); err != nil {
+				return 0, err
+			}
+			if err = writeStateFile(repo, mergeMsgFile, msg); err != nil {
+				return 0, err
+			}
+			if err = sequencer.WriteAmend(repo, headOid); err != nil {
+				return 0, err
+			}
+		}
+		return b.stop(p, repo, c)
+	}
+
+	// a commit whose changes HEAD has already is dropped, unless it
+	// changed nothing to begin with, or such commits are kept
+	if r2.Tree.ObjectId().String() == head.Tree().String() {
+		empty := parent == nil && len(r2.Tree.Entries()) == 0 || parent != nil && parent.Tree().String() == c.Tree().String()
+		if !empty && !r.KeepRedundant {
+			for _, name := range []string{mergeMsgFile, autoMergeFile} {
+				if err = os.Remove(path.Join(repo.Path(), name)); err != nil && !os.IsNotExist(err) {
+					return 0, err
+				}
+			}
+			fmt.Fprintf(p.Werr, "dropping %s %s -- patch contents already upstream\n", c.ObjectId(), sequencer.Subject(c))
+			return 0, sequencer.RecordRewritten(repo, c.ObjectId(), headOid, isFixup(next))
+		}
+	}
+
+	msg, author, parents := c.Message(), c.Author(), []*objects.ObjectId{headOid}
+	summary := false
+	if isFixup(cmd) {
+		author, parents = head.Author(), head.Parents()
+		if msg, summary, err = b.squashMessage(repo, c, isFixup(next)); err != nil {
+			return 0, err
+		}
+	}
+	nc, err := api.WriteCommit(repo, r2.Tree.ObjectId(), parents, author, committer, msg)
+	if err != nil {
+		return 0, err
+	}
+	subject := strings.SplitN(msg, "\n", 2)[0]
+	if err = api.UpdateRef(repo, api.HeadRef, nc.ObjectId(), headOid, committer, fmt.Sprintf("%s (%s): %s", reflogAction(), cmd, subject)); err != nil {
+		return 0, err
+	}
+	if err = os.Remove(path.Join(repo.Path(), mergeMsgFile)); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if isFixup(cmd) && !isFixup(next) {
+		if err = sequencer.ClearSquash(repo); err != nil {
+			return 0, err
+		}
+	}
+	if summary {
+		var before *objects.Commit
+		if len(parents) > 0 {
+			if before, err = api.CommitFromOid(repo, parents[0]); err != nil {
+				return 0, err
+			}
+		}
+		if err = printCommitSummary(p, repo, config, api.HeadRef, len(parents) == 0, true, nc, before); err != nil {
+			return 0, err
+		}
+	}
+	return 0, sequencer.RecordRewritten(repo, c.ObjectId(), nc.ObjectId(), isFixup(next))
+}
+
+// squashMessage returns the message of the commit that a fixup or a
+// squash makes with HEAD. Until the last of a chain of them, this
+// lists the messages of all of them, comments and all. The last one
+// keeps the message of the commit that they were melded into if they
+// are all fixups, or else leaves the message without its comments,
+// as git-commit would once it was edited, and returns true to show
+// that the commit is to be summed up. Such a message is edited by
+// git-commit in git, which leaves REBASE_HEAD behind and AUTO_MERGE
+// not.
+func (b *RebaseBuiltin) squashMessage(repo *api.DiskRepository, c *objects.Commit, more bool) (string, bool, error) {
+	if more {
+		msg, err := sequencer.SquashMessage(repo)
+		return msg, false, err
+	}
+	if msg, fixup, err := sequencer.FixupMessage(repo); err != nil || fixup {
+		return msg, false, err
+	}
+	msg, err := sequencer.SquashMessage(repo)
+	if err != nil {
+		return "", false, err
+	}
+	if err = writeStateFile(repo, rebaseHeadFile, c.ObjectId().String()+"\n"); err != nil {
+		return "", false, err
+	}
+	if err = os.Remove(path.Join(repo.Path(), autoMergeFile)); err != nil && !os.IsNotExist(err) {
+		return "", false, err
+	}
+	return stripComments(msg), true, nil
+}
+
+// merge merges the changes of a commit since its parent into HEAD,
+// leaving conflicts in the index and the working tree, along with the
+// message of the commit.
+func (b *RebaseBuiltin) merge(p *Params, repo *api.DiskRepository, config *api.Config, c, parent *objects.Commit) (*merge.Result, int, error) {
+	headTree, err := headTree(repo)
+	if err != nil {
+		return nil, 0, err
+	}
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return nil, 0, err
+	}
+	mopts, err := mergeOptions(config)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := sequencer.Apply(repo, sequencer.Pick, c, parent, headTree, mopts)
+	if err != nil {
+		return nil, 0, err
+	}
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = checkout.SwitchTrees(repo, idx, headTree, r.Tree, &checkout.Options{Ignorer: ig, Action: "merge"})
+	if e, ok := err.(*checkout.Error); ok {
+		printCheckoutError(p, e)
+		return nil, 1, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	for _, e := range r.Unmerged {
+		idx.Add(e)
+	}
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return nil, 0, err
+	}
+	if !r.Clean {
+		for _, m := range r.Messages {
+			fmt.Fprintln(p.Wout, m.Text)
+		}
+	}
+
+	msg := c.Message()
+	if err = writeStateFile(repo, autoMergeFile, r.Tree.ObjectId().String()+"\n"); err != nil {
+		return nil, 0, err
+	}
+	if !r.Clean {
+		msg += "\n# Conflicts:\n"
+		for i, e := range r.Unmerged {
+			if i == 0 || r.Unmerged[i-1].Name() != e.Name() {
+				msg += "#\t" + e.Name() + "\n"
+			}
+		}
+	}
+	return r, 0, writeStateFile(repo, mergeMsgFile, msg)
+}
+
+// the advice that a conflict of a rebase gets
+const rebaseResolveAdvice = `hint: Resolve all conflicts manually, mark them as resolved with
+hint: "git add/rm <conflicted_files>", then run "git rebase --continue".
+hint: You can instead skip this commit: run "git rebase --skip".
+hint: To abort and get back to the state before "git rebase", run "git rebase --abort".
+`
+
+// stop stops the rebase at a commit that conflicted, recording it as
+// REBASE_HEAD.
+func (b *RebaseBuiltin) stop(p *Params, repo *api.DiskRepository, c *objects.Commit) (int, error) {
+	if err := sequencer.WriteStop(repo, c); err != nil {
+		return 0, err
+	}
+	if err := writeStateFile(repo, rebaseHeadFile, c.ObjectId().String()+"\n"); err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(p.Werr, "Could not apply %s... %s\n", abbrev(c.ObjectId()), sequencer.Subject(c))
+	return 1, nil
+}
+
+// exec runs a shell command, and stops the rebase if it fails, or
+// leaves local changes behind.
+func (b *RebaseBuiltin) exec(p *Params, repo *api.DiskRepository, command string) (int, error) {
+	clearLine(p)
+	fmt.Fprintf(p.Werr, "Executing: %s\n", command)
+	status := runShell(p, repo, command)
+	clean, err := b.requireCleanTree(p, repo, "")
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case status != 0:
+		changes := ""
+		if !clean {
+			changes = "and made changes to the index and/or the working tree\n"
+		}
+		fmt.Fprintf(p.Werr, "warning: execution failed: %s\n%sYou can fix the problem, and then run\n\n  git rebase --continue\n\n\n", command, changes)
+		if status == 127 {
+			// the command was not found
+			status = 1
+		}
+	case !clean:
+		fmt.Fprintf(p.Werr, "warning: execution succeeded: %s\nbut left changes to the index and/or the working tree\n", command)
+		fmt.Fprint(p.Werr, "Commit or stash your changes, and then run\n\n  git rebase --continue\n\n\n")
+		status = 1
+	}
+	return status, nil
+}
+
+// the characters that make git run a command with the shell rather
+// than directly
+const shellMetachars = "|&;<>()$`\\\"' \t\n*?[#~=%"
+
+// runShell runs a command in the working tree, with the shell if it
+// needs one, and returns its exit status, which is 127 if it can't be
+// run.
+func runShell(p *Params, repo *api.DiskRepository, command string) int {
+	var cmd *exec.Cmd
+	if strings.ContainsAny(command, shellMetachars) {
+		cmd = exec.Command("sh", "-c", command)
+	} else {
+		cmd = exec.Command(command)
+	}
+	cmd.Dir, cmd.Stdin, cmd.Stdout, cmd.Stderr = repo.WorkDir(), p.Rin, p.Wout, p.Werr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	} else if err != nil {
+		fmt.Fprintf(p.Werr, "error: cannot run %s: No such file or directory\n", command)
+		return 127
+	}
+	return 0
+}
+
+// clearLine clears the line of progress on the terminal, as git's
+// term_clear_line does.
+func clearLine(p *Params) {
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		fmt.Fprintf(p.Werr, "\r%*s\r", util.TermColumns(), "")
+	} else {
+		fmt.Fprint(p.Werr, "\r\x1b[K")
+	}
+}
+
+// finish points the branch that was rebased at HEAD, and checks it
+// out, once the rebase is done.
+func (b *RebaseBuiltin) finish(p *Params, repo *api.DiskRepository, r *sequencer.Rebase) (int, error) {
+	if strings.HasPrefix(r.HeadName, "refs/") {
+		config, err := api.ReadConfig(repo)
+		if err != nil {
+			return 0, err
+		}
+		who, err := identity(p.Werr, config, api.RoleCommitter)
+		if err != nil {
+			return 0, err
+		}
+		_, headOid, err := api.Head(repo)
+		if err != nil {
+			return 0, err
+		}
+		action := reflogAction() + " (finish): "
+		if err = api.UpdateRef(repo, r.HeadName, headOid, r.OrigHead, who, fmt.Sprintf("%s%s onto %s", action, r.HeadName, r.Onto)); err != nil {
+			return 0, err
+		}
+		if err = api.SetHead(repo, r.HeadName, headOid, who, action+"returning to "+r.HeadName); err != nil {
+			return 0, err
+		}
+	}
+	if !r.Quiet {
+		clearLine(p)
+		fmt.Fprintf(p.Werr, "Successfully rebased and updated %s.\n", r.HeadName)
+	}
+	return 0, sequencer.RemoveRebase(repo)
+}
+
+// ================================================================= //
+// REBASES IN PROGRESS
+// ================================================================= //
+
+// resume commits the changes that resolve the conflicts of the commit
+// that stopped the rebase, if there are any, and carries on with the
+// rest of it.
+func (b *RebaseBuiltin) resume(p *Params, repo *api.DiskRepository) (int, error) {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+	unstaged, err := diff.DiffIndexWorkTree(repo, api.NewWorkTree(repo), idx, new(diff.Options))
+	if err != nil {
+		return 0, err
+	}
+	if unmerged(idx) || len(unstaged.Edits()) > 0 {
+		for i, e := range idx.Entries() {
+			if e.Stage() != 0 && (i == 0 || idx.Entries()[i-1].Name() != e.Name()) {
+				fmt.Fprintf(p.Wout, "%s: needs merge\n", e.Name())
+			}
+		}
+		fmt.Fprintln(p.Wout, "You must edit all merge conflicts and then\nmark them as resolved using git add")
+		return 1, nil
+	}
+	r, err := sequencer.ReadRebase(repo)
+	if err != nil {
+		return 0, err
+	}
+	if status, err := b.commitResolved(p, repo, idx); status != 0 || err != nil {
+		return status, err
+	}
+	return b.resumeTodo(p, repo, r)
+}
+
+// resumeTodo records the commit that stopped the rebase as rewritten
+// as HEAD, and carries on with the instructions that are left.
+func (b *RebaseBuiltin) resumeTodo(p *Params, repo *api.DiskRepository, r *sequencer.Rebase) (int, error) {
+	todo, err := sequencer.ReadRebaseTodo(repo)
+	if err != nil {
+		return 0, err
+	}
+	stopped, err := sequencer.Stopped(repo)
+	if err != nil {
+		return 0, err
+	}
+	if stopped != nil {
+		_, headOid, err := api.Head(repo)
+		if err != nil {
+			return 0, err
+		}
+		if err = sequencer.RecordRewritten(repo, stopped, headOid, len(todo) > 0 && isFixup(todo[0].Command)); err != nil {
+			return 0, err
+		}
+	}
+	return b.pickAll(p, repo, r, todo)
+}
+
+// commitResolved commits the changes that are staged, with the message
+// and the author of the commit that stopped the rebase, or amends HEAD
+// with them if the commit was a fixup or a squash of it.
+func (b *RebaseBuiltin) commitResolved(p *Params, repo *api.DiskRepository, idx *api.Index) (int, error) {
+	head, err := headCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	amend, err := sequencer.Amend(repo)
+	if err != nil {
+		return 0, err
+	}
+	if amend != nil && amend.String() != head.ObjectId().String() {
+		fmt.Fprintln(p.Werr, "error: \nYou have uncommitted changes in your working tree. Please, commit them\nfirst and then run 'git rebase --continue' again.")
+		return 1, nil
+	}
+	t, err := api.TreeFromOid(repo, head.Tree())
+	if err != nil {
+		return 0, err
+	}
+	staged, err := diff.DiffTreeIndex(repo, t, idx, new(diff.Options))
+	if err != nil {
+		return 0, err
+	}
+	if len(staged.Edits()) == 0 {
+		if err = os.Remove(path.Join(repo.Path(), mergeMsgFile)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	committer, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := sequencer.StopMessage(repo)
+	if err != nil {
+		return 0, err
+	}
+	msg = stripComments(msg)
+	author, parents := head.Author(), head.Parents()
+	if amend == nil {
+		if author, err = sequencer.ReadAuthorScript(repo); err != nil {
+			return 0, err
+		}
+		parents = []*objects.ObjectId{head.ObjectId()}
+	}
+	oid, err := idx.WriteTree(repo, false)
+	if err != nil {
+		return 0, err
+	}
+	nc, err := api.WriteCommit(repo, oid, parents, author, committer, msg)
+	if err != nil {
+		return 0, err
+	}
+	subject := strings.SplitN(msg, "\n", 2)[0]
+	if err = api.UpdateRef(repo, api.HeadRef, nc.ObjectId(), head.ObjectId(), committer, reflogAction()+" (continue): "+subject); err != nil {
+		return 0, err
+	}
+	for _, name := range []string{mergeMsgFile, mergeHeadFile, autoMergeFile} {
+		if err = os.Remove(path.Join(repo.Path(), name)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	if amend != nil {
+		if err = sequencer.ClearSquash(repo); err != nil {
+			return 0, err
+		}
+	}
+	var parent *objects.Commit
+	if len(parents) > 0 {
+		if parent, err = api.CommitFromOid(repo, parents[0]); err != nil {
+			return 0, err
+		}
+	}
+	return 0, printCommitSummary(p, repo, config, api.HeadRef, len(parents) == 0, amend != nil, nc, parent)
+}
+
+// skip throws away the changes of the commit that stopped the rebase,
+// and carries on with the rest of it.
+func (b *RebaseBuiltin) skip(p *Params, repo *api.DiskRepository) (int, error) {
+	head, err := headCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	if err = resetTo(repo, head); err != nil {
+		return 0, err
+	}
+	if err = removeBranchState(repo); err != nil {
+		return 0, err
+	}
+	r, err := sequencer.ReadRebase(repo)
+	if err != nil {
+		return 0, err
+	}
+	return b.resumeTodo(p, repo, r)
+}
+
+// abort gives up on the rebase, and goes back to the branch as it was
+// before.
+func (b *RebaseBuiltin) abort(p *Params, repo *api.DiskRepository) (int, error) {
+	r, err := sequencer.ReadRebase(repo)
+	if err != nil {
+		return 0, err
+	}
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	orig, err := api.CommitFromOid(repo, r.OrigHead)
+	if err != nil {
+		return 0, err
+	}
+	if err = resetTo(repo, orig); err != nil {
+		return 0, fmt.Errorf("could not move back to %s", r.OrigHead)
+	}
+	branch, msg := "", reflogAction()+" (abort): returning to "
+	if strings.HasPrefix(r.HeadName, "refs/") {
+		branch, msg = r.HeadName, msg+r.HeadName
+		if err = api.UpdateRef(repo, branch, r.OrigHead, nil, who, msg); err != nil {
+			return 0, err
+		}
+	} else {
+		msg += r.OrigHead.String()
+	}
+	if err = api.SetHead(repo, branch, r.OrigHead, who, msg); err != nil {
+		return 0, err
+	}
+	if err = removeBranchState(repo); err != nil {
+		return 0, err
+	}
+	if err = removeRebaseHead(repo); err != nil {
+		return 0, err
+	}
+	return 0, sequencer.RemoveRebase(repo)
+}
+
+// resetTo checks out the tree of a commit into the index and the
+// working tree, throwing away local changes, without moving HEAD.
+func resetTo(repo *api.DiskRepository, c *objects.Commit) error {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	t, err := api.TreeFromOid(repo, c.Tree())
+	if err != nil {
+		return err
+	}
+	if err = checkout.SwitchTrees(repo, idx, nil, t, &checkout.Options{Force: true}); err != nil {
+		return err
+	}
+	err, lock = repo.WriteIndex(lock, idx), nil
+	return err
+}
+
+// removeRebaseHead forgets about the commit that stopped the rebase,
+// and the result of its merge.
+func removeRebaseHead(repo *api.DiskRepository) error {
+	for _, name := range []string{rebaseHeadFile, autoMergeFile} {
+		if err := os.Remove(path.Join(repo.Path(), name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/checkout"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"os"
+	"path"
+)
+
+// ================================================================= //
+// RESET
+// ================================================================= //
+
+// the modes of git-reset
+const (
+	resetDefault = iota
+	resetMixed
+	resetSoft
+	resetHard
+	resetMerge
+	resetKeep
+)
+
+// the names of the modes of git-reset
+var resetModes = []string{"", "mixed", "soft", "hard", "merge", "keep"}
+
+// ResetBuiltin implements git-reset, which resets HEAD, the index
+// and the working tree to a commit, or the entries of paths to a
+// tree.
+type ResetBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagMode  int
+	flagQuiet bool
+}
+
+var Reset = &ResetBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "reset",
+		Description: "Reset current HEAD to the specified state",
+		UsageLine:   "[-q] [--soft | --mixed | --hard | --keep | --merge] [<commit>] | [-q] [<tree-ish>] [--] <pathspec>...",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Reset.Var(choiceFlag{&Reset.flagMode, resetSoft}, "soft", "Reset HEAD, but not the index or the working tree.")
+	Reset.Var(choiceFlag{&Reset.flagMode, resetMixed}, "mixed", "Reset HEAD and the index, but not the working tree.")
+	Reset.Var(choiceFlag{&Reset.flagMode, resetHard}, "hard", "Reset HEAD, the index and the working tree.")
+	Reset.Var(choiceFlag{&Reset.flagMode, resetKeep}, "keep", "Reset HEAD, but keep local changes.")
+	Reset.Var(choiceFlag{&Reset.flagMode, resetMerge}, "merge", "Reset HEAD, the index and the files that changed, but keep unstaged changes.")
+	Reset.BoolVar(&Reset.flagQuiet, "q", false, "Only report errors.")
+	Reset.BoolVar(&Reset.flagQuiet, "quiet", false, "Only report errors.")
+
+	Reset.Usage = func() {}
+
+	// add to command list
+	Add(Reset)
+}
+
+func (b *ResetBuiltin) Execute(p *Params, args []string) {
+	b.flagMode, b.flagQuiet = resetDefault, false
+	args, paths, dashdash := splitDashDash(args)
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.reset(p, args, paths, dashdash)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *ResetBuiltin) reset(p *Params, args, paths []string, dashdash bool) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	rev, paths, status := b.parseArgs(p, repo, args, paths, dashdash)
+	if status != 0 {
+		return status, nil
+	}
+	ps, err := addPathspec(repo, workPrefix(repo), paths)
+	if err != nil {
+		return 0, err
+	}
+
+	// an unborn branch is reset to the empty tree
+	var c *objects.Commit
+	t := objects.NewTree(nil, nil, nil)
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	unborn := rev == api.HeadRef && headOid == nil
+	switch {
+	case unborn:
+	case len(paths) == 0:
+		o, err := api.LookupRevision(repo, rev)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to resolve '%s' as a valid revision.", rev)
+		}
+		if o, err = api.PeelObject(repo, o); err != nil {
+			return 0, err
+		}
+		var ok bool
+		if c, ok = o.(*objects.Commit); !ok {
+			fmt.Fprintf(p.Werr, "error: object %s is a %s, not a commit\n", o.ObjectId(), o.Header().Type())
+			return 0, fmt.Errorf("Could not parse object '%s'.", rev)
+		}
+		if t, err = api.TreeFromObject(repo, c); err != nil {
+			return 0, err
+		}
+	default:
+		if t, err = quietTree(repo, rev); err != nil {
+			return 0, fmt.Errorf("Failed to resolve '%s' as a valid tree.", rev)
+		}
+	}
+
+	mode := b.flagMode
+	if len(paths) > 0 {
+		switch mode {
+		case resetMixed:
+			fmt.Fprintln(p.Werr, "warning: --mixed with paths is deprecated; use 'git reset -- <paths>' instead.")
+		case resetDefault:
+		default:
+			return 0, fmt.Errorf("Cannot do %s reset with paths.", resetModes[mode])
+		}
+	}
+	if mode == resetDefault {
+		mode = resetMixed
+	}
+
+	if mode != resetSoft {
+		if err = b.resetIndex(p, repo, mode, rev, t, ps); err != nil {
+			return 0, err
+		}
+	} else if idx, err := readIndex(repo); err != nil {
+		return 0, err
+	} else if unmerged(idx) {
+		return 0, errors.New("Cannot do a soft reset in the middle of a merge.")
+	}
+
+	if len(paths) > 0 {
+		return 0, nil
+	}
+	if !unborn {
+		if err = b.resetHead(p, repo, rev, c, headOid); err != nil {
+			return 0, err
+		}
+		if mode == resetHard && !b.flagQuiet {
+			fmt.Fprintf(p.Wout, "HEAD is now at %s %s\n", abbrev(c.ObjectId()), commitSubject(c))
+		}
+	}
+	return 0, removeBranchState(repo)
+}
+
+// parseArgs tells the revision from the paths, as git does: the
+// first argument is a revision if it resolves to one, and isn't a
+// file as well, unless "--" says otherwise.
+func (b *ResetBuiltin) parseArgs(p *Params, repo *api.DiskRepository, args, paths []string, dashdash bool) (string, []string, int) {
+	switch {
+	case len(args) == 0:
+		return api.HeadRef, paths, 0
+	case dashdash:
+		return args[0], append(args[1:], paths...), 0
+	}
+	_, err := api.LookupRevision(repo, args[0])
+	_, statErr := os.Lstat(path.Join(repo.WorkDir(), workPrefix(repo), args[0]))
+	var problem string
+	switch {
+	case err == nil && statErr == nil:
+		problem = "both revision and filename"
+	case err == nil:
+		return args[0], args[1:], 0
+	case statErr == nil:
+		return api.HeadRef, args, 0
+	default:
+		problem = "unknown revision or path not in the working tree."
+	}
+	fmt.Fprintf(p.Werr, "fatal: ambiguous argument '%s': %s\n", args[0], problem)
+	fmt.Fprintln(p.Werr, "Use '--' to separate paths from revisions, like this:")
+	fmt.Fprintln(p.Werr, "'git <command> [<revision>...] -- [<file>...]'")
+	return "", nil, 128
+}
+
+// resetIndex resets the index, and the working tree unless the
+// reset is mixed, to a tree.
+func (b *ResetBuiltin) resetIndex(p *Params, repo *api.DiskRepository, mode int, rev string, t *objects.Tree, ps *pathspec.Pathspec) error {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	if mode == resetKeep && unmerged(idx) {
+		return errors.New("Cannot do a keep reset in the middle of a merge.")
+	}
+
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case resetHard:
+		err = checkout.SwitchTrees(repo, idx, nil, t, &checkout.Options{Force: true})
+	case resetMerge:
+		err = checkout.MergeTree(repo, idx, t, &checkout.Options{Ignorer: ig})
+	case resetKeep:
+		var head *objects.Tree
+		if head, err = headTree(repo); err != nil {
+			return errors.New("You do not have a valid HEAD.")
+		}
+		err = checkout.SwitchTrees(repo, idx, head, t, &checkout.Options{Ignorer: ig})
+	}
+	switch e := err.(type) {
+	case nil:
+	case *checkout.Error:
+		fmt.Fprintf(p.Werr, "error: %s\n", e.First())
+		return fmt.Errorf("Could not reset index file to revision '%s'.", rev)
+	case *checkout.UnmergedError:
+		return fmt.Errorf("Could not reset index file to revision '%s'.", rev)
+	default:
+		return err
+	}
+
+	// the index of a keep reset is reset as well, and the files of a
+	// mixed reset are refreshed
+	if mode == resetKeep || mode == resetMixed {
+		if _, err = checkout.RestorePaths(repo, idx, ps, &checkout.RestoreOptions{Source: t, Staged: true}); err != nil {
+			return err
+		}
+	}
+	if mode == resetMixed {
+		if err = b.refresh(p, repo, idx); err != nil {
+			return err
+		}
+	}
+	err, lock = repo.WriteIndex(lock, idx), nil
+	return err
+}
+
+// refresh refreshes the stat data of the index, and lists the files
+// that have changes, unless the reset is quiet.
+func (b *ResetBuiltin) refresh(p *Params, repo *api.DiskRepository, idx *api.Index) error {
+	wt := api.NewWorkTree(repo)
+	header := false
+	for _, e := range append([]*api.IndexEntry(nil), idx.Entries()...) {
+		entry, status, err := wt.Refresh(e)
+		if err != nil {
+			return err
+		}
+		if entry != e {
+			idx.Add(entry)
+		}
+		var code string
+		switch {
+		case e.ExtendedFlags().IntentToAdd():
+			code = "A"
+		case status == api.WorkDeleted:
+			code = "D"
+		case status == api.WorkModified:
+			code = "M"
+		}
+		if code == "" || b.flagQuiet {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(p.Wout, "Unstaged changes after reset:")
+			header = true
+		}
+		fmt.Fprintf(p.Wout, "%s\t%s\n", code, e.Name())
+	}
+	return nil
+}
+
+// resetHead points HEAD at a commit, and ORIG_HEAD at the commit
+// that HEAD pointed at before.
+func (b *ResetBuiltin) resetHead(p *Params, repo *api.DiskRepository, rev string, c *objects.Commit, headOid *objects.ObjectId) error {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return err
+	}
+	origMsg, msg := "reset: updating ORIG_HEAD", "reset: moving to "+rev
+	if action := os.Getenv("GIT_REFLOG_ACTION"); action != "" {
+		origMsg, msg = action+": updating ORIG_HEAD", action+": updating HEAD"
+	}
+
+	// an unborn branch leaves no ORIG_HEAD behind
+	if headOid != nil {
+		err = api.UpdateRef(repo, "ORIG_HEAD", headOid, nil, who, origMsg)
+	} else if orig, e := repo.Ref("ORIG_HEAD"); e == nil {
+		err = api.DeleteRef(repo, orig.Name(), nil)
+	}
+	if err != nil {
+		return err
+	}
+	return api.UpdateRef(repo, api.HeadRef, c.ObjectId(), headOid, who, msg)
+}
+
+// the files that keep the state of merges, cherry-picks and reverts
+// that are in progress
+var branchStateFiles = []string{
+	"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_HEAD", "MERGE_RR", "MERGE_MSG", "MERGE_MODE", "AUTO_MERGE", "SQUASH_MSG",
+}
+
+// removeBranchState forgets about the merge, cherry-pick or revert
+// that is in progress, if there is one.
+func removeBranchState(repo *api.DiskRepository) error {
+	for _, name := range branchStateFiles {
+		if err := os.Remove(path.Join(repo.Path(), name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmerged returns true if the index has merge conflicts.
+func unmerged(idx *api.Index) bool {
+	for _, e := range idx.Entries() {
+		if e.Stage() != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/checkout"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// ================================================================= //
+// RESTORE
+// ================================================================= //
+
+// RestoreBuiltin implements git-restore, which restores the files
+// of the working tree, or of the index, from a source.
+type RestoreBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagSource   string
+	flagStaged   bool
+	flagWorkTree bool
+	flagOverlay  bool
+	flagQuiet    bool
+}
+
+var Restore = &RestoreBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "restore",
+		Description: "Restore working tree files",
+		UsageLine:   "[-s <tree>] [-S] [-W] [--overlay] [-q] [--] <pathspec>...",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Restore.StringVar(&Restore.flagSource, "s", "", "Restore the files from the given tree.")
+	Restore.StringVar(&Restore.flagSource, "source", "", "Restore the files from the given tree.")
+	Restore.BoolVar(&Restore.flagStaged, "S", false, "Restore the index.")
+	Restore.BoolVar(&Restore.flagStaged, "staged", false, "Restore the index.")
+	Restore.BoolVar(&Restore.flagWorkTree, "W", false, "Restore the working tree, which is the default.")
+	Restore.BoolVar(&Restore.flagWorkTree, "worktree", false, "Restore the working tree, which is the default.")
+	Restore.BoolVar(&Restore.flagOverlay, "overlay", false, "Never remove files that are missing from the source.")
+	Restore.BoolVar(&Restore.flagQuiet, "q", false, "Suppress feedback messages.")
+	Restore.BoolVar(&Restore.flagQuiet, "quiet", false, "Suppress feedback messages.")
+
+	Restore.Usage = func() {}
+
+	// add to command list
+	Add(Restore)
+}
+
+func (b *RestoreBuiltin) Execute(p *Params, args []string) {
+	b.flagSource, b.flagStaged, b.flagWorkTree = "", false, false
+	b.flagOverlay, b.flagQuiet = false, false
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.restore(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *RestoreBuiltin) restore(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	if len(args) == 0 {
+		return 0, errors.New("you must specify path(s) to restore")
+	}
+	if !b.flagStaged {
+		b.flagWorkTree = true
+	}
+
+	// the index is restored from HEAD by default, and the
+	// working tree from the index
+	source := b.flagSource
+	if source == "" && b.flagStaged {
+		source = api.HeadRef
+	}
+	var t *objects.Tree
+	if source != "" {
+		if t, err = quietTree(repo, source); err != nil {
+			return 0, fmt.Errorf("could not resolve %s", source)
+		}
+	}
+	opts := &checkout.RestoreOptions{
+		Source:   t,
+		Staged:   b.flagStaged,
+		WorkTree: b.flagWorkTree,
+		Overlay:  b.flagOverlay,
+	}
+	_, status, err := restorePaths(p, repo, args, opts)
+	return status, err
+}
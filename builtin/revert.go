@@ -0,0 +1,42 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"github.com/jbrukh/ggit/api/sequencer"
+)
+
+// ================================================================= //
+// REVERT
+// ================================================================= //
+
+// RevertBuiltin implements git-revert, which undoes the changes of
+// existing commits on the current branch, recording a new commit for
+// each of them.
+type RevertBuiltin struct {
+	replayBuiltin
+}
+
+var Revert = &RevertBuiltin{
+	replayBuiltin{
+		HelpInfo: HelpInfo{
+			Name:        "revert",
+			Description: "Revert some existing commits",
+			UsageLine:   "[-n] [-m <parent-number>] <commit>... | (--continue | --skip | --abort | --quit)",
+			ManPage:     "TODO",
+		},
+		cmd: sequencer.Revert,
+	},
+}
+
+func init() {
+	Revert.initReplay()
+
+	// add to command list
+	Add(Revert)
+}
@@ -0,0 +1,247 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/pathspec"
+	"strings"
+)
+
+// ================================================================= //
+// RM
+// ================================================================= //
+
+// RmBuiltin implements git-rm, which removes files from the
+// index and the working tree.
+type RmBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagCached        bool
+	flagForce         bool
+	flagRecursive     bool
+	flagDryRun        bool
+	flagQuiet         bool
+	flagIgnoreUnmatch bool
+}
+
+var Rm = &RmBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "rm",
+		Description: "Remove files from the working tree and from the index",
+		UsageLine:   "[-f] [-n] [-r] [--cached] [--ignore-unmatch] [--quiet] [--] <pathspec>...",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Rm.BoolVar(&Rm.flagCached, "cached", false, "Only remove the files from the index, keeping them in the working tree.")
+	Rm.BoolVar(&Rm.flagForce, "f", false, "Override the up-to-date check.")
+	Rm.BoolVar(&Rm.flagForce, "force", false, "Override the up-to-date check.")
+	Rm.BoolVar(&Rm.flagRecursive, "r", false, "Allow recursive removal when a leading directory name is given.")
+	Rm.BoolVar(&Rm.flagDryRun, "n", false, "Don't actually remove any file, just show what would be removed.")
+	Rm.BoolVar(&Rm.flagDryRun, "dry-run", false, "Don't actually remove any file, just show what would be removed.")
+	Rm.BoolVar(&Rm.flagQuiet, "q", false, "Don't show the removed files.")
+	Rm.BoolVar(&Rm.flagQuiet, "quiet", false, "Don't show the removed files.")
+	Rm.BoolVar(&Rm.flagIgnoreUnmatch, "ignore-unmatch", false, "Exit with a zero status even if no files matched.")
+
+	Rm.Usage = func() {}
+
+	// add to command list
+	Add(Rm)
+}
+
+func (b *RmBuiltin) Execute(p *Params, args []string) {
+	b.flagCached, b.flagForce, b.flagRecursive = false, false, false
+	b.flagDryRun, b.flagQuiet, b.flagIgnoreUnmatch = false, false, false
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(p.Werr, "fatal: No pathspec was given. Which files should I remove?")
+		p.Status = 128
+		return
+	}
+	status, err := b.rm(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+// the complaints about files that can't be removed safely, in the
+// singular and the plural, and the hints that follow them
+var (
+	stagedBothError   = [2]string{"the following file has staged content different from both the\nfile and the HEAD:", "the following files have staged content different from both the\nfile and the HEAD:"}
+	stagedIndexError  = [2]string{"the following file has changes staged in the index:", "the following files have changes staged in the index:"}
+	localChangesError = [2]string{"the following file has local modifications:", "the following files have local modifications:"}
+)
+
+const (
+	forceHint  = "(use -f to force removal)"
+	cachedHint = "(use --cached to keep the file, or -f to force removal)"
+)
+
+func (b *RmBuiltin) rm(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := workPrefix(repo)
+	ps, err := addPathspec(repo, prefix, args)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, e := range idx.Entries() {
+		if ps.MatchIndexEntry(e) && (len(names) == 0 || names[len(names)-1] != e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+
+	// every pathspec must match, and directories are only
+	// removed recursively with -r
+	for _, arg := range args {
+		one, _ := pathspec.Parse(prefix, []string{arg}, 0)
+		it := one.Items()[0]
+		matched, recursive := false, false
+		for _, name := range names {
+			if one.Match(name, false) {
+				matched = true
+				if !it.HasWildcards() && name != strings.TrimSuffix(it.Match(), "/") {
+					recursive = true
+				}
+			}
+		}
+		switch {
+		case !matched && !b.flagIgnoreUnmatch:
+			return 0, fmt.Errorf("pathspec '%s' did not match any files", arg)
+		case recursive && !b.flagRecursive:
+			return 0, fmt.Errorf("not removing '%s' recursively without -r", strings.TrimSuffix(it.Original(), "/"))
+		}
+	}
+
+	wt := api.NewWorkTree(repo)
+	if !b.flagForce {
+		ok, err := b.checkLocalChanges(p, repo, wt, idx, names)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 1, nil
+		}
+	}
+
+	for _, name := range names {
+		if !b.flagQuiet {
+			fmt.Fprintf(p.Wout, "rm '%s'\n", name)
+		}
+		if !b.flagDryRun {
+			idx.Remove(name)
+		}
+	}
+	if b.flagDryRun {
+		return 0, nil
+	}
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return 0, err
+	}
+	if !b.flagCached {
+		for _, name := range names {
+			if err = wt.RemoveFile(name); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return 0, nil
+}
+
+// checkLocalChanges makes sure that the files can be removed
+// without losing their contents: that the index matches either
+// HEAD or the working tree, with --cached, and otherwise that it
+// matches both. The files that don't are reported, and false is
+// returned.
+func (b *RmBuiltin) checkLocalChanges(p *Params, repo *api.DiskRepository, wt *api.WorkTree, idx *api.Index, names []string) (bool, error) {
+	head, err := headTree(repo)
+	if err != nil {
+		return false, err
+	}
+	var stagedBoth, stagedIndex, localChanges []string
+	for _, name := range names {
+		e := idx.Entry(name, 0)
+		if e == nil {
+			continue
+		}
+		info, err := wt.Lstat(name)
+		if err != nil || (info.IsDir() && e.Mode() != objects.ModeCommit) {
+			// the file is gone already
+			continue
+		}
+		status, err := wt.Status(e)
+		if err != nil {
+			return false, err
+		}
+		local := status != api.WorkUnmodified
+		staged := true
+		if head != nil {
+			if te, err := api.TreeEntryFromPath(repo, head, name); err == nil {
+				staged = te.Mode() != e.Mode() || te.ObjectId().String() != e.ObjectId().String()
+			}
+		}
+		switch {
+		case local && staged:
+			if !b.flagCached || !e.ExtendedFlags().IntentToAdd() {
+				stagedBoth = append(stagedBoth, name)
+			}
+		case b.flagCached:
+		case staged:
+			stagedIndex = append(stagedIndex, name)
+		case local:
+			localChanges = append(localChanges, name)
+		}
+	}
+	printFiles := func(msgs [2]string, files []string, hint string) {
+		if len(files) == 0 {
+			return
+		}
+		msg := msgs[0]
+		if len(files) > 1 {
+			msg = msgs[1]
+		}
+		fmt.Fprintf(p.Werr, "error: %s\n", msg)
+		for _, name := range files {
+			fmt.Fprintf(p.Werr, "    %s\n", name)
+		}
+		fmt.Fprintln(p.Werr, hint)
+	}
+	printFiles(stagedBothError, stagedBoth, forceHint)
+	printFiles(stagedIndexError, stagedIndex, cachedHint)
+	printFiles(localChangesError, localChanges, cachedHint)
+	return len(stagedBoth)+len(stagedIndex)+len(localChanges) == 0, nil
+}
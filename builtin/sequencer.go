@@ -0,0 +1,716 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/checkout"
+	"github.com/jbrukh/ggit/api/diff"
+	"github.com/jbrukh/ggit/api/objects"
+	"github.com/jbrukh/ggit/api/sequencer"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// REPLAY
+// ================================================================= //
+
+// the files of a cherry-pick or a revert that is in progress
+const (
+	cherryPickHeadFile = "CHERRY_PICK_HEAD"
+	revertHeadFile     = "REVERT_HEAD"
+)
+
+// replayBuiltin is what git-cherry-pick and git-revert share, which
+// replay the changes of commits onto HEAD, or undo them, one commit
+// after another.
+type replayBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	cmd              sequencer.Command
+	flagNoCommit     bool
+	flagRecordOrigin bool
+	flagMainline     string
+	flagAction       string
+}
+
+// initReplay defines the flags that git-cherry-pick and git-revert
+// share.
+func (b *replayBuiltin) initReplay() {
+	b.BoolVar(&b.flagNoCommit, "n", false, "Apply the changes to the index and the working tree without committing them.")
+	b.BoolVar(&b.flagNoCommit, "no-commit", false, "Apply the changes to the index and the working tree without committing them.")
+	b.StringVar(&b.flagMainline, "m", "", "The number of the parent of merge commits that their changes are taken against.")
+	b.StringVar(&b.flagMainline, "mainline", "", "The number of the parent of merge commits that their changes are taken against.")
+	for _, name := range []string{"continue", "skip", "abort", "quit"} {
+		b.Var(modeFlag{&b.flagAction, name}, name, "")
+	}
+	b.Lookup("continue").Usage = "Continue after the conflicts of a commit are resolved."
+	b.Lookup("skip").Usage = "Skip the commit that conflicted, and continue with the rest."
+	b.Lookup("abort").Usage = "Give up, and go back to where HEAD was before."
+	b.Lookup("quit").Usage = "Forget about the commits that are left, keeping what was done."
+
+	b.Usage = func() {}
+}
+
+func (b *replayBuiltin) Execute(p *Params, args []string) {
+	b.flagNoCommit, b.flagRecordOrigin, b.flagMainline, b.flagAction = false, false, "", ""
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil || len(args) == 0 && b.flagAction == "" || len(args) > 0 && b.flagAction != "" {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	opts := &sequencer.Options{NoCommit: b.flagNoCommit, RecordOrigin: b.flagRecordOrigin}
+	if b.flagMainline != "" {
+		if opts.Mainline, err = strconv.Atoi(b.flagMainline); err != nil || opts.Mainline <= 0 {
+			fmt.Fprintln(p.Werr, "error: option `mainline' expects a number greater than zero")
+			p.Status = 129
+			return
+		}
+	}
+	status, err := b.replay(p, opts, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *replayBuiltin) replay(p *Params, opts *sequencer.Options, args []string) (int, error) {
+	if b.flagAction != "" {
+		for _, given := range []struct {
+			set  bool
+			name string
+		}{
+			{opts.NoCommit, "--no-commit"},
+			{opts.RecordOrigin, "-x"},
+			{opts.Mainline > 0, "--mainline"},
+		} {
+			if given.set {
+				return 0, fmt.Errorf("%s: %s cannot be used with --%s", b.HelpInfo.Name, given.name, b.flagAction)
+			}
+		}
+	}
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	switch b.flagAction {
+	case "continue":
+		return b.resume(p, repo)
+	case "skip":
+		return b.skip(p, repo)
+	case "abort":
+		return b.abort(p, repo)
+	case "quit":
+		if err = removeBranchState(repo); err != nil {
+			return 0, err
+		}
+		return 0, sequencer.Remove(repo)
+	}
+
+	commits, single, status, err := b.commits(p, repo, args)
+	if status != 0 || err != nil {
+		return status, err
+	}
+	if single {
+		return b.pick(p, repo, b.cmd, commits[0], opts)
+	}
+
+	// a sequence may not start while another one is in progress
+	if cmd, ok := sequencer.LastCommand(repo); ok {
+		fmt.Fprintf(p.Werr, "error: %s is already in progress\n", replayName(cmd))
+		skip := ""
+		if replayHead(repo) {
+			skip = "--skip | "
+		}
+		fmt.Fprintf(p.Werr, "hint: try \"git %s (--continue | %s--abort | --quit)\"\n", replayName(cmd), skip)
+		return 0, b.failure()
+	}
+	var todo []*sequencer.Instruction
+	for _, c := range commits {
+		todo = append(todo, sequencer.NewInstruction(b.cmd, c))
+	}
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	if b.cmd == sequencer.Revert && headOid == nil {
+		return b.fail(p, "can't revert as initial commit")
+	}
+	if err = sequencer.Start(repo, headOid, todo, opts); err != nil {
+		return 0, err
+	}
+	if err = sequencer.UpdateAbortSafety(repo, headOid); err != nil {
+		return 0, err
+	}
+	return b.pickAll(p, repo, todo, opts)
+}
+
+// replayName returns the name of the command that replays commits
+// by a command of a sequence, as in "cherry-pick".
+func replayName(cmd sequencer.Command) string {
+	if cmd == sequencer.Revert {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// failure is the error that stops a cherry-pick or a revert once
+// what went wrong has been explained.
+func (b *replayBuiltin) failure() error {
+	return fmt.Errorf("%s failed", b.HelpInfo.Name)
+}
+
+// fail explains what went wrong, and stops.
+func (b *replayBuiltin) fail(p *Params, format string, args ...interface{}) (int, error) {
+	fmt.Fprintf(p.Werr, "error: "+format+"\n", args...)
+	return 0, b.failure()
+}
+
+// ================================================================= //
+// REVISIONS
+// ================================================================= //
+
+// commits returns the commits that the arguments name, in the order
+// in which they are replayed, and whether the arguments name a single
+// commit rather than a range. Commits that are named one by one are
+// replayed in the order given, while ranges, as in A..B and ^A B,
+// are walked by date, and picked oldest first.
+func (b *replayBuiltin) commits(p *Params, repo *api.DiskRepository, args []string) ([]*objects.Commit, bool, int, error) {
+	var positive, negative []*objects.Commit
+	for _, arg := range args {
+		revs, excluded := []string{arg}, []bool{false}
+		switch {
+		case strings.Contains(arg, ".."):
+			i := strings.Index(arg, "..")
+			from, to := arg[:i], arg[i+2:]
+			if from == "" {
+				from = api.HeadRef
+			}
+			if to == "" {
+				to = api.HeadRef
+			}
+			revs, excluded = []string{from, to}, []bool{true, false}
+		case strings.HasPrefix(arg, "^"):
+			revs, excluded = []string{arg[1:]}, []bool{true}
+		}
+		for i, rev := range revs {
+			c, status, err := b.revision(p, repo, rev, arg)
+			if status != 0 || err != nil {
+				return nil, false, status, err
+			}
+			if excluded[i] {
+				negative = append(negative, c)
+			} else {
+				positive = append(positive, c)
+			}
+		}
+	}
+	if len(negative) == 0 {
+		return positive, len(args) == 1, 0, nil
+	}
+
+	excluded := make(map[string]bool)
+	err := api.WalkCommits(repo, negative, func(c *objects.Commit) error {
+		excluded[c.ObjectId().String()] = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, 0, err
+	}
+	var commits []*objects.Commit
+	err = api.WalkCommits(repo, positive, func(c *objects.Commit) error {
+		if !excluded[c.ObjectId().String()] {
+			commits = append(commits, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if len(commits) == 0 {
+		status, err := b.fail(p, "empty commit set passed")
+		return nil, false, status, err
+	}
+	if b.cmd == sequencer.Pick {
+		for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+			commits[i], commits[j] = commits[j], commits[i]
+		}
+	}
+	return commits, false, 0, nil
+}
+
+// revision resolves a revision of an argument to a commit.
+func (b *replayBuiltin) revision(p *Params, repo *api.DiskRepository, rev, arg string) (*objects.Commit, int, error) {
+	o, err := api.LookupRevision(repo, rev)
+	if err == nil {
+		o, err = api.PeelObject(repo, o)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad revision '%s'", rev)
+	}
+	c, ok := o.(*objects.Commit)
+	if !ok {
+		status, err := b.fail(p, "%s: can't %s a %s", arg, b.HelpInfo.Name, o.Header().Type())
+		return nil, status, err
+	}
+	return c, 0, nil
+}
+
+// ================================================================= //
+// PICKS
+// ================================================================= //
+
+// pickAll replays the instructions of the sequence in progress one
+// by one, and forgets about the sequence once they are all done. The
+// instructions that are left are kept, with the one that stopped the
+// sequence first.
+func (b *replayBuiltin) pickAll(p *Params, repo *api.DiskRepository, todo []*sequencer.Instruction, opts *sequencer.Options) (int, error) {
+	for len(todo) > 0 {
+		if err := sequencer.WriteTodo(repo, todo); err != nil {
+			return 0, err
+		}
+		if status, err := b.pick(p, repo, todo[0].Command, todo[0].Commit, opts); status != 0 || err != nil {
+			return status, err
+		}
+		todo = todo[1:]
+	}
+	return 0, sequencer.Remove(repo)
+}
+
+// pick replays a commit onto HEAD, by picking or reverting it, and
+// commits the result unless it conflicts, or is not to be committed.
+func (b *replayBuiltin) pick(p *Params, repo *api.DiskRepository, cmd sequencer.Command, c *objects.Commit, opts *sequencer.Options) (int, error) {
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	headRef, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	head, err := headCommit(repo)
+	if err != nil {
+		return 0, err
+	}
+	headTree, err := headTree(repo)
+	if err != nil {
+		return 0, err
+	}
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	// the changes are replayed onto HEAD, which the index must agree
+	// with, unless they are not to be committed, in which case they
+	// are replayed onto the index
+	ours := headTree
+	if !opts.NoCommit {
+		td, err := diff.DiffTreeIndex(repo, headTree, idx, new(diff.Options))
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case unmerged(idx):
+			verb := "Cherry-picking"
+			if cmd == sequencer.Revert {
+				verb = "Reverting"
+			}
+			fmt.Fprint(p.Werr, strings.Replace(unmergedFiles, "Committing", verb, 1))
+			return 0, b.failure()
+		case len(td.Edits()) > 0:
+			fmt.Fprintf(p.Werr, "error: your local changes would be overwritten by %s.\n", b.HelpInfo.Name)
+			fmt.Fprintln(p.Werr, "hint: commit your changes or stash them to proceed.")
+			return 0, b.failure()
+		}
+	}
+	parent, err := sequencer.Parent(repo, c, opts.Mainline)
+	if err != nil {
+		return b.fail(p, "%s", err)
+	}
+	if err = sequencer.UpdateAbortSafety(repo, headOid); err != nil {
+		return 0, err
+	}
+	if opts.NoCommit {
+		oid, err := idx.WriteTree(repo, false)
+		if e, ok := err.(*api.IndexTreeError); ok && len(e.Unmerged) > 0 {
+			for _, e := range idx.Entries() {
+				if e.Stage() != 0 {
+					fmt.Fprintf(p.Werr, "%s: unmerged (%s)\n", e.Name(), e.ObjectId())
+				}
+			}
+			return b.fail(p, "your index file is unmerged.")
+		} else if err != nil {
+			return 0, err
+		}
+		if ours, err = api.TreeFromOid(repo, oid); err != nil {
+			return 0, err
+		}
+	}
+
+	mopts, err := mergeOptions(config)
+	if err != nil {
+		return 0, err
+	}
+	r, err := sequencer.Apply(repo, cmd, c, parent, ours, mopts)
+	if err != nil {
+		return 0, err
+	}
+	ig, err := api.NewStandardIgnorer(repo)
+	if err != nil {
+		return 0, err
+	}
+	err = checkout.SwitchTrees(repo, idx, ours, r.Tree, &checkout.Options{Ignorer: ig, Action: "merge"})
+	if e, ok := err.(*checkout.Error); ok {
+		printCheckoutError(p, e)
+		return 0, b.failure()
+	} else if err != nil {
+		return 0, err
+	}
+	for _, e := range r.Unmerged {
+		idx.Add(e)
+	}
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return 0, err
+	}
+	for _, m := range r.Messages {
+		fmt.Fprintln(p.Wout, m.Text)
+	}
+
+	// the result is kept for the commit that follows, as git-merge
+	// keeps it
+	msg := sequencer.Message(cmd, c, parent, opts.RecordOrigin)
+	if err = writeStateFile(repo, autoMergeFile, r.Tree.ObjectId().String()+"\n"); err != nil {
+		return 0, err
+	}
+	if !r.Clean {
+		msg += "\n# Conflicts:\n"
+		for i, e := range r.Unmerged {
+			if i == 0 || r.Unmerged[i-1].Name() != e.Name() {
+				msg += "#\t" + e.Name() + "\n"
+			}
+		}
+	}
+	if err = writeStateFile(repo, mergeMsgFile, msg); err != nil {
+		return 0, err
+	}
+	oid := c.ObjectId().String() + "\n"
+	switch {
+	case cmd == sequencer.Pick && !opts.NoCommit:
+		err = writeStateFile(repo, cherryPickHeadFile, oid)
+	case cmd == sequencer.Revert && (!r.Clean || opts.NoCommit):
+		err = writeStateFile(repo, revertHeadFile, oid)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !r.Clean {
+		verb := "apply"
+		if cmd == sequencer.Revert {
+			verb = "revert"
+		}
+		fmt.Fprintf(p.Werr, "error: could not %s %s... %s\n", verb, abbrev(c.ObjectId()), sequencer.Subject(c))
+		if opts.NoCommit {
+			fmt.Fprintln(p.Werr, "hint: after resolving the conflicts, mark the corrected paths")
+			fmt.Fprintln(p.Werr, "hint: with 'git add <paths>' or 'git rm <paths>'")
+		} else {
+			name := replayName(cmd)
+			fmt.Fprintf(p.Werr, resolveAdvice, name, name, name, name)
+		}
+		return 1, nil
+	}
+	if opts.NoCommit {
+		return 0, nil
+	}
+
+	// a pick that changes nothing is left to git-commit to refuse
+	if head == nil && len(r.Tree.Entries()) == 0 || head != nil && r.Tree.ObjectId().String() == head.Tree().String() {
+		Commit.Execute(p, nil)
+		return p.Status, nil
+	}
+	committer, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	author := c.Author()
+	if cmd == sequencer.Revert {
+		if author, err = identity(p.Werr, config, api.RoleAuthor); err != nil {
+			return 0, err
+		}
+	}
+	var parents []*objects.ObjectId
+	if head != nil {
+		parents = append(parents, headOid)
+	}
+	msg = cleanupMessage(msg)
+	nc, err := api.WriteCommit(repo, r.Tree.ObjectId(), parents, author, committer, msg)
+	if err != nil {
+		return 0, err
+	}
+	action := os.Getenv("GIT_REFLOG_ACTION")
+	if action == "" {
+		action = replayName(cmd)
+	}
+	subject := strings.SplitN(msg, "\n", 2)[0]
+	if err = api.UpdateRef(repo, api.HeadRef, nc.ObjectId(), headOid, committer, action+": "+subject); err != nil {
+		return 0, err
+	}
+	for _, name := range []string{cherryPickHeadFile, mergeMsgFile} {
+		if err = os.Remove(path.Join(repo.Path(), name)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	return 0, printCommitSummary(p, repo, config, headRef, false, true, nc, head)
+}
+
+// the advice that a conflict gets, given the name of the command
+// that replays commits four times
+const resolveAdvice = `hint: After resolving the conflicts, mark them with
+hint: "git add/rm <pathspec>", then run
+hint: "git %s --continue".
+hint: You can instead skip this commit with "git %s --skip".
+hint: To abort and get back to the state before "git %s",
+hint: run "git %s --abort".
+`
+
+// ================================================================= //
+// SEQUENCES IN PROGRESS
+// ================================================================= //
+
+// resume commits the commit that conflicted, once its conflicts are
+// resolved, and carries on with the sequence in progress, if there
+// is one.
+func (b *replayBuiltin) resume(p *Params, repo *api.DiskRepository) (int, error) {
+	if !sequencer.InProgress(repo) {
+		if !replayHead(repo) {
+			return b.fail(p, "no cherry-pick or revert in progress")
+		}
+		Commit.Execute(p, nil)
+		return p.Status, nil
+	}
+	todo, err := sequencer.ReadTodo(repo)
+	if err != nil {
+		return b.fail(p, "unusable instruction sheet: '%s'", path.Join(api.DefaultGitDir, sequencer.Dir, "todo"))
+	}
+	opts, err := sequencer.ReadOptions(repo)
+	if err != nil {
+		return b.fail(p, "malformed options sheet: '%s'", path.Join(api.DefaultGitDir, sequencer.Dir, "opts"))
+	}
+	if len(todo) > 0 && todo[0].Command != b.cmd {
+		return b.fail(p, "cannot %s during a %s.", b.HelpInfo.Name, replayName(todo[0].Command))
+	}
+	if replayHead(repo) {
+		Commit.Execute(p, nil)
+		if p.Status != 0 {
+			return p.Status, nil
+		}
+	}
+
+	// the commit that conflicted must be committed by now
+	idx, err := readIndex(repo)
+	if err != nil {
+		return 0, err
+	}
+	t, err := headTree(repo)
+	if err != nil {
+		return 0, err
+	}
+	td, err := diff.DiffTreeIndex(repo, t, idx, new(diff.Options))
+	if err != nil {
+		return 0, err
+	}
+	if unmerged(idx) || len(td.Edits()) > 0 {
+		if unmerged(idx) {
+			verb := "Cherry-picking"
+			if b.cmd == sequencer.Revert {
+				verb = "Reverting"
+			}
+			fmt.Fprint(p.Werr, strings.Replace(unmergedFiles, "Committing", verb, 1))
+		} else {
+			fmt.Fprintf(p.Werr, "error: your local changes would be overwritten by %s.\n", b.HelpInfo.Name)
+			fmt.Fprintln(p.Werr, "hint: commit your changes or stash them to proceed.")
+		}
+		return 0, b.failure()
+	}
+	if len(todo) > 0 {
+		todo = todo[1:]
+	}
+	return b.pickAll(p, repo, todo, opts)
+}
+
+// skip drops the commit that conflicted, and carries on with the
+// sequence in progress, if there is one.
+func (b *replayBuiltin) skip(p *Params, repo *api.DiskRepository) (int, error) {
+	file := cherryPickHeadFile
+	if b.cmd == sequencer.Revert {
+		file = revertHeadFile
+	}
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(path.Join(repo.Path(), file)); err != nil {
+		if cmd, ok := sequencer.LastCommand(repo); !ok || cmd != b.cmd {
+			return b.fail(p, "no %s in progress", b.HelpInfo.Name)
+		}
+		if !sequencer.AbortIsSafe(repo, headOid) {
+			fmt.Fprintln(p.Werr, "error: there is nothing to skip")
+			fmt.Fprintln(p.Werr, "hint: have you committed already?")
+			fmt.Fprintf(p.Werr, "hint: try \"git %s --continue\"\n", b.HelpInfo.Name)
+			return 0, b.failure()
+		}
+	}
+	if headOid == nil {
+		return b.fail(p, "failed to skip the commit")
+	}
+	Reset.Execute(p, []string{"--merge", headOid.String()})
+	if p.Status != 0 {
+		return b.fail(p, "failed to skip the commit")
+	}
+	if !sequencer.InProgress(repo) {
+		return 0, nil
+	}
+	return b.resume(p, repo)
+}
+
+// abort gives up on the sequence in progress, and goes back to where
+// HEAD was before it, unless HEAD has moved since.
+func (b *replayBuiltin) abort(p *Params, repo *api.DiskRepository) (int, error) {
+	_, headOid, err := api.Head(repo)
+	if err != nil {
+		return 0, err
+	}
+	if !sequencer.InProgress(repo) {
+		switch {
+		case !replayHead(repo):
+			return b.fail(p, "no cherry-pick or revert in progress")
+		case headOid == nil:
+			return b.fail(p, "cannot abort from a branch yet to be born")
+		}
+		Reset.Execute(p, []string{"--merge", headOid.String()})
+		if p.Status != 0 {
+			return 0, b.failure()
+		}
+		return 0, nil
+	}
+	start, err := sequencer.Head(repo)
+	switch {
+	case err != nil:
+		return b.fail(p, "%s", err)
+	case start == nil:
+		return b.fail(p, "cannot abort from a branch yet to be born")
+	case !sequencer.AbortIsSafe(repo, headOid):
+		fmt.Fprintln(p.Werr, "warning: You seem to have moved HEAD. Not rewinding, check your HEAD!")
+	default:
+		Reset.Execute(p, []string{"--merge", start.String()})
+		if p.Status != 0 {
+			return 0, b.failure()
+		}
+	}
+	return 0, sequencer.Remove(repo)
+}
+
+// replayHead returns true if a single cherry-pick or revert is in
+// progress.
+func replayHead(repo *api.DiskRepository) bool {
+	for _, name := range []string{cherryPickHeadFile, revertHeadFile} {
+		if _, err := os.Stat(path.Join(repo.Path(), name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// cherryPickHead returns the commit that is being cherry-picked, or
+// nil if no cherry-pick is in progress.
+func cherryPickHead(repo *api.DiskRepository) (*objects.Commit, error) {
+	data, err := ioutil.ReadFile(path.Join(repo.Path(), cherryPickHeadFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	oid, err := objects.OidFromString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.New("could not parse CHERRY_PICK_HEAD")
+	}
+	return api.CommitFromOid(repo, oid)
+}
+
+// printSequenceStatus explains, in the status that git-commit shows,
+// which commit is being cherry-picked or reverted, and how to go on.
+// As in git, the commit is not named while a sequence is in progress.
+func printSequenceStatus(p *Params, repo *api.DiskRepository, idx *api.Index) error {
+	last, inSequence := sequencer.LastCommand(repo)
+	var cmd sequencer.Command
+	var oid *objects.ObjectId
+	picked, err := cherryPickHead(repo)
+	if err != nil {
+		return err
+	}
+	switch {
+	case inSequence:
+		cmd = last
+	case picked != nil:
+		cmd, oid = sequencer.Pick, picked.ObjectId()
+	default:
+		data, err := ioutil.ReadFile(path.Join(repo.Path(), revertHeadFile))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if oid, err = objects.OidFromString(strings.TrimSpace(string(data))); err != nil {
+			return errors.New("could not parse REVERT_HEAD")
+		}
+		cmd = sequencer.Revert
+	}
+
+	name := replayName(cmd)
+	switch {
+	case oid == nil && cmd == sequencer.Pick:
+		fmt.Fprintln(p.Wout, "Cherry-pick currently in progress.")
+	case oid == nil:
+		fmt.Fprintln(p.Wout, "Revert currently in progress.")
+	case cmd == sequencer.Pick:
+		fmt.Fprintf(p.Wout, "You are currently cherry-picking commit %s.\n", abbrev(oid))
+	default:
+		fmt.Fprintf(p.Wout, "You are currently reverting commit %s.\n", abbrev(oid))
+	}
+	switch {
+	case unmerged(idx):
+		fmt.Fprintf(p.Wout, "  (fix conflicts and run \"git %s --continue\")\n", name)
+	case oid == nil:
+		fmt.Fprintf(p.Wout, "  (run \"git %s --continue\" to continue)\n", name)
+	default:
+		fmt.Fprintf(p.Wout, "  (all conflicts fixed: run \"git %s --continue\")\n", name)
+	}
+	fmt.Fprintf(p.Wout, "  (use \"git %s --skip\" to skip this patch)\n", name)
+	fmt.Fprintf(p.Wout, "  (use \"git %s --abort\" to cancel the %s operation)\n", name, name)
+	fmt.Fprintln(p.Wout)
+	return nil
+}
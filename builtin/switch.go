@@ -0,0 +1,130 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+)
+
+// ================================================================= //
+// SWITCH
+// ================================================================= //
+
+// SwitchBuiltin implements git-switch, which switches branches.
+type SwitchBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagCreate string
+	flagForce  bool
+	flagQuiet  bool
+	flagDetach bool
+}
+
+var Switch = &SwitchBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "switch",
+		Description: "Switch branches",
+		UsageLine:   "[-q] [-f] ([<branch>] | -c <new-branch> [<start-point>] | --detach [<start-point>])",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Switch.StringVar(&Switch.flagCreate, "c", "", "Create a new branch and switch to it.")
+	Switch.StringVar(&Switch.flagCreate, "create", "", "Create a new branch and switch to it.")
+	Switch.BoolVar(&Switch.flagForce, "f", false, "Throw away local changes.")
+	Switch.BoolVar(&Switch.flagForce, "force", false, "Throw away local changes.")
+	Switch.BoolVar(&Switch.flagForce, "discard-changes", false, "Throw away local changes.")
+	Switch.BoolVar(&Switch.flagQuiet, "q", false, "Suppress feedback messages.")
+	Switch.BoolVar(&Switch.flagQuiet, "quiet", false, "Suppress feedback messages.")
+	Switch.BoolVar(&Switch.flagDetach, "d", false, "Switch to a commit for inspection and discardable experiments.")
+	Switch.BoolVar(&Switch.flagDetach, "detach", false, "Switch to a commit for inspection and discardable experiments.")
+
+	Switch.Usage = func() {}
+
+	// add to command list
+	Add(Switch)
+}
+
+func (b *SwitchBuiltin) Execute(p *Params, args []string) {
+	b.flagCreate, b.flagForce, b.flagQuiet, b.flagDetach = "", false, false, false
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.switchTo(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *SwitchBuiltin) switchTo(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	if len(args) > 1 {
+		return 0, errors.New("only one reference expected")
+	}
+	if b.flagCreate != "" && b.flagDetach {
+		return 0, errors.New("'--detach' cannot be used with '-b/-B/--orphan'")
+	}
+
+	if _, err := repo.Ref("MERGE_HEAD"); err == nil {
+		fmt.Fprintln(p.Werr, "fatal: cannot switch branch while merging")
+		fmt.Fprintln(p.Werr, `Consider "git merge --quit" or "git worktree add".`)
+		return 128, nil
+	}
+
+	var target *switchTarget
+	switch {
+	case len(args) == 1:
+		if target, err = resolveSwitchTarget(repo, args[0]); err != nil {
+			return 0, err
+		}
+		if target == nil {
+			return 0, fmt.Errorf("invalid reference: %s", args[0])
+		}
+	case b.flagCreate != "" || b.flagDetach:
+		if target, err = currentTarget(repo); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, errors.New("missing branch or commit argument")
+	}
+
+	if b.flagDetach {
+		target.branch = ""
+	} else if target.branch == "" && b.flagCreate == "" {
+		// only branches are switched to without --detach
+		kind := "commit"
+		if o, err := api.LookupRevision(repo, target.name); err == nil && o.Header().Type() == objects.ObjectTag {
+			kind = "tag"
+		}
+		fmt.Fprintf(p.Werr, "fatal: a branch is expected, got %s '%s'\n", kind, target.name)
+		fmt.Fprintln(p.Werr, "hint: If you want to detach HEAD at the commit, try again with the --detach option.")
+		return 128, nil
+	}
+	opts := &switchOptions{
+		newBranch: b.flagCreate,
+		force:     b.flagForce,
+		quiet:     b.flagQuiet,
+		detach:    b.flagDetach,
+		hide:      len(args) == 0,
+		advice:    "-c with the switch command",
+	}
+	return switchBranches(p, repo, target, opts)
+}
@@ -0,0 +1,463 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"github.com/jbrukh/ggit/api/objects"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ================================================================= //
+// TAG
+// ================================================================= //
+
+// TagBuiltin implements git-tag, which lists, creates and deletes
+// tags.
+type TagBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagList     bool
+	flagSort     stringsFlag
+	flagLines    optionalFlag
+	flagAnnotate bool
+	flagMessages stringsFlag
+	flagFile     string
+	flagDelete   bool
+	flagForce    bool
+}
+
+var Tag = &TagBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "tag",
+		Description: "Create, list, or delete a tag object",
+		UsageLine:   "[-a] [-f] [-m <msg> | -F <file>] <tagname> [<object>] | -d <tagname>... | [-n[<num>]] -l [--sort=<key>] [<pattern>...]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	Tag.BoolVar(&Tag.flagList, "l", false, "List the tags that match the patterns.")
+	Tag.BoolVar(&Tag.flagList, "list", false, "List the tags that match the patterns.")
+	Tag.Var(&Tag.flagSort, "sort", "Sort the tags by the given key, which is reversed by a \"-\" prefix.")
+	Tag.Var(&Tag.flagLines, "n", "Print the given number of lines of the annotation of each tag.")
+	Tag.BoolVar(&Tag.flagAnnotate, "a", false, "Make an unsigned, annotated tag object.")
+	Tag.BoolVar(&Tag.flagAnnotate, "annotate", false, "Make an unsigned, annotated tag object.")
+	Tag.Var(&Tag.flagMessages, "m", "Use the given tag message, which implies -a.")
+	Tag.Var(&Tag.flagMessages, "message", "Use the given tag message, which implies -a.")
+	Tag.StringVar(&Tag.flagFile, "F", "", "Take the tag message from the given file, which implies -a.")
+	Tag.StringVar(&Tag.flagFile, "file", "", "Take the tag message from the given file, which implies -a.")
+	Tag.BoolVar(&Tag.flagDelete, "d", false, "Delete existing tags.")
+	Tag.BoolVar(&Tag.flagDelete, "delete", false, "Delete existing tags.")
+	Tag.BoolVar(&Tag.flagForce, "f", false, "Replace an existing tag.")
+	Tag.BoolVar(&Tag.flagForce, "force", false, "Replace an existing tag.")
+
+	Tag.Usage = func() {}
+
+	// add to command list
+	Add(Tag)
+}
+
+// matches the number that is stuck to -n, as in -n3
+var linesFlag = regexp.MustCompile(`^-n([0-9]+)$`)
+
+func (b *TagBuiltin) Execute(p *Params, args []string) {
+	b.flagList, b.flagSort, b.flagLines, b.flagAnnotate = false, nil, optionalFlag{}, false
+	b.flagMessages, b.flagFile, b.flagDelete, b.flagForce = nil, "", false, false
+	args = append([]string{}, args...)
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		args[i] = linesFlag.ReplaceAllString(arg, "-n=$1")
+	}
+	args, err := parseInterspersed(&b.FlagSet, args)
+	if err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	// the options of a new tag are no use without its name, or
+	// when tags are listed or deleted
+	creating := b.flagAnnotate || len(b.flagMessages) > 0 || b.flagFile != "" || b.flagForce
+	if creating && (len(args) == 0 || b.flagList || b.flagLines.set || b.flagDelete) {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	status, err := b.tag(p, args)
+	if err != nil {
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		status = 128
+	}
+	p.Status = status
+}
+
+func (b *TagBuiltin) tag(p *Params, args []string) (int, error) {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b.flagDelete:
+		return b.delete(p, repo, args)
+	case len(args) == 0 || b.flagList || b.flagLines.set:
+		return b.list(p, repo, args)
+	case len(args) > 2:
+		return 0, errors.New("too many arguments")
+	}
+	return b.create(p, repo, args)
+}
+
+// ================================================================= //
+// LISTING
+// ================================================================= //
+
+// a tag as it is listed
+type listedTag struct {
+	name   string
+	object objects.Object // what the ref points at
+}
+
+// list lists the tags that match the patterns, if there are any,
+// in the order of --sort.
+func (b *TagBuiltin) list(p *Params, repo *api.DiskRepository, patterns []string) (int, error) {
+	lines := 0
+	if b.flagLines.set {
+		lines = 1
+		if b.flagLines.value != "" {
+			n, err := strconv.Atoi(b.flagLines.value)
+			if err != nil {
+				return 0, errors.New("option `n' expects a numerical value")
+			}
+			lines = n
+		}
+	}
+	var keys []tagSortKey
+	for _, s := range b.flagSort {
+		key, err := parseTagSortKey(s)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+
+	refs, err := repo.Refs()
+	if err != nil {
+		return 0, err
+	}
+	var tags []*listedTag
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Name(), "refs/tags/") {
+			continue
+		}
+		name := strings.TrimPrefix(r.Name(), "refs/tags/")
+		if !matchesAny(patterns, name) {
+			continue
+		}
+		o, err := repo.ObjectFromOid(r.ObjectId())
+		if err != nil {
+			return 0, err
+		}
+		tags = append(tags, &listedTag{name, o})
+	}
+
+	// the last key is the primary one, and the name breaks ties
+	sort.SliceStable(tags, func(i, j int) bool {
+		for k := len(keys) - 1; k >= 0; k-- {
+			if c := keys[k].compare(repo, tags[i], tags[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return tags[i].name < tags[j].name
+	})
+
+	for _, t := range tags {
+		if lines == 0 {
+			fmt.Fprintln(p.Wout, t.name)
+			continue
+		}
+		fmt.Fprintf(p.Wout, "%-15s %s\n", t.name, strings.Join(annotationLines(t.object, lines), "\n    "))
+	}
+	return 0, nil
+}
+
+// annotationLines returns the first lines of the message of a tag,
+// or of the commit that a lightweight tag points at.
+func annotationLines(o objects.Object, n int) []string {
+	var msg string
+	switch t := o.(type) {
+	case *objects.Tag:
+		msg = t.Message()
+	case *objects.Commit:
+		msg = t.Message()
+	}
+	if msg == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(msg, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return lines
+}
+
+// tagSortKey is a key that tags are sorted by.
+type tagSortKey struct {
+	field   string
+	reverse bool
+}
+
+// parseTagSortKey parses the value of --sort, as in "-taggerdate".
+func parseTagSortKey(s string) (tagSortKey, error) {
+	key := tagSortKey{strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")}
+	switch key.field {
+	case "v:refname":
+		key.field = "version:refname"
+	case "refname", "version:refname", "taggerdate", "creatordate", "committerdate", "objectname":
+	default:
+		return key, fmt.Errorf("unknown field name: %s", key.field)
+	}
+	return key, nil
+}
+
+// compare compares two tags by the key.
+func (key tagSortKey) compare(repo *api.DiskRepository, t1, t2 *listedTag) int {
+	var c int
+	switch key.field {
+	case "refname":
+		c = strings.Compare(t1.name, t2.name)
+	case "version:refname":
+		c = compareVersions(t1.name, t2.name)
+	case "objectname":
+		c = strings.Compare(t1.object.ObjectId().String(), t2.object.ObjectId().String())
+	default:
+		c = compareInts(tagDate(t1.object, key.field), tagDate(t2.object, key.field))
+	}
+	if key.reverse {
+		return -c
+	}
+	return c
+}
+
+// tagDate returns the date of a field of the object of a tag, or
+// zero if the object has no such field.
+func tagDate(o objects.Object, field string) int64 {
+	switch t := o.(type) {
+	case *objects.Tag:
+		if field == "taggerdate" || field == "creatordate" {
+			return t.Tagger().Seconds()
+		}
+	case *objects.Commit:
+		if field == "committerdate" || field == "creatordate" {
+			return t.Committer().Seconds()
+		}
+	}
+	return 0
+}
+
+func compareInts(i, j int64) int {
+	switch {
+	case i < j:
+		return -1
+	case i > j:
+		return 1
+	}
+	return 0
+}
+
+// compareVersions compares two names, in which runs of digits are
+// compared as numbers, so that "v1.9" comes before "v1.10".
+func compareVersions(s1, s2 string) int {
+	for s1 != "" && s2 != "" {
+		r1, r2 := leadingRun(s1), leadingRun(s2)
+		s1, s2 = s1[len(r1):], s2[len(r2):]
+		if isDigit(r1[0]) && isDigit(r2[0]) {
+			n1, n2 := strings.TrimLeft(r1, "0"), strings.TrimLeft(r2, "0")
+			if c := compareInts(int64(len(n1)), int64(len(n2))); c != 0 {
+				return c
+			}
+			if c := strings.Compare(n1, n2); c != 0 {
+				return c
+			}
+			continue
+		}
+		if c := strings.Compare(r1, r2); c != 0 {
+			return c
+		}
+	}
+	return compareInts(int64(len(s1)), int64(len(s2)))
+}
+
+// leadingRun returns the run of digits or non-digits that a string
+// starts with.
+func leadingRun(s string) string {
+	digit := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digit {
+		i++
+	}
+	return s[:i]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// ================================================================= //
+// CREATING
+// ================================================================= //
+
+// the advice that is given when a tag is made of a tag
+const nestedTagAdvice = `hint: You have created a nested tag. The object referred to by your new tag is
+hint: already a tag. If you meant to tag the object that it points to, use:
+hint: 
+hint: 	git tag -f %s %s^{}
+hint: Disable this message with "git config advice.nestedTag false"
+`
+
+// create creates a tag of an object, which is HEAD by default. The
+// tag is annotated if it has a message.
+func (b *TagBuiltin) create(p *Params, repo *api.DiskRepository, args []string) (int, error) {
+	name, full := args[0], "refs/tags/"+args[0]
+	rev := api.HeadRef
+	if len(args) > 1 {
+		rev = args[1]
+	}
+	if strings.HasPrefix(name, "-") || !api.ValidRefName(full) {
+		return 0, fmt.Errorf("'%s' is not a valid tag name.", name)
+	}
+	var old *objects.ObjectId
+	if r, err := repo.Ref(full); err == nil {
+		if !b.flagForce {
+			return 0, fmt.Errorf("tag '%s' already exists", name)
+		}
+		old = r.ObjectId()
+	}
+	o, err := api.LookupRevision(repo, rev)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to resolve '%s' as a valid ref.", rev)
+	}
+
+	config, err := api.ReadConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+	who, err := identity(p.Werr, config, api.RoleCommitter)
+	if err != nil {
+		return 0, err
+	}
+	target := o
+	if b.flagAnnotate || len(b.flagMessages) > 0 || b.flagFile != "" {
+		msg, err := b.message(p)
+		if err != nil {
+			return 0, err
+		}
+		if target, err = api.WriteTag(repo, o, name, who, stripComments(msg)); err != nil {
+			return 0, err
+		}
+		if o.Header().Type() == objects.ObjectTag {
+			if advice, err := config.Bool("advice.nestedTag", true); err == nil && advice {
+				fmt.Fprintf(p.Werr, nestedTagAdvice, name, rev)
+			}
+		}
+	}
+
+	msg := reflogTagMessage(repo, o)
+	if err = api.UpdateRef(repo, full, target.ObjectId(), old, who, msg); err != nil {
+		return 0, err
+	}
+	if old != nil && old.String() != target.ObjectId().String() {
+		fmt.Fprintf(p.Wout, "Updated tag '%s' (was %s)\n", name, abbrev(old))
+	}
+	return 0, nil
+}
+
+// message returns the message of an annotated tag, whose paragraphs
+// are given by -m, or which is read by -F.
+func (b *TagBuiltin) message(p *Params) (string, error) {
+	switch {
+	case len(b.flagMessages) > 0:
+		return strings.Join(b.flagMessages, "\n\n"), nil
+	case b.flagFile == "-":
+		data, err := ioutil.ReadAll(p.Rin)
+		if err != nil {
+			return "", errors.New("could not read from standard input")
+		}
+		return string(data), nil
+	case b.flagFile != "":
+		data, err := ioutil.ReadFile(b.flagFile)
+		if err != nil {
+			return "", fmt.Errorf("could not open or read '%s': %s", b.flagFile, describeError(err))
+		}
+		return string(data), nil
+	}
+	// there is no editor to ask for the message with
+	return "", errors.New("no tag message?")
+}
+
+// stripComments cleans up a message, as cleanupMessage does, after
+// it drops the lines that are comments.
+func stripComments(msg string) string {
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return cleanupMessage(strings.Join(lines, "\n"))
+}
+
+// reflogTagMessage describes the tagging of an object, as in
+// "tag: tagging 44410c2 (second, 2011-03-17)", for the log of the
+// tag, if it has one.
+func reflogTagMessage(repo *api.DiskRepository, o objects.Object) string {
+	var desc string
+	switch t := o.(type) {
+	case *objects.Commit:
+		desc = commitSubject(t) + ", " + t.Committer().Time().Format("2006-01-02")
+	case *objects.Tree:
+		desc = "tree object"
+	case *objects.Blob:
+		desc = "blob object"
+	case *objects.Tag:
+		desc = "other tag object"
+	default:
+		desc = "object of unknown type"
+	}
+	return fmt.Sprintf("tag: tagging %s (%s)", abbrev(o.ObjectId()), desc)
+}
+
+// ================================================================= //
+// DELETING
+// ================================================================= //
+
+// delete deletes tags, and says what they pointed at.
+func (b *TagBuiltin) delete(p *Params, repo *api.DiskRepository, names []string) (int, error) {
+	status := 0
+	for _, name := range names {
+		r, err := repo.Ref("refs/tags/" + name)
+		if err != nil {
+			fmt.Fprintf(p.Werr, "error: tag '%s' not found.\n", name)
+			status = 1
+			continue
+		}
+		if err = api.DeleteRef(repo, r.Name(), r.ObjectId()); err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(p.Wout, "Deleted tag '%s' (was %s)\n", name, abbrev(r.ObjectId()))
+	}
+	return status, nil
+}
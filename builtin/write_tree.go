@@ -0,0 +1,118 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+package builtin
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jbrukh/ggit/api"
+	"os"
+)
+
+// ================================================================= //
+// WRITE-TREE
+// ================================================================= //
+
+// WriteTreeBuiltin implements git-write-tree, which stores the
+// index as trees.
+type WriteTreeBuiltin struct {
+	HelpInfo
+	flag.FlagSet
+	flagMissingOk bool
+	flagPrefix    string
+}
+
+var WriteTree = &WriteTreeBuiltin{
+	HelpInfo: HelpInfo{
+		Name:        "write-tree",
+		Description: "Create a tree object from the current index",
+		UsageLine:   "[--missing-ok] [--prefix=<prefix>/]",
+		ManPage:     "TODO",
+	},
+}
+
+func init() {
+	WriteTree.BoolVar(&WriteTree.flagMissingOk, "missing-ok", false, "Don't check that the objects of the index exist.")
+	WriteTree.StringVar(&WriteTree.flagPrefix, "prefix", "", "Write the tree of a subdirectory instead.")
+
+	WriteTree.Usage = func() {}
+
+	// add to command list
+	Add(WriteTree)
+}
+
+// the most unmerged entries that are listed
+const maxUnmergedListed = 10
+
+func (b *WriteTreeBuiltin) Execute(p *Params, args []string) {
+	b.flagMissingOk, b.flagPrefix = false, ""
+	if err := b.Parse(args); err != nil {
+		b.WriteUsage(p.Werr)
+		p.Status = 129
+		return
+	}
+	if err := b.writeTree(p); err != nil {
+		if te, ok := err.(*api.IndexTreeError); ok {
+			for i, e := range te.Unmerged {
+				if i == maxUnmergedListed {
+					fmt.Fprintln(p.Werr, "...")
+					break
+				}
+				fmt.Fprintf(p.Werr, "%s: unmerged (%s)\n", e.Name(), e.ObjectId())
+			}
+			for _, e := range te.Missing {
+				fmt.Fprintf(p.Werr, "error: invalid object %.6o %s for '%s'\n", e.Mode(), e.ObjectId(), e.Name())
+			}
+			err = fmt.Errorf("git-write-tree: error building trees")
+		}
+		fmt.Fprintf(p.Werr, "fatal: %s\n", err)
+		p.Status = 128
+	}
+}
+
+func (b *WriteTreeBuiltin) writeTree(p *Params) error {
+	repo, err := api.AssertDiskRepo(p.Repo)
+	if err != nil {
+		return err
+	}
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lock != nil {
+			lock.Rollback()
+		}
+	}()
+	idx, err := repo.Index()
+	if os.IsNotExist(err) {
+		idx, err = new(api.Index), nil
+	}
+	if err != nil {
+		return fmt.Errorf("git-write-tree: error reading the index")
+	}
+
+	oid, err := idx.WriteTree(repo, b.flagMissingOk)
+	if err != nil {
+		return err
+	}
+	if b.flagPrefix != "" {
+		tree := idx.CachedTree().Tree(b.flagPrefix)
+		if tree == nil {
+			return fmt.Errorf("git-write-tree: prefix %s not found", b.flagPrefix)
+		}
+		oid = tree.Oid
+	}
+
+	// the index keeps the trees that were written
+	if err, lock = repo.WriteIndex(lock, idx), nil; err != nil {
+		return err
+	}
+	fmt.Fprintln(p.Wout, oid)
+	return nil
+}
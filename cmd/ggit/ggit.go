@@ -76,11 +76,14 @@ func main() {
 			fmt.Fprintln(Werr, msgNotARepo)
 			os.Exit(1)
 		}
-		cmd.Execute(&builtin.Params{
-			repo,
-			os.Stdout,
-			os.Stderr,
-		}, args)
+		p := &builtin.Params{
+			Repo: repo,
+			Rin:  os.Stdin,
+			Wout: os.Stdout,
+			Werr: os.Stderr,
+		}
+		cmd.Execute(p, args)
+		os.Exit(p.Status)
 	} else {
 		fmt.Fprintf(os.Stderr, fmtUnknownCommand, name)
 		usage()
@@ -0,0 +1,106 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_algorithms.go implements a repo test case, which contains two
+commits of a file whose diff the line diff algorithms disagree on.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+	"strings"
+)
+
+// ================================================================= //
+// TEST CASE: DIFF ALGORITHMS
+// ================================================================= //
+
+type InfoAlgorithms struct {
+	Before string // main.go at the first commit
+	After  string // main.go at the second commit
+}
+
+// code in which two functions trade places, and which has
+// many lines that are the same, which is where the algorithms
+// disagree
+var (
+	algorithmsBefore = `package main
+
+func a() {
+	x := 1
+	if x > 0 {
+		return
+	}
+}
+
+func b() {
+	y := 2
+	if y > 0 {
+		return
+	}
+}
+
+func c() {
+	z := 3
+}
+` + strings.Repeat("}\n", 70)
+
+	algorithmsAfter = `package main
+
+func b() {
+	y := 2
+	if y > 0 {
+		return
+	}
+}
+
+func a() {
+	x := 1
+	if x > 0 {
+		return
+	}
+}
+
+func c() {
+	z := 4
+}
+` + strings.Repeat("}\n", 69) + "// the end\n}\n"
+)
+
+// Algorithms has the commits of main.go before and after, HEAD~
+// and HEAD.
+var Algorithms = NewRepoTestCase(
+	"__diff_algorithms",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		if err = util.TestFile(repo, "main.go", algorithmsBefore); err != nil {
+			return err
+		}
+		if err = util.GitExecMany(repo,
+			[]string{"add", "--all"},
+			[]string{"commit", "-m", "first"},
+		); err != nil {
+			return err
+		}
+		if err = util.TestFile(repo, "main.go", algorithmsAfter); err != nil {
+			return err
+		}
+		testCase.info = &InfoAlgorithms{
+			Before: algorithmsBefore,
+			After:  algorithmsAfter,
+		}
+		return util.GitExecMany(repo,
+			[]string{"add", "--all"},
+			[]string{"commit", "-m", "second"},
+		)
+	},
+)
@@ -0,0 +1,74 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_am.go implements a repo test case, which contains a mailbox of
+patches, one of which conflicts with the commit that is checked out.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+	"io/ioutil"
+	"path"
+)
+
+// ================================================================= //
+// TEST CASE: A MAILBOX TO APPLY
+// ================================================================= //
+
+type InfoAm struct {
+	Mbox string // the mailbox, which is also at .git/mbox
+}
+
+// Am has a mailbox of the patches of three commits, which are gone
+// from its history. Its last commit changes a, as the second patch
+// does, so that the second patch conflicts.
+var Am = NewRepoTestCase(
+	"__am",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		commit := func(name, contents string, args ...string) error {
+			if err := util.TestFile(repo, name, contents); err != nil {
+				return err
+			}
+			return util.GitExecMany(repo, []string{"add", "--all"}, append([]string{"commit", "-q"}, args...))
+		}
+		commits := [][]string{
+			{"a", "a\n", "-m", "add a"},
+			{"b", "b\n", "-m", "add b", "-m", "with a body"},
+			{"a", "c\n", "--author", "A U Thor <author@example.com>", "-m", "change a"},
+			{"d", "d\n", "-m", "add d"},
+		}
+		for _, c := range commits {
+			if err = commit(c[0], c[1], c[2:]...); err != nil {
+				return err
+			}
+		}
+		mbox, err := util.GitExec(repo, "format-patch", "--stdout", "HEAD~3")
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(path.Join(repo, ".git", "mbox"), []byte(mbox), 0666); err != nil {
+			return err
+		}
+		if err = util.GitExecMany(repo, []string{"reset", "-q", "--hard", "HEAD~3"}); err != nil {
+			return err
+		}
+		if err = commit("a", "x\n", "-m", "conflict in a"); err != nil {
+			return err
+		}
+		testCase.info = &InfoAm{
+			Mbox: mbox,
+		}
+		return nil
+	},
+)
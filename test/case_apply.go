@@ -0,0 +1,101 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_apply.go implements a repo test case, which contains two commits
+and the patch between them, with a rename, a binary file and a change of
+mode.
+*/
+package test
+
+import (
+	"errors"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// TEST CASE: A PATCH TO APPLY
+// ================================================================= //
+
+type InfoApply struct {
+	Source string // the contents of main.txt at the first commit
+	Patch  string // the patch from first to second, as git makes it
+}
+
+var applySource = strings.Repeat("a line that is the same\n", 10) +
+	"one\ntwo\nthree\n" + strings.Repeat("another line that is the same\n", 10)
+
+// Apply has the commits first and second, which are tagged so,
+// and has the first one checked out.
+var Apply = NewRepoTestCase(
+	"__apply",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		files := map[string]string{
+			"main.txt":  applySource,
+			"gone.txt":  "bye\n",
+			"old.txt":   applySource,
+			"run.sh":    "echo\n",
+			"image.bin": "\x00an image\n",
+		}
+		if err = writeFiles(repo, files); err != nil {
+			return err
+		}
+		if err = util.GitExecMany(repo,
+			[]string{"add", "--all"},
+			[]string{"commit", "-m", "first"},
+			[]string{"tag", "first"},
+		); err != nil {
+			return err
+		}
+
+		files = map[string]string{
+			"main.txt":    strings.Replace(applySource, "two", "TWO", 1),
+			"sub/new.txt": strings.Replace(applySource, "one", "ONE", 1),
+			"image.bin":   "\x00an image\x00that changed\n",
+			"added.txt":   "hello\n",
+		}
+		if err = writeFiles(repo, files); err != nil {
+			return err
+		}
+		for _, name := range []string{"gone.txt", "old.txt"} {
+			if err = util.DeleteFile(repo, name); err != nil {
+				return err
+			}
+		}
+		if err = os.Chmod(path.Join(repo, "run.sh"), 0755); err != nil {
+			return err
+		}
+		if err = util.GitExecMany(repo,
+			[]string{"add", "--all"},
+			[]string{"commit", "-m", "second"},
+			[]string{"tag", "second"},
+		); err != nil {
+			return err
+		}
+		patch, err := util.GitExec(repo, "diff", "--binary", "-M", "first", "second")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(patch, "rename from old.txt") {
+			return errors.New("expected a rename")
+		}
+		_, err = util.GitExec(repo, "reset", "--hard", "first")
+		testCase.info = &InfoApply{
+			Source: applySource,
+			Patch:  patch,
+		}
+		return err
+	},
+)
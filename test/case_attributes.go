@@ -0,0 +1,36 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_attributes.go implements a repo test case, which contains
+gitattributes files at the top, in a subdirectory and in .git/info.
+*/
+package test
+
+// ================================================================= //
+// TEST CASE: GITATTRIBUTES
+// ================================================================= //
+
+// Attributes has no commits, only the gitattributes files, which
+// set, unset, unspecify and give values to attributes, and define
+// a macro.
+var Attributes = NewRepoTestCase(
+	"__attributes",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		files := map[string]string{
+			".gitattributes":       "[attr]mine text eol=lf\n*.txt text\n*.png binary diff\n*.c mine\n/top.c -text\n",
+			"sub/.gitattributes":   "*.txt -text\nx.* !text foo=bar\n",
+			".git/info/attributes": "important.txt diff=custom\n",
+		}
+		return writeFiles(repo, files)
+	},
+)
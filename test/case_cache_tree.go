@@ -0,0 +1,47 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_cache_tree.go implements a repo test case, which contains nested
+directories that are staged, and whose index has cached trees.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: AN INDEX WITH CACHED TREES
+// ================================================================= //
+
+// CacheTree has files staged but no commits; its index has the
+// cached trees that git-write-tree leaves behind.
+var CacheTree = NewRepoTestCase(
+	"__cache_tree",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		files := map[string]string{
+			"a/b/f": "f\n",
+			"a/g":   "g\n",
+			"a-b":   "ab\n",
+			"c/h":   "h\n",
+			"top":   "top\n",
+		}
+		if err = writeFiles(repo, files); err != nil {
+			return err
+		}
+		return util.GitExecMany(repo,
+			[]string{"add", "--all"},
+			[]string{"write-tree"},
+		)
+	},
+)
@@ -0,0 +1,60 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_checkout.go implements a repo test case, which contains two branches
+to switch between.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: TWO BRANCHES TO CHECK OUT
+// ================================================================= //
+
+// Checkout has a first commit with a, b and d/c, after which
+// master changes b, and the branch other adds z and e/f. It has
+// master checked out.
+var Checkout = NewRepoTestCase(
+	"__checkout",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		steps := []struct {
+			files map[string]string
+			cmds  [][]string
+		}{
+			{
+				map[string]string{"a": "a\n", "b": "b\n", "d/c": "c\n"},
+				[][]string{{"add", "."}, {"commit", "-m", "first"}, {"branch", "other"}},
+			},
+			{
+				map[string]string{"b": "b2\n"},
+				[][]string{{"commit", "-a", "-m", "second"}, {"checkout", "other"}},
+			},
+			{
+				map[string]string{"z": "z\n", "e/f": "f\n"},
+				[][]string{{"add", "z", "e/f"}, {"commit", "-m", "third"}, {"checkout", "master"}},
+			},
+		}
+		for _, step := range steps {
+			if err = writeFiles(repo, step.files); err != nil {
+				return err
+			}
+			if err = util.GitExecMany(repo, step.cmds...); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+)
@@ -0,0 +1,57 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_config.go implements a repo test case, whose configuration file
+has comments, quoting, escapes and continued lines.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: A CONFIGURATION FILE
+// ================================================================= //
+
+const testConfig = `# a comment
+[core]
+	bare = false ; another comment
+	Editor = "vim -f"
+[Diff]
+	algorithm = histogram
+	renameLimit = 2k
+[remote "Origin"]
+	url = http://example.com/a.git  # trailing comment
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[branch.Master]
+	remote = origin
+[weird]
+	spaces =   a   b	c
+	quoted = "  kept  " and "#not a comment"
+	escapes = tab\there\\ \"q\"
+	continued = one \
+two
+	flag
+`
+
+// Config has no commits, and its .git/config is replaced by one
+// with sections and subsections in mixed case, and values that are
+// hard to read.
+var Config = NewRepoTestCase(
+	"__config",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		return util.TestFile(repo, ".git/config", testConfig)
+	},
+)
@@ -0,0 +1,64 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_conflict.go implements a repo test case, which contains a merge that
+stopped at conflicts.
+*/
+package test
+
+import (
+	"errors"
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: A MERGE WITH CONFLICTS
+// ================================================================= //
+
+// Conflict is in the middle of the merge of the branch theirs
+// into master, whose index has conflicts on f, which both sides
+// changed, on g, which both sides added, and on h, which their
+// side deleted.
+var Conflict = NewRepoTestCase(
+	"__conflict",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		steps := []struct {
+			setup []string
+			files map[string]string
+		}{
+			{nil, map[string]string{"f": "f\n", "h": "h\n", "same": "same\n"}},
+			{[]string{"checkout", "-qb", "theirs"}, map[string]string{"f": "theirs\n", "g": "theirs\n", "same": "same\n"}},
+			{[]string{"checkout", "-q", "master"}, map[string]string{"f": "ours\n", "g": "ours\n", "h": "ours\n", "same": "same\n"}},
+		}
+		for _, step := range steps {
+			if step.setup != nil {
+				if err = util.GitExecMany(repo, step.setup); err != nil {
+					return err
+				}
+			}
+			if err = replaceFiles(repo, step.files); err != nil {
+				return err
+			}
+			if err = util.GitExecMany(repo,
+				[]string{"add", "--all"},
+				[]string{"commit", "-q", "-m", "commit"},
+			); err != nil {
+				return err
+			}
+		}
+		if _, err = util.GitExec(repo, "merge", "-q", "theirs"); err == nil {
+			return errors.New("expected conflicts")
+		}
+		return nil
+	},
+)
@@ -0,0 +1,68 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_criss_cross.go implements a repo test case, which contains branches
+whose merge bases conflict, and a branch that is unrelated to them.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: CRISS-CROSS MERGES
+// ================================================================= //
+
+// CrissCross has the branches ours and theirs, which merged each
+// other at the tag ours1 and at theirs^2, and resolved the conflict
+// of the other one differently, and the branch unrelated, whose root
+// is an orphan.
+var CrissCross = NewRepoTestCase(
+	"__criss_cross",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		steps := []struct {
+			setup [][]string
+			files map[string]string
+		}{
+			{nil, map[string]string{"a": numbers(), "b": "b\n"}},
+			{[][]string{{"checkout", "-qb", "theirs"}}, map[string]string{"a": numbers("2", "theirs"), "b": "b\n"}},
+			{[][]string{{"checkout", "-qb", "ours", "HEAD~"}}, map[string]string{"a": numbers("2", "ours"), "b": "b\n"}},
+			// each side resolves the conflict of the other one differently
+			{
+				[][]string{{"tag", "ours1"}, {"merge", "-q", "-s", "ours", "--no-commit", "theirs"}},
+				map[string]string{"a": numbers("2", "OURS"), "b": "b2\n"},
+			},
+			{
+				[][]string{{"checkout", "-q", "theirs"}, {"merge", "-q", "-s", "ours", "--no-commit", "ours1"}},
+				map[string]string{"a": numbers("2", "THEIRS"), "b": "b\n", "c": "c\n"},
+			},
+			{[][]string{{"checkout", "-q", "--orphan", "unrelated"}}, map[string]string{"b": "unrelated\n", "d": "d\n"}},
+		}
+		for _, step := range steps {
+			if err = util.GitExecMany(repo, step.setup...); err != nil {
+				return err
+			}
+			if err = replaceFiles(repo, step.files); err != nil {
+				return err
+			}
+			if err = util.GitExecMany(repo,
+				[]string{"add", "--all"},
+				[]string{"commit", "-q", "--allow-empty", "-m", "commit"},
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+)
@@ -0,0 +1,62 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_mails.go implements a repo test case, which contains commits whose
+authors and messages are hard to put in emails.
+*/
+package test
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: COMMITS TO MAIL
+// ================================================================= //
+
+type InfoMails struct {
+	Revs []string // the revisions of the commits, oldest first
+}
+
+// the authors and the messages of the commits
+var mailCommits = []struct{ author, msg string }{
+	{"A U Thor <author@example.com>", "first"},
+	{"A. U. Thor <author@example.com>", "second: a fairly long subject line that goes on and on\nand on beyond the limit of seventy-eight\n\n  with a body  \n\n\nof paragraphs\n\n"},
+	{"Jöhn Dœ <john@example.com>", "thïrd subject"},
+	{"Jöhn Dœ <john@example.com>", "an ASCII subject with =?encoded?= words\n\nand a bödy"},
+	{"A U Thor <author@example.com>", "Re: [RFC] .. dots...and [brackets]!"},
+}
+
+// Mails has a commit of each of the mailCommits, whose messages are
+// kept verbatim.
+var Mails = NewRepoTestCase(
+	"__mails",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		info := new(InfoMails)
+		for i, c := range mailCommits {
+			if err = util.TestFile(repo, "file", fmt.Sprintf("%d\n", i)); err != nil {
+				return err
+			}
+			if err = util.GitExecMany(repo,
+				[]string{"add", "--all"},
+				[]string{"commit", "--cleanup=verbatim", "--author", c.author, "-m", c.msg},
+			); err != nil {
+				return err
+			}
+			info.Revs = append([]string{fmt.Sprintf("HEAD~%d", i)}, info.Revs...)
+		}
+		testCase.info = info
+		return nil
+	},
+)
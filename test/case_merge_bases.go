@@ -0,0 +1,86 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_merge_bases.go implements a repo test case, which contains a history
+with criss-cross merges, an unrelated root, and a branch that was rewritten
+after another forked from it.
+*/
+package test
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/util"
+	"os"
+)
+
+// ================================================================= //
+// TEST CASE: A HISTORY OF MERGE BASES
+// ================================================================= //
+
+// MergeBases has the branches x and y, which merge each other
+// criss-cross from the tag B, z, which forks from master^, lonely,
+// whose root is unrelated, and topic, which forks from up before up
+// is rewritten. Commits are a minute apart, except for the ones of
+// the criss-cross merges, which have the same date.
+var MergeBases = NewRepoTestCase(
+	"__merge_bases",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+
+		date := 1300000000
+		defer os.Setenv("GIT_COMMITTER_DATE", os.Getenv("GIT_COMMITTER_DATE"))
+		steps := [][]string{
+			{"commit", "-q", "--allow-empty", "-m", "A"},
+			{"commit", "-q", "--allow-empty", "-m", "B"},
+			{"tag", "B"},
+			{"checkout", "-q", "-b", "x"},
+			{"commit", "-q", "--allow-empty", "-m", "X1"},
+			{"checkout", "-q", "-b", "y", "B"},
+			{"commit", "-q", "--allow-empty", "-m", "Y1"},
+			nil,
+			{"merge", "-q", "--no-ff", "-m", "Y2", "x"},
+			{"checkout", "-q", "x"},
+			nil,
+			{"merge", "-q", "--no-ff", "-m", "X2", "y^"},
+			{"checkout", "-q", "-b", "z", "master^"},
+			{"commit", "-q", "--allow-empty", "-m", "Z1"},
+			{"checkout", "-q", "--orphan", "lonely"},
+			{"commit", "-q", "--allow-empty", "-m", "L"},
+
+			// topic forks from up, which is rewritten afterwards
+			{"checkout", "-q", "-b", "up", "B"},
+			{"commit", "-q", "--allow-empty", "-m", "U1"},
+			{"checkout", "-q", "-b", "topic"},
+			{"commit", "-q", "--allow-empty", "-m", "T1"},
+			{"checkout", "-q", "up"},
+			{"commit", "-q", "--allow-empty", "--amend", "-m", "U1'"},
+			{"commit", "-q", "--allow-empty", "-m", "U2"},
+			{"checkout", "-q", "topic"},
+		}
+		for _, step := range steps {
+			// nil steps take a minute back, so that the next
+			// commit has the date of the one before
+			if step == nil {
+				date -= 60
+				continue
+			}
+			os.Setenv("GIT_COMMITTER_DATE", fmt.Sprintf("%d +0000", date))
+			if err = util.GitExecMany(repo, step); err != nil {
+				return err
+			}
+			if step[0] != "checkout" {
+				date += 60
+			}
+		}
+		return nil
+	},
+)
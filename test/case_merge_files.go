@@ -0,0 +1,64 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_merge_files.go implements a repo test case, which contains the
+sides of three-way merges of file contents, for git-merge-file.
+*/
+package test
+
+import (
+	"fmt"
+	"path"
+)
+
+// ================================================================= //
+// TEST CASE: FILES TO MERGE
+// ================================================================= //
+
+type InfoMergeFiles struct {
+	Sides [][3]string // the sides of each merge, as base, ours and theirs
+}
+
+// the sides of merges, as base, ours and theirs
+var mergeFileSides = [][3]string{
+	// changes that don't overlap
+	{"1\n2\n3\n4\n5\n6\n7\n8\n", "1\nTWO\n3\n4\n5\n6\n7\n8\n", "1\n2\n3\n4\n5\n6\nSEVEN\n8\n"},
+	// the same change on both sides
+	{"1\n2\n3\n", "1\nTWO\n3\n", "1\nTWO\n3\n"},
+	// conflicts that share lines at their ends
+	{"a\nb\nc\nd\ne\n", "a\nx\nb\nC\nd\ny\ne\n", "a\nx\nb\nc!\nd\ny\ne\n"},
+	// conflicts that are only separated by lines without letters
+	{"f(\n1\n}\n}\n}\n}\n2\n)\n", "f(\nA\n}\n}\n}\n}\nB\n)\n", "f(\nX\n}\n}\n}\n}\nY\n)\n"},
+	// no newline at the end, and lines that end in CR LF
+	{"a\r\nb\r\nc", "a\r\nB\r\nc", "a\r\nb!\r\nC"},
+	// both sides added the file
+	{"", "ours\n", "theirs\n"},
+}
+
+// MergeFiles has no commits; the sides of the i-th merge are the
+// files base, ours and theirs in the directory named i.
+var MergeFiles = NewRepoTestCase(
+	"__diff_merge",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		files := make(map[string]string)
+		for i, sides := range mergeFileSides {
+			for j, name := range []string{"base", "ours", "theirs"} {
+				files[path.Join(fmt.Sprint(i), name)] = sides[j]
+			}
+		}
+		testCase.info = &InfoMergeFiles{
+			Sides: mergeFileSides,
+		}
+		return writeFiles(repo, files)
+	},
+)
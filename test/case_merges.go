@@ -0,0 +1,191 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_merges.go implements a repo test case, which contains the sides of
+merges of all sorts, each in a history of its own.
+*/
+package test
+
+import (
+	"fmt"
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// TEST CASE: SIDES OF MERGES
+// ================================================================= //
+
+type InfoMerges struct {
+	Names []string // the names of the merges, in order
+}
+
+// the files of the sides of a merge, by path; contents that start
+// with "link:" make symlinks, and contents that start with "exec:"
+// make executable files
+type mergeCase struct {
+	name               string
+	base, ours, theirs map[string]string
+}
+
+var mergeCases = []mergeCase{
+	{
+		"clean",
+		map[string]string{"a": numbers(), "dir/b": "b\n", "gone": "gone\n", "same": "same\n"},
+		map[string]string{"a": numbers("2", "two"), "dir/b": "b\n", "dir/c": "c\n", "same": "same\n"},
+		map[string]string{"a": numbers("19", "nineteen"), "dir/b": "b\n", "gone": "gone\n", "same": "same\n", "new": "new\n"},
+	},
+	{
+		"contents",
+		map[string]string{"a": numbers(), "md": "md\n", "run": "echo\n", "bin": "\x00bin\n"},
+		map[string]string{"a": numbers("3", "three"), "run": "exec:echo\n", "bin": "\x00ours\n", "both": "ours\n"},
+		map[string]string{"a": numbers("3", "THREE"), "md": "changed\n", "run": "echo hi\n", "bin": "\x00theirs\n", "both": "theirs\n"},
+	},
+	{
+		"renames",
+		map[string]string{"a": numbers(), "b": numbers("1", "b"), "c": numbers("1", "c"), "d": numbers("1", "d"), "e": numbers("1", "e")},
+		map[string]string{"a2": numbers("1", "one"), "b": numbers("1", "b", "20", "twenty"), "c2": numbers("1", "c"), "e2": numbers("1", "e", "2", "two")},
+		map[string]string{"a": numbers("1", "ONE"), "b2": numbers("1", "b"), "c3": numbers("1", "c", "5", "five"), "e2": numbers("1", "e", "19", "nineteen")},
+	},
+	{
+		"rename-rename",
+		map[string]string{"f": numbers(), "g": numbers("1", "g"), "i": numbers("1", "i"), "bin": "\x00" + numbers()},
+		map[string]string{"f1": numbers("2", "two"), "h": numbers("1", "g", "2", "two"), "i2": numbers("1", "i", "2", "two"), "bin1": "\x00" + numbers("2", "two")},
+		map[string]string{"f2": numbers("2", "TWO"), "g": numbers("1", "g", "19", "nineteen"), "h": "h\n", "i2": numbers("1", "i", "2", "TWO"), "bin2": "\x00" + numbers("2", "TWO")},
+	},
+	{
+		"rename-delete",
+		map[string]string{"a": numbers(), "b": numbers("1", "b")},
+		map[string]string{"a2": numbers("1", "one"), "b2": numbers("1", "b"), "b": "replaced\n"},
+		map[string]string{"b": numbers("1", "b", "2", "two"), "a2": "added\n"},
+	},
+	{
+		"pure-rename-delete",
+		map[string]string{"a": numbers(), "b": numbers("1", "b")},
+		map[string]string{"a2": numbers(), "b": numbers("1", "b")},
+		map[string]string{"b2": numbers("1", "b")},
+	},
+	{
+		"file-directory",
+		map[string]string{"d1": "y\n", "d2": "z\n", "d3": "w\n", "dir/f": "f\n"},
+		map[string]string{"d1/g": "q\n", "d2/g": "q\n", "d3": "w\n", "dir/f": "f\n"},
+		map[string]string{"d1": "y2\n", "d2": "z\n", "d3/h": "h\n", "dir": "file\n"},
+	},
+	{
+		"directories",
+		map[string]string{"a/b/c": "c\n", "a/b/d": "d\n", "a/e": "e\n", "x/y": "y\n"},
+		map[string]string{"a/b/c": "c2\n", "a/b/d": "d\n", "a/e": "e\n", "n/m": "m\n"},
+		map[string]string{"a/b/d": "d2\n", "a/e": "e2\n", "x/y/z": "z\n", "n/o": "o\n"},
+	},
+	{
+		"types",
+		map[string]string{"a": "a\n", "b": "b\n"},
+		map[string]string{"a": "link:target", "b": "b\n", "c": "link:c"},
+		map[string]string{"a": "a2\n", "b": "link:b", "c": "c\n"},
+	},
+}
+
+// Merges has, for each merge, the base of its sides at the tag
+// <name>/base, and its sides at the branches <name>/ours and
+// <name>/theirs. The histories of the merges are unrelated.
+var Merges = NewRepoTestCase(
+	"__merges",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		info := new(InfoMerges)
+		for _, c := range mergeCases {
+			sides := []struct {
+				files map[string]string
+				setup []string
+				after []string
+			}{
+				{c.base, []string{"checkout", "-q", "--orphan", c.name + "/ours"}, []string{"tag", c.name + "/base"}},
+				{c.ours, nil, nil},
+				{c.theirs, []string{"checkout", "-qb", c.name + "/theirs", c.name + "/base"}, nil},
+			}
+			for _, side := range sides {
+				if side.setup != nil {
+					if err = util.GitExecMany(repo, side.setup); err != nil {
+						return err
+					}
+				}
+				if err = replaceFiles(repo, side.files); err != nil {
+					return err
+				}
+				if err = util.GitExecMany(repo,
+					[]string{"add", "--all"},
+					[]string{"commit", "-q", "--allow-empty", "-m", c.name},
+				); err != nil {
+					return err
+				}
+				if side.after != nil {
+					if err = util.GitExecMany(repo, side.after); err != nil {
+						return err
+					}
+				}
+			}
+			info.Names = append(info.Names, c.name)
+		}
+		testCase.info = info
+		return nil
+	},
+)
+
+// numbers returns the lines 1 to 20, with some of them replaced,
+// as in numbers("3", "three"), so that files are similar enough to
+// be renames of each other.
+func numbers(replace ...string) string {
+	lines := make([]string, 20)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i + 1)
+	}
+	s := "\n" + strings.Join(lines, "\n") + "\n"
+	for i := 0; i+1 < len(replace); i += 2 {
+		s = strings.Replace(s, "\n"+replace[i]+"\n", "\n"+replace[i+1]+"\n", 1)
+	}
+	return s[1:]
+}
+
+// replaceFiles replaces the files of the working tree and of the
+// index of a repo with the given ones, whose contents are as in
+// mergeCase.
+func replaceFiles(repo string, files map[string]string) error {
+	if _, err := util.GitExec(repo, "rm", "-rfq", "--ignore-unmatch", "."); err != nil {
+		return err
+	}
+	for name, contents := range files {
+		pth := path.Join(repo, name)
+		switch {
+		case strings.HasPrefix(contents, "link:"):
+			if err := os.MkdirAll(path.Dir(pth), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(contents[len("link:"):], pth); err != nil {
+				return err
+			}
+		case strings.HasPrefix(contents, "exec:"):
+			if err := util.TestFile(repo, name, contents[len("exec:"):]); err != nil {
+				return err
+			}
+			if err := os.Chmod(pth, 0755); err != nil {
+				return err
+			}
+		default:
+			if err := util.TestFile(repo, name, contents); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
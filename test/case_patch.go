@@ -0,0 +1,115 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_patch.go implements a repo test case, which contains two commits
+whose patch has hunks of all sorts.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+	"strings"
+)
+
+// ================================================================= //
+// TEST CASE: TWO COMMITS TO PATCH
+// ================================================================= //
+
+type InfoPatch struct {
+	Source string // the contents of main.go at the first commit
+}
+
+// a file with a few functions, to exercise hunk
+// merging and function headers
+var patchSource = `package main
+
+import "fmt"
+
+func one() {
+	fmt.Println("one")
+	fmt.Println("two")
+	fmt.Println("three")
+}
+
+func two() {
+	for i := 0; i < 10; i++ {
+		fmt.Println(i)
+	}
+}
+
+func three() {
+	fmt.Println("a")
+	fmt.Println("b")
+	fmt.Println("c")
+	fmt.Println("d")
+	fmt.Println("e")
+}
+`
+
+// Patch has the commits first and second, which are tagged so,
+// and has the first one checked out.
+var Patch = NewRepoTestCase(
+	"__patch",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		files := map[string]string{
+			"main.go":          patchSource,
+			"nonl.txt":         "a\nb\nc",
+			"gone.txt":         "bye\n",
+			"sub/dir/deep.txt": "1\n2\n3\n",
+			"run.sh":           "echo\n",
+			"sp ace.txt":       "x\n",
+		}
+		if err = writeFiles(repo, files); err != nil {
+			return err
+		}
+		if err = util.GitExecMany(repo,
+			[]string{"add", "--all"},
+			[]string{"commit", "-m", "first"},
+			[]string{"tag", "first"},
+		); err != nil {
+			return err
+		}
+
+		changed := strings.Replace(patchSource, `"two"`, `"TWO"`, 1)
+		changed = strings.Replace(changed, "\tfmt.Println(\"c\")\n", "", 1)
+		changed = strings.Replace(changed, "\tfmt.Println(\"e\")\n", "\tfmt.Println(\"e\")\n\tfmt.Println(\"f\")\n", 1)
+		files = map[string]string{
+			"main.go":          changed,
+			"nonl.txt":         "a\nB\nc",
+			"sub/dir/deep.txt": "1\n2\n3\n4\n",
+			"sub/new.txt":      "new\n",
+			"sp ace.txt":       "y\n",
+		}
+		if err = writeFiles(repo, files); err != nil {
+			return err
+		}
+		if err = util.DeleteFile(repo, "gone.txt"); err != nil {
+			return err
+		}
+		if err = os.Chmod(path.Join(repo, "run.sh"), 0755); err != nil {
+			return err
+		}
+		err = util.GitExecMany(repo,
+			[]string{"add", "--all"},
+			[]string{"commit", "-m", "second"},
+			[]string{"tag", "second"},
+			[]string{"reset", "-q", "--hard", "first"},
+		)
+		testCase.info = &InfoPatch{
+			Source: patchSource,
+		}
+		return err
+	},
+)
@@ -0,0 +1,68 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_picks.go implements a repo test case, which contains commits to
+cherry-pick and to revert.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: COMMITS TO PICK
+// ================================================================= //
+
+// Picks has a master with commits that add and change files, and a
+// merge of the side branch, and the branch other, which starts at
+// the first commit and conflicts with some of them. It has other
+// checked out.
+var Picks = NewRepoTestCase(
+	"__picks",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		steps := []struct {
+			setup          [][]string
+			name, contents string
+			msg            string
+		}{
+			{nil, "a", "a\n", "add a"},
+			{nil, "b", "b\n", "add b\n\nSigned-off-by: A U Thor <author@example.com>"},
+			{nil, "a", "a2\n", "change a\n\nwith a body"},
+			{[][]string{{"checkout", "-qb", "side", "HEAD~"}}, "s", "s\n", "add s"},
+			{
+				[][]string{
+					{"checkout", "-q", "master"},
+					{"merge", "-q", "--no-edit", "side"},
+					{"checkout", "-qb", "other", "master~3"},
+				},
+				"a", "other\n", "change a on other\n\nFixes: 1\nnot a trailer",
+			},
+		}
+		for _, step := range steps {
+			if err = util.GitExecMany(repo, step.setup...); err != nil {
+				return err
+			}
+			if err = util.TestFile(repo, step.name, step.contents); err != nil {
+				return err
+			}
+			if err = util.GitExecMany(repo,
+				[]string{"add", "--all"},
+				[]string{"commit", "-q", "-m", step.msg},
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+)
@@ -0,0 +1,73 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_rebase.go implements a repo test case, which contains a branch to
+rebase, with commits of all sorts.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: A BRANCH TO REBASE
+// ================================================================= //
+
+// Rebase has the branch topic, which forked from master at its
+// first commit, and has a commit that master has the changes of
+// already, fixups and squashes, an empty commit and a commit that
+// conflicts with master. It has topic checked out.
+var Rebase = NewRepoTestCase(
+	"__rebase",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		steps := []struct {
+			setup          []string
+			name, contents string
+			msg            []string
+		}{
+			{nil, "a", "a\n", []string{"add a"}},
+			{nil, "b", "b\n", []string{"add b"}},
+			{nil, "c", "c\n", []string{"add c"}},
+			{[]string{"checkout", "-qb", "topic", "master~2"}, "t", "t\n", []string{"add t", "with a body"}},
+			{nil, "c", "c\n", []string{"add c again"}},
+			{nil, "u", "u\n", []string{"add u"}},
+			{nil, "t", "t2\n", []string{"fixup! add t"}},
+			{nil, "u", "u2\n", []string{"squash! add u", "squash body"}},
+			{nil, "t", "t3\n", []string{"fixup! add"}},
+			{nil, "", "", []string{"empty"}},
+			{nil, "b", "bb\n", []string{"conflict in b"}},
+		}
+		for _, step := range steps {
+			if step.setup != nil {
+				if err = util.GitExecMany(repo, step.setup); err != nil {
+					return err
+				}
+			}
+			// steps without a file make empty commits
+			if step.name != "" {
+				if err = util.TestFile(repo, step.name, step.contents); err != nil {
+					return err
+				}
+			}
+			args := []string{"commit", "-q", "--allow-empty"}
+			for _, m := range step.msg {
+				args = append(args, "-m", m)
+			}
+			if err = util.GitExecMany(repo, []string{"add", "--all"}, args); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+)
@@ -0,0 +1,48 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_ref_updates.go implements a repo test case, which contains loose and
+packed refs, some of them in directories that other refs could conflict
+with.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+)
+
+// ================================================================= //
+// TEST CASE: REFS TO UPDATE
+// ================================================================= //
+
+// RefUpdates has the commits first and second on master, which is
+// packed, as are the annotated tag v1 and the branches a and p/c.
+// The branch topic/packed is packed and loose, and topic/loose and
+// b/c are loose.
+var RefUpdates = NewRepoTestCase(
+	"__ref_updates",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		return util.GitExecMany(repo,
+			[]string{"commit", "--allow-empty", "-m", "first"},
+			[]string{"commit", "--allow-empty", "-m", "second"},
+			[]string{"tag", "-a", "-m", "packed", "v1"},
+			[]string{"branch", "topic/packed"},
+			[]string{"branch", "a"},
+			[]string{"branch", "p/c"},
+			[]string{"pack-refs", "--all"},
+			[]string{"branch", "topic/loose"},
+			[]string{"branch", "-f", "topic/packed"},
+			[]string{"branch", "b/c"},
+		)
+	},
+)
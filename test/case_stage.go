@@ -0,0 +1,49 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+case_stage.go implements a repo test case, which contains a staged file
+and an executable file that is only intended to be added.
+*/
+package test
+
+import (
+	"github.com/jbrukh/ggit/util"
+	"os"
+	"path"
+)
+
+// ================================================================= //
+// TEST CASE: STAGED FILES
+// ================================================================= //
+
+// Stage has no commits; a/b is staged, and c, which is executable,
+// is staged with git-add -N.
+var Stage = NewRepoTestCase(
+	"__stage",
+	func(testCase *RepoTestCase) error {
+		repo, err := createRepo(testCase)
+		if err != nil {
+			return err
+		}
+		files := map[string]string{
+			"a/b": "contents\n",
+			"c":   "more\n",
+		}
+		if err = writeFiles(repo, files); err != nil {
+			return err
+		}
+		if err = os.Chmod(path.Join(repo, "c"), 0755); err != nil {
+			return err
+		}
+		return util.GitExecMany(repo,
+			[]string{"add", "a/b"},
+			[]string{"add", "-N", "c"},
+		)
+	},
+)
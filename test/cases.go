@@ -14,7 +14,9 @@ package test
 import (
 	"fmt"
 	"github.com/jbrukh/ggit/util"
+	"io"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -33,6 +35,24 @@ var repoTestCases = []*RepoTestCase{
 	Refs,
 	Tree,
 	TreeDiff,
+	Attributes,
+	Algorithms,
+	Config,
+	MergeFiles,
+	Patch,
+	Apply,
+	Merges,
+	MergeBases,
+	CacheTree,
+	RefUpdates,
+	Stage,
+	Checkout,
+	Conflict,
+	CrissCross,
+	Picks,
+	Rebase,
+	Mails,
+	Am,
 }
 
 // init initializes all the repo test cases, if they haven't been
@@ -95,6 +115,32 @@ func (tc *RepoTestCase) Build() error {
 	return tc.builder(tc)
 }
 
+// Clone copies the repo of the test case to a new one with the given
+// name, for tests that change it, and returns the path of the copy.
+// The index of the copy is refreshed, since the files that it stats
+// are new ones.
+func (tc *RepoTestCase) Clone(name string) (string, error) {
+	dir := util.TempRepo(name)
+	os.RemoveAll(dir)
+	err := filepath.Walk(tc.repo, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(tc.repo, pth)
+		if err != nil {
+			return err
+		}
+		return copyFile(pth, filepath.Join(dir, rel), info)
+	})
+	if err == nil {
+		_, err = util.GitExec(dir, "update-index", "-q", "--unmerged", "--refresh")
+	}
+	if err != nil {
+		return dir, fmt.Errorf("Could not clone case '%s': %s", tc.name, err.Error())
+	}
+	return dir, nil
+}
+
 func NewRepoTestCase(name string, builder RepoBuilder) *RepoTestCase {
 	return &RepoTestCase{
 		name:    name,
@@ -124,3 +170,46 @@ func createRepo(testCase *RepoTestCase) (repo string, err error) {
 	testCase.repo = repo
 	return
 }
+
+// writeFiles writes files with the given contents, by their paths,
+// to the working tree of a repo.
+func writeFiles(repo string, files map[string]string) error {
+	for name, contents := range files {
+		if err := util.TestFile(repo, name, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies a file, a directory without its contents or a
+// symlink, keeping its mode and its time of modification.
+func copyFile(src, dst string, info os.FileInfo) error {
+	switch {
+	case info.IsDir():
+		return os.MkdirAll(dst, info.Mode().Perm())
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,212 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+color.go implements git's color values, such as "bold red" or "#ff0000 ul",
+which are turned into ANSI escape sequences, and the settings that tell
+when output is colored.
+*/
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ColorReset is the escape sequence that ends a color.
+const ColorReset = "\x1b[m"
+
+// ================================================================= //
+// COLOR VALUES
+// ================================================================= //
+
+// the names of the ANSI colors, in the order of their codes
+var colorNames = []string{
+	"black", "red", "green", "yellow",
+	"blue", "magenta", "cyan", "white",
+}
+
+// the attributes of text, with their codes and the codes that
+// turn them off
+var colorAttrs = []struct {
+	name     string
+	val, neg int
+}{
+	{"bold", 1, 22},
+	{"dim", 2, 22},
+	{"italic", 3, 23},
+	{"ul", 4, 24},
+	{"blink", 5, 25},
+	{"reverse", 7, 27},
+	{"strike", 9, 29},
+}
+
+// ParseColor turns a color value into an escape sequence, as
+// git's color_parse does. The value is a list of words: at most
+// two colors, the foreground and then the background, and any
+// number of attributes, which may be negated with "no". Colors
+// are named, bright named, numbered from 0 to 255 or given as
+// #rrggbb, and "normal" leaves a color as it is. The word "reset"
+// resets all attributes first. An empty value gives an empty
+// sequence.
+func ParseColor(value string) (string, error) {
+	words := strings.Fields(value)
+	if len(words) == 1 && strings.EqualFold(words[0], "reset") {
+		return ColorReset, nil
+	}
+	var fg, bg []string
+	fgSet, bgSet, reset := false, false, false
+	attrs := uint(0)
+	for _, word := range words {
+		if strings.EqualFold(word, "reset") {
+			reset = true
+			continue
+		}
+		if c, ok := parseColorWord(word); ok {
+			switch {
+			case !fgSet:
+				fg, fgSet = c, true
+			case !bgSet:
+				bg, bgSet = c, true
+			default:
+				return "", fmt.Errorf("invalid color value: %s", value)
+			}
+			continue
+		}
+		code, ok := parseColorAttr(word)
+		if !ok {
+			return "", fmt.Errorf("invalid color value: %s", value)
+		}
+		attrs |= 1 << uint(code)
+	}
+	if !reset && attrs == 0 && fg == nil && bg == nil {
+		return "", nil
+	}
+
+	var codes []string
+	if reset {
+		// the reset is implied by an empty first code
+		codes = append(codes, "")
+	}
+	for code := 0; attrs != 0; code++ {
+		if attrs&(1<<uint(code)) != 0 {
+			attrs &^= 1 << uint(code)
+			codes = append(codes, strconv.Itoa(code))
+		}
+	}
+	if fg != nil {
+		codes = append(codes, fg[0])
+	}
+	if bg != nil {
+		codes = append(codes, bg[1])
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m", nil
+}
+
+// parseColorWord returns the codes of a color as a foreground
+// and as a background, which are nil for "normal".
+func parseColorWord(word string) ([]string, bool) {
+	ansi := func(fg int) []string {
+		return []string{strconv.Itoa(fg), strconv.Itoa(fg + 10)}
+	}
+	if strings.EqualFold(word, "normal") {
+		return nil, true
+	}
+	if strings.EqualFold(word, "default") {
+		return ansi(39), true
+	}
+	if len(word) == 7 && word[0] == '#' {
+		if rgb, err := strconv.ParseUint(word[1:], 16, 32); err == nil {
+			c := fmt.Sprintf("2;%d;%d;%d", rgb>>16, (rgb>>8)&0xff, rgb&0xff)
+			return []string{"38;" + c, "48;" + c}, true
+		}
+	}
+	for i, name := range colorNames {
+		if strings.EqualFold(word, name) {
+			return ansi(30 + i), true
+		}
+		if strings.EqualFold(word, "bright"+name) {
+			return ansi(90 + i), true
+		}
+	}
+	if n, err := strconv.Atoi(word); err == nil {
+		switch {
+		case n == -1:
+			return nil, true
+		case 0 <= n && n < 8:
+			return ansi(30 + n), true
+		case 8 <= n && n < 16:
+			return ansi(90 + n - 8), true
+		case 16 <= n && n < 256:
+			return []string{fmt.Sprintf("38;5;%d", n), fmt.Sprintf("48;5;%d", n)}, true
+		}
+	}
+	return nil, false
+}
+
+// parseColorAttr returns the code of an attribute, or of its
+// negation, as in "nobold" or "no-bold".
+func parseColorAttr(word string) (int, bool) {
+	neg := false
+	if strings.HasPrefix(word, "no") {
+		neg, word = true, strings.TrimPrefix(word[2:], "-")
+	}
+	for _, attr := range colorAttrs {
+		if word == attr.name {
+			if neg {
+				return attr.neg, true
+			}
+			return attr.val, true
+		}
+	}
+	return 0, false
+}
+
+// ================================================================= //
+// COLOR SETTINGS
+// ================================================================= //
+
+// ColorWhen tells when output is colored.
+type ColorWhen int
+
+const (
+	ColorNever  ColorWhen = iota // never color
+	ColorAlways                  // always color
+	ColorAuto                    // color only on terminals
+)
+
+// ParseColorWhen parses a setting such as color.ui or the value
+// of --color, which is "never", "always", "auto" or a boolean.
+// As in git, true means auto.
+func ParseColorWhen(value string) (ColorWhen, error) {
+	switch strings.ToLower(value) {
+	case "never", "false", "no", "off", "0":
+		return ColorNever, nil
+	case "always":
+		return ColorAlways, nil
+	case "auto", "true", "yes", "on", "1":
+		return ColorAuto, nil
+	}
+	return ColorNever, fmt.Errorf("bad color setting: %s", value)
+}
+
+// Enabled returns true if output to out is colored. Auto colors
+// output to terminals, unless $TERM says the terminal is dumb.
+func (w ColorWhen) Enabled(out io.Writer) bool {
+	switch w {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		f, ok := out.(*os.File)
+		return ok && IsTerminal(f) && os.Getenv("TERM") != "dumb"
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"testing"
+)
+
+// the escape sequences that git config --get-color gives
+var testCasesColor = []struct {
+	value, color string
+}{
+	{"", ""},
+	{"red", "\x1b[31m"},
+	{"bold red", "\x1b[1;31m"},
+	{"normal", ""},
+	{"reset", "\x1b[m"},
+	{"reset red", "\x1b[;31m"},
+	{"nobold no-ul", "\x1b[22;24m"},
+	{"#ff0080 blue", "\x1b[38;2;255;0;128;44m"},
+	{"brightred 200", "\x1b[91;48;5;200m"},
+	{"7 12", "\x1b[37;104m"},
+	{"-1 red", "\x1b[41m"},
+	{"default default", "\x1b[39;49m"},
+	{"ul italic bold red", "\x1b[1;3;4;31m"},
+}
+
+func Test_ParseColor(t *testing.T) {
+	for _, c := range testCasesColor {
+		color, err := ParseColor(c.value)
+		AssertNoErr(t, err)
+		AssertEqualString(t, c.color, color)
+	}
+	for _, value := range []string{"red green blue", "bold-ish", "256"} {
+		_, err := ParseColor(value)
+		Assert(t, err != nil, "expected an error for "+value)
+	}
+}
@@ -106,11 +106,21 @@ func NewDataParser(rd *bufio.Reader) *DataParser {
 // error occurs, the parser commands will panic with ParseErr, which
 // this method will recover and return
 func SafeParse(f func()) (err error) {
+	return safeParse(f, ParserDebug)
+}
+
+// QuietParse is like SafeParse, but never prints the debugging
+// output, for parses that are expected to fail now and then.
+func QuietParse(f func()) (err error) {
+	return safeParse(f, false)
+}
+
+func safeParse(f func(), debug bool) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if e, ok := r.(*ParseErr); ok {
 				err = e
-				if ParserDebug {
+				if debug {
 					fmt.Fprintln(os.Stderr, "------------ ParserDebug Output ------------")
 					fmt.Fprint(os.Stderr, e.Stack())
 				}
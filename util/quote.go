@@ -0,0 +1,126 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+quote.go implements the quoting of paths for display, and its undoing.
+*/
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// QuotePath quotes a path in the style of git's quote_c_style,
+// which is used whenever a path contains control characters,
+// quotes, backslashes or non-ASCII bytes. Other paths are
+// returned as they are.
+func QuotePath(name string) string {
+	needsQuote := false
+	for i := 0; i < len(name); i++ {
+		if mustQuote(name[i]) {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return name
+	}
+	buf := bytes.NewBufferString(`"`)
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch c {
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\v':
+			buf.WriteString(`\v`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		default:
+			if mustQuote(c) {
+				fmt.Fprintf(buf, `\%03o`, c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteString(`"`)
+	return buf.String()
+}
+
+func mustQuote(c byte) bool {
+	return c < 0x20 || c == '"' || c == '\\' || c >= 0x7f
+}
+
+// UnquotePath undoes QuotePath, as git's unquote_c_style does. A
+// path that isn't quoted is returned as it is. It returns the
+// path and the rest of the string that follows its closing
+// quote, and an error if the quoting is broken.
+func UnquotePath(s string) (name, rest string, err error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, "", nil
+	}
+	buf := new(bytes.Buffer)
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return buf.String(), s[i+1:], nil
+		case c != '\\':
+			buf.WriteByte(c)
+			continue
+		}
+		if i++; i == len(s) {
+			break
+		}
+		switch c = s[i]; c {
+		case 'a':
+			buf.WriteByte('\a')
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'v':
+			buf.WriteByte('\v')
+		case '\\', '"':
+			buf.WriteByte(c)
+		case '0', '1', '2', '3':
+			if i+2 >= len(s) || !isOctal(s[i+1]) || !isOctal(s[i+2]) {
+				return "", "", fmt.Errorf("bad quoting: %s", s)
+			}
+			buf.WriteByte((c-'0')<<6 | (s[i+1]-'0')<<3 | (s[i+2] - '0'))
+			i += 2
+		default:
+			return "", "", fmt.Errorf("bad quoting: %s", s)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoting: %s", s)
+}
+
+func isOctal(c byte) bool {
+	return '0' <= c && c <= '7'
+}
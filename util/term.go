@@ -0,0 +1,40 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+term.go implements the discovery of the width of the terminal.
+*/
+package util
+
+import (
+	"os"
+	"strconv"
+)
+
+// DefaultColumns is the width that is assumed when the width
+// of the terminal can't be found.
+const DefaultColumns = 80
+
+// TermColumns returns the width of the terminal, as git's
+// term_columns does: the value of $COLUMNS if it is set, or
+// else the width of the terminal on standard output, or else
+// DefaultColumns.
+func TermColumns() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if n := termWidth(os.Stdout); n > 0 {
+		return n
+	}
+	return DefaultColumns
+}
+
+// IsTerminal returns true if f is a terminal.
+func IsTerminal(f *os.File) bool {
+	return termWidth(f) > 0
+}
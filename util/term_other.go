@@ -0,0 +1,19 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package util
+
+import "os"
+
+// termWidth is not known on this platform.
+func termWidth(f *os.File) int {
+	return 0
+}
@@ -34,3 +34,19 @@ func Test_IsDigit(t *testing.T) {
 	Assert(t, IsDigit('9'))
 	Assert(t, !IsDigit('z'))
 }
+
+func Test_UnquotePath(t *testing.T) {
+	for _, name := range []string{"plain", "with space", "tab\there", "quo\"te", "caf\xc3\xa9", "back\\slash"} {
+		quoted := QuotePath(name)
+		unquoted, rest, err := UnquotePath(quoted + " b/x")
+		AssertNoErr(t, err)
+		if quoted != name {
+			AssertEqualString(t, name, unquoted)
+			AssertEqualString(t, " b/x", rest)
+		}
+	}
+	_, _, err := UnquotePath(`"open`)
+	Assert(t, err != nil, "expected an error for an unterminated path")
+	_, _, err = UnquotePath(`"bad\q"`)
+	Assert(t, err != nil, "expected an error for a bad escape")
+}
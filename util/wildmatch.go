@@ -0,0 +1,306 @@
+//
+// Unless otherwise noted, this project is licensed under the Creative
+// Commons Attribution-NonCommercial-NoDerivs 3.0 Unported License. Please
+// see the README file.
+//
+// Copyright (c) 2012 The ggit Authors
+//
+
+/*
+wildmatch.go implements git's wildmatch, the shell-style pattern matcher
+used for ignore rules and pathspecs. It understands '*', '?', bracket
+expressions with ranges and character classes, backslash escapes and,
+when matching paths, the special meaning of '**'.
+*/
+package util
+
+// ================================================================= //
+// CONSTANTS
+// ================================================================= //
+
+// flags that alter the behavior of Wildmatch
+const (
+	// WildPathname causes wildcards other than '**' not
+	// to match the '/' character.
+	WildPathname = 1 << iota
+
+	// WildCasefold causes the match to ignore case.
+	WildCasefold
+)
+
+// internal results of matching, as in git's wildmatch.c
+const (
+	wmAbortToStarStar = -2
+	wmAbortAll        = -1
+	wmMatch           = 0
+	wmNoMatch         = 1
+)
+
+// ================================================================= //
+// WILDMATCH
+// ================================================================= //
+
+// Wildmatch returns true if and only if the text is matched by
+// the pattern, according to the given flags.
+func Wildmatch(pattern, text string, flags int) bool {
+	return dowild([]byte(pattern), []byte(text), flags) == wmMatch
+}
+
+// at returns the byte at the given index, or NUL if
+// the index is past the end of the slice, which lets
+// us follow the C implementation closely.
+func at(b []byte, i int) byte {
+	if i < len(b) {
+		return b[i]
+	}
+	return 0
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func toUpper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+func dowild(p, text []byte, flags int) int {
+	var pi, ti int
+	for ; pi < len(p); pi, ti = pi+1, ti+1 {
+		pc, tc := p[pi], at(text, ti)
+		if tc == 0 && pc != '*' {
+			return wmAbortAll
+		}
+		if flags&WildCasefold != 0 {
+			tc, pc = toLower(tc), toLower(pc)
+		}
+		switch pc {
+		case '\\':
+			// literal match with the following character
+			pi++
+			pc = at(p, pi)
+			if flags&WildCasefold != 0 {
+				pc = toLower(pc)
+			}
+			if pc == 0 || tc != pc {
+				return wmNoMatch
+			}
+		case '?':
+			if flags&WildPathname != 0 && tc == '/' {
+				return wmNoMatch
+			}
+		case '*':
+			var matchSlash bool
+			pi++
+			if at(p, pi) == '*' {
+				prev := pi - 2
+				for pi++; at(p, pi) == '*'; pi++ {
+				}
+				if flags&WildPathname == 0 {
+					// without WildPathname, '*' == '**'
+					matchSlash = true
+				} else if (prev < 0 || p[prev] == '/') &&
+					(pi == len(p) || p[pi] == '/' || (p[pi] == '\\' && at(p, pi+1) == '/')) {
+					// "**/" may match zero directories
+					if at(p, pi) == '/' && dowild(p[pi+1:], text[ti:], flags) == wmMatch {
+						return wmMatch
+					}
+					matchSlash = true
+				}
+			} else {
+				matchSlash = flags&WildPathname == 0
+			}
+			if pi == len(p) {
+				// trailing "**" matches everything, while a
+				// trailing "*" matches only if there are no
+				// more slash characters
+				if !matchSlash {
+					for _, c := range text[ti:] {
+						if c == '/' {
+							return wmNoMatch
+						}
+					}
+				}
+				return wmMatch
+			} else if !matchSlash && p[pi] == '/' {
+				// a single asterisk followed by a slash matches
+				// up to the next directory
+				slash := -1
+				for i := ti; i < len(text); i++ {
+					if text[i] == '/' {
+						slash = i
+						break
+					}
+				}
+				if slash < 0 {
+					return wmNoMatch
+				}
+				// the slash is consumed by the loop
+				ti = slash
+				continue
+			}
+			for ; ti < len(text); ti++ {
+				tc = text[ti]
+				if matched := dowild(p[pi:], text[ti:], flags); matched != wmNoMatch {
+					if !matchSlash || matched != wmAbortToStarStar {
+						return matched
+					}
+				} else if !matchSlash && tc == '/' {
+					return wmAbortToStarStar
+				}
+			}
+			return wmAbortAll
+		case '[':
+			var ok bool
+			if pi, ok = matchBracket(p, pi, tc, flags); !ok {
+				return wmAbortAll
+			} else if pi < 0 {
+				return wmNoMatch
+			}
+		default:
+			if tc != pc {
+				return wmNoMatch
+			}
+		}
+	}
+	if ti < len(text) {
+		return wmNoMatch
+	}
+	return wmMatch
+}
+
+// matchBracket matches the character tc against the bracket
+// expression that starts at p[pi]. It returns the index of
+// the closing bracket if the character matched, and -1 if it
+// did not. If the bracket expression is malformed, ok is false.
+func matchBracket(p []byte, pi int, tc byte, flags int) (end int, ok bool) {
+	var matched, negated bool
+	pi++
+	pc := at(p, pi)
+	if pc == '^' {
+		pc = '!'
+	}
+	if pc == '!' {
+		negated = true
+		pi++
+		pc = at(p, pi)
+	}
+	var prev byte
+	for {
+		if pc == 0 {
+			return 0, false
+		}
+		switch {
+		case pc == '\\':
+			pi++
+			pc = at(p, pi)
+			if pc == 0 {
+				return 0, false
+			}
+			if tc == pc {
+				matched = true
+			}
+		case pc == '-' && prev != 0 && at(p, pi+1) != 0 && at(p, pi+1) != ']':
+			pi++
+			pc = at(p, pi)
+			if pc == '\\' {
+				pi++
+				pc = at(p, pi)
+				if pc == 0 {
+					return 0, false
+				}
+			}
+			if tc <= pc && tc >= prev {
+				matched = true
+			} else if flags&WildCasefold != 0 {
+				if up := toUpper(tc); up <= pc && up >= prev {
+					matched = true
+				}
+			}
+			pc = 0 // resets prev
+		case pc == '[' && at(p, pi+1) == ':':
+			start := pi + 2
+			pi = start
+			for pc = at(p, pi); pc != 0 && pc != ']'; pc = at(p, pi) {
+				pi++
+			}
+			if pc == 0 {
+				return 0, false
+			}
+			if pi-start-1 < 0 || p[pi-1] != ':' {
+				// didn't find ":]", so treat like a normal set
+				pi = start - 2
+				pc = '['
+				if tc == pc {
+					matched = true
+				}
+				break
+			}
+			class, known := charClass(string(p[start:pi-1]), tc, flags)
+			if !known {
+				return 0, false
+			}
+			if class {
+				matched = true
+			}
+			pc = 0 // resets prev
+		default:
+			if tc == pc {
+				matched = true
+			}
+		}
+		prev = pc
+		pi++
+		if pc = at(p, pi); pc == ']' {
+			break
+		}
+	}
+	if matched == negated || (flags&WildPathname != 0 && tc == '/') {
+		return -1, true
+	}
+	return pi, true
+}
+
+// charClass returns whether c belongs to the named POSIX
+// character class, and whether the class is known at all.
+func charClass(name string, c byte, flags int) (member bool, known bool) {
+	isLower := c >= 'a' && c <= 'z'
+	isUpper := c >= 'A' && c <= 'Z'
+	isDigit := c >= '0' && c <= '9'
+	isAlpha := isLower || isUpper
+	isPrint := c >= 0x20 && c < 0x7f
+	isSpace := c == ' ' || (c >= '\t' && c <= '\r')
+	switch name {
+	case "alnum":
+		return isAlpha || isDigit, true
+	case "alpha":
+		return isAlpha, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < 0x20 || c == 0x7f, true
+	case "digit":
+		return isDigit, true
+	case "graph":
+		return isPrint && c != ' ', true
+	case "lower":
+		return isLower || (flags&WildCasefold != 0 && isUpper), true
+	case "print":
+		return isPrint, true
+	case "punct":
+		return isPrint && c != ' ' && !isAlpha && !isDigit, true
+	case "space":
+		return isSpace, true
+	case "upper":
+		return isUpper || (flags&WildCasefold != 0 && isLower), true
+	case "xdigit":
+		return isDigit || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F'), true
+	}
+	return false, false
+}
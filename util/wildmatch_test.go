@@ -0,0 +1,86 @@
+package util
+
+import (
+	"testing"
+)
+
+// cases adapted from git's t3070-wildmatch.sh, with the
+// expected result with and without WildPathname
+var testCasesWildmatch = []struct {
+	text, pattern string
+	glob, path    bool
+}{
+	{"foo", "foo", true, true},
+	{"foo", "bar", false, false},
+	{"", "", true, true},
+	{"foo", "???", true, true},
+	{"foo", "??", false, false},
+	{"foo", "*", true, true},
+	{"foo", "f*", true, true},
+	{"foo", "*f", false, false},
+	{"foo", "*foo*", true, true},
+	{"foobar", "*ob*a*r*", true, true},
+	{"aaaaaaabababab", "*ab", true, true},
+	{"foo*", `foo\*`, true, true},
+	{"foobar", `foo\*bar`, false, false},
+	{`f\oo`, `f\\oo`, true, true},
+	{"ball", "*[al]?", true, true},
+	{"ten", "[ten]", false, false},
+	{"ten", "**[!te]", true, true},
+	{"ten", "**[!ten]", false, false},
+	{"ten", "t[a-g]n", true, true},
+	{"ten", "t[!a-g]n", false, false},
+	{"ton", "t[!a-g]n", true, true},
+	{"ton", "t[^a-g]n", true, true},
+	{"a]b", "a[]]b", true, true},
+	{"a-b", "a[]-]b", true, true},
+	{"a]b", "a[]-]b", true, true},
+	{"aab", "a[]-]b", false, false},
+	{"aab", "a[]a-]b", true, true},
+	{"]", "]", true, true},
+	{"foo/baz/bar", "foo*bar", true, false},
+	{"foo/baz/bar", "foo**bar", true, false},
+	{"foo/baz/bar", "foo/*/bar", true, true},
+	{"foo/baz/bar", "foo/**/bar", true, true},
+	{"foo/b/a/z/bar", "foo/**/bar", true, true},
+	{"foo/bar", "foo/**/bar", false, true},
+	{"foo/bar", "foo?bar", true, false},
+	{"foo/bar", "foo[/]bar", true, false},
+	{"foo/bar", "foo[^a-z]bar", true, false},
+	{"foo/bb/aa/rr", "*/*/*", true, false},
+	{"abc/def", "**/**/**", false, true},
+	{"foo/bar/baz", "**/bar*", true, false},
+	{"deep/foo/bar/baz/", "**/bar/*/", true, true},
+	{"foo/bar/baz", "**/bar/**", true, true},
+	{"foo/bar/baz/x", "*/bar/**", true, true},
+	{"deep/foo/bar", "**/foo", false, false},
+	{"deep/foo", "**/foo", true, true},
+	{"foo", "**/foo", false, true},
+	{"XXX/foo", "*/foo", true, true},
+	{"bar/baz/foo", "*/foo", true, false},
+	{"a1B", "[[:alpha:]][[:digit:]][[:upper:]]", true, true},
+	{"a", "[[:digit:][:upper:][:space:]]", false, false},
+	{"5", "[[:xdigit:]]", true, true},
+	{"f", "[[:xdigit:]]", true, true},
+	{"-", "[[:punct:]]", true, true},
+	{"a", "[[:alpha:]", false, false},
+	{"-adobe-courier-bold-o-normal--12-120-75-75-m-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*", true, true},
+	{"-adobe-courier-bold-o-normal--12-120-75-75-X-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*", false, false},
+	{"XXX/adobe/courier/bold/o/normal//12/120/75/75/m/70/iso8859/1", "XXX/*/*/*/*/*/*/12/*/*/*/m/*/*/*", true, true},
+	{"abcd/abcdefg/abcdefghijk/abcdefghijklmnop.txt", "**/*a*b*g*n*t", true, true},
+	{"abcd/abcdefg/abcdefghijk/abcdefghijklmnop.txtz", "**/*a*b*g*n*t", false, false},
+}
+
+func Test_Wildmatch(t *testing.T) {
+	for _, c := range testCasesWildmatch {
+		Assertf(t, Wildmatch(c.pattern, c.text, 0) == c.glob, "glob: '%s' ~ '%s' should be %v", c.pattern, c.text, c.glob)
+		Assertf(t, Wildmatch(c.pattern, c.text, WildPathname) == c.path, "path: '%s' ~ '%s' should be %v", c.pattern, c.text, c.path)
+	}
+}
+
+func Test_WildmatchCasefold(t *testing.T) {
+	Assert(t, !Wildmatch("[A-Z]", "a", 0))
+	Assert(t, Wildmatch("[A-Z]", "a", WildCasefold))
+	Assert(t, Wildmatch("*.TXT", "readme.txt", WildCasefold))
+	Assert(t, Wildmatch("[[:upper:]]", "a", WildCasefold))
+}